@@ -0,0 +1,135 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+const (
+	// ActiveSpeakerInterval is how often the dominant speaker is re-evaluated,
+	// which also bounds the rate of active-speaker broadcasts
+	ActiveSpeakerInterval = 500 * time.Millisecond
+	// Minimum time a speaker keeps the floor before another may take it
+	activeSpeakerHoldTime = 1500 * time.Millisecond
+	// Levels older than this are treated as silence
+	activeSpeakerStaleAfter = 1 * time.Second
+	// Audio levels are -dBov (0 loudest, 127 silent); quieter than this is not speech
+	activeSpeakerMaxLevel = 70
+	// A challenger must be this much louder (in dB) than the current speaker
+	activeSpeakerHysteresis = 6
+	// Weight of the newest sample in the smoothed loudness
+	activeSpeakerSmoothing = 0.3
+)
+
+// readAudioLevel extracts the RFC 6464 audio level from an RTP packet
+func readAudioLevel(packet *rtp.Packet, extensionID uint8) (uint8, bool) {
+	payload := packet.GetExtension(extensionID)
+	if payload == nil {
+		return 0, false
+	}
+	var ext rtp.AudioLevelExtension
+	if err := ext.Unmarshal(payload); err != nil {
+		return 0, false
+	}
+	return ext.Level, true
+}
+
+// speakerLevel is a smoothed loudness (127 - level, so higher is louder)
+type speakerLevel struct {
+	loudness  float64
+	updatedAt time.Time
+}
+
+// activeSpeakerDetector picks the dominant speaker of a room from the audio
+// levels of forwarded packets
+type activeSpeakerDetector struct {
+	mu         sync.Mutex
+	levels     map[*Client]*speakerLevel
+	current    *Client
+	lastSwitch time.Time
+}
+
+func newActiveSpeakerDetector() *activeSpeakerDetector {
+	return &activeSpeakerDetector{levels: make(map[*Client]*speakerLevel)}
+}
+
+// observe records the audio level of one packet from the client
+func (d *activeSpeakerDetector) observe(c *Client, level uint8) {
+	loudness := float64(127 - min(level, 127))
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entry, ok := d.levels[c]
+	if !ok {
+		d.levels[c] = &speakerLevel{loudness: loudness, updatedAt: time.Now()}
+		return
+	}
+	entry.loudness = (1-activeSpeakerSmoothing)*entry.loudness + activeSpeakerSmoothing*loudness
+	entry.updatedAt = time.Now()
+}
+
+// remove forgets a client that left the room
+func (d *activeSpeakerDetector) remove(c *Client) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.levels, c)
+	if d.current == c {
+		d.current = nil
+	}
+}
+
+// speaking returns every client whose recent level is loud enough to be speech
+func (d *activeSpeakerDetector) speaking(now time.Time) []*Client {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var clients []*Client
+	for c, entry := range d.levels {
+		if now.Sub(entry.updatedAt) <= activeSpeakerStaleAfter && entry.loudness >= float64(127-activeSpeakerMaxLevel) {
+			clients = append(clients, c)
+		}
+	}
+	return clients
+}
+
+// evaluate returns the dominant speaker and their level (-dBov) when it changed
+// since the last call
+func (d *activeSpeakerDetector) evaluate(now time.Time) (*Client, uint8, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.current != nil && now.Sub(d.lastSwitch) < activeSpeakerHoldTime {
+		return nil, 0, false
+	}
+
+	loudnessOf := func(c *Client) float64 {
+		entry, ok := d.levels[c]
+		if !ok || now.Sub(entry.updatedAt) > activeSpeakerStaleAfter {
+			return 0
+		}
+		return entry.loudness
+	}
+
+	var loudest *Client
+	best := float64(127 - activeSpeakerMaxLevel)
+	for c := range d.levels {
+		if loudness := loudnessOf(c); loudness >= best {
+			loudest, best = c, loudness
+		}
+	}
+
+	if loudest == nil || loudest == d.current {
+		return nil, 0, false
+	}
+	if d.current != nil && best < loudnessOf(d.current)+activeSpeakerHysteresis {
+		return nil, 0, false
+	}
+
+	d.current = loudest
+	d.lastSwitch = now
+	return loudest, uint8(127 - best), true
+}
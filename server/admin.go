@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"video-meeting-app/db"
+)
+
+const HubStatsTimeout = 2 * time.Second
+
+// HubStats is a point-in-time view of the hub's connection bookkeeping
+type HubStats struct {
+	Clients          int `json:"clients"`
+	Meetings         int `json:"meetings"`
+	LargestMeeting   int `json:"largestMeeting"`
+	QueuedOutbound   int `json:"queuedOutbound"`
+	QueuedClientSend int `json:"queuedClientSend"`
+	Shards           int `json:"shards"`
+
+	SendQueue SendQueueStats `json:"sendQueue"`
+	Replay    ReplayStats    `json:"replay"`
+}
+
+// snapshot collects one shard's statistics; must run on the shard's goroutine
+func (h *hubShard) snapshot() HubStats {
+	stats := HubStats{
+		Clients:        len(h.clients),
+		Meetings:       len(h.meetings),
+		QueuedOutbound: len(h.outbound),
+	}
+	for _, clients := range h.meetings {
+		stats.LargestMeeting = max(stats.LargestMeeting, len(clients))
+	}
+	for client := range h.clients {
+		queued, bytes := client.send.size()
+		stats.QueuedClientSend += queued
+		stats.SendQueue.QueuedBytes += bytes
+		stats.SendQueue.LargestQueueBytes = max(stats.SendQueue.LargestQueueBytes, bytes)
+		if queued >= SendQueueSize || bytes >= client.send.maxBytes {
+			stats.SendQueue.FullQueues++
+		}
+	}
+	stats.Replay.Buffers = len(h.replay)
+	stats.Replay.Bytes = h.replayBytes
+	for _, buffer := range h.replay {
+		stats.Replay.Entries += len(buffer.entries)
+	}
+	return stats
+}
+
+// Stats asks every shard for a snapshot and adds them up. Safe to call from
+// any goroutine.
+func (h *Hub) Stats() (HubStats, error) {
+	total := HubStats{
+		Shards:    len(h.shards),
+		SendQueue: getSendQueueStats(),
+		Replay: ReplayStats{
+			Evicted: replayBuffersEvicted.Load(),
+			Trimmed: replayEntriesTrimmed.Load(),
+		},
+	}
+	deadline := time.After(HubStatsTimeout)
+	for i, shard := range h.shards {
+		reply := make(chan HubStats, 1)
+		select {
+		case shard.stats <- reply:
+		case <-deadline:
+			return HubStats{}, fmt.Errorf("hub shard %d did not respond within %v", i, HubStatsTimeout)
+		}
+		stats := <-reply
+		total.Clients += stats.Clients
+		total.Meetings += stats.Meetings
+		total.LargestMeeting = max(total.LargestMeeting, stats.LargestMeeting)
+		total.QueuedOutbound += stats.QueuedOutbound
+		total.QueuedClientSend += stats.QueuedClientSend
+		total.SendQueue.FullQueues += stats.SendQueue.FullQueues
+		total.SendQueue.QueuedBytes += stats.SendQueue.QueuedBytes
+		total.SendQueue.LargestQueueBytes = max(total.SendQueue.LargestQueueBytes, stats.SendQueue.LargestQueueBytes)
+		total.Replay.Buffers += stats.Replay.Buffers
+		total.Replay.Entries += stats.Replay.Entries
+		total.Replay.Bytes += stats.Replay.Bytes
+	}
+	return total, nil
+}
+
+// RoomCount returns the number of meetings with an SFU room
+func (s *SFU) RoomCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.rooms)
+}
+
+// Admin roles. Users with the legacy isAdmin flag and no adminRole are admins.
+const (
+	AdminRoleAdmin   = "admin"
+	AdminRoleSupport = "support"
+)
+
+// AdminPermission is an action in the admin API
+type AdminPermission string
+
+const (
+	AdminPermissionViewUsers      AdminPermission = "view-users"
+	AdminPermissionManageUsers    AdminPermission = "manage-users"
+	AdminPermissionViewMeetings   AdminPermission = "view-meetings"
+	AdminPermissionManageMeetings AdminPermission = "manage-meetings"
+	AdminPermissionManageSecrets  AdminPermission = "manage-secrets"
+	AdminPermissionManageOrigins  AdminPermission = "manage-origins"
+	AdminPermissionDebug          AdminPermission = "debug"
+)
+
+var adminRolePermissions = map[string]map[AdminPermission]bool{
+	AdminRoleAdmin: {
+		AdminPermissionViewUsers:      true,
+		AdminPermissionManageUsers:    true,
+		AdminPermissionViewMeetings:   true,
+		AdminPermissionManageMeetings: true,
+		AdminPermissionManageSecrets:  true,
+		AdminPermissionManageOrigins:  true,
+		AdminPermissionDebug:          true,
+	},
+	AdminRoleSupport: {
+		AdminPermissionViewUsers:    true,
+		AdminPermissionViewMeetings: true,
+	},
+}
+
+// adminRoleOf returns the user's admin role, or "" for regular users
+func adminRoleOf(user User) string {
+	if _, ok := adminRolePermissions[user.AdminRole]; ok {
+		return user.AdminRole
+	}
+	if user.IsAdmin {
+		return AdminRoleAdmin
+	}
+	return ""
+}
+
+type adminContextKey struct{}
+
+// adminFromContext returns the admin authenticated by requireAdmin
+func adminFromContext(ctx context.Context) User {
+	user, _ := ctx.Value(adminContextKey{}).(User)
+	return user
+}
+
+// requireAdmin rejects requests from users without an admin role and makes
+// the admin available to handlers through adminFromContext
+func requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := getUserIDFromToken(r)
+		if userID == "" {
+			sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var user User
+		if err := db.Users.FindOne(r.Context(), bson.M{"_id": userID}).Decode(&user); err != nil || adminRoleOf(user) == "" {
+			sendErrorResponse(w, "Admin access required", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminContextKey{}, user)))
+	})
+}
+
+// requireAdminPermission rejects admins whose role lacks the permission; it
+// must run after requireAdmin
+func requireAdminPermission(permission AdminPermission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !adminRolePermissions[adminRoleOf(adminFromContext(r.Context()))][permission] {
+				sendErrorResponse(w, fmt.Sprintf("Your admin role does not allow %s", permission), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// withAdminPermission wraps a single admin handler in requireAdminPermission
+func withAdminPermission(permission AdminPermission, handler http.HandlerFunc) http.Handler {
+	return requireAdminPermission(permission)(handler)
+}
+
+// adminDebugHandler reports runtime, GC and hub statistics for diagnosing leaks
+func adminDebugHandler(w http.ResponseWriter, r *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	var gc debug.GCStats
+	debug.ReadGCStats(&gc)
+
+	hubStats, err := hub.Stats()
+	if err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	sfuRooms := 0
+	if sfu != nil {
+		sfuRooms = sfu.RoomCount()
+	}
+
+	sendSuccessResponse(w, map[string]interface{}{
+		"goroutines": runtime.NumGoroutine(),
+		"hub":        hubStats,
+		"sfuRooms":   sfuRooms,
+		"memory": map[string]interface{}{
+			"heapAlloc":    mem.HeapAlloc,
+			"heapInuse":    mem.HeapInuse,
+			"heapObjects":  mem.HeapObjects,
+			"stackInuse":   mem.StackInuse,
+			"sys":          mem.Sys,
+			"totalAlloc":   mem.TotalAlloc,
+			"mallocs":      mem.Mallocs,
+			"frees":        mem.Frees,
+			"nextGCTarget": mem.NextGC,
+		},
+		"gc": map[string]interface{}{
+			"numGC":       gc.NumGC,
+			"lastGC":      gc.LastGC,
+			"pauseTotal":  gc.PauseTotal.String(),
+			"cpuFraction": mem.GCCPUFraction,
+		},
+		"cleanup":   getCleanupStats(),
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// registerPprofRoutes mounts the net/http/pprof handlers under the admin router.
+// pprof.Index only resolves named profiles under /debug/pprof/, so they are
+// routed explicitly. CPU profiles and traces must fit in the server's
+// WriteTimeout, e.g. /debug/pprof/profile?seconds=10.
+func registerPprofRoutes(admin *mux.Router) {
+	profiles := admin.PathPrefix("/debug/pprof").Subrouter()
+	profiles.Use(requireAdminPermission(AdminPermissionDebug))
+	profiles.HandleFunc("/", pprof.Index).Methods("GET")
+	profiles.HandleFunc("/cmdline", pprof.Cmdline).Methods("GET")
+	profiles.HandleFunc("/profile", pprof.Profile).Methods("GET")
+	profiles.HandleFunc("/symbol", pprof.Symbol).Methods("GET", "POST")
+	profiles.HandleFunc("/trace", pprof.Trace).Methods("GET")
+	profiles.HandleFunc("/{profile}", func(w http.ResponseWriter, r *http.Request) {
+		pprof.Handler(mux.Vars(r)["profile"]).ServeHTTP(w, r)
+	}).Methods("GET")
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+)
+
+const (
+	DefaultAdminMeetingsPageSize = 50
+	MaxAdminMeetingsPageSize     = 200
+)
+
+// AdminMeeting is a meeting as listed in the admin API
+type AdminMeeting struct {
+	Meeting
+	ParticipantCount int `json:"participantCount"`
+}
+
+// adminListMeetingsHandler lists meetings, newest first. Active meetings are
+// listed unless ?active=false.
+func adminListMeetingsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := bson.M{"isActive": query.Get("active") != "false"}
+
+	limit := DefaultAdminMeetingsPageSize
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			sendErrorResponse(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(parsed, MaxAdminMeetingsPageSize)
+	}
+	offset := 0
+	if value := query.Get("offset"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			sendErrorResponse(w, "Invalid offset", http.StatusBadRequest)
+			return
+		}
+		offset = parsed
+	}
+
+	total, err := db.Meetings.CountDocuments(r.Context(), filter)
+	if err != nil {
+		sendErrorResponse(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	cursor, err := db.Meetings.Find(r.Context(), filter, options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: 1}}).
+		SetSkip(int64(offset)).
+		SetLimit(int64(limit)))
+	if err != nil {
+		sendErrorResponse(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	var found []Meeting
+	if err := cursor.All(r.Context(), &found); err != nil {
+		sendErrorResponse(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	ids := make([]string, len(found))
+	for i, meeting := range found {
+		ids[i] = meeting.ID
+	}
+	counts, err := countConnectedParticipants(r, ids)
+	if err != nil {
+		sendErrorResponse(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	meetings := make([]AdminMeeting, len(found))
+	for i, meeting := range found {
+		meetings[i] = AdminMeeting{Meeting: meeting, ParticipantCount: counts[meeting.ID]}
+	}
+	sendSuccessResponse(w, map[string]interface{}{
+		"meetings": meetings,
+		"total":    total,
+	})
+}
+
+// countConnectedParticipants counts the participants who haven't left each meeting
+func countConnectedParticipants(r *http.Request, meetingIDs []string) (map[string]int, error) {
+	cursor, err := db.Participants.Aggregate(r.Context(), mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"meetingId": bson.M{"$in": meetingIDs}, "leftAt": bson.M{"$exists": false}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$meetingId", "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var groups []struct {
+		MeetingID string `bson:"_id"`
+		Count     int    `bson:"count"`
+	}
+	if err := cursor.All(r.Context(), &groups); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int, len(groups))
+	for _, group := range groups {
+		counts[group.MeetingID] = group.Count
+	}
+	return counts, nil
+}
+
+// adminEndMeetingHandler ends any active meeting, as its host could
+func adminEndMeetingHandler(w http.ResponseWriter, r *http.Request) {
+	meeting, err := findMeeting(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+
+	admin := adminFromContext(r.Context())
+	now := time.Now()
+	ended, err := finishMeeting(r.Context(), meeting, admin.ID, map[string]interface{}{
+		"endedBy": admin.ID,
+		"endedAt": now,
+		"reason":  "admin",
+	}, now)
+	if err != nil {
+		log.Printf("Error ending meeting %s: %v", meeting.ID, err)
+		sendErrorResponse(w, "Failed to end meeting", http.StatusInternalServerError)
+		return
+	}
+	if !ended {
+		sendErrorResponse(w, "Meeting has already ended", http.StatusConflict)
+		return
+	}
+
+	recordSecurityEvent(r.Context(), SecurityEvent{
+		Type:    SecurityMeetingForceEnded,
+		UserID:  meeting.CreatedBy,
+		IP:      getClientIP(r),
+		ActorID: admin.ID,
+		Details: map[string]interface{}{"meetingId": meeting.ID},
+	})
+
+	sendSuccessResponse(w, map[string]interface{}{"id": meeting.ID, "isActive": false, "endedAt": now})
+}
+
+// adminKickParticipantHandler removes a participant from a meeting and
+// disconnects their connections to this instance
+func adminKickParticipantHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	meeting, err := findMeeting(r.Context(), vars["id"])
+	if err != nil {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+	userID := vars["userId"]
+
+	var participant Participant
+	err = db.Participants.FindOneAndDelete(r.Context(), bson.M{"meetingId": meeting.ID, "userId": userID}).Decode(&participant)
+	if err == mongo.ErrNoDocuments {
+		sendErrorResponse(w, "Not a participant of this meeting", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		sendErrorResponse(w, "Failed to remove participant", http.StatusInternalServerError)
+		return
+	}
+
+	admin := adminFromContext(r.Context())
+	now := time.Now()
+	if participant.LeftAt == nil {
+		recordAttendance(r.Context(), meeting.ID, userID, participant.UserName, AttendanceLeave, now)
+		seatFreed(r.Context(), meeting.ID)
+	}
+	hub.DisconnectUser(meeting.ID, userID, WebSocketMessage{
+		Type:      "removed-from-meeting",
+		Data:      map[string]interface{}{"userId": userID, "reason": "admin"},
+		UserID:    admin.ID,
+		Timestamp: now,
+	})
+
+	recordSecurityEvent(r.Context(), SecurityEvent{
+		Type:    SecurityParticipantKicked,
+		UserID:  userID,
+		IP:      getClientIP(r),
+		ActorID: admin.ID,
+		Details: map[string]interface{}{"meetingId": meeting.ID},
+	})
+
+	sendSuccessResponse(w, map[string]interface{}{"meetingId": meeting.ID, "userId": userID})
+}
+
+// adminHubStatsHandler reports the hub's connection statistics
+func adminHubStatsHandler(w http.ResponseWriter, r *http.Request) {
+	stats, err := hub.Stats()
+	if err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	sendSuccessResponse(w, stats)
+}
+
+// adminRotateSigningKeyHandler replaces the key that signs invitation,
+// recording and attachment links
+func adminRotateSigningKeyHandler(w http.ResponseWriter, r *http.Request) {
+	key, err := rotateSigningKey(r.Context(), time.Now())
+	if err != nil {
+		log.Printf("Error rotating signing key: %v", err)
+		sendErrorResponse(w, "Failed to rotate signing key", http.StatusInternalServerError)
+		return
+	}
+
+	recordSecurityEvent(r.Context(), SecurityEvent{
+		Type:    SecuritySigningKeyRotated,
+		IP:      getClientIP(r),
+		ActorID: adminFromContext(r.Context()).ID,
+		Details: map[string]interface{}{"keyId": key.ID},
+	})
+
+	sendSuccessResponse(w, key)
+}
+
+// adminRotateDataKeyHandler replaces the data key that encrypts chat messages
+// and transcripts at rest
+func adminRotateDataKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if keyWrapper == nil {
+		sendCodedError(w, ErrCodeUnavailable, "Encryption at rest is not enabled")
+		return
+	}
+	key, err := rotateDataKey(r.Context(), time.Now())
+	if err != nil {
+		log.Printf("Error rotating data key: %v", err)
+		sendErrorResponse(w, "Failed to rotate data key", http.StatusInternalServerError)
+		return
+	}
+
+	recordSecurityEvent(r.Context(), SecurityEvent{
+		Type:    SecurityDataKeyRotated,
+		IP:      getClientIP(r),
+		ActorID: adminFromContext(r.Context()).ID,
+		Details: map[string]interface{}{"keyId": key.ID, "provider": key.Provider},
+	})
+
+	sendSuccessResponse(w, key)
+}
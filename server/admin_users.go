@@ -0,0 +1,446 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+)
+
+const (
+	DefaultAdminUsersPageSize    = 50
+	MaxAdminUsersPageSize        = 200
+	DisabledUsersRefreshInterval = 30 * time.Second
+	MaxDisableReasonLength       = 500
+)
+
+// disabledUserSet caches the IDs of disabled accounts so every authenticated
+// request can reject them without a database round trip. Each instance
+// refreshes it periodically, so a disable made elsewhere applies within
+// DisabledUsersRefreshInterval.
+type disabledUserSet struct {
+	mu  sync.RWMutex
+	ids map[string]bool
+}
+
+var disabledUsers = &disabledUserSet{ids: make(map[string]bool)}
+
+func (s *disabledUserSet) Contains(userID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ids[userID]
+}
+
+func (s *disabledUserSet) Set(userID string, disabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if disabled {
+		s.ids[userID] = true
+	} else {
+		delete(s.ids, userID)
+	}
+}
+
+func (s *disabledUserSet) refresh(ctx context.Context) error {
+	cursor, err := db.Users.Find(ctx, bson.M{"disabledAt": bson.M{"$exists": true}},
+		options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	var users []User
+	if err := cursor.All(ctx, &users); err != nil {
+		return err
+	}
+
+	ids := make(map[string]bool, len(users))
+	for _, user := range users {
+		ids[user.ID] = true
+	}
+	s.mu.Lock()
+	s.ids = ids
+	s.mu.Unlock()
+	return nil
+}
+
+// startDisabledUsersRefresh reloads the disabled account cache until ctx is cancelled
+func startDisabledUsersRefresh(ctx context.Context) {
+	if err := disabledUsers.refresh(ctx); err != nil {
+		log.Printf("Error loading disabled accounts: %v", err)
+	}
+
+	ticker := time.NewTicker(DisabledUsersRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := disabledUsers.refresh(ctx); err != nil {
+				log.Printf("Error refreshing disabled accounts: %v", err)
+			}
+		}
+	}
+}
+
+// UserUsage summarizes how much a user has used the service
+type UserUsage struct {
+	MeetingsHosted   int64      `json:"meetingsHosted"`
+	MeetingsAttended int64      `json:"meetingsAttended"`
+	MinutesInMeeting int64      `json:"minutesInMeetings"`
+	ActiveMeetings   int64      `json:"activeMeetings"`
+	Devices          int64      `json:"devices"`
+	LastLoginAt      *time.Time `json:"lastLoginAt,omitempty"`
+}
+
+// loadUserUsage gathers usage statistics for one user
+func loadUserUsage(ctx context.Context, userID string, now time.Time) (UserUsage, error) {
+	var usage UserUsage
+	var err error
+
+	if usage.MeetingsHosted, err = db.Meetings.CountDocuments(ctx, bson.M{"createdBy": userID}); err != nil {
+		return usage, err
+	}
+	archived, err := db.MeetingsArchive.CountDocuments(ctx, bson.M{"createdBy": userID})
+	if err != nil {
+		return usage, err
+	}
+	usage.MeetingsHosted += archived
+	if usage.ActiveMeetings, err = db.Meetings.CountDocuments(ctx, bson.M{"createdBy": userID, "isActive": true}); err != nil {
+		return usage, err
+	}
+	if usage.Devices, err = db.DeviceTokens.CountDocuments(ctx, bson.M{"userId": userID}); err != nil {
+		return usage, err
+	}
+
+	// Time in meetings comes from participant records; open ones count up to now
+	cursor, err := db.Participants.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"userId": userID}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":      nil,
+			"meetings": bson.M{"$addToSet": "$meetingId"},
+			"millis": bson.M{"$sum": bson.M{"$subtract": bson.A{
+				bson.M{"$ifNull": bson.A{"$leftAt", now}},
+				"$joinedAt",
+			}}},
+		}}},
+	})
+	if err != nil {
+		return usage, err
+	}
+	var totals []struct {
+		Meetings []string `bson:"meetings"`
+		Millis   int64    `bson:"millis"`
+	}
+	if err := cursor.All(ctx, &totals); err != nil {
+		return usage, err
+	}
+	if len(totals) > 0 {
+		usage.MeetingsAttended = int64(len(totals[0].Meetings))
+		usage.MinutesInMeeting = totals[0].Millis / int64(time.Minute/time.Millisecond)
+	}
+
+	var lastLogin SecurityEvent
+	err = db.SecurityEvents.FindOne(ctx,
+		bson.M{"userId": userID, "type": SecurityLoginSucceeded},
+		options.FindOne().SetSort(bson.M{"at": -1}),
+	).Decode(&lastLogin)
+	if err == nil {
+		usage.LastLoginAt = &lastLogin.At
+	} else if err != mongo.ErrNoDocuments {
+		return usage, err
+	}
+
+	return usage, nil
+}
+
+// adminListUsersHandler lists users, newest first, optionally searching by
+// name or email and filtering by ?disabled=true|false
+func adminListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter := bson.M{}
+	if q := strings.TrimSpace(query.Get("q")); q != "" {
+		pattern := bson.M{"$regex": regexp.QuoteMeta(q), "$options": "i"}
+		filter["$or"] = []bson.M{{"name": pattern}, {"email": pattern}}
+	}
+	if disabled := query.Get("disabled"); disabled != "" {
+		filter["disabledAt"] = bson.M{"$exists": disabled == "true"}
+	}
+
+	limit := DefaultAdminUsersPageSize
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			sendErrorResponse(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(parsed, MaxAdminUsersPageSize)
+	}
+	offset := 0
+	if value := query.Get("offset"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			sendErrorResponse(w, "Invalid offset", http.StatusBadRequest)
+			return
+		}
+		offset = parsed
+	}
+
+	total, err := db.Users.CountDocuments(r.Context(), filter)
+	if err != nil {
+		sendErrorResponse(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	cursor, err := db.Users.Find(r.Context(), filter, options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: 1}}).
+		SetSkip(int64(offset)).
+		SetLimit(int64(limit)))
+	if err != nil {
+		sendErrorResponse(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	users := []User{}
+	if err := cursor.All(r.Context(), &users); err != nil {
+		sendErrorResponse(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	admin := adminFromContext(r.Context())
+	recordSecurityEvent(r.Context(), SecurityEvent{
+		Type:    SecurityAdminUsersListed,
+		IP:      getClientIP(r),
+		ActorID: admin.ID,
+		Details: map[string]interface{}{"query": query.Get("q"), "disabled": query.Get("disabled"), "offset": offset},
+	})
+
+	sendSuccessResponse(w, map[string]interface{}{
+		"users": users,
+		"total": total,
+	})
+}
+
+// adminGetUserHandler returns one user with their usage statistics
+func adminGetUserHandler(w http.ResponseWriter, r *http.Request) {
+	var user User
+	if err := db.Users.FindOne(r.Context(), bson.M{"_id": mux.Vars(r)["id"]}).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			sendCodedError(w, ErrCodeUserNotFound, "User not found")
+		} else {
+			sendErrorResponse(w, "Database error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	usage, err := loadUserUsage(r.Context(), user.ID, time.Now())
+	if err != nil {
+		log.Printf("Error loading usage for %s: %v", user.ID, err)
+		sendErrorResponse(w, "Failed to load usage", http.StatusInternalServerError)
+		return
+	}
+
+	recordSecurityEvent(r.Context(), SecurityEvent{
+		Type:    SecurityAdminUserViewed,
+		UserID:  user.ID,
+		Email:   user.Email,
+		IP:      getClientIP(r),
+		ActorID: adminFromContext(r.Context()).ID,
+	})
+
+	sendSuccessResponse(w, map[string]interface{}{
+		"user":      user,
+		"adminRole": adminRoleOf(user),
+		"usage":     usage,
+	})
+}
+
+// adminSetDisabledHandler returns a handler that disables or re-enables an account
+func adminSetDisabledHandler(disable bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		admin := adminFromContext(r.Context())
+		targetID := mux.Vars(r)["id"]
+		if disable && targetID == admin.ID {
+			sendErrorResponse(w, "You cannot disable your own account", http.StatusBadRequest)
+			return
+		}
+
+		var req struct {
+			Reason string `json:"reason,omitempty"`
+		}
+		if disable && r.ContentLength != 0 {
+			if !decodeJSONBody(w, r, &req) {
+				return
+			}
+		}
+		req.Reason = strings.TrimSpace(req.Reason)
+		if len(req.Reason) > MaxDisableReasonLength {
+			sendErrorResponse(w, "Reason must be 500 characters or fewer", http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		update := bson.M{
+			"$unset": bson.M{"disabledAt": "", "disabledReason": ""},
+			"$set":   bson.M{"updatedAt": now},
+		}
+		eventType := SecurityAccountEnabled
+		if disable {
+			update = bson.M{"$set": bson.M{"disabledAt": now, "disabledReason": req.Reason, "updatedAt": now}}
+			eventType = SecurityAccountDisabled
+		}
+
+		var user User
+		err := db.Users.FindOneAndUpdate(r.Context(), bson.M{"_id": targetID}, update,
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				sendCodedError(w, ErrCodeUserNotFound, "User not found")
+			} else {
+				log.Printf("Error updating account %s: %v", targetID, err)
+				sendErrorResponse(w, "Failed to update account", http.StatusInternalServerError)
+			}
+			return
+		}
+		disabledUsers.Set(user.ID, disable)
+		if disable {
+			endUserSessions(r.Context(), user.ID)
+		}
+
+		details := map[string]interface{}{}
+		if req.Reason != "" {
+			details["reason"] = req.Reason
+		}
+		recordSecurityEvent(r.Context(), SecurityEvent{
+			Type:    eventType,
+			UserID:  user.ID,
+			Email:   user.Email,
+			IP:      getClientIP(r),
+			ActorID: admin.ID,
+			Details: details,
+		})
+
+		sendSuccessResponse(w, user)
+	}
+}
+
+// generateTemporaryPassword returns a random password for an admin reset
+func generateTemporaryPassword() (string, error) {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// adminResetPasswordHandler replaces the user's password with a temporary one
+// and emails it to them. Any lockout on the account is cleared.
+func adminResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	admin := adminFromContext(r.Context())
+
+	var user User
+	if err := db.Users.FindOne(r.Context(), bson.M{"_id": mux.Vars(r)["id"]}).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			sendCodedError(w, ErrCodeUserNotFound, "User not found")
+		} else {
+			sendErrorResponse(w, "Database error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	password, err := generateTemporaryPassword()
+	if err != nil {
+		sendErrorResponse(w, "Failed to generate password", http.StatusInternalServerError)
+		return
+	}
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
+		sendErrorResponse(w, "Failed to generate password", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = db.Users.UpdateOne(r.Context(), bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"password": hashedPassword, "updatedAt": time.Now()}})
+	if err != nil {
+		log.Printf("Error resetting password for %s: %v", user.ID, err)
+		sendErrorResponse(w, "Failed to reset password", http.StatusInternalServerError)
+		return
+	}
+	if err := clearAccountLockout(r.Context(), user.Email); err != nil {
+		log.Printf("Error clearing lockout for %s: %v", user.ID, err)
+	}
+	// Whoever knew the old password is signed out
+	endUserSessions(r.Context(), user.ID)
+
+	err = mail.EnqueueTemplate(user.Email, "password_reset", map[string]string{
+		"Name":              user.Name,
+		"TemporaryPassword": password,
+		"LoginURL":          appBaseURL() + "/login",
+	})
+	if err != nil {
+		log.Printf("Error sending password reset to %s: %v", user.ID, err)
+	}
+
+	recordSecurityEvent(r.Context(), SecurityEvent{
+		Type:    SecurityPasswordReset,
+		UserID:  user.ID,
+		Email:   user.Email,
+		IP:      getClientIP(r),
+		ActorID: admin.ID,
+	})
+
+	sendSuccessResponse(w, map[string]string{"message": "A temporary password was emailed to the user"})
+}
+
+// adminSetPlanHandler moves a user to another plan tier
+func adminSetPlanHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Plan string `json:"plan"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if !isValidPlan(req.Plan) {
+		sendErrorResponse(w, "plan must be free or pro", http.StatusBadRequest)
+		return
+	}
+
+	var previous User
+	err := db.Users.FindOneAndUpdate(r.Context(), bson.M{"_id": mux.Vars(r)["id"]},
+		bson.M{"$set": bson.M{"plan": req.Plan, "updatedAt": time.Now()}}).Decode(&previous)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			sendCodedError(w, ErrCodeUserNotFound, "User not found")
+		} else {
+			sendErrorResponse(w, "Failed to update plan", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	recordSecurityEvent(r.Context(), SecurityEvent{
+		Type:    SecurityPlanChanged,
+		UserID:  previous.ID,
+		Email:   previous.Email,
+		IP:      getClientIP(r),
+		ActorID: adminFromContext(r.Context()).ID,
+		Details: map[string]interface{}{"from": planOf(previous), "to": req.Plan},
+	})
+
+	quota, err := loadQuotaState(r.Context(), previous.ID, time.Now())
+	if err != nil {
+		sendErrorResponse(w, "Failed to load quota", http.StatusInternalServerError)
+		return
+	}
+	sendSuccessResponse(w, quota)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+)
+
+const (
+	DefaultAnalyticsDays = 30
+	MaxAnalyticsDays     = 366
+)
+
+// DailyAnalytics sums up a tenant's usage over one UTC day. The rollup job
+// computes the previous day's after midnight; rerunning it recomputes them.
+type DailyAnalytics struct {
+	ID                 string    `json:"-" bson:"_id"`
+	Date               string    `json:"date" bson:"date"`
+	MeetingsStarted    int64     `json:"meetingsStarted" bson:"meetingsStarted"`
+	ParticipantJoins   int64     `json:"participantJoins" bson:"participantJoins"`
+	UniqueParticipants int64     `json:"uniqueParticipants" bson:"uniqueParticipants"`
+	Recordings         int64     `json:"recordings" bson:"recordings"`
+	RecordingSeconds   int64     `json:"recordingSeconds" bson:"recordingSeconds"`
+	ComputedAt         time.Time `json:"computedAt" bson:"computedAt"`
+}
+
+// rollupDailyAnalytics computes every tenant's analytics for the day before
+// now
+func rollupDailyAnalytics(ctx context.Context, now time.Time) error {
+	ctx, span := tracer.Start(ctx, "analytics.rollup")
+	defer span.End()
+
+	end := now.UTC().Truncate(24 * time.Hour)
+	start := end.AddDate(0, 0, -1)
+	for _, tenantID := range tenantIDs() {
+		if err := rollupTenantDay(db.WithTenant(ctx, tenantID), tenantID, start, end); err != nil {
+			return fmt.Errorf("tenant %q: %w", tenantID, err)
+		}
+	}
+	return nil
+}
+
+func rollupTenantDay(ctx context.Context, tenantID string, start, end time.Time) error {
+	day := bson.M{"$gte": start, "$lt": end}
+	date := start.Format("2006-01-02")
+	stats := DailyAnalytics{ID: date, Date: date, ComputedAt: time.Now()}
+	if tenantID != db.DefaultTenant {
+		stats.ID = tenantID + "/" + date
+	}
+
+	var err error
+	if stats.MeetingsStarted, err = db.Meetings.CountDocuments(ctx, bson.M{"startedAt": day}); err != nil {
+		return err
+	}
+	joins := bson.M{"type": AttendanceJoin, "at": day}
+	if stats.ParticipantJoins, err = db.AttendanceEvents.CountDocuments(ctx, joins); err != nil {
+		return err
+	}
+	users, err := db.AttendanceEvents.Distinct(ctx, "userId", joins)
+	if err != nil {
+		return err
+	}
+	stats.UniqueParticipants = int64(len(users))
+
+	cursor, err := db.Recordings.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"createdAt": day}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":     nil,
+			"count":   bson.M{"$sum": 1},
+			"seconds": bson.M{"$sum": "$durationSeconds"},
+		}}},
+	})
+	if err != nil {
+		return err
+	}
+	var recordings []struct {
+		Count   int64 `bson:"count"`
+		Seconds int64 `bson:"seconds"`
+	}
+	if err := cursor.All(ctx, &recordings); err != nil {
+		return err
+	}
+	if len(recordings) > 0 {
+		stats.Recordings = recordings[0].Count
+		stats.RecordingSeconds = recordings[0].Seconds
+	}
+
+	_, err = db.DailyAnalytics.ReplaceOne(ctx, bson.M{"_id": stats.ID}, stats, options.Replace().SetUpsert(true))
+	return err
+}
+
+// adminDailyAnalyticsHandler returns the daily analytics of the request's
+// tenant, newest first; days limits how many
+func adminDailyAnalyticsHandler(w http.ResponseWriter, r *http.Request) {
+	days := DefaultAnalyticsDays
+	if value := r.URL.Query().Get("days"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			sendErrorResponse(w, "Invalid days", http.StatusBadRequest)
+			return
+		}
+		days = min(n, MaxAnalyticsDays)
+	}
+
+	cursor, err := db.DailyAnalytics.Find(r.Context(), bson.M{},
+		options.Find().SetSort(bson.D{{Key: "date", Value: -1}}).SetLimit(int64(days)))
+	if err != nil {
+		log.Printf("Error loading daily analytics: %v", err)
+		sendErrorResponse(w, "Failed to load analytics", http.StatusInternalServerError)
+		return
+	}
+	rollups := []DailyAnalytics{}
+	if err := cursor.All(r.Context(), &rollups); err != nil {
+		sendErrorResponse(w, "Failed to parse analytics", http.StatusInternalServerError)
+		return
+	}
+	sendSuccessResponse(w, rollups)
+}
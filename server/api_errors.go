@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ErrorCode is the machine-readable reason for an error response, sent as
+// the envelope's code. Clients branch on codes; messages are for people and
+// may change.
+type ErrorCode string
+
+// Codes of errors without a more specific reason, chosen by status
+const (
+	ErrCodeBadRequest       ErrorCode = "BAD_REQUEST"
+	ErrCodeUnauthorized     ErrorCode = "UNAUTHORIZED"
+	ErrCodeForbidden        ErrorCode = "FORBIDDEN"
+	ErrCodeNotFound         ErrorCode = "NOT_FOUND"
+	ErrCodeConflict         ErrorCode = "CONFLICT"
+	ErrCodeGone             ErrorCode = "GONE"
+	ErrCodeBodyTooLarge     ErrorCode = "BODY_TOO_LARGE"
+	ErrCodeUnsupportedMedia ErrorCode = "UNSUPPORTED_MEDIA_TYPE"
+	ErrCodeLocked           ErrorCode = "LOCKED"
+	ErrCodeRateLimited      ErrorCode = "RATE_LIMITED"
+	ErrCodeInternal         ErrorCode = "INTERNAL_ERROR"
+	ErrCodeUpstream         ErrorCode = "UPSTREAM_ERROR"
+	ErrCodeUnavailable      ErrorCode = "SERVICE_UNAVAILABLE"
+)
+
+// Codes of specific errors clients handle
+const (
+	ErrCodeInvalidJSON        ErrorCode = "INVALID_JSON"
+	ErrCodeUnknownField       ErrorCode = "UNKNOWN_FIELD"
+	ErrCodeInvalidCredentials ErrorCode = "INVALID_CREDENTIALS"
+	ErrCodeLoginLocked        ErrorCode = "LOGIN_LOCKED"
+	ErrCodeAccountDisabled    ErrorCode = "ACCOUNT_DISABLED"
+	ErrCodeCaptchaRequired    ErrorCode = "CAPTCHA_REQUIRED"
+	ErrCodeEmailTaken         ErrorCode = "EMAIL_TAKEN"
+	ErrCodeUserNotFound       ErrorCode = "USER_NOT_FOUND"
+	ErrCodeMeetingNotFound    ErrorCode = "MEETING_NOT_FOUND"
+	ErrCodeMeetingEnded       ErrorCode = "MEETING_ENDED"
+	ErrCodeMeetingTimeLimit   ErrorCode = "MEETING_TIME_LIMIT_REACHED"
+	ErrCodeMeetingLocked      ErrorCode = "MEETING_LOCKED"
+	ErrCodeIPNotAllowed       ErrorCode = "IP_NOT_ALLOWED"
+	ErrCodeCapacityReached    ErrorCode = "CAPACITY_REACHED"
+	ErrCodeQuotaExceeded      ErrorCode = "QUOTA_EXCEEDED"
+	ErrCodeHostOnly           ErrorCode = "HOST_ONLY"
+	ErrCodePermissionDenied   ErrorCode = "PERMISSION_DENIED"
+	ErrCodeWrongRegion        ErrorCode = "WRONG_REGION"
+)
+
+// errorCodeInfo is the status a code is sent with and what it means
+type errorCodeInfo struct {
+	Status      int
+	Description string
+}
+
+// errorCodes registers every code the API sends
+var errorCodes = map[ErrorCode]errorCodeInfo{
+	ErrCodeBadRequest:       {http.StatusBadRequest, "The request is invalid; the message says why"},
+	ErrCodeUnauthorized:     {http.StatusUnauthorized, "Sign in first"},
+	ErrCodeForbidden:        {http.StatusForbidden, "The caller may not do this"},
+	ErrCodeNotFound:         {http.StatusNotFound, "The resource does not exist or is not visible to the caller"},
+	ErrCodeConflict:         {http.StatusConflict, "The resource is not in a state that allows this"},
+	ErrCodeGone:             {http.StatusGone, "The resource no longer exists"},
+	ErrCodeBodyTooLarge:     {http.StatusRequestEntityTooLarge, "The request body exceeds the route's limit"},
+	ErrCodeUnsupportedMedia: {http.StatusUnsupportedMediaType, "The uploaded file type is not allowed"},
+	ErrCodeLocked:           {http.StatusLocked, "The resource is locked"},
+	ErrCodeRateLimited:      {http.StatusTooManyRequests, "Too many requests; retry later"},
+	ErrCodeInternal:         {http.StatusInternalServerError, "The server failed; retrying may help"},
+	ErrCodeUpstream:         {http.StatusBadGateway, "A service the server depends on failed"},
+	ErrCodeUnavailable:      {http.StatusServiceUnavailable, "The feature is disabled or temporarily unavailable"},
+
+	ErrCodeInvalidJSON:        {http.StatusBadRequest, "The request body is not valid JSON"},
+	ErrCodeUnknownField:       {http.StatusBadRequest, "The request body has a field the endpoint does not accept"},
+	ErrCodeInvalidCredentials: {http.StatusUnauthorized, "The email or password is wrong"},
+	ErrCodeLoginLocked:        {http.StatusTooManyRequests, "Too many failed logins; retry after Retry-After seconds"},
+	ErrCodeAccountDisabled:    {http.StatusForbidden, "The account has been disabled by an admin"},
+	ErrCodeCaptchaRequired:    {http.StatusForbidden, "Complete the CAPTCHA from /api/auth/captcha and retry with captchaToken"},
+	ErrCodeEmailTaken:         {http.StatusConflict, "An account with the email already exists"},
+	ErrCodeUserNotFound:       {http.StatusNotFound, "The user does not exist"},
+	ErrCodeMeetingNotFound:    {http.StatusNotFound, "The meeting does not exist or is not visible to the caller"},
+	ErrCodeMeetingEnded:       {http.StatusGone, "The meeting has ended"},
+	ErrCodeMeetingTimeLimit:   {http.StatusGone, "The meeting ran for as long as its host's plan allows"},
+	ErrCodeMeetingLocked:      {http.StatusLocked, "The host has locked the meeting"},
+	ErrCodeIPNotAllowed:       {http.StatusForbidden, "The meeting's IP rules do not admit the caller's address"},
+	ErrCodeCapacityReached:    {http.StatusForbidden, "The meeting has as many participants as it allows"},
+	ErrCodeQuotaExceeded:      {http.StatusForbidden, "The caller's plan does not allow this"},
+	ErrCodeHostOnly:           {http.StatusForbidden, "Only the meeting's host may do this"},
+	ErrCodePermissionDenied:   {http.StatusForbidden, "The caller's meeting role does not allow this"},
+	ErrCodeWrongRegion:        {http.StatusMisdirectedRequest, "Another region serves the meeting; reconnect to the Location header's URL"},
+}
+
+// statusErrorCodes are the codes of errors sent by status alone
+var statusErrorCodes = map[int]ErrorCode{
+	http.StatusBadRequest:            ErrCodeBadRequest,
+	http.StatusUnauthorized:          ErrCodeUnauthorized,
+	http.StatusForbidden:             ErrCodeForbidden,
+	http.StatusNotFound:              ErrCodeNotFound,
+	http.StatusConflict:              ErrCodeConflict,
+	http.StatusGone:                  ErrCodeGone,
+	http.StatusRequestEntityTooLarge: ErrCodeBodyTooLarge,
+	http.StatusUnsupportedMediaType:  ErrCodeUnsupportedMedia,
+	http.StatusLocked:                ErrCodeLocked,
+	http.StatusTooManyRequests:       ErrCodeRateLimited,
+	http.StatusInternalServerError:   ErrCodeInternal,
+	http.StatusBadGateway:            ErrCodeUpstream,
+	http.StatusServiceUnavailable:    ErrCodeUnavailable,
+}
+
+// errorCodeForStatus returns the generic code of an error status
+func errorCodeForStatus(status int) ErrorCode {
+	if code, ok := statusErrorCodes[status]; ok {
+		return code
+	}
+	if status >= http.StatusInternalServerError {
+		return ErrCodeInternal
+	}
+	return ErrCodeBadRequest
+}
+
+// sendCodedError sends an error with a specific code, with the code's status
+func sendCodedError(w http.ResponseWriter, code ErrorCode, message string) {
+	sendJSONResponse(w, errorCodes[code].Status, Response{
+		Success: false,
+		Error:   message,
+		Code:    code,
+	})
+}
+
+// errorCodeSchema describes the code field for the OpenAPI document
+func errorCodeSchema() map[string]interface{} {
+	codes := make([]string, 0, len(errorCodes))
+	for code := range errorCodes {
+		codes = append(codes, string(code))
+	}
+	sort.Strings(codes)
+
+	lines := make([]string, len(codes))
+	for i, code := range codes {
+		info := errorCodes[ErrorCode(code)]
+		lines[i] = fmt.Sprintf("- %s (%d): %s", code, info.Status, info.Description)
+	}
+	return map[string]interface{}{
+		"type":        "string",
+		"enum":        codes,
+		"description": "Machine-readable reason for the error:\n" + strings.Join(lines, "\n"),
+	}
+}
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+)
+
+// Non-human clients such as recording bots authenticate with API keys
+// instead of sessions. A key belongs to a service account, a user that
+// cannot sign in and is managed by the user who created it, and is sent as
+// "Authorization: Bearer mk_<keyId>_<secret>". Each key is limited to its
+// scopes and its own request budget.
+
+const (
+	APIKeyPrefix = "mk_"
+	// After a rotation the previous secret keeps working this long, so
+	// deployed clients can be updated
+	APIKeyRotationGrace         = 24 * time.Hour
+	MaxAPIKeyRequestsPerMinute  = 6000
+	MaxServiceAccountNameLength = 100
+	MaxAPIKeysPerAccount        = 10
+	// Last-use times are only written this often
+	apiKeyLastUsedResolution = time.Minute
+)
+
+// API key scopes
+const (
+	ScopeMeetingsRead    = "meetings:read"
+	ScopeMeetingsWrite   = "meetings:write" // create and join meetings, connect to them
+	ScopeChatRead        = "chat:read"
+	ScopeChatWrite       = "chat:write"
+	ScopeRecordingsRead  = "recordings:read"
+	ScopeRecordingsWrite = "recordings:write" // start, switch and stop recordings
+)
+
+func isValidScope(scope string) bool {
+	switch scope {
+	case ScopeMeetingsRead, ScopeMeetingsWrite, ScopeChatRead, ScopeChatWrite, ScopeRecordingsRead, ScopeRecordingsWrite:
+		return true
+	}
+	return false
+}
+
+// apiKeyRouteScopes is the scope each route requires of API keys, by
+// "METHOD /path template". Routes missing here, such as account and key
+// management, are closed to API keys.
+var apiKeyRouteScopes = map[string]string{
+	"GET /api/meetings":                                      ScopeMeetingsRead,
+	"GET /api/meetings/code/{code}":                          ScopeMeetingsRead,
+	"GET /api/meetings/{id}":                                 ScopeMeetingsRead,
+	"GET /api/meetings/{id}/participants":                    ScopeMeetingsRead,
+	"GET /api/meetings/{id}/state":                           ScopeMeetingsRead,
+	"GET /api/meetings/{id}/timeline":                        ScopeMeetingsRead,
+	"GET /api/meetings/{id}/attendance":                      ScopeMeetingsRead,
+	"GET /api/meetings/{id}/transcript":                      ScopeMeetingsRead,
+	"GET /api/meetings/{id}/summary":                         ScopeMeetingsRead,
+	"GET /api/meetings/{id}/notes":                           ScopeMeetingsRead,
+	"GET /api/meetings/{id}/whiteboard":                      ScopeMeetingsRead,
+	"GET /api/meetings/{id}/polls":                           ScopeMeetingsRead,
+	"GET /api/meetings/{id}/analytics":                       ScopeMeetingsRead,
+	"GET /api/meetings/{id}/quality":                         ScopeMeetingsRead,
+	"POST /api/meetings":                                     ScopeMeetingsWrite,
+	"POST /api/meetings/{id}/join":                           ScopeMeetingsWrite,
+	"POST /api/meetings/{id}/embed-token":                    ScopeMeetingsWrite,
+	"PUT /api/meetings/{id}/participants":                    ScopeMeetingsWrite,
+	"PATCH /api/meetings/{id}/participants":                  ScopeMeetingsWrite,
+	"DELETE /api/meetings/{id}/participants/me":              ScopeMeetingsWrite,
+	"POST /api/meetings/{id}/heartbeat":                      ScopeMeetingsWrite,
+	"GET /api/ws/{meetingId}":                                ScopeMeetingsWrite,
+	"GET /api/sse/{meetingId}":                               ScopeMeetingsWrite,
+	"POST /api/sse/{meetingId}/{sessionId}":                  ScopeMeetingsWrite,
+	"GET /api/meetings/{id}/chat":                            ScopeChatRead,
+	"GET /api/meetings/{id}/chat/attachments/{attachmentId}": ScopeChatRead,
+	"GET /api/meetings/{id}/chat/{messageId}/history":        ScopeChatRead,
+	"POST /api/meetings/{id}/chat/read":                      ScopeChatRead,
+	"POST /api/meetings/{id}/chat/attachments":               ScopeChatWrite,
+	"PUT /api/meetings/{id}/chat/{messageId}":                ScopeChatWrite,
+	"DELETE /api/meetings/{id}/chat/{messageId}":             ScopeChatWrite,
+	"GET /api/meetings/{id}/recordings":                      ScopeRecordingsRead,
+	"GET /api/recordings/{id}":                               ScopeRecordingsRead,
+	"GET /api/recordings/{id}/stream":                        ScopeRecordingsRead,
+	"GET /api/recordings/{id}/{media:poster|preview}":        ScopeRecordingsRead,
+	"GET /api/recordings/{id}/captions.vtt":                  ScopeRecordingsRead,
+	"POST /api/meetings/{id}/recording/start":                ScopeRecordingsWrite,
+	"PUT /api/meetings/{id}/recording/layout":                ScopeRecordingsWrite,
+	"POST /api/meetings/{id}/recording/stop":                 ScopeRecordingsWrite,
+}
+
+// APIKey authenticates a service account. Only hashes of its secrets are
+// stored; the secret is shown once, when the key is created or rotated.
+type APIKey struct {
+	ID                string     `json:"id" bson:"_id"`
+	AccountID         string     `json:"accountId" bson:"accountId"`
+	OwnerID           string     `json:"ownerId" bson:"ownerId"`
+	Name              string     `json:"name" bson:"name"`
+	Scopes            []string   `json:"scopes" bson:"scopes"`
+	RequestsPerMinute int        `json:"requestsPerMinute" bson:"requestsPerMinute"`
+	SecretHash        string     `json:"-" bson:"secretHash"`
+	PreviousHash      string     `json:"-" bson:"previousHash,omitempty"`
+	PreviousExpiresAt *time.Time `json:"previousExpiresAt,omitempty" bson:"previousExpiresAt,omitempty"`
+	CreatedAt         time.Time  `json:"createdAt" bson:"createdAt"`
+	RotatedAt         *time.Time `json:"rotatedAt,omitempty" bson:"rotatedAt,omitempty"`
+	LastUsedAt        *time.Time `json:"lastUsedAt,omitempty" bson:"lastUsedAt,omitempty"`
+	// Set only in the response that creates or rotates the key
+	Key string `json:"key,omitempty" bson:"-"`
+}
+
+func (k *APIKey) hasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type apiKeyContextKey struct{}
+
+// requestAPIKey returns the API key that authenticated the request, if any
+func requestAPIKey(r *http.Request) *APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey{}).(*APIKey)
+	return key
+}
+
+// apiKeyLimiter holds each key's request budget; created in main
+var apiKeyLimiter *RateLimiter
+
+func hashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// newAPIKeySecret returns a random secret and the full key carrying it
+func newAPIKeySecret(keyID string) (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(buf)
+	return secret, APIKeyPrefix + keyID + "_" + secret, nil
+}
+
+// authenticateAPIKey checks a presented key, returning it if it is valid
+func authenticateAPIKey(ctx context.Context, presented string, now time.Time) (*APIKey, error) {
+	keyID, secret, ok := strings.Cut(strings.TrimPrefix(presented, APIKeyPrefix), "_")
+	if !ok || keyID == "" || secret == "" {
+		return nil, errors.New("malformed API key")
+	}
+
+	var key APIKey
+	if err := db.APIKeys.FindOne(ctx, bson.M{"_id": keyID}).Decode(&key); err != nil {
+		return nil, errors.New("invalid API key")
+	}
+	hash := hashAPIKeySecret(secret)
+	valid := subtle.ConstantTimeCompare([]byte(hash), []byte(key.SecretHash)) == 1
+	if !valid && key.PreviousHash != "" && key.PreviousExpiresAt != nil && now.Before(*key.PreviousExpiresAt) {
+		valid = subtle.ConstantTimeCompare([]byte(hash), []byte(key.PreviousHash)) == 1
+	}
+	if !valid || disabledUsers.Contains(key.AccountID) {
+		return nil, errors.New("invalid API key")
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > apiKeyLastUsedResolution {
+		if _, err := db.APIKeys.UpdateOne(ctx, bson.M{"_id": key.ID}, bson.M{"$set": bson.M{"lastUsedAt": now}}); err != nil {
+			log.Printf("Error updating last use of API key %s: %v", key.ID, err)
+		}
+	}
+	return &key, nil
+}
+
+// apiKeyMiddleware authenticates requests bearing an API key, checks the
+// route against the key's scopes and enforces the key's request budget.
+// Handlers then see the service account as the signed-in user.
+func apiKeyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !strings.HasPrefix(presented, APIKeyPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		now := time.Now()
+		key, err := authenticateAPIKey(r.Context(), presented, now)
+		if err != nil {
+			sendErrorResponse(w, "Invalid API key", http.StatusUnauthorized)
+			return
+		}
+
+		template := ""
+		if route := mux.CurrentRoute(r); route != nil {
+			template, _ = route.GetPathTemplate()
+		}
+		scope, ok := apiKeyRouteScopes[r.Method+" "+template]
+		if !ok && r.Method == http.MethodHead {
+			scope, ok = apiKeyRouteScopes["GET "+template]
+		}
+		if !ok {
+			sendCodedError(w, ErrCodeForbidden, "API keys cannot use this endpoint")
+			return
+		}
+		if !key.hasScope(scope) {
+			sendCodedError(w, ErrCodeForbidden, fmt.Sprintf("This API key lacks the %s scope", scope))
+			return
+		}
+
+		policy := RateLimitPolicy{
+			Name:              "api-key",
+			RequestsPerMinute: key.RequestsPerMinute,
+			Burst:             max(key.RequestsPerMinute/6, 1), // ten seconds' worth
+		}
+		allowed, _, reset := apiKeyLimiter.Allow(policy, "key:"+key.ID, now)
+		if !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+			sendCodedError(w, ErrCodeRateLimited, "API key rate limit exceeded")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, key)))
+	})
+}
+
+// apiKeyAllowsMessage reports whether a realtime client may send a message
+// type; clients connected with an API key need chat:write to chat
+func apiKeyAllowsMessage(c *Client, messageType string) bool {
+	if c.apiKey == nil || !strings.HasPrefix(messageType, "chat-") {
+		return true
+	}
+	return c.apiKey.hasScope(ScopeChatWrite)
+}
+
+// loadOwnedServiceAccount finds a service account of the user, writing the
+// error response and returning false if there is none
+func loadOwnedServiceAccount(w http.ResponseWriter, r *http.Request, userID string) (User, bool) {
+	var account User
+	err := db.Users.FindOne(r.Context(), bson.M{"_id": mux.Vars(r)["id"], "serviceAccountOf": userID}).Decode(&account)
+	if err != nil {
+		sendErrorResponse(w, "Service account not found", http.StatusNotFound)
+		return account, false
+	}
+	return account, true
+}
+
+// createServiceAccountHandler creates a service account managed by the caller
+func createServiceAccountHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > MaxServiceAccountNameLength {
+		sendErrorResponse(w, "Name must be between 1 and 100 characters", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	id := uuid.New().String()
+	account := User{
+		ID:               id,
+		Name:             name,
+		Email:            id + "@service.invalid", // emails are unique; service accounts have none
+		ServiceAccountOf: userID,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+	if _, err := db.Users.InsertOne(r.Context(), account); err != nil {
+		log.Printf("Error creating service account: %v", err)
+		sendErrorResponse(w, "Failed to create service account", http.StatusInternalServerError)
+		return
+	}
+	sendSuccessResponse(w, account)
+}
+
+// getServiceAccountsHandler lists the caller's service accounts
+func getServiceAccountsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	cursor, err := db.Users.Find(r.Context(), bson.M{"serviceAccountOf": userID},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}))
+	if err != nil {
+		sendErrorResponse(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	accounts := []User{}
+	if err := cursor.All(r.Context(), &accounts); err != nil {
+		sendErrorResponse(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	sendSuccessResponse(w, accounts)
+}
+
+type createAPIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// Defaults to rateLimit.apiKeyRequestsPerMinute
+	RequestsPerMinute int `json:"requestsPerMinute,omitempty"`
+}
+
+// createAPIKeyHandler issues a key for one of the caller's service accounts.
+// The response is the only time the key is shown.
+func createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	account, ok := loadOwnedServiceAccount(w, r, userID)
+	if !ok {
+		return
+	}
+
+	var req createAPIKeyRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > MaxServiceAccountNameLength {
+		sendErrorResponse(w, "Name must be between 1 and 100 characters", http.StatusBadRequest)
+		return
+	}
+	if len(req.Scopes) == 0 {
+		sendErrorResponse(w, "At least one scope is required", http.StatusBadRequest)
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !isValidScope(scope) {
+			sendErrorResponse(w, fmt.Sprintf("Unknown scope %q", scope), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.RequestsPerMinute == 0 {
+		req.RequestsPerMinute = appConfig.RateLimit.APIKeyRequestsPerMinute
+	}
+	if req.RequestsPerMinute < 1 || req.RequestsPerMinute > MaxAPIKeyRequestsPerMinute {
+		sendErrorResponse(w, fmt.Sprintf("requestsPerMinute must be between 1 and %d", MaxAPIKeyRequestsPerMinute), http.StatusBadRequest)
+		return
+	}
+
+	count, err := db.APIKeys.CountDocuments(r.Context(), bson.M{"accountId": account.ID})
+	if err != nil {
+		sendErrorResponse(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if count >= MaxAPIKeysPerAccount {
+		sendCodedError(w, ErrCodeConflict, fmt.Sprintf("A service account may have at most %d keys", MaxAPIKeysPerAccount))
+		return
+	}
+
+	key := APIKey{
+		ID:                uuid.New().String(),
+		AccountID:         account.ID,
+		OwnerID:           userID,
+		Name:              req.Name,
+		Scopes:            req.Scopes,
+		RequestsPerMinute: req.RequestsPerMinute,
+		CreatedAt:         time.Now(),
+	}
+	secret, full, err := newAPIKeySecret(key.ID)
+	if err != nil {
+		log.Printf("Error generating API key: %v", err)
+		sendErrorResponse(w, "Failed to create API key", http.StatusInternalServerError)
+		return
+	}
+	key.SecretHash = hashAPIKeySecret(secret)
+	if _, err := db.APIKeys.InsertOne(r.Context(), key); err != nil {
+		log.Printf("Error storing API key: %v", err)
+		sendErrorResponse(w, "Failed to create API key", http.StatusInternalServerError)
+		return
+	}
+
+	key.Key = full
+	sendSuccessResponse(w, key)
+}
+
+// getAPIKeysHandler lists a service account's keys, without their secrets
+func getAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	account, ok := loadOwnedServiceAccount(w, r, userID)
+	if !ok {
+		return
+	}
+
+	cursor, err := db.APIKeys.Find(r.Context(), bson.M{"accountId": account.ID},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}))
+	if err != nil {
+		sendErrorResponse(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	keys := []APIKey{}
+	if err := cursor.All(r.Context(), &keys); err != nil {
+		sendErrorResponse(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	sendSuccessResponse(w, keys)
+}
+
+// rotateAPIKeyHandler replaces a key's secret. The previous secret keeps
+// working for APIKeyRotationGrace.
+func rotateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	account, ok := loadOwnedServiceAccount(w, r, userID)
+	if !ok {
+		return
+	}
+
+	keyID := mux.Vars(r)["keyId"]
+	secret, full, err := newAPIKeySecret(keyID)
+	if err != nil {
+		log.Printf("Error generating API key: %v", err)
+		sendErrorResponse(w, "Failed to rotate API key", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	var key APIKey
+	err = db.APIKeys.FindOneAndUpdate(r.Context(),
+		bson.M{"_id": keyID, "accountId": account.ID},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{
+			"previousHash":      "$secretHash",
+			"previousExpiresAt": now.Add(APIKeyRotationGrace),
+			"secretHash":        hashAPIKeySecret(secret),
+			"rotatedAt":         now,
+		}}}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&key)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		sendErrorResponse(w, "API key not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error rotating API key %s: %v", keyID, err)
+		sendErrorResponse(w, "Failed to rotate API key", http.StatusInternalServerError)
+		return
+	}
+
+	key.Key = full
+	sendSuccessResponse(w, key)
+}
+
+// deleteAPIKeyHandler revokes a key immediately, including any previous
+// secret still in its rotation grace period
+func deleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	account, ok := loadOwnedServiceAccount(w, r, userID)
+	if !ok {
+		return
+	}
+
+	result, err := db.APIKeys.DeleteOne(r.Context(), bson.M{"_id": mux.Vars(r)["keyId"], "accountId": account.ID})
+	if err != nil {
+		sendErrorResponse(w, "Failed to revoke API key", http.StatusInternalServerError)
+		return
+	}
+	if result.DeletedCount == 0 {
+		sendErrorResponse(w, "API key not found", http.StatusNotFound)
+		return
+	}
+	sendSuccessResponse(w, map[string]string{"message": "API key revoked"})
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+)
+
+// Attendance event types
+const (
+	AttendanceJoin  = "join"
+	AttendanceLeave = "leave"
+)
+
+// AttendanceEvent is a single join or leave of a participant
+type AttendanceEvent struct {
+	ID        string    `json:"id" bson:"_id"`
+	MeetingID string    `json:"meetingId" bson:"meetingId"`
+	UserID    string    `json:"userId" bson:"userId"`
+	UserName  string    `json:"userName" bson:"userName"`
+	Type      string    `json:"type" bson:"type"`
+	At        time.Time `json:"at" bson:"at"`
+}
+
+// AttendanceRecord summarizes one user's attendance of a meeting
+type AttendanceRecord struct {
+	UserID       string     `json:"userId"`
+	UserName     string     `json:"userName"`
+	FirstJoined  time.Time  `json:"firstJoined"`
+	LastLeft     *time.Time `json:"lastLeft,omitempty"`
+	JoinCount    int        `json:"joinCount"`
+	TotalSeconds int64      `json:"totalSeconds"`
+	Present      bool       `json:"present"`
+}
+
+// recordAttendance stores a join or leave event, counting joins in the
+// user's meeting history; failures are logged only
+func recordAttendance(ctx context.Context, meetingID, userID, userName, eventType string, at time.Time) {
+	ctx = meetingTenantContext(ctx, meetingID)
+	_, err := db.AttendanceEvents.InsertOne(ctx, AttendanceEvent{
+		ID:        uuid.New().String(),
+		MeetingID: meetingID,
+		UserID:    userID,
+		UserName:  userName,
+		Type:      eventType,
+		At:        at,
+	})
+	if err != nil {
+		log.Printf("Error recording %s attendance for %s in meeting %s: %v", eventType, userID, meetingID, err)
+	}
+	if eventType == AttendanceJoin {
+		recordMeetingHistory(ctx, meetingID, userID, at)
+	}
+}
+
+// isHostOrAttendee reports whether the user hosted the meeting or ever joined it
+func isHostOrAttendee(ctx context.Context, meeting Meeting, userID string) bool {
+	if meeting.CreatedBy == userID {
+		return true
+	}
+	count, err := db.AttendanceEvents.CountDocuments(ctx, bson.M{"meetingId": meeting.ID, "userId": userID},
+		options.Count().SetLimit(1))
+	return err == nil && count > 0
+}
+
+// recordMeetingEndAttendance records a leave for everyone still present when a meeting ends
+func recordMeetingEndAttendance(ctx context.Context, meetingID string, endedAt time.Time) {
+	cursor, err := db.Participants.Find(ctx, bson.M{
+		"meetingId": meetingID,
+		"leftAt":    bson.M{"$exists": false},
+	})
+	if err != nil {
+		log.Printf("Error loading participants for attendance: %v", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var present []Participant
+	if err := cursor.All(ctx, &present); err != nil {
+		log.Printf("Error loading participants for attendance: %v", err)
+		return
+	}
+	for _, participant := range present {
+		recordAttendance(ctx, meetingID, participant.UserID, participant.UserName, AttendanceLeave, endedAt)
+	}
+}
+
+// summarizeAttendance folds ordered events into per-user records. Sessions
+// still open are counted up to until.
+func summarizeAttendance(events []AttendanceEvent, until time.Time) []AttendanceRecord {
+	records := map[string]*AttendanceRecord{}
+	openSince := map[string]time.Time{}
+
+	for _, event := range events {
+		record, ok := records[event.UserID]
+		if !ok {
+			record = &AttendanceRecord{UserID: event.UserID, FirstJoined: event.At}
+			records[event.UserID] = record
+		}
+		if event.UserName != "" {
+			record.UserName = event.UserName
+		}
+
+		switch event.Type {
+		case AttendanceJoin:
+			if _, open := openSince[event.UserID]; !open {
+				openSince[event.UserID] = event.At
+			}
+			record.JoinCount++
+		case AttendanceLeave:
+			if since, open := openSince[event.UserID]; open {
+				record.TotalSeconds += int64(event.At.Sub(since).Seconds())
+				delete(openSince, event.UserID)
+			}
+			left := event.At
+			record.LastLeft = &left
+		}
+	}
+
+	for userID, since := range openSince {
+		records[userID].TotalSeconds += int64(until.Sub(since).Seconds())
+		records[userID].Present = true
+	}
+
+	result := make([]AttendanceRecord, 0, len(records))
+	for _, record := range records {
+		result = append(result, *record)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].FirstJoined.Before(result[j].FirstJoined)
+	})
+	return result
+}
+
+// getAttendanceHandler returns the attendance report for the host, as JSON or
+// as a CSV download with ?format=csv
+func getAttendanceHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	meetingID := vars["id"]
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	meeting, err := findMeeting(r.Context(), meetingID)
+	if err != nil {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+	if meeting.CreatedBy != userID {
+		sendCodedError(w, ErrCodeHostOnly, "Only the host can view attendance")
+		return
+	}
+
+	cursor, err := db.AttendanceEvents.Find(
+		r.Context(),
+		bson.M{"meetingId": meeting.ID},
+		options.Find().SetSort(bson.D{{Key: "at", Value: 1}}),
+	)
+	if err != nil {
+		sendErrorResponse(w, "Failed to fetch attendance", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(r.Context())
+
+	var events []AttendanceEvent
+	if err := cursor.All(r.Context(), &events); err != nil {
+		sendErrorResponse(w, "Failed to parse attendance", http.StatusInternalServerError)
+		return
+	}
+
+	until := time.Now()
+	if !meeting.IsActive {
+		until = meeting.UpdatedAt
+	}
+	records := summarizeAttendance(events, until)
+
+	if r.URL.Query().Get("format") == "csv" {
+		writeAttendanceCSV(w, meeting, records)
+		return
+	}
+
+	sendSuccessResponse(w, map[string]interface{}{
+		"meetingId": meeting.ID,
+		"attendees": records,
+	})
+}
+
+func writeAttendanceCSV(w http.ResponseWriter, meeting Meeting, records []AttendanceRecord) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="attendance-%s.csv"`, meeting.ID))
+	w.WriteHeader(http.StatusOK)
+
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"User ID", "Name", "First Joined", "Last Left", "Join Count", "Total Minutes", "Present"})
+	for _, record := range records {
+		lastLeft := ""
+		if record.LastLeft != nil {
+			lastLeft = record.LastLeft.UTC().Format(time.RFC3339)
+		}
+		writer.Write([]string{
+			record.UserID,
+			record.UserName,
+			record.FirstJoined.UTC().Format(time.RFC3339),
+			lastLeft,
+			strconv.Itoa(record.JoinCount),
+			strconv.FormatFloat(float64(record.TotalSeconds)/60, 'f', 1, 64),
+			strconv.FormatBool(record.Present),
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Printf("Error writing attendance CSV: %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"video-meeting-app/db"
+)
+
+// Security event types
+const (
+	SecurityAccountRegistered = "account_registered"
+	SecurityLoginSucceeded    = "login_succeeded"
+	SecurityLoginFailed       = "login_failed"
+	SecurityLoginBlocked      = "login_blocked"
+	SecurityAccountLocked     = "account_locked"
+	SecurityIPLocked          = "ip_locked"
+	SecurityAccountUnlocked   = "account_unlocked"
+	SecurityAccountDisabled   = "account_disabled"
+	SecurityAccountEnabled    = "account_enabled"
+	SecurityPasswordReset     = "password_reset"
+	SecurityPlanChanged       = "plan_changed"
+	SecurityDataExported      = "data_exported"
+	SecurityAccountErased     = "account_erased"
+	// Admin reads are audited too
+	SecurityAdminUsersListed = "admin_users_listed"
+	SecurityAdminUserViewed  = "admin_user_viewed"
+	// Admin actions on meetings and server secrets
+	SecurityMeetingForceEnded = "meeting_force_ended"
+	SecurityParticipantKicked = "participant_kicked"
+	SecuritySigningKeyRotated = "signing_key_rotated"
+	SecurityDataKeyRotated    = "data_key_rotated"
+	SecurityInstanceDrained   = "instance_drained"
+	SecurityCORSOriginAdded   = "cors_origin_added"
+	SecurityCORSOriginRemoved = "cors_origin_removed"
+)
+
+// SecurityEvent is an audit record of a security-relevant action
+type SecurityEvent struct {
+	ID      string                 `json:"id" bson:"_id"`
+	Type    string                 `json:"type" bson:"type"`
+	UserID  string                 `json:"userId,omitempty" bson:"userId,omitempty"`
+	Email   string                 `json:"email,omitempty" bson:"email,omitempty"`
+	IP      string                 `json:"ip,omitempty" bson:"ip,omitempty"`
+	ActorID string                 `json:"actorId,omitempty" bson:"actorId,omitempty"`
+	Details map[string]interface{} `json:"details,omitempty" bson:"details,omitempty"`
+	At      time.Time              `json:"at" bson:"at"`
+}
+
+// recordSecurityEvent logs and stores an audit event; storage failures are logged only
+func recordSecurityEvent(ctx context.Context, event SecurityEvent) {
+	event.ID = uuid.New().String()
+	if event.At.IsZero() {
+		event.At = time.Now()
+	}
+
+	log.Printf("Security event %s: user=%q email=%q ip=%q actor=%q details=%v",
+		event.Type, event.UserID, event.Email, event.IP, event.ActorID, event.Details)
+
+	if _, err := db.SecurityEvents.InsertOne(ctx, event); err != nil {
+		log.Printf("Error recording security event %s: %v", event.Type, err)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // register decoders for image.Decode
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"video-meeting-app/db"
+	"video-meeting-app/storage"
+)
+
+const (
+	MaxAvatarUploadSize = 5 << 20
+	// Larger images are rejected before decoding to bound memory use
+	MaxAvatarSourcePixels = 40_000_000
+	AvatarSize            = 256
+	AvatarJPEGQuality     = 85
+	AvatarCacheMaxAge     = 365 * 24 * time.Hour
+)
+
+// Avatar files are named by content hash, so a URL never changes meaning
+var avatarFilePattern = regexp.MustCompile(`^[0-9a-f]{16}\.jpg$`)
+
+// processAvatar decodes an uploaded image, crops it to a centered square and
+// scales it to AvatarSize, returning it as JPEG
+func processAvatar(data []byte) ([]byte, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("unsupported image format")
+	}
+	if cfg.Width*cfg.Height > MaxAvatarSourcePixels {
+		return nil, fmt.Errorf("image dimensions are too large")
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("image could not be decoded")
+	}
+
+	bounds := src.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	crop := image.Rect(0, 0, side, side).Add(image.Point{
+		X: bounds.Min.X + (bounds.Dx()-side)/2,
+		Y: bounds.Min.Y + (bounds.Dy()-side)/2,
+	})
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scaleSquare(src, crop, AvatarSize), &jpeg.Options{Quality: AvatarJPEGQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// scaleSquare resamples the square crop of src to size x size by averaging
+// the source pixels under each output pixel. Transparent areas become white,
+// since JPEG has no alpha channel.
+func scaleSquare(src image.Image, crop image.Rectangle, size int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	side := crop.Dx()
+
+	for y := 0; y < size; y++ {
+		y0 := crop.Min.Y + y*side/size
+		y1 := max(crop.Min.Y+(y+1)*side/size, y0+1)
+		for x := 0; x < size; x++ {
+			x0 := crop.Min.X + x*side/size
+			x1 := max(crop.Min.X+(x+1)*side/size, x0+1)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			// Colors are alpha-premultiplied, so compositing over white adds
+			// the uncovered fraction to each channel
+			white := 0xffff*n - a
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8((r + white) / n >> 8),
+				G: uint8((g + white) / n >> 8),
+				B: uint8((b + white) / n >> 8),
+				A: 0xff,
+			})
+		}
+	}
+	return dst
+}
+
+// uploadAvatarHandler accepts a multipart "avatar" file, stores the processed
+// image and points the user's avatarUrl at it
+func uploadAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, MaxAvatarUploadSize+1024)
+	file, _, err := r.FormFile("avatar")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			sendErrorResponse(w, "Avatar must be 5 MB or smaller", http.StatusRequestEntityTooLarge)
+		} else {
+			sendErrorResponse(w, "An avatar file is required", http.StatusBadRequest)
+		}
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, MaxAvatarUploadSize+1))
+	if err != nil {
+		sendErrorResponse(w, "Failed to read upload", http.StatusBadRequest)
+		return
+	}
+	if len(data) > MaxAvatarUploadSize {
+		sendErrorResponse(w, "Avatar must be 5 MB or smaller", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	avatar, err := processAvatar(data)
+	if err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var user User
+	if err := db.Users.FindOne(r.Context(), bson.M{"_id": userID}).Decode(&user); err != nil {
+		sendCodedError(w, ErrCodeUserNotFound, "User not found")
+		return
+	}
+
+	sum := sha256.Sum256(avatar)
+	fileName := hex.EncodeToString(sum[:8]) + ".jpg"
+	key := "avatars/" + userID + "/" + fileName
+	if err := blobStore.Put(r.Context(), key, "image/jpeg", avatar); err != nil {
+		log.Printf("Error storing avatar for %s: %v", userID, err)
+		sendErrorResponse(w, "Failed to store avatar", http.StatusInternalServerError)
+		return
+	}
+
+	avatarURL := "/api/avatars/" + userID + "/" + fileName
+	_, err = db.Users.UpdateOne(
+		r.Context(),
+		bson.M{"_id": userID},
+		bson.M{"$set": bson.M{"avatarUrl": avatarURL, "avatarKey": key, "updatedAt": time.Now()}},
+	)
+	if err != nil {
+		log.Printf("Error updating avatar for %s: %v", userID, err)
+		sendErrorResponse(w, "Failed to update avatar", http.StatusInternalServerError)
+		return
+	}
+
+	// The previous image is unreachable once the user points at the new one
+	if user.AvatarKey != "" && user.AvatarKey != key {
+		if err := blobStore.Delete(r.Context(), user.AvatarKey); err != nil {
+			log.Printf("Error deleting old avatar %s: %v", user.AvatarKey, err)
+		}
+	}
+
+	sendSuccessResponse(w, map[string]string{"avatarUrl": avatarURL})
+}
+
+// deleteAvatarHandler removes the user's avatar
+func deleteAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var user User
+	if err := db.Users.FindOne(r.Context(), bson.M{"_id": userID}).Decode(&user); err != nil {
+		sendCodedError(w, ErrCodeUserNotFound, "User not found")
+		return
+	}
+
+	_, err := db.Users.UpdateOne(
+		r.Context(),
+		bson.M{"_id": userID},
+		bson.M{
+			"$unset": bson.M{"avatarUrl": "", "avatarKey": ""},
+			"$set":   bson.M{"updatedAt": time.Now()},
+		},
+	)
+	if err != nil {
+		sendErrorResponse(w, "Failed to remove avatar", http.StatusInternalServerError)
+		return
+	}
+	if user.AvatarKey != "" {
+		if err := blobStore.Delete(r.Context(), user.AvatarKey); err != nil {
+			log.Printf("Error deleting avatar %s: %v", user.AvatarKey, err)
+		}
+	}
+
+	sendSuccessResponse(w, map[string]string{"message": "Avatar removed"})
+}
+
+// serveAvatarHandler serves a stored avatar. URLs are content-addressed, so
+// responses may be cached indefinitely.
+func serveAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !avatarFilePattern.MatchString(vars["file"]) {
+		http.NotFound(w, r)
+		return
+	}
+
+	etag := `"` + vars["file"] + `"`
+	cacheControl := fmt.Sprintf("public, max-age=%d, immutable", int(AvatarCacheMaxAge.Seconds()))
+	if r.Header.Get("If-None-Match") == etag {
+		w.Header().Set("Cache-Control", cacheControl)
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	reader, info, err := blobStore.Get(r.Context(), "avatars/"+vars["userId"]+"/"+vars["file"])
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Error loading avatar: %v", err)
+		}
+		http.NotFound(w, r)
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	io.Copy(w, reader)
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+)
+
+const (
+	MinVideoBitrateKbps = 100
+	MaxVideoBitrateKbps = 20000
+	// sfuBitrateCapInterval is how often the SFU reloads caps and repeats
+	// the REMB enforcing them
+	sfuBitrateCapInterval = 5 * time.Second
+	// sfuUncappedBitrate is sent once as REMB when a cap is lifted
+	sfuUncappedBitrate = 50_000_000
+)
+
+type bandwidthCapRequest struct {
+	MaxVideoBitrateKbps int `json:"maxVideoBitrateKbps"` // 0 lifts the cap
+}
+
+// validVideoBitrateCap reports whether kbps is a cap the API accepts; 0 means none
+func validVideoBitrateCap(kbps int) bool {
+	return kbps == 0 || (kbps >= MinVideoBitrateKbps && kbps <= MaxVideoBitrateKbps)
+}
+
+// effectiveBitrateCap is the lower of a participant's cap and the
+// organization's, in kbps; 0 means neither is set
+func effectiveBitrateCap(participantCap, orgCap int) int {
+	switch {
+	case participantCap == 0:
+		return orgCap
+	case orgCap == 0:
+		return participantCap
+	}
+	return min(participantCap, orgCap)
+}
+
+// orgVideoBitrateCap returns the organization's cap on its meetings'
+// participants, in kbps, or 0
+func orgVideoBitrateCap(ctx context.Context, orgID string) int {
+	if orgID == "" {
+		return 0
+	}
+	var org Organization
+	err := db.Organizations.FindOne(ctx, bson.M{"_id": orgID},
+		options.FindOne().SetProjection(bson.M{"settings.maxVideoBitrateKbps": 1})).Decode(&org)
+	if err != nil {
+		return 0
+	}
+	return org.Settings.MaxVideoBitrateKbps
+}
+
+// meetingBitrateCaps returns the effective cap of every participant of the
+// meeting who has one, by user ID, and the organization's cap for the rest
+func meetingBitrateCaps(ctx context.Context, meeting Meeting) (map[string]int, int, error) {
+	orgCap := orgVideoBitrateCap(ctx, meeting.OrgID)
+	cursor, err := db.Participants.Find(ctx,
+		bson.M{"meetingId": meeting.ID, "maxVideoBitrateKbps": bson.M{"$gt": 0}},
+		options.Find().SetProjection(bson.M{"userId": 1, "maxVideoBitrateKbps": 1}))
+	if err != nil {
+		return nil, orgCap, err
+	}
+	var capped []Participant
+	if err := cursor.All(ctx, &capped); err != nil {
+		return nil, orgCap, err
+	}
+
+	caps := make(map[string]int, len(capped))
+	for _, participant := range capped {
+		caps[participant.UserID] = effectiveBitrateCap(participant.MaxVideoBitrateKbps, orgCap)
+	}
+	return caps, orgCap, nil
+}
+
+// peerBitrateCap returns the effective cap of the participant with a peer ID
+func peerBitrateCap(ctx context.Context, meeting Meeting, peerID string) int {
+	var participant Participant
+	err := db.Participants.FindOne(ctx, bson.M{"meetingId": meeting.ID, "peerId": peerID},
+		options.FindOne().SetProjection(bson.M{"maxVideoBitrateKbps": 1})).Decode(&participant)
+	if err != nil {
+		return orgVideoBitrateCap(ctx, meeting.OrgID)
+	}
+	return effectiveBitrateCap(participant.MaxVideoBitrateKbps, orgVideoBitrateCap(ctx, meeting.OrgID))
+}
+
+// capVideoBandwidth sets the bandwidth of every video section of an SDP to
+// kbps. A description's b= lines limit what its recipient sends, so a peer
+// is capped through the descriptions it receives.
+func capVideoBandwidth(sdp string, kbps int) string {
+	lineEnd := "\r\n"
+	if !strings.Contains(sdp, lineEnd) {
+		lineEnd = "\n"
+	}
+	lines := strings.Split(strings.TrimSuffix(sdp, lineEnd), lineEnd)
+	bandwidth := []string{"b=AS:" + strconv.Itoa(kbps), "b=TIAS:" + strconv.Itoa(kbps*1000)}
+
+	out := make([]string, 0, len(lines)+4)
+	inVideo, pending := false, false
+	for _, line := range lines {
+		if strings.HasPrefix(line, "m=") {
+			if pending {
+				out = append(out, bandwidth...)
+			}
+			inVideo = strings.HasPrefix(line, "m=video")
+			pending = inVideo
+			out = append(out, line)
+			continue
+		}
+		if !inVideo {
+			out = append(out, line)
+			continue
+		}
+		if strings.HasPrefix(line, "b=") {
+			continue
+		}
+		// b= lines come after the section's i= and c= lines
+		if pending && !strings.HasPrefix(line, "i=") && !strings.HasPrefix(line, "c=") {
+			out = append(out, bandwidth...)
+			pending = false
+		}
+		out = append(out, line)
+	}
+	if pending {
+		out = append(out, bandwidth...)
+	}
+	return strings.Join(out, lineEnd) + lineEnd
+}
+
+// updateBandwidthCapHandler lets hosts cap a participant's video bitrate.
+// The cap applies without rejoining: the SFU enforces it within a few
+// seconds, and in mesh meetings on the participant's next negotiation.
+func updateBandwidthCapHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	meeting, err := findMeeting(r.Context(), vars["id"])
+	if err != nil {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+	if !hasPermission(r.Context(), meeting, userID, PermissionLimitBandwidth) {
+		sendCodedError(w, ErrCodePermissionDenied, permissionDeniedMessage(PermissionLimitBandwidth))
+		return
+	}
+
+	var req bandwidthCapRequest
+	if !decodeStrictJSONBody(w, r, &req) {
+		return
+	}
+	if !validVideoBitrateCap(req.MaxVideoBitrateKbps) {
+		sendErrorResponse(w, fmt.Sprintf("maxVideoBitrateKbps must be 0 or between %d and %d",
+			MinVideoBitrateKbps, MaxVideoBitrateKbps), http.StatusBadRequest)
+		return
+	}
+
+	update := bson.M{"$set": bson.M{"maxVideoBitrateKbps": req.MaxVideoBitrateKbps}}
+	if req.MaxVideoBitrateKbps == 0 {
+		update = bson.M{"$unset": bson.M{"maxVideoBitrateKbps": ""}}
+	}
+	targetUserID := vars["userId"]
+	result, err := db.Participants.UpdateOne(r.Context(), bson.M{"meetingId": meeting.ID, "userId": targetUserID}, update)
+	if err != nil {
+		log.Printf("Error updating bandwidth cap: %v", err)
+		sendErrorResponse(w, "Failed to update bandwidth cap", http.StatusInternalServerError)
+		return
+	}
+	if result.MatchedCount == 0 {
+		sendErrorResponse(w, "Participant not found", http.StatusNotFound)
+		return
+	}
+
+	// Clients in mesh meetings apply the cap to their senders right away
+	effective := effectiveBitrateCap(req.MaxVideoBitrateKbps, orgVideoBitrateCap(r.Context(), meeting.OrgID))
+	data := map[string]interface{}{"userId": targetUserID, "maxVideoBitrateKbps": effective}
+	hub.BroadcastToMeeting(meeting.ID, WebSocketMessage{
+		Type:   "bandwidth-limit",
+		Data:   data,
+		UserID: userID,
+	}, nil)
+
+	sendSuccessResponse(w, data)
+}
+
+// enforceBitrateCaps asks capped publishers by REMB to send no more video
+// than their cap, and lifts the limit once from those no longer capped
+func (room *sfuRoom) enforceBitrateCaps(ctx context.Context) {
+	meeting, err := findMeeting(ctx, room.meetingID)
+	if err != nil {
+		return
+	}
+	caps, orgCap, err := meetingBitrateCaps(ctx, meeting)
+	if err != nil {
+		log.Printf("SFU: error loading bitrate caps for meeting %s: %v", room.meetingID, err)
+		return
+	}
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	for client, pc := range room.peers {
+		kbps, ok := caps[client.userID]
+		if !ok {
+			kbps = orgCap
+		}
+		bitrate := int64(kbps) * 1000
+		if kbps == 0 {
+			if !room.capped[client] {
+				continue
+			}
+			delete(room.capped, client)
+			bitrate = sfuUncappedBitrate
+		} else {
+			room.capped[client] = true
+		}
+
+		var ssrcs []uint32
+		for _, receiver := range pc.GetReceivers() {
+			for _, track := range receiver.Tracks() {
+				if track.Kind() == webrtc.RTPCodecTypeVideo {
+					ssrcs = append(ssrcs, uint32(track.SSRC()))
+				}
+			}
+		}
+		if len(ssrcs) > 0 {
+			pc.WriteRTCP([]rtcp.Packet{&rtcp.ReceiverEstimatedMaximumBitrate{Bitrate: float32(bitrate), SSRCs: ssrcs}})
+		}
+	}
+}
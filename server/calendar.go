@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"video-meeting-app/db"
+)
+
+const (
+	DefaultMeetingDuration = 1 * time.Hour
+	icsTimeFormat          = "20060102T150405Z"
+)
+
+// appBaseURL returns the frontend URL used in links sent to users
+func appBaseURL() string {
+	return appConfig.AppURL
+}
+
+// meetingJoinURL returns the frontend link for joining a meeting, preferring
+// the short code when the meeting has one
+func meetingJoinURL(meeting Meeting) string {
+	id := meeting.ID
+	if meeting.Code != "" {
+		id = meeting.Code
+	}
+	return fmt.Sprintf("%s/meeting/%s", appBaseURL(), id)
+}
+
+// canViewMeeting reports whether the user may see a meeting's details
+func canViewMeeting(meeting Meeting, userID string) bool {
+	if !meeting.IsPrivate || meeting.CreatedBy == userID {
+		return true
+	}
+	for _, invitee := range meeting.Invitees {
+		if invitee == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func meetingInviteICSHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	meetingID := vars["id"]
+	userID := getUserIDFromToken(r)
+
+	meeting, err := findMeeting(r.Context(), meetingID)
+	if err != nil || !canViewMeeting(meeting, userID) {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+
+	var organizer User
+	db.Users.FindOne(r.Context(), bson.M{"_id": meeting.CreatedBy}).Decode(&organizer)
+
+	ics := renderMeetingICS(meeting, organizer, time.Now())
+
+	filename := meeting.Code
+	if filename == "" {
+		filename = meeting.ID
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8; method=PUBLISH")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="meeting-%s.ics"`, filename))
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(ics))
+}
+
+// renderMeetingICS renders an RFC 5545 calendar containing a single event for the meeting
+func renderMeetingICS(meeting Meeting, organizer User, now time.Time) string {
+	start := meeting.CreatedAt
+	if meeting.ScheduledFor != nil {
+		start = *meeting.ScheduledFor
+	}
+	end := start.Add(DefaultMeetingDuration)
+	joinURL := meetingJoinURL(meeting)
+
+	description := "Join the meeting: " + joinURL
+	if meeting.Description != "" {
+		description = meeting.Description + "\n\n" + description
+	}
+
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//Video Meeting App//Meeting Invite//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"BEGIN:VEVENT",
+		"UID:" + meeting.ID + "@video-meeting-app",
+		"DTSTAMP:" + now.UTC().Format(icsTimeFormat),
+		"DTSTART:" + start.UTC().Format(icsTimeFormat),
+		"DTEND:" + end.UTC().Format(icsTimeFormat),
+		"SUMMARY:" + escapeICSText(meeting.Title),
+		"DESCRIPTION:" + escapeICSText(description),
+		"URL:" + joinURL,
+		"LOCATION:" + escapeICSText(joinURL),
+	}
+	if organizer.Email != "" {
+		lines = append(lines, fmt.Sprintf("ORGANIZER;CN=%s:mailto:%s", escapeICSParam(organizer.Name), organizer.Email))
+	}
+	lines = append(lines,
+		"STATUS:CONFIRMED",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	)
+
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(foldICSLine(line))
+		b.WriteString("\r\n")
+	}
+	return b.String()
+}
+
+// escapeICSText escapes a TEXT value per RFC 5545 section 3.3.11
+func escapeICSText(value string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	).Replace(value)
+}
+
+// escapeICSParam quotes a parameter value, dropping characters it cannot contain
+func escapeICSParam(value string) string {
+	value = strings.NewReplacer(`"`, "", "\r", "", "\n", " ").Replace(value)
+	return `"` + value + `"`
+}
+
+// foldICSLine splits lines longer than 75 octets, continuing with a leading
+// space, without breaking UTF-8 sequences
+func foldICSLine(line string) string {
+	const limit = 75
+	if len(line) <= limit {
+		return line
+	}
+
+	var b strings.Builder
+	width := 0
+	for _, r := range line {
+		size := len(string(r))
+		if width+size > limit {
+			b.WriteString("\r\n ")
+			width = 1
+		}
+		b.WriteRune(r)
+		width += size
+	}
+	return b.String()
+}
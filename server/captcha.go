@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/config"
+	"video-meeting-app/db"
+)
+
+const CaptchaHTTPTimeout = 10 * time.Second
+
+// CaptchaVerifier checks a CAPTCHA response token from the client
+type CaptchaVerifier interface {
+	Name() string
+	Verify(ctx context.Context, token, remoteIP string) (bool, error)
+}
+
+// captchaVerifier is the configured verifier; nil when CAPTCHAs are off
+var captchaVerifier CaptchaVerifier
+
+var captchaHTTPClient = &http.Client{Timeout: CaptchaHTTPTimeout}
+
+// newCaptchaVerifier returns the configured provider's verifier
+func newCaptchaVerifier(cfg config.CaptchaConfig) (CaptchaVerifier, error) {
+	// hCaptcha, reCAPTCHA and Turnstile share the same siteverify API
+	urls := map[string]string{
+		config.CaptchaProviderHCaptcha:  "https://api.hcaptcha.com/siteverify",
+		config.CaptchaProviderRecaptcha: "https://www.google.com/recaptcha/api/siteverify",
+		config.CaptchaProviderTurnstile: "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+	}
+	verifyURL, ok := urls[cfg.Provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown CAPTCHA provider %q", cfg.Provider)
+	}
+	return &siteverifyVerifier{name: cfg.Provider, url: verifyURL, secret: cfg.SecretKey}, nil
+}
+
+// siteverifyVerifier posts the token to the provider's siteverify endpoint
+type siteverifyVerifier struct {
+	name   string
+	url    string
+	secret string
+}
+
+func (v *siteverifyVerifier) Name() string { return v.name }
+
+func (v *siteverifyVerifier) Verify(ctx context.Context, token, remoteIP string) (bool, error) {
+	form := url.Values{"secret": {v.secret}, "response": {token}}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := captchaHTTPClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("%s responded with status %d", v.name, resp.StatusCode)
+	}
+
+	var result struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, err
+	}
+	return result.Success, nil
+}
+
+// signupCaptchaRequired reports whether a registration from ip needs a
+// CAPTCHA: when the IP has registered enough accounts recently
+func signupCaptchaRequired(ctx context.Context, ip string, now time.Time) bool {
+	cfg := appConfig.Captcha
+	if captchaVerifier == nil {
+		return false
+	}
+	if cfg.Always {
+		return true
+	}
+	count, err := db.SecurityEvents.CountDocuments(ctx, bson.M{
+		"type": SecurityAccountRegistered,
+		"ip":   ip,
+		"at":   bson.M{"$gte": now.Add(-time.Duration(cfg.SignupWindow))},
+	}, options.Count().SetLimit(int64(cfg.SignupsPerIP)))
+	if err != nil {
+		// Ask for one rather than let a database hiccup open the door
+		log.Printf("Error counting signups from %s: %v", ip, err)
+		return true
+	}
+	return count >= int64(cfg.SignupsPerIP)
+}
+
+// loginCaptchaRequired reports whether a login to the account from ip needs
+// a CAPTCHA: when either has failed enough logins recently
+func loginCaptchaRequired(ctx context.Context, email, ip string) bool {
+	cfg := appConfig.Captcha
+	if captchaVerifier == nil {
+		return false
+	}
+	if cfg.Always {
+		return true
+	}
+	count, err := db.LoginAttempts.CountDocuments(ctx, bson.M{
+		"_id":      bson.M{"$in": []string{accountAttemptKey(email), ipAttemptKey(ip)}},
+		"failures": bson.M{"$gte": cfg.LoginFailures},
+	})
+	if err != nil {
+		log.Printf("Error checking failed logins for %s: %v", ip, err)
+		return true
+	}
+	return count > 0
+}
+
+// checkCaptcha verifies the request's CAPTCHA token, writing the error
+// response and returning false when it is missing or wrong
+func checkCaptcha(w http.ResponseWriter, r *http.Request, token string) bool {
+	if token == "" {
+		sendCodedError(w, ErrCodeCaptchaRequired, "Please complete the CAPTCHA")
+		return false
+	}
+	ok, err := captchaVerifier.Verify(r.Context(), token, getClientIP(r))
+	if err != nil {
+		log.Printf("Error verifying CAPTCHA with %s: %v", captchaVerifier.Name(), err)
+		sendCodedError(w, ErrCodeUpstream, "Could not verify the CAPTCHA, please try again")
+		return false
+	}
+	if !ok {
+		sendCodedError(w, ErrCodeCaptchaRequired, "CAPTCHA verification failed, please try again")
+		return false
+	}
+	return true
+}
+
+type captchaSettingsResponse struct {
+	// Empty when CAPTCHAs are off
+	Provider string `json:"provider,omitempty"`
+	SiteKey  string `json:"siteKey,omitempty"`
+}
+
+// getCaptchaSettingsHandler tells the client which CAPTCHA widget to show
+// when registration or login answers CAPTCHA_REQUIRED
+func getCaptchaSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	if captchaVerifier == nil {
+		sendSuccessResponse(w, captchaSettingsResponse{})
+		return
+	}
+	sendSuccessResponse(w, captchaSettingsResponse{Provider: captchaVerifier.Name(), SiteKey: appConfig.Captcha.SiteKey})
+}
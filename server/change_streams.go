@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+)
+
+const ChangeStreamRetryDelay = 5 * time.Second
+
+// errCodeChangeStreamUnsupported is returned by standalone servers, which
+// have no oplog to stream from
+const errCodeChangeStreamUnsupported = 40573
+
+// changeEvent is the part of a change stream event the watchers use
+type changeEvent struct {
+	OperationType     string   `bson:"operationType"`
+	FullDocument      bson.Raw `bson:"fullDocument"`
+	UpdateDescription struct {
+		UpdatedFields bson.M   `bson:"updatedFields"`
+		RemovedFields []string `bson:"removedFields"`
+	} `bson:"updateDescription"`
+}
+
+// onlyTouches reports whether an update changed nothing but the given fields
+func (e changeEvent) onlyTouches(fields map[string]bool) bool {
+	if e.OperationType != "update" {
+		return false
+	}
+	for field := range e.UpdateDescription.UpdatedFields {
+		if !fields[field] {
+			return false
+		}
+	}
+	for _, field := range e.UpdateDescription.RemovedFields {
+		if !fields[field] {
+			return false
+		}
+	}
+	return true
+}
+
+// Bookkeeping fields whose updates clients needn't hear about. Heartbeats
+// touch lastActive for every participant every minute.
+var (
+	participantNoiseFields = map[string]bool{"lastActive": true}
+	meetingNoiseFields     = map[string]bool{"updatedAt": true, "remindersSentAt": true, "joinSeq": true}
+)
+
+// watchChangeStreams relays participant and meeting changes, whichever
+// instance or tool made them, to the clients connected here until ctx is
+// cancelled. Clients of the instance that made a change may hear about it
+// twice; the events carry the whole document, so applying one again is
+// harmless.
+func watchChangeStreams(ctx context.Context) {
+	go watchCollection(ctx, db.Participants.Mongo(), participantNoiseFields, relayParticipantChange)
+	go watchCollection(ctx, db.Meetings.Mongo(), meetingNoiseFields, relayMeetingChange)
+}
+
+func relayParticipantChange(event changeEvent) {
+	var participant Participant
+	if err := bson.Unmarshal(event.FullDocument, &participant); err != nil {
+		log.Printf("Error decoding participant change: %v", err)
+		return
+	}
+	hub.RelayToMeeting(participant.MeetingID, WebSocketMessage{
+		Type:   "participant-updated",
+		Data:   participant,
+		UserID: participant.UserID,
+	}, false)
+}
+
+func relayMeetingChange(event changeEvent) {
+	var meeting Meeting
+	if err := bson.Unmarshal(event.FullDocument, &meeting); err != nil {
+		log.Printf("Error decoding meeting change: %v", err)
+		return
+	}
+	// The change may have come from another instance or tool
+	meetingLookups.Invalidate(context.Background(), meeting.ID)
+
+	if _, ended := event.UpdateDescription.UpdatedFields["isActive"]; ended && !meeting.IsActive {
+		screenShares.ReleaseMeeting(meeting.ID)
+		hub.RelayToMeeting(meeting.ID, WebSocketMessage{
+			Type: "meeting-ended",
+			Data: map[string]interface{}{"endedAt": meeting.UpdatedAt},
+		}, true)
+		return
+	}
+	hub.RelayToMeeting(meeting.ID, WebSocketMessage{
+		Type: "meeting-updated",
+		Data: meeting,
+	}, false)
+}
+
+// watchCollection calls handle for each insert, update or replace in the
+// collection, skipping updates of only the noise fields. It resumes after
+// the last event seen when the stream breaks, and gives up on servers that
+// can't stream changes.
+func watchCollection(ctx context.Context, collection *mongo.Collection, noise map[string]bool, handle func(changeEvent)) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"operationType": bson.M{"$in": []string{"insert", "update", "replace"}}}}},
+	}
+	var resumeToken bson.Raw
+
+	for {
+		opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+		if resumeToken != nil {
+			opts.SetResumeAfter(resumeToken)
+		}
+
+		stream, err := collection.Watch(ctx, pipeline, opts)
+		if err != nil {
+			var cmdErr mongo.CommandError
+			if errors.As(err, &cmdErr) && cmdErr.Code == errCodeChangeStreamUnsupported {
+				log.Printf("Change streams are not supported by this MongoDB deployment; not watching %s", collection.Name())
+				return
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			// The token may have fallen off the oplog; start from now
+			log.Printf("Error watching %s: %v", collection.Name(), err)
+			resumeToken = nil
+		} else {
+			for stream.Next(ctx) {
+				resumeToken = stream.ResumeToken()
+				var event changeEvent
+				if err := stream.Decode(&event); err != nil {
+					log.Printf("Error decoding %s change: %v", collection.Name(), err)
+					continue
+				}
+				if event.FullDocument == nil || event.onlyTouches(noise) {
+					continue
+				}
+				handle(event)
+			}
+			err = stream.Err()
+			stream.Close(context.Background())
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Change stream on %s ended: %v", collection.Name(), err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(ChangeStreamRetryDelay):
+		}
+	}
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+)
+
+const (
+	MaxChatMessageLength    = 2000
+	DefaultChatHistoryLimit = 100
+	MaxChatHistoryLimit     = 500
+)
+
+// handleChatMessage persists a chat message and broadcasts it to the meeting
+func handleChatMessage(ctx context.Context, c *Client, data json.RawMessage) {
+	var req struct {
+		Message       string   `json:"message"`
+		AttachmentIDs []string `json:"attachmentIds"`
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		c.sendError("Invalid chat message")
+		return
+	}
+
+	text := strings.TrimSpace(req.Message)
+	if len(text) > MaxChatMessageLength || (text == "" && len(req.AttachmentIDs) == 0) {
+		c.sendError("Chat message must be between 1 and 2000 characters")
+		return
+	}
+
+	attachments, err := loadMessageAttachments(ctx, c, req.AttachmentIDs)
+	if err != nil {
+		c.sendError(err.Error())
+		return
+	}
+
+	meeting, err := findMeeting(ctx, c.meetingID)
+	if err != nil {
+		c.sendError("Meeting not found")
+		return
+	}
+	if err := filterChatMessage(ctx, meeting, c.userID, c.userName, text); err != nil {
+		c.sendError(err.Error())
+		return
+	}
+
+	message := ChatMessage{
+		ID:          uuid.New().String(),
+		MeetingID:   c.meetingID,
+		UserID:      c.userID,
+		UserName:    c.userName,
+		Message:     text,
+		Attachments: attachments,
+		Timestamp:   time.Now(),
+	}
+
+	if _, err := db.ChatMessages.InsertOne(ctx, message); err != nil {
+		log.Printf("Error saving chat message: %v", err)
+		c.sendError("Failed to send message")
+		return
+	}
+	withAttachmentURLs([]ChatMessage{message}, message.Timestamp)
+
+	hub.BroadcastToMeeting(c.meetingID, WebSocketMessage{
+		Type:   "chat-message",
+		Data:   message,
+		UserID: c.userID,
+	}, nil)
+}
+
+// handleChatClear deletes the meeting's chat history; requires the clear-chat permission
+func handleChatClear(ctx context.Context, c *Client, data json.RawMessage) {
+	if _, ok := requireClientPermission(ctx, c, PermissionClearChat); !ok {
+		return
+	}
+
+	if _, err := db.ChatMessages.DeleteMany(ctx, bson.M{"meetingId": c.meetingID}); err != nil {
+		log.Printf("Error clearing chat: %v", err)
+		c.sendError("Failed to clear chat")
+		return
+	}
+	deleteMeetingAttachments(ctx, c.meetingID)
+
+	hub.BroadcastToMeeting(c.meetingID, WebSocketMessage{
+		Type:   "chat-cleared",
+		Data:   map[string]string{"clearedBy": c.userID},
+		UserID: c.userID,
+	}, nil)
+}
+
+// chatMessageError pairs a failure message with the HTTP status the REST
+// handlers respond with; WebSocket clients only get the message
+type chatMessageError struct {
+	status  int
+	message string
+}
+
+func (e *chatMessageError) Error() string { return e.message }
+
+// findChatMessage loads a message of the meeting that has not been deleted
+func findChatMessage(ctx context.Context, meetingID, messageID string) (ChatMessage, error) {
+	var message ChatMessage
+	err := db.ChatMessages.FindOne(ctx, bson.M{
+		"_id":       messageID,
+		"meetingId": meetingID,
+		"deletedAt": bson.M{"$exists": false},
+	}).Decode(&message)
+	if err == mongo.ErrNoDocuments {
+		return message, &chatMessageError{http.StatusNotFound, "Message not found"}
+	}
+	if err != nil {
+		log.Printf("Error loading chat message %s: %v", messageID, err)
+		return message, &chatMessageError{http.StatusInternalServerError, "Failed to load message"}
+	}
+	return message, nil
+}
+
+// editChatMessage replaces the text of the user's own message, keeping the
+// previous text in its history. The new text goes through the chat filter.
+func editChatMessage(ctx context.Context, meeting Meeting, messageID, userID, text string) (ChatMessage, error) {
+	message, err := findChatMessage(ctx, meeting.ID, messageID)
+	if err != nil {
+		return message, err
+	}
+	if message.UserID != userID {
+		return message, &chatMessageError{http.StatusForbidden, "You can only edit your own messages"}
+	}
+
+	text = strings.TrimSpace(text)
+	if len(text) > MaxChatMessageLength || (text == "" && len(message.Attachments) == 0) {
+		return message, &chatMessageError{http.StatusBadRequest, "Chat message must be between 1 and 2000 characters"}
+	}
+	if text == message.Message {
+		return message, nil
+	}
+	if err := filterChatMessage(ctx, meeting, userID, message.UserName, text); err != nil {
+		return message, err
+	}
+
+	// Text is stored sealed, so the update seals it as ChatMessage's
+	// MarshalBSON would
+	sealed, err := sealText(meeting.ID, text)
+	if err != nil {
+		log.Printf("Error encrypting chat message %s: %v", message.ID, err)
+		return message, &chatMessageError{http.StatusInternalServerError, "Failed to edit message"}
+	}
+	previous, err := sealText(meeting.ID, message.Message)
+	if err != nil {
+		log.Printf("Error encrypting chat message %s: %v", message.ID, err)
+		return message, &chatMessageError{http.StatusInternalServerError, "Failed to edit message"}
+	}
+
+	// Matching on the last edit keeps concurrent edits from losing history
+	now := time.Now()
+	err = db.ChatMessages.FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": message.ID, "editedAt": message.EditedAt, "deletedAt": bson.M{"$exists": false}},
+		bson.M{
+			"$set":  bson.M{"message": sealed, "editedAt": now},
+			"$push": bson.M{"history": ChatEdit{Message: previous, EditedAt: now}},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&message)
+	if err == mongo.ErrNoDocuments {
+		return message, &chatMessageError{http.StatusConflict, "Message was changed or deleted, reload and try again"}
+	}
+	if err != nil {
+		log.Printf("Error editing chat message %s: %v", message.ID, err)
+		return message, &chatMessageError{http.StatusInternalServerError, "Failed to edit message"}
+	}
+	return message, nil
+}
+
+// deleteChatMessage removes a message from the chat. Authors can delete their
+// own messages and moderators anyone's. The document is kept, marked deleted,
+// so the moderation trail survives.
+func deleteChatMessage(ctx context.Context, meeting Meeting, messageID, userID string) (ChatMessage, error) {
+	message, err := findChatMessage(ctx, meeting.ID, messageID)
+	if err != nil {
+		return message, err
+	}
+	if message.UserID != userID && !hasPermission(ctx, meeting, userID, PermissionModerateChat) {
+		return message, &chatMessageError{http.StatusForbidden, "You can only delete your own messages"}
+	}
+
+	now := time.Now()
+	result, err := db.ChatMessages.UpdateOne(
+		ctx,
+		bson.M{"_id": message.ID, "deletedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"deletedAt": now, "deletedBy": userID}},
+	)
+	if err != nil {
+		log.Printf("Error deleting chat message %s: %v", message.ID, err)
+		return message, &chatMessageError{http.StatusInternalServerError, "Failed to delete message"}
+	}
+	if result.MatchedCount == 0 {
+		return message, &chatMessageError{http.StatusNotFound, "Message not found"}
+	}
+	if message.UserID != userID {
+		log.Printf("Chat message %s by %s deleted by moderator %s in meeting %s", message.ID, message.UserID, userID, meeting.ID)
+	}
+
+	message.DeletedAt = &now
+	message.DeletedBy = userID
+	return message, nil
+}
+
+// broadcastChatUpdate tells the meeting a message was edited or deleted.
+// Deleted messages are sent without their content.
+func broadcastChatUpdate(meetingID, action string, message ChatMessage, actorID string) {
+	if action == "deleted" {
+		message.Message = ""
+		message.Attachments = nil
+	}
+	withAttachmentURLs([]ChatMessage{message}, time.Now())
+
+	hub.BroadcastToMeeting(meetingID, WebSocketMessage{
+		Type: "chat-updated",
+		Data: map[string]interface{}{
+			"action":  action,
+			"message": message,
+		},
+		UserID: actorID,
+	}, nil)
+}
+
+// handleChatEdit edits one of the client's own messages
+func handleChatEdit(ctx context.Context, c *Client, data json.RawMessage) {
+	var req struct {
+		MessageID string `json:"messageId"`
+		Message   string `json:"message"`
+	}
+	if err := json.Unmarshal(data, &req); err != nil || req.MessageID == "" {
+		c.sendError("Invalid chat edit")
+		return
+	}
+
+	meeting, err := findMeeting(ctx, c.meetingID)
+	if err != nil {
+		c.sendError("Meeting not found")
+		return
+	}
+	message, err := editChatMessage(ctx, meeting, req.MessageID, c.userID, req.Message)
+	if err != nil {
+		c.sendError(err.Error())
+		return
+	}
+	broadcastChatUpdate(c.meetingID, "edited", message, c.userID)
+}
+
+// handleChatDelete deletes one of the client's messages, or any message for moderators
+func handleChatDelete(ctx context.Context, c *Client, data json.RawMessage) {
+	var req struct {
+		MessageID string `json:"messageId"`
+	}
+	if err := json.Unmarshal(data, &req); err != nil || req.MessageID == "" {
+		c.sendError("Invalid chat delete")
+		return
+	}
+
+	meeting, err := findMeeting(ctx, c.meetingID)
+	if err != nil {
+		c.sendError("Meeting not found")
+		return
+	}
+	message, err := deleteChatMessage(ctx, meeting, req.MessageID, c.userID)
+	if err != nil {
+		c.sendError(err.Error())
+		return
+	}
+	broadcastChatUpdate(c.meetingID, "deleted", message, c.userID)
+}
+
+// sendChatMessageError responds with the status carried by a chat message error
+func sendChatMessageError(w http.ResponseWriter, err error) {
+	var chatErr *chatMessageError
+	if errors.As(err, &chatErr) {
+		sendErrorResponse(w, chatErr.message, chatErr.status)
+		return
+	}
+	sendErrorResponse(w, err.Error(), http.StatusInternalServerError)
+}
+
+func editChatMessageHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Message string `json:"message"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+
+	meeting, err := findMeeting(r.Context(), vars["id"])
+	if err != nil || !canViewMeeting(meeting, userID) {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+
+	message, err := editChatMessage(r.Context(), meeting, vars["messageId"], userID, req.Message)
+	if err != nil {
+		sendChatMessageError(w, err)
+		return
+	}
+	broadcastChatUpdate(meeting.ID, "edited", message, userID)
+
+	withAttachmentURLs([]ChatMessage{message}, time.Now())
+	sendSuccessResponse(w, message)
+}
+
+func deleteChatMessageHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	meeting, err := findMeeting(r.Context(), vars["id"])
+	if err != nil || !canViewMeeting(meeting, userID) {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+
+	message, err := deleteChatMessage(r.Context(), meeting, vars["messageId"], userID)
+	if err != nil {
+		sendChatMessageError(w, err)
+		return
+	}
+	broadcastChatUpdate(meeting.ID, "deleted", message, userID)
+
+	sendSuccessResponse(w, map[string]string{"message": "Message deleted"})
+}
+
+// getChatMessageHistoryHandler returns a message's previous versions, for its
+// author and for moderators. Deleted messages remain visible to moderators.
+func getChatMessageHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	meeting, err := findMeeting(r.Context(), vars["id"])
+	if err != nil || !canViewMeeting(meeting, userID) {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+
+	var message ChatMessage
+	err = db.ChatMessages.FindOne(r.Context(), bson.M{"_id": vars["messageId"], "meetingId": meeting.ID}).Decode(&message)
+	if err != nil {
+		sendErrorResponse(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	moderator := hasPermission(r.Context(), meeting, userID, PermissionModerateChat)
+	if !moderator && (message.UserID != userID || message.DeletedAt != nil) {
+		sendErrorResponse(w, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	history := message.History
+	if history == nil {
+		history = []ChatEdit{}
+	}
+	sendSuccessResponse(w, map[string]interface{}{
+		"messageId": message.ID,
+		"current":   message.Message,
+		"editedAt":  message.EditedAt,
+		"deletedAt": message.DeletedAt,
+		"deletedBy": message.DeletedBy,
+		"history":   history,
+	})
+}
+
+func getChatHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	meetingID := vars["id"]
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	meeting, err := findMeeting(r.Context(), meetingID)
+	if err != nil || !canViewMeeting(meeting, userID) {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+
+	limit := DefaultChatHistoryLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			sendErrorResponse(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(parsed, MaxChatHistoryLimit)
+	}
+
+	// Take the newest messages, then return them oldest first
+	cursor, err := db.ChatMessages.Find(
+		r.Context(),
+		bson.M{"meetingId": meeting.ID, "deletedAt": bson.M{"$exists": false}},
+		options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}}).SetLimit(int64(limit)+1),
+	)
+	if err != nil {
+		sendErrorResponse(w, "Failed to fetch chat history", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(r.Context())
+
+	messages := []ChatMessage{}
+	if err := cursor.All(r.Context(), &messages); err != nil {
+		sendErrorResponse(w, "Failed to parse chat history", http.StatusInternalServerError)
+		return
+	}
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	withAttachmentURLs(messages, time.Now())
+
+	// Unread counts let a reconnecting client badge the chat without
+	// replaying every message
+	marker, err := loadChatReadMarker(r.Context(), meeting.ID, userID)
+	if err != nil {
+		sendErrorResponse(w, "Failed to fetch read marker", http.StatusInternalServerError)
+		return
+	}
+	unread, err := countUnreadChat(r.Context(), meeting.ID, userID, marker)
+	if err != nil {
+		sendErrorResponse(w, "Failed to count unread messages", http.StatusInternalServerError)
+		return
+	}
+
+	sendSuccessResponse(w, map[string]interface{}{
+		"messages":    messages,
+		"hasMore":     hasMore,
+		"unreadCount": unread,
+		"lastRead":    marker,
+	})
+}
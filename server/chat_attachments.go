@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"video-meeting-app/db"
+	"video-meeting-app/storage"
+)
+
+const (
+	MaxAttachmentsPerMessage = 5
+	MaxAttachmentNameLength  = 255
+	// AttachmentURLTTL is how long a signed download URL stays valid
+	AttachmentURLTTL      = 24 * time.Hour
+	AttachmentScanTimeout = 60 * time.Second
+)
+
+// ChatAttachment is a file uploaded to a meeting's chat; the content lives in
+// blob storage under Key
+type ChatAttachment struct {
+	ID          string    `json:"id" bson:"_id"`
+	MeetingID   string    `json:"meetingId" bson:"meetingId"`
+	UploadedBy  string    `json:"uploadedBy" bson:"uploadedBy"`
+	FileName    string    `json:"fileName" bson:"fileName"`
+	ContentType string    `json:"contentType" bson:"contentType"`
+	Size        int64     `json:"size" bson:"size"`
+	Key         string    `json:"-" bson:"key"`
+	CreatedAt   time.Time `json:"createdAt" bson:"createdAt"`
+	// Filled in for the caller when sending or listing
+	URL string `json:"url,omitempty" bson:"-"`
+}
+
+// AttachmentScanner checks uploads for malware before they are stored
+type AttachmentScanner interface {
+	Scan(ctx context.Context, fileName string, data []byte) (clean bool, threat string, err error)
+}
+
+// attachmentScanner is created in main from the chat config
+var attachmentScanner AttachmentScanner = noopScanner{}
+
+func newAttachmentScanner(scanURL string) AttachmentScanner {
+	if scanURL == "" {
+		return noopScanner{}
+	}
+	return &httpScanner{url: scanURL, client: &http.Client{Timeout: AttachmentScanTimeout}}
+}
+
+// noopScanner accepts every file, for deployments without a scanner
+type noopScanner struct{}
+
+func (noopScanner) Scan(ctx context.Context, fileName string, data []byte) (bool, string, error) {
+	return true, "", nil
+}
+
+// httpScanner posts the file as multipart "file" to a scanning service, which
+// answers {"clean": bool, "threat": "..."}
+type httpScanner struct {
+	url    string
+	client *http.Client
+}
+
+func (s *httpScanner) Scan(ctx context.Context, fileName string, data []byte) (bool, string, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	file, err := form.CreateFormFile("file", fileName)
+	if err != nil {
+		return false, "", err
+	}
+	if _, err := file.Write(data); err != nil {
+		return false, "", err
+	}
+	if err := form.Close(); err != nil {
+		return false, "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, &body)
+	if err != nil {
+		return false, "", err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return false, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, "", fmt.Errorf("scanner responded with status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Clean  bool   `json:"clean"`
+		Threat string `json:"threat"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, "", err
+	}
+	return result.Clean, result.Threat, nil
+}
+
+// attachmentToken returns a token granting download of the attachment until
+// expiresAt. The signed payload is prefixed so other signed tokens cannot be
+// passed off as attachment tokens.
+func attachmentToken(attachmentID string, expiresAt time.Time) string {
+	payload := attachmentID + "." + strconv.FormatInt(expiresAt.Unix(), 10)
+	return payload + "." + signValue("attachment."+payload)
+}
+
+// parseAttachmentToken verifies a token's signature and expiry and returns the attachment ID
+func parseAttachmentToken(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errors.New("malformed attachment token")
+	}
+	if !verifySignature("attachment."+parts[0]+"."+parts[1], parts[2]) {
+		return "", errors.New("invalid attachment signature")
+	}
+	expiresAt, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", errors.New("malformed attachment token")
+	}
+	if time.Now().Unix() > expiresAt {
+		return "", errors.New("attachment link has expired")
+	}
+	return parts[0], nil
+}
+
+// attachmentURL returns a signed, expiring download URL for the attachment
+func attachmentURL(attachment ChatAttachment, now time.Time) string {
+	return "/api/meetings/" + attachment.MeetingID + "/chat/attachments/" + attachment.ID +
+		"?token=" + url.QueryEscape(attachmentToken(attachment.ID, now.Add(AttachmentURLTTL)))
+}
+
+// withAttachmentURLs fills in download URLs for the messages' attachments
+func withAttachmentURLs(messages []ChatMessage, now time.Time) {
+	for i := range messages {
+		for j := range messages[i].Attachments {
+			messages[i].Attachments[j].URL = attachmentURL(messages[i].Attachments[j], now)
+		}
+	}
+}
+
+// isAllowedAttachment reports whether the file extension is on the allowlist
+func isAllowedAttachment(fileName string) bool {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	for _, allowed := range appConfig.Chat.AttachmentTypes {
+		if ext == strings.ToLower(allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitizeAttachmentName keeps the base name of an upload, without characters
+// that would break a Content-Disposition header
+func sanitizeAttachmentName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	if len(name) > MaxAttachmentNameLength {
+		ext := filepath.Ext(name)
+		name = name[:MaxAttachmentNameLength-len(ext)] + ext
+	}
+	return strings.TrimSpace(name)
+}
+
+// loadMessageAttachments resolves the attachment IDs sent with a chat message.
+// Only files the sender uploaded to this meeting can be attached.
+func loadMessageAttachments(ctx context.Context, c *Client, ids []string) ([]ChatAttachment, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	if len(ids) > MaxAttachmentsPerMessage {
+		return nil, fmt.Errorf("a message can have at most %d attachments", MaxAttachmentsPerMessage)
+	}
+
+	cursor, err := db.ChatAttachments.Find(ctx, bson.M{
+		"_id":        bson.M{"$in": ids},
+		"meetingId":  c.meetingID,
+		"uploadedBy": c.userID,
+	})
+	if err != nil {
+		return nil, errors.New("failed to load attachments")
+	}
+	var found []ChatAttachment
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, errors.New("failed to load attachments")
+	}
+
+	// Keep the order the sender gave
+	byID := make(map[string]ChatAttachment, len(found))
+	for _, attachment := range found {
+		byID[attachment.ID] = attachment
+	}
+	attachments := make([]ChatAttachment, 0, len(ids))
+	for _, id := range ids {
+		attachment, ok := byID[id]
+		if !ok {
+			return nil, errors.New("attachment not found")
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, nil
+}
+
+// deleteMeetingAttachments removes every attachment uploaded to the meeting's chat
+func deleteMeetingAttachments(ctx context.Context, meetingID string) {
+	cursor, err := db.ChatAttachments.Find(ctx, bson.M{"meetingId": meetingID})
+	if err != nil {
+		log.Printf("Error loading attachments for meeting %s: %v", meetingID, err)
+		return
+	}
+	var attachments []ChatAttachment
+	if err := cursor.All(ctx, &attachments); err != nil {
+		log.Printf("Error loading attachments for meeting %s: %v", meetingID, err)
+		return
+	}
+	for _, attachment := range attachments {
+		if err := blobStore.Delete(ctx, attachment.Key); err != nil {
+			log.Printf("Error deleting attachment %s: %v", attachment.Key, err)
+			continue
+		}
+		if _, err := db.ChatAttachments.DeleteOne(ctx, bson.M{"_id": attachment.ID}); err != nil {
+			log.Printf("Error deleting attachment %s: %v", attachment.ID, err)
+		}
+	}
+}
+
+// uploadChatAttachmentHandler accepts a multipart "file" for the meeting's
+// chat. The returned ID is then sent with a chat message to share it.
+func uploadChatAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	meeting, err := findMeeting(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+	if !meeting.IsActive {
+		sendCodedError(w, ErrCodeMeetingEnded, "Meeting has ended")
+		return
+	}
+	if meeting.CreatedBy != userID && !isParticipant(r.Context(), meeting.ID, userID) {
+		sendErrorResponse(w, "Only participants can share files", http.StatusForbidden)
+		return
+	}
+
+	maxSize := int64(appConfig.Chat.MaxAttachmentMB) << 20
+	tooLargeMessage := fmt.Sprintf("Attachment must be %d MB or smaller", appConfig.Chat.MaxAttachmentMB)
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+1024)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			sendErrorResponse(w, tooLargeMessage, http.StatusRequestEntityTooLarge)
+		} else {
+			sendErrorResponse(w, "A file is required", http.StatusBadRequest)
+		}
+		return
+	}
+	defer file.Close()
+
+	fileName := sanitizeAttachmentName(header.Filename)
+	if fileName == "" || fileName == "." {
+		sendErrorResponse(w, "File name is required", http.StatusBadRequest)
+		return
+	}
+	if !isAllowedAttachment(fileName) {
+		sendErrorResponse(w, "This file type is not allowed", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(file, maxSize+1))
+	if err != nil {
+		sendErrorResponse(w, "Failed to read upload", http.StatusBadRequest)
+		return
+	}
+	if int64(len(data)) > maxSize {
+		sendErrorResponse(w, tooLargeMessage, http.StatusRequestEntityTooLarge)
+		return
+	}
+	if len(data) == 0 {
+		sendErrorResponse(w, "File is empty", http.StatusBadRequest)
+		return
+	}
+
+	// Files are only stored once the scanner has passed them; if it can't be
+	// reached the upload is refused rather than let through unchecked
+	clean, threat, err := attachmentScanner.Scan(r.Context(), fileName, data)
+	if err != nil {
+		log.Printf("Error scanning attachment %q in meeting %s: %v", fileName, meeting.ID, err)
+		sendErrorResponse(w, "File could not be scanned, try again later", http.StatusServiceUnavailable)
+		return
+	}
+	if !clean {
+		log.Printf("Rejected infected attachment %q from %s in meeting %s: %s", fileName, userID, meeting.ID, threat)
+		sendErrorResponse(w, "File failed the virus scan", http.StatusUnprocessableEntity)
+		return
+	}
+
+	// Derive the type from the extension; the client's claim is not trusted
+	contentType := mime.TypeByExtension(strings.ToLower(filepath.Ext(fileName)))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	attachment := ChatAttachment{
+		ID:          uuid.New().String(),
+		MeetingID:   meeting.ID,
+		UploadedBy:  userID,
+		FileName:    fileName,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		CreatedAt:   time.Now(),
+	}
+	attachment.Key = "chat/" + meeting.ID + "/" + attachment.ID
+	if err := blobStore.Put(r.Context(), attachment.Key, contentType, data); err != nil {
+		log.Printf("Error storing attachment for meeting %s: %v", meeting.ID, err)
+		sendErrorResponse(w, "Failed to store attachment", http.StatusInternalServerError)
+		return
+	}
+	if _, err := db.ChatAttachments.InsertOne(r.Context(), attachment); err != nil {
+		log.Printf("Error saving attachment for meeting %s: %v", meeting.ID, err)
+		if err := blobStore.Delete(r.Context(), attachment.Key); err != nil {
+			log.Printf("Error deleting attachment %s: %v", attachment.Key, err)
+		}
+		sendErrorResponse(w, "Failed to save attachment", http.StatusInternalServerError)
+		return
+	}
+
+	attachment.URL = attachmentURL(attachment, attachment.CreatedAt)
+	sendSuccessResponse(w, attachment)
+}
+
+// downloadChatAttachmentHandler serves an attachment. It accepts either a
+// signed ?token= from a download URL, so links work without the session, or
+// a session of the host or an attendee.
+func downloadChatAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if token := r.URL.Query().Get("token"); token != "" {
+		tokenAttachmentID, err := parseAttachmentToken(token)
+		if err != nil || tokenAttachmentID != vars["attachmentId"] {
+			sendErrorResponse(w, "Invalid or expired attachment link", http.StatusForbidden)
+			return
+		}
+	} else {
+		userID := getUserIDFromToken(r)
+		if userID == "" {
+			sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		meeting, err := findMeeting(r.Context(), vars["id"])
+		if err != nil {
+			sendErrorResponse(w, "Attachment not found", http.StatusNotFound)
+			return
+		}
+		if !isHostOrAttendee(r.Context(), meeting, userID) {
+			sendCodedError(w, ErrCodeHostOnly, "Only the host and attendees can download attachments")
+			return
+		}
+	}
+
+	var attachment ChatAttachment
+	err := db.ChatAttachments.FindOne(r.Context(), bson.M{"_id": vars["attachmentId"], "meetingId": vars["id"]}).Decode(&attachment)
+	if err != nil {
+		sendErrorResponse(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+
+	object, info, err := blobStore.Get(r.Context(), attachment.Key)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Error loading attachment %s: %v", attachment.ID, err)
+		}
+		sendErrorResponse(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+	defer object.Close()
+
+	// Only images are shown inline; anything else is always downloaded so an
+	// uploaded HTML or SVG file can't run in the app's origin
+	disposition := "attachment"
+	if strings.HasPrefix(attachment.ContentType, "image/") && attachment.ContentType != "image/svg+xml" {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.FileName}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	http.ServeContent(w, r, "", info.ModTime, object)
+}
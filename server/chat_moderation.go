@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"video-meeting-app/config"
+	"video-meeting-app/db"
+)
+
+const (
+	ModerationHTTPTimeout = 5 * time.Second
+	// Score from the moderation API above which strict meetings block a
+	// message the API did not flag outright
+	strictModerationScore = 0.5
+)
+
+// ModerationVerdict is a policy's decision on a chat message
+type ModerationVerdict struct {
+	Blocked bool
+	Policy  string
+	Reason  string
+}
+
+// ModerationPolicy checks chat text. strictness is the meeting's chat filter,
+// moderate or strict; policies may match more aggressively when strict.
+type ModerationPolicy interface {
+	Name() string
+	Check(ctx context.Context, text, strictness string) (ModerationVerdict, error)
+}
+
+// ChatModerator runs messages through its policies in order until one blocks
+type ChatModerator struct {
+	policies []ModerationPolicy
+}
+
+// chatModerator is created in main from the chat config
+var chatModerator = &ChatModerator{}
+
+func newChatModerator(cfg config.ChatConfig) (*ChatModerator, error) {
+	m := &ChatModerator{}
+	if len(cfg.BlockedWords) > 0 {
+		policy, err := newWordlistPolicy(cfg.BlockedWords)
+		if err != nil {
+			return nil, err
+		}
+		m.policies = append(m.policies, policy)
+	}
+	if len(cfg.BlockedPatterns) > 0 {
+		policy := &patternPolicy{}
+		for _, pattern := range cfg.BlockedPatterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid blocked pattern %q: %w", pattern, err)
+			}
+			policy.patterns = append(policy.patterns, re)
+		}
+		m.policies = append(m.policies, policy)
+	}
+	if cfg.ModerationURL != "" {
+		m.policies = append(m.policies, &apiModerationPolicy{
+			url:    cfg.ModerationURL,
+			apiKey: cfg.ModerationAPIKey,
+			client: &http.Client{Timeout: ModerationHTTPTimeout},
+		})
+	}
+	return m, nil
+}
+
+// Check returns the first blocking verdict. A policy that fails is skipped so
+// an unreachable moderation API doesn't stop the chat.
+func (m *ChatModerator) Check(ctx context.Context, text, strictness string) ModerationVerdict {
+	if strictness == config.ChatFilterOff {
+		return ModerationVerdict{}
+	}
+	for _, policy := range m.policies {
+		verdict, err := policy.Check(ctx, text, strictness)
+		if err != nil {
+			log.Printf("Chat moderation policy %s failed: %v", policy.Name(), err)
+			continue
+		}
+		if verdict.Blocked {
+			verdict.Policy = policy.Name()
+			return verdict
+		}
+	}
+	return ModerationVerdict{}
+}
+
+// wordlistPolicy blocks listed words. Moderate meetings match whole words
+// only; strict meetings also catch words hidden inside others or broken up
+// with punctuation, e.g. "b.a.d".
+type wordlistPolicy struct {
+	words     []string
+	wholeWord []*regexp.Regexp
+}
+
+func newWordlistPolicy(words []string) (*wordlistPolicy, error) {
+	p := &wordlistPolicy{}
+	for _, word := range words {
+		word = strings.ToLower(strings.TrimSpace(word))
+		if word == "" {
+			continue
+		}
+		re, err := regexp.Compile(`(?i)\b` + regexp.QuoteMeta(word) + `\b`)
+		if err != nil {
+			return nil, err
+		}
+		p.words = append(p.words, word)
+		p.wholeWord = append(p.wholeWord, re)
+	}
+	return p, nil
+}
+
+func (p *wordlistPolicy) Name() string { return "wordlist" }
+
+func (p *wordlistPolicy) Check(ctx context.Context, text, strictness string) (ModerationVerdict, error) {
+	for _, re := range p.wholeWord {
+		if re.MatchString(text) {
+			return ModerationVerdict{Blocked: true, Reason: "blocked word"}, nil
+		}
+	}
+	if strictness != config.ChatFilterStrict {
+		return ModerationVerdict{}, nil
+	}
+
+	compact := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, text)
+	for _, word := range p.words {
+		if strings.Contains(compact, word) {
+			return ModerationVerdict{Blocked: true, Reason: "blocked word"}, nil
+		}
+	}
+	return ModerationVerdict{}, nil
+}
+
+// patternPolicy blocks messages matching any configured regular expression
+type patternPolicy struct {
+	patterns []*regexp.Regexp
+}
+
+func (p *patternPolicy) Name() string { return "pattern" }
+
+func (p *patternPolicy) Check(ctx context.Context, text, strictness string) (ModerationVerdict, error) {
+	for _, re := range p.patterns {
+		if re.MatchString(text) {
+			return ModerationVerdict{Blocked: true, Reason: "blocked pattern"}, nil
+		}
+	}
+	return ModerationVerdict{}, nil
+}
+
+// apiModerationPolicy posts {"text": ...} to an external moderation service,
+// which answers {"flagged": bool, "score": 0..1, "categories": [...]}.
+// Flagged messages are always blocked; strict meetings also block high scores.
+type apiModerationPolicy struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+func (p *apiModerationPolicy) Name() string { return "api" }
+
+func (p *apiModerationPolicy) Check(ctx context.Context, text, strictness string) (ModerationVerdict, error) {
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return ModerationVerdict{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return ModerationVerdict{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return ModerationVerdict{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ModerationVerdict{}, fmt.Errorf("moderation API responded with status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Flagged    bool     `json:"flagged"`
+		Score      float64  `json:"score"`
+		Categories []string `json:"categories"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return ModerationVerdict{}, err
+	}
+
+	blocked := result.Flagged || (strictness == config.ChatFilterStrict && result.Score >= strictModerationScore)
+	if !blocked {
+		return ModerationVerdict{}, nil
+	}
+	reason := "flagged by moderation"
+	if len(result.Categories) > 0 {
+		reason += ": " + strings.Join(result.Categories, ", ")
+	}
+	return ModerationVerdict{Blocked: true, Reason: reason}, nil
+}
+
+// chatFilterOf returns the meeting's filter strictness
+func chatFilterOf(meeting Meeting) string {
+	if meeting.ChatFilter != "" {
+		return meeting.ChatFilter
+	}
+	return appConfig.Chat.DefaultFilter
+}
+
+// filterChatMessage checks text against the meeting's chat filter before it is
+// sent. Blocked messages are reported to the host, who isn't filtered.
+func filterChatMessage(ctx context.Context, meeting Meeting, userID, userName, text string) error {
+	if meeting.CreatedBy == userID || text == "" {
+		return nil
+	}
+	verdict := chatModerator.Check(ctx, text, chatFilterOf(meeting))
+	if !verdict.Blocked {
+		return nil
+	}
+
+	log.Printf("Blocked chat message from %s in meeting %s: %s (%s)", userID, meeting.ID, verdict.Reason, verdict.Policy)
+	hub.SendToUserInMeeting(meeting.ID, meeting.CreatedBy, WebSocketMessage{
+		Type: "chat-blocked",
+		Data: map[string]interface{}{
+			"userId":   userID,
+			"userName": userName,
+			"message":  text,
+			"policy":   verdict.Policy,
+			"reason":   verdict.Reason,
+		},
+	})
+	return &chatMessageError{http.StatusUnprocessableEntity, "Message was blocked by the chat filter"}
+}
+
+// setChatFilterHandler sets the meeting's chat filter strictness; host only
+func setChatFilterHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Filter string `json:"filter"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if !config.IsValidChatFilter(req.Filter) {
+		sendErrorResponse(w, "Filter must be off, moderate or strict", http.StatusBadRequest)
+		return
+	}
+
+	meeting, err := findMeeting(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+	if meeting.CreatedBy != userID {
+		sendCodedError(w, ErrCodeHostOnly, "Only the host can change the chat filter")
+		return
+	}
+
+	_, err = db.Meetings.UpdateOne(
+		r.Context(),
+		bson.M{"_id": meeting.ID},
+		bson.M{"$set": bson.M{"chatFilter": req.Filter, "updatedAt": time.Now()}},
+	)
+	if err != nil {
+		log.Printf("Error updating chat filter: %v", err)
+		sendErrorResponse(w, "Failed to update meeting", http.StatusInternalServerError)
+		return
+	}
+	meetingLookups.Invalidate(r.Context(), meeting.ID)
+
+	hub.BroadcastToMeeting(meeting.ID, WebSocketMessage{
+		Type:   "chat-filter-changed",
+		Data:   map[string]string{"chatFilter": req.Filter},
+		UserID: userID,
+	}, nil)
+
+	sendSuccessResponse(w, map[string]string{"id": meeting.ID, "chatFilter": req.Filter})
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+)
+
+// TypingRelayInterval is the least time between relayed "is typing" events
+// from one client; clients repeat them while the user keeps typing
+const TypingRelayInterval = 2 * time.Second
+
+// ChatReadMarker is the newest chat message a user has read in a meeting
+type ChatReadMarker struct {
+	ID                string    `json:"-" bson:"_id"` // meetingId:userId
+	MeetingID         string    `json:"meetingId" bson:"meetingId"`
+	UserID            string    `json:"userId" bson:"userId"`
+	LastReadMessageID string    `json:"lastReadMessageId" bson:"lastReadMessageId"`
+	LastReadAt        time.Time `json:"lastReadAt" bson:"lastReadAt"` // timestamp of that message
+	UpdatedAt         time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+// handleTyping relays that the client started or stopped typing. Starts are
+// throttled per client; stops always go out so indicators clear promptly.
+func handleTyping(ctx context.Context, c *Client, data json.RawMessage) {
+	var req struct {
+		IsTyping bool `json:"isTyping"`
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		c.sendError("Invalid typing event")
+		return
+	}
+
+	// Messages from one client are handled in order on its read loop, so
+	// lastTypingAt needs no lock
+	now := time.Now()
+	if req.IsTyping {
+		if now.Sub(c.lastTypingAt) < TypingRelayInterval {
+			return
+		}
+		c.lastTypingAt = now
+	} else {
+		c.lastTypingAt = time.Time{}
+	}
+
+	hub.BroadcastToMeeting(c.meetingID, WebSocketMessage{
+		Type: "typing",
+		Data: map[string]interface{}{
+			"userId":   c.userID,
+			"userName": c.userName,
+			"isTyping": req.IsTyping,
+		},
+		UserID: c.userID,
+	}, c)
+}
+
+// markChatRead moves the user's read marker up to the message. Markers never
+// move backwards, so late or repeated receipts are harmless.
+func markChatRead(ctx context.Context, meetingID, userID, messageID string) (ChatReadMarker, error) {
+	message, err := findChatMessage(ctx, meetingID, messageID)
+	if err != nil {
+		return ChatReadMarker{}, err
+	}
+
+	var marker ChatReadMarker
+	err = db.ChatReads.FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": meetingID + ":" + userID},
+		bson.M{
+			"$max":         bson.M{"lastReadAt": message.Timestamp},
+			"$set":         bson.M{"updatedAt": time.Now()},
+			"$setOnInsert": bson.M{"meetingId": meetingID, "userId": userID},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&marker)
+	if err != nil {
+		log.Printf("Error updating read marker for %s in meeting %s: %v", userID, meetingID, err)
+		return marker, &chatMessageError{http.StatusInternalServerError, "Failed to update read marker"}
+	}
+
+	// $max can't carry the message ID along, so set it only when this
+	// message is the one the marker now points at
+	if marker.LastReadAt.Equal(message.Timestamp) && marker.LastReadMessageID != message.ID {
+		_, err = db.ChatReads.UpdateOne(
+			ctx,
+			bson.M{"_id": marker.ID, "lastReadAt": message.Timestamp},
+			bson.M{"$set": bson.M{"lastReadMessageId": message.ID}},
+		)
+		if err != nil {
+			log.Printf("Error updating read marker for %s in meeting %s: %v", userID, meetingID, err)
+		}
+		marker.LastReadMessageID = message.ID
+	}
+	return marker, nil
+}
+
+// broadcastChatRead tells the other participants how far the user has read
+func broadcastChatRead(meetingID string, marker ChatReadMarker, exclude *Client) {
+	hub.BroadcastToMeeting(meetingID, WebSocketMessage{
+		Type: "chat-read",
+		Data: map[string]interface{}{
+			"userId":            marker.UserID,
+			"lastReadMessageId": marker.LastReadMessageID,
+			"lastReadAt":        marker.LastReadAt,
+		},
+		UserID: marker.UserID,
+	}, exclude)
+}
+
+// handleChatRead records a read receipt sent over the WebSocket
+func handleChatRead(ctx context.Context, c *Client, data json.RawMessage) {
+	var req struct {
+		MessageID string `json:"messageId"`
+	}
+	if err := json.Unmarshal(data, &req); err != nil || req.MessageID == "" {
+		c.sendError("Invalid read receipt")
+		return
+	}
+
+	marker, err := markChatRead(ctx, c.meetingID, c.userID, req.MessageID)
+	if err != nil {
+		c.sendError(err.Error())
+		return
+	}
+	broadcastChatRead(c.meetingID, marker, c)
+}
+
+// markChatReadHandler records a read receipt over REST, e.g. when the chat is
+// read before the WebSocket reconnects
+func markChatReadHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		MessageID string `json:"messageId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MessageID == "" {
+		sendErrorResponse(w, "messageId is required", http.StatusBadRequest)
+		return
+	}
+
+	meeting, err := findMeeting(r.Context(), mux.Vars(r)["id"])
+	if err != nil || !canViewMeeting(meeting, userID) {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+
+	marker, err := markChatRead(r.Context(), meeting.ID, userID, req.MessageID)
+	if err != nil {
+		sendChatMessageError(w, err)
+		return
+	}
+	broadcastChatRead(meeting.ID, marker, nil)
+
+	sendSuccessResponse(w, marker)
+}
+
+// loadChatReadMarker returns the user's read marker, or nil when they have
+// not read anything in the meeting yet
+func loadChatReadMarker(ctx context.Context, meetingID, userID string) (*ChatReadMarker, error) {
+	var marker ChatReadMarker
+	err := db.ChatReads.FindOne(ctx, bson.M{"_id": meetingID + ":" + userID}).Decode(&marker)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &marker, nil
+}
+
+// countUnreadChat counts messages from others posted after the marker
+func countUnreadChat(ctx context.Context, meetingID, userID string, marker *ChatReadMarker) (int64, error) {
+	filter := bson.M{
+		"meetingId": meetingID,
+		"userId":    bson.M{"$ne": userID},
+		"deletedAt": bson.M{"$exists": false},
+	}
+	if marker != nil {
+		filter["timestamp"] = bson.M{"$gt": marker.LastReadAt}
+	}
+	return db.ChatMessages.CountDocuments(ctx, filter)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"video-meeting-app/db"
+)
+
+// CleanupStats counts what the background cleanup job has done since startup
+type CleanupStats struct {
+	Runs                   int64     `json:"runs"`
+	ParticipantsMarkedLeft int64     `json:"participantsMarkedLeft"`
+	MeetingsDeactivated    int64     `json:"meetingsDeactivated"`
+	MeetingsTimeLimited    int64     `json:"meetingsTimeLimited"`
+	OverflowAdmitted       int64     `json:"overflowAdmitted"`
+	Errors                 int64     `json:"errors"`
+	LastRunAt              time.Time `json:"lastRunAt"`
+}
+
+var (
+	cleanupRuns                   atomic.Int64
+	cleanupParticipantsMarkedLeft atomic.Int64
+	cleanupMeetingsDeactivated    atomic.Int64
+	cleanupMeetingsTimeLimited    atomic.Int64
+	cleanupOverflowAdmitted       atomic.Int64
+	cleanupErrors                 atomic.Int64
+	cleanupLastRun                atomic.Int64 // unix nanoseconds
+)
+
+// getCleanupStats returns a snapshot of the cleanup counters
+func getCleanupStats() CleanupStats {
+	stats := CleanupStats{
+		Runs:                   cleanupRuns.Load(),
+		ParticipantsMarkedLeft: cleanupParticipantsMarkedLeft.Load(),
+		MeetingsDeactivated:    cleanupMeetingsDeactivated.Load(),
+		MeetingsTimeLimited:    cleanupMeetingsTimeLimited.Load(),
+		OverflowAdmitted:       cleanupOverflowAdmitted.Load(),
+		Errors:                 cleanupErrors.Load(),
+	}
+	if last := cleanupLastRun.Load(); last > 0 {
+		stats.LastRunAt = time.Unix(0, last)
+	}
+	return stats
+}
+
+// participantTimeout is how long a participant may go without a heartbeat
+// before being marked left
+func participantTimeout() time.Duration {
+	return time.Duration(appConfig.Meetings.ParticipantTimeout)
+}
+
+// runCleanup removes stale participants, deactivates meetings that have been
+// empty for too long, ends meetings past their time limit and hands free
+// seats to overflow queues. It returns the last error; the steps after a
+// failed one still run.
+func runCleanup(ctx context.Context, now time.Time) error {
+	ctx, span := tracer.Start(ctx, "cleanup.run")
+	defer span.End()
+
+	emptyTimeout := time.Duration(appConfig.Meetings.EmptyMeetingTimeout)
+	cleanupRuns.Add(1)
+	cleanupLastRun.Store(now.UnixNano())
+	var lastErr error
+
+	markedLeft, err := markStaleParticipantsLeft(ctx, now)
+	if err != nil {
+		cleanupErrors.Add(1)
+		lastErr = fmt.Errorf("marking stale participants: %w", err)
+	}
+	cleanupParticipantsMarkedLeft.Add(markedLeft)
+
+	deactivated, err := deactivateEmptyMeetings(ctx, now, emptyTimeout)
+	if err != nil {
+		cleanupErrors.Add(1)
+		lastErr = fmt.Errorf("deactivating empty meetings: %w", err)
+	}
+	cleanupMeetingsDeactivated.Add(deactivated)
+
+	timeLimited, err := endMeetingsPastTimeLimit(ctx, now)
+	if err != nil {
+		cleanupErrors.Add(1)
+		lastErr = fmt.Errorf("ending meetings past their time limit: %w", err)
+	}
+	cleanupMeetingsTimeLimited.Add(timeLimited)
+
+	// Seats freed by stale participants, or held past their admit window,
+	// go to the next users in line
+	admitted, err := promoteOverflowQueues(ctx)
+	if err != nil {
+		cleanupErrors.Add(1)
+		lastErr = fmt.Errorf("admitting from overflow queues: %w", err)
+	}
+	cleanupOverflowAdmitted.Add(admitted)
+
+	if markedLeft > 0 || deactivated > 0 || timeLimited > 0 {
+		log.Printf("Cleanup: marked %d participants left, deactivated %d meetings, ended %d at their time limit in %v",
+			markedLeft, deactivated, timeLimited, time.Since(now))
+	}
+	return lastErr
+}
+
+// markStaleParticipantsLeft flags participants without a heartbeat within
+// the participant timeout as left
+func markStaleParticipantsLeft(ctx context.Context, now time.Time) (int64, error) {
+	cursor, err := db.Participants.Find(ctx, bson.M{
+		"lastActive": bson.M{"$lt": now.Add(-participantTimeout())},
+		"leftAt":     bson.M{"$exists": false},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var stale []Participant
+	if err := cursor.All(ctx, &stale); err != nil {
+		return 0, err
+	}
+
+	var marked int64
+	for _, participant := range stale {
+		// Re-check the heartbeat so a participant refreshed meanwhile is kept
+		result, err := db.Participants.UpdateOne(
+			ctx,
+			bson.M{
+				"_id":        participant.ID,
+				"lastActive": bson.M{"$lt": now.Add(-participantTimeout())},
+				"leftAt":     bson.M{"$exists": false},
+			},
+			bson.M{"$set": bson.M{"leftAt": now}},
+		)
+		if err != nil {
+			return marked, err
+		}
+		if result.ModifiedCount > 0 {
+			recordAttendance(ctx, participant.MeetingID, participant.UserID, participant.UserName, AttendanceLeave, now)
+			seatFreed(ctx, participant.MeetingID)
+			marked++
+		}
+	}
+	return marked, nil
+}
+
+// deactivateEmptyMeetings ends meetings that have had no present
+// participants for at least emptyTimeout
+func deactivateEmptyMeetings(ctx context.Context, now time.Time, emptyTimeout time.Duration) (int64, error) {
+	cutoff := now.Add(-emptyTimeout)
+
+	cursor, err := db.Meetings.Find(ctx, bson.M{
+		"isActive":  true,
+		"updatedAt": bson.M{"$lt": cutoff},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var meetings []Meeting
+	if err := cursor.All(ctx, &meetings); err != nil {
+		return 0, err
+	}
+
+	var deactivated int64
+	for _, meeting := range meetings {
+		// Leave meetings that are scheduled to start soon (or recently) alone
+		if meeting.ScheduledFor != nil && meeting.ScheduledFor.After(cutoff) {
+			continue
+		}
+
+		// A meeting is still in use if anyone is present or left within the window
+		recent, err := db.Participants.CountDocuments(ctx, bson.M{
+			"meetingId": meeting.ID,
+			"$or": []bson.M{
+				{"leftAt": bson.M{"$exists": false}},
+				{"leftAt": bson.M{"$gt": cutoff}},
+			},
+		})
+		if err != nil {
+			return deactivated, err
+		}
+		if recent > 0 {
+			continue
+		}
+
+		// Ended like any other meeting, so lingering clients are disconnected
+		// and subscribers hear of it
+		ended, err := finishMeeting(ctx, meeting, "", map[string]interface{}{
+			"endedAt": now,
+			"reason":  "empty",
+		}, now)
+		if err != nil {
+			return deactivated, err
+		}
+		if ended {
+			deactivated++
+		}
+	}
+
+	return deactivated, nil
+}
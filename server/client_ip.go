@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Clients behind a load balancer reach the server from the balancer's
+// address, which passes theirs on in X-Forwarded-For. Anyone can send that
+// header, so it is only believed from the configured trusted proxies, and
+// only as far as the proxies appended to it: the client is the rightmost hop
+// that is not a trusted proxy itself.
+
+// trustedProxies is parsed from server.trustedProxies in main
+var trustedProxies []netip.Prefix
+
+// parseTrustedProxies parses CIDRs, and bare addresses as single-address
+// ranges; config validation has rejected anything else
+func parseTrustedProxies(entries []string) []netip.Prefix {
+	normalized, err := normalizeCIDRs(entries)
+	if err != nil {
+		return nil
+	}
+	prefixes := make([]netip.Prefix, 0, len(normalized))
+	for _, cidr := range normalized {
+		prefixes = append(prefixes, netip.MustParsePrefix(cidr))
+	}
+	return prefixes
+}
+
+func isTrustedProxy(addr netip.Addr) bool {
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHostAddr parses an address that may carry a port
+func parseHostAddr(value string) (netip.Addr, bool) {
+	value = strings.TrimSpace(value)
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// clientAddr returns the request's client address: the connection's peer,
+// or when that is a trusted proxy, the client it forwarded for
+func clientAddr(r *http.Request) (netip.Addr, bool) {
+	peer, ok := parseHostAddr(r.RemoteAddr)
+	if !ok || !isTrustedProxy(peer) {
+		return peer, ok
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	if len(hops) == 0 {
+		if realIP, ok := parseHostAddr(r.Header.Get("X-Real-Ip")); ok {
+			return realIP, true
+		}
+		return peer, true
+	}
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := parseHostAddr(hops[i])
+		if !ok {
+			// Whatever is left of a hop the proxies didn't write is the
+			// client's to make up
+			break
+		}
+		client = hop
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+	return client, true
+}
+
+// getClientIP returns the request's client address as a string, for logs,
+// security events and per-address limits
+func getClientIP(r *http.Request) string {
+	if addr, ok := clientAddr(r); ok {
+		return addr.String()
+	}
+	return r.RemoteAddr
+}
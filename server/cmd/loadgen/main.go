@@ -0,0 +1,696 @@
+// Command loadgen puts a server under meeting load to validate hub and SFU
+// changes before a release. It creates meetings and connects synthetic
+// participants to them over WebSocket. Each participant joins, chats and
+// exchanges signaling messages with the others, and periodically leaves and
+// rejoins. At the end it reports latency percentiles per operation.
+//
+// The meetings are created by the account in LOADGEN_EMAIL and
+// LOADGEN_PASSWORD, or the one whose session token is in LOADGEN_SESSION.
+// With -accounts set, participants use that many freshly registered
+// accounts; otherwise they all use the host's. The server's rate limits
+// apply to the generator like any other client, so raise them on the
+// server under test.
+//
+// Latencies of chat and signaling are measured from sending a message to
+// each recipient receiving it, which is exact since every participant runs
+// in this process.
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	mathrand "math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// SessionCookieName must match the server's CookieName
+	SessionCookieName = "session_token"
+	RequestTimeout    = 30 * time.Second
+	// Marks the chat messages and offers the generator sends
+	loadgenTag = "loadgen"
+)
+
+// Operations whose latency is reported
+const (
+	OpJoin    = "join"
+	OpConnect = "connect"
+	OpChat    = "chat"
+	OpSignal  = "signal"
+	OpLeave   = "leave"
+)
+
+// response is the server's response envelope
+type response struct {
+	Success bool            `json:"success"`
+	Data    json.RawMessage `json:"data"`
+	Message string          `json:"message"`
+	Error   string          `json:"error"`
+	Code    string          `json:"code"`
+}
+
+// api calls the server's REST API
+type api struct {
+	server string
+	origin string
+	http   *http.Client
+}
+
+// do sends a request with the session, returning the response data and the
+// session cookie the server set, if any
+func (a *api) do(session, method, path string, body interface{}) (json.RawMessage, string, error) {
+	var payload io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, "", err
+		}
+		payload = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, a.server+path, payload)
+	if err != nil {
+		return nil, "", err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Origin", a.origin)
+	if session != "" {
+		req.AddCookie(&http.Cookie{Name: SessionCookieName, Value: session})
+	}
+
+	resp, err := a.http.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	var envelope response
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return nil, "", fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	if !envelope.Success {
+		message := envelope.Error
+		if message == "" {
+			message = envelope.Message
+		}
+		if envelope.Code != "" {
+			message += " (" + envelope.Code + ")"
+		}
+		return nil, "", fmt.Errorf("%s %s: %s", method, path, message)
+	}
+	var newSession string
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == SessionCookieName {
+			newSession = cookie.Value
+		}
+	}
+	return envelope.Data, newSession, nil
+}
+
+// signIn returns the host's session
+func (a *api) signIn() (string, error) {
+	if session := os.Getenv("LOADGEN_SESSION"); session != "" {
+		return session, nil
+	}
+	email, password := os.Getenv("LOADGEN_EMAIL"), os.Getenv("LOADGEN_PASSWORD")
+	if email == "" || password == "" {
+		return "", errors.New("set LOADGEN_SESSION, or LOADGEN_EMAIL and LOADGEN_PASSWORD")
+	}
+	_, session, err := a.do("", "POST", "/api/auth/login", map[string]string{"email": email, "password": password})
+	if err == nil && session == "" {
+		err = errors.New("the server did not return a session")
+	}
+	return session, err
+}
+
+// registerAccounts creates n participant accounts and returns their sessions
+func (a *api) registerAccounts(n int) ([]string, error) {
+	run := randomHex(4)
+	sessions := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		_, session, err := a.do("", "POST", "/api/auth/register", map[string]string{
+			"name":     fmt.Sprintf("Load %d", i+1),
+			"email":    fmt.Sprintf("loadgen-%s-%d@example.com", run, i+1),
+			"password": "Lg-" + randomHex(12),
+		})
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+// createMeetings creates n meetings for perMeeting participants each
+func (a *api) createMeetings(session string, n, perMeeting int) ([]string, error) {
+	ids := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		data, _, err := a.do(session, "POST", "/api/meetings", map[string]interface{}{
+			"title":           fmt.Sprintf("Load test %d", i+1),
+			"maxParticipants": perMeeting,
+		})
+		if err != nil {
+			return nil, err
+		}
+		var meeting struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(data, &meeting); err != nil {
+			return nil, err
+		}
+		ids = append(ids, meeting.ID)
+	}
+	return ids, nil
+}
+
+// recorder collects latency samples and errors per operation
+type recorder struct {
+	mu      sync.Mutex
+	samples map[string][]time.Duration
+	errors  map[string]int
+	// Last error seen per operation, to show why
+	lastErr map[string]string
+}
+
+func newRecorder() *recorder {
+	return &recorder{
+		samples: make(map[string][]time.Duration),
+		errors:  make(map[string]int),
+		lastErr: make(map[string]string),
+	}
+}
+
+func (r *recorder) observe(op string, latency time.Duration) {
+	r.mu.Lock()
+	r.samples[op] = append(r.samples[op], latency)
+	r.mu.Unlock()
+}
+
+func (r *recorder) fail(op string, err error) {
+	r.mu.Lock()
+	r.errors[op]++
+	r.lastErr[op] = err.Error()
+	r.mu.Unlock()
+}
+
+// report prints each operation's count, errors and latency percentiles
+func (r *recorder) report(w io.Writer, elapsed time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ops := make([]string, 0, len(r.samples))
+	seen := make(map[string]bool)
+	for op := range r.samples {
+		ops = append(ops, op)
+		seen[op] = true
+	}
+	for op := range r.errors {
+		if !seen[op] {
+			ops = append(ops, op)
+		}
+	}
+	sort.Strings(ops)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "OP\tCOUNT\tERRORS\tRATE/S\tP50\tP90\tP99\tMAX\t")
+	for _, op := range ops {
+		samples := r.samples[op]
+		sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f\t%s\t%s\t%s\t%s\t\n", op, len(samples), r.errors[op],
+			float64(len(samples))/elapsed.Seconds(),
+			percentile(samples, 50), percentile(samples, 90), percentile(samples, 99), percentile(samples, 100))
+	}
+	tw.Flush()
+	for _, op := range ops {
+		if r.lastErr[op] != "" {
+			fmt.Fprintf(w, "last %s error: %s\n", op, r.lastErr[op])
+		}
+	}
+}
+
+// percentile returns the p-th percentile of sorted samples
+func percentile(sorted []time.Duration, p int) string {
+	if len(sorted) == 0 {
+		return "-"
+	}
+	i := (len(sorted)*p+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i].Round(100 * time.Microsecond).String()
+}
+
+// peerDirectory lists the connected participants of each meeting, for
+// picking signaling targets
+type peerDirectory struct {
+	mu    sync.Mutex
+	peers map[string][]string // meetingId -> peer IDs
+}
+
+func (d *peerDirectory) add(meetingID, peerID string) {
+	d.mu.Lock()
+	d.peers[meetingID] = append(d.peers[meetingID], peerID)
+	d.mu.Unlock()
+}
+
+func (d *peerDirectory) remove(meetingID, peerID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	peers := d.peers[meetingID]
+	for i, id := range peers {
+		if id == peerID {
+			d.peers[meetingID] = append(peers[:i], peers[i+1:]...)
+			return
+		}
+	}
+}
+
+// pick returns a random peer of the meeting other than self
+func (d *peerDirectory) pick(meetingID, self string) (string, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	peers := d.peers[meetingID]
+	if len(peers) < 2 {
+		return "", false
+	}
+	for {
+		if id := peers[mathrand.Intn(len(peers))]; id != self {
+			return id, true
+		}
+	}
+}
+
+// settings are the load's shape, from the flags
+type settings struct {
+	chatInterval   time.Duration
+	signalInterval time.Duration
+	churnInterval  time.Duration
+}
+
+// participant is one synthetic client
+type participant struct {
+	n         int
+	session   string
+	meetingID string
+	api       *api
+	dialer    *websocket.Dialer
+	wsURL     string
+	rec       *recorder
+	peers     *peerDirectory
+	settings  settings
+	connected *atomic.Int64
+}
+
+// run joins the meeting and generates traffic, rejoining after each churn,
+// until ctx is cancelled
+func (p *participant) run(ctx context.Context) {
+	for ctx.Err() == nil {
+		if err := p.stay(ctx); err != nil && ctx.Err() == nil {
+			// Back off before retrying a failed join or connection
+			sleep(ctx, time.Second+jitter(time.Second))
+		}
+	}
+}
+
+// stay is one stay in the meeting: join, connect, generate traffic and
+// leave, either at ctx's end or after a churn interval
+func (p *participant) stay(ctx context.Context) error {
+	peerID := fmt.Sprintf("loadgen-%d-%s", p.n, randomHex(4))
+	path := "/api/meetings/" + url.PathEscape(p.meetingID)
+
+	started := time.Now()
+	if _, _, err := p.api.do(p.session, "POST", path+"/join", map[string]string{
+		"userName": fmt.Sprintf("Load %d", p.n),
+		"peerId":   peerID,
+	}); err != nil {
+		p.rec.fail(OpJoin, err)
+		return err
+	}
+	p.rec.observe(OpJoin, time.Since(started))
+
+	header := http.Header{}
+	header.Set("Origin", p.api.origin)
+	header.Set("Cookie", (&http.Cookie{Name: SessionCookieName, Value: p.session}).String())
+	started = time.Now()
+	conn, resp, err := p.dialer.DialContext(ctx, p.wsURL+"/api/ws/"+url.PathEscape(p.meetingID)+"?peerId="+url.QueryEscape(peerID), header)
+	if err != nil {
+		if resp != nil {
+			err = fmt.Errorf("%w (%s)", err, resp.Status)
+		}
+		p.rec.fail(OpConnect, err)
+		return err
+	}
+	p.rec.observe(OpConnect, time.Since(started))
+	p.connected.Add(1)
+	p.peers.add(p.meetingID, peerID)
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		p.read(conn)
+	}()
+
+	err = p.generate(ctx, conn, peerID, closed)
+
+	p.peers.remove(p.meetingID, peerID)
+	p.connected.Add(-1)
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	conn.Close()
+	<-closed
+
+	// Leave as a user would, unless the whole run is over
+	if ctx.Err() == nil {
+		started = time.Now()
+		if _, _, err := p.api.do(p.session, "DELETE", path+"/participants/me", nil); err != nil {
+			p.rec.fail(OpLeave, err)
+		} else {
+			p.rec.observe(OpLeave, time.Since(started))
+		}
+	}
+	return err
+}
+
+// generate sends chat and signaling messages until ctx ends, the churn
+// interval passes or the connection closes
+func (p *participant) generate(ctx context.Context, conn *websocket.Conn, peerID string, closed <-chan struct{}) error {
+	// Random phases keep the participants from sending in lockstep
+	chat := time.NewTimer(jitter(p.settings.chatInterval))
+	defer chat.Stop()
+	offer := time.NewTimer(jitter(p.settings.signalInterval))
+	defer offer.Stop()
+	var churn <-chan time.Time
+	if p.settings.churnInterval > 0 {
+		timer := time.NewTimer(p.settings.churnInterval/2 + jitter(p.settings.churnInterval))
+		defer timer.Stop()
+		churn = timer.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-churn:
+			return nil
+		case <-closed:
+			err := errors.New("connection closed by the server")
+			p.rec.fail(OpConnect, err)
+			return err
+
+		case <-chat.C:
+			chat.Reset(p.settings.chatInterval)
+			err := p.send(conn, "chat-message", map[string]string{
+				"message": fmt.Sprintf("%s %d", loadgenTag, time.Now().UnixNano()),
+			})
+			if err != nil {
+				p.rec.fail(OpChat, err)
+			}
+
+		case <-offer.C:
+			offer.Reset(p.settings.signalInterval)
+			to, ok := p.peers.pick(p.meetingID, peerID)
+			if !ok {
+				continue
+			}
+			// The offer's origin line carries when it was sent
+			sdp := fmt.Sprintf("v=0\r\no=%s %d 0 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\n", loadgenTag, time.Now().UnixNano())
+			err := p.send(conn, "signal", map[string]interface{}{
+				"type":     "offer",
+				"toPeerId": to,
+				"offer":    map[string]string{"type": "offer", "sdp": sdp},
+			})
+			if err != nil {
+				p.rec.fail(OpSignal, err)
+			}
+		}
+	}
+}
+
+// send writes a client message; only generate writes, so no lock is needed
+func (p *participant) send(conn *websocket.Conn, messageType string, data interface{}) error {
+	conn.SetWriteDeadline(time.Now().Add(RequestTimeout))
+	return conn.WriteJSON(map[string]interface{}{"type": messageType, "data": data})
+}
+
+// read records the latency of the generator's messages the participant
+// receives, until the connection closes
+func (p *participant) read(conn *websocket.Conn) {
+	for {
+		_, frame, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		now := time.Now()
+		var message struct {
+			Type string          `json:"type"`
+			Data json.RawMessage `json:"data"`
+		}
+		if json.Unmarshal(frame, &message) != nil {
+			continue
+		}
+
+		switch message.Type {
+		case "chat-message":
+			var chat struct {
+				Message string `json:"message"`
+			}
+			if json.Unmarshal(message.Data, &chat) == nil {
+				if sent, ok := sentAt(chat.Message, loadgenTag+" "); ok {
+					p.rec.observe(OpChat, now.Sub(sent))
+				}
+			}
+		case "signal":
+			var relayed struct {
+				Offer *struct {
+					SDP string `json:"sdp"`
+				} `json:"offer"`
+			}
+			if json.Unmarshal(message.Data, &relayed) == nil && relayed.Offer != nil {
+				if sent, ok := sentAt(relayed.Offer.SDP, "o="+loadgenTag+" "); ok {
+					p.rec.observe(OpSignal, now.Sub(sent))
+				}
+			}
+		case "error":
+			var reply struct {
+				Error string `json:"error"`
+			}
+			json.Unmarshal(message.Data, &reply)
+			p.rec.fail("server-error", errors.New(reply.Error))
+		}
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage: loadgen [flags]
+
+Connects synthetic participants to new meetings on a server and reports
+latency percentiles of joins, connections, chat and signaling.
+
+flags:`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	server := flag.String("server", envOr("LOADGEN_SERVER", "http://localhost:8080"), "server base URL")
+	origin := flag.String("origin", envOr("LOADGEN_ORIGIN", "http://localhost:5173"), "Origin header; must be an allowed CORS origin")
+	clients := flag.Int("clients", 1000, "synthetic participants")
+	perMeeting := flag.Int("per-meeting", 10, "participants per meeting")
+	accounts := flag.Int("accounts", 0, "accounts to register for the participants; 0 uses the host's")
+	duration := flag.Duration("duration", 2*time.Minute, "how long to generate load, after the ramp-up")
+	ramp := flag.Duration("ramp", 30*time.Second, "spread the participants' first joins over this long")
+	chatInterval := flag.Duration("chat-interval", 10*time.Second, "how often each participant chats")
+	signalInterval := flag.Duration("signal-interval", 2*time.Second, "how often each participant sends an offer to another")
+	churnInterval := flag.Duration("churn-interval", time.Minute, "how long participants stay before rejoining, on average; 0 disables churn")
+	progress := flag.Duration("progress", 10*time.Second, "how often to print progress")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 0 || *clients < 1 || *perMeeting < 1 || *accounts < 0 ||
+		*chatInterval <= 0 || *signalInterval <= 0 || *churnInterval < 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	wsURL, err := websocketURL(*server)
+	if err != nil {
+		fatal(err)
+	}
+	a := &api{server: strings.TrimSuffix(*server, "/"), origin: *origin, http: &http.Client{
+		Timeout:   RequestTimeout,
+		Transport: &http.Transport{MaxIdleConnsPerHost: 256},
+	}}
+
+	host, err := a.signIn()
+	if err != nil {
+		fatal(err)
+	}
+	sessions := []string{host}
+	if *accounts > 0 {
+		fmt.Printf("Registering %d accounts\n", *accounts)
+		if sessions, err = a.registerAccounts(*accounts); err != nil {
+			fatal(err)
+		}
+	}
+	meetings := (*clients + *perMeeting - 1) / *perMeeting
+	fmt.Printf("Creating %d meetings\n", meetings)
+	meetingIDs, err := a.createMeetings(host, meetings, *perMeeting)
+	if err != nil {
+		fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, *ramp+*duration)
+	defer cancel()
+
+	rec := newRecorder()
+	peers := &peerDirectory{peers: make(map[string][]string)}
+	var connected atomic.Int64
+	dialer := &websocket.Dialer{HandshakeTimeout: RequestTimeout}
+	shape := settings{chatInterval: *chatInterval, signalInterval: *signalInterval, churnInterval: *churnInterval}
+
+	fmt.Printf("Connecting %d participants over %s, then running for %s\n", *clients, *ramp, *duration)
+	started := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < *clients; i++ {
+		p := &participant{
+			n:         i + 1,
+			session:   sessions[i%len(sessions)],
+			meetingID: meetingIDs[i / *perMeeting],
+			api:       a,
+			dialer:    dialer,
+			wsURL:     wsURL,
+			rec:       rec,
+			peers:     peers,
+			settings:  shape,
+			connected: &connected,
+		}
+		delay := time.Duration(int64(*ramp) * int64(i) / int64(*clients))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if sleep(ctx, delay) {
+				p.run(ctx)
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(*progress)
+	defer ticker.Stop()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+wait:
+	for {
+		select {
+		case <-done:
+			break wait
+		case <-ticker.C:
+			fmt.Printf("%s: %d connected\n", time.Since(started).Round(time.Second), connected.Load())
+		}
+	}
+	elapsed := time.Since(started)
+
+	fmt.Println()
+	rec.report(os.Stdout, elapsed)
+
+	// The meetings are left behind otherwise
+	for _, id := range meetingIDs {
+		if _, _, err := a.do(host, "POST", "/api/meetings/"+url.PathEscape(id)+"/end", nil); err != nil {
+			fmt.Fprintln(os.Stderr, "loadgen:", err)
+		}
+	}
+}
+
+// sentAt reads the send time the generator put after prefix in text
+func sentAt(text, prefix string) (time.Time, bool) {
+	i := strings.Index(text, prefix)
+	if i < 0 {
+		return time.Time{}, false
+	}
+	fields := strings.Fields(text[i+len(prefix):])
+	if len(fields) == 0 {
+		return time.Time{}, false
+	}
+	nanos, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nanos), true
+}
+
+// websocketURL turns the server's base URL into its WebSocket one
+func websocketURL(server string) (string, error) {
+	parsed, err := url.Parse(strings.TrimSuffix(server, "/"))
+	if err != nil {
+		return "", err
+	}
+	switch parsed.Scheme {
+	case "http":
+		parsed.Scheme = "ws"
+	case "https":
+		parsed.Scheme = "wss"
+	default:
+		return "", fmt.Errorf("server %q must be an http or https URL", server)
+	}
+	return parsed.String(), nil
+}
+
+// sleep waits for d, reporting false if ctx ended first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// jitter returns a random duration up to d
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(mathrand.Int63n(int64(d)))
+}
+
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "loadgen:", err)
+	os.Exit(1)
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,249 @@
+// Command meetctl operates a running server through its admin API: it lists
+// active meetings, force-ends meetings, kicks participants, shows hub
+// statistics and rotates the link signing key.
+//
+// It signs in with MEETCTL_EMAIL and MEETCTL_PASSWORD, or reuses the session
+// token in MEETCTL_SESSION. The account needs an admin role allowing the
+// command.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// SessionCookieName must match the server's CookieName
+	SessionCookieName = "session_token"
+	RequestTimeout    = 30 * time.Second
+)
+
+// response is the server's response envelope
+type response struct {
+	Success bool            `json:"success"`
+	Data    json.RawMessage `json:"data"`
+	Message string          `json:"message"`
+	Error   string          `json:"error"`
+	Code    string          `json:"code"`
+}
+
+// client calls the admin API with a session
+type client struct {
+	server  string
+	session string
+	http    *http.Client
+}
+
+func (c *client) do(method, path string, body interface{}) (json.RawMessage, error) {
+	var payload io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		payload = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.server+path, payload)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.session != "" {
+		req.AddCookie(&http.Cookie{Name: SessionCookieName, Value: c.session})
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var envelope response
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return nil, fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	if !envelope.Success {
+		message := envelope.Error
+		if message == "" {
+			message = envelope.Message
+		}
+		if envelope.Code != "" {
+			message += " (" + envelope.Code + ")"
+		}
+		return nil, fmt.Errorf("%s %s: %s", method, path, message)
+	}
+	if path == "/api/auth/login" {
+		for _, cookie := range resp.Cookies() {
+			if cookie.Name == SessionCookieName {
+				c.session = cookie.Value
+			}
+		}
+	}
+	return envelope.Data, nil
+}
+
+// signIn makes sure the client has a session
+func (c *client) signIn() error {
+	if c.session = os.Getenv("MEETCTL_SESSION"); c.session != "" {
+		return nil
+	}
+	email, password := os.Getenv("MEETCTL_EMAIL"), os.Getenv("MEETCTL_PASSWORD")
+	if email == "" || password == "" {
+		return errors.New("set MEETCTL_SESSION, or MEETCTL_EMAIL and MEETCTL_PASSWORD")
+	}
+	if _, err := c.do("POST", "/api/auth/login", map[string]string{"email": email, "password": password}); err != nil {
+		return err
+	}
+	if c.session == "" {
+		return errors.New("the server did not return a session")
+	}
+	return nil
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage: meetctl [-server URL] [-json] <command> [arguments]
+
+commands:
+  meetings [-ended] [-limit N]     list active (or ended) meetings
+  end <meetingId>                  end a meeting for everyone
+  kick <meetingId> <userId>        remove a participant from a meeting
+  stats                            show hub connection statistics
+  rotate-signing-key               replace the key that signs links`)
+}
+
+func main() {
+	server := flag.String("server", envOr("MEETCTL_SERVER", "http://localhost:8080"), "server base URL")
+	raw := flag.Bool("json", false, "print the raw JSON response")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	c := &client{server: *server, http: &http.Client{Timeout: RequestTimeout}}
+	if err := c.signIn(); err != nil {
+		fmt.Fprintln(os.Stderr, "meetctl:", err)
+		os.Exit(1)
+	}
+
+	data, print, err := run(c, flag.Arg(0), flag.Args()[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "meetctl:", err)
+		if errors.Is(err, errUsage) {
+			usage()
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+	if *raw || print == nil {
+		var out bytes.Buffer
+		json.Indent(&out, data, "", "  ")
+		fmt.Println(out.String())
+		return
+	}
+	if err := print(data); err != nil {
+		fmt.Fprintln(os.Stderr, "meetctl:", err)
+		os.Exit(1)
+	}
+}
+
+var errUsage = errors.New("invalid arguments")
+
+// run performs a command, returning its response data and a function that
+// prints it for people, or nil to print the JSON
+func run(c *client, command string, args []string) (json.RawMessage, func(json.RawMessage) error, error) {
+	switch command {
+	case "meetings":
+		flags := flag.NewFlagSet("meetings", flag.ContinueOnError)
+		ended := flags.Bool("ended", false, "list ended meetings instead")
+		limit := flags.Int("limit", 50, "maximum meetings to list")
+		if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+			return nil, nil, errUsage
+		}
+		query := url.Values{"active": {strconv.FormatBool(!*ended)}, "limit": {strconv.Itoa(*limit)}}
+		data, err := c.do("GET", "/api/admin/meetings?"+query.Encode(), nil)
+		return data, printMeetings, err
+
+	case "end":
+		if len(args) != 1 {
+			return nil, nil, errUsage
+		}
+		data, err := c.do("POST", "/api/admin/meetings/"+url.PathEscape(args[0])+"/end", nil)
+		return data, printDone("Ended meeting " + args[0]), err
+
+	case "kick":
+		if len(args) != 2 {
+			return nil, nil, errUsage
+		}
+		data, err := c.do("POST", "/api/admin/meetings/"+url.PathEscape(args[0])+"/participants/"+url.PathEscape(args[1])+"/kick", nil)
+		return data, printDone(fmt.Sprintf("Removed %s from meeting %s", args[1], args[0])), err
+
+	case "stats":
+		if len(args) != 0 {
+			return nil, nil, errUsage
+		}
+		data, err := c.do("GET", "/api/admin/hub", nil)
+		return data, nil, err
+
+	case "rotate-signing-key":
+		if len(args) != 0 {
+			return nil, nil, errUsage
+		}
+		data, err := c.do("POST", "/api/admin/signing-keys/rotate", nil)
+		return data, printDone("Rotated the signing key; links signed before the previous rotation no longer work"), err
+	}
+	return nil, nil, errUsage
+}
+
+func printMeetings(data json.RawMessage) error {
+	var page struct {
+		Meetings []struct {
+			ID               string    `json:"id"`
+			Code             string    `json:"code"`
+			Title            string    `json:"title"`
+			CreatedBy        string    `json:"createdBy"`
+			CreatedAt        time.Time `json:"createdAt"`
+			ParticipantCount int       `json:"participantCount"`
+		} `json:"meetings"`
+		Total int64 `json:"total"`
+	}
+	if err := json.Unmarshal(data, &page); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tCODE\tTITLE\tHOST\tPARTICIPANTS\tCREATED")
+	for _, m := range page.Meetings {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", m.ID, m.Code, m.Title, m.CreatedBy, m.ParticipantCount, m.CreatedAt.Format(time.RFC3339))
+	}
+	w.Flush()
+	fmt.Printf("%d of %d meetings\n", len(page.Meetings), page.Total)
+	return nil
+}
+
+func printDone(message string) func(json.RawMessage) error {
+	return func(json.RawMessage) error {
+		fmt.Println(message)
+		return nil
+	}
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
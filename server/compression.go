@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// MinCompressSize is the smallest response body worth compressing
+const MinCompressSize = 1024
+
+// BrotliLevel trades ratio for CPU; above 6 brotli slows sharply for
+// responses made per request
+const BrotliLevel = 5
+
+// encodingWriter compresses into the writer it was last Reset to
+type encodingWriter interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// responseEncoder is a content coding responses may be compressed with
+type responseEncoder struct {
+	name string
+	pool sync.Pool
+}
+
+// responseEncoders in order of preference
+var responseEncoders = []*responseEncoder{
+	{name: "br", pool: sync.Pool{New: func() interface{} { return brotli.NewWriterLevel(nil, BrotliLevel) }}},
+	{name: "gzip", pool: sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}},
+}
+
+// negotiateEncoding picks the encoder the client gives the highest weight,
+// preferring the earlier of equally weighted ones, or nil. "*" weighs the
+// encodings the header doesn't name.
+func negotiateEncoding(acceptEncoding string) *responseEncoder {
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			weights[name] = q
+		}
+	}
+
+	var best *responseEncoder
+	bestQ := 0.0
+	for _, encoder := range responseEncoders {
+		q, ok := weights[encoder.name]
+		if !ok {
+			q = weights["*"]
+		}
+		if q > bestQ {
+			best, bestQ = encoder, q
+		}
+	}
+	return best
+}
+
+// compressionMiddleware compresses JSON responses for clients that accept
+// it. Small bodies, media and streams are passed through, as are WebSocket
+// upgrades, which need the connection itself.
+func compressionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoder := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoder == nil || r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoder: encoder, status: http.StatusOK}
+		next.ServeHTTP(cw, r)
+		cw.finish()
+	})
+}
+
+// compressWriter buffers the start of a response until it knows whether the
+// response is worth compressing
+type compressWriter struct {
+	http.ResponseWriter
+	encoder     *responseEncoder
+	status      int
+	wroteHeader bool
+	buffer      []byte
+	// decided is set once the response is being compressed or passed through
+	decided bool
+	enc     encodingWriter
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.decided {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if !cw.wroteHeader {
+		cw.status = status
+		cw.wroteHeader = true
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.decided {
+		if !cw.compressible() {
+			cw.passThrough()
+		} else {
+			cw.buffer = append(cw.buffer, p...)
+			if len(cw.buffer) < MinCompressSize {
+				return len(p), nil
+			}
+			return len(p), cw.startCompressing()
+		}
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// compressible reports whether the response may be compressed
+func (cw *compressWriter) compressible() bool {
+	header := cw.Header()
+	if cw.status < http.StatusOK || cw.status == http.StatusNoContent || cw.status == http.StatusNotModified {
+		return false
+	}
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+	return strings.HasPrefix(header.Get("Content-Type"), "application/json")
+}
+
+// passThrough sends the response, and what was buffered of it, as is
+func (cw *compressWriter) passThrough() error {
+	cw.decided = true
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if len(cw.buffer) == 0 {
+		return nil
+	}
+	_, err := cw.ResponseWriter.Write(cw.buffer)
+	cw.buffer = nil
+	return err
+}
+
+func (cw *compressWriter) startCompressing() error {
+	cw.decided = true
+	header := cw.Header()
+	header.Del("Content-Length")
+	header.Set("Content-Encoding", cw.encoder.name)
+	header.Add("Vary", "Accept-Encoding")
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	cw.enc = cw.encoder.pool.Get().(encodingWriter)
+	cw.enc.Reset(cw.ResponseWriter)
+	_, err := cw.enc.Write(cw.buffer)
+	cw.buffer = nil
+	return err
+}
+
+// Flush sends what has been written so far, giving up on compressing a
+// response that is still too small
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.passThrough()
+	}
+	if cw.enc != nil {
+		cw.enc.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// finish completes the response once the handler has returned
+func (cw *compressWriter) finish() {
+	if !cw.decided {
+		if cw.compressible() {
+			// Too small to be worth it, but a larger one would be compressed
+			cw.Header().Add("Vary", "Accept-Encoding")
+		}
+		cw.passThrough()
+		return
+	}
+	if cw.enc != nil {
+		cw.enc.Close()
+		cw.encoder.pool.Put(cw.enc)
+		cw.enc = nil
+	}
+}
+
+// etagRecorder holds a response back until its ETag is known
+type etagRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (er *etagRecorder) WriteHeader(status int) {
+	if er.status == 0 {
+		er.status = status
+	}
+}
+
+func (er *etagRecorder) Write(p []byte) (int, error) {
+	if er.status == 0 {
+		er.status = http.StatusOK
+	}
+	return er.body.Write(p)
+}
+
+// withETag lets polling clients revalidate a list endpoint: successful
+// responses carry a weak ETag of their body, and a request whose
+// If-None-Match matches it gets 304 Not Modified without the body. The
+// handler still runs, so this saves bandwidth rather than queries.
+func withETag(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			next(w, r)
+			return
+		}
+
+		recorder := &etagRecorder{ResponseWriter: w}
+		next(recorder, r)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		if recorder.status != http.StatusOK {
+			w.WriteHeader(recorder.status)
+			w.Write(recorder.body.Bytes())
+			return
+		}
+
+		sum := sha256.Sum256(recorder.body.Bytes())
+		etag := `W/"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "private, no-cache")
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(recorder.body.Bytes())
+	}
+}
+
+// etagMatches compares If-None-Match with an ETag weakly, as RFC 9110
+// requires for If-None-Match
+func etagMatches(ifNoneMatch, etag string) bool {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
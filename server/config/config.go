@@ -0,0 +1,1530 @@
+package config
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"video-meeting-app/schedule"
+)
+
+// Config holds every tunable setting of the server. Values come from the
+// defaults below, then an optional YAML/JSON file (CONFIG_FILE), then
+// environment variable overrides.
+type Config struct {
+	AppURL    string          `json:"appUrl" yaml:"appUrl"`
+	Server    ServerConfig    `json:"server" yaml:"server"`
+	Database  DatabaseConfig  `json:"database" yaml:"database"`
+	Mongo     MongoConfig     `json:"mongo" yaml:"mongo"`
+	Postgres  PostgresConfig  `json:"postgres" yaml:"postgres"`
+	CORS      CORSConfig      `json:"cors" yaml:"cors"`
+	RateLimit RateLimitConfig `json:"rateLimit" yaml:"rateLimit"`
+	Meetings  MeetingsConfig  `json:"meetings" yaml:"meetings"`
+	Media     MediaConfig     `json:"media" yaml:"media"`
+	Slack     SlackConfig     `json:"slack" yaml:"slack"`
+	Push      PushConfig      `json:"push" yaml:"push"`
+	Mail      MailConfig      `json:"mail" yaml:"mail"`
+	Storage   StorageConfig   `json:"storage" yaml:"storage"`
+	Presence  PresenceConfig  `json:"presence" yaml:"presence"`
+	Plans     PlansConfig     `json:"plans" yaml:"plans"`
+	Chat      ChatConfig      `json:"chat" yaml:"chat"`
+	Retention RetentionConfig `json:"retention" yaml:"retention"`
+	Jobs      JobsConfig      `json:"jobs" yaml:"jobs"`
+	Cache     CacheConfig     `json:"cache" yaml:"cache"`
+	Handover  HandoverConfig  `json:"handover" yaml:"handover"`
+	Sessions  SessionsConfig  `json:"sessions" yaml:"sessions"`
+	Regions   RegionsConfig   `json:"regions" yaml:"regions"`
+	Hub       HubConfig       `json:"hub" yaml:"hub"`
+	TLS       TLSConfig       `json:"tls" yaml:"tls"`
+
+	Transcription TranscriptionConfig `json:"transcription" yaml:"transcription"`
+	Recordings    RecordingsConfig    `json:"recordings" yaml:"recordings"`
+	Embed         EmbedConfig         `json:"embed" yaml:"embed"`
+	Passwords     PasswordsConfig     `json:"passwords" yaml:"passwords"`
+	Captcha       CaptchaConfig       `json:"captcha" yaml:"captcha"`
+	Encryption    EncryptionConfig    `json:"encryption" yaml:"encryption"`
+	Tenants       []TenantConfig      `json:"tenants,omitempty" yaml:"tenants,omitempty"`
+}
+
+type ServerConfig struct {
+	Port            string   `json:"port" yaml:"port"`
+	ReadTimeout     Duration `json:"readTimeout" yaml:"readTimeout"`
+	WriteTimeout    Duration `json:"writeTimeout" yaml:"writeTimeout"`
+	IdleTimeout     Duration `json:"idleTimeout" yaml:"idleTimeout"`
+	ShutdownTimeout Duration `json:"shutdownTimeout" yaml:"shutdownTimeout"`
+	// HubShards is how many goroutines WebSocket meetings are spread over
+	HubShards int `json:"hubShards" yaml:"hubShards"`
+	// SwaggerUI serves an API browser for /api/openapi.json at /api/docs
+	SwaggerUI bool `json:"swaggerUi" yaml:"swaggerUi"`
+	// TrustedProxies are the CIDRs or addresses of the load balancers and
+	// proxies in front of the server. X-Forwarded-For and X-Real-Ip are only
+	// believed from these peers; from anyone else the connection's address
+	// is the client's.
+	TrustedProxies []string `json:"trustedProxies,omitempty" yaml:"trustedProxies,omitempty"`
+}
+
+// Database drivers. Handlers query every driver in MongoDB's query language;
+// with postgres each collection is a table of documents and the server
+// evaluates queries itself, as it does for memory, which keeps everything in
+// process memory for demos and CI.
+const (
+	DatabaseDriverMongo    = "mongodb"
+	DatabaseDriverPostgres = "postgres"
+	DatabaseDriverMemory   = "memory"
+)
+
+// DatabaseSeedDemo seeds the demo data bundled with the server, which signs
+// in as demo@example.com with the password demo-password
+const DatabaseSeedDemo = "demo"
+
+// DatabaseConfig selects the database the server stores its data in
+type DatabaseConfig struct {
+	Driver string `json:"driver" yaml:"driver"`
+	// MigrateOnStartup applies pending migrations when the server starts;
+	// otherwise run "server migrate up" before deploying
+	MigrateOnStartup bool `json:"migrateOnStartup" yaml:"migrateOnStartup"`
+	// Seed loads documents into empty collections at startup: "demo" for
+	// the bundled demo data, or the path of an extended JSON file
+	Seed string `json:"seed,omitempty" yaml:"seed,omitempty"`
+}
+
+type MongoConfig struct {
+	URI                    string   `json:"uri" yaml:"uri"`
+	Database               string   `json:"database" yaml:"database"`
+	MaxPoolSize            uint64   `json:"maxPoolSize" yaml:"maxPoolSize"`
+	MinPoolSize            uint64   `json:"minPoolSize" yaml:"minPoolSize"`
+	MaxConnecting          uint64   `json:"maxConnecting" yaml:"maxConnecting"`
+	MaxConnIdleTime        Duration `json:"maxConnIdleTime" yaml:"maxConnIdleTime"`
+	ConnectTimeout         Duration `json:"connectTimeout" yaml:"connectTimeout"`
+	ServerSelectionTimeout Duration `json:"serverSelectionTimeout" yaml:"serverSelectionTimeout"`
+	HeartbeatInterval      Duration `json:"heartbeatInterval" yaml:"heartbeatInterval"`
+	// QueryTimeout bounds each operation whose context has no deadline of
+	// its own; zero leaves operations unbounded
+	QueryTimeout Duration `json:"queryTimeout" yaml:"queryTimeout"`
+	// ChangeStreams relays participant and meeting changes made by other
+	// instances to local clients; needs a replica set
+	ChangeStreams bool `json:"changeStreams" yaml:"changeStreams"`
+}
+
+// PostgresConfig connects to PostgreSQL for the postgres database driver
+type PostgresConfig struct {
+	URL            string   `json:"url" yaml:"url"`
+	MaxConns       uint64   `json:"maxConns" yaml:"maxConns"`
+	MinConns       uint64   `json:"minConns" yaml:"minConns"`
+	ConnectTimeout Duration `json:"connectTimeout" yaml:"connectTimeout"`
+	// QueryTimeout bounds each operation whose context has no deadline of
+	// its own; zero leaves operations unbounded
+	QueryTimeout Duration `json:"queryTimeout" yaml:"queryTimeout"`
+}
+
+type CORSConfig struct {
+	AllowedOrigins []string `json:"allowedOrigins" yaml:"allowedOrigins"`
+}
+
+type RateLimitConfig struct {
+	// Default policy, keyed by user when signed in and by IP otherwise
+	RequestsPerMinute int `json:"requestsPerMinute" yaml:"requestsPerMinute"`
+	Burst             int `json:"burst" yaml:"burst"`
+	// Stricter policy for login attempts, keyed by IP
+	LoginPerMinute int      `json:"loginPerMinute" yaml:"loginPerMinute"`
+	LoginBurst     int      `json:"loginBurst" yaml:"loginBurst"`
+	EvictAfter     Duration `json:"evictAfter" yaml:"evictAfter"`
+	// Budget of new API keys, which replaces the default policy for them
+	APIKeyRequestsPerMinute int `json:"apiKeyRequestsPerMinute" yaml:"apiKeyRequestsPerMinute"`
+	// Budgets for messages clients send over meeting WebSockets
+	WebSocket WebSocketLimits `json:"webSocket" yaml:"webSocket"`
+	// Budgets for app messages clients send over SFU data channels
+	DataChannels DataChannelLimits `json:"dataChannels" yaml:"dataChannels"`
+}
+
+// MessageBudget limits one kind of WebSocket message per client
+type MessageBudget struct {
+	PerMinute int `json:"perMinute" yaml:"perMinute"`
+	Burst     int `json:"burst" yaml:"burst"`
+	MaxBytes  int `json:"maxBytes" yaml:"maxBytes"`
+}
+
+// WebSocketLimits sets per-client message budgets. Going over budget drops
+// the message with a warning; MaxViolations within ViolationWindow disconnects
+// the client.
+type WebSocketLimits struct {
+	Default MessageBudget `json:"default" yaml:"default"`
+	// Budgets for specific message types, e.g. "chat-message"; other types
+	// share the default budget
+	Types           map[string]MessageBudget `json:"types" yaml:"types"`
+	MaxViolations   int                      `json:"maxViolations" yaml:"maxViolations"`
+	ViolationWindow Duration                 `json:"violationWindow" yaml:"violationWindow"`
+}
+
+// Budget returns the budget for a message type
+func (l WebSocketLimits) Budget(messageType string) (string, MessageBudget) {
+	if budget, ok := l.Types[messageType]; ok {
+		return messageType, budget
+	}
+	return "default", l.Default
+}
+
+// DataChannelLimits sets per-participant budgets for the app messages the SFU
+// relays, by data channel label. Messages over budget are dropped.
+type DataChannelLimits struct {
+	Default MessageBudget `json:"default" yaml:"default"`
+	// Budgets for specific channels, e.g. "cursor"; other channels share the
+	// default budget
+	Channels map[string]MessageBudget `json:"channels" yaml:"channels"`
+}
+
+// Budget returns the budget for a channel label
+func (l DataChannelLimits) Budget(label string) (string, MessageBudget) {
+	if budget, ok := l.Channels[label]; ok {
+		return label, budget
+	}
+	return "default", l.Default
+}
+
+type MeetingsConfig struct {
+	DefaultParticipants int      `json:"defaultParticipants" yaml:"defaultParticipants"`
+	MaxParticipants     int      `json:"maxParticipants" yaml:"maxParticipants"`
+	MaxScreenShares     int      `json:"maxScreenShares" yaml:"maxScreenShares"`
+	ParticipantTimeout  Duration `json:"participantTimeout" yaml:"participantTimeout"`
+	// Participants without a heartbeat for this long are listed as inactive
+	ParticipantIdleTimeout Duration `json:"participantIdleTimeout" yaml:"participantIdleTimeout"`
+	EmptyMeetingTimeout    Duration `json:"emptyMeetingTimeout" yaml:"emptyMeetingTimeout"`
+	// Who may record meetings; organizations can override it
+	RecordingPolicy string `json:"recordingPolicy" yaml:"recordingPolicy"`
+	// Joins to a full meeting may wait in a queue for a seat. A queued user
+	// given a seat has OverflowAdmitWindow to take it, and one still waiting
+	// must check their place at least that often to keep it.
+	OverflowQueue       bool     `json:"overflowQueue" yaml:"overflowQueue"`
+	OverflowAdmitWindow Duration `json:"overflowAdmitWindow" yaml:"overflowAdmitWindow"`
+}
+
+// Recording policies
+const (
+	RecordingPolicyDisabled = "disabled"
+	RecordingPolicyHostOnly = "host-only"
+	RecordingPolicyAnyone   = "anyone"
+)
+
+type MediaConfig struct {
+	SFUMode bool   `json:"sfuMode" yaml:"sfuMode"`
+	TURNURL string `json:"turnUrl,omitempty" yaml:"turnUrl,omitempty"`
+	// In SFU mode, audio quieter than this level (-dBov, 0 loudest to 127
+	// silent) is not forwarded; 0 forwards all audio
+	SilenceLevel int `json:"silenceLevel" yaml:"silenceLevel"`
+}
+
+// SlackConfig holds the Slack app credentials; the integration is disabled
+// unless all of them are set
+type SlackConfig struct {
+	ClientID      string `json:"clientId" yaml:"clientId"`
+	ClientSecret  string `json:"clientSecret" yaml:"clientSecret"`
+	SigningSecret string `json:"signingSecret" yaml:"signingSecret"`
+	// OAuth redirect, i.e. the public URL of /api/integrations/slack/oauth/callback
+	RedirectURL string `json:"redirectUrl" yaml:"redirectUrl"`
+}
+
+// Enabled reports whether the Slack integration is configured
+func (s SlackConfig) Enabled() bool {
+	return s.ClientID != "" && s.ClientSecret != "" && s.SigningSecret != "" && s.RedirectURL != ""
+}
+
+// PushConfig holds push provider credentials; each provider is disabled
+// while its credentials are missing
+type PushConfig struct {
+	// Path to a Firebase service account JSON key
+	FCMCredentialsFile string `json:"fcmCredentialsFile" yaml:"fcmCredentialsFile"`
+	// Path to the APNs .p8 auth key and its identifiers
+	APNsKeyFile    string `json:"apnsKeyFile" yaml:"apnsKeyFile"`
+	APNsKeyID      string `json:"apnsKeyId" yaml:"apnsKeyId"`
+	APNsTeamID     string `json:"apnsTeamId" yaml:"apnsTeamId"`
+	APNsTopic      string `json:"apnsTopic" yaml:"apnsTopic"` // the app's bundle ID
+	APNsProduction bool   `json:"apnsProduction" yaml:"apnsProduction"`
+}
+
+// APNsEnabled reports whether APNs credentials are configured
+func (p PushConfig) APNsEnabled() bool {
+	return p.APNsKeyFile != "" && p.APNsKeyID != "" && p.APNsTeamID != "" && p.APNsTopic != ""
+}
+
+// Mail providers
+const (
+	MailProviderLog      = "log"
+	MailProviderSMTP     = "smtp"
+	MailProviderSendGrid = "sendgrid"
+)
+
+// MailConfig selects and configures the email provider. When Provider is
+// empty it is inferred: SendGrid if an API key is set, SMTP if a host is set,
+// otherwise messages are only logged.
+type MailConfig struct {
+	Provider  string `json:"provider" yaml:"provider"`
+	From      string `json:"from" yaml:"from"`
+	PerMinute int    `json:"perMinute" yaml:"perMinute"`
+	QueueSize int    `json:"queueSize" yaml:"queueSize"`
+
+	SMTPHost     string `json:"smtpHost" yaml:"smtpHost"`
+	SMTPPort     string `json:"smtpPort" yaml:"smtpPort"`
+	SMTPUsername string `json:"smtpUsername" yaml:"smtpUsername"`
+	SMTPPassword string `json:"smtpPassword" yaml:"smtpPassword"`
+
+	SendGridAPIKey string `json:"sendgridApiKey" yaml:"sendgridApiKey"`
+}
+
+// ResolvedProvider returns the configured provider or the inferred one
+func (m MailConfig) ResolvedProvider() string {
+	switch {
+	case m.Provider != "":
+		return m.Provider
+	case m.SendGridAPIKey != "":
+		return MailProviderSendGrid
+	case m.SMTPHost != "":
+		return MailProviderSMTP
+	default:
+		return MailProviderLog
+	}
+}
+
+// Storage backends
+const (
+	StorageBackendGridFS = "gridfs"
+	StorageBackendLocal  = "local"
+	StorageBackendS3     = "s3"
+	StorageBackendMemory = "memory"
+)
+
+// StorageConfig selects where uploaded files such as avatars are kept
+type StorageConfig struct {
+	Backend      string `json:"backend" yaml:"backend"`
+	GridFSBucket string `json:"gridfsBucket" yaml:"gridfsBucket"`
+	LocalDir     string `json:"localDir" yaml:"localDir"`
+
+	S3Bucket string `json:"s3Bucket" yaml:"s3Bucket"`
+	S3Region string `json:"s3Region" yaml:"s3Region"`
+	// Endpoint of an S3-compatible service such as MinIO; AWS when empty
+	S3Endpoint        string `json:"s3Endpoint,omitempty" yaml:"s3Endpoint,omitempty"`
+	S3PathStyle       bool   `json:"s3PathStyle" yaml:"s3PathStyle"`
+	S3AccessKeyID     string `json:"s3AccessKeyId" yaml:"s3AccessKeyId"`
+	S3SecretAccessKey string `json:"s3SecretAccessKey" yaml:"s3SecretAccessKey"`
+}
+
+// Chat filter strictness, set per meeting
+const (
+	ChatFilterOff      = "off"
+	ChatFilterModerate = "moderate"
+	ChatFilterStrict   = "strict"
+)
+
+// IsValidChatFilter reports whether filter is a known strictness
+func IsValidChatFilter(filter string) bool {
+	return filter == ChatFilterOff || filter == ChatFilterModerate || filter == ChatFilterStrict
+}
+
+// ChatConfig limits chat attachments and configures the chat filter
+type ChatConfig struct {
+	MaxAttachmentMB int `json:"maxAttachmentMb" yaml:"maxAttachmentMb"`
+	// File extensions that may be attached, e.g. ".pdf"
+	AttachmentTypes []string `json:"attachmentTypes" yaml:"attachmentTypes"`
+	// Optional virus scanner that uploads are sent to before they are stored
+	AttachmentScanURL string `json:"attachmentScanUrl,omitempty" yaml:"attachmentScanUrl,omitempty"`
+
+	// Filter strictness for meetings whose host has not chosen one
+	DefaultFilter string `json:"defaultFilter" yaml:"defaultFilter"`
+	// Words blocked as whole words, or anywhere inside words in strict meetings
+	BlockedWords []string `json:"blockedWords,omitempty" yaml:"blockedWords,omitempty"`
+	// Regular expressions blocked in every filtered meeting
+	BlockedPatterns []string `json:"blockedPatterns,omitempty" yaml:"blockedPatterns,omitempty"`
+	// Optional external moderation API that messages are checked against
+	ModerationURL    string `json:"moderationUrl,omitempty" yaml:"moderationUrl,omitempty"`
+	ModerationAPIKey string `json:"moderationApiKey,omitempty" yaml:"moderationApiKey,omitempty"`
+}
+
+// RetentionConfig controls how long meeting data is kept before the purge
+// job deletes it. Organizations may override the chat and recording
+// periods. A period of 0 days keeps that data forever.
+type RetentionConfig struct {
+	ChatDays      int `json:"chatDays" yaml:"chatDays"`
+	RecordingDays int `json:"recordingDays" yaml:"recordingDays"`
+	// Participant records are kept this long after their meeting ends
+	ParticipantsAfterEnd Duration `json:"participantsAfterEnd" yaml:"participantsAfterEnd"`
+	PurgeInterval        Duration `json:"purgeInterval" yaml:"purgeInterval"`
+	// Ended meetings move to the archive this long after they end, keeping
+	// the meetings listing small; 0 never archives them
+	ArchiveAfter Duration `json:"archiveAfter" yaml:"archiveAfter"`
+}
+
+// JobsConfig sets when the scheduled jobs run, as cron expressions in UTC or
+// shorthands such as "@every 5m"; see the schedule package. With several
+// instances, each run happens on one of them.
+type JobsConfig struct {
+	Cleanup   string `json:"cleanup" yaml:"cleanup"`
+	Reminders string `json:"reminders" yaml:"reminders"`
+	// Empty runs the purge every retention.purgeInterval
+	Retention       string `json:"retention,omitempty" yaml:"retention,omitempty"`
+	AnalyticsRollup string `json:"analyticsRollup" yaml:"analyticsRollup"`
+}
+
+// CacheConfig controls the read-through cache of meeting lookups. Set
+// RedisURL to share cached meetings between instances; updates made on one
+// instance then evict the meeting from every instance's memory.
+type CacheConfig struct {
+	// Meetings kept in memory; 0 disables the cache
+	MeetingCacheSize int      `json:"meetingCacheSize" yaml:"meetingCacheSize"`
+	MeetingTTL       Duration `json:"meetingTtl" yaml:"meetingTtl"`
+	RedisURL         string   `json:"redisUrl,omitempty" yaml:"redisUrl,omitempty"`
+}
+
+// HandoverConfig controls how an instance hands its meetings over when it is
+// drained, e.g. in a blue/green deploy. Clients are told to reconnect to
+// ReconnectURL, or to the URL they used when it is empty. With RedisURL set,
+// state kept in memory, such as what reconnecting clients need to resume, is
+// passed on to the instance they reconnect to.
+type HandoverConfig struct {
+	RedisURL     string `json:"redisUrl,omitempty" yaml:"redisUrl,omitempty"`
+	ReconnectURL string `json:"reconnectUrl,omitempty" yaml:"reconnectUrl,omitempty"`
+	// How long handed over state waits for the meeting's clients
+	StateTTL Duration `json:"stateTtl" yaml:"stateTtl"`
+	// Clients spread their reconnects over this long
+	ReconnectWindow Duration `json:"reconnectWindow" yaml:"reconnectWindow"`
+}
+
+// SessionsConfig controls login sessions. They are stored in the database,
+// or in Redis when RedisURL is set, so any instance can validate them. A
+// session expires after IdleTimeout without use, and after MaxLifetime
+// regardless.
+type SessionsConfig struct {
+	RedisURL    string   `json:"redisUrl,omitempty" yaml:"redisUrl,omitempty"`
+	IdleTimeout Duration `json:"idleTimeout" yaml:"idleTimeout"`
+	MaxLifetime Duration `json:"maxLifetime" yaml:"maxLifetime"`
+}
+
+// RegionsConfig routes each meeting's realtime traffic to one region in a
+// global deployment. Every instance lists every region's public URL and
+// names its own in Local; routing is off when Local is empty. ClientHeader
+// names the header a geo-aware load balancer or CDN sets to the caller's
+// region. A meeting moves to another region once a majority of at least
+// MinParticipantsToMove connected participants is there, and at most once
+// per MoveCooldown.
+type RegionsConfig struct {
+	Local                 string           `json:"local,omitempty" yaml:"local,omitempty"`
+	ClientHeader          string           `json:"clientHeader" yaml:"clientHeader"`
+	Endpoints             []RegionEndpoint `json:"endpoints,omitempty" yaml:"endpoints,omitempty"`
+	MinParticipantsToMove int              `json:"minParticipantsToMove" yaml:"minParticipantsToMove"`
+	MoveCooldown          Duration         `json:"moveCooldown" yaml:"moveCooldown"`
+}
+
+// RegionEndpoint is where clients reach a region's instances
+type RegionEndpoint struct {
+	Name string `json:"name" yaml:"name"`
+	URL  string `json:"url" yaml:"url"`
+}
+
+// HubConfig caps the memory the WebSocket hub holds, so a long-running
+// instance can't slowly run out from busy or abandoned meetings. Each
+// meeting keeps recent messages, up to ReplayMaxBytes, for clients resuming
+// after a reconnect. Past ReplayTotalMaxBytes across meetings, the buffers
+// of meetings nobody is connected to are evicted first, least recently used
+// first, then the largest buffers are trimmed. A client with more than
+// SendQueueMaxBytes waiting has droppable messages shed.
+type HubConfig struct {
+	ReplayMaxBytes      int `json:"replayMaxBytes" yaml:"replayMaxBytes"`
+	ReplayTotalMaxBytes int `json:"replayTotalMaxBytes" yaml:"replayTotalMaxBytes"`
+	SendQueueMaxBytes   int `json:"sendQueueMaxBytes" yaml:"sendQueueMaxBytes"`
+}
+
+// TLSConfig serves HTTPS directly, with certificates from Let's Encrypt, for
+// deployments not behind a TLS-terminating proxy. Listing domains enables it;
+// server.port then serves HTTPS and HTTPPort answers ACME challenges and
+// redirects everything else to HTTPS.
+type TLSConfig struct {
+	Domains []string `json:"domains,omitempty" yaml:"domains,omitempty"`
+	// Email is given to Let's Encrypt for expiry notices
+	Email string `json:"email,omitempty" yaml:"email,omitempty"`
+	// CacheDir keeps certificates across restarts
+	CacheDir string `json:"cacheDir" yaml:"cacheDir"`
+	HTTPPort string `json:"httpPort" yaml:"httpPort"`
+	// DirectoryURL is the ACME server; Let's Encrypt production when empty
+	DirectoryURL string `json:"directoryUrl,omitempty" yaml:"directoryUrl,omitempty"`
+	// HSTSMaxAge is sent in Strict-Transport-Security; zero omits the header
+	HSTSMaxAge            Duration `json:"hstsMaxAge" yaml:"hstsMaxAge"`
+	HSTSIncludeSubdomains bool     `json:"hstsIncludeSubdomains" yaml:"hstsIncludeSubdomains"`
+}
+
+// Enabled reports whether the server terminates TLS itself
+func (t TLSConfig) Enabled() bool {
+	return len(t.Domains) > 0
+}
+
+// PresenceConfig controls online-status tracking. Set RedisURL when running
+// more than one instance so presence is shared between them.
+type PresenceConfig struct {
+	RedisURL string `json:"redisUrl,omitempty" yaml:"redisUrl,omitempty"`
+	// A connection counts as online until TTL passes without a heartbeat
+	TTL               Duration `json:"ttl" yaml:"ttl"`
+	HeartbeatInterval Duration `json:"heartbeatInterval" yaml:"heartbeatInterval"`
+}
+
+// Plan tiers
+const (
+	PlanFree = "free"
+	PlanPro  = "pro"
+)
+
+// PlanLimits are the quotas of one plan tier. Meeting limits are those of
+// the host's plan.
+type PlanLimits struct {
+	MaxMeetingDuration       Duration `json:"maxMeetingDuration" yaml:"maxMeetingDuration"`
+	MaxParticipants          int      `json:"maxParticipants" yaml:"maxParticipants"`
+	RecordingMinutesPerMonth int      `json:"recordingMinutesPerMonth" yaml:"recordingMinutesPerMonth"`
+}
+
+// PlansConfig holds the limits of each plan tier
+type PlansConfig struct {
+	Free PlanLimits `json:"free" yaml:"free"`
+	Pro  PlanLimits `json:"pro" yaml:"pro"`
+}
+
+// Limits returns the limits of the plan, treating unknown plans as free
+func (p PlansConfig) Limits(plan string) PlanLimits {
+	if plan == PlanPro {
+		return p.Pro
+	}
+	return p.Free
+}
+
+// Speech-to-text providers
+const (
+	STTProviderWhisper = "whisper"
+	STTProviderGoogle  = "google"
+)
+
+// TranscriptionConfig selects the speech-to-text provider for meeting
+// transcripts. Transcription needs SFU mode and is off while Provider is empty.
+type TranscriptionConfig struct {
+	Provider string `json:"provider" yaml:"provider"`
+	// BCP 47 language of the speech, e.g. en-US
+	Language string `json:"language" yaml:"language"`
+	// Concurrent requests to the provider
+	Workers int `json:"workers" yaml:"workers"`
+	// How often the speech so far is re-transcribed for interim live captions;
+	// zero sends only final captions
+	CaptionInterval Duration `json:"captionInterval" yaml:"captionInterval"`
+
+	WhisperAPIKey string `json:"whisperApiKey" yaml:"whisperApiKey"`
+	WhisperModel  string `json:"whisperModel" yaml:"whisperModel"`
+	WhisperURL    string `json:"whisperUrl" yaml:"whisperUrl"`
+
+	GoogleAPIKey string `json:"googleApiKey" yaml:"googleApiKey"`
+}
+
+// RecordingsConfig limits recording uploads and controls the poster frame
+// and preview clip generated for each stored recording. Generation runs
+// ffmpeg and is off while FFmpegPath is empty.
+type RecordingsConfig struct {
+	// MaxUploadMB caps the size of a finished recording's file
+	MaxUploadMB int `json:"maxUploadMb" yaml:"maxUploadMb"`
+	// UploadWindow is how long after stopping a recording its file may be
+	// uploaded
+	UploadWindow Duration `json:"uploadWindow" yaml:"uploadWindow"`
+
+	FFmpegPath string `json:"ffmpegPath,omitempty" yaml:"ffmpegPath,omitempty"`
+	// Where in the recording the poster frame and preview are taken from;
+	// shorter recordings use their midpoint
+	PreviewOffset Duration `json:"previewOffset" yaml:"previewOffset"`
+	PreviewLength Duration `json:"previewLength" yaml:"previewLength"`
+	// How often recordings without previews are looked for
+	PreviewInterval Duration `json:"previewInterval" yaml:"previewInterval"`
+}
+
+// EmbedConfig controls embedding the meeting client in partner sites.
+// Embedding is off while AllowedDomains is empty.
+type EmbedConfig struct {
+	// Hosts that may embed meetings, e.g. partner.com or *.partner.com
+	AllowedDomains []string `json:"allowedDomains,omitempty" yaml:"allowedDomains,omitempty"`
+	// How long an embed token can be used to join the meeting
+	TokenTTL Duration `json:"tokenTtl" yaml:"tokenTtl"`
+}
+
+// PasswordsConfig sets the Argon2id parameters for password hashes. Hashes
+// made with other parameters, or with bcrypt, are redone at the next login.
+type PasswordsConfig struct {
+	// Memory in KiB
+	Memory      int `json:"memory" yaml:"memory"`
+	Iterations  int `json:"iterations" yaml:"iterations"`
+	Parallelism int `json:"parallelism" yaml:"parallelism"`
+}
+
+// CAPTCHA providers
+const (
+	CaptchaProviderHCaptcha  = "hcaptcha"
+	CaptchaProviderRecaptcha = "recaptcha"
+	CaptchaProviderTurnstile = "turnstile"
+)
+
+// CaptchaConfig selects the CAPTCHA that registration and login require when
+// a client looks abusive. CAPTCHAs are off while Provider is empty.
+type CaptchaConfig struct {
+	Provider  string `json:"provider,omitempty" yaml:"provider,omitempty"`
+	SiteKey   string `json:"siteKey,omitempty" yaml:"siteKey,omitempty"`
+	SecretKey string `json:"secretKey,omitempty" yaml:"secretKey,omitempty"`
+	// Require a CAPTCHA on every registration and login
+	Always bool `json:"always" yaml:"always"`
+	// Registrations from one IP within SignupWindow before a CAPTCHA is needed
+	SignupsPerIP int      `json:"signupsPerIp" yaml:"signupsPerIp"`
+	SignupWindow Duration `json:"signupWindow" yaml:"signupWindow"`
+	// Failed logins for the account or IP before a CAPTCHA is needed
+	LoginFailures int `json:"loginFailures" yaml:"loginFailures"`
+}
+
+// Where the master key of encryption at rest is kept
+const (
+	EncryptionProviderEnv   = "env"
+	EncryptionProviderVault = "vault"
+)
+
+// EncryptionConfig turns on encryption at rest of chat messages and
+// transcripts. Text is encrypted with data keys, which are stored wrapped by
+// the master key: MasterKey itself, or a key in Vault's transit engine that
+// never leaves Vault. Encryption is off while Provider is empty.
+type EncryptionConfig struct {
+	Provider string `json:"provider,omitempty" yaml:"provider,omitempty"`
+	// Base64 of 32 random bytes, for the env provider
+	MasterKey    string `json:"masterKey,omitempty" yaml:"masterKey,omitempty"`
+	VaultAddress string `json:"vaultAddress,omitempty" yaml:"vaultAddress,omitempty"`
+	VaultToken   string `json:"vaultToken,omitempty" yaml:"vaultToken,omitempty"`
+	VaultKey     string `json:"vaultKey,omitempty" yaml:"vaultKey,omitempty"`
+}
+
+// TenantConfig is a customer deployment hosted by the server, isolated from
+// the others' data. Requests reach it on its domains; hosts no tenant claims
+// belong to the default tenant, which uses the server-wide settings.
+type TenantConfig struct {
+	ID      string   `json:"id" yaml:"id"`
+	Name    string   `json:"name" yaml:"name"`
+	Domains []string `json:"domains" yaml:"domains"`
+	// Origins of the tenant's frontend, in place of cors.allowedOrigins
+	AllowedOrigins []string       `json:"allowedOrigins" yaml:"allowedOrigins"`
+	Branding       TenantBranding `json:"branding" yaml:"branding"`
+}
+
+// TenantBranding is how a tenant's frontend presents the product
+type TenantBranding struct {
+	ProductName  string `json:"productName,omitempty" yaml:"productName,omitempty"`
+	LogoURL      string `json:"logoUrl,omitempty" yaml:"logoUrl,omitempty"`
+	PrimaryColor string `json:"primaryColor,omitempty" yaml:"primaryColor,omitempty"`
+	SupportEmail string `json:"supportEmail,omitempty" yaml:"supportEmail,omitempty"`
+}
+
+var (
+	tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+	colorPattern    = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+)
+
+// Duration is a time.Duration written as a string such as "15s" or "30m"
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Default returns the configuration used when nothing is overridden
+func Default() *Config {
+	return &Config{
+		AppURL: "https://google-meet-clone-lovat.vercel.app",
+		Server: ServerConfig{
+			Port:            "8080",
+			ReadTimeout:     Duration(15 * time.Second),
+			WriteTimeout:    Duration(15 * time.Second),
+			IdleTimeout:     Duration(60 * time.Second),
+			ShutdownTimeout: Duration(10 * time.Second),
+			HubShards:       16,
+		},
+		Database: DatabaseConfig{
+			Driver:           DatabaseDriverMongo,
+			MigrateOnStartup: true,
+		},
+		Mongo: MongoConfig{
+			URI:                    "mongodb://localhost:27017",
+			Database:               "video_meeting_app",
+			MaxPoolSize:            200,
+			MinPoolSize:            20,
+			MaxConnecting:          50,
+			MaxConnIdleTime:        Duration(10 * time.Minute),
+			ConnectTimeout:         Duration(15 * time.Second),
+			ServerSelectionTimeout: Duration(10 * time.Second),
+			HeartbeatInterval:      Duration(10 * time.Second),
+			QueryTimeout:           Duration(10 * time.Second),
+		},
+		Postgres: PostgresConfig{
+			MaxConns:       50,
+			MinConns:       5,
+			ConnectTimeout: Duration(15 * time.Second),
+			QueryTimeout:   Duration(10 * time.Second),
+		},
+		CORS: CORSConfig{
+			AllowedOrigins: []string{
+				"https://famous-sprite-14c531.netlify.app",
+				"https://google-meet-clone-lovat.vercel.app",
+				"https://google-meet-clone-ma9v.onrender.com",
+				"http://localhost:5173",
+				"http://localhost:3000",
+			},
+		},
+		RateLimit: RateLimitConfig{
+			RequestsPerMinute:       100,
+			Burst:                   100,
+			LoginPerMinute:          5,
+			LoginBurst:              5,
+			EvictAfter:              Duration(10 * time.Minute),
+			APIKeyRequestsPerMinute: 120,
+			WebSocket: WebSocketLimits{
+				Default: MessageBudget{PerMinute: 600, Burst: 100, MaxBytes: 64 << 10},
+				Types: map[string]MessageBudget{
+					"chat-message":    {PerMinute: 30, Burst: 10, MaxBytes: 16 << 10},
+					"chat-edit":       {PerMinute: 30, Burst: 10, MaxBytes: 16 << 10},
+					"typing":          {PerMinute: 60, Burst: 10, MaxBytes: 1 << 10},
+					"stats":           {PerMinute: 30, Burst: 5, MaxBytes: 1 << 10},
+					"raise-hand":      {PerMinute: 10, Burst: 3, MaxBytes: 1 << 10},
+					"poll-vote":       {PerMinute: 30, Burst: 10, MaxBytes: 4 << 10},
+					"sfu-preferences": {PerMinute: 120, Burst: 20, MaxBytes: 8 << 10},
+					"sfu-ice-restart": {PerMinute: 10, Burst: 3, MaxBytes: 1 << 10},
+					"whiteboard":      {PerMinute: 300, Burst: 60, MaxBytes: 64 << 10},
+					"notes-op":        {PerMinute: 600, Burst: 100, MaxBytes: 32 << 10},
+					// Keys are re-sent to every peer whenever someone leaves
+					"e2ee-public-key":  {PerMinute: 10, Burst: 3, MaxBytes: 4 << 10},
+					"e2ee-key":         {PerMinute: 600, Burst: 200, MaxBytes: 4 << 10},
+					"e2ee-key-request": {PerMinute: 60, Burst: 20, MaxBytes: 1 << 10},
+					// SDP offers are large and ICE candidates arrive in bursts
+					"signal":        {PerMinute: 1200, Burst: 300, MaxBytes: 256 << 10},
+					"sfu-candidate": {PerMinute: 1200, Burst: 300, MaxBytes: 8 << 10},
+				},
+				MaxViolations:   20,
+				ViolationWindow: Duration(time.Minute),
+			},
+			DataChannels: DataChannelLimits{
+				Default: MessageBudget{PerMinute: 600, Burst: 100, MaxBytes: 16 << 10},
+				Channels: map[string]MessageBudget{
+					// Cursor positions are sent at up to 30 per second
+					"cursor":     {PerMinute: 1800, Burst: 60, MaxBytes: 1 << 10},
+					"whiteboard": {PerMinute: 1200, Burst: 200, MaxBytes: 16 << 10},
+				},
+			},
+		},
+		Meetings: MeetingsConfig{
+			DefaultParticipants:    50,
+			MaxParticipants:        100,
+			MaxScreenShares:        1,
+			ParticipantTimeout:     Duration(5 * time.Minute),
+			ParticipantIdleTimeout: Duration(2 * time.Minute),
+			EmptyMeetingTimeout:    Duration(30 * time.Minute),
+			RecordingPolicy:        RecordingPolicyHostOnly,
+			OverflowAdmitWindow:    Duration(2 * time.Minute),
+		},
+		Media: MediaConfig{
+			SilenceLevel: 70,
+		},
+		Mail: MailConfig{
+			From:      "no-reply@video-meeting-app.local",
+			PerMinute: 60,
+			QueueSize: 1000,
+			SMTPPort:  "587",
+		},
+		Storage: StorageConfig{
+			Backend:      StorageBackendGridFS,
+			GridFSBucket: "uploads",
+			LocalDir:     "./data/uploads",
+		},
+		Presence: PresenceConfig{
+			TTL:               Duration(90 * time.Second),
+			HeartbeatInterval: Duration(30 * time.Second),
+		},
+		Chat: ChatConfig{
+			MaxAttachmentMB: 25,
+			DefaultFilter:   ChatFilterModerate,
+			AttachmentTypes: []string{
+				".png", ".jpg", ".jpeg", ".gif", ".webp", ".pdf", ".txt", ".csv", ".md",
+				".zip", ".docx", ".xlsx", ".pptx",
+			},
+		},
+		Retention: RetentionConfig{
+			ChatDays:             30,
+			RecordingDays:        90,
+			ParticipantsAfterEnd: Duration(24 * time.Hour),
+			PurgeInterval:        Duration(time.Hour),
+			ArchiveAfter:         Duration(30 * 24 * time.Hour),
+		},
+		Jobs: JobsConfig{
+			Cleanup:         "@every 1m",
+			Reminders:       "@every 1m",
+			AnalyticsRollup: "10 0 * * *",
+		},
+		Cache: CacheConfig{
+			MeetingCacheSize: 10000,
+			MeetingTTL:       Duration(30 * time.Second),
+		},
+		Handover: HandoverConfig{
+			StateTTL:        Duration(5 * time.Minute),
+			ReconnectWindow: Duration(5 * time.Second),
+		},
+		Sessions: SessionsConfig{
+			IdleTimeout: Duration(7 * 24 * time.Hour),
+			MaxLifetime: Duration(30 * 24 * time.Hour),
+		},
+		Regions: RegionsConfig{
+			ClientHeader:          "X-Client-Region",
+			MinParticipantsToMove: 3,
+			MoveCooldown:          Duration(5 * time.Minute),
+		},
+		Hub: HubConfig{
+			ReplayMaxBytes:      1 << 20,
+			ReplayTotalMaxBytes: 256 << 20,
+			SendQueueMaxBytes:   4 << 20,
+		},
+		TLS: TLSConfig{
+			CacheDir:   "certs",
+			HTTPPort:   "80",
+			HSTSMaxAge: Duration(365 * 24 * time.Hour),
+		},
+		Transcription: TranscriptionConfig{
+			Language:        "en-US",
+			Workers:         4,
+			CaptionInterval: Duration(2 * time.Second),
+			WhisperModel:    "whisper-1",
+			WhisperURL:      "https://api.openai.com/v1/audio/transcriptions",
+		},
+		Recordings: RecordingsConfig{
+			MaxUploadMB:     512,
+			UploadWindow:    Duration(24 * time.Hour),
+			PreviewOffset:   Duration(5 * time.Second),
+			PreviewLength:   Duration(10 * time.Second),
+			PreviewInterval: Duration(time.Minute),
+		},
+		Embed: EmbedConfig{
+			TokenTTL: Duration(15 * time.Minute),
+		},
+		Captcha: CaptchaConfig{
+			SignupsPerIP:  3,
+			SignupWindow:  Duration(time.Hour),
+			LoginFailures: 3,
+		},
+		Passwords: PasswordsConfig{
+			Memory:      64 * 1024,
+			Iterations:  3,
+			Parallelism: 2,
+		},
+		Plans: PlansConfig{
+			Free: PlanLimits{
+				MaxMeetingDuration:       Duration(60 * time.Minute),
+				MaxParticipants:          50,
+				RecordingMinutesPerMonth: 0,
+			},
+			Pro: PlanLimits{
+				MaxMeetingDuration:       Duration(24 * time.Hour),
+				MaxParticipants:          100,
+				RecordingMinutesPerMonth: 1200,
+			},
+		},
+	}
+}
+
+// Load builds the configuration from defaults, the file at path (if any) and
+// the environment, and validates the result
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, fmt.Errorf("loading %s: %w", path, err)
+		}
+	}
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	cfg.AppURL = strings.TrimRight(cfg.AppURL, "/")
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return yaml.Unmarshal(data, c)
+	case ".json":
+		return json.Unmarshal(data, c)
+	default:
+		return fmt.Errorf("unsupported config format %q (use .yaml, .yml or .json)", filepath.Ext(path))
+	}
+}
+
+// applyEnv overrides file values with environment variables
+func (c *Config) applyEnv() error {
+	var errs []error
+	setString := func(name string, dst *string) {
+		if value := os.Getenv(name); value != "" {
+			*dst = value
+		}
+	}
+	setInt := func(name string, dst *int) {
+		if value := os.Getenv(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				return
+			}
+			*dst = n
+		}
+	}
+	setUint := func(name string, dst *uint64) {
+		if value := os.Getenv(name); value != "" {
+			n, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				return
+			}
+			*dst = n
+		}
+	}
+	setDuration := func(name string, dst *Duration) {
+		if value := os.Getenv(name); value != "" {
+			if err := dst.UnmarshalText([]byte(value)); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+		}
+	}
+	setBool := func(name string, dst *bool) {
+		if value := os.Getenv(name); value != "" {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				return
+			}
+			*dst = b
+		}
+	}
+
+	setString("APP_URL", &c.AppURL)
+
+	setString("PORT", &c.Server.Port)
+	setDuration("SERVER_READ_TIMEOUT", &c.Server.ReadTimeout)
+	setDuration("SERVER_WRITE_TIMEOUT", &c.Server.WriteTimeout)
+	setDuration("SERVER_IDLE_TIMEOUT", &c.Server.IdleTimeout)
+	setDuration("SERVER_SHUTDOWN_TIMEOUT", &c.Server.ShutdownTimeout)
+	setInt("HUB_SHARDS", &c.Server.HubShards)
+	setBool("SWAGGER_UI", &c.Server.SwaggerUI)
+	if value := os.Getenv("TRUSTED_PROXIES"); value != "" {
+		var proxies []string
+		for _, proxy := range strings.Split(value, ",") {
+			if proxy = strings.TrimSpace(proxy); proxy != "" {
+				proxies = append(proxies, proxy)
+			}
+		}
+		c.Server.TrustedProxies = proxies
+	}
+
+	// STORAGE=memory keeps the database and uploads in memory with demo
+	// data, so the server runs without MongoDB; the variables below can
+	// still override each part
+	if value := os.Getenv("STORAGE"); value != "" {
+		if value == DatabaseDriverMemory {
+			c.Database.Driver = DatabaseDriverMemory
+			c.Database.Seed = DatabaseSeedDemo
+			c.Storage.Backend = StorageBackendMemory
+		} else {
+			errs = append(errs, fmt.Errorf("STORAGE: %q is not supported; use %q", value, DatabaseDriverMemory))
+		}
+	}
+	setString("DATABASE_DRIVER", &c.Database.Driver)
+	setString("DATABASE_SEED", &c.Database.Seed)
+	setBool("DATABASE_MIGRATE_ON_STARTUP", &c.Database.MigrateOnStartup)
+	setString("MONGODB_URI", &c.Mongo.URI)
+	setString("MONGODB_DATABASE", &c.Mongo.Database)
+	setUint("MONGODB_MAX_POOL_SIZE", &c.Mongo.MaxPoolSize)
+	setUint("MONGODB_MIN_POOL_SIZE", &c.Mongo.MinPoolSize)
+	setUint("MONGODB_MAX_CONNECTING", &c.Mongo.MaxConnecting)
+	setDuration("MONGODB_QUERY_TIMEOUT", &c.Mongo.QueryTimeout)
+	setBool("MONGODB_CHANGE_STREAMS", &c.Mongo.ChangeStreams)
+	setString("POSTGRES_URL", &c.Postgres.URL)
+	setUint("POSTGRES_MAX_CONNS", &c.Postgres.MaxConns)
+	setUint("POSTGRES_MIN_CONNS", &c.Postgres.MinConns)
+	setDuration("POSTGRES_QUERY_TIMEOUT", &c.Postgres.QueryTimeout)
+
+	if value := os.Getenv("ALLOWED_ORIGINS"); value != "" {
+		var origins []string
+		for _, origin := range strings.Split(value, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				origins = append(origins, origin)
+			}
+		}
+		c.CORS.AllowedOrigins = origins
+	}
+
+	setInt("RATE_LIMIT_PER_MINUTE", &c.RateLimit.RequestsPerMinute)
+	setInt("RATE_LIMIT_BURST", &c.RateLimit.Burst)
+	setInt("LOGIN_RATE_LIMIT_PER_MINUTE", &c.RateLimit.LoginPerMinute)
+	setInt("LOGIN_RATE_LIMIT_BURST", &c.RateLimit.LoginBurst)
+	setInt("API_KEY_RATE_LIMIT_PER_MINUTE", &c.RateLimit.APIKeyRequestsPerMinute)
+	setInt("WS_MESSAGES_PER_MINUTE", &c.RateLimit.WebSocket.Default.PerMinute)
+	setInt("WS_MESSAGE_BURST", &c.RateLimit.WebSocket.Default.Burst)
+	setInt("WS_MAX_VIOLATIONS", &c.RateLimit.WebSocket.MaxViolations)
+
+	setInt("DEFAULT_PARTICIPANTS", &c.Meetings.DefaultParticipants)
+	setInt("MAX_PARTICIPANTS", &c.Meetings.MaxParticipants)
+	setInt("MAX_SCREEN_SHARES", &c.Meetings.MaxScreenShares)
+	setDuration("PARTICIPANT_TIMEOUT", &c.Meetings.ParticipantTimeout)
+	setDuration("PARTICIPANT_IDLE_TIMEOUT", &c.Meetings.ParticipantIdleTimeout)
+	setDuration("EMPTY_MEETING_TIMEOUT", &c.Meetings.EmptyMeetingTimeout)
+	setString("RECORDING_POLICY", &c.Meetings.RecordingPolicy)
+	setBool("OVERFLOW_QUEUE", &c.Meetings.OverflowQueue)
+	setDuration("OVERFLOW_ADMIT_WINDOW", &c.Meetings.OverflowAdmitWindow)
+
+	setBool("SFU_MODE", &c.Media.SFUMode)
+	setString("TURN_URL", &c.Media.TURNURL)
+	setInt("SILENCE_LEVEL", &c.Media.SilenceLevel)
+
+	setString("SLACK_CLIENT_ID", &c.Slack.ClientID)
+	setString("SLACK_CLIENT_SECRET", &c.Slack.ClientSecret)
+	setString("SLACK_SIGNING_SECRET", &c.Slack.SigningSecret)
+	setString("SLACK_REDIRECT_URL", &c.Slack.RedirectURL)
+
+	setString("FCM_CREDENTIALS_FILE", &c.Push.FCMCredentialsFile)
+	setString("APNS_KEY_FILE", &c.Push.APNsKeyFile)
+	setString("APNS_KEY_ID", &c.Push.APNsKeyID)
+	setString("APNS_TEAM_ID", &c.Push.APNsTeamID)
+	setString("APNS_TOPIC", &c.Push.APNsTopic)
+	setBool("APNS_PRODUCTION", &c.Push.APNsProduction)
+
+	setString("MAIL_PROVIDER", &c.Mail.Provider)
+	setString("SMTP_FROM", &c.Mail.From)
+	setString("MAIL_FROM", &c.Mail.From)
+	setInt("MAIL_PER_MINUTE", &c.Mail.PerMinute)
+	setInt("MAIL_QUEUE_SIZE", &c.Mail.QueueSize)
+	setString("SMTP_HOST", &c.Mail.SMTPHost)
+	setString("SMTP_PORT", &c.Mail.SMTPPort)
+	setString("SMTP_USERNAME", &c.Mail.SMTPUsername)
+	setString("SMTP_PASSWORD", &c.Mail.SMTPPassword)
+	setString("SENDGRID_API_KEY", &c.Mail.SendGridAPIKey)
+
+	setString("STORAGE_BACKEND", &c.Storage.Backend)
+	setString("STORAGE_GRIDFS_BUCKET", &c.Storage.GridFSBucket)
+	setString("STORAGE_LOCAL_DIR", &c.Storage.LocalDir)
+	setString("S3_BUCKET", &c.Storage.S3Bucket)
+	setString("S3_REGION", &c.Storage.S3Region)
+	setString("S3_ENDPOINT", &c.Storage.S3Endpoint)
+	setBool("S3_PATH_STYLE", &c.Storage.S3PathStyle)
+	setString("AWS_ACCESS_KEY_ID", &c.Storage.S3AccessKeyID)
+	setString("AWS_SECRET_ACCESS_KEY", &c.Storage.S3SecretAccessKey)
+
+	setInt("CHAT_MAX_ATTACHMENT_MB", &c.Chat.MaxAttachmentMB)
+	setString("CHAT_ATTACHMENT_SCAN_URL", &c.Chat.AttachmentScanURL)
+	setString("CHAT_FILTER_DEFAULT", &c.Chat.DefaultFilter)
+	setString("CHAT_MODERATION_URL", &c.Chat.ModerationURL)
+	setString("CHAT_MODERATION_API_KEY", &c.Chat.ModerationAPIKey)
+	if value := os.Getenv("CHAT_BLOCKED_WORDS"); value != "" {
+		var words []string
+		for _, word := range strings.Split(value, ",") {
+			if word = strings.TrimSpace(word); word != "" {
+				words = append(words, word)
+			}
+		}
+		c.Chat.BlockedWords = words
+	}
+
+	setInt("RETENTION_CHAT_DAYS", &c.Retention.ChatDays)
+	setInt("RETENTION_RECORDING_DAYS", &c.Retention.RecordingDays)
+	setDuration("RETENTION_PARTICIPANTS_AFTER_END", &c.Retention.ParticipantsAfterEnd)
+	setDuration("RETENTION_PURGE_INTERVAL", &c.Retention.PurgeInterval)
+	setDuration("RETENTION_ARCHIVE_AFTER", &c.Retention.ArchiveAfter)
+
+	setString("JOBS_CLEANUP_SCHEDULE", &c.Jobs.Cleanup)
+	setString("JOBS_REMINDERS_SCHEDULE", &c.Jobs.Reminders)
+	setString("JOBS_RETENTION_SCHEDULE", &c.Jobs.Retention)
+	setString("JOBS_ANALYTICS_ROLLUP_SCHEDULE", &c.Jobs.AnalyticsRollup)
+
+	setInt("MEETING_CACHE_SIZE", &c.Cache.MeetingCacheSize)
+	setDuration("MEETING_CACHE_TTL", &c.Cache.MeetingTTL)
+	setString("CACHE_REDIS_URL", &c.Cache.RedisURL)
+
+	setString("HANDOVER_REDIS_URL", &c.Handover.RedisURL)
+	setString("HANDOVER_RECONNECT_URL", &c.Handover.ReconnectURL)
+	setDuration("HANDOVER_STATE_TTL", &c.Handover.StateTTL)
+	setDuration("HANDOVER_RECONNECT_WINDOW", &c.Handover.ReconnectWindow)
+	setString("SESSIONS_REDIS_URL", &c.Sessions.RedisURL)
+	setDuration("SESSIONS_IDLE_TIMEOUT", &c.Sessions.IdleTimeout)
+	setDuration("SESSIONS_MAX_LIFETIME", &c.Sessions.MaxLifetime)
+	setString("REGION", &c.Regions.Local)
+	setString("REGION_CLIENT_HEADER", &c.Regions.ClientHeader)
+	setInt("HUB_REPLAY_MAX_BYTES", &c.Hub.ReplayMaxBytes)
+	setInt("HUB_REPLAY_TOTAL_MAX_BYTES", &c.Hub.ReplayTotalMaxBytes)
+	setInt("HUB_SEND_QUEUE_MAX_BYTES", &c.Hub.SendQueueMaxBytes)
+
+	if value := os.Getenv("TLS_DOMAINS"); value != "" {
+		var domains []string
+		for _, domain := range strings.Split(value, ",") {
+			if domain = strings.TrimSpace(domain); domain != "" {
+				domains = append(domains, domain)
+			}
+		}
+		c.TLS.Domains = domains
+	}
+	setString("TLS_EMAIL", &c.TLS.Email)
+	setString("TLS_CACHE_DIR", &c.TLS.CacheDir)
+	setString("TLS_HTTP_PORT", &c.TLS.HTTPPort)
+	setString("TLS_ACME_DIRECTORY_URL", &c.TLS.DirectoryURL)
+	setDuration("TLS_HSTS_MAX_AGE", &c.TLS.HSTSMaxAge)
+	setBool("TLS_HSTS_INCLUDE_SUBDOMAINS", &c.TLS.HSTSIncludeSubdomains)
+
+	setString("REDIS_URL", &c.Presence.RedisURL)
+	setDuration("PRESENCE_TTL", &c.Presence.TTL)
+	setDuration("PRESENCE_HEARTBEAT_INTERVAL", &c.Presence.HeartbeatInterval)
+
+	setString("TRANSCRIPTION_PROVIDER", &c.Transcription.Provider)
+	setString("TRANSCRIPTION_LANGUAGE", &c.Transcription.Language)
+	setInt("TRANSCRIPTION_WORKERS", &c.Transcription.Workers)
+	setDuration("TRANSCRIPTION_CAPTION_INTERVAL", &c.Transcription.CaptionInterval)
+	setString("OPENAI_API_KEY", &c.Transcription.WhisperAPIKey)
+	setString("WHISPER_MODEL", &c.Transcription.WhisperModel)
+	setString("WHISPER_URL", &c.Transcription.WhisperURL)
+	setString("GOOGLE_STT_API_KEY", &c.Transcription.GoogleAPIKey)
+
+	setInt("RECORDING_MAX_UPLOAD_MB", &c.Recordings.MaxUploadMB)
+	setDuration("RECORDING_UPLOAD_WINDOW", &c.Recordings.UploadWindow)
+	setString("FFMPEG_PATH", &c.Recordings.FFmpegPath)
+	setDuration("RECORDING_PREVIEW_OFFSET", &c.Recordings.PreviewOffset)
+	setDuration("RECORDING_PREVIEW_LENGTH", &c.Recordings.PreviewLength)
+	setDuration("RECORDING_PREVIEW_INTERVAL", &c.Recordings.PreviewInterval)
+
+	if value := os.Getenv("EMBED_ALLOWED_DOMAINS"); value != "" {
+		var domains []string
+		for _, domain := range strings.Split(value, ",") {
+			if domain = strings.TrimSpace(domain); domain != "" {
+				domains = append(domains, domain)
+			}
+		}
+		c.Embed.AllowedDomains = domains
+	}
+	setDuration("EMBED_TOKEN_TTL", &c.Embed.TokenTTL)
+
+	setInt("PASSWORD_ARGON2_MEMORY", &c.Passwords.Memory)
+	setInt("PASSWORD_ARGON2_ITERATIONS", &c.Passwords.Iterations)
+	setInt("PASSWORD_ARGON2_PARALLELISM", &c.Passwords.Parallelism)
+
+	setString("CAPTCHA_PROVIDER", &c.Captcha.Provider)
+	setString("CAPTCHA_SITE_KEY", &c.Captcha.SiteKey)
+	setString("CAPTCHA_SECRET_KEY", &c.Captcha.SecretKey)
+	setBool("CAPTCHA_ALWAYS", &c.Captcha.Always)
+	setInt("CAPTCHA_SIGNUPS_PER_IP", &c.Captcha.SignupsPerIP)
+	setDuration("CAPTCHA_SIGNUP_WINDOW", &c.Captcha.SignupWindow)
+	setInt("CAPTCHA_LOGIN_FAILURES", &c.Captcha.LoginFailures)
+	setString("ENCRYPTION_PROVIDER", &c.Encryption.Provider)
+	setString("ENCRYPTION_MASTER_KEY", &c.Encryption.MasterKey)
+	setString("ENCRYPTION_VAULT_ADDRESS", &c.Encryption.VaultAddress)
+	setString("ENCRYPTION_VAULT_TOKEN", &c.Encryption.VaultToken)
+	setString("ENCRYPTION_VAULT_KEY", &c.Encryption.VaultKey)
+
+	setDuration("PLAN_FREE_MAX_MEETING_DURATION", &c.Plans.Free.MaxMeetingDuration)
+	setInt("PLAN_FREE_MAX_PARTICIPANTS", &c.Plans.Free.MaxParticipants)
+	setInt("PLAN_FREE_RECORDING_MINUTES", &c.Plans.Free.RecordingMinutesPerMonth)
+	setDuration("PLAN_PRO_MAX_MEETING_DURATION", &c.Plans.Pro.MaxMeetingDuration)
+	setInt("PLAN_PRO_MAX_PARTICIPANTS", &c.Plans.Pro.MaxParticipants)
+	setInt("PLAN_PRO_RECORDING_MINUTES", &c.Plans.Pro.RecordingMinutesPerMonth)
+
+	return errors.Join(errs...)
+}
+
+// Validate reports every invalid setting at once
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	if parsed, err := url.Parse(c.AppURL); err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		errs = append(errs, fmt.Errorf("appUrl %q must be an absolute URL", c.AppURL))
+	}
+
+	if port, err := strconv.Atoi(c.Server.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("server.port %q must be a number between 1 and 65535", c.Server.Port))
+	}
+	check(c.Server.ReadTimeout > 0, "server.readTimeout must be positive")
+	check(c.Server.WriteTimeout > 0, "server.writeTimeout must be positive")
+	check(c.Server.IdleTimeout > 0, "server.idleTimeout must be positive")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdownTimeout must be positive")
+	check(c.Server.HubShards > 0, "server.hubShards must be positive")
+	for _, proxy := range c.Server.TrustedProxies {
+		_, prefixErr := netip.ParsePrefix(proxy)
+		_, addrErr := netip.ParseAddr(proxy)
+		check(prefixErr == nil || addrErr == nil,
+			"server.trustedProxies entry %q must be a CIDR range or IP address", proxy)
+	}
+
+	switch c.Database.Driver {
+	case DatabaseDriverMongo:
+		check(c.Mongo.URI != "", "mongo.uri is required")
+		check(c.Mongo.Database != "", "mongo.database is required")
+		check(c.Mongo.MaxPoolSize > 0, "mongo.maxPoolSize must be positive")
+		check(c.Mongo.MinPoolSize <= c.Mongo.MaxPoolSize, "mongo.minPoolSize must not exceed mongo.maxPoolSize")
+		check(c.Mongo.MaxConnecting > 0, "mongo.maxConnecting must be positive")
+		check(c.Mongo.ConnectTimeout > 0, "mongo.connectTimeout must be positive")
+		check(c.Mongo.ServerSelectionTimeout > 0, "mongo.serverSelectionTimeout must be positive")
+		check(c.Mongo.HeartbeatInterval > 0, "mongo.heartbeatInterval must be positive")
+		check(c.Mongo.QueryTimeout >= 0, "mongo.queryTimeout must not be negative")
+	case DatabaseDriverPostgres:
+		check(c.Postgres.URL != "", "postgres.url is required")
+		check(c.Postgres.MaxConns > 0 && c.Postgres.MaxConns <= math.MaxInt32, "postgres.maxConns must be positive")
+		check(c.Postgres.MinConns <= c.Postgres.MaxConns, "postgres.minConns must not exceed postgres.maxConns")
+		check(c.Postgres.ConnectTimeout > 0, "postgres.connectTimeout must be positive")
+		check(c.Postgres.QueryTimeout >= 0, "postgres.queryTimeout must not be negative")
+	case DatabaseDriverMemory:
+	default:
+		check(false, "database.driver %q is not supported; use %q, %q or %q",
+			c.Database.Driver, DatabaseDriverMongo, DatabaseDriverPostgres, DatabaseDriverMemory)
+	}
+	check(c.Database.Driver == DatabaseDriverMongo || !c.Mongo.ChangeStreams,
+		"mongo.changeStreams needs the %q database driver", DatabaseDriverMongo)
+
+	check(len(c.CORS.AllowedOrigins) > 0, "cors.allowedOrigins must list at least one origin")
+	for _, origin := range c.CORS.AllowedOrigins {
+		// *. allows the host's subdomains
+		parsed, err := url.Parse(strings.Replace(origin, "://*.", "://", 1))
+		check(err == nil && parsed.Scheme != "" && parsed.Host != "" && parsed.Path == "" && !strings.Contains(parsed.Host, "*"),
+			"cors.allowedOrigins entry %q must be a scheme and host, e.g. https://example.com or https://*.example.com", origin)
+	}
+
+	check(c.RateLimit.RequestsPerMinute > 0, "rateLimit.requestsPerMinute must be positive")
+	check(c.RateLimit.Burst > 0, "rateLimit.burst must be positive")
+	check(c.RateLimit.LoginPerMinute > 0, "rateLimit.loginPerMinute must be positive")
+	check(c.RateLimit.LoginBurst > 0, "rateLimit.loginBurst must be positive")
+	check(c.RateLimit.EvictAfter > 0, "rateLimit.evictAfter must be positive")
+	check(c.RateLimit.APIKeyRequestsPerMinute > 0, "rateLimit.apiKeyRequestsPerMinute must be positive")
+	checkBudget := func(name string, budget MessageBudget) {
+		check(budget.PerMinute > 0 && budget.Burst > 0 && budget.MaxBytes > 0,
+			"rateLimit.webSocket %s budget needs positive perMinute, burst and maxBytes", name)
+	}
+	checkBudget("default", c.RateLimit.WebSocket.Default)
+	for messageType, budget := range c.RateLimit.WebSocket.Types {
+		checkBudget(messageType, budget)
+	}
+	checkChannelBudget := func(name string, budget MessageBudget) {
+		check(budget.PerMinute > 0 && budget.Burst > 0 && budget.MaxBytes > 0,
+			"rateLimit.dataChannels %s budget needs positive perMinute, burst and maxBytes", name)
+	}
+	checkChannelBudget("default", c.RateLimit.DataChannels.Default)
+	for label, budget := range c.RateLimit.DataChannels.Channels {
+		checkChannelBudget(label, budget)
+	}
+	check(c.RateLimit.WebSocket.MaxViolations > 0, "rateLimit.webSocket.maxViolations must be positive")
+	check(c.RateLimit.WebSocket.ViolationWindow > 0, "rateLimit.webSocket.violationWindow must be positive")
+
+	check(c.Meetings.MaxParticipants > 0, "meetings.maxParticipants must be positive")
+	check(c.Meetings.DefaultParticipants > 0 && c.Meetings.DefaultParticipants <= c.Meetings.MaxParticipants,
+		"meetings.defaultParticipants must be between 1 and meetings.maxParticipants")
+	check(c.Meetings.MaxScreenShares > 0, "meetings.maxScreenShares must be positive")
+	check(c.Meetings.ParticipantTimeout > 0, "meetings.participantTimeout must be positive")
+	check(c.Meetings.ParticipantIdleTimeout > 0 && c.Meetings.ParticipantIdleTimeout < c.Meetings.ParticipantTimeout,
+		"meetings.participantIdleTimeout must be positive and shorter than meetings.participantTimeout")
+	check(c.Meetings.EmptyMeetingTimeout > 0, "meetings.emptyMeetingTimeout must be positive")
+	check(c.Meetings.OverflowAdmitWindow > 0, "meetings.overflowAdmitWindow must be positive")
+	switch c.Meetings.RecordingPolicy {
+	case RecordingPolicyDisabled, RecordingPolicyHostOnly, RecordingPolicyAnyone:
+	default:
+		errs = append(errs, fmt.Errorf("meetings.recordingPolicy %q must be disabled, host-only or anyone", c.Meetings.RecordingPolicy))
+	}
+
+	check(c.Media.SilenceLevel >= 0 && c.Media.SilenceLevel <= 127, "media.silenceLevel must be between 0 and 127")
+	if c.Media.TURNURL != "" {
+		parsed, err := url.Parse(c.Media.TURNURL)
+		check(err == nil && (parsed.Scheme == "turn" || parsed.Scheme == "turns") && parsed.Opaque != "",
+			"media.turnUrl %q must be a turn: or turns: URI", c.Media.TURNURL)
+	}
+
+	if c.Slack.RedirectURL != "" {
+		parsed, err := url.Parse(c.Slack.RedirectURL)
+		check(err == nil && parsed.Scheme == "https" && parsed.Host != "",
+			"slack.redirectUrl %q must be an https URL", c.Slack.RedirectURL)
+	}
+
+	switch c.Mail.ResolvedProvider() {
+	case MailProviderLog:
+	case MailProviderSMTP:
+		check(c.Mail.SMTPHost != "", "mail.smtpHost is required for the smtp provider")
+	case MailProviderSendGrid:
+		check(c.Mail.SendGridAPIKey != "", "mail.sendgridApiKey is required for the sendgrid provider")
+	default:
+		errs = append(errs, fmt.Errorf("mail.provider %q must be log, smtp or sendgrid", c.Mail.Provider))
+	}
+	check(c.Mail.From != "", "mail.from is required")
+	check(c.Mail.PerMinute > 0, "mail.perMinute must be positive")
+	check(c.Mail.QueueSize > 0, "mail.queueSize must be positive")
+
+	switch c.Storage.Backend {
+	case StorageBackendGridFS:
+		check(c.Storage.GridFSBucket != "", "storage.gridfsBucket is required for the gridfs backend")
+		check(c.Database.Driver == DatabaseDriverMongo,
+			"storage.backend gridfs needs the %q database driver", DatabaseDriverMongo)
+	case StorageBackendLocal:
+		check(c.Storage.LocalDir != "", "storage.localDir is required for the local backend")
+	case StorageBackendS3:
+		check(c.Storage.S3Bucket != "", "storage.s3Bucket is required for the s3 backend")
+		check(c.Storage.S3Region != "", "storage.s3Region is required for the s3 backend")
+		check(c.Storage.S3AccessKeyID != "" && c.Storage.S3SecretAccessKey != "",
+			"storage.s3AccessKeyId and storage.s3SecretAccessKey are required for the s3 backend")
+		if c.Storage.S3Endpoint != "" {
+			parsed, err := url.Parse(c.Storage.S3Endpoint)
+			check(err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != "",
+				"storage.s3Endpoint %q must be an http or https URL", c.Storage.S3Endpoint)
+		}
+	case StorageBackendMemory:
+	default:
+		errs = append(errs, fmt.Errorf("storage.backend %q must be gridfs, s3, local or memory", c.Storage.Backend))
+	}
+
+	check(c.Chat.MaxAttachmentMB > 0, "chat.maxAttachmentMb must be positive")
+	for _, ext := range c.Chat.AttachmentTypes {
+		check(strings.HasPrefix(ext, ".") && len(ext) > 1, "chat.attachmentTypes entry %q must be an extension such as .pdf", ext)
+	}
+	if c.Chat.AttachmentScanURL != "" {
+		parsed, err := url.Parse(c.Chat.AttachmentScanURL)
+		check(err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != "",
+			"chat.attachmentScanUrl %q must be an http or https URL", c.Chat.AttachmentScanURL)
+	}
+	check(IsValidChatFilter(c.Chat.DefaultFilter), "chat.defaultFilter %q must be off, moderate or strict", c.Chat.DefaultFilter)
+	for _, pattern := range c.Chat.BlockedPatterns {
+		_, err := regexp.Compile(pattern)
+		check(err == nil, "chat.blockedPatterns entry %q is not a valid regular expression", pattern)
+	}
+	if c.Chat.ModerationURL != "" {
+		parsed, err := url.Parse(c.Chat.ModerationURL)
+		check(err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != "",
+			"chat.moderationUrl %q must be an http or https URL", c.Chat.ModerationURL)
+	}
+
+	check(c.Retention.ChatDays >= 0, "retention.chatDays must not be negative")
+	check(c.Retention.RecordingDays >= 0, "retention.recordingDays must not be negative")
+	check(c.Retention.ParticipantsAfterEnd >= 0, "retention.participantsAfterEnd must not be negative")
+	check(c.Retention.PurgeInterval > 0, "retention.purgeInterval must be positive")
+	check(c.Retention.ArchiveAfter >= 0, "retention.archiveAfter must not be negative")
+
+	jobSchedules := []struct{ name, spec string }{
+		{"cleanup", c.Jobs.Cleanup},
+		{"reminders", c.Jobs.Reminders},
+		{"retention", c.Jobs.Retention},
+		{"analyticsRollup", c.Jobs.AnalyticsRollup},
+	}
+	for _, job := range jobSchedules {
+		if job.name == "retention" && job.spec == "" {
+			continue
+		}
+		if _, err := schedule.Parse(job.spec); err != nil {
+			errs = append(errs, fmt.Errorf("jobs.%s: %w", job.name, err))
+		}
+	}
+
+	check(c.Recordings.MaxUploadMB > 0, "recordings.maxUploadMb must be positive")
+	check(c.Recordings.UploadWindow > 0, "recordings.uploadWindow must be positive")
+	check(c.Recordings.PreviewOffset >= 0, "recordings.previewOffset must not be negative")
+	check(c.Recordings.PreviewLength > 0, "recordings.previewLength must be positive")
+	check(c.Recordings.PreviewInterval > 0, "recordings.previewInterval must be positive")
+
+	for _, domain := range c.Embed.AllowedDomains {
+		host := strings.TrimPrefix(domain, "*.")
+		check(host != "" && !strings.ContainsAny(host, ":/* ") && strings.Contains(host, "."),
+			"embed.allowedDomains entry %q must be a host such as partner.com or *.partner.com", domain)
+	}
+	check(c.Embed.TokenTTL > 0 && c.Embed.TokenTTL <= Duration(24*time.Hour),
+		"embed.tokenTtl must be between 0 and 24h")
+
+	check(c.Passwords.Memory >= 8*1024 && c.Passwords.Memory <= 4*1024*1024,
+		"passwords.memory must be between 8192 and 4194304 KiB")
+	check(c.Passwords.Iterations > 0 && c.Passwords.Iterations <= 100, "passwords.iterations must be between 1 and 100")
+	check(c.Passwords.Parallelism > 0 && c.Passwords.Parallelism <= 255, "passwords.parallelism must be between 1 and 255")
+
+	switch c.Captcha.Provider {
+	case "":
+	case CaptchaProviderHCaptcha, CaptchaProviderRecaptcha, CaptchaProviderTurnstile:
+		check(c.Captcha.SiteKey != "", "captcha.siteKey is required")
+		check(c.Captcha.SecretKey != "", "captcha.secretKey is required")
+		check(c.Captcha.SignupsPerIP >= 0, "captcha.signupsPerIp must not be negative")
+		check(c.Captcha.SignupWindow > 0, "captcha.signupWindow must be positive")
+		check(c.Captcha.LoginFailures >= 0, "captcha.loginFailures must not be negative")
+	default:
+		errs = append(errs, fmt.Errorf("captcha.provider %q must be hcaptcha, recaptcha or turnstile", c.Captcha.Provider))
+	}
+
+	tenantIDs := make(map[string]bool)
+	tenantDomains := make(map[string]bool)
+	for _, tenant := range c.Tenants {
+		check(tenantIDPattern.MatchString(tenant.ID),
+			"tenants entry %q must have an id of lowercase letters, digits and dashes", tenant.ID)
+		check(!tenantIDs[tenant.ID], "tenants id %q is used twice", tenant.ID)
+		tenantIDs[tenant.ID] = true
+		check(tenant.Name != "", "tenants entry %q needs a name", tenant.ID)
+		check(len(tenant.Domains) > 0, "tenants entry %q must list at least one domain", tenant.ID)
+		for _, domain := range tenant.Domains {
+			domain = strings.ToLower(domain)
+			check(domain != "" && !strings.ContainsAny(domain, "/:*"),
+				"tenants entry %q domain %q must be a host name, e.g. meet.example.com", tenant.ID, domain)
+			check(!tenantDomains[domain], "tenants domain %q is claimed twice", domain)
+			tenantDomains[domain] = true
+		}
+		for _, origin := range tenant.AllowedOrigins {
+			parsed, err := url.Parse(strings.Replace(origin, "://*.", "://", 1))
+			check(err == nil && parsed.Scheme != "" && parsed.Host != "" && parsed.Path == "" && !strings.Contains(parsed.Host, "*"),
+				"tenants entry %q origin %q must be a scheme and host, e.g. https://example.com", tenant.ID, origin)
+		}
+		if logo := tenant.Branding.LogoURL; logo != "" {
+			parsed, err := url.Parse(logo)
+			check(err == nil && parsed.Scheme == "https" && parsed.Host != "",
+				"tenants entry %q branding.logoUrl must be an https URL", tenant.ID)
+		}
+		check(tenant.Branding.PrimaryColor == "" || colorPattern.MatchString(tenant.Branding.PrimaryColor),
+			"tenants entry %q branding.primaryColor must be a hex color such as #1a73e8", tenant.ID)
+	}
+
+	switch c.Encryption.Provider {
+	case "":
+	case EncryptionProviderEnv:
+		key, err := base64.StdEncoding.DecodeString(c.Encryption.MasterKey)
+		check(err == nil && len(key) == 32, "encryption.masterKey must be 32 bytes in base64")
+	case EncryptionProviderVault:
+		parsed, err := url.Parse(c.Encryption.VaultAddress)
+		check(err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != "",
+			"encryption.vaultAddress must be an http or https URL")
+		check(c.Encryption.VaultToken != "", "encryption.vaultToken is required")
+		check(c.Encryption.VaultKey != "", "encryption.vaultKey is required")
+	default:
+		errs = append(errs, fmt.Errorf("encryption.provider %q must be env or vault", c.Encryption.Provider))
+	}
+
+	check(c.Cache.MeetingCacheSize >= 0, "cache.meetingCacheSize must not be negative")
+	check(c.Cache.MeetingTTL > 0, "cache.meetingTtl must be positive")
+	if c.Cache.RedisURL != "" {
+		parsed, err := url.Parse(c.Cache.RedisURL)
+		check(err == nil && (parsed.Scheme == "redis" || parsed.Scheme == "rediss"),
+			"cache.redisUrl must be a redis:// or rediss:// URL")
+	}
+
+	if c.TLS.Enabled() {
+		for _, domain := range c.TLS.Domains {
+			check(!strings.ContainsAny(domain, ":/ ") && strings.Contains(domain, "."),
+				"tls.domains entry %q must be a host name", domain)
+		}
+		check(c.TLS.CacheDir != "", "tls.cacheDir is required when tls.domains is set")
+		if port, err := strconv.Atoi(c.TLS.HTTPPort); err != nil || port < 1 || port > 65535 {
+			errs = append(errs, fmt.Errorf("tls.httpPort %q must be a number between 1 and 65535", c.TLS.HTTPPort))
+		}
+		check(c.TLS.HTTPPort != c.Server.Port, "tls.httpPort must differ from server.port")
+		if c.TLS.DirectoryURL != "" {
+			parsed, err := url.Parse(c.TLS.DirectoryURL)
+			check(err == nil && parsed.Scheme == "https" && parsed.Host != "", "tls.directoryUrl must be an https URL")
+		}
+	}
+	check(c.TLS.HSTSMaxAge >= 0, "tls.hstsMaxAge must not be negative")
+
+	check(c.Presence.HeartbeatInterval > 0, "presence.heartbeatInterval must be positive")
+	check(c.Presence.TTL > c.Presence.HeartbeatInterval, "presence.ttl must be longer than presence.heartbeatInterval")
+	if c.Presence.RedisURL != "" {
+		parsed, err := url.Parse(c.Presence.RedisURL)
+		check(err == nil && (parsed.Scheme == "redis" || parsed.Scheme == "rediss"),
+			"presence.redisUrl must be a redis:// or rediss:// URL")
+	}
+
+	if c.Handover.RedisURL != "" {
+		parsed, err := url.Parse(c.Handover.RedisURL)
+		check(err == nil && (parsed.Scheme == "redis" || parsed.Scheme == "rediss"),
+			"handover.redisUrl must be a redis:// or rediss:// URL")
+	}
+	if c.Handover.ReconnectURL != "" {
+		parsed, err := url.Parse(c.Handover.ReconnectURL)
+		check(err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != "",
+			"handover.reconnectUrl must be an http or https URL")
+	}
+	check(c.Handover.StateTTL > 0, "handover.stateTtl must be positive")
+	check(c.Handover.ReconnectWindow >= 0, "handover.reconnectWindow must not be negative")
+
+	if c.Sessions.RedisURL != "" {
+		parsed, err := url.Parse(c.Sessions.RedisURL)
+		check(err == nil && (parsed.Scheme == "redis" || parsed.Scheme == "rediss"),
+			"sessions.redisUrl must be a redis:// or rediss:// URL")
+	}
+	check(c.Sessions.IdleTimeout > 0, "sessions.idleTimeout must be positive")
+	check(c.Sessions.MaxLifetime >= c.Sessions.IdleTimeout, "sessions.maxLifetime must not be shorter than sessions.idleTimeout")
+
+	regions := make(map[string]bool, len(c.Regions.Endpoints))
+	for i, endpoint := range c.Regions.Endpoints {
+		check(endpoint.Name != "" && !regions[endpoint.Name], "regions.endpoints[%d].name must be set and unique", i)
+		regions[endpoint.Name] = true
+		parsed, err := url.Parse(endpoint.URL)
+		check(err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != "",
+			"regions.endpoints[%d].url must be an http or https URL", i)
+	}
+	if c.Regions.Local != "" {
+		check(regions[c.Regions.Local], "regions.local %q must be one of regions.endpoints", c.Regions.Local)
+		check(c.Regions.ClientHeader != "", "regions.clientHeader must be set when regions.local is")
+	}
+	check(c.Regions.MinParticipantsToMove >= 1, "regions.minParticipantsToMove must be at least 1")
+	check(c.Regions.MoveCooldown >= 0, "regions.moveCooldown must not be negative")
+	check(c.Hub.ReplayMaxBytes > 0, "hub.replayMaxBytes must be positive")
+	check(c.Hub.ReplayTotalMaxBytes >= c.Hub.ReplayMaxBytes, "hub.replayTotalMaxBytes must be at least hub.replayMaxBytes")
+	check(c.Hub.SendQueueMaxBytes > 0, "hub.sendQueueMaxBytes must be positive")
+
+	switch c.Transcription.Provider {
+	case "":
+	case STTProviderWhisper:
+		check(c.Transcription.WhisperAPIKey != "", "transcription.whisperApiKey is required for the whisper provider")
+		check(c.Transcription.WhisperModel != "", "transcription.whisperModel is required for the whisper provider")
+		parsed, err := url.Parse(c.Transcription.WhisperURL)
+		check(err == nil && parsed.Scheme != "" && parsed.Host != "",
+			"transcription.whisperUrl %q must be an absolute URL", c.Transcription.WhisperURL)
+	case STTProviderGoogle:
+		check(c.Transcription.GoogleAPIKey != "", "transcription.googleApiKey is required for the google provider")
+	default:
+		errs = append(errs, fmt.Errorf("transcription.provider %q must be whisper or google", c.Transcription.Provider))
+	}
+	if c.Transcription.Provider != "" {
+		check(c.Media.SFUMode, "transcription requires media.sfuMode")
+		check(c.Transcription.Language != "", "transcription.language is required")
+		check(c.Transcription.Workers > 0, "transcription.workers must be positive")
+		check(c.Transcription.CaptionInterval >= 0, "transcription.captionInterval must not be negative")
+	}
+
+	for name, limits := range map[string]PlanLimits{PlanFree: c.Plans.Free, PlanPro: c.Plans.Pro} {
+		check(limits.MaxMeetingDuration > 0, "plans.%s.maxMeetingDuration must be positive", name)
+		check(limits.MaxParticipants > 0, "plans.%s.maxParticipants must be positive", name)
+		check(limits.RecordingMinutesPerMonth >= 0, "plans.%s.recordingMinutesPerMonth must not be negative", name)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+)
+
+const (
+	// QualityWindowSize is how many recent stats reports a participant's
+	// aggregates cover
+	QualityWindowSize = 10
+	// Aggregates not refreshed for this long are left out of the quality
+	// listing, as the participant stopped reporting
+	QualityStaleAfter = 1 * time.Minute
+
+	// Averages past these limits rate a connection fair or poor
+	fairRTTMillis  = 200
+	poorRTTMillis  = 400
+	fairPacketLoss = 3  // percent
+	poorPacketLoss = 10 // percent
+	maxRTTMillis   = 60000
+)
+
+// Connection quality ratings
+const (
+	QualityGood = "good"
+	QualityFair = "fair"
+	QualityPoor = "poor"
+)
+
+// qualityRank orders ratings worst first
+var qualityRank = map[string]int{QualityPoor: 0, QualityFair: 1, QualityGood: 2}
+
+// ConnectionQuality aggregates a participant's recent stats reports
+type ConnectionQuality struct {
+	ID           string    `json:"-" bson:"_id"` // meetingId:userId
+	MeetingID    string    `json:"meetingId" bson:"meetingId"`
+	UserID       string    `json:"userId" bson:"userId"`
+	UserName     string    `json:"userName" bson:"userName"`
+	Samples      int       `json:"samples" bson:"samples"` // reports in the window
+	RTTMillis    float64   `json:"rttMillis" bson:"rttMillis"`
+	MaxRTTMillis float64   `json:"maxRttMillis" bson:"maxRttMillis"`
+	PacketLoss   float64   `json:"packetLoss" bson:"packetLoss"` // percent
+	BitrateKbps  float64   `json:"bitrateKbps" bson:"bitrateKbps"`
+	Rating       string    `json:"rating" bson:"rating"`
+	UpdatedAt    time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+// qualitySample is one stats report
+type qualitySample struct {
+	RTTMillis   float64 `json:"rtt"`
+	PacketLoss  float64 `json:"packetLoss"`
+	BitrateKbps float64 `json:"bitrate"`
+}
+
+// qualityWindow holds a client's most recent stats reports
+type qualityWindow struct {
+	samples [QualityWindowSize]qualitySample
+	next    int
+	count   int
+}
+
+func (q *qualityWindow) add(sample qualitySample) {
+	q.samples[q.next] = sample
+	q.next = (q.next + 1) % QualityWindowSize
+	q.count = min(q.count+1, QualityWindowSize)
+}
+
+// aggregate averages the window and rates the connection
+func (q *qualityWindow) aggregate() ConnectionQuality {
+	var agg ConnectionQuality
+	for _, sample := range q.samples[:q.count] {
+		agg.RTTMillis += sample.RTTMillis
+		agg.MaxRTTMillis = max(agg.MaxRTTMillis, sample.RTTMillis)
+		agg.PacketLoss += sample.PacketLoss
+		agg.BitrateKbps += sample.BitrateKbps
+	}
+	agg.Samples = q.count
+	if q.count > 0 {
+		agg.RTTMillis /= float64(q.count)
+		agg.PacketLoss /= float64(q.count)
+		agg.BitrateKbps /= float64(q.count)
+	}
+
+	switch {
+	case agg.RTTMillis > poorRTTMillis || agg.PacketLoss > poorPacketLoss:
+		agg.Rating = QualityPoor
+	case agg.RTTMillis > fairRTTMillis || agg.PacketLoss > fairPacketLoss:
+		agg.Rating = QualityFair
+	default:
+		agg.Rating = QualityGood
+	}
+	return agg
+}
+
+// handleStats records a client's periodic connection stats report and
+// stores the aggregates of its recent reports
+func handleStats(ctx context.Context, c *Client, data json.RawMessage) {
+	var sample qualitySample
+	if err := json.Unmarshal(data, &sample); err != nil {
+		c.sendError("Invalid stats report")
+		return
+	}
+	if sample.RTTMillis < 0 || sample.RTTMillis > maxRTTMillis ||
+		sample.PacketLoss < 0 || sample.PacketLoss > 100 || sample.BitrateKbps < 0 {
+		c.sendError("Stats report values are out of range")
+		return
+	}
+
+	// Messages from one client are handled in order on its read loop, so
+	// the window needs no lock
+	if c.quality == nil {
+		c.quality = &qualityWindow{}
+	}
+	c.quality.add(sample)
+	agg := c.quality.aggregate()
+
+	_, err := db.ConnectionQuality.UpdateOne(
+		ctx,
+		bson.M{"_id": c.meetingID + ":" + c.userID},
+		bson.M{"$set": bson.M{
+			"meetingId":    c.meetingID,
+			"userId":       c.userID,
+			"userName":     c.userName,
+			"samples":      agg.Samples,
+			"rttMillis":    agg.RTTMillis,
+			"maxRttMillis": agg.MaxRTTMillis,
+			"packetLoss":   agg.PacketLoss,
+			"bitrateKbps":  agg.BitrateKbps,
+			"rating":       agg.Rating,
+			"updatedAt":    time.Now(),
+		}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		log.Printf("Error saving connection quality for %s in meeting %s: %v", c.userID, c.meetingID, err)
+	}
+}
+
+// getMeetingQualityHandler lists the connection quality of participants who
+// reported stats recently, worst first, so the host can see who has a bad
+// connection
+func getMeetingQualityHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	meeting, err := findMeeting(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+	if meeting.CreatedBy != userID {
+		sendCodedError(w, ErrCodeHostOnly, "Only the host can view connection quality")
+		return
+	}
+
+	cursor, err := db.ConnectionQuality.Find(r.Context(), bson.M{
+		"meetingId": meeting.ID,
+		"updatedAt": bson.M{"$gte": time.Now().Add(-QualityStaleAfter)},
+	})
+	if err != nil {
+		sendErrorResponse(w, "Failed to fetch connection quality", http.StatusInternalServerError)
+		return
+	}
+	participants := []ConnectionQuality{}
+	if err := cursor.All(r.Context(), &participants); err != nil {
+		sendErrorResponse(w, "Failed to fetch connection quality", http.StatusInternalServerError)
+		return
+	}
+
+	sort.Slice(participants, func(i, j int) bool {
+		a, b := participants[i], participants[j]
+		if qualityRank[a.Rating] != qualityRank[b.Rating] {
+			return qualityRank[a.Rating] < qualityRank[b.Rating]
+		}
+		if a.PacketLoss != b.PacketLoss {
+			return a.PacketLoss > b.PacketLoss
+		}
+		return a.RTTMillis > b.RTTMillis
+	})
+	sendSuccessResponse(w, participants)
+}
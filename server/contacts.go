@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+)
+
+const (
+	MaxContactsPerImport  = 500
+	MaxQuickInvites       = 50
+	MaxContactSearchLimit = 100
+)
+
+// Contact is an entry in a user's address book. UserID is set when the email
+// belongs to a registered user.
+type Contact struct {
+	ID        string    `json:"id" bson:"_id"`
+	OwnerID   string    `json:"ownerId" bson:"ownerId"`
+	Email     string    `json:"email" bson:"email"`
+	Name      string    `json:"name,omitempty" bson:"name,omitempty"`
+	UserID    string    `json:"userId,omitempty" bson:"userId,omitempty"`
+	AvatarURL string    `json:"avatarUrl,omitempty" bson:"-"`
+	Online    bool      `json:"online" bson:"-"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+}
+
+// upsertContacts adds the emails to the owner's contacts, linking registered
+// users and keeping existing entries
+func upsertContacts(ctx context.Context, ownerID string, emails []string, names map[string]string) ([]Contact, error) {
+	cursor, err := db.Users.Find(ctx, bson.M{"email": bson.M{"$in": emails}})
+	if err != nil {
+		return nil, err
+	}
+	var users []User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	usersByEmail := make(map[string]User, len(users))
+	for _, user := range users {
+		usersByEmail[user.Email] = user
+	}
+
+	now := time.Now()
+	contacts := make([]Contact, 0, len(emails))
+	for _, email := range emails {
+		user := usersByEmail[email]
+		if user.ID == ownerID {
+			continue
+		}
+		name := names[email]
+		if name == "" {
+			name = user.Name
+		}
+
+		set := bson.M{}
+		if name != "" {
+			set["name"] = name
+		}
+		if user.ID != "" {
+			set["userId"] = user.ID
+		}
+		update := bson.M{"$setOnInsert": bson.M{"_id": uuid.New().String(), "createdAt": now}}
+		if len(set) > 0 {
+			update["$set"] = set
+		}
+
+		var contact Contact
+		err := db.Contacts.FindOneAndUpdate(
+			ctx,
+			bson.M{"ownerId": ownerID, "email": email},
+			update,
+			options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+		).Decode(&contact)
+		if err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, contact)
+	}
+	return contacts, nil
+}
+
+// decorateContacts fills in avatars and online status of registered contacts
+func decorateContacts(ctx context.Context, contacts []Contact) {
+	var userIDs []string
+	for _, contact := range contacts {
+		if contact.UserID != "" {
+			userIDs = append(userIDs, contact.UserID)
+		}
+	}
+	if len(userIDs) == 0 {
+		return
+	}
+
+	avatars := make(map[string]string)
+	cursor, err := db.Users.Find(ctx, bson.M{"_id": bson.M{"$in": userIDs}},
+		options.Find().SetProjection(bson.M{"avatarUrl": 1}))
+	if err == nil {
+		var users []User
+		if cursor.All(ctx, &users) == nil {
+			for _, user := range users {
+				avatars[user.ID] = user.AvatarURL
+			}
+		}
+	}
+
+	online := presence.Online(ctx, userIDs)
+	for i := range contacts {
+		contacts[i].AvatarURL = avatars[contacts[i].UserID]
+		contacts[i].Online = online[contacts[i].UserID]
+	}
+}
+
+// getContactsHandler lists the user's contacts, optionally filtered by a
+// name or email search
+func getContactsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	filter := bson.M{"ownerId": userID}
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}, {Key: "email", Value: 1}})
+	if q := strings.TrimSpace(r.URL.Query().Get("q")); q != "" {
+		pattern := bson.M{"$regex": regexp.QuoteMeta(q), "$options": "i"}
+		filter["$or"] = []bson.M{{"name": pattern}, {"email": pattern}}
+		opts.SetLimit(MaxContactSearchLimit)
+	}
+
+	cursor, err := db.Contacts.Find(r.Context(), filter, opts)
+	if err != nil {
+		sendErrorResponse(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	contacts := []Contact{}
+	if err := cursor.All(r.Context(), &contacts); err != nil {
+		sendErrorResponse(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	decorateContacts(r.Context(), contacts)
+	sendSuccessResponse(w, contacts)
+}
+
+func addContactHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Email string `json:"email"`
+		Name  string `json:"name,omitempty"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if !validateEmail(email) {
+		sendErrorResponse(w, "A valid email is required", http.StatusBadRequest)
+		return
+	}
+
+	contacts, err := upsertContacts(r.Context(), userID, []string{email}, map[string]string{email: strings.TrimSpace(req.Name)})
+	if err != nil {
+		log.Printf("Error adding contact: %v", err)
+		sendErrorResponse(w, "Failed to add contact", http.StatusInternalServerError)
+		return
+	}
+	if len(contacts) == 0 {
+		sendErrorResponse(w, "You cannot add yourself as a contact", http.StatusBadRequest)
+		return
+	}
+
+	decorateContacts(r.Context(), contacts)
+	sendSuccessResponse(w, contacts[0])
+}
+
+// importContactsHandler adds many contacts at once; invalid addresses are
+// reported back rather than failing the whole import
+func importContactsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Emails []string `json:"emails"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if len(req.Emails) == 0 || len(req.Emails) > MaxContactsPerImport {
+		sendErrorResponse(w, fmt.Sprintf("Between 1 and %d emails are required", MaxContactsPerImport), http.StatusBadRequest)
+		return
+	}
+
+	var emails []string
+	invalid := []string{}
+	for _, email := range req.Emails {
+		email = strings.ToLower(strings.TrimSpace(email))
+		if !validateEmail(email) {
+			invalid = append(invalid, email)
+			continue
+		}
+		emails = append(emails, email)
+	}
+	emails = uniqueStrings(emails)
+
+	contacts := []Contact{}
+	if len(emails) > 0 {
+		var err error
+		if contacts, err = upsertContacts(r.Context(), userID, emails, nil); err != nil {
+			log.Printf("Error importing contacts: %v", err)
+			sendErrorResponse(w, "Failed to import contacts", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	sendSuccessResponse(w, map[string]interface{}{
+		"imported": contacts,
+		"invalid":  invalid,
+	})
+}
+
+func deleteContactHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	result, err := db.Contacts.DeleteOne(r.Context(), bson.M{"_id": mux.Vars(r)["id"], "ownerId": userID})
+	if err != nil {
+		sendErrorResponse(w, "Failed to remove contact", http.StatusInternalServerError)
+		return
+	}
+	if result.DeletedCount == 0 {
+		sendErrorResponse(w, "Contact not found", http.StatusNotFound)
+		return
+	}
+
+	sendSuccessResponse(w, map[string]string{"message": "Contact removed"})
+}
+
+// quickInviteHandler rings the chosen contacts who are online right now with
+// a "quick-invite" message on their presence socket. Registered contacts also become
+// invitees so they can open private meetings.
+func quickInviteHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	meeting, err := findMeeting(r.Context(), vars["id"])
+	if err != nil {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+	if !meeting.IsActive {
+		sendCodedError(w, ErrCodeMeetingEnded, "Meeting has ended")
+		return
+	}
+	if meeting.CreatedBy != userID && !isParticipant(r.Context(), meeting.ID, userID) {
+		sendErrorResponse(w, "Only participants can invite contacts", http.StatusForbidden)
+		return
+	}
+
+	var req struct {
+		ContactIDs []string `json:"contactIds"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	req.ContactIDs = uniqueStrings(req.ContactIDs)
+	if len(req.ContactIDs) == 0 || len(req.ContactIDs) > MaxQuickInvites {
+		sendErrorResponse(w, fmt.Sprintf("Between 1 and %d contacts are required", MaxQuickInvites), http.StatusBadRequest)
+		return
+	}
+
+	cursor, err := db.Contacts.Find(r.Context(), bson.M{
+		"_id":     bson.M{"$in": req.ContactIDs},
+		"ownerId": userID,
+		"userId":  bson.M{"$exists": true},
+	})
+	if err != nil {
+		sendErrorResponse(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	var contacts []Contact
+	if err := cursor.All(r.Context(), &contacts); err != nil {
+		sendErrorResponse(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	userIDs := make([]string, 0, len(contacts))
+	for _, contact := range contacts {
+		userIDs = append(userIDs, contact.UserID)
+	}
+	if len(userIDs) > 0 {
+		_, err := db.Meetings.UpdateOne(
+			r.Context(),
+			bson.M{"_id": meeting.ID},
+			bson.M{"$addToSet": bson.M{"invitees": bson.M{"$each": userIDs}}},
+		)
+		if err != nil {
+			log.Printf("Error adding quick invitees: %v", err)
+		}
+		meetingLookups.Invalidate(r.Context(), meeting.ID)
+	}
+
+	online := presence.Online(r.Context(), userIDs)
+
+	var inviter User
+	db.Users.FindOne(r.Context(), bson.M{"_id": userID}).Decode(&inviter)
+
+	notified := []string{}
+	offline := []string{}
+	for _, contact := range contacts {
+		if !online[contact.UserID] {
+			offline = append(offline, contact.ID)
+			continue
+		}
+		presence.Notify(r.Context(), contact.UserID, WebSocketMessage{
+			Type: "quick-invite",
+			Data: map[string]string{
+				"meetingId":   meeting.ID,
+				"title":       meeting.Title,
+				"joinUrl":     meetingJoinURL(meeting),
+				"inviterId":   userID,
+				"inviterName": inviter.Name,
+			},
+			MeetingID: meeting.ID,
+			UserID:    userID,
+		})
+		notified = append(notified, contact.ID)
+	}
+
+	sendSuccessResponse(w, map[string]interface{}{
+		"notified": notified,
+		"offline":  offline,
+	})
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+)
+
+// Browsers may call the API from the configured origins and from origins
+// added at runtime, by admins for the whole service or by organizations for
+// their own integrations. Organization origins are not trusted with users'
+// sessions: they get CORS without credentials, so they can only use API keys
+// and embed tokens.
+
+const (
+	CORSOriginsRefreshInterval = time.Minute
+	MaxOrgCORSOrigins          = 20
+)
+
+// CORSOrigin is an origin added at runtime, such as https://app.example.com
+// or https://*.example.com for its subdomains
+type CORSOrigin struct {
+	ID        string    `json:"id" bson:"_id"`
+	Origin    string    `json:"origin" bson:"origin"`
+	OrgID     string    `json:"orgId,omitempty" bson:"orgId,omitempty"`
+	CreatedBy string    `json:"createdBy" bson:"createdBy"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+}
+
+// originPattern is a parsed allowed origin. The host keeps any port; a
+// wildcard pattern matches subdomains of the host, not the host itself.
+type originPattern struct {
+	scheme   string
+	host     string
+	wildcard bool
+}
+
+// parseOriginPattern parses an origin, optionally with a *. wildcard
+// subdomain, normalizing its case
+func parseOriginPattern(origin string) (originPattern, error) {
+	parsed, err := url.Parse(strings.ToLower(strings.Replace(origin, "://*.", "://", 1)))
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" ||
+		parsed.Path != "" || parsed.RawQuery != "" || parsed.User != nil || strings.Contains(parsed.Host, "*") {
+		return originPattern{}, fmt.Errorf("origin must be a scheme and host, e.g. https://example.com or https://*.example.com")
+	}
+	wildcard := strings.Contains(origin, "://*.")
+	// Wildcards over a whole top-level domain are too broad
+	if wildcard && !strings.Contains(parsed.Hostname(), ".") {
+		return originPattern{}, fmt.Errorf("wildcard origins must name a domain, e.g. https://*.example.com")
+	}
+	return originPattern{scheme: parsed.Scheme, host: parsed.Host, wildcard: wildcard}, nil
+}
+
+func (p originPattern) String() string {
+	if p.wildcard {
+		return p.scheme + "://*." + p.host
+	}
+	return p.scheme + "://" + p.host
+}
+
+func (p originPattern) matches(origin originPattern) bool {
+	if origin.wildcard || origin.scheme != p.scheme {
+		return false
+	}
+	if p.wildcard {
+		return strings.HasSuffix(origin.host, "."+p.host)
+	}
+	return origin.host == p.host
+}
+
+// originRegistry holds the allowed origins. Each instance reloads the stored
+// ones periodically, so changes made elsewhere apply within
+// CORSOriginsRefreshInterval.
+type originRegistry struct {
+	mu     sync.RWMutex
+	loaded bool
+	// Configured and admin origins, trusted with credentials
+	trusted []originPattern
+	// Organization origins
+	untrusted []originPattern
+}
+
+var corsOrigins = &originRegistry{}
+
+// configuredOrigins returns the origins from the configuration
+func configuredOrigins() []originPattern {
+	patterns := make([]originPattern, 0, len(appConfig.CORS.AllowedOrigins))
+	for _, origin := range appConfig.CORS.AllowedOrigins {
+		if pattern, err := parseOriginPattern(origin); err == nil {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// Allowed reports whether browsers may call the API from origin, and whether
+// they may send credentials. Until the stored origins are loaded only the
+// configured ones are allowed.
+func (o *originRegistry) Allowed(origin string) (allowed, credentials bool) {
+	parsed, err := parseOriginPattern(origin)
+	if err != nil {
+		return false, false
+	}
+
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	trusted := o.trusted
+	if !o.loaded {
+		trusted = configuredOrigins()
+	}
+	for _, pattern := range trusted {
+		if pattern.matches(parsed) {
+			return true, true
+		}
+	}
+	for _, pattern := range o.untrusted {
+		if pattern.matches(parsed) {
+			return true, false
+		}
+	}
+	return false, false
+}
+
+func (o *originRegistry) refresh(ctx context.Context) error {
+	cursor, err := db.CORSOrigins.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	var stored []CORSOrigin
+	if err := cursor.All(ctx, &stored); err != nil {
+		return err
+	}
+
+	trusted := configuredOrigins()
+	var untrusted []originPattern
+	for _, origin := range stored {
+		pattern, err := parseOriginPattern(origin.Origin)
+		if err != nil {
+			continue
+		}
+		if origin.OrgID == "" {
+			trusted = append(trusted, pattern)
+		} else {
+			untrusted = append(untrusted, pattern)
+		}
+	}
+	o.mu.Lock()
+	o.loaded = true
+	o.trusted = trusted
+	o.untrusted = untrusted
+	o.mu.Unlock()
+	return nil
+}
+
+// startCORSOriginsRefresh reloads the allowed origins until ctx is cancelled
+func startCORSOriginsRefresh(ctx context.Context) {
+	if err := corsOrigins.refresh(ctx); err != nil {
+		log.Printf("Error loading CORS origins: %v", err)
+	}
+
+	ticker := time.NewTicker(CORSOriginsRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := corsOrigins.refresh(ctx); err != nil {
+				log.Printf("Error refreshing CORS origins: %v", err)
+			}
+		}
+	}
+}
+
+// isAllowedOrigin reports whether origin may use the API with the user's
+// session
+func isAllowedOrigin(origin string) bool {
+	allowed, credentials := corsOrigins.Allowed(origin)
+	return allowed && credentials
+}
+
+// addCORSOrigin stores an origin, for the whole service when orgID is empty,
+// and applies it on this instance straight away
+func addCORSOrigin(ctx context.Context, origin, orgID, userID string) (CORSOrigin, error) {
+	pattern, err := parseOriginPattern(origin)
+	if err != nil {
+		return CORSOrigin{}, err
+	}
+	added := CORSOrigin{
+		ID:        uuid.New().String(),
+		Origin:    pattern.String(),
+		OrgID:     orgID,
+		CreatedBy: userID,
+		CreatedAt: time.Now(),
+	}
+	if _, err := db.CORSOrigins.InsertOne(ctx, added); err != nil {
+		return CORSOrigin{}, err
+	}
+	if err := corsOrigins.refresh(ctx); err != nil {
+		log.Printf("Error refreshing CORS origins: %v", err)
+	}
+	return added, nil
+}
+
+// removeCORSOrigin deletes a stored origin matching filter, reporting whether
+// there was one
+func removeCORSOrigin(ctx context.Context, filter bson.M) (bool, error) {
+	result, err := db.CORSOrigins.DeleteOne(ctx, filter)
+	if err != nil {
+		return false, err
+	}
+	if err := corsOrigins.refresh(ctx); err != nil {
+		log.Printf("Error refreshing CORS origins: %v", err)
+	}
+	return result.DeletedCount > 0, nil
+}
+
+func listCORSOrigins(ctx context.Context, filter bson.M) ([]CORSOrigin, error) {
+	cursor, err := db.CORSOrigins.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	origins := []CORSOrigin{}
+	if err := cursor.All(ctx, &origins); err != nil {
+		return nil, err
+	}
+	return origins, nil
+}
+
+type corsOriginRequest struct {
+	Origin string `json:"origin"`
+}
+
+type adminCORSOriginsResponse struct {
+	// From the configuration; these can't be removed through the API
+	Configured []string     `json:"configured"`
+	Added      []CORSOrigin `json:"added"`
+}
+
+// adminListCORSOriginsHandler lists the origins allowed for the whole service
+func adminListCORSOriginsHandler(w http.ResponseWriter, r *http.Request) {
+	added, err := listCORSOrigins(r.Context(), bson.M{"orgId": bson.M{"$exists": false}})
+	if err != nil {
+		sendErrorResponse(w, "Failed to fetch origins", http.StatusInternalServerError)
+		return
+	}
+	sendSuccessResponse(w, adminCORSOriginsResponse{Configured: appConfig.CORS.AllowedOrigins, Added: added})
+}
+
+// adminAddCORSOriginHandler allows an origin, with credentials, for the whole
+// service
+func adminAddCORSOriginHandler(w http.ResponseWriter, r *http.Request) {
+	var req corsOriginRequest
+	if !decodeStrictJSONBody(w, r, &req) {
+		return
+	}
+	if _, err := parseOriginPattern(req.Origin); err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	admin := adminFromContext(r.Context())
+	added, err := addCORSOrigin(r.Context(), req.Origin, "", admin.ID)
+	if mongo.IsDuplicateKeyError(err) {
+		sendErrorResponse(w, "Origin is already allowed", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error adding CORS origin: %v", err)
+		sendErrorResponse(w, "Failed to add origin", http.StatusInternalServerError)
+		return
+	}
+
+	recordSecurityEvent(r.Context(), SecurityEvent{
+		Type:    SecurityCORSOriginAdded,
+		IP:      getClientIP(r),
+		ActorID: admin.ID,
+		Details: map[string]interface{}{"origin": added.Origin},
+	})
+	sendSuccessResponse(w, added)
+}
+
+// adminRemoveCORSOriginHandler removes an origin added through the admin API
+func adminRemoveCORSOriginHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	removed, err := removeCORSOrigin(r.Context(), bson.M{"_id": id, "orgId": bson.M{"$exists": false}})
+	if err != nil {
+		sendErrorResponse(w, "Failed to remove origin", http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		sendErrorResponse(w, "Origin not found", http.StatusNotFound)
+		return
+	}
+
+	recordSecurityEvent(r.Context(), SecurityEvent{
+		Type:    SecurityCORSOriginRemoved,
+		IP:      getClientIP(r),
+		ActorID: adminFromContext(r.Context()).ID,
+		Details: map[string]interface{}{"originId": id},
+	})
+	sendSuccessResponse(w, map[string]string{"message": "Origin removed"})
+}
+
+// getOrgCORSOriginsHandler lists the origins an organization has added
+func getOrgCORSOriginsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	org, _, ok := requireOrgRole(w, r, mux.Vars(r)["id"], userID, OrgRoleOwner, OrgRoleAdmin)
+	if !ok {
+		return
+	}
+
+	origins, err := listCORSOrigins(r.Context(), bson.M{"orgId": org.ID})
+	if err != nil {
+		sendErrorResponse(w, "Failed to fetch origins", http.StatusInternalServerError)
+		return
+	}
+	sendSuccessResponse(w, origins)
+}
+
+// addOrgCORSOriginHandler lets an owner or admin allow an https origin for
+// the organization's integrations
+func addOrgCORSOriginHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	org, _, ok := requireOrgRole(w, r, mux.Vars(r)["id"], userID, OrgRoleOwner, OrgRoleAdmin)
+	if !ok {
+		return
+	}
+
+	var req corsOriginRequest
+	if !decodeStrictJSONBody(w, r, &req) {
+		return
+	}
+	pattern, err := parseOriginPattern(req.Origin)
+	if err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if pattern.scheme != "https" {
+		sendErrorResponse(w, "Organization origins must use https", http.StatusBadRequest)
+		return
+	}
+
+	count, err := db.CORSOrigins.CountDocuments(r.Context(), bson.M{"orgId": org.ID})
+	if err != nil {
+		sendErrorResponse(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if count >= MaxOrgCORSOrigins {
+		sendErrorResponse(w, fmt.Sprintf("Organizations can add at most %d origins", MaxOrgCORSOrigins), http.StatusBadRequest)
+		return
+	}
+
+	added, err := addCORSOrigin(r.Context(), req.Origin, org.ID, userID)
+	if mongo.IsDuplicateKeyError(err) {
+		sendErrorResponse(w, "Origin is already allowed", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error adding CORS origin for organization %s: %v", org.ID, err)
+		sendErrorResponse(w, "Failed to add origin", http.StatusInternalServerError)
+		return
+	}
+	sendSuccessResponse(w, added)
+}
+
+// removeOrgCORSOriginHandler removes one of the organization's origins
+func removeOrgCORSOriginHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	org, _, ok := requireOrgRole(w, r, vars["id"], userID, OrgRoleOwner, OrgRoleAdmin)
+	if !ok {
+		return
+	}
+
+	removed, err := removeCORSOrigin(r.Context(), bson.M{"_id": vars["originId"], "orgId": org.ID})
+	if err != nil {
+		sendErrorResponse(w, "Failed to remove origin", http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		sendErrorResponse(w, "Origin not found", http.StatusNotFound)
+		return
+	}
+	sendSuccessResponse(w, map[string]string{"message": "Origin removed"})
+}
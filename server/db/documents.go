@@ -0,0 +1,595 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// backend keeps a collection's documents for a documentStore. It only finds
+// documents coarsely; the documentStore matches, updates, sorts and
+// projects them.
+type backend interface {
+	// candidates returns, in insertion order, the documents that may match
+	// filter: every match, and possibly others
+	candidates(ctx context.Context, filter bson.D) ([]bson.Raw, error)
+	// modify calls fn with the candidates for filter, which no other write
+	// changes until fn returns, and applies what fn writes through w. A
+	// write fn makes is visible to the candidates of the writes after it.
+	modify(ctx context.Context, filter bson.D, fn func(docs []bson.Raw, w writer) error) error
+}
+
+// writer changes the documents of a backend. Documents are identified by
+// their _id.
+type writer interface {
+	insert(doc bson.Raw) error
+	replace(doc bson.Raw) error
+	remove(id interface{}) error
+}
+
+// documentStore is a Store over a backend
+type documentStore struct {
+	backend backend
+}
+
+// createIndex passes indexes to backends that build them
+func (s *documentStore) createIndex(ctx context.Context, model mongo.IndexModel) error {
+	if b, ok := s.backend.(indexer); ok {
+		return b.createIndex(ctx, model)
+	}
+	return nil
+}
+
+// matchNothing is the filter of a write that looks nothing up
+var matchNothing = bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: bson.A{}}}}}
+
+// duplicateKeyError is the error MongoDB gives for a unique index
+// violation, so mongo.IsDuplicateKeyError recognizes it
+func duplicateKeyError(index string, key interface{}) error {
+	return mongo.WriteException{WriteErrors: mongo.WriteErrors{{
+		Code:    11000,
+		Message: fmt.Sprintf("E11000 duplicate key error index: %s dup key: %v", index, key),
+	}}}
+}
+
+// idKey is the canonical extended JSON of an _id, for comparing and
+// storing document IDs
+func idKey(id interface{}) (string, error) {
+	wrapped, err := bson.MarshalExtJSON(bson.D{{Key: "v", Value: id}}, true, false)
+	if err != nil {
+		return "", err
+	}
+	// Strip {"v": and }
+	key := strings.TrimSpace(string(wrapped))
+	key = strings.TrimPrefix(key, `{"v":`)
+	return strings.TrimSuffix(key, "}"), nil
+}
+
+// documentID returns the _id of a stored document
+func documentID(doc bson.Raw) (interface{}, error) {
+	value, err := doc.LookupErr("_id")
+	if err != nil {
+		return nil, fmt.Errorf("document has no _id")
+	}
+	var id interface{}
+	err = value.Unmarshal(&id)
+	return id, err
+}
+
+// decodeAll decodes stored documents
+func decodeAll(raws []bson.Raw) ([]bson.D, error) {
+	docs := make([]bson.D, len(raws))
+	for i, raw := range raws {
+		if err := bson.Unmarshal(raw, &docs[i]); err != nil {
+			return nil, err
+		}
+	}
+	return docs, nil
+}
+
+// matching decodes the candidates that match a filter
+func matching(raws []bson.Raw, match matcher) ([]bson.D, error) {
+	var docs []bson.D
+	for _, raw := range raws {
+		var doc bson.D
+		if err := bson.Unmarshal(raw, &doc); err != nil {
+			return nil, err
+		}
+		if match(doc) {
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
+
+// prepareFilter reads and compiles a filter
+func prepareFilter(filter interface{}) (bson.D, matcher, error) {
+	doc, err := toDocument(filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	match, err := compileFilter(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	return doc, match, nil
+}
+
+// optionalDocument reads an optional sort or projection
+func optionalDocument(v interface{}) (bson.D, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return toDocument(v)
+}
+
+// find returns the documents matching filter, sorted, skipped and limited
+func (s *documentStore) find(ctx context.Context, filter interface{}, sortSpec interface{}, skip, limit int64) ([]bson.D, error) {
+	doc, match, err := prepareFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	order, err := optionalDocument(sortSpec)
+	if err != nil {
+		return nil, err
+	}
+	raws, err := s.backend.candidates(ctx, doc)
+	if err != nil {
+		return nil, err
+	}
+	docs, err := matching(raws, match)
+	if err != nil {
+		return nil, err
+	}
+	if err := sortDocuments(docs, order); err != nil {
+		return nil, err
+	}
+	return window(docs, skip, limit), nil
+}
+
+// window skips and limits documents; a limit of 0 is no limit
+func window(docs []bson.D, skip, limit int64) []bson.D {
+	if skip > 0 {
+		if skip >= int64(len(docs)) {
+			return nil
+		}
+		docs = docs[skip:]
+	}
+	if limit < 0 {
+		limit = -limit
+	}
+	if limit > 0 && limit < int64(len(docs)) {
+		docs = docs[:limit]
+	}
+	return docs
+}
+
+func projectAll(docs []bson.D, projection interface{}) ([]interface{}, error) {
+	spec, err := optionalDocument(projection)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]interface{}, len(docs))
+	for i, doc := range docs {
+		if out[i], err = projectDocument(doc, spec); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func int64Value(p *int64) int64 {
+	if p == nil {
+		return 0
+	}
+	return *p
+}
+
+func (s *documentStore) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	o := options.MergeFindOptions(opts...)
+	docs, err := s.find(ctx, filter, o.Sort, int64Value(o.Skip), int64Value(o.Limit))
+	if err != nil {
+		return nil, err
+	}
+	projected, err := projectAll(docs, o.Projection)
+	if err != nil {
+		return nil, err
+	}
+	return mongo.NewCursorFromDocuments(projected, nil, nil)
+}
+
+// singleResult returns a document, or ErrNoDocuments when there is none
+func singleResult(doc interface{}, err error) *mongo.SingleResult {
+	if err == nil && doc == nil {
+		err = mongo.ErrNoDocuments
+	}
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+	}
+	return mongo.NewSingleResultFromDocument(doc, nil, nil)
+}
+
+func (s *documentStore) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	o := options.MergeFindOneOptions(opts...)
+	docs, err := s.find(ctx, filter, o.Sort, int64Value(o.Skip), 1)
+	if err != nil || len(docs) == 0 {
+		return singleResult(nil, err)
+	}
+	spec, err := optionalDocument(o.Projection)
+	if err != nil {
+		return singleResult(nil, err)
+	}
+	return singleResult(projectDocument(docs[0], spec))
+}
+
+func (s *documentStore) CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	o := options.MergeCountOptions(opts...)
+	docs, err := s.find(ctx, filter, nil, int64Value(o.Skip), int64Value(o.Limit))
+	return int64(len(docs)), err
+}
+
+func (s *documentStore) Distinct(ctx context.Context, fieldName string, filter interface{}, opts ...*options.DistinctOptions) ([]interface{}, error) {
+	docs, err := s.find(ctx, filter, nil, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	var values []interface{}
+	path := splitPath(fieldName)
+	for _, doc := range docs {
+		for _, value := range lookupValues(doc, path) {
+			items := bson.A{value}
+			if list, ok := value.(bson.A); ok {
+				items = list
+			}
+			for _, item := range items {
+				if !containsValue(values, item) {
+					values = append(values, item)
+				}
+			}
+		}
+	}
+	return values, nil
+}
+
+func (s *documentStore) Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	stages, ok, err := toPipeline(pipeline)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("pipeline must be a list of stages")
+	}
+	// A leading $match narrows what is loaded
+	var filter bson.D
+	if len(stages) > 0 && len(stages[0]) == 1 && stages[0][0].Key == "$match" {
+		filter, _ = stages[0][0].Value.(bson.D)
+		stages = stages[1:]
+	}
+	docs, err := s.find(ctx, filter, nil, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	if docs, err = aggregate(docs, stages); err != nil {
+		return nil, err
+	}
+	out := make([]interface{}, len(docs))
+	for i, doc := range docs {
+		out[i] = doc
+	}
+	return mongo.NewCursorFromDocuments(out, nil, nil)
+}
+
+// newDocument prepares a document for insertion, giving it an ObjectID when
+// it has no _id, as the MongoDB driver does
+func newDocument(document interface{}) (bson.Raw, interface{}, error) {
+	doc, err := toDocument(document)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, element := range doc {
+		if strings.HasPrefix(element.Key, "$") {
+			return nil, nil, fmt.Errorf("document can't have %s as a field name", element.Key)
+		}
+	}
+	id, ok := lookupPath(doc, []string{"_id"})
+	if !ok {
+		id = primitive.NewObjectID()
+		doc = append(bson.D{{Key: "_id", Value: id}}, doc...)
+	}
+	if _, isArray := id.(bson.A); isArray {
+		return nil, nil, fmt.Errorf("_id can't be an array")
+	}
+	raw, err := bson.Marshal(doc)
+	return raw, id, err
+}
+
+func (s *documentStore) InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	raw, id, err := newDocument(document)
+	if err != nil {
+		return nil, err
+	}
+	err = s.backend.modify(ctx, matchNothing, func(_ []bson.Raw, w writer) error {
+		return w.insert(raw)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &mongo.InsertOneResult{InsertedID: id}, nil
+}
+
+func (s *documentStore) InsertMany(ctx context.Context, documents []interface{}, opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error) {
+	raws := make([]bson.Raw, len(documents))
+	ids := make([]interface{}, len(documents))
+	for i, document := range documents {
+		var err error
+		if raws[i], ids[i], err = newDocument(document); err != nil {
+			return nil, err
+		}
+	}
+	err := s.backend.modify(ctx, matchNothing, func(_ []bson.Raw, w writer) error {
+		for _, raw := range raws {
+			if err := w.insert(raw); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &mongo.InsertManyResult{InsertedIDs: ids}, nil
+}
+
+// change is the outcome of updating or replacing one document
+type change struct {
+	before, after bson.D
+	upserted      bool
+	modified      bool
+}
+
+// rewrite updates or replaces the documents matching filter, the first by
+// sortSpec or all of them, inserting one for an upsert when none match
+func (s *documentStore) rewrite(ctx context.Context, filter interface{}, sortSpec interface{}, many, upsert bool,
+	transform func(doc bson.D, inserting bool) (bson.D, error)) ([]change, error) {
+	doc, match, err := prepareFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	order, err := optionalDocument(sortSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []change
+	err = s.backend.modify(ctx, doc, func(raws []bson.Raw, w writer) error {
+		changes = nil
+		docs, err := matching(raws, match)
+		if err != nil {
+			return err
+		}
+		if !many && len(docs) > 1 {
+			if err := sortDocuments(docs, order); err != nil {
+				return err
+			}
+			docs = docs[:1]
+		}
+
+		if len(docs) == 0 {
+			if !upsert {
+				return nil
+			}
+			seed, err := upsertDocument(doc)
+			if err != nil {
+				return err
+			}
+			after, err := transform(seed, true)
+			if err != nil {
+				return err
+			}
+			raw, _, err := newDocument(after)
+			if err != nil {
+				return err
+			}
+			if err := bson.Unmarshal(raw, &after); err != nil {
+				return err
+			}
+			changes = append(changes, change{after: after, upserted: true, modified: true})
+			return w.insert(raw)
+		}
+
+		for _, before := range docs {
+			after, err := transform(before, false)
+			if err != nil {
+				return err
+			}
+			raw, err := bson.Marshal(after)
+			if err != nil {
+				return err
+			}
+			original, err := bson.Marshal(before)
+			if err != nil {
+				return err
+			}
+			modified := !bytes.Equal(raw, original)
+			changes = append(changes, change{before: before, after: after, modified: modified})
+			if modified {
+				if err := w.replace(raw); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	return changes, err
+}
+
+func updateResult(changes []change) *mongo.UpdateResult {
+	result := &mongo.UpdateResult{}
+	for _, c := range changes {
+		switch {
+		case c.upserted:
+			result.UpsertedCount++
+			result.UpsertedID, _ = lookupPath(c.after, []string{"_id"})
+		case c.modified:
+			result.MatchedCount++
+			result.ModifiedCount++
+		default:
+			result.MatchedCount++
+		}
+	}
+	return result
+}
+
+func (s *documentStore) update(ctx context.Context, filter, u interface{}, many bool, opts []*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	o := options.MergeUpdateOptions(opts...)
+	if o.ArrayFilters != nil {
+		return nil, errors.New("arrayFilters are not supported")
+	}
+	parsed, err := parseUpdate(u)
+	if err != nil {
+		return nil, err
+	}
+	upsert := o.Upsert != nil && *o.Upsert
+	changes, err := s.rewrite(ctx, filter, nil, many, upsert, parsed.apply)
+	if err != nil {
+		return nil, err
+	}
+	return updateResult(changes), nil
+}
+
+func (s *documentStore) UpdateOne(ctx context.Context, filter, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return s.update(ctx, filter, update, false, opts)
+}
+
+func (s *documentStore) UpdateMany(ctx context.Context, filter, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return s.update(ctx, filter, update, true, opts)
+}
+
+func (s *documentStore) ReplaceOne(ctx context.Context, filter, replacement interface{}, opts ...*options.ReplaceOptions) (*mongo.UpdateResult, error) {
+	o := options.MergeReplaceOptions(opts...)
+	doc, err := toDocument(replacement)
+	if err != nil {
+		return nil, err
+	}
+	for _, element := range doc {
+		if strings.HasPrefix(element.Key, "$") {
+			return nil, errors.New("replacement document must not contain update operators")
+		}
+	}
+	upsert := o.Upsert != nil && *o.Upsert
+	changes, err := s.rewrite(ctx, filter, nil, false, upsert, func(current bson.D, inserting bool) (bson.D, error) {
+		out := cloneDocument(doc)
+		id, hasID := lookupPath(current, []string{"_id"})
+		newID, replacementHasID := lookupPath(out, []string{"_id"})
+		switch {
+		case hasID && replacementHasID && compareValues(id, newID) != 0:
+			return nil, errImmutableID
+		case hasID && !replacementHasID:
+			out = append(bson.D{{Key: "_id", Value: id}}, out...)
+		}
+		return out, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updateResult(changes), nil
+}
+
+func (s *documentStore) FindOneAndUpdate(ctx context.Context, filter, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult {
+	o := options.MergeFindOneAndUpdateOptions(opts...)
+	if o.ArrayFilters != nil {
+		return singleResult(nil, errors.New("arrayFilters are not supported"))
+	}
+	parsed, err := parseUpdate(update)
+	if err != nil {
+		return singleResult(nil, err)
+	}
+	upsert := o.Upsert != nil && *o.Upsert
+	changes, err := s.rewrite(ctx, filter, o.Sort, false, upsert, parsed.apply)
+	if err != nil || len(changes) == 0 {
+		return singleResult(nil, err)
+	}
+	result := changes[0].before
+	if o.ReturnDocument != nil && *o.ReturnDocument == options.After {
+		result = changes[0].after
+	}
+	if result == nil {
+		return singleResult(nil, nil)
+	}
+	spec, err := optionalDocument(o.Projection)
+	if err != nil {
+		return singleResult(nil, err)
+	}
+	return singleResult(projectDocument(result, spec))
+}
+
+// remove deletes the documents matching filter, the first by sortSpec or
+// all of them, and returns them
+func (s *documentStore) remove(ctx context.Context, filter interface{}, sortSpec interface{}, many bool) ([]bson.D, error) {
+	doc, match, err := prepareFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	order, err := optionalDocument(sortSpec)
+	if err != nil {
+		return nil, err
+	}
+	var removed []bson.D
+	err = s.backend.modify(ctx, doc, func(raws []bson.Raw, w writer) error {
+		docs, err := matching(raws, match)
+		if err != nil {
+			return err
+		}
+		if !many && len(docs) > 1 {
+			if err := sortDocuments(docs, order); err != nil {
+				return err
+			}
+			docs = docs[:1]
+		}
+		removed = docs
+		for _, doc := range docs {
+			id, _ := lookupPath(doc, []string{"_id"})
+			if err := w.remove(id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return removed, err
+}
+
+func (s *documentStore) FindOneAndDelete(ctx context.Context, filter interface{}, opts ...*options.FindOneAndDeleteOptions) *mongo.SingleResult {
+	o := options.MergeFindOneAndDeleteOptions(opts...)
+	removed, err := s.remove(ctx, filter, o.Sort, false)
+	if err != nil || len(removed) == 0 {
+		return singleResult(nil, err)
+	}
+	spec, err := optionalDocument(o.Projection)
+	if err != nil {
+		return singleResult(nil, err)
+	}
+	return singleResult(projectDocument(removed[0], spec))
+}
+
+func (s *documentStore) DeleteOne(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	removed, err := s.remove(ctx, filter, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	return &mongo.DeleteResult{DeletedCount: int64(len(removed))}, nil
+}
+
+func (s *documentStore) DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	removed, err := s.remove(ctx, filter, nil, true)
+	if err != nil {
+		return nil, err
+	}
+	return &mongo.DeleteResult{DeletedCount: int64(len(removed))}, nil
+}
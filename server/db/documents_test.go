@@ -0,0 +1,365 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// testStore returns a store in memory holding docs, written as extended
+// JSON
+func testStore(t *testing.T, docs ...string) *documentStore {
+	t.Helper()
+	s := &documentStore{backend: &memTable{name: "test", docs: make(map[string]memDocument)}}
+	for _, doc := range docs {
+		if _, err := s.InsertOne(context.Background(), extJSON(t, doc)); err != nil {
+			t.Fatalf("inserting %s: %v", doc, err)
+		}
+	}
+	return s
+}
+
+// all returns the documents a cursor yields as canonical extended JSON
+func all(t *testing.T, cursor *mongo.Cursor, err error) string {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs := []bson.D{}
+	if err := cursor.All(context.Background(), &docs); err != nil {
+		t.Fatal(err)
+	}
+	return canonical(t, bson.D{{Key: "d", Value: docs}})
+}
+
+// list returns documents written as extended JSON in the form all gives
+func list(t *testing.T, s string) string {
+	return canonical(t, extJSON(t, `{"d": `+s+`}`))
+}
+
+// contents returns everything in s, in insertion order
+func contents(t *testing.T, s *documentStore) string {
+	t.Helper()
+	cursor, err := s.Find(context.Background(), bson.D{})
+	return all(t, cursor, err)
+}
+
+func TestStoreFind(t *testing.T) {
+	ctx := context.Background()
+	s := testStore(t,
+		`{"_id": 1, "n": 3, "tag": "a"}`,
+		`{"_id": 2, "n": 1, "tag": "b"}`,
+		`{"_id": 3, "n": 2, "tag": "a"}`,
+		`{"_id": 4, "n": 4}`,
+	)
+	tests := []struct {
+		name   string
+		filter bson.D
+		opts   *options.FindOptions
+		want   string
+	}{
+		{"everything in insertion order", bson.D{}, options.Find(), `[{"_id": 1, "n": 3, "tag": "a"}, {"_id": 2, "n": 1, "tag": "b"}, {"_id": 3, "n": 2, "tag": "a"}, {"_id": 4, "n": 4}]`},
+		{"filter", bson.D{{Key: "tag", Value: "a"}}, options.Find().SetProjection(bson.D{{Key: "_id", Value: 1}}), `[{"_id": 1}, {"_id": 3}]`},
+		{"by _id", bson.D{{Key: "_id", Value: 2}}, options.Find(), `[{"_id": 2, "n": 1, "tag": "b"}]`},
+		{"by _id $in", bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: bson.A{4, 1, 9}}}}}, options.Find().SetProjection(bson.D{{Key: "n", Value: 1}}), `[{"_id": 1, "n": 3}, {"_id": 4, "n": 4}]`},
+		{"sort", bson.D{}, options.Find().SetSort(bson.D{{Key: "n", Value: -1}}).SetProjection(bson.D{{Key: "n", Value: 1}}), `[{"_id": 4, "n": 4}, {"_id": 1, "n": 3}, {"_id": 3, "n": 2}, {"_id": 2, "n": 1}]`},
+		{"sort, skip and limit", bson.D{}, options.Find().SetSort(bson.D{{Key: "n", Value: 1}}).SetSkip(1).SetLimit(2).SetProjection(bson.D{{Key: "tag", Value: 0}}), `[{"_id": 3, "n": 2}, {"_id": 1, "n": 3}]`},
+		{"negative limit", bson.D{}, options.Find().SetLimit(-1).SetProjection(bson.D{{Key: "_id", Value: 1}}), `[{"_id": 1}]`},
+		{"skip past the end", bson.D{}, options.Find().SetSkip(10), `[]`},
+		{"no match", bson.D{{Key: "tag", Value: "z"}}, options.Find(), `[]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cursor, err := s.Find(ctx, tt.filter, tt.opts)
+			if got := all(t, cursor, err); got != list(t, tt.want) {
+				t.Errorf("Find = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	var doc bson.D
+	if err := s.FindOne(ctx, bson.D{{Key: "tag", Value: "a"}}, options.FindOne().SetSort(bson.D{{Key: "n", Value: 1}})).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"_id": 3, "n": 2, "tag": "a"}`; canonical(t, doc) != canonical(t, extJSON(t, want)) {
+		t.Errorf("FindOne = %s, want %s", canonical(t, doc), want)
+	}
+	if err := s.FindOne(ctx, bson.D{{Key: "tag", Value: "z"}}).Err(); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("FindOne of nothing = %v, want mongo.ErrNoDocuments", err)
+	}
+	if _, err := s.Find(ctx, bson.D{{Key: "$where", Value: "true"}}); err == nil {
+		t.Error("Find with $where succeeded, want an error")
+	}
+}
+
+func TestStoreCountAndDistinct(t *testing.T) {
+	ctx := context.Background()
+	s := testStore(t,
+		`{"_id": 1, "tags": ["a", "b"], "team": "x"}`,
+		`{"_id": 2, "tags": ["b", "c"], "team": "x"}`,
+		`{"_id": 3, "tags": "d", "team": "y"}`,
+		`{"_id": 4}`,
+	)
+	for _, tt := range []struct {
+		filter bson.D
+		opts   *options.CountOptions
+		want   int64
+	}{
+		{bson.D{}, options.Count(), 4},
+		{bson.D{{Key: "team", Value: "x"}}, options.Count(), 2},
+		{bson.D{}, options.Count().SetLimit(1), 1},
+		{bson.D{}, options.Count().SetSkip(3), 1},
+		{bson.D{{Key: "team", Value: "z"}}, options.Count(), 0},
+	} {
+		if got, err := s.CountDocuments(ctx, tt.filter, tt.opts); err != nil || got != tt.want {
+			t.Errorf("CountDocuments(%v) = %d, %v, want %d", tt.filter, got, err, tt.want)
+		}
+	}
+
+	values, err := s.Distinct(ctx, "tags", bson.D{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := canonical(t, bson.D{{Key: "d", Value: values}}), list(t, `["a", "b", "c", "d"]`); got != want {
+		t.Errorf("Distinct(tags) = %s, want %s", got, want)
+	}
+	values, err = s.Distinct(ctx, "team", bson.D{{Key: "_id", Value: bson.D{{Key: "$gt", Value: 1}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || values[0] != "x" || values[1] != "y" {
+		t.Errorf("Distinct(team) = %v, want [x y]", values)
+	}
+}
+
+func TestStoreInsert(t *testing.T) {
+	ctx := context.Background()
+	s := testStore(t)
+	result, err := s.InsertOne(ctx, bson.D{{Key: "n", Value: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, ok := result.InsertedID.(primitive.ObjectID)
+	if !ok {
+		t.Fatalf("InsertOne without an _id gave %T, want an ObjectID", result.InsertedID)
+	}
+	if n, _ := s.CountDocuments(ctx, bson.D{{Key: "_id", Value: id}}); n != 1 {
+		t.Errorf("the inserted document isn't found by its _id")
+	}
+
+	type user struct {
+		ID   string `bson:"_id"`
+		Name string `bson:"name"`
+	}
+	many, err := s.InsertMany(ctx, []interface{}{user{"u1", "ann"}, bson.M{"_id": "u2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(many.InsertedIDs) != 2 || many.InsertedIDs[0] != "u1" || many.InsertedIDs[1] != "u2" {
+		t.Errorf("InsertMany = %v, want [u1 u2]", many.InsertedIDs)
+	}
+	if _, err := s.InsertOne(ctx, user{"u1", "bob"}); !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("inserting a duplicate _id = %v, want a duplicate key error", err)
+	}
+	if _, err := s.InsertOne(ctx, bson.D{{Key: "$set", Value: 1}}); err == nil {
+		t.Error("inserting an operator succeeded, want an error")
+	}
+}
+
+func TestStoreUpdate(t *testing.T) {
+	ctx := context.Background()
+	docs := []string{
+		`{"_id": 1, "n": 1, "tag": "a"}`,
+		`{"_id": 2, "n": 2, "tag": "a"}`,
+		`{"_id": 3, "n": 3, "tag": "b"}`,
+	}
+	tests := []struct {
+		name     string
+		many     bool
+		filter   bson.D
+		update   interface{}
+		upsert   bool
+		matched  int64
+		modified int64
+		upserted interface{}
+		want     string
+	}{
+		{"one", false, bson.D{{Key: "tag", Value: "a"}}, bson.D{{Key: "$inc", Value: bson.D{{Key: "n", Value: 10}}}}, false, 1, 1, nil,
+			`[{"_id": 1, "n": 11, "tag": "a"}, {"_id": 2, "n": 2, "tag": "a"}, {"_id": 3, "n": 3, "tag": "b"}]`},
+		{"many", true, bson.D{{Key: "tag", Value: "a"}}, bson.D{{Key: "$set", Value: bson.D{{Key: "tag", Value: "c"}}}}, false, 2, 2, nil,
+			`[{"_id": 1, "n": 1, "tag": "c"}, {"_id": 2, "n": 2, "tag": "c"}, {"_id": 3, "n": 3, "tag": "b"}]`},
+		{"unchanged", true, bson.D{}, bson.D{{Key: "$max", Value: bson.D{{Key: "n", Value: 2}}}}, false, 3, 1, nil,
+			`[{"_id": 1, "n": 2, "tag": "a"}, {"_id": 2, "n": 2, "tag": "a"}, {"_id": 3, "n": 3, "tag": "b"}]`},
+		{"no match", false, bson.D{{Key: "tag", Value: "z"}}, bson.D{{Key: "$set", Value: bson.D{{Key: "n", Value: 0}}}}, false, 0, 0, nil,
+			`[{"_id": 1, "n": 1, "tag": "a"}, {"_id": 2, "n": 2, "tag": "a"}, {"_id": 3, "n": 3, "tag": "b"}]`},
+		{"upsert matching", false, bson.D{{Key: "_id", Value: 3}}, bson.D{{Key: "$set", Value: bson.D{{Key: "n", Value: 0}}}}, true, 1, 1, nil,
+			`[{"_id": 1, "n": 1, "tag": "a"}, {"_id": 2, "n": 2, "tag": "a"}, {"_id": 3, "n": 0, "tag": "b"}]`},
+		{"upsert inserting", false, bson.D{{Key: "_id", Value: 4}, {Key: "tag", Value: "d"}}, bson.D{{Key: "$set", Value: bson.D{{Key: "n", Value: 4}}}, {Key: "$setOnInsert", Value: bson.D{{Key: "new", Value: true}}}}, true, 0, 0, int32(4),
+			`[{"_id": 1, "n": 1, "tag": "a"}, {"_id": 2, "n": 2, "tag": "a"}, {"_id": 3, "n": 3, "tag": "b"}, {"_id": 4, "tag": "d", "n": 4, "new": true}]`},
+		{"pipeline", true, bson.D{{Key: "tag", Value: "b"}}, mongo.Pipeline{{{Key: "$set", Value: bson.D{{Key: "m", Value: bson.D{{Key: "$multiply", Value: bson.A{"$n", 2}}}}}}}}, false, 1, 1, nil,
+			`[{"_id": 1, "n": 1, "tag": "a"}, {"_id": 2, "n": 2, "tag": "a"}, {"_id": 3, "n": 3, "tag": "b", "m": 6}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testStore(t, docs...)
+			update := s.UpdateOne
+			if tt.many {
+				update = s.UpdateMany
+			}
+			result, err := update(ctx, tt.filter, tt.update, options.Update().SetUpsert(tt.upsert))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.MatchedCount != tt.matched || result.ModifiedCount != tt.modified || result.UpsertedID != tt.upserted {
+				t.Errorf("result = %+v, want %d matched, %d modified and %v upserted", result, tt.matched, tt.modified, tt.upserted)
+			}
+			if got := contents(t, s); got != list(t, tt.want) {
+				t.Errorf("documents = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	s := testStore(t, docs...)
+	result, err := s.UpdateOne(ctx, bson.D{{Key: "tag", Value: "z"}}, bson.D{{Key: "$set", Value: bson.D{{Key: "n", Value: 5}}}}, options.Update().SetUpsert(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := result.UpsertedID.(primitive.ObjectID); !ok || result.UpsertedCount != 1 {
+		t.Errorf("upserting without an _id = %+v, want a new ObjectID", result)
+	}
+	if _, err := s.UpdateOne(ctx, bson.D{{Key: "_id", Value: 1}}, bson.D{{Key: "$set", Value: bson.D{{Key: "_id", Value: 9}}}}); !errors.Is(err, errImmutableID) {
+		t.Errorf("changing an _id = %v, want errImmutableID", err)
+	}
+	if _, err := s.UpdateOne(ctx, bson.D{{Key: "_id", Value: 1}}, bson.D{{Key: "n", Value: 9}}); err == nil {
+		t.Error("updating with a replacement document succeeded, want an error")
+	}
+	if _, err := s.UpdateMany(ctx, bson.D{}, bson.D{{Key: "$inc", Value: bson.D{{Key: "tag", Value: 1}}}}); err == nil {
+		t.Error("$inc of strings succeeded, want an error")
+	}
+	cursor, err := s.Find(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$lte", Value: 3}}}})
+	if got, want := all(t, cursor, err), list(t, `[{"_id": 1, "n": 1, "tag": "a"}, {"_id": 2, "n": 2, "tag": "a"}, {"_id": 3, "n": 3, "tag": "b"}]`); got != want {
+		t.Errorf("failed updates changed the documents to %s", got)
+	}
+}
+
+func TestStoreReplaceOne(t *testing.T) {
+	ctx := context.Background()
+	s := testStore(t, `{"_id": 1, "a": 1}`)
+	result, err := s.ReplaceOne(ctx, bson.D{{Key: "a", Value: 1}}, bson.D{{Key: "b", Value: 2}})
+	if err != nil || result.MatchedCount != 1 || result.ModifiedCount != 1 {
+		t.Fatalf("ReplaceOne = %+v, %v, want 1 matched and modified", result, err)
+	}
+	if got, want := contents(t, s), list(t, `[{"_id": 1, "b": 2}]`); got != want {
+		t.Errorf("after replacing, documents = %s, want %s", got, want)
+	}
+	if _, err := s.ReplaceOne(ctx, bson.D{{Key: "_id", Value: 1}}, bson.D{{Key: "_id", Value: 2}}); !errors.Is(err, errImmutableID) {
+		t.Errorf("replacing with another _id = %v, want errImmutableID", err)
+	}
+	if _, err := s.ReplaceOne(ctx, bson.D{{Key: "_id", Value: 1}}, bson.D{{Key: "$set", Value: bson.D{}}}); err == nil {
+		t.Error("replacing with operators succeeded, want an error")
+	}
+	result, err = s.ReplaceOne(ctx, bson.D{{Key: "_id", Value: 5}}, bson.D{{Key: "c", Value: 3}}, options.Replace().SetUpsert(true))
+	if err != nil || result.UpsertedID != int32(5) {
+		t.Fatalf("upserting ReplaceOne = %+v, %v, want _id 5 upserted", result, err)
+	}
+	if got, want := contents(t, s), list(t, `[{"_id": 1, "b": 2}, {"_id": 5, "c": 3}]`); got != want {
+		t.Errorf("after upserting, documents = %s, want %s", got, want)
+	}
+}
+
+func TestStoreFindOneAndUpdate(t *testing.T) {
+	ctx := context.Background()
+	s := testStore(t, `{"_id": 1, "n": 2}`, `{"_id": 2, "n": 1}`)
+	inc := bson.D{{Key: "$inc", Value: bson.D{{Key: "n", Value: 10}}}}
+
+	var before, after bson.D
+	if err := s.FindOneAndUpdate(ctx, bson.D{}, inc, options.FindOneAndUpdate().SetSort(bson.D{{Key: "n", Value: 1}})).Decode(&before); err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"_id": 2, "n": 1}`; canonical(t, before) != canonical(t, extJSON(t, want)) {
+		t.Errorf("FindOneAndUpdate returned %s, want the document before, %s", canonical(t, before), want)
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After).SetProjection(bson.D{{Key: "_id", Value: 0}})
+	if err := s.FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: 1}}, inc, opts).Decode(&after); err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"n": 12}`; canonical(t, after) != canonical(t, extJSON(t, want)) {
+		t.Errorf("FindOneAndUpdate returned %s, want the document after, %s", canonical(t, after), want)
+	}
+
+	if err := s.FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: 3}}, inc).Err(); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("FindOneAndUpdate of nothing = %v, want mongo.ErrNoDocuments", err)
+	}
+	if err := s.FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: 3}}, inc, options.FindOneAndUpdate().SetUpsert(true)).Err(); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("upserting FindOneAndUpdate returning the document before = %v, want mongo.ErrNoDocuments", err)
+	}
+	if err := s.FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: 4}}, inc, options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&after); err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"_id": 4, "n": 10}`; canonical(t, after) != canonical(t, extJSON(t, want)) {
+		t.Errorf("upserting FindOneAndUpdate returned %s, want %s", canonical(t, after), want)
+	}
+	if got, want := contents(t, s), list(t, `[{"_id": 1, "n": 12}, {"_id": 2, "n": 11}, {"_id": 3, "n": 10}, {"_id": 4, "n": 10}]`); got != want {
+		t.Errorf("documents = %s, want %s", got, want)
+	}
+}
+
+func TestStoreDelete(t *testing.T) {
+	ctx := context.Background()
+	s := testStore(t, `{"_id": 1, "n": 2}`, `{"_id": 2, "n": 1}`, `{"_id": 3, "n": 3}`, `{"_id": 4, "n": 1}`)
+
+	var deleted bson.D
+	if err := s.FindOneAndDelete(ctx, bson.D{}, options.FindOneAndDelete().SetSort(bson.D{{Key: "n", Value: -1}})).Decode(&deleted); err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"_id": 3, "n": 3}`; canonical(t, deleted) != canonical(t, extJSON(t, want)) {
+		t.Errorf("FindOneAndDelete returned %s, want %s", canonical(t, deleted), want)
+	}
+	if err := s.FindOneAndDelete(ctx, bson.D{{Key: "_id", Value: 3}}).Err(); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("FindOneAndDelete of nothing = %v, want mongo.ErrNoDocuments", err)
+	}
+
+	result, err := s.DeleteOne(ctx, bson.D{{Key: "n", Value: 1}})
+	if err != nil || result.DeletedCount != 1 {
+		t.Fatalf("DeleteOne = %+v, %v, want 1 deleted", result, err)
+	}
+	if got, want := contents(t, s), list(t, `[{"_id": 1, "n": 2}, {"_id": 4, "n": 1}]`); got != want {
+		t.Errorf("after DeleteOne, documents = %s, want %s", got, want)
+	}
+	result, err = s.DeleteMany(ctx, bson.D{{Key: "n", Value: bson.D{{Key: "$gte", Value: 1}}}})
+	if err != nil || result.DeletedCount != 2 {
+		t.Fatalf("DeleteMany = %+v, %v, want 2 deleted", result, err)
+	}
+	result, err = s.DeleteMany(ctx, bson.D{})
+	if err != nil || result.DeletedCount != 0 {
+		t.Fatalf("DeleteMany of nothing = %+v, %v, want 0 deleted", result, err)
+	}
+	if _, err := s.InsertOne(ctx, bson.D{{Key: "_id", Value: 1}}); err != nil {
+		t.Errorf("inserting a deleted _id again: %v", err)
+	}
+}
+
+func TestStoreAggregate(t *testing.T) {
+	ctx := context.Background()
+	s := testStore(t,
+		`{"_id": 1, "team": "a", "n": 1}`,
+		`{"_id": 2, "team": "b", "n": 2}`,
+		`{"_id": 3, "team": "a", "n": 3}`,
+	)
+	cursor, err := s.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{{Key: "n", Value: bson.D{{Key: "$gt", Value: 1}}}}}},
+		{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$team"}, {Key: "total", Value: bson.D{{Key: "$sum", Value: "$n"}}}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+	})
+	if got, want := all(t, cursor, err), list(t, `[{"_id": "a", "total": 3}, {"_id": "b", "total": 2}]`); got != want {
+		t.Errorf("Aggregate = %s, want %s", got, want)
+	}
+	if _, err := s.Aggregate(ctx, bson.D{{Key: "$match", Value: bson.D{}}}); err == nil {
+		t.Error("Aggregate of a document succeeded, want an error")
+	}
+}
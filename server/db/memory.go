@@ -0,0 +1,423 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// With the memory driver collections live in process memory, so the server
+// runs without a database for demos and CI. Nothing survives a restart.
+
+// memTxKey carries the journal of a memory transaction in a context
+type memTxKey struct{}
+
+// memTransactions serializes memory transactions
+var memTransactions sync.Mutex
+
+// ConnectMemory opens every collection in memory
+func ConnectMemory() error {
+	var tables []*memTable
+	openCollections(func(name string) Store {
+		table := &memTable{name: name, docs: make(map[string]memDocument)}
+		tables = append(tables, table)
+		return &documentStore{backend: table}
+	})
+
+	sweepCtx, stopSweeping := context.WithCancel(context.Background())
+	pingDB = func(context.Context) error { return nil }
+	closeDB = func(context.Context) error {
+		stopSweeping()
+		return nil
+	}
+	inTransaction = memTransaction
+
+	if err := createIndexes(context.Background()); err != nil {
+		return err
+	}
+	go sweepMemory(sweepCtx, tables)
+	return nil
+}
+
+// memJournal undoes the writes of a memory transaction that fails
+type memJournal struct {
+	undo []func()
+}
+
+// memTransaction runs fn while no other memory transaction runs, and undoes
+// its writes if it fails
+func memTransaction(ctx context.Context, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	if _, ok := ctx.Value(memTxKey{}).(*memJournal); ok {
+		return fn(ctx)
+	}
+	memTransactions.Lock()
+	defer memTransactions.Unlock()
+
+	journal := &memJournal{}
+	result, err := fn(context.WithValue(ctx, memTxKey{}, journal))
+	if err != nil {
+		for i := len(journal.undo) - 1; i >= 0; i-- {
+			journal.undo[i]()
+		}
+		return nil, err
+	}
+	return result, nil
+}
+
+// memDocument is a stored document and when it was inserted
+type memDocument struct {
+	seq uint64
+	raw bson.Raw
+}
+
+// memSlot is a place in a table's insertion order. It is stale once its
+// document is removed or inserted again.
+type memSlot struct {
+	key string
+	seq uint64
+}
+
+// memTable is the backend of a collection kept in memory
+type memTable struct {
+	name string
+
+	mu     sync.RWMutex
+	docs   map[string]memDocument // By idKey of _id
+	order  []memSlot
+	seq    uint64
+	unique []*memIndex
+	ttl    map[string]time.Duration // Field to expire documents after
+}
+
+// memIndex is a unique index
+type memIndex struct {
+	name    string
+	fields  [][]string
+	sparse  bool
+	entries map[string]string // Key to the idKey holding it
+}
+
+// key returns the index key of doc, and false when a sparse index skips it
+func (i *memIndex) key(doc bson.D) (string, bool, error) {
+	values := make(bson.A, len(i.fields))
+	present := false
+	for n, field := range i.fields {
+		value, ok := lookupPath(doc, field)
+		present = present || ok
+		values[n] = value
+	}
+	if i.sparse && !present {
+		return "", false, nil
+	}
+	key, err := idKey(values)
+	return key, true, err
+}
+
+func (t *memTable) candidates(ctx context.Context, filter bson.D) ([]bson.Raw, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.find(filter), nil
+}
+
+func (t *memTable) modify(ctx context.Context, filter bson.D, fn func(docs []bson.Raw, w writer) error) error {
+	journal, _ := ctx.Value(memTxKey{}).(*memJournal)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return fn(t.find(filter), &memWriter{table: t, journal: journal})
+}
+
+// find returns the documents filter may match, in insertion order. Only
+// lookups by _id are narrowed.
+func (t *memTable) find(filter bson.D) []bson.Raw {
+	if keys, ok := idKeys(filter); ok {
+		found := make(map[string]memDocument, len(keys))
+		for _, key := range keys {
+			if doc, ok := t.docs[key]; ok {
+				found[key] = doc
+			}
+		}
+		ordered := make([]memDocument, 0, len(found))
+		for _, doc := range found {
+			ordered = append(ordered, doc)
+		}
+		sort.Slice(ordered, func(i, j int) bool { return ordered[i].seq < ordered[j].seq })
+		docs := make([]bson.Raw, len(ordered))
+		for i, doc := range ordered {
+			docs[i] = doc.raw
+		}
+		return docs
+	}
+
+	docs := make([]bson.Raw, 0, len(t.docs))
+	for _, slot := range t.order {
+		if doc, ok := t.docs[slot.key]; ok && doc.seq == slot.seq {
+			docs = append(docs, doc.raw)
+		}
+	}
+	return docs
+}
+
+// idKeys returns the _ids a filter's top-level _id condition allows
+func idKeys(filter bson.D) ([]string, bool) {
+	for _, element := range filter {
+		if element.Key != "_id" {
+			continue
+		}
+		values := bson.A{element.Value}
+		if ops, ok := element.Value.(bson.D); ok && isOperatorDocument(ops) {
+			if len(ops) != 1 || ops[0].Key != "$in" {
+				return nil, false
+			}
+			if values, ok = ops[0].Value.(bson.A); !ok {
+				return nil, false
+			}
+		}
+		keys := make([]string, len(values))
+		for i, value := range values {
+			var ok bool
+			if keys[i], ok = exactJSON(value); !ok {
+				return nil, false
+			}
+		}
+		return keys, true
+	}
+	return nil, false
+}
+
+// memWriter writes a table's documents while its lock is held
+type memWriter struct {
+	table   *memTable
+	journal *memJournal
+}
+
+// record adds the undo of a write to the transaction's journal
+func (w *memWriter) record(undo func(t *memTable)) {
+	if w.journal == nil {
+		return
+	}
+	t := w.table
+	w.journal.undo = append(w.journal.undo, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		undo(t)
+	})
+}
+
+func (w *memWriter) insert(doc bson.Raw) error {
+	key, err := w.table.put(doc, "")
+	if err != nil {
+		return err
+	}
+	w.record(func(t *memTable) { t.delete(key) })
+	return nil
+}
+
+func (w *memWriter) replace(doc bson.Raw) error {
+	id, err := documentID(doc)
+	if err != nil {
+		return err
+	}
+	key, err := idKey(id)
+	if err != nil {
+		return err
+	}
+	old, ok := w.table.docs[key]
+	if !ok {
+		return nil
+	}
+	if _, err := w.table.put(doc, key); err != nil {
+		return err
+	}
+	w.record(func(t *memTable) { t.put(old.raw, key) })
+	return nil
+}
+
+func (w *memWriter) remove(id interface{}) error {
+	key, err := idKey(id)
+	if err != nil {
+		return err
+	}
+	old, ok := w.table.docs[key]
+	if !ok {
+		return nil
+	}
+	w.table.delete(key)
+	w.record(func(t *memTable) { t.put(old.raw, "") })
+	return nil
+}
+
+// put inserts doc, or replaces the document stored under replacing, after
+// checking the unique indexes
+func (t *memTable) put(raw bson.Raw, replacing string) (string, error) {
+	id, err := documentID(raw)
+	if err != nil {
+		return "", err
+	}
+	key, err := idKey(id)
+	if err != nil {
+		return "", err
+	}
+	if _, exists := t.docs[key]; exists && key != replacing {
+		return "", duplicateKeyError("_id_", key)
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return "", err
+	}
+
+	indexKeys := make([]string, len(t.unique))
+	indexed := make([]bool, len(t.unique))
+	for i, index := range t.unique {
+		if indexKeys[i], indexed[i], err = index.key(doc); err != nil {
+			return "", err
+		}
+		if holder, taken := index.entries[indexKeys[i]]; indexed[i] && taken && holder != replacing {
+			return "", duplicateKeyError(index.name, indexKeys[i])
+		}
+	}
+
+	if replacing != "" {
+		t.unindex(replacing)
+		t.docs[key] = memDocument{seq: t.docs[key].seq, raw: raw}
+	} else {
+		t.seq++
+		t.docs[key] = memDocument{seq: t.seq, raw: raw}
+		t.order = append(t.order, memSlot{key: key, seq: t.seq})
+	}
+	for i, index := range t.unique {
+		if indexed[i] {
+			index.entries[indexKeys[i]] = key
+		}
+	}
+	return key, nil
+}
+
+// delete removes the document stored under key
+func (t *memTable) delete(key string) {
+	if _, ok := t.docs[key]; !ok {
+		return
+	}
+	t.unindex(key)
+	delete(t.docs, key)
+
+	// Drop stale slots once they outnumber the documents
+	if len(t.order) > 2*len(t.docs)+64 {
+		order := make([]memSlot, 0, len(t.docs))
+		for _, slot := range t.order {
+			if doc, ok := t.docs[slot.key]; ok && doc.seq == slot.seq {
+				order = append(order, slot)
+			}
+		}
+		t.order = order
+	}
+}
+
+// unindex removes the unique index entries of the document under key
+func (t *memTable) unindex(key string) {
+	if len(t.unique) == 0 {
+		return
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(t.docs[key].raw, &doc); err != nil {
+		return
+	}
+	for _, index := range t.unique {
+		if entry, indexed, err := index.key(doc); err == nil && indexed && index.entries[entry] == key {
+			delete(index.entries, entry)
+		}
+	}
+}
+
+// createIndex builds the unique indexes of a model and records its TTL
+func (t *memTable) createIndex(ctx context.Context, model mongo.IndexModel) error {
+	keys, err := toDocument(model.Keys)
+	if err != nil {
+		return err
+	}
+	opts := model.Options
+	if opts == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if opts.ExpireAfterSeconds != nil && len(keys) == 1 {
+		if t.ttl == nil {
+			t.ttl = make(map[string]time.Duration)
+		}
+		t.ttl[keys[0].Key] = time.Duration(*opts.ExpireAfterSeconds) * time.Second
+	}
+	if opts.Unique == nil || !*opts.Unique {
+		return nil
+	}
+
+	index := &memIndex{sparse: opts.Sparse != nil && *opts.Sparse, entries: make(map[string]string)}
+	for _, key := range keys {
+		index.fields = append(index.fields, splitPath(key.Key))
+		if index.name != "" {
+			index.name += "_"
+		}
+		index.name += fmt.Sprint(key.Key, "_", key.Value)
+	}
+	for key, stored := range t.docs {
+		var doc bson.D
+		if err := bson.Unmarshal(stored.raw, &doc); err != nil {
+			return err
+		}
+		entry, indexed, err := index.key(doc)
+		if err != nil {
+			return err
+		}
+		if !indexed {
+			continue
+		}
+		if _, taken := index.entries[entry]; taken {
+			return duplicateKeyError(index.name, entry)
+		}
+		index.entries[entry] = key
+	}
+	t.unique = append(t.unique, index)
+	return nil
+}
+
+// sweepMemory deletes documents whose TTL has passed until ctx is done
+func sweepMemory(ctx context.Context, tables []*memTable) {
+	ticker := time.NewTicker(TTLSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, table := range tables {
+			table.expire(time.Now())
+		}
+	}
+}
+
+// expire deletes the documents whose TTL passed before now
+func (t *memTable) expire(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for field, after := range t.ttl {
+		cutoff := primitive.NewDateTimeFromTime(now.Add(-after))
+		for key, doc := range t.docs {
+			value, err := doc.raw.LookupErr(splitPath(field)...)
+			if err != nil {
+				continue
+			}
+			if date, ok := value.DateTimeOK(); ok && primitive.DateTime(date) < cutoff {
+				t.delete(key)
+			}
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// indexedStore returns a store in memory with one index built on keys
+func indexedStore(t *testing.T, keys bson.D, opts *options.IndexOptions, docs ...string) *documentStore {
+	t.Helper()
+	s := testStore(t, docs...)
+	if err := s.createIndex(context.Background(), mongo.IndexModel{Keys: keys, Options: opts}); err != nil {
+		t.Fatalf("creating index %v: %v", keys, err)
+	}
+	return s
+}
+
+// sorted returns everything in s by _id
+func sorted(t *testing.T, s *documentStore) string {
+	t.Helper()
+	cursor, err := s.Find(context.Background(), bson.D{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	return all(t, cursor, err)
+}
+
+func TestMemoryUniqueIndex(t *testing.T) {
+	ctx := context.Background()
+	email := bson.D{{Key: "email", Value: 1}}
+	set := func(field string, value interface{}) bson.D {
+		return bson.D{{Key: "$set", Value: bson.D{{Key: field, Value: value}}}}
+	}
+
+	s := indexedStore(t, email, options.Index().SetUnique(true), `{"_id": 1, "email": "a"}`, `{"_id": 2, "email": "b"}`)
+	if _, err := s.InsertOne(ctx, bson.D{{Key: "_id", Value: 3}, {Key: "email", Value: "a"}}); !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("inserting a taken key = %v, want a duplicate key error", err)
+	}
+	if _, err := s.UpdateOne(ctx, bson.D{{Key: "_id", Value: 2}}, set("email", "a")); !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("updating to a taken key = %v, want a duplicate key error", err)
+	}
+	if _, err := s.ReplaceOne(ctx, bson.D{{Key: "_id", Value: 2}}, bson.D{{Key: "email", Value: "a"}}); !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("replacing with a taken key = %v, want a duplicate key error", err)
+	}
+	if _, err := s.UpdateOne(ctx, bson.D{{Key: "_id", Value: 3}}, set("email", "a"), options.Update().SetUpsert(true)); !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("upserting a taken key = %v, want a duplicate key error", err)
+	}
+	if got, want := sorted(t, s), list(t, `[{"_id": 1, "email": "a"}, {"_id": 2, "email": "b"}]`); got != want {
+		t.Errorf("after the conflicts, documents = %s, want %s", got, want)
+	}
+
+	// A document keeps its own key through updates
+	if _, err := s.UpdateOne(ctx, bson.D{{Key: "_id", Value: 1}}, bson.D{{Key: "$set", Value: bson.D{{Key: "email", Value: "a"}, {Key: "n", Value: 1}}}}); err != nil {
+		t.Errorf("updating the document holding a key: %v", err)
+	}
+	// and frees it when the key changes or the document goes
+	if _, err := s.UpdateOne(ctx, bson.D{{Key: "_id", Value: 1}}, set("email", "c")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.InsertOne(ctx, bson.D{{Key: "_id", Value: 3}, {Key: "email", Value: "a"}}); err != nil {
+		t.Errorf("inserting a key freed by an update: %v", err)
+	}
+	if _, err := s.DeleteOne(ctx, bson.D{{Key: "_id", Value: 2}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.UpdateOne(ctx, bson.D{{Key: "_id", Value: 3}}, set("email", "b")); err != nil {
+		t.Errorf("updating to a key freed by a delete: %v", err)
+	}
+	if _, err := s.InsertOne(ctx, bson.D{{Key: "_id", Value: 4}, {Key: "email", Value: "a"}}); err != nil {
+		t.Errorf("inserting a key freed by an update: %v", err)
+	}
+
+	// InsertMany is ordered: it stops at a conflict, keeping what came
+	// before it
+	_, err := s.InsertMany(ctx, []interface{}{bson.D{{Key: "_id", Value: 5}, {Key: "email", Value: "e"}}, bson.D{{Key: "_id", Value: 6}, {Key: "email", Value: "e"}}, bson.D{{Key: "_id", Value: 7}, {Key: "email", Value: "f"}}})
+	if !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("InsertMany with a conflict = %v, want a duplicate key error", err)
+	}
+	if n, _ := s.CountDocuments(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$gte", Value: 5}}}}); n != 1 {
+		t.Errorf("InsertMany with a conflict at the second document inserted %d, want 1", n)
+	}
+}
+
+func TestMemoryCompoundIndex(t *testing.T) {
+	ctx := context.Background()
+	s := indexedStore(t, bson.D{{Key: "tenantId", Value: 1}, {Key: "email", Value: 1}}, options.Index().SetUnique(true),
+		`{"_id": 1, "tenantId": "t1", "email": "a"}`)
+	for _, tt := range []struct {
+		doc  string
+		dupe bool
+	}{
+		{`{"_id": 2, "tenantId": "t2", "email": "a"}`, false},
+		{`{"_id": 3, "tenantId": "t1", "email": "b"}`, false},
+		{`{"_id": 4, "email": "a"}`, false},
+		{`{"_id": 5, "tenantId": "t1", "email": "a"}`, true},
+		{`{"_id": 6, "email": "a", "tenantId": null}`, true},
+	} {
+		_, err := s.InsertOne(ctx, extJSON(t, tt.doc))
+		if tt.dupe != mongo.IsDuplicateKeyError(err) || (!tt.dupe && err != nil) {
+			t.Errorf("inserting %s = %v, want a duplicate key error: %v", tt.doc, err, tt.dupe)
+		}
+	}
+}
+
+func TestMemorySparseIndex(t *testing.T) {
+	ctx := context.Background()
+	code := bson.D{{Key: "code", Value: 1}}
+
+	sparse := indexedStore(t, code, options.Index().SetUnique(true).SetSparse(true), `{"_id": 1}`)
+	if _, err := sparse.InsertOne(ctx, bson.D{{Key: "_id", Value: 2}}); err != nil {
+		t.Errorf("a sparse index refused a second document without the field: %v", err)
+	}
+	if _, err := sparse.InsertOne(ctx, bson.D{{Key: "_id", Value: 3}, {Key: "code", Value: nil}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sparse.InsertOne(ctx, bson.D{{Key: "_id", Value: 4}, {Key: "code", Value: nil}}); !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("a sparse index took a second null = %v, want a duplicate key error", err)
+	}
+
+	dense := indexedStore(t, code, options.Index().SetUnique(true), `{"_id": 1}`)
+	if _, err := dense.InsertOne(ctx, bson.D{{Key: "_id", Value: 2}}); !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("an index that isn't sparse took a second document without the field = %v, want a duplicate key error", err)
+	}
+	if _, err := dense.InsertOne(ctx, bson.D{{Key: "_id", Value: 3}, {Key: "code", Value: nil}}); !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("an index that isn't sparse took a null next to a missing field = %v, want a duplicate key error", err)
+	}
+}
+
+func TestMemoryCreateIndex(t *testing.T) {
+	ctx := context.Background()
+	s := testStore(t, `{"_id": 1, "email": "a"}`, `{"_id": 2, "email": "a"}`)
+	err := s.createIndex(ctx, mongo.IndexModel{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)})
+	if !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("building a unique index over duplicates = %v, want a duplicate key error", err)
+	}
+	// The failed index isn't kept
+	if _, err := s.InsertOne(ctx, bson.D{{Key: "_id", Value: 3}, {Key: "email", Value: "a"}}); err != nil {
+		t.Errorf("inserting after a failed index: %v", err)
+	}
+
+	// Indexes that aren't unique change nothing
+	if err := s.createIndex(ctx, mongo.IndexModel{Keys: bson.D{{Key: "email", Value: 1}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.InsertOne(ctx, bson.D{{Key: "_id", Value: 4}, {Key: "email", Value: "a"}}); err != nil {
+		t.Errorf("inserting next to an index that isn't unique: %v", err)
+	}
+}
+
+func TestMemoryIDs(t *testing.T) {
+	ctx := context.Background()
+	s := testStore(t, `{"_id": "x"}`, `{"_id": 1}`)
+	for _, doc := range []string{`{"_id": "x"}`, `{"_id": 1}`} {
+		if _, err := s.InsertOne(ctx, extJSON(t, doc)); !mongo.IsDuplicateKeyError(err) {
+			t.Errorf("inserting %s again = %v, want a duplicate key error", doc, err)
+		}
+	}
+	if _, err := s.InsertOne(ctx, bson.D{{Key: "_id", Value: "1"}}); err != nil {
+		t.Errorf("inserting the string \"1\" next to the number 1: %v", err)
+	}
+
+	// Lookups by _id return documents in insertion order, once each,
+	// whether or not the _ids narrow what is scanned
+	for _, tt := range []struct {
+		ids  bson.A
+		want string
+	}{
+		{bson.A{"1", "x", "x", "y"}, `[{"_id": "x"}, {"_id": "1"}]`},
+		{bson.A{"1", 1, "x", 1}, `[{"_id": "x"}, {"_id": 1}, {"_id": "1"}]`},
+	} {
+		cursor, err := s.Find(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: tt.ids}}}})
+		if got, want := all(t, cursor, err), list(t, tt.want); got != want {
+			t.Errorf("Find by _id in %v = %s, want %s", tt.ids, got, want)
+		}
+	}
+}
+
+func TestMemoryTransaction(t *testing.T) {
+	ctx := context.Background()
+	errFailed := errors.New("failed")
+	s := indexedStore(t, bson.D{{Key: "email", Value: 1}}, options.Index().SetUnique(true),
+		`{"_id": 1, "email": "a"}`, `{"_id": 2, "email": "b"}`, `{"_id": 3, "email": "c"}`)
+	const before = `[{"_id": 1, "email": "a"}, {"_id": 2, "email": "b"}, {"_id": 3, "email": "c"}]`
+
+	_, err := memTransaction(ctx, func(ctx context.Context) (interface{}, error) {
+		if _, err := s.InsertOne(ctx, bson.D{{Key: "_id", Value: 4}, {Key: "email", Value: "d"}}); err != nil {
+			return nil, err
+		}
+		if _, err := s.UpdateOne(ctx, bson.D{{Key: "_id", Value: 1}}, bson.D{{Key: "$set", Value: bson.D{{Key: "email", Value: "e"}}}}); err != nil {
+			return nil, err
+		}
+		if _, err := s.UpdateOne(ctx, bson.D{{Key: "_id", Value: 1}}, bson.D{{Key: "$set", Value: bson.D{{Key: "n", Value: 1}}}}); err != nil {
+			return nil, err
+		}
+		if _, err := s.DeleteOne(ctx, bson.D{{Key: "_id", Value: 2}}); err != nil {
+			return nil, err
+		}
+		// The transaction sees its own writes
+		if n, _ := s.CountDocuments(ctx, bson.D{{Key: "email", Value: bson.D{{Key: "$in", Value: bson.A{"d", "e"}}}}}); n != 2 {
+			t.Errorf("inside the transaction, %d documents have its keys, want 2", n)
+		}
+		return nil, errFailed
+	})
+	if !errors.Is(err, errFailed) {
+		t.Fatalf("memTransaction = %v, want its function's error", err)
+	}
+	if got, want := sorted(t, s), list(t, before); got != want {
+		t.Errorf("after rolling back, documents = %s, want %s", got, want)
+	}
+	// The unique index is rolled back with the documents
+	for _, email := range []string{"a", "b"} {
+		if _, err := s.InsertOne(ctx, bson.D{{Key: "email", Value: email}}); !mongo.IsDuplicateKeyError(err) {
+			t.Errorf("after rolling back, inserting %s = %v, want a duplicate key error", email, err)
+		}
+	}
+	for _, email := range []string{"d", "e"} {
+		if _, err := s.InsertOne(ctx, bson.D{{Key: "email", Value: email}}); err != nil {
+			t.Errorf("after rolling back, inserting %s: %v", email, err)
+		}
+	}
+	if _, err := s.DeleteMany(ctx, bson.D{{Key: "email", Value: bson.D{{Key: "$in", Value: bson.A{"d", "e"}}}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A write that fails mid-transaction is rolled back with the others
+	_, err = memTransaction(ctx, func(ctx context.Context) (interface{}, error) {
+		if _, err := s.UpdateOne(ctx, bson.D{{Key: "_id", Value: 3}}, bson.D{{Key: "$set", Value: bson.D{{Key: "email", Value: "z"}}}}); err != nil {
+			return nil, err
+		}
+		return s.InsertOne(ctx, bson.D{{Key: "_id", Value: 5}, {Key: "email", Value: "a"}})
+	})
+	if !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("memTransaction = %v, want a duplicate key error", err)
+	}
+	if got, want := sorted(t, s), list(t, before); got != want {
+		t.Errorf("after a failed write, documents = %s, want %s", got, want)
+	}
+
+	// Nested transactions join the outer one
+	result, err := memTransaction(ctx, func(ctx context.Context) (interface{}, error) {
+		if _, err := s.DeleteOne(ctx, bson.D{{Key: "_id", Value: 3}}); err != nil {
+			return nil, err
+		}
+		return memTransaction(ctx, func(ctx context.Context) (interface{}, error) {
+			_, err := s.InsertOne(ctx, bson.D{{Key: "_id", Value: 6}, {Key: "email", Value: "c"}})
+			return "done", err
+		})
+	})
+	if err != nil || result != "done" {
+		t.Fatalf("nested memTransaction = %v, %v, want done", result, err)
+	}
+	if got, want := sorted(t, s), list(t, `[{"_id": 1, "email": "a"}, {"_id": 2, "email": "b"}, {"_id": 6, "email": "c"}]`); got != want {
+		t.Errorf("after committing, documents = %s, want %s", got, want)
+	}
+	_, err = memTransaction(ctx, func(ctx context.Context) (interface{}, error) {
+		if _, err := s.DeleteOne(ctx, bson.D{{Key: "_id", Value: 6}}); err != nil {
+			return nil, err
+		}
+		return memTransaction(ctx, func(ctx context.Context) (interface{}, error) {
+			return nil, errFailed
+		})
+	})
+	if !errors.Is(err, errFailed) {
+		t.Fatalf("nested memTransaction = %v, want the inner error", err)
+	}
+	if n, _ := s.CountDocuments(ctx, bson.D{{Key: "_id", Value: 6}}); n != 1 {
+		t.Error("an error from a nested transaction didn't roll back the outer one")
+	}
+}
+
+func TestMemoryExpire(t *testing.T) {
+	ctx := context.Background()
+	s := testStore(t)
+	table := s.backend.(*memTable)
+	for _, model := range []mongo.IndexModel{
+		{Keys: bson.D{{Key: "lastActive", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(60)},
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	} {
+		if err := s.createIndex(ctx, model); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := testTime
+	for _, doc := range []bson.D{
+		{{Key: "_id", Value: "stale"}, {Key: "lastActive", Value: now.Add(-2 * time.Minute)}},
+		{{Key: "_id", Value: "active"}, {Key: "lastActive", Value: now.Add(-30 * time.Second)}},
+		{{Key: "_id", Value: "expired"}, {Key: "expiresAt", Value: now.Add(-time.Second)}},
+		{{Key: "_id", Value: "unexpired"}, {Key: "expiresAt", Value: now.Add(time.Second)}},
+		{{Key: "_id", Value: "not a date"}, {Key: "expiresAt", Value: "2020-01-01"}},
+		{{Key: "_id", Value: "no field"}},
+	} {
+		if _, err := s.InsertOne(ctx, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	table.expire(now)
+	cursor, err := s.Find(ctx, bson.D{}, options.Find().SetProjection(bson.D{{Key: "_id", Value: 1}}))
+	if got, want := all(t, cursor, err), list(t, `[{"_id": "active"}, {"_id": "unexpired"}, {"_id": "not a date"}, {"_id": "no field"}]`); got != want {
+		t.Errorf("after expiring, documents = %s, want %s", got, want)
+	}
+	if _, err := s.InsertOne(ctx, bson.D{{Key: "_id", Value: "stale"}}); err != nil {
+		t.Errorf("inserting the _id of an expired document: %v", err)
+	}
+}
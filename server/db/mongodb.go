@@ -3,46 +3,86 @@ package db
 import (
 	"context"
 	"log"
-	"os"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
+
+	"video-meeting-app/config"
 )
 
 var (
 	Client     *mongo.Client
 	Database   *mongo.Database
-	Users      *mongo.Collection
-	Meetings   *mongo.Collection
-	Participants *mongo.Collection
+	Users      *Collection
+	Meetings   *Collection
+	Participants *Collection
+	Polls      *Collection
+	Invitations *Collection
+	ChatMessages *Collection
+	AttendanceEvents *Collection
+	LoginAttempts Store
+	SecurityEvents *Collection
+	Webhooks *Collection
+	WebhookDeliveries *Collection
+	SlackInstallations *Collection
+	DeviceTokens *Collection
+	EmailDeadLetters Store
+	Contacts *Collection
+	Organizations *Collection
+	OrgMembers *Collection
+	OrgInvites *Collection
+	Usage *Collection
+	TranscriptUtterances *Collection
+	TalkTime *Collection
+	Recordings *Collection
+	ChatAttachments *Collection
+	ChatReads *Collection
+	SigningKeys Store
+	ConnectionQuality *Collection
+	WhiteboardEvents *Collection
+	WhiteboardSnapshots *Collection
+	MeetingNotes *Collection
+	NotesOps *Collection
+	MeetingEvents *Collection
+	APIKeys *Collection
+	CORSOrigins Store
+	DataKeys Store
+	Outbox *Collection
+	JobLocks Store
+	DailyAnalytics *Collection
+	Sessions *Collection
+	OverflowQueue *Collection
+	MeetingsArchive *Collection
+	MeetingHistory *Collection
 )
 
 // ConnectDB establishes connection to MongoDB with proper configuration
-func ConnectDB() error {
-	// Get MongoDB URI from environment variable
-	mongoURI := os.Getenv("MONGODB_URI")
-	if mongoURI == "" {
-		mongoURI = "mongodb://localhost:27017" // Default local URI
-	}
-
-	// Configure client options with improved settings
+func ConnectDB(cfg config.MongoConfig) error {
+	// Configure client options from the mongo section of the config
 	clientOptions := options.Client().
-		ApplyURI(mongoURI).
-		SetMaxPoolSize(200).                    // Increased pool size
-		SetMinPoolSize(20).                     // Increased min pool size
-		SetMaxConnIdleTime(10 * time.Minute).   // Increased idle time
-		SetConnectTimeout(15 * time.Second).    // Increased connect timeout
-		SetServerSelectionTimeout(10 * time.Second). // Increased server selection timeout
+		ApplyURI(cfg.URI).
+		SetMaxPoolSize(cfg.MaxPoolSize).
+		SetMinPoolSize(cfg.MinPoolSize).
+		SetMaxConnIdleTime(time.Duration(cfg.MaxConnIdleTime)).
+		SetConnectTimeout(time.Duration(cfg.ConnectTimeout)).
+		SetServerSelectionTimeout(time.Duration(cfg.ServerSelectionTimeout)).
 		SetRetryWrites(true).
 		SetRetryReads(true).
-		SetHeartbeatInterval(10 * time.Second). // Added heartbeat
-		SetMaxConnecting(50)                    // Limit concurrent connections
+		SetHeartbeatInterval(time.Duration(cfg.HeartbeatInterval)).
+		SetMaxConnecting(cfg.MaxConnecting).
+		SetMonitor(otelmongo.NewMonitor()) // Trace every command under the caller's span
+	if cfg.QueryTimeout > 0 {
+		// Operations stop at the caller's deadline or cancellation, or after
+		// QueryTimeout when the caller set no deadline
+		clientOptions.SetTimeout(time.Duration(cfg.QueryTimeout))
+	}
 
 	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ConnectTimeout))
 	defer cancel()
 
 	// Connect to MongoDB
@@ -58,10 +98,10 @@ func ConnectDB() error {
 
 	// Set global variables
 	Client = client
-	Database = client.Database("video_meeting_app")
-	Users = Database.Collection("users")
-	Meetings = Database.Collection("meetings")
-	Participants = Database.Collection("participants")
+	Database = client.Database(cfg.Database)
+	openCollections(func(name string) Store { return Database.Collection(name) })
+	pingDB = func(ctx context.Context) error { return client.Ping(ctx, nil) }
+	closeDB = client.Disconnect
 
 	// Create indexes
 	if err := createIndexes(ctx); err != nil {
@@ -74,9 +114,9 @@ func ConnectDB() error {
 
 // createIndexes creates necessary indexes for collections
 func createIndexes(ctx context.Context) error {
-	// Create unique index on email field for users
-	_, err := Users.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys:    bson.D{{Key: "email", Value: 1}},
+	// Create unique index on email field for users; each tenant has its own accounts
+	err := createIndex(ctx, Users, mongo.IndexModel{
+		Keys:    bson.D{{Key: "tenantId", Value: 1}, {Key: "email", Value: 1}},
 		Options: options.Index().SetUnique(true),
 	})
 	if err != nil {
@@ -84,7 +124,7 @@ func createIndexes(ctx context.Context) error {
 	}
 
 	// Create compound index on meeting and participant for faster lookups
-	_, err = Participants.Indexes().CreateOne(ctx, mongo.IndexModel{
+	err = createIndex(ctx, Participants, mongo.IndexModel{
 		Keys: bson.D{
 			{Key: "meetingId", Value: 1},
 			{Key: "userId", Value: 1},
@@ -95,54 +135,434 @@ func createIndexes(ctx context.Context) error {
 		return err
 	}
 
-	// Create TTL index for participants to auto-remove after 24 hours
-	_, err = Participants.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys: bson.D{{Key: "lastActive", Value: 1}},
-		Options: options.Index().SetExpireAfterSeconds(86400), // 24 hours
+	// Create index on createdBy field for meetings
+	err = createIndex(ctx, Meetings, mongo.IndexModel{
+		Keys: bson.D{{Key: "createdBy", Value: 1}},
 	})
 	if err != nil {
 		return err
 	}
 
-	// Create index on createdBy field for meetings
-	_, err = Meetings.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys: bson.D{{Key: "createdBy", Value: 1}},
+	// Create unique index on short meeting codes; sparse so older meetings without a code are allowed
+	err = createIndex(ctx, Meetings, mongo.IndexModel{
+		Keys:    bson.D{{Key: "code", Value: 1}},
+		Options: options.Index().SetUnique(true).SetSparse(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create index matching the meetings listing sort order for cursor pagination
+	err = createIndex(ctx, Meetings, mongo.IndexModel{
+		Keys: bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}},
 	})
 	if err != nil {
 		return err
 	}
 
 	// Create index on scheduledFor field for meetings
-	_, err = Meetings.Indexes().CreateOne(ctx, mongo.IndexModel{
+	err = createIndex(ctx, Meetings, mongo.IndexModel{
 		Keys: bson.D{{Key: "scheduledFor", Value: 1}},
 	})
 	if err != nil {
 		return err
 	}
 
-	return nil
-}
+	// Create index on meetingId field for polls
+	err = createIndex(ctx, Polls, mongo.IndexModel{
+		Keys: bson.D{{Key: "meetingId", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
 
-// CloseDB closes the MongoDB connection
-func CloseDB() {
-	if Client != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-		if err := Client.Disconnect(ctx); err != nil {
-			log.Printf("Error disconnecting from MongoDB: %v", err)
-		}
+	// Create unique index so each address has one invitation per meeting
+	err = createIndex(ctx, Invitations, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "meetingId", Value: 1},
+			{Key: "email", Value: 1},
+		},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create index for reading a meeting's chat history in order
+	err = createIndex(ctx, ChatMessages, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "meetingId", Value: 1},
+			{Key: "timestamp", Value: 1},
+		},
+	})
+	if err != nil {
+		return err
 	}
-}
 
-// IsConnected checks if the database connection is alive
-func IsConnected() bool {
-	if Client == nil {
-		return false
+	// Create index for replaying a meeting's attendance in order
+	err = createIndex(ctx, AttendanceEvents, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "meetingId", Value: 1},
+			{Key: "at", Value: 1},
+		},
+	})
+	if err != nil {
+		return err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	// Create TTL index so failed login counters are forgotten after a day without failures
+	err = createIndex(ctx, LoginAttempts, mongo.IndexModel{
+		Keys:    bson.D{{Key: "lastFailure", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(86400), // 24 hours
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create index for reading a user's security events newest first
+	err = createIndex(ctx, SecurityEvents, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "userId", Value: 1},
+			{Key: "at", Value: -1},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create index for counting recent registrations from an IP
+	err = createIndex(ctx, SecurityEvents, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "type", Value: 1},
+			{Key: "ip", Value: 1},
+			{Key: "at", Value: -1},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create index for finding a user's webhooks subscribed to an event
+	err = createIndex(ctx, Webhooks, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "userId", Value: 1},
+			{Key: "events", Value: 1},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create unique index so each address appears once per address book
+	err = createIndex(ctx, Contacts, mongo.IndexModel{
+		Keys:    bson.D{{Key: "ownerId", Value: 1}, {Key: "email", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create index for linking contacts when a user registers
+	err = createIndex(ctx, Contacts, mongo.IndexModel{
+		Keys: bson.D{{Key: "email", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create unique index so a user joins an organization once
+	err = createIndex(ctx, OrgMembers, mongo.IndexModel{
+		Keys:    bson.D{{Key: "orgId", Value: 1}, {Key: "userId", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create index for listing a user's organizations
+	err = createIndex(ctx, OrgMembers, mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create unique index so each address has one pending invite per organization
+	err = createIndex(ctx, OrgInvites, mongo.IndexModel{
+		Keys:    bson.D{{Key: "orgId", Value: 1}, {Key: "email", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create index for listing an organization's meetings
+	err = createIndex(ctx, Meetings, mongo.IndexModel{
+		Keys: bson.D{{Key: "orgId", Value: 1}, {Key: "createdAt", Value: -1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create sparse index for finding meetings past their plan's time limit
+	err = createIndex(ctx, Meetings, mongo.IndexModel{
+		Keys:    bson.D{{Key: "endsAt", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create index for reading a meeting's transcript in order
+	err = createIndex(ctx, TranscriptUtterances, mongo.IndexModel{
+		Keys: bson.D{{Key: "meetingId", Value: 1}, {Key: "startedAt", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create index for loading a meeting's talk time
+	err = createIndex(ctx, TalkTime, mongo.IndexModel{
+		Keys: bson.D{{Key: "meetingId", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create indexes for listing a meeting's connection quality; aggregates
+	// are forgotten a day after the last report
+	err = createIndex(ctx, ConnectionQuality, mongo.IndexModel{
+		Keys: bson.D{{Key: "meetingId", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+	err = createIndex(ctx, ConnectionQuality, mongo.IndexModel{
+		Keys:    bson.D{{Key: "updatedAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(86400), // 24 hours
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create index for replaying a meeting's whiteboard events in order
+	err = createIndex(ctx, WhiteboardEvents, mongo.IndexModel{
+		Keys: bson.D{{Key: "meetingId", Value: 1}, {Key: "seq", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create index for listing a service account's API keys
+	err = createIndex(ctx, APIKeys, mongo.IndexModel{
+		Keys: bson.D{{Key: "accountId", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create unique index so an origin is only added once for the service
+	// and once per organization
+	err = createIndex(ctx, CORSOrigins, mongo.IndexModel{
+		Keys:    bson.D{{Key: "origin", Value: 1}, {Key: "orgId", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create index for listing a user's service accounts
+	err = createIndex(ctx, Users, mongo.IndexModel{
+		Keys:    bson.D{{Key: "serviceAccountOf", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create index for listing a meeting's timeline in order
+	err = createIndex(ctx, MeetingEvents, mongo.IndexModel{
+		Keys: bson.D{{Key: "meetingId", Value: 1}, {Key: "at", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create index for transforming notes edits against later operations
+	err = createIndex(ctx, NotesOps, mongo.IndexModel{
+		Keys: bson.D{{Key: "meetingId", Value: 1}, {Key: "version", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create index for listing a meeting's recordings
+	err = createIndex(ctx, Recordings, mongo.IndexModel{
+		Keys: bson.D{{Key: "meetingId", Value: 1}, {Key: "startedAt", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create index for cleaning up a meeting's chat attachments
+	err = createIndex(ctx, ChatAttachments, mongo.IndexModel{
+		Keys: bson.D{{Key: "meetingId", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create indexes for purging chat and recordings past their retention
+	err = createIndex(ctx, ChatMessages, mongo.IndexModel{
+		Keys: bson.D{{Key: "timestamp", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+	err = createIndex(ctx, ChatAttachments, mongo.IndexModel{
+		Keys: bson.D{{Key: "createdAt", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+	err = createIndex(ctx, Recordings, mongo.IndexModel{
+		Keys: bson.D{{Key: "createdAt", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create index for looking up a user's push devices
+	err = createIndex(ctx, DeviceTokens, mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create index for the delivery worker's queue of due attempts
+	err = createIndex(ctx, WebhookDeliveries, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "status", Value: 1},
+			{Key: "nextAttemptAt", Value: 1},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create index for a webhook's delivery log, newest first
+	err = createIndex(ctx, WebhookDeliveries, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "webhookId", Value: 1},
+			{Key: "createdAt", Value: -1},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create index for the event dispatcher's queue of due events
+	err = createIndex(ctx, Outbox, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "status", Value: 1},
+			{Key: "nextAttemptAt", Value: 1},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create TTL index so dispatched events are kept for a week
+	err = createIndex(ctx, Outbox, mongo.IndexModel{
+		Keys:    bson.D{{Key: "processedAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(7 * 86400), // 7 days
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create index for ending a user's sessions
+	err = createIndex(ctx, Sessions, mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create TTL index so sessions are dropped once they expire
+	err = createIndex(ctx, Sessions, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create unique index so a user waits for a meeting at most once, and
+	// for taking the queue in order
+	err = createIndex(ctx, OverflowQueue, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "meetingId", Value: 1},
+			{Key: "userId", Value: 1},
+		},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+	err = createIndex(ctx, OverflowQueue, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "meetingId", Value: 1},
+			{Key: "queuedAt", Value: 1},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create index matching the archived meetings listing, by member
+	err = createIndex(ctx, MeetingsArchive, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "memberIds", Value: 1},
+			{Key: "createdAt", Value: -1},
+			{Key: "_id", Value: -1},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create index for finding archived meetings by code
+	err = createIndex(ctx, MeetingsArchive, mongo.IndexModel{
+		Keys:    bson.D{{Key: "code", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create unique index so each user has one history entry per meeting,
+	// and one matching the recent meetings listing
+	err = createIndex(ctx, MeetingHistory, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "userId", Value: 1},
+			{Key: "meetingId", Value: 1},
+		},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+	err = createIndex(ctx, MeetingHistory, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "userId", Value: 1},
+			{Key: "lastJoinedAt", Value: -1},
+		},
+	})
+	if err != nil {
+		return err
+	}
 
-	err := Client.Ping(ctx, nil)
-	return err == nil
-} 
\ No newline at end of file
+	return nil
+}
@@ -0,0 +1,764 @@
+package db
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// missingValue is what an expression referring to an absent field yields.
+// Fields set to it are left out.
+type missingValue struct{}
+
+var missing = missingValue{}
+
+// orNull turns a missing value into null
+func orNull(v interface{}) interface{} {
+	if v == missing {
+		return nil
+	}
+	return v
+}
+
+// toPipeline reads an aggregation pipeline given as mongo.Pipeline, a slice
+// of documents or a bson.A. ok is false for values that aren't a list.
+func toPipeline(v interface{}) (stages []bson.D, ok bool, err error) {
+	if v == nil {
+		return nil, false, nil
+	}
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Slice || value.Type() == reflect.TypeOf(bson.D{}) || value.Type() == reflect.TypeOf(bson.Raw{}) {
+		return nil, false, nil
+	}
+	stages = make([]bson.D, value.Len())
+	for i := range stages {
+		if stages[i], err = toDocument(value.Index(i).Interface()); err != nil {
+			return nil, true, err
+		}
+	}
+	return stages, true, nil
+}
+
+// runStages runs update pipeline stages on one document
+func runStages(doc bson.D, stages []bson.D) (bson.D, error) {
+	for _, stage := range stages {
+		if len(stage) != 1 {
+			return nil, fmt.Errorf("a pipeline stage must have exactly one field")
+		}
+		switch stage[0].Key {
+		case "$set", "$addFields", "$unset", "$project", "$replaceRoot", "$replaceWith":
+		default:
+			return nil, fmt.Errorf("%s is not allowed in an update pipeline", stage[0].Key)
+		}
+	}
+	out, err := aggregate([]bson.D{doc}, stages)
+	if err != nil {
+		return nil, err
+	}
+	return out[0], nil
+}
+
+// aggregate runs pipeline stages over documents
+func aggregate(docs []bson.D, stages []bson.D) ([]bson.D, error) {
+	for _, stage := range stages {
+		if len(stage) != 1 {
+			return nil, fmt.Errorf("a pipeline stage must have exactly one field")
+		}
+		op, spec := stage[0].Key, stage[0].Value
+		var err error
+		switch op {
+		case "$match":
+			filter, ok := spec.(bson.D)
+			if !ok {
+				return nil, fmt.Errorf("$match takes a document")
+			}
+			var match matcher
+			if match, err = compileFilter(filter); err != nil {
+				return nil, err
+			}
+			kept := docs[:0:0]
+			for _, doc := range docs {
+				if match(doc) {
+					kept = append(kept, doc)
+				}
+			}
+			docs = kept
+		case "$sort":
+			order, ok := spec.(bson.D)
+			if !ok {
+				return nil, fmt.Errorf("$sort takes a document")
+			}
+			err = sortDocuments(docs, order)
+		case "$limit", "$skip":
+			n, ok := toInt64(spec)
+			if !ok || n < 0 {
+				return nil, fmt.Errorf("%s takes a non-negative number", op)
+			}
+			if n > int64(len(docs)) {
+				n = int64(len(docs))
+			}
+			if op == "$limit" {
+				docs = docs[:n]
+			} else {
+				docs = docs[n:]
+			}
+		case "$group":
+			docs, err = groupDocuments(docs, spec)
+		case "$project":
+			docs, err = mapDocuments(docs, func(doc bson.D) (bson.D, error) { return projectStage(doc, spec) })
+		case "$set", "$addFields":
+			docs, err = mapDocuments(docs, func(doc bson.D) (bson.D, error) { return setStage(doc, spec) })
+		case "$unset":
+			fields := bson.A{spec}
+			if list, ok := spec.(bson.A); ok {
+				fields = list
+			}
+			docs, err = mapDocuments(docs, func(doc bson.D) (bson.D, error) {
+				out := cloneDocument(doc)
+				for _, field := range fields {
+					name, ok := field.(string)
+					if !ok {
+						return nil, fmt.Errorf("$unset takes field names")
+					}
+					out = unsetPath(out, splitPath(name))
+				}
+				return out, nil
+			})
+		case "$replaceRoot", "$replaceWith":
+			expr := spec
+			if op == "$replaceRoot" {
+				var ok bool
+				if expr, ok = lookupPath(asDocument(spec), []string{"newRoot"}); !ok {
+					return nil, fmt.Errorf("$replaceRoot takes newRoot")
+				}
+			}
+			docs, err = mapDocuments(docs, func(doc bson.D) (bson.D, error) {
+				root, err := evalExpr(expr, doc)
+				if err != nil {
+					return nil, err
+				}
+				out, ok := root.(bson.D)
+				if !ok {
+					return nil, fmt.Errorf("%s must evaluate to a document", op)
+				}
+				return out, nil
+			})
+		case "$unwind":
+			docs, err = unwindDocuments(docs, spec)
+		case "$count":
+			name, ok := spec.(string)
+			if !ok || name == "" {
+				return nil, fmt.Errorf("$count takes a field name")
+			}
+			if len(docs) > 0 {
+				docs = []bson.D{{{Key: name, Value: int32(len(docs))}}}
+			}
+		default:
+			return nil, fmt.Errorf("unsupported pipeline stage %s", op)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return docs, nil
+}
+
+func asDocument(v interface{}) bson.D {
+	doc, _ := v.(bson.D)
+	return doc
+}
+
+func mapDocuments(docs []bson.D, fn func(bson.D) (bson.D, error)) ([]bson.D, error) {
+	out := make([]bson.D, len(docs))
+	for i, doc := range docs {
+		var err error
+		if out[i], err = fn(doc); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// setStage adds or replaces fields with the values of expressions, all
+// evaluated against the incoming document
+func setStage(doc bson.D, spec interface{}) (bson.D, error) {
+	fields, ok := spec.(bson.D)
+	if !ok {
+		return nil, fmt.Errorf("$set takes a document")
+	}
+	values := make([]interface{}, len(fields))
+	for i, field := range fields {
+		var err error
+		if values[i], err = evalExpr(field.Value, doc); err != nil {
+			return nil, err
+		}
+	}
+	out := cloneDocument(doc)
+	for i, field := range fields {
+		path := splitPath(field.Key)
+		if values[i] == missing {
+			out = unsetPath(out, path)
+			continue
+		}
+		var err error
+		if out, err = setPath(out, path, values[i]); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// projectStage reshapes a document by included, excluded and computed
+// fields
+func projectStage(doc bson.D, spec interface{}) (bson.D, error) {
+	fields, ok := spec.(bson.D)
+	if !ok {
+		return nil, fmt.Errorf("$project takes a document")
+	}
+	var plain, computed bson.D
+	for _, field := range fields {
+		switch field.Value.(type) {
+		case bool, int32, int64, float64:
+			plain = append(plain, field)
+		default:
+			computed = append(computed, field)
+		}
+	}
+	if len(computed) == 0 {
+		return projectDocument(doc, plain)
+	}
+
+	keepID := true
+	var paths [][]string
+	for _, field := range plain {
+		switch {
+		case field.Key == "_id":
+			keepID = truthy(field.Value)
+		case truthy(field.Value):
+			paths = append(paths, splitPath(field.Key))
+		default:
+			return nil, fmt.Errorf("$project can't exclude %s next to computed fields", field.Key)
+		}
+	}
+	if keepID {
+		paths = append(paths, []string{"_id"})
+	}
+	out := includePaths(doc, paths)
+	for _, field := range computed {
+		value, err := evalExpr(field.Value, doc)
+		if err != nil {
+			return nil, err
+		}
+		if value == missing {
+			continue
+		}
+		if out, err = setPath(out, splitPath(field.Key), value); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func unwindDocuments(docs []bson.D, spec interface{}) ([]bson.D, error) {
+	path, _ := spec.(string)
+	preserve := false
+	if options, ok := spec.(bson.D); ok {
+		value, _ := lookupPath(options, []string{"path"})
+		path, _ = value.(string)
+		flag, _ := lookupPath(options, []string{"preserveNullAndEmptyArrays"})
+		preserve = truthy(flag)
+	}
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("$unwind takes a field path")
+	}
+	fieldPath := splitPath(path[1:])
+
+	var out []bson.D
+	for _, doc := range docs {
+		value, found := lookupPath(doc, fieldPath)
+		list, isList := value.(bson.A)
+		switch {
+		case isList && len(list) > 0:
+			for _, item := range list {
+				unwound, err := setPath(cloneDocument(doc), fieldPath, cloneValue(item))
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, unwound)
+			}
+		case found && !isList && value != nil:
+			out = append(out, doc)
+		case preserve && isList:
+			out = append(out, unsetPath(cloneDocument(doc), fieldPath))
+		case preserve:
+			out = append(out, doc)
+		}
+	}
+	return out, nil
+}
+
+// groupDocuments runs a $group stage. Groups come out in the order their
+// first document came in.
+func groupDocuments(docs []bson.D, spec interface{}) ([]bson.D, error) {
+	fields, ok := spec.(bson.D)
+	if !ok {
+		return nil, fmt.Errorf("$group takes a document")
+	}
+	idExpr, ok := lookupPath(fields, []string{"_id"})
+	if !ok {
+		return nil, fmt.Errorf("$group needs an _id")
+	}
+	type accumulator struct {
+		field string
+		op    string
+		expr  interface{}
+	}
+	var accumulators []accumulator
+	for _, field := range fields {
+		if field.Key == "_id" {
+			continue
+		}
+		acc, ok := field.Value.(bson.D)
+		if !ok || len(acc) != 1 {
+			return nil, fmt.Errorf("$group field %s must be an accumulator", field.Key)
+		}
+		accumulators = append(accumulators, accumulator{field.Key, acc[0].Key, acc[0].Value})
+	}
+
+	type group struct {
+		id     interface{}
+		values []interface{}
+		counts []int
+	}
+	var groups []*group
+	byKey := map[string]*group{}
+	for _, doc := range docs {
+		id, err := evalExpr(idExpr, doc)
+		if err != nil {
+			return nil, err
+		}
+		id = orNull(id)
+		keyBytes, err := bson.Marshal(bson.D{{Key: "k", Value: id}})
+		if err != nil {
+			return nil, err
+		}
+		g := byKey[string(keyBytes)]
+		if g == nil {
+			g = &group{id: id, values: make([]interface{}, len(accumulators)), counts: make([]int, len(accumulators))}
+			for i := range g.values {
+				g.values[i] = missing
+			}
+			byKey[string(keyBytes)] = g
+			groups = append(groups, g)
+		}
+
+		for i, acc := range accumulators {
+			value, err := evalExpr(acc.expr, doc)
+			if err != nil {
+				return nil, err
+			}
+			current := g.values[i]
+			switch acc.op {
+			case "$sum", "$avg":
+				if typeRank(orNull(value)) != rankNumber {
+					continue
+				}
+				if current == missing {
+					current = int32(0)
+				}
+				g.values[i] = addNumbers(current, value)
+				g.counts[i]++
+			case "$count":
+				if current == missing {
+					current = int32(0)
+				}
+				g.values[i] = addNumbers(current, int32(1))
+			case "$min", "$max":
+				value = orNull(value)
+				if value == nil {
+					continue
+				}
+				c := compareValues(value, orNull(current))
+				if current == missing || (acc.op == "$min" && c < 0) || (acc.op == "$max" && c > 0) {
+					g.values[i] = value
+				}
+			case "$first":
+				if g.counts[i] == 0 {
+					g.values[i] = orNull(value)
+				}
+				g.counts[i]++
+			case "$last":
+				g.values[i] = orNull(value)
+			case "$push", "$addToSet":
+				list, _ := current.(bson.A)
+				if list == nil {
+					list = bson.A{}
+				}
+				if value != missing && (acc.op == "$push" || !containsValue(list, value)) {
+					list = append(list, value)
+				}
+				g.values[i] = list
+			default:
+				return nil, fmt.Errorf("unsupported accumulator %s", acc.op)
+			}
+		}
+	}
+
+	out := make([]bson.D, len(groups))
+	for n, g := range groups {
+		doc := bson.D{{Key: "_id", Value: g.id}}
+		for i, acc := range accumulators {
+			value := g.values[i]
+			switch {
+			case acc.op == "$avg" && g.counts[i] > 0:
+				sum, _ := toFloat64(value)
+				value = sum / float64(g.counts[i])
+			case acc.op == "$avg":
+				value = nil
+			case value == missing && (acc.op == "$sum" || acc.op == "$count"):
+				value = int32(0)
+			case value == missing && (acc.op == "$push" || acc.op == "$addToSet"):
+				value = bson.A{}
+			}
+			doc = append(doc, bson.E{Key: acc.field, Value: orNull(value)})
+		}
+		out[n] = doc
+	}
+	return out, nil
+}
+
+// evalExpr evaluates an aggregation expression against a document
+func evalExpr(expr interface{}, root bson.D) (interface{}, error) {
+	switch e := expr.(type) {
+	case string:
+		switch {
+		case e == "$$ROOT" || e == "$$CURRENT":
+			return root, nil
+		case e == "$$NOW":
+			return primitive.NewDateTimeFromTime(time.Now()), nil
+		case strings.HasPrefix(e, "$$"):
+			return nil, fmt.Errorf("unsupported variable %s", e)
+		case strings.HasPrefix(e, "$"):
+			value, found := exprLookup(root, splitPath(e[1:]))
+			if !found {
+				return missing, nil
+			}
+			return value, nil
+		}
+		return e, nil
+	case bson.A:
+		out := make(bson.A, len(e))
+		for i, item := range e {
+			value, err := evalExpr(item, root)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = orNull(value)
+		}
+		return out, nil
+	case bson.D:
+		if len(e) == 1 && strings.HasPrefix(e[0].Key, "$") {
+			return evalOperator(e[0].Key, e[0].Value, root)
+		}
+		out := bson.D{}
+		for _, field := range e {
+			if strings.HasPrefix(field.Key, "$") {
+				return nil, fmt.Errorf("unsupported expression %s", field.Key)
+			}
+			value, err := evalExpr(field.Value, root)
+			if err != nil {
+				return nil, err
+			}
+			if value != missing {
+				out = append(out, bson.E{Key: field.Key, Value: value})
+			}
+		}
+		return out, nil
+	}
+	return expr, nil
+}
+
+// exprLookup finds a field path for an expression; through an array of
+// documents it yields the array of their values
+func exprLookup(v interface{}, path []string) (interface{}, bool) {
+	if len(path) == 0 {
+		return v, true
+	}
+	switch c := v.(type) {
+	case bson.D:
+		for _, element := range c {
+			if element.Key == path[0] {
+				return exprLookup(element.Value, path[1:])
+			}
+		}
+	case bson.A:
+		out := bson.A{}
+		for _, item := range c {
+			if value, ok := exprLookup(item, path); ok {
+				out = append(out, value)
+			}
+		}
+		return out, true
+	}
+	return nil, false
+}
+
+// evalArgs evaluates an operator's arguments, given as an array or as one
+// expression
+func evalArgs(args interface{}, root bson.D) ([]interface{}, error) {
+	list, ok := args.(bson.A)
+	if !ok {
+		list = bson.A{args}
+	}
+	values := make([]interface{}, len(list))
+	for i, arg := range list {
+		var err error
+		if values[i], err = evalExpr(arg, root); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func evalOperator(op string, args interface{}, root bson.D) (interface{}, error) {
+	if op == "$literal" {
+		return args, nil
+	}
+	if op == "$cond" {
+		return evalCond(args, root)
+	}
+	values, err := evalArgs(args, root)
+	if err != nil {
+		return nil, err
+	}
+	nArgs := func(n int) error {
+		if len(values) != n {
+			return fmt.Errorf("%s takes %d arguments", op, n)
+		}
+		return nil
+	}
+
+	switch op {
+	case "$add", "$multiply":
+		var result interface{} = int32(0)
+		if op == "$multiply" {
+			result = int32(1)
+		}
+		isDate := false
+		for _, value := range values {
+			value = orNull(value)
+			if value == nil {
+				return nil, nil
+			}
+			if date, ok := value.(primitive.DateTime); ok && op == "$add" && !isDate {
+				isDate = true
+				value = int64(date)
+			}
+			if typeRank(value) != rankNumber {
+				return nil, fmt.Errorf("%s only supports numbers and dates", op)
+			}
+			if op == "$add" {
+				result = addNumbers(result, value)
+			} else {
+				result = multiplyNumbers(result, value)
+			}
+		}
+		if isDate {
+			ms, _ := toFloat64(result)
+			return primitive.DateTime(int64(ms)), nil
+		}
+		return result, nil
+	case "$subtract":
+		if err := nArgs(2); err != nil {
+			return nil, err
+		}
+		a, b := orNull(values[0]), orNull(values[1])
+		if a == nil || b == nil {
+			return nil, nil
+		}
+		dateA, aDate := a.(primitive.DateTime)
+		dateB, bDate := b.(primitive.DateTime)
+		switch {
+		case aDate && bDate:
+			return int64(dateA) - int64(dateB), nil
+		case aDate && typeRank(b) == rankNumber:
+			ms, _ := toFloat64(b)
+			return primitive.DateTime(int64(dateA) - int64(ms)), nil
+		case typeRank(a) == rankNumber && typeRank(b) == rankNumber:
+			return addNumbers(a, negate(b)), nil
+		}
+		return nil, fmt.Errorf("$subtract only supports numbers and dates")
+	case "$divide":
+		if err := nArgs(2); err != nil {
+			return nil, err
+		}
+		a, aOK := toFloat64(orNull(values[0]))
+		b, bOK := toFloat64(orNull(values[1]))
+		if !aOK || !bOK {
+			return nil, nil
+		}
+		if b == 0 {
+			return nil, fmt.Errorf("$divide by zero")
+		}
+		return a / b, nil
+	case "$ifNull":
+		for _, value := range values {
+			if orNull(value) != nil {
+				return value, nil
+			}
+		}
+		return nil, nil
+	case "$min", "$max", "$sum", "$avg":
+		if len(values) == 1 {
+			if list, ok := values[0].(bson.A); ok {
+				values = list
+			}
+		}
+		var result interface{}
+		count := 0
+		for _, value := range values {
+			value = orNull(value)
+			if value == nil {
+				continue
+			}
+			switch op {
+			case "$sum", "$avg":
+				if typeRank(value) != rankNumber {
+					continue
+				}
+				if result == nil {
+					result = int32(0)
+				}
+				result = addNumbers(result, value)
+			default:
+				c := compareValues(value, result)
+				if result == nil || (op == "$min" && c < 0) || (op == "$max" && c > 0) {
+					result = value
+				}
+			}
+			count++
+		}
+		switch {
+		case op == "$sum" && result == nil:
+			return int32(0), nil
+		case op == "$avg" && count > 0:
+			sum, _ := toFloat64(result)
+			return sum / float64(count), nil
+		}
+		return result, nil
+	case "$slice":
+		if len(values) != 2 && len(values) != 3 {
+			return nil, fmt.Errorf("$slice takes 2 or 3 arguments")
+		}
+		list, ok := orNull(values[0]).(bson.A)
+		if !ok {
+			return nil, nil
+		}
+		start, n := int64(0), int64(0)
+		if len(values) == 2 {
+			if n, ok = toInt64(values[1]); !ok {
+				return nil, fmt.Errorf("$slice takes a number")
+			}
+			if n < 0 {
+				start, n = int64(len(list))+n, -n
+			}
+		} else {
+			if start, ok = toInt64(values[1]); !ok {
+				return nil, fmt.Errorf("$slice takes a number")
+			}
+			if n, ok = toInt64(values[2]); !ok || n < 0 {
+				return nil, fmt.Errorf("$slice takes a positive count")
+			}
+			if start < 0 {
+				start += int64(len(list))
+			}
+		}
+		start = max(0, min(start, int64(len(list))))
+		end := min(start+n, int64(len(list)))
+		return list[start:end], nil
+	case "$size":
+		if err := nArgs(1); err != nil {
+			return nil, err
+		}
+		list, ok := values[0].(bson.A)
+		if !ok {
+			return nil, fmt.Errorf("$size takes an array")
+		}
+		return int32(len(list)), nil
+	case "$concat":
+		var b strings.Builder
+		for _, value := range values {
+			s, ok := orNull(value).(string)
+			if !ok {
+				return nil, nil
+			}
+			b.WriteString(s)
+		}
+		return b.String(), nil
+	case "$eq", "$ne", "$gt", "$gte", "$lt", "$lte":
+		if err := nArgs(2); err != nil {
+			return nil, err
+		}
+		c := compareValues(orNull(values[0]), orNull(values[1]))
+		switch op {
+		case "$eq":
+			return c == 0, nil
+		case "$ne":
+			return c != 0, nil
+		case "$gt":
+			return c > 0, nil
+		case "$gte":
+			return c >= 0, nil
+		case "$lt":
+			return c < 0, nil
+		}
+		return c <= 0, nil
+	}
+	return nil, fmt.Errorf("unsupported expression operator %s", op)
+}
+
+func evalCond(args interface{}, root bson.D) (interface{}, error) {
+	var ifExpr, thenExpr, elseExpr interface{}
+	switch a := args.(type) {
+	case bson.A:
+		if len(a) != 3 {
+			return nil, fmt.Errorf("$cond takes 3 arguments")
+		}
+		ifExpr, thenExpr, elseExpr = a[0], a[1], a[2]
+	case bson.D:
+		ifExpr, _ = lookupPath(a, []string{"if"})
+		thenExpr, _ = lookupPath(a, []string{"then"})
+		elseExpr, _ = lookupPath(a, []string{"else"})
+	default:
+		return nil, fmt.Errorf("$cond takes an array or a document")
+	}
+	condition, err := evalExpr(ifExpr, root)
+	if err != nil {
+		return nil, err
+	}
+	if truthy(orNull(condition)) {
+		return evalExpr(thenExpr, root)
+	}
+	return evalExpr(elseExpr, root)
+}
+
+// negate keeps the number's type where it can, so that subtracting two
+// ints gives an int as in MongoDB
+func negate(v interface{}) interface{} {
+	switch n := v.(type) {
+	case int32:
+		if n == math.MinInt32 {
+			return -int64(n)
+		}
+		return -n
+	case int64:
+		return -n
+	}
+	f, _ := toFloat64(v)
+	return -f
+}
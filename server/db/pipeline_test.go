@@ -0,0 +1,226 @@
+package db
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// documents parses a list of documents written as extended JSON
+func documents(t *testing.T, s string) []bson.D {
+	t.Helper()
+	list := extJSON(t, `{"d": `+s+`}`)[0].Value.(bson.A)
+	docs := make([]bson.D, len(list))
+	for i, item := range list {
+		docs[i] = item.(bson.D)
+	}
+	return docs
+}
+
+func TestAggregate(t *testing.T) {
+	const people = `[
+		{"_id": 1, "name": "ann", "team": "a", "age": 30, "tags": ["x", "y"]},
+		{"_id": 2, "name": "bob", "team": "b", "age": 25, "tags": []},
+		{"_id": 3, "name": "cat", "team": "a", "age": 35.5},
+		{"_id": 4, "name": "dan", "age": null, "tags": ["y"]}
+	]`
+	tests := []struct {
+		name     string
+		docs     string
+		pipeline string
+		want     string
+	}{
+		{"empty pipeline", `[{"_id": 1}]`, `[]`, `[{"_id": 1}]`},
+		{"$match", people, `[{"$match": {"team": "a"}}]`, `[{"_id": 1, "name": "ann", "team": "a", "age": 30, "tags": ["x", "y"]}, {"_id": 3, "name": "cat", "team": "a", "age": 35.5}]`},
+		{"$sort", people, `[{"$sort": {"age": -1}}, {"$project": {"_id": 1}}]`, `[{"_id": 3}, {"_id": 1}, {"_id": 2}, {"_id": 4}]`},
+		{"$skip and $limit", people, `[{"$sort": {"_id": 1}}, {"$skip": 1}, {"$limit": 2}, {"$project": {"_id": 1}}]`, `[{"_id": 2}, {"_id": 3}]`},
+		{"$skip past the end", people, `[{"$skip": 10}]`, `[]`},
+		{"$limit past the end", `[{"_id": 1}]`, `[{"$limit": 10}]`, `[{"_id": 1}]`},
+
+		{"$group accumulators", people, `[{"$group": {
+			"_id": "$team",
+			"n": {"$sum": 1},
+			"total": {"$sum": "$age"},
+			"avg": {"$avg": "$age"},
+			"min": {"$min": "$age"},
+			"max": {"$max": "$age"},
+			"first": {"$first": "$name"},
+			"last": {"$last": "$name"},
+			"names": {"$push": "$name"},
+			"count": {"$count": {}}
+		}}]`, `[
+			{"_id": "a", "n": 2, "total": 65.5, "avg": 32.75, "min": 30, "max": 35.5, "first": "ann", "last": "cat", "names": ["ann", "cat"], "count": 2},
+			{"_id": "b", "n": 1, "total": 25, "avg": 25.0, "min": 25, "max": 25, "first": "bob", "last": "bob", "names": ["bob"], "count": 1},
+			{"_id": null, "n": 1, "total": 0, "avg": null, "min": null, "max": null, "first": "dan", "last": "dan", "names": ["dan"], "count": 1}
+		]`},
+		{"$group by null", people, `[{"$group": {"_id": null, "total": {"$sum": "$age"}}}]`, `[{"_id": null, "total": 90.5}]`},
+		{"$group $sum of ints", `[{"n": 1}, {"n": 2}]`, `[{"$group": {"_id": null, "total": {"$sum": "$n"}}}]`, `[{"_id": null, "total": 3}]`},
+		{"$group $avg of ints", `[{"n": 1}, {"n": 2}]`, `[{"$group": {"_id": null, "avg": {"$avg": "$n"}}}]`, `[{"_id": null, "avg": 1.5}]`},
+		{"$group $first of missing", `[{"_id": 1}, {"_id": 2, "n": 1}]`, `[{"$group": {"_id": null, "first": {"$first": "$n"}}}]`, `[{"_id": null, "first": null}]`},
+		{"$group $push skips missing", `[{"_id": 1}, {"_id": 2, "n": 1}]`, `[{"$group": {"_id": null, "ns": {"$push": "$n"}}}]`, `[{"_id": null, "ns": [1]}]`},
+		{"$group $addToSet", `[{"n": 1}, {"n": 2}, {"n": 1}]`, `[{"$group": {"_id": null, "ns": {"$addToSet": "$n"}}}]`, `[{"_id": null, "ns": [1, 2]}]`},
+		{"$group by document", `[{"a": 1, "b": 1}, {"a": 1, "b": 1}, {"a": 1, "b": 2}]`, `[{"$group": {"_id": {"a": "$a", "b": "$b"}, "n": {"$sum": 1}}}]`, `[{"_id": {"a": 1, "b": 1}, "n": 2}, {"_id": {"a": 1, "b": 2}, "n": 1}]`},
+		{"$group of nothing", `[]`, `[{"$group": {"_id": null, "n": {"$sum": 1}}}]`, `[]`},
+
+		{"$project inclusion", people, `[{"$match": {"_id": 1}}, {"$project": {"name": 1}}]`, `[{"_id": 1, "name": "ann"}]`},
+		{"$project exclusion", people, `[{"$match": {"_id": 3}}, {"$project": {"name": 0, "team": 0}}]`, `[{"_id": 3, "age": 35.5}]`},
+		{"$project computed", people, `[{"$match": {"_id": 1}}, {"$project": {"_id": 0, "name": 1, "who": "$name", "n": {"$size": "$tags"}}}]`, `[{"name": "ann", "who": "ann", "n": 2}]`},
+		{"$project computed missing", people, `[{"$match": {"_id": 3}}, {"$project": {"who": "$nobody"}}]`, `[{"_id": 3}]`},
+		{"$project literal", `[{"_id": 1}]`, `[{"$project": {"one": {"$literal": 1}, "path": {"$literal": "$name"}}}]`, `[{"_id": 1, "one": 1, "path": "$name"}]`},
+
+		{"$set", `[{"_id": 1, "a": 1}]`, `[{"$set": {"b": {"$add": ["$a", 1]}, "c.d": "x"}}]`, `[{"_id": 1, "a": 1, "b": 2, "c": {"d": "x"}}]`},
+		{"$addFields", `[{"_id": 1, "a": 1}]`, `[{"$addFields": {"a": 2}}]`, `[{"_id": 1, "a": 2}]`},
+		{"$set to missing removes", `[{"_id": 1, "a": 1}]`, `[{"$set": {"a": "$nobody"}}]`, `[{"_id": 1}]`},
+		{"$set from the incoming document", `[{"_id": 1, "a": 1}]`, `[{"$set": {"a": 2, "b": "$a"}}]`, `[{"_id": 1, "a": 2, "b": 1}]`},
+		{"$unset", `[{"_id": 1, "a": 1, "b": {"c": 1, "d": 2}}]`, `[{"$unset": ["a", "b.c"]}]`, `[{"_id": 1, "b": {"d": 2}}]`},
+		{"$unset one field", `[{"_id": 1, "a": 1}]`, `[{"$unset": "a"}]`, `[{"_id": 1}]`},
+		{"$replaceRoot", `[{"_id": 1, "a": {"b": 1}}]`, `[{"$replaceRoot": {"newRoot": "$a"}}]`, `[{"b": 1}]`},
+		{"$replaceWith", `[{"_id": 1, "a": 2}]`, `[{"$replaceWith": {"x": "$a"}}]`, `[{"x": 2}]`},
+
+		{"$unwind", people, `[{"$unwind": "$tags"}, {"$project": {"tags": 1}}]`, `[{"_id": 1, "tags": "x"}, {"_id": 1, "tags": "y"}, {"_id": 4, "tags": "y"}]`},
+		{"$unwind preserving", people, `[{"$unwind": {"path": "$tags", "preserveNullAndEmptyArrays": true}}, {"$project": {"tags": 1}}]`,
+			`[{"_id": 1, "tags": "x"}, {"_id": 1, "tags": "y"}, {"_id": 2}, {"_id": 3}, {"_id": 4, "tags": "y"}]`},
+		{"$unwind a scalar", `[{"_id": 1, "a": 5}]`, `[{"$unwind": "$a"}]`, `[{"_id": 1, "a": 5}]`},
+		{"$unwind null", `[{"_id": 1, "a": null}]`, `[{"$unwind": "$a"}]`, `[]`},
+
+		{"$count", people, `[{"$match": {"team": "a"}}, {"$count": "n"}]`, `[{"n": 2}]`},
+		{"$count of nothing", people, `[{"$match": {"team": "z"}}, {"$count": "n"}]`, `[]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stages := documents(t, tt.pipeline)
+			got, err := aggregate(documents(t, tt.docs), stages)
+			if err != nil {
+				t.Fatalf("aggregate(%s): %v", tt.pipeline, err)
+			}
+			gotJSON := canonical(t, bson.D{{Key: "d", Value: append([]bson.D{}, got...)}})
+			if gotJSON != canonical(t, extJSON(t, `{"d": `+tt.want+`}`)) {
+				t.Errorf("aggregate(%s) = %s, want %s", tt.pipeline, gotJSON, tt.want)
+			}
+		})
+	}
+}
+
+func TestAggregateErrors(t *testing.T) {
+	for _, pipeline := range []string{
+		`[{"$lookup": {"from": "users"}}]`,
+		`[{"$match": {}, "$sort": {"a": 1}}]`,
+		`[{"$match": 1}]`,
+		`[{"$limit": -1}]`,
+		`[{"$group": {"n": {"$sum": 1}}}]`,
+		`[{"$group": {"_id": null, "n": {"$stdDevPop": "$a"}}}]`,
+		`[{"$project": {"a": 0, "b": "$a"}}]`,
+		`[{"$unwind": "a"}]`,
+		`[{"$count": ""}]`,
+		`[{"$replaceRoot": {"newRoot": "$a"}}]`,
+		`[{"$set": {"a": {"$toUpper": "$a"}}}]`,
+	} {
+		if _, err := aggregate(documents(t, `[{"_id": 1, "a": 1}]`), documents(t, pipeline)); err == nil {
+			t.Errorf("aggregate(%s) succeeded, want an error", pipeline)
+		}
+	}
+}
+
+func TestEvalExpr(t *testing.T) {
+	root := extJSON(t, `{
+		"i": 5, "j": 3, "l": {"$numberLong": "7"}, "f": 1.5, "s": "ab", "n": null, "list": [1, 2, 3, 4],
+		"at": {"$date": "2026-01-01T00:00:00Z"}, "later": {"$date": "2026-01-01T00:00:10Z"},
+		"items": [{"v": 1}, {"v": 2}]
+	}`)
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{`"$i"`, `5`},
+		{`"$items.v"`, `[1, 2]`},
+		{`"plain"`, `"plain"`},
+		{`{"$literal": {"$add": [1, 2]}}`, `{"$add": [1, 2]}`},
+
+		{`{"$add": ["$i", "$j"]}`, `8`},
+		{`{"$add": ["$i", "$l"]}`, `{"$numberLong": "12"}`},
+		{`{"$add": ["$i", "$f"]}`, `6.5`},
+		{`{"$add": ["$i", "$n"]}`, `null`},
+		{`{"$add": ["$i", "$nobody"]}`, `null`},
+		{`{"$add": ["$at", 1000]}`, `{"$date": "2026-01-01T00:00:01Z"}`},
+		{`{"$add": [2147483647, 1]}`, `{"$numberLong": "2147483648"}`},
+		{`{"$subtract": ["$i", "$j"]}`, `2`},
+		{`{"$subtract": ["$j", "$i"]}`, `-2`},
+		{`{"$subtract": ["$l", "$i"]}`, `{"$numberLong": "2"}`},
+		{`{"$subtract": ["$i", "$f"]}`, `3.5`},
+		{`{"$subtract": ["$later", "$at"]}`, `{"$numberLong": "10000"}`},
+		{`{"$subtract": ["$later", 10000]}`, `{"$date": "2026-01-01T00:00:00Z"}`},
+		{`{"$subtract": ["$i", "$n"]}`, `null`},
+		{`{"$multiply": ["$i", "$j"]}`, `15`},
+		{`{"$multiply": ["$i", "$f"]}`, `7.5`},
+		{`{"$divide": ["$i", 2]}`, `2.5`},
+		{`{"$divide": [6, 3]}`, `2.0`},
+		{`{"$divide": ["$n", 3]}`, `null`},
+
+		{`{"$ifNull": ["$n", "$nobody", "x"]}`, `"x"`},
+		{`{"$ifNull": ["$i", "x"]}`, `5`},
+		{`{"$cond": [{"$gt": ["$i", 3]}, "big", "small"]}`, `"big"`},
+		{`{"$cond": {"if": {"$lt": ["$i", 3]}, "then": "small", "else": "big"}}`, `"big"`},
+		{`{"$cond": ["$n", 1, 2]}`, `2`},
+		{`{"$cond": [0, 1, 2]}`, `2`},
+		{`{"$cond": ["", 1, 2]}`, `1`},
+
+		{`{"$size": "$list"}`, `4`},
+		{`{"$slice": ["$list", 2]}`, `[1, 2]`},
+		{`{"$slice": ["$list", -2]}`, `[3, 4]`},
+		{`{"$slice": ["$list", 1, 2]}`, `[2, 3]`},
+		{`{"$slice": ["$list", -3, 2]}`, `[2, 3]`},
+		{`{"$slice": ["$list", 10]}`, `[1, 2, 3, 4]`},
+		{`{"$slice": ["$n", 1]}`, `null`},
+		{`{"$concat": ["$s", "-", "$s"]}`, `"ab-ab"`},
+		{`{"$concat": ["$s", "$n"]}`, `null`},
+		{`{"$concat": ["$s", "$nobody"]}`, `null`},
+
+		{`{"$sum": "$list"}`, `10`},
+		{`{"$sum": ["$i", "$s", "$f"]}`, `6.5`},
+		{`{"$sum": "$nobody"}`, `0`},
+		{`{"$avg": "$list"}`, `2.5`},
+		{`{"$avg": []}`, `null`},
+		{`{"$min": "$list"}`, `1`},
+		{`{"$max": ["$i", "$j", "$n"]}`, `5`},
+
+		{`{"$eq": ["$i", 5.0]}`, `true`},
+		{`{"$ne": ["$i", 5]}`, `false`},
+		{`{"$gt": ["$s", "$i"]}`, `true`},
+		{`{"$gte": ["$i", 5]}`, `true`},
+		{`{"$lt": ["$n", 0]}`, `true`},
+		{`{"$lte": ["$j", 2]}`, `false`},
+		{`{"a": "$i", "b": "$nobody"}`, `{"a": 5}`},
+	}
+	for _, tt := range tests {
+		expr := extJSON(t, `{"e": `+tt.expr+`}`)[0].Value
+		got, err := evalExpr(expr, root)
+		if err != nil {
+			t.Errorf("evalExpr(%s): %v", tt.expr, err)
+			continue
+		}
+		if canonical(t, bson.D{{Key: "v", Value: got}}) != canonical(t, extJSON(t, `{"v": `+tt.want+`}`)) {
+			t.Errorf("evalExpr(%s) = %s, want %s", tt.expr, canonical(t, bson.D{{Key: "v", Value: got}}), tt.want)
+		}
+	}
+}
+
+func TestEvalExprErrors(t *testing.T) {
+	root := extJSON(t, `{"i": 5, "s": "ab"}`)
+	for _, expr := range []string{
+		`{"$add": ["$i", "$s"]}`,
+		`{"$subtract": ["$s", 1]}`,
+		`{"$subtract": [1]}`,
+		`{"$divide": ["$i", 0]}`,
+		`{"$size": "$s"}`,
+		`{"$size": "$nobody"}`,
+		`{"$cond": [true, 1]}`,
+		`{"$slice": ["$i"]}`,
+		`{"$trim": {"input": "$s"}}`,
+		`"$$CLUSTER_TIME"`,
+		`{"a": 1, "$b": 2}`,
+	} {
+		if _, err := evalExpr(extJSON(t, `{"e": `+expr+`}`)[0].Value, root); err == nil {
+			t.Errorf("evalExpr(%s) succeeded, want an error", expr)
+		}
+	}
+}
@@ -0,0 +1,508 @@
+package db
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"video-meeting-app/config"
+)
+
+// With the postgres driver each collection is a table of BSON documents.
+// fields holds each document as canonical extended JSON so queries can
+// narrow the rows they load through a GIN index; the documentStore matches
+// what they load exactly.
+
+const (
+	// TTLSweepInterval is how often expired documents are deleted, as
+	// MongoDB's TTL monitor does every minute
+	TTLSweepInterval = time.Minute
+	// transactionRetries bounds how often InTransaction retries fn after a
+	// serialization failure
+	transactionRetries = 5
+)
+
+// PostgreSQL error codes
+const (
+	pgUniqueViolation      = "23505"
+	pgSerializationFailure = "40001"
+	pgDeadlockDetected     = "40P01"
+)
+
+var pgPool *pgxpool.Pool
+
+// pgTxKey carries the pgx.Tx of InTransaction in a context
+type pgTxKey struct{}
+
+// pgQuerier runs statements on a pool or in a transaction
+type pgQuerier interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// ConnectPostgres connects to PostgreSQL and opens every collection as a
+// table, creating the tables and indexes that don't exist
+func ConnectPostgres(cfg config.PostgresConfig) error {
+	poolConfig, err := pgxpool.ParseConfig(cfg.URL)
+	if err != nil {
+		return err
+	}
+	poolConfig.MaxConns = int32(cfg.MaxConns)
+	poolConfig.MinConns = int32(cfg.MinConns)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ConnectTimeout))
+	defer cancel()
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return err
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return err
+	}
+
+	var tables []*pgTable
+	openCollections(func(name string) Store {
+		table := &pgTable{pool: pool, name: name, queryTimeout: time.Duration(cfg.QueryTimeout)}
+		tables = append(tables, table)
+		return &documentStore{backend: table}
+	})
+	for _, table := range tables {
+		if err := table.create(ctx); err != nil {
+			pool.Close()
+			return fmt.Errorf("creating table %s: %w", table.name, err)
+		}
+	}
+
+	pgPool = pool
+	pingDB = pool.Ping
+	sweepCtx, stopSweeping := context.WithCancel(context.Background())
+	closeDB = func(context.Context) error {
+		stopSweeping()
+		pool.Close()
+		return nil
+	}
+	inTransaction = pgTransaction
+
+	if err := createIndexes(context.Background()); err != nil {
+		log.Printf("Warning: Failed to create indexes: %v", err)
+	}
+	go sweepExpired(sweepCtx, tables)
+	return nil
+}
+
+// pgTransaction runs fn in a repeatable read transaction, which like a
+// MongoDB transaction reads one snapshot and fails on write conflicts. fn is
+// retried when the transaction can't be serialized.
+func pgTransaction(ctx context.Context, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	if _, ok := ctx.Value(pgTxKey{}).(pgx.Tx); ok {
+		return fn(ctx)
+	}
+	var err error
+	for attempt := 0; attempt < transactionRetries; attempt++ {
+		var result interface{}
+		result, err = func() (interface{}, error) {
+			tx, err := pgPool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead})
+			if err != nil {
+				return nil, err
+			}
+			defer tx.Rollback(context.Background())
+			result, err := fn(context.WithValue(ctx, pgTxKey{}, tx))
+			if err != nil {
+				return nil, err
+			}
+			return result, tx.Commit(ctx)
+		}()
+		var pgErr *pgconn.PgError
+		if !errors.As(err, &pgErr) || (pgErr.Code != pgSerializationFailure && pgErr.Code != pgDeadlockDetected) {
+			return result, err
+		}
+	}
+	return nil, err
+}
+
+// pgTable is the backend of a collection stored in PostgreSQL
+type pgTable struct {
+	pool         *pgxpool.Pool
+	name         string
+	queryTimeout time.Duration
+
+	mu  sync.Mutex
+	ttl map[string]time.Duration // Field to expire documents after
+}
+
+func (t *pgTable) ident() string {
+	return pgx.Identifier{t.name}.Sanitize()
+}
+
+func (t *pgTable) create(ctx context.Context) error {
+	_, err := t.pool.Exec(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		seq BIGSERIAL,
+		id TEXT PRIMARY KEY,
+		doc BYTEA NOT NULL,
+		fields JSONB NOT NULL
+	)`, t.ident()))
+	if err != nil {
+		return err
+	}
+	_, err = t.pool.Exec(ctx, fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s USING GIN (fields jsonb_path_ops)",
+		pgx.Identifier{t.name + "_fields"}.Sanitize(), t.ident()))
+	return err
+}
+
+// querier returns the transaction of ctx, or the pool outside one
+func (t *pgTable) querier(ctx context.Context) pgQuerier {
+	if tx, ok := ctx.Value(pgTxKey{}).(pgx.Tx); ok {
+		return tx
+	}
+	return t.pool
+}
+
+// withTimeout bounds ctx by the query timeout when it has no deadline
+func (t *pgTable) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || t.queryTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, t.queryTimeout)
+}
+
+func (t *pgTable) load(ctx context.Context, q pgQuerier, filter bson.D, lock bool) ([]bson.Raw, error) {
+	var where pgCondition
+	sql := fmt.Sprintf("SELECT doc FROM %s WHERE %s ORDER BY seq", t.ident(), where.filter(filter))
+	if lock {
+		sql += " FOR UPDATE"
+	}
+	rows, err := q.Query(ctx, sql, where.args...)
+	if err != nil {
+		return nil, err
+	}
+	docs, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (bson.Raw, error) {
+		var doc []byte
+		err := row.Scan(&doc)
+		return doc, err
+	})
+	return docs, pgError(err)
+}
+
+func (t *pgTable) candidates(ctx context.Context, filter bson.D) ([]bson.Raw, error) {
+	ctx, cancel := t.withTimeout(ctx)
+	defer cancel()
+	return t.load(ctx, t.querier(ctx), filter, false)
+}
+
+func (t *pgTable) modify(ctx context.Context, filter bson.D, fn func(docs []bson.Raw, w writer) error) error {
+	ctx, cancel := t.withTimeout(ctx)
+	defer cancel()
+
+	// Within a transaction this is a savepoint
+	tx, err := t.querier(ctx).Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	docs, err := t.load(ctx, tx, filter, true)
+	if err != nil {
+		return err
+	}
+	if err := fn(docs, &pgWriter{ctx: ctx, tx: tx, table: t}); err != nil {
+		return err
+	}
+	return pgError(tx.Commit(ctx))
+}
+
+// pgWriter writes a table's rows in a transaction
+type pgWriter struct {
+	ctx   context.Context
+	tx    pgx.Tx
+	table *pgTable
+}
+
+// row returns the id and fields columns of a document
+func row(doc bson.Raw) (string, string, error) {
+	id, err := documentID(doc)
+	if err != nil {
+		return "", "", err
+	}
+	key, err := idKey(id)
+	if err != nil {
+		return "", "", err
+	}
+	fields, err := bson.MarshalExtJSON(doc, true, false)
+	return key, string(fields), err
+}
+
+func (w *pgWriter) insert(doc bson.Raw) error {
+	key, fields, err := row(doc)
+	if err != nil {
+		return err
+	}
+	_, err = w.tx.Exec(w.ctx, fmt.Sprintf("INSERT INTO %s (id, doc, fields) VALUES ($1, $2, $3)", w.table.ident()),
+		key, []byte(doc), fields)
+	return pgError(err)
+}
+
+func (w *pgWriter) replace(doc bson.Raw) error {
+	key, fields, err := row(doc)
+	if err != nil {
+		return err
+	}
+	_, err = w.tx.Exec(w.ctx, fmt.Sprintf("UPDATE %s SET doc = $2, fields = $3 WHERE id = $1", w.table.ident()),
+		key, []byte(doc), fields)
+	return pgError(err)
+}
+
+func (w *pgWriter) remove(id interface{}) error {
+	key, err := idKey(id)
+	if err != nil {
+		return err
+	}
+	_, err = w.tx.Exec(w.ctx, fmt.Sprintf("DELETE FROM %s WHERE id = $1", w.table.ident()), key)
+	return pgError(err)
+}
+
+// pgError turns unique violations into MongoDB's duplicate key error
+func pgError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation {
+		return duplicateKeyError(pgErr.ConstraintName, pgErr.Detail)
+	}
+	return err
+}
+
+var nonIdentifier = regexp.MustCompile(`[^a-zA-Z0-9_]+`)
+
+// createIndex builds the unique indexes of a model and records its TTL.
+// Other indexes aren't needed: queries find rows through the fields index.
+func (t *pgTable) createIndex(ctx context.Context, model mongo.IndexModel) error {
+	keys, err := toDocument(model.Keys)
+	if err != nil {
+		return err
+	}
+	opts := model.Options
+	if opts == nil {
+		return nil
+	}
+	if opts.ExpireAfterSeconds != nil && len(keys) == 1 {
+		t.mu.Lock()
+		if t.ttl == nil {
+			t.ttl = make(map[string]time.Duration)
+		}
+		t.ttl[keys[0].Key] = time.Duration(*opts.ExpireAfterSeconds) * time.Second
+		t.mu.Unlock()
+	}
+	if opts.Unique == nil || !*opts.Unique {
+		return nil
+	}
+
+	var names, columns, present []string
+	for _, key := range keys {
+		path := pgPath(key.Key)
+		names = append(names, key.Key)
+		// Documents missing a field index it as null, as in MongoDB
+		columns = append(columns, fmt.Sprintf("(COALESCE(fields #> %s, 'null'::jsonb))", path))
+		present = append(present, fmt.Sprintf("fields #> %s IS NOT NULL", path))
+	}
+	name := nonIdentifier.ReplaceAllString(t.name+"_"+strings.Join(names, "_"), "_")
+	if len(name) > 63 {
+		sum := sha1.Sum([]byte(name))
+		name = name[:50] + "_" + hex.EncodeToString(sum[:6])
+	}
+	sql := fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s ON %s (%s)",
+		pgx.Identifier{name}.Sanitize(), t.ident(), strings.Join(columns, ", "))
+	if opts.Sparse != nil && *opts.Sparse {
+		// Sparse indexes skip documents missing all of their fields
+		sql += " WHERE " + strings.Join(present, " OR ")
+	}
+	_, err = t.pool.Exec(ctx, sql)
+	return err
+}
+
+// pgPath is a dotted field as a PostgreSQL text array literal
+func pgPath(field string) string {
+	parts := splitPath(field)
+	for i, part := range parts {
+		parts[i] = strconv.Quote(part)
+	}
+	return "'{" + strings.ReplaceAll(strings.Join(parts, ","), "'", "''") + "}'"
+}
+
+// sweepExpired deletes documents whose TTL has passed until ctx is done
+func sweepExpired(ctx context.Context, tables []*pgTable) {
+	ticker := time.NewTicker(TTLSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, table := range tables {
+			table.mu.Lock()
+			ttl := make(map[string]time.Duration, len(table.ttl))
+			for field, after := range table.ttl {
+				ttl[field] = after
+			}
+			table.mu.Unlock()
+
+			for field, after := range ttl {
+				cutoff := time.Now().Add(-after).UnixMilli()
+				_, err := table.pool.Exec(ctx, fmt.Sprintf(
+					"DELETE FROM %s WHERE (fields #>> %s)::bigint < $1",
+					table.ident(), pgPath(field+".$date.$numberLong")), cutoff)
+				if err != nil && ctx.Err() == nil {
+					log.Printf("Failed to delete expired %s: %v", table.name, err)
+				}
+			}
+		}
+	}
+}
+
+// pgCondition builds a WHERE clause that selects a superset of the rows a
+// filter matches, from the parts of the filter the fields index can answer
+type pgCondition struct {
+	args []any
+}
+
+func (c *pgCondition) arg(v any) string {
+	c.args = append(c.args, v)
+	return "$" + strconv.Itoa(len(c.args))
+}
+
+func (c *pgCondition) filter(filter bson.D) string {
+	var conditions []string
+	for _, element := range filter {
+		var condition string
+		switch element.Key {
+		case "$and", "$or":
+			list, _ := element.Value.(bson.A)
+			var parts []string
+			used := len(c.args)
+			for _, item := range list {
+				sub, ok := item.(bson.D)
+				if !ok {
+					// Drop the arguments of the parts left out too
+					parts, c.args = nil, c.args[:used]
+					break
+				}
+				parts = append(parts, c.filter(sub))
+			}
+			if len(parts) > 0 {
+				separator := " AND "
+				if element.Key == "$or" {
+					separator = " OR "
+				}
+				condition = "(" + strings.Join(parts, separator) + ")"
+			}
+		default:
+			condition = c.field(element.Key, element.Value)
+		}
+		if condition != "" && condition != "TRUE" {
+			conditions = append(conditions, condition)
+		}
+	}
+	if len(conditions) == 0 {
+		return "TRUE"
+	}
+	return strings.Join(conditions, " AND ")
+}
+
+// field is the condition for one field of a filter. Only top-level fields
+// compared with values whose extended JSON is unique are narrowed.
+func (c *pgCondition) field(name string, value interface{}) string {
+	if strings.HasPrefix(name, "$") || strings.Contains(name, ".") {
+		return "TRUE"
+	}
+	ops, ok := value.(bson.D)
+	if !ok || !isOperatorDocument(ops) {
+		return c.equal(name, value)
+	}
+	var conditions []string
+	for _, op := range ops {
+		switch op.Key {
+		case "$eq":
+			conditions = append(conditions, c.equal(name, op.Value))
+		case "$in":
+			conditions = append(conditions, c.in(name, op.Value))
+		case "$exists":
+			if truthy(op.Value) {
+				conditions = append(conditions, "fields ? "+c.arg(name))
+			} else {
+				conditions = append(conditions, "NOT fields ? "+c.arg(name))
+			}
+		}
+	}
+	if len(conditions) == 0 {
+		return "TRUE"
+	}
+	return "(" + strings.Join(conditions, " AND ") + ")"
+}
+
+// exactJSON returns the canonical extended JSON of values that only equal
+// values with the same JSON: not numbers, which compare across types, nor
+// null, which also matches missing fields
+func exactJSON(value interface{}) (string, bool) {
+	switch value.(type) {
+	case string, bool, primitive.ObjectID, primitive.DateTime:
+	default:
+		return "", false
+	}
+	key, err := idKey(value)
+	return key, err == nil
+}
+
+func (c *pgCondition) equal(name string, value interface{}) string {
+	v, ok := exactJSON(value)
+	if !ok {
+		return "TRUE"
+	}
+	if name == "_id" {
+		return "id = " + c.arg(v)
+	}
+	field, _ := json.Marshal(name)
+	// The field is the value, or an array holding it
+	return fmt.Sprintf("(fields @> %s::jsonb OR fields @> %s::jsonb)",
+		c.arg(fmt.Sprintf("{%s:%s}", field, v)), c.arg(fmt.Sprintf("{%s:[%s]}", field, v)))
+}
+
+func (c *pgCondition) in(name string, value interface{}) string {
+	list, ok := value.(bson.A)
+	if !ok {
+		return "TRUE"
+	}
+	if len(list) == 0 {
+		return "FALSE"
+	}
+	values := make([]string, len(list))
+	for i, item := range list {
+		if values[i], ok = exactJSON(item); !ok {
+			return "TRUE"
+		}
+	}
+	if name == "_id" {
+		return "id = ANY(" + c.arg(values) + ")"
+	}
+	conditions := make([]string, len(list))
+	for i, item := range list {
+		conditions[i] = c.equal(name, item)
+	}
+	return "(" + strings.Join(conditions, " OR ") + ")"
+}
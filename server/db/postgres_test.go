@@ -0,0 +1,98 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestPgConditionFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+		want   string
+		args   []any
+	}{
+		{"empty", `{}`, `TRUE`, nil},
+		{"_id", `{"_id": "u1"}`, `id = $1`, []any{`"u1"`}},
+		{"_id $eq", `{"_id": {"$eq": "u1"}}`, `(id = $1)`, []any{`"u1"`}},
+		{"string", `{"email": "a@b.c"}`, `(fields @> $1::jsonb OR fields @> $2::jsonb)`, []any{`{"email":"a@b.c"}`, `{"email":["a@b.c"]}`}},
+		{"bool", `{"active": true}`, `(fields @> $1::jsonb OR fields @> $2::jsonb)`, []any{`{"active":true}`, `{"active":[true]}`}},
+		{"date", `{"at": {"$date": "2026-01-01T00:00:00Z"}}`, `(fields @> $1::jsonb OR fields @> $2::jsonb)`,
+			[]any{`{"at":{"$date":{"$numberLong":"1767225600000"}}}`, `{"at":[{"$date":{"$numberLong":"1767225600000"}}]}`}},
+		{"number matches other number types", `{"n": 1}`, `TRUE`, nil},
+		{"null matches missing", `{"n": null}`, `TRUE`, nil},
+		{"document", `{"a": {"b": "c"}}`, `TRUE`, nil},
+		{"dotted path", `{"a.b": "c"}`, `TRUE`, nil},
+		{"range", `{"n": {"$gt": "a"}}`, `TRUE`, nil},
+		{"several fields", `{"_id": "u1", "n": 1, "tenantId": "t"}`, `id = $1 AND (fields @> $2::jsonb OR fields @> $3::jsonb)`,
+			[]any{`"u1"`, `{"tenantId":"t"}`, `{"tenantId":["t"]}`}},
+
+		{"_id $in", `{"_id": {"$in": ["u1", "u2"]}}`, `(id = ANY($1))`, []any{[]string{`"u1"`, `"u2"`}}},
+		{"$in", `{"role": {"$in": ["a", "b"]}}`, `(((fields @> $1::jsonb OR fields @> $2::jsonb) OR (fields @> $3::jsonb OR fields @> $4::jsonb)))`,
+			[]any{`{"role":"a"}`, `{"role":["a"]}`, `{"role":"b"}`, `{"role":["b"]}`}},
+		{"empty $in", `{"role": {"$in": []}}`, `(FALSE)`, nil},
+		{"$in with a number", `{"_id": {"$in": ["u1", 2]}}`, `(TRUE)`, nil},
+		{"$in with null", `{"role": {"$in": ["a", null]}}`, `(TRUE)`, nil},
+		{"$exists", `{"deletedAt": {"$exists": true}}`, `(fields ? $1)`, []any{"deletedAt"}},
+		{"not $exists", `{"deletedAt": {"$exists": false}}`, `(NOT fields ? $1)`, []any{"deletedAt"}},
+		{"operators together", `{"role": {"$exists": true, "$ne": "a"}}`, `(fields ? $1)`, []any{"role"}},
+
+		{"$and", `{"$and": [{"_id": "u1"}, {"n": 1}]}`, `(id = $1 AND TRUE)`, []any{`"u1"`}},
+		{"$or", `{"$or": [{"_id": "u1"}, {"email": "x"}]}`, `(id = $1 OR (fields @> $2::jsonb OR fields @> $3::jsonb))`,
+			[]any{`"u1"`, `{"email":"x"}`, `{"email":["x"]}`}},
+		{"$or with an open branch", `{"$or": [{"_id": "u1"}, {"n": 1}]}`, `(id = $1 OR TRUE)`, []any{`"u1"`}},
+		{"$or of a non-document", `{"$or": [{"_id": "u1"}, 1]}`, `TRUE`, nil},
+		{"$nor", `{"$nor": [{"_id": "u1"}]}`, `TRUE`, nil},
+		{"quotes are arguments", `{"it's": "o'k"}`, `(fields @> $1::jsonb OR fields @> $2::jsonb)`, []any{`{"it's":"o'k"}`, `{"it's":["o'k"]}`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c pgCondition
+			got := c.filter(extJSON(t, tt.filter))
+			if got != tt.want {
+				t.Errorf("filter(%s) = %s, want %s", tt.filter, got, tt.want)
+			}
+			if fmt.Sprint(c.args) != fmt.Sprint(tt.args) {
+				t.Errorf("filter(%s) args = %q, want %q", tt.filter, c.args, tt.args)
+			}
+		})
+	}
+}
+
+func TestPgPath(t *testing.T) {
+	tests := map[string]string{
+		"expiresAt": `'{"expiresAt"}'`,
+		"a.b":       `'{"a","b"}'`,
+		`it's`:      `'{"it''s"}'`,
+		`say"hi`:    `'{"say\"hi"}'`,
+	}
+	for field, want := range tests {
+		if got := pgPath(field); got != want {
+			t.Errorf("pgPath(%q) = %s, want %s", field, got, want)
+		}
+	}
+}
+
+func TestExactJSON(t *testing.T) {
+	doc := extJSON(t, `{
+		"s": "x", "b": false, "oid": {"$oid": "64b7f0c2a1b2c3d4e5f60718"}, "date": {"$date": "2026-01-01T00:00:00Z"},
+		"i": 1, "l": {"$numberLong": "1"}, "f": 1.0, "n": null, "a": ["x"], "d": {"s": "x"}
+	}`)
+	want := map[string]string{
+		"s":    `"x"`,
+		"b":    `false`,
+		"oid":  `{"$oid":"64b7f0c2a1b2c3d4e5f60718"}`,
+		"date": `{"$date":{"$numberLong":"1767225600000"}}`,
+	}
+	for _, element := range doc {
+		got, ok := exactJSON(element.Value)
+		if expected, exact := want[element.Key]; ok != exact || got != expected {
+			t.Errorf("exactJSON(%s) = %s, %v, want %s, %v", element.Key, got, ok, expected, exact)
+		}
+	}
+	if _, ok := exactJSON(bson.D{}); ok {
+		t.Error("exactJSON of a document is exact, want not")
+	}
+}
@@ -0,0 +1,834 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Stores other than MongoDB evaluate queries themselves. Documents are
+// decoded to bson.D, so nested documents are bson.D and arrays bson.A, and
+// values are compared in MongoDB's BSON order. The operators supported are
+// the ones the server uses; others are refused rather than misread.
+
+// matcher reports whether a document matches a compiled filter
+type matcher func(doc bson.D) bool
+
+// toDocument converts a filter, update or document given as bson.M, bson.D,
+// a struct or raw BSON to a bson.D. nil is the empty document.
+func toDocument(v interface{}) (bson.D, error) {
+	switch v := v.(type) {
+	case nil:
+		return bson.D{}, nil
+	case bson.D:
+		if v == nil {
+			return bson.D{}, nil
+		}
+		// Round trip anyway, so nested values take their decoded types
+	case bson.Raw:
+		var doc bson.D
+		err := bson.Unmarshal(v, &doc)
+		return doc, err
+	}
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.D
+	err = bson.Unmarshal(raw, &doc)
+	return doc, err
+}
+
+// compileFilter compiles a query filter
+func compileFilter(filter bson.D) (matcher, error) {
+	var clauses []matcher
+	for _, element := range filter {
+		var clause matcher
+		var err error
+		switch element.Key {
+		case "$and", "$or", "$nor":
+			clause, err = compileLogical(element.Key, element.Value)
+		case "$comment":
+			continue
+		default:
+			if strings.HasPrefix(element.Key, "$") {
+				return nil, fmt.Errorf("unsupported query operator %s", element.Key)
+			}
+			clause, err = compileField(splitPath(element.Key), element.Value)
+		}
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, clause)
+	}
+	return func(doc bson.D) bool {
+		for _, clause := range clauses {
+			if !clause(doc) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+func compileLogical(op string, value interface{}) (matcher, error) {
+	list, ok := value.(bson.A)
+	if !ok || len(list) == 0 {
+		return nil, fmt.Errorf("%s takes a non-empty array", op)
+	}
+	clauses := make([]matcher, len(list))
+	for i, item := range list {
+		sub, ok := item.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("%s entries must be documents", op)
+		}
+		var err error
+		if clauses[i], err = compileFilter(sub); err != nil {
+			return nil, err
+		}
+	}
+	return func(doc bson.D) bool {
+		for _, clause := range clauses {
+			matched := clause(doc)
+			switch {
+			case op == "$and" && !matched:
+				return false
+			case op == "$or" && matched:
+				return true
+			case op == "$nor" && matched:
+				return false
+			}
+		}
+		return op != "$or"
+	}, nil
+}
+
+// isOperatorDocument reports whether v is a document of query operators,
+// such as {$gt: 1}, rather than a document to compare with
+func isOperatorDocument(v interface{}) bool {
+	doc, ok := v.(bson.D)
+	return ok && len(doc) > 0 && strings.HasPrefix(doc[0].Key, "$")
+}
+
+// compileField compiles the condition on one field path
+func compileField(path []string, condition interface{}) (matcher, error) {
+	if regex, ok := condition.(primitive.Regex); ok {
+		test, err := compileRegex(regex.Pattern, regex.Options)
+		if err != nil {
+			return nil, err
+		}
+		return pathMatcher(path, test), nil
+	}
+	if !isOperatorDocument(condition) {
+		return equalityMatcher(path, condition), nil
+	}
+
+	ops := condition.(bson.D)
+	var clauses []matcher
+	for _, op := range ops {
+		var clause matcher
+		switch op.Key {
+		case "$eq":
+			clause = equalityMatcher(path, op.Value)
+		case "$ne":
+			eq := equalityMatcher(path, op.Value)
+			clause = func(doc bson.D) bool { return !eq(doc) }
+		case "$gt", "$gte", "$lt", "$lte":
+			clause = pathMatcher(path, comparison(op.Key, op.Value))
+		case "$in", "$nin":
+			list, ok := op.Value.(bson.A)
+			if !ok {
+				return nil, fmt.Errorf("%s takes an array", op.Key)
+			}
+			in, err := inMatcher(path, list)
+			if err != nil {
+				return nil, err
+			}
+			clause = in
+			if op.Key == "$nin" {
+				clause = func(doc bson.D) bool { return !in(doc) }
+			}
+		case "$exists":
+			want := truthy(op.Value)
+			clause = func(doc bson.D) bool {
+				_, found := lookupPath(doc, path)
+				return found == want
+			}
+		case "$regex":
+			pattern, _ := op.Value.(string)
+			options := ""
+			if regex, ok := op.Value.(primitive.Regex); ok {
+				pattern, options = regex.Pattern, regex.Options
+			}
+			for _, other := range ops {
+				if other.Key == "$options" {
+					options, _ = other.Value.(string)
+				}
+			}
+			test, err := compileRegex(pattern, options)
+			if err != nil {
+				return nil, err
+			}
+			clause = pathMatcher(path, test)
+		case "$options":
+			// Read along with $regex
+			if _, ok := lookupPath(ops, []string{"$regex"}); !ok {
+				return nil, fmt.Errorf("$options needs $regex")
+			}
+			continue
+		case "$type":
+			test, err := typeTest(op.Value)
+			if err != nil {
+				return nil, err
+			}
+			clause = pathMatcher(path, test)
+		case "$size":
+			size, ok := toInt64(op.Value)
+			if !ok {
+				return nil, fmt.Errorf("$size takes a number")
+			}
+			clause = func(doc bson.D) bool {
+				for _, value := range lookupValues(doc, path) {
+					if list, ok := value.(bson.A); ok && int64(len(list)) == size {
+						return true
+					}
+				}
+				return false
+			}
+		case "$all":
+			list, ok := op.Value.(bson.A)
+			if !ok {
+				return nil, fmt.Errorf("$all takes an array")
+			}
+			all := make([]matcher, len(list))
+			for j, item := range list {
+				all[j] = equalityMatcher(path, item)
+			}
+			clause = func(doc bson.D) bool {
+				for _, m := range all {
+					if !m(doc) {
+						return false
+					}
+				}
+				return len(all) > 0
+			}
+		case "$elemMatch":
+			sub, ok := op.Value.(bson.D)
+			if !ok {
+				return nil, fmt.Errorf("$elemMatch takes a document")
+			}
+			element, err := compileElementMatch(sub)
+			if err != nil {
+				return nil, err
+			}
+			clause = func(doc bson.D) bool {
+				for _, value := range lookupValues(doc, path) {
+					if list, ok := value.(bson.A); ok {
+						for _, item := range list {
+							if element(item) {
+								return true
+							}
+						}
+					}
+				}
+				return false
+			}
+		case "$not":
+			inner, err := compileField(path, op.Value)
+			if err != nil {
+				return nil, err
+			}
+			clause = func(doc bson.D) bool { return !inner(doc) }
+		default:
+			return nil, fmt.Errorf("unsupported query operator %s", op.Key)
+		}
+		clauses = append(clauses, clause)
+	}
+	return func(doc bson.D) bool {
+		for _, clause := range clauses {
+			if !clause(doc) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// compileElementMatch compiles a condition on array elements, either a
+// query on document elements or operators on the elements themselves. It
+// also serves $pull.
+func compileElementMatch(condition interface{}) (func(interface{}) bool, error) {
+	if isOperatorDocument(condition) {
+		m, err := compileField([]string{"v"}, condition)
+		if err != nil {
+			return nil, err
+		}
+		return func(item interface{}) bool { return m(bson.D{{Key: "v", Value: item}}) }, nil
+	}
+	if sub, ok := condition.(bson.D); ok {
+		m, err := compileFilter(sub)
+		if err != nil {
+			return nil, err
+		}
+		return func(item interface{}) bool {
+			doc, ok := item.(bson.D)
+			return ok && m(doc)
+		}, nil
+	}
+	return func(item interface{}) bool { return compareValues(item, condition) == 0 }, nil
+}
+
+// pathMatcher matches documents with a value at path, or an element of an
+// array there, that passes test
+func pathMatcher(path []string, test func(interface{}) bool) matcher {
+	return func(doc bson.D) bool {
+		for _, value := range lookupValues(doc, path) {
+			if test(value) {
+				return true
+			}
+			if list, ok := value.(bson.A); ok {
+				for _, item := range list {
+					if test(item) {
+						return true
+					}
+				}
+			}
+		}
+		return false
+	}
+}
+
+// equalityMatcher matches documents whose value at path equals want or is
+// an array containing it. A null want also matches a missing field.
+func equalityMatcher(path []string, want interface{}) matcher {
+	if regex, ok := want.(primitive.Regex); ok {
+		if test, err := compileRegex(regex.Pattern, regex.Options); err == nil {
+			return pathMatcher(path, test)
+		}
+	}
+	if want == nil {
+		return func(doc bson.D) bool {
+			values := lookupValues(doc, path)
+			if len(values) == 0 {
+				return true
+			}
+			for _, value := range values {
+				if value == nil {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return pathMatcher(path, func(value interface{}) bool { return compareValues(value, want) == 0 })
+}
+
+func inMatcher(path []string, list bson.A) (matcher, error) {
+	clauses := make([]matcher, len(list))
+	for i, item := range list {
+		if isOperatorDocument(item) {
+			return nil, fmt.Errorf("$in entries can't be operators")
+		}
+		clauses[i] = equalityMatcher(path, item)
+	}
+	return func(doc bson.D) bool {
+		for _, clause := range clauses {
+			if clause(doc) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// comparison tests values against want with a range operator. Values only
+// compare with values of the same kind: numbers with numbers, dates with
+// dates, and so on.
+func comparison(op string, want interface{}) func(interface{}) bool {
+	return func(value interface{}) bool {
+		if typeRank(value) != typeRank(want) {
+			return false
+		}
+		c := compareValues(value, want)
+		switch op {
+		case "$gt":
+			return c > 0
+		case "$gte":
+			return c >= 0
+		case "$lt":
+			return c < 0
+		default:
+			return c <= 0
+		}
+	}
+}
+
+func compileRegex(pattern, options string) (func(interface{}) bool, error) {
+	flags := ""
+	for _, option := range options {
+		switch option {
+		case 'i', 'm', 's':
+			flags += string(option)
+		case 'x', 'u':
+		default:
+			return nil, fmt.Errorf("unsupported regex option %q", option)
+		}
+	}
+	if flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return func(value interface{}) bool {
+		s, ok := value.(string)
+		return ok && re.MatchString(s)
+	}, nil
+}
+
+// typeTest tests values against a $type alias or BSON type number
+func typeTest(want interface{}) (func(interface{}) bool, error) {
+	aliases := map[string]func(interface{}) bool{
+		"double":    func(v interface{}) bool { _, ok := v.(float64); return ok },
+		"string":    func(v interface{}) bool { _, ok := v.(string); return ok },
+		"object":    func(v interface{}) bool { _, ok := v.(bson.D); return ok },
+		"array":     func(v interface{}) bool { _, ok := v.(bson.A); return ok },
+		"binData":   func(v interface{}) bool { _, ok := v.(primitive.Binary); return ok },
+		"objectId":  func(v interface{}) bool { _, ok := v.(primitive.ObjectID); return ok },
+		"bool":      func(v interface{}) bool { _, ok := v.(bool); return ok },
+		"date":      func(v interface{}) bool { _, ok := v.(primitive.DateTime); return ok },
+		"null":      func(v interface{}) bool { return v == nil },
+		"int":       func(v interface{}) bool { _, ok := v.(int32); return ok },
+		"long":      func(v interface{}) bool { _, ok := v.(int64); return ok },
+		"decimal":   func(v interface{}) bool { _, ok := v.(primitive.Decimal128); return ok },
+		"number":    func(v interface{}) bool { return typeRank(v) == rankNumber },
+		"timestamp": func(v interface{}) bool { _, ok := v.(primitive.Timestamp); return ok },
+		"regex":     func(v interface{}) bool { _, ok := v.(primitive.Regex); return ok },
+	}
+	numbers := map[int64]string{1: "double", 2: "string", 3: "object", 4: "array", 5: "binData", 7: "objectId",
+		8: "bool", 9: "date", 10: "null", 11: "regex", 16: "int", 17: "timestamp", 18: "long", 19: "decimal"}
+
+	alias, ok := want.(string)
+	if number, isNumber := toInt64(want); isNumber {
+		alias, ok = numbers[number]
+	}
+	test, known := aliases[alias]
+	if !ok || !known {
+		return nil, fmt.Errorf("unsupported $type %v", want)
+	}
+	return test, nil
+}
+
+// splitPath splits a dotted field path
+func splitPath(path string) []string {
+	return strings.Split(path, ".")
+}
+
+// lookupValues returns the values at path. Arrays along the way are
+// traversed, so "a.b" finds the b of each document in an array a; a
+// numeric part indexes an array.
+func lookupValues(value interface{}, path []string) []interface{} {
+	if len(path) == 0 {
+		return []interface{}{value}
+	}
+	switch v := value.(type) {
+	case bson.D:
+		for _, element := range v {
+			if element.Key == path[0] {
+				return lookupValues(element.Value, path[1:])
+			}
+		}
+	case bson.A:
+		var values []interface{}
+		if index, err := strconv.Atoi(path[0]); err == nil && index >= 0 {
+			if index < len(v) {
+				values = append(values, lookupValues(v[index], path[1:])...)
+			}
+			return values
+		}
+		for _, item := range v {
+			if _, ok := item.(bson.D); ok {
+				values = append(values, lookupValues(item, path)...)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// lookupPath returns the first value at path and whether there is one
+func lookupPath(doc bson.D, path []string) (interface{}, bool) {
+	values := lookupValues(doc, path)
+	if len(values) == 0 {
+		return nil, false
+	}
+	return values[0], true
+}
+
+func truthy(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	}
+	if n, ok := toFloat64(v); ok {
+		return n != 0
+	}
+	return true
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	case float64:
+		if v == math.Trunc(v) {
+			return int64(v), true
+		}
+	}
+	return 0, false
+}
+
+func toFloat64(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	case primitive.Decimal128:
+		f, err := strconv.ParseFloat(v.String(), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// BSON comparison order of the kinds of values
+const (
+	rankMinKey = iota
+	rankNull
+	rankNumber
+	rankString
+	rankObject
+	rankArray
+	rankBinary
+	rankObjectID
+	rankBool
+	rankDate
+	rankTimestamp
+	rankRegex
+	rankMaxKey
+)
+
+func typeRank(v interface{}) int {
+	switch v.(type) {
+	case primitive.MinKey:
+		return rankMinKey
+	case nil, primitive.Null, primitive.Undefined:
+		return rankNull
+	case int32, int64, int, float64, primitive.Decimal128:
+		return rankNumber
+	case string, primitive.Symbol:
+		return rankString
+	case bson.D:
+		return rankObject
+	case bson.A:
+		return rankArray
+	case primitive.Binary:
+		return rankBinary
+	case primitive.ObjectID:
+		return rankObjectID
+	case bool:
+		return rankBool
+	case primitive.DateTime:
+		return rankDate
+	case primitive.Timestamp:
+		return rankTimestamp
+	case primitive.Regex:
+		return rankRegex
+	case primitive.MaxKey:
+		return rankMaxKey
+	}
+	return rankObject
+}
+
+// compareValues orders two values as MongoDB does
+func compareValues(a, b interface{}) int {
+	ra, rb := typeRank(a), typeRank(b)
+	if ra != rb {
+		return cmpInt(int64(ra), int64(rb))
+	}
+	switch ra {
+	case rankNumber:
+		ia, aInt := a.(int64)
+		ib, bInt := b.(int64)
+		if aInt && bInt {
+			return cmpInt(ia, ib)
+		}
+		fa, _ := toFloat64(a)
+		fb, _ := toFloat64(b)
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	case rankString:
+		return strings.Compare(stringOf(a), stringOf(b))
+	case rankObject:
+		da, db := a.(bson.D), b.(bson.D)
+		for i := 0; i < len(da) && i < len(db); i++ {
+			if c := strings.Compare(da[i].Key, db[i].Key); c != 0 {
+				return c
+			}
+			if c := compareValues(da[i].Value, db[i].Value); c != 0 {
+				return c
+			}
+		}
+		return cmpInt(int64(len(da)), int64(len(db)))
+	case rankArray:
+		aa, ab := a.(bson.A), b.(bson.A)
+		for i := 0; i < len(aa) && i < len(ab); i++ {
+			if c := compareValues(aa[i], ab[i]); c != 0 {
+				return c
+			}
+		}
+		return cmpInt(int64(len(aa)), int64(len(ab)))
+	case rankBinary:
+		ba, bb := a.(primitive.Binary), b.(primitive.Binary)
+		if len(ba.Data) != len(bb.Data) {
+			return cmpInt(int64(len(ba.Data)), int64(len(bb.Data)))
+		}
+		if ba.Subtype != bb.Subtype {
+			return cmpInt(int64(ba.Subtype), int64(bb.Subtype))
+		}
+		return bytes.Compare(ba.Data, bb.Data)
+	case rankObjectID:
+		oa, ob := a.(primitive.ObjectID), b.(primitive.ObjectID)
+		return bytes.Compare(oa[:], ob[:])
+	case rankBool:
+		ba, bb := a.(bool), b.(bool)
+		if ba == bb {
+			return 0
+		}
+		if !ba {
+			return -1
+		}
+		return 1
+	case rankDate:
+		return cmpInt(int64(a.(primitive.DateTime)), int64(b.(primitive.DateTime)))
+	case rankTimestamp:
+		ta, tb := a.(primitive.Timestamp), b.(primitive.Timestamp)
+		return primitive.CompareTimestamp(ta, tb)
+	case rankRegex:
+		xa, xb := a.(primitive.Regex), b.(primitive.Regex)
+		if c := strings.Compare(xa.Pattern, xb.Pattern); c != 0 {
+			return c
+		}
+		return strings.Compare(xa.Options, xb.Options)
+	}
+	return 0
+}
+
+func stringOf(v interface{}) string {
+	if symbol, ok := v.(primitive.Symbol); ok {
+		return string(symbol)
+	}
+	return v.(string)
+}
+
+func cmpInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// sortDocuments sorts documents by a sort specification such as
+// {createdAt: -1, _id: -1}. An array sorts by its least element ascending
+// and its greatest descending, and an empty one before null; a missing
+// field sorts as null.
+func sortDocuments(docs []bson.D, spec bson.D) error {
+	if len(spec) == 0 {
+		return nil
+	}
+	type key struct {
+		path []string
+		desc bool
+	}
+	keys := make([]key, len(spec))
+	for i, element := range spec {
+		direction, ok := toInt64(element.Value)
+		if !ok || (direction != 1 && direction != -1) {
+			return fmt.Errorf("sort direction of %s must be 1 or -1", element.Key)
+		}
+		keys[i] = key{splitPath(element.Key), direction == -1}
+	}
+	sortValue := func(doc bson.D, k key) interface{} {
+		values := lookupValues(doc, k.path)
+		var best interface{}
+		found := false
+		for _, value := range values {
+			candidates := []interface{}{value}
+			if list, ok := value.(bson.A); ok {
+				candidates = list
+				if len(list) == 0 {
+					candidates = []interface{}{primitive.MinKey{}}
+				}
+			}
+			for _, candidate := range candidates {
+				c := compareValues(candidate, best)
+				if !found || (k.desc && c > 0) || (!k.desc && c < 0) {
+					best, found = candidate, true
+				}
+			}
+		}
+		return best
+	}
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, k := range keys {
+			c := compareValues(sortValue(docs[i], k), sortValue(docs[j], k))
+			if k.desc {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+	return nil
+}
+
+// projectDocument applies a find projection of included ({a: 1}) or
+// excluded ({a: 0}) fields. _id is kept unless excluded.
+func projectDocument(doc bson.D, projection bson.D) (bson.D, error) {
+	if len(projection) == 0 {
+		return doc, nil
+	}
+	include := -1
+	keepID := true
+	for _, element := range projection {
+		if isOperatorDocument(element.Value) {
+			return nil, fmt.Errorf("unsupported projection of %s", element.Key)
+		}
+		on := truthy(element.Value)
+		if element.Key == "_id" {
+			keepID = on
+			continue
+		}
+		mode := 0
+		if on {
+			mode = 1
+		}
+		if include != -1 && include != mode {
+			return nil, fmt.Errorf("projection can't both include and exclude fields")
+		}
+		include = mode
+	}
+
+	if include == -1 && keepID {
+		// Only {_id: 1}, which includes just the _id
+		include = 1
+	}
+	if include != 1 {
+		out := cloneDocument(doc)
+		for _, element := range projection {
+			if element.Key != "_id" || !keepID {
+				out = unsetPath(out, splitPath(element.Key))
+			}
+		}
+		return out, nil
+	}
+
+	var paths [][]string
+	if keepID {
+		paths = append(paths, []string{"_id"})
+	}
+	for _, element := range projection {
+		if element.Key != "_id" {
+			paths = append(paths, splitPath(element.Key))
+		}
+	}
+	return includePaths(doc, paths), nil
+}
+
+// includePaths keeps only the fields of doc on paths, in doc's order
+func includePaths(doc bson.D, paths [][]string) bson.D {
+	out := bson.D{}
+	for _, element := range doc {
+		var rest [][]string
+		whole := false
+		for _, path := range paths {
+			if path[0] != element.Key {
+				continue
+			}
+			if len(path) == 1 {
+				whole = true
+			} else {
+				rest = append(rest, path[1:])
+			}
+		}
+		switch {
+		case whole:
+			out = append(out, element)
+		case len(rest) > 0:
+			switch v := element.Value.(type) {
+			case bson.D:
+				out = append(out, bson.E{Key: element.Key, Value: includePaths(v, rest)})
+			case bson.A:
+				list := bson.A{}
+				for _, item := range v {
+					if sub, ok := item.(bson.D); ok {
+						list = append(list, includePaths(sub, rest))
+					}
+				}
+				out = append(out, bson.E{Key: element.Key, Value: list})
+			}
+		}
+	}
+	return out
+}
+
+// cloneDocument deep copies a document so it can be changed in place
+func cloneDocument(doc bson.D) bson.D {
+	return cloneValue(doc).(bson.D)
+}
+
+func cloneValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case bson.D:
+		out := make(bson.D, len(v))
+		for i, element := range v {
+			out[i] = bson.E{Key: element.Key, Value: cloneValue(element.Value)}
+		}
+		return out
+	case bson.A:
+		out := make(bson.A, len(v))
+		for i, item := range v {
+			out[i] = cloneValue(item)
+		}
+		return out
+	}
+	return v
+}
@@ -0,0 +1,348 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The expectations in these tests are what MongoDB returns for the same
+// queries, updates and pipelines.
+
+var testTime = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// extJSON parses a document written as relaxed extended JSON: 1 is an int32,
+// 1.5 a double and {"$numberLong": "1"} an int64
+func extJSON(t *testing.T, s string) bson.D {
+	t.Helper()
+	var doc bson.D
+	if err := bson.UnmarshalExtJSON([]byte(s), false, &doc); err != nil {
+		t.Fatalf("parsing %s: %v", s, err)
+	}
+	return doc
+}
+
+// canonical returns v as canonical extended JSON, which tells numeric types
+// apart, for comparing results
+func canonical(t *testing.T, v interface{}) string {
+	t.Helper()
+	out, err := bson.MarshalExtJSON(v, true, false)
+	if err != nil {
+		t.Fatalf("marshalling %v: %v", v, err)
+	}
+	return string(out)
+}
+
+func TestCompileFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+		doc    string
+		want   bool
+	}{
+		{"equal", `{"a": 1}`, `{"a": 1}`, true},
+		{"equal other value", `{"a": 1}`, `{"a": 2}`, false},
+		{"numbers equal across types", `{"a": 1}`, `{"a": {"$numberLong": "1"}}`, true},
+		{"int equals double", `{"a": 1}`, `{"a": 1.0}`, true},
+		{"number is not a string", `{"a": 1}`, `{"a": "1"}`, false},
+		{"array holding the value", `{"a": 2}`, `{"a": [1, 2]}`, true},
+		{"whole array", `{"a": [1, 2]}`, `{"a": [1, 2]}`, true},
+		{"array in another order", `{"a": [1, 2]}`, `{"a": [2, 1]}`, false},
+		{"embedded document", `{"a": {"b": 1}}`, `{"a": {"b": 1}}`, true},
+		{"embedded document field order", `{"a": {"b": 1, "c": 2}}`, `{"a": {"c": 2, "b": 1}}`, false},
+		{"dotted path", `{"a.b": 1}`, `{"a": {"b": 1}}`, true},
+		{"dotted path through array", `{"a.b": 1}`, `{"a": [{"b": 2}, {"b": 1}]}`, true},
+		{"array index", `{"a.1": 2}`, `{"a": [1, 2]}`, true},
+		{"array index out of range", `{"a.5": 2}`, `{"a": [1, 2]}`, false},
+		{"null matches missing", `{"a": null}`, `{}`, true},
+		{"null matches null", `{"a": null}`, `{"a": null}`, true},
+		{"null doesn't match value", `{"a": null}`, `{"a": 0}`, false},
+		{"several fields", `{"a": 1, "b": 2}`, `{"a": 1, "b": 3}`, false},
+		{"empty filter", `{}`, `{"a": 1}`, true},
+
+		{"$eq", `{"a": {"$eq": 1}}`, `{"a": 1}`, true},
+		{"$ne", `{"a": {"$ne": 1}}`, `{"a": 2}`, true},
+		{"$ne missing", `{"a": {"$ne": 1}}`, `{}`, true},
+		{"$ne array holding it", `{"a": {"$ne": 1}}`, `{"a": [1, 2]}`, false},
+		{"$ne null missing", `{"a": {"$ne": null}}`, `{}`, false},
+		{"$ne null set", `{"a": {"$ne": null}}`, `{"a": 0}`, true},
+
+		{"$gt", `{"a": {"$gt": 1}}`, `{"a": 2}`, true},
+		{"$gt equal", `{"a": {"$gt": 1}}`, `{"a": 1}`, false},
+		{"$gte equal", `{"a": {"$gte": 1}}`, `{"a": 1}`, true},
+		{"$lt", `{"a": {"$lt": 1}}`, `{"a": 0.5}`, true},
+		{"$lte", `{"a": {"$lte": 1}}`, `{"a": 2}`, false},
+		{"$gt is bracketed by type", `{"a": {"$gt": 1}}`, `{"a": "2"}`, false},
+		{"$lt is bracketed by type", `{"a": {"$lt": "b"}}`, `{"a": 1}`, false},
+		{"$gt missing", `{"a": {"$gt": 1}}`, `{}`, false},
+		{"$gt array element", `{"a": {"$gt": 1}}`, `{"a": [0, 5]}`, true},
+		{"range by different elements", `{"a": {"$gt": 1, "$lt": 3}}`, `{"a": [0, 4]}`, true},
+		{"range", `{"a": {"$gt": 1, "$lt": 3}}`, `{"a": 4}`, false},
+		{"$gt strings", `{"a": {"$gt": "apple"}}`, `{"a": "banana"}`, true},
+		{"$lt dates", `{"a": {"$lt": {"$date": "2026-01-02T00:00:00Z"}}}`, `{"a": {"$date": "2026-01-01T00:00:00Z"}}`, true},
+		{"$gte dates", `{"a": {"$gte": {"$date": "2026-01-02T00:00:00Z"}}}`, `{"a": {"$date": "2026-01-01T00:00:00Z"}}`, false},
+
+		{"$in", `{"a": {"$in": [1, 2]}}`, `{"a": 2}`, true},
+		{"$in none", `{"a": {"$in": [1, 2]}}`, `{"a": 3}`, false},
+		{"$in array field", `{"a": {"$in": [1, 2]}}`, `{"a": [3, 1]}`, true},
+		{"$in null matches missing", `{"a": {"$in": [null]}}`, `{}`, true},
+		{"$in empty", `{"a": {"$in": []}}`, `{"a": 1}`, false},
+		{"$in regex", `{"a": {"$in": [{"$regularExpression": {"pattern": "^b", "options": ""}}]}}`, `{"a": "bob"}`, true},
+		{"$nin", `{"a": {"$nin": [1, 2]}}`, `{"a": 3}`, true},
+		{"$nin missing", `{"a": {"$nin": [1]}}`, `{}`, true},
+		{"$nin array holding one", `{"a": {"$nin": [1]}}`, `{"a": [1, 3]}`, false},
+
+		{"$exists", `{"a": {"$exists": true}}`, `{"a": null}`, true},
+		{"$exists missing", `{"a": {"$exists": true}}`, `{}`, false},
+		{"$exists false", `{"a": {"$exists": false}}`, `{}`, true},
+		{"$exists false set", `{"a": {"$exists": false}}`, `{"a": 1}`, false},
+		{"$exists number", `{"a": {"$exists": 1}}`, `{"a": 1}`, true},
+		{"$exists dotted", `{"a.b": {"$exists": true}}`, `{"a": {"c": 1}}`, false},
+
+		{"$regex", `{"a": {"$regex": "^ab"}}`, `{"a": "abc"}`, true},
+		{"$regex case", `{"a": {"$regex": "^ab"}}`, `{"a": "ABC"}`, false},
+		{"$regex $options", `{"a": {"$regex": "^ab", "$options": "i"}}`, `{"a": "ABC"}`, true},
+		{"regex value", `{"a": {"$regularExpression": {"pattern": "^ab", "options": "i"}}}`, `{"a": "ABC"}`, true},
+		{"regex array element", `{"a": {"$regex": "^go"}}`, `{"a": ["rust", "golang"]}`, true},
+		{"regex non-string", `{"a": {"$regex": "1"}}`, `{"a": 1}`, false},
+
+		{"$type alias", `{"a": {"$type": "string"}}`, `{"a": "x"}`, true},
+		{"$type number", `{"a": {"$type": 2}}`, `{"a": "x"}`, true},
+		{"$type other", `{"a": {"$type": "string"}}`, `{"a": 1}`, false},
+		{"$type int", `{"a": {"$type": "int"}}`, `{"a": 1}`, true},
+		{"$type long", `{"a": {"$type": "long"}}`, `{"a": 1}`, false},
+		{"$type number alias", `{"a": {"$type": "number"}}`, `{"a": 1.5}`, true},
+		{"$type null", `{"a": {"$type": "null"}}`, `{}`, false},
+		{"$type date", `{"a": {"$type": "date"}}`, `{"a": {"$date": "2026-01-01T00:00:00Z"}}`, true},
+		{"$type array element", `{"a": {"$type": "string"}}`, `{"a": [1, "x"]}`, true},
+
+		{"$size", `{"a": {"$size": 2}}`, `{"a": [1, 2]}`, true},
+		{"$size other", `{"a": {"$size": 1}}`, `{"a": [1, 2]}`, false},
+		{"$size not an array", `{"a": {"$size": 1}}`, `{"a": 1}`, false},
+		{"$size zero", `{"a": {"$size": 0}}`, `{"a": []}`, true},
+
+		{"$all", `{"a": {"$all": [1, 2]}}`, `{"a": [2, 3, 1]}`, true},
+		{"$all missing one", `{"a": {"$all": [1, 2]}}`, `{"a": [1, 3]}`, false},
+		{"$all empty", `{"a": {"$all": []}}`, `{"a": [1]}`, false},
+		{"$all scalar", `{"a": {"$all": [1]}}`, `{"a": 1}`, true},
+
+		{"$elemMatch", `{"a": {"$elemMatch": {"b": 1, "c": 2}}}`, `{"a": [{"b": 1, "c": 2}]}`, true},
+		{"$elemMatch one element", `{"a": {"$elemMatch": {"b": 1, "c": 2}}}`, `{"a": [{"b": 1, "c": 1}, {"b": 2, "c": 2}]}`, false},
+		{"$elemMatch operators", `{"a": {"$elemMatch": {"$gt": 1, "$lt": 3}}}`, `{"a": [0, 2]}`, true},
+		{"$elemMatch operators one element", `{"a": {"$elemMatch": {"$gt": 1, "$lt": 3}}}`, `{"a": [0, 4]}`, false},
+		{"$elemMatch not an array", `{"a": {"$elemMatch": {"b": 1}}}`, `{"a": {"b": 1}}`, false},
+
+		{"$not", `{"a": {"$not": {"$gt": 1}}}`, `{"a": 1}`, true},
+		{"$not matching", `{"a": {"$not": {"$gt": 1}}}`, `{"a": 2}`, false},
+		{"$not missing", `{"a": {"$not": {"$gt": 1}}}`, `{}`, true},
+		{"$not regex", `{"a": {"$not": {"$regularExpression": {"pattern": "^a", "options": ""}}}}`, `{"a": "bc"}`, true},
+
+		{"$and", `{"$and": [{"a": 1}, {"b": 2}]}`, `{"a": 1, "b": 2}`, true},
+		{"$and one fails", `{"$and": [{"a": 1}, {"b": 2}]}`, `{"a": 1, "b": 3}`, false},
+		{"$or", `{"$or": [{"a": 1}, {"b": 2}]}`, `{"a": 0, "b": 2}`, true},
+		{"$or none", `{"$or": [{"a": 1}, {"b": 2}]}`, `{"a": 0, "b": 0}`, false},
+		{"$nor", `{"$nor": [{"a": 1}, {"b": 2}]}`, `{"a": 0, "b": 0}`, true},
+		{"$nor one matches", `{"$nor": [{"a": 1}, {"b": 2}]}`, `{"a": 1}`, false},
+		{"$comment", `{"$comment": "why", "a": 1}`, `{"a": 1}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, err := compileFilter(extJSON(t, tt.filter))
+			if err != nil {
+				t.Fatalf("compileFilter(%s): %v", tt.filter, err)
+			}
+			if got := match(extJSON(t, tt.doc)); got != tt.want {
+				t.Errorf("%s matching %s = %v, want %v", tt.filter, tt.doc, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileFilterRefusesUnsupported(t *testing.T) {
+	filters := []string{
+		`{"$where": "this.a == 1"}`,
+		`{"$text": {"$search": "a"}}`,
+		`{"a": {"$near": [0, 0]}}`,
+		`{"a": {"$mod": [2, 0]}}`,
+		`{"a": {"$in": 1}}`,
+		`{"a": {"$in": [{"$gt": 1}]}}`,
+		`{"a": {"$size": "2"}}`,
+		`{"a": {"$type": "shape"}}`,
+		`{"a": {"$regex": "a", "$options": "z"}}`,
+		`{"a": {"$regex": "("}}`,
+		`{"$or": []}`,
+		`{"$and": [1]}`,
+	}
+	for _, filter := range filters {
+		if _, err := compileFilter(extJSON(t, filter)); err == nil {
+			t.Errorf("compileFilter(%s) succeeded, want an error", filter)
+		}
+	}
+}
+
+func TestCompareValues(t *testing.T) {
+	// Ascending in MongoDB's comparison order
+	ordered := bson.A{
+		primitive.MinKey{},
+		nil,
+		int32(1),
+		int64(2),
+		2.5,
+		"a",
+		"b",
+		bson.D{{Key: "a", Value: int32(1)}},
+		bson.D{{Key: "b", Value: int32(1)}},
+		bson.A{int32(1)},
+		bson.A{int32(1), int32(2)},
+		primitive.Binary{Data: []byte{1}},
+		primitive.NewObjectIDFromTimestamp(primitive.NewDateTimeFromTime(testTime).Time()),
+		false,
+		true,
+		primitive.NewDateTimeFromTime(testTime),
+		primitive.Timestamp{T: 1},
+		primitive.Regex{Pattern: "a"},
+		primitive.MaxKey{},
+	}
+	for i := range ordered {
+		for j := range ordered {
+			want := cmpInt(int64(i), int64(j))
+			if got := compareValues(ordered[i], ordered[j]); got != want {
+				t.Errorf("compareValues(%v, %v) = %d, want %d", ordered[i], ordered[j], got, want)
+			}
+		}
+	}
+
+	if got := compareValues(int32(2), 2.0); got != 0 {
+		t.Errorf("compareValues(int32 2, double 2) = %d, want 0", got)
+	}
+}
+
+func TestSortDocuments(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+		docs []string
+		want []int32 // _ids in sorted order
+	}{
+		{
+			name: "ascending",
+			spec: `{"n": 1}`,
+			docs: []string{`{"_id": 1, "n": 3}`, `{"_id": 2, "n": 1}`, `{"_id": 3, "n": 2}`},
+			want: []int32{2, 3, 1},
+		},
+		{
+			name: "descending",
+			spec: `{"n": -1}`,
+			docs: []string{`{"_id": 1, "n": 3}`, `{"_id": 2, "n": 1}`, `{"_id": 3, "n": 2}`},
+			want: []int32{1, 3, 2},
+		},
+		{
+			name: "second key breaks ties",
+			spec: `{"a": 1, "b": -1}`,
+			docs: []string{`{"_id": 1, "a": 1, "b": 1}`, `{"_id": 2, "a": 1, "b": 2}`, `{"_id": 3, "a": 0, "b": 0}`},
+			want: []int32{3, 2, 1},
+		},
+		{
+			name: "missing sorts as null, before numbers",
+			spec: `{"n": 1}`,
+			docs: []string{`{"_id": 1, "n": 1}`, `{"_id": 2}`, `{"_id": 3, "n": null}`},
+			want: []int32{2, 3, 1},
+		},
+		{
+			name: "types in comparison order",
+			spec: `{"v": 1}`,
+			docs: []string{`{"_id": 1, "v": true}`, `{"_id": 2, "v": "s"}`, `{"_id": 3, "v": 5}`, `{"_id": 4, "v": {"x": 1}}`},
+			want: []int32{3, 2, 4, 1},
+		},
+		{
+			name: "arrays ascending by their least element",
+			spec: `{"v": 1}`,
+			docs: []string{`{"_id": 1, "v": [5, 0]}`, `{"_id": 2, "v": 3}`, `{"_id": 3, "v": [4, 2]}`},
+			want: []int32{1, 3, 2},
+		},
+		{
+			name: "arrays descending by their greatest element",
+			spec: `{"v": -1}`,
+			docs: []string{`{"_id": 1, "v": [5, 0]}`, `{"_id": 2, "v": 3}`, `{"_id": 3, "v": [4, 2]}`},
+			want: []int32{1, 3, 2},
+		},
+		{
+			name: "empty array before null",
+			spec: `{"v": 1}`,
+			docs: []string{`{"_id": 1, "v": null}`, `{"_id": 2, "v": []}`, `{"_id": 3, "v": 1}`},
+			want: []int32{2, 1, 3},
+		},
+		{
+			name: "dotted path",
+			spec: `{"a.b": 1}`,
+			docs: []string{`{"_id": 1, "a": {"b": 2}}`, `{"_id": 2, "a": {"b": 1}}`},
+			want: []int32{2, 1},
+		},
+		{
+			name: "stable",
+			spec: `{"n": 1}`,
+			docs: []string{`{"_id": 1, "n": 1}`, `{"_id": 2, "n": 0}`, `{"_id": 3, "n": 1}`, `{"_id": 4, "n": 0}`},
+			want: []int32{2, 4, 1, 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docs := make([]bson.D, len(tt.docs))
+			for i, doc := range tt.docs {
+				docs[i] = extJSON(t, doc)
+			}
+			if err := sortDocuments(docs, extJSON(t, tt.spec)); err != nil {
+				t.Fatal(err)
+			}
+			for i, doc := range docs {
+				if id, _ := lookupPath(doc, []string{"_id"}); id != tt.want[i] {
+					t.Fatalf("sorted %s by %s = %v, want _ids %v", tt.docs, tt.spec, docs, tt.want)
+				}
+			}
+		})
+	}
+
+	if err := sortDocuments([]bson.D{{}}, extJSON(t, `{"n": 2}`)); err == nil {
+		t.Error("sorting by a direction of 2 succeeded, want an error")
+	}
+}
+
+func TestProjectDocument(t *testing.T) {
+	doc := `{"_id": 1, "a": 1, "b": {"c": 2, "d": 3}, "e": [{"f": 4, "g": 5}, {"f": 6}, 7]}`
+	tests := []struct {
+		name       string
+		projection string
+		want       string
+	}{
+		{"none", `{}`, doc},
+		{"include", `{"a": 1}`, `{"_id": 1, "a": 1}`},
+		{"include without _id", `{"a": 1, "_id": 0}`, `{"a": 1}`},
+		{"include keeps document order", `{"a": true, "_id": 1, "b": 1}`, `{"_id": 1, "a": 1, "b": {"c": 2, "d": 3}}`},
+		{"include dotted", `{"b.c": 1}`, `{"_id": 1, "b": {"c": 2}}`},
+		{"include through array", `{"e.f": 1}`, `{"_id": 1, "e": [{"f": 4}, {"f": 6}]}`},
+		{"include missing", `{"z": 1}`, `{"_id": 1}`},
+		{"exclude", `{"a": 0, "e": 0}`, `{"_id": 1, "b": {"c": 2, "d": 3}}`},
+		{"exclude dotted", `{"b.d": 0}`, `{"_id": 1, "a": 1, "b": {"c": 2}, "e": [{"f": 4, "g": 5}, {"f": 6}, 7]}`},
+		{"exclude _id only", `{"_id": 0}`, `{"a": 1, "b": {"c": 2, "d": 3}, "e": [{"f": 4, "g": 5}, {"f": 6}, 7]}`},
+		{"include _id only", `{"_id": 1}`, `{"_id": 1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := projectDocument(extJSON(t, doc), extJSON(t, tt.projection))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if canonical(t, got) != canonical(t, extJSON(t, tt.want)) {
+				t.Errorf("projecting %s = %s, want %s", tt.projection, canonical(t, got), tt.want)
+			}
+		})
+	}
+
+	for _, projection := range []string{`{"a": 1, "b": 0}`, `{"a": {"$slice": 1}}`} {
+		if _, err := projectDocument(extJSON(t, doc), extJSON(t, projection)); err == nil {
+			t.Errorf("projecting %s succeeded, want an error", projection)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package db
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"os"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/config"
+)
+
+//go:embed seed/demo.json
+var demoSeed []byte
+
+// Seed loads documents into the collections that are empty. source is
+// config.DatabaseSeedDemo or the path of an extended JSON file holding an object of
+// collection names to arrays of documents, e.g. {"users": [...]}. Documents
+// are inserted as they are, so tenant data goes to the tenant in its
+// tenantId field.
+func Seed(ctx context.Context, source string) error {
+	data := demoSeed
+	if source != config.DatabaseSeedDemo {
+		var err error
+		if data, err = os.ReadFile(source); err != nil {
+			return err
+		}
+	}
+
+	var seed bson.D
+	if err := bson.UnmarshalExtJSON(data, false, &seed); err != nil {
+		return fmt.Errorf("reading seed %s: %w", source, err)
+	}
+	for _, element := range seed {
+		store, ok := collections[element.Key]
+		if !ok {
+			return fmt.Errorf("seed %s: unknown collection %q", source, element.Key)
+		}
+		docs, ok := element.Value.(bson.A)
+		if !ok {
+			return fmt.Errorf("seed %s: %s must be an array of documents", source, element.Key)
+		}
+		count, err := store.CountDocuments(ctx, bson.D{}, options.Count().SetLimit(1))
+		if err != nil {
+			return err
+		}
+		if count > 0 || len(docs) == 0 {
+			continue
+		}
+		if _, err := store.InsertMany(ctx, docs); err != nil {
+			return fmt.Errorf("seeding %s: %w", element.Key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package db
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"video-meeting-app/config"
+)
+
+// connectMemory opens the collections in memory for one test
+func connectMemory(t *testing.T) {
+	t.Helper()
+	if err := ConnectMemory(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(CloseDB)
+}
+
+// count returns how many documents a collection holds
+func count(t *testing.T, name string) int64 {
+	t.Helper()
+	n, err := collections[name].CountDocuments(context.Background(), bson.D{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestSeedDemo(t *testing.T) {
+	connectMemory(t)
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := Seed(ctx, config.DatabaseSeedDemo); err != nil {
+			t.Fatalf("seeding %d: %v", i+1, err)
+		}
+		for name, want := range map[string]int64{"users": 2, "meetings": 1, "contacts": 1, "polls": 0} {
+			if got := count(t, name); got != want {
+				t.Errorf("after seeding %d times, %s holds %d documents, want %d", i+1, name, got, want)
+			}
+		}
+	}
+
+	var user struct {
+		Email string `bson:"email"`
+	}
+	if err := collections["users"].FindOne(ctx, bson.D{{Key: "_id", Value: "00000000-0000-4000-8000-000000000001"}}).Decode(&user); err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "demo@example.com" {
+		t.Errorf("the demo user's email is %q, want demo@example.com", user.Email)
+	}
+}
+
+func TestSeedFile(t *testing.T) {
+	connectMemory(t)
+	ctx := context.Background()
+	write := func(content string) string {
+		path := filepath.Join(t.TempDir(), "seed.json")
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	if _, err := collections["meetings"].InsertOne(ctx, bson.D{{Key: "_id", Value: "existing"}}); err != nil {
+		t.Fatal(err)
+	}
+	seed := write(`{
+		"users": [{"_id": "u1", "email": "a@example.com", "createdAt": {"$date": "2026-01-01T00:00:00Z"}}],
+		"meetings": [{"_id": "m1"}],
+		"polls": []
+	}`)
+	if err := Seed(ctx, seed); err != nil {
+		t.Fatal(err)
+	}
+	if got := count(t, "users"); got != 1 {
+		t.Errorf("users holds %d documents, want 1", got)
+	}
+	// Collections that hold documents already are left alone
+	if got := count(t, "meetings"); got != 1 {
+		t.Errorf("meetings holds %d documents, want only the one it had", got)
+	}
+
+	for _, tt := range []struct {
+		content string
+		err     string
+	}{
+		{`{"rooms": []}`, `unknown collection "rooms"`},
+		{`{"users": {"_id": "u2"}}`, `users must be an array of documents`},
+		{`[]`, `reading seed`},
+		{`{"contacts": [{"_id": "c1"}, {"_id": "c1"}]}`, `seeding contacts`},
+	} {
+		err := Seed(ctx, write(tt.content))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("seeding %s = %v, want an error containing %q", tt.content, err, tt.err)
+		}
+	}
+	if err := Seed(ctx, filepath.Join(t.TempDir(), "missing.json")); !os.IsNotExist(err) {
+		t.Errorf("seeding a missing file = %v, want a not-exist error", err)
+	}
+}
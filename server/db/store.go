@@ -0,0 +1,159 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Handlers query and update documents in MongoDB's query language whichever
+// database the server runs on. Each collection is a Store: with the mongodb
+// driver the mongo.Collection itself, with other drivers a documentStore
+// that evaluates filters, updates and pipelines in Go over the documents its
+// database hands it.
+
+// Store holds the documents of one collection. It is the subset of
+// mongo.Collection's methods the server uses.
+type Store interface {
+	Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error)
+	FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult
+	FindOneAndUpdate(ctx context.Context, filter, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult
+	FindOneAndDelete(ctx context.Context, filter interface{}, opts ...*options.FindOneAndDeleteOptions) *mongo.SingleResult
+	CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error)
+	Distinct(ctx context.Context, fieldName string, filter interface{}, opts ...*options.DistinctOptions) ([]interface{}, error)
+	Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error)
+	InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error)
+	InsertMany(ctx context.Context, documents []interface{}, opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error)
+	UpdateOne(ctx context.Context, filter, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+	UpdateMany(ctx context.Context, filter, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+	ReplaceOne(ctx context.Context, filter, replacement interface{}, opts ...*options.ReplaceOptions) (*mongo.UpdateResult, error)
+	DeleteOne(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
+	DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
+}
+
+// indexer is a Store that builds indexes from MongoDB index models
+type indexer interface {
+	createIndex(ctx context.Context, model mongo.IndexModel) error
+}
+
+// errNotConnected is returned by Ping before a database is connected
+var errNotConnected = errors.New("database is not connected")
+
+// Set by the driver's Connect function
+var (
+	pingDB        func(ctx context.Context) error
+	closeDB       func(ctx context.Context) error
+	inTransaction func(ctx context.Context, fn func(ctx context.Context) (interface{}, error)) (interface{}, error)
+)
+
+// collections holds every collection's Store by name
+var collections map[string]Store
+
+// openCollections opens every collection with openStore
+func openCollections(openStore func(name string) Store) {
+	collections = make(map[string]Store)
+	open := func(name string) Store {
+		collections[name] = openStore(name)
+		return collections[name]
+	}
+	tenantCollection := func(name string) *Collection {
+		return &Collection{Store: open(name)}
+	}
+
+	Users = tenantCollection("users")
+	Meetings = tenantCollection("meetings")
+	Participants = tenantCollection("participants")
+	Polls = tenantCollection("polls")
+	Invitations = tenantCollection("invitations")
+	ChatMessages = tenantCollection("chat_messages")
+	AttendanceEvents = tenantCollection("attendance_events")
+	LoginAttempts = open("login_attempts")
+	SecurityEvents = tenantCollection("security_events")
+	Webhooks = tenantCollection("webhooks")
+	WebhookDeliveries = tenantCollection("webhook_deliveries")
+	SlackInstallations = tenantCollection("slack_installations")
+	DeviceTokens = tenantCollection("device_tokens")
+	EmailDeadLetters = open("email_dead_letters")
+	Contacts = tenantCollection("contacts")
+	Organizations = tenantCollection("organizations")
+	OrgMembers = tenantCollection("org_members")
+	OrgInvites = tenantCollection("org_invites")
+	Usage = tenantCollection("usage")
+	TranscriptUtterances = tenantCollection("transcript_utterances")
+	TalkTime = tenantCollection("talk_time")
+	Recordings = tenantCollection("recordings")
+	ChatAttachments = tenantCollection("chat_attachments")
+	ChatReads = tenantCollection("chat_reads")
+	SigningKeys = open("signing_keys")
+	ConnectionQuality = tenantCollection("connection_quality")
+	WhiteboardEvents = tenantCollection("whiteboard_events")
+	WhiteboardSnapshots = tenantCollection("whiteboard_snapshots")
+	MeetingNotes = tenantCollection("meeting_notes")
+	NotesOps = tenantCollection("notes_ops")
+	MeetingEvents = tenantCollection("meeting_events")
+	APIKeys = tenantCollection("api_keys")
+	CORSOrigins = open("cors_origins")
+	DataKeys = open("data_keys")
+	Outbox = tenantCollection("event_outbox")
+	JobLocks = open("job_locks")
+	DailyAnalytics = tenantCollection("analytics_daily")
+	Sessions = tenantCollection("sessions")
+	OverflowQueue = tenantCollection("overflow_queue")
+	MeetingsArchive = tenantCollection("meetings_archive")
+	MeetingHistory = tenantCollection("meeting_history")
+}
+
+// createIndex builds an index on a collection, however its driver indexes
+func createIndex(ctx context.Context, store Store, model mongo.IndexModel) error {
+	if c, ok := store.(*Collection); ok {
+		store = c.Store
+	}
+	switch s := store.(type) {
+	case *mongo.Collection:
+		_, err := s.Indexes().CreateOne(ctx, model)
+		return err
+	case indexer:
+		return s.createIndex(ctx, model)
+	}
+	return nil
+}
+
+// Ping checks that the database is reachable
+func Ping(ctx context.Context) error {
+	if pingDB == nil {
+		return errNotConnected
+	}
+	return pingDB(ctx)
+}
+
+// InTransaction runs fn in a transaction of the PostgreSQL or memory
+// driver. With MongoDB, start a session on Client instead.
+func InTransaction(ctx context.Context, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	if inTransaction == nil {
+		return fn(ctx)
+	}
+	return inTransaction(ctx, fn)
+}
+
+// CloseDB closes the database connection
+func CloseDB() {
+	if closeDB != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := closeDB(ctx); err != nil {
+			log.Printf("Error disconnecting from the database: %v", err)
+		}
+	}
+}
+
+// IsConnected checks if the database connection is alive
+func IsConnected() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return Ping(ctx) == nil
+}
@@ -0,0 +1,197 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// One server can host several isolated tenants. Every document of a tenant
+// carries its tenant ID, and a Collection confines each operation to the
+// tenant in the operation's context: filters match only the tenant's
+// documents and inserted documents are stamped with its ID. The default
+// tenant's documents carry no tenant ID, so data from before tenancy was
+// turned on stays with it.
+//
+// A context without a tenant, such as a background job's, is not confined
+// and sees every tenant's documents; documents it inserts go to the default
+// tenant.
+//
+// Server-wide state, such as signing and data keys, CORS origins, login
+// attempts, undeliverable email and job locks, is kept in plain Stores.
+
+// TenantField is the field holding a document's tenant ID
+const TenantField = "tenantId"
+
+// DefaultTenant is the ID of the tenant whose documents carry none
+const DefaultTenant = ""
+
+type tenantKey struct{}
+
+var errUnscopablePipeline = errors.New("aggregations of tenant data take a mongo.Pipeline")
+
+// WithTenant confines the database operations made with ctx to a tenant
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenantID)
+}
+
+// TenantFromContext returns the tenant ctx is confined to, if any
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenantID, ok := ctx.Value(tenantKey{}).(string)
+	return tenantID, ok
+}
+
+// CopyTenant confines ctx to the tenant of from, if it has one
+func CopyTenant(ctx, from context.Context) context.Context {
+	if tenantID, ok := TenantFromContext(from); ok {
+		return WithTenant(ctx, tenantID)
+	}
+	return ctx
+}
+
+// InTenant reports whether a document of tenantID is visible to ctx
+func InTenant(ctx context.Context, tenantID string) bool {
+	scope, ok := TenantFromContext(ctx)
+	return !ok || scope == tenantID
+}
+
+// tenantFilter matches the documents of a tenant
+func tenantFilter(tenantID string) bson.M {
+	if tenantID == DefaultTenant {
+		return bson.M{TenantField: bson.M{"$exists": false}}
+	}
+	return bson.M{TenantField: tenantID}
+}
+
+// Collection is a collection of tenant data. It has the methods of Store;
+// those taking filters or documents are confined to the context's tenant.
+type Collection struct {
+	Store
+}
+
+// Mongo returns the MongoDB collection holding c, or nil when another
+// driver stores it
+func (c *Collection) Mongo() *mongo.Collection {
+	collection, _ := c.Store.(*mongo.Collection)
+	return collection
+}
+
+// scope adds the context's tenant to a filter. Equality on the tenant ID sits
+// in a top-level $and, so upserts stamp it on the documents they insert.
+func scope(ctx context.Context, filter interface{}) interface{} {
+	tenantID, ok := TenantFromContext(ctx)
+	if !ok {
+		return filter
+	}
+	if filter == nil {
+		return tenantFilter(tenantID)
+	}
+	return bson.M{"$and": bson.A{filter, tenantFilter(tenantID)}}
+}
+
+// stamp sets the context's tenant ID on a document to be inserted
+func stamp(ctx context.Context, document interface{}) (interface{}, error) {
+	tenantID, ok := TenantFromContext(ctx)
+	if !ok || tenantID == DefaultTenant {
+		return document, nil
+	}
+	raw, err := bson.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	elements, err := bson.Raw(raw).Elements()
+	if err != nil {
+		return nil, err
+	}
+	stamped := make(bson.D, 0, len(elements)+1)
+	for _, element := range elements {
+		if element.Key() != TenantField {
+			stamped = append(stamped, bson.E{Key: element.Key(), Value: element.Value()})
+		}
+	}
+	return append(stamped, bson.E{Key: TenantField, Value: tenantID}), nil
+}
+
+func (c *Collection) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	return c.Store.Find(ctx, scope(ctx, filter), opts...)
+}
+
+func (c *Collection) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	return c.Store.FindOne(ctx, scope(ctx, filter), opts...)
+}
+
+func (c *Collection) FindOneAndUpdate(ctx context.Context, filter, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult {
+	return c.Store.FindOneAndUpdate(ctx, scope(ctx, filter), update, opts...)
+}
+
+func (c *Collection) FindOneAndDelete(ctx context.Context, filter interface{}, opts ...*options.FindOneAndDeleteOptions) *mongo.SingleResult {
+	return c.Store.FindOneAndDelete(ctx, scope(ctx, filter), opts...)
+}
+
+func (c *Collection) CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	return c.Store.CountDocuments(ctx, scope(ctx, filter), opts...)
+}
+
+func (c *Collection) Distinct(ctx context.Context, fieldName string, filter interface{}, opts ...*options.DistinctOptions) ([]interface{}, error) {
+	return c.Store.Distinct(ctx, fieldName, scope(ctx, filter), opts...)
+}
+
+// Aggregate starts the pipeline by matching the tenant's documents
+func (c *Collection) Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	tenantID, ok := TenantFromContext(ctx)
+	if !ok {
+		return c.Store.Aggregate(ctx, pipeline, opts...)
+	}
+	stages, ok := pipeline.(mongo.Pipeline)
+	if !ok {
+		return nil, errUnscopablePipeline
+	}
+	scoped := append(mongo.Pipeline{{{Key: "$match", Value: tenantFilter(tenantID)}}}, stages...)
+	return c.Store.Aggregate(ctx, scoped, opts...)
+}
+
+func (c *Collection) InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	stamped, err := stamp(ctx, document)
+	if err != nil {
+		return nil, err
+	}
+	return c.Store.InsertOne(ctx, stamped, opts...)
+}
+
+func (c *Collection) InsertMany(ctx context.Context, documents []interface{}, opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error) {
+	stamped := make([]interface{}, len(documents))
+	for i, document := range documents {
+		var err error
+		if stamped[i], err = stamp(ctx, document); err != nil {
+			return nil, err
+		}
+	}
+	return c.Store.InsertMany(ctx, stamped, opts...)
+}
+
+func (c *Collection) UpdateOne(ctx context.Context, filter, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return c.Store.UpdateOne(ctx, scope(ctx, filter), update, opts...)
+}
+
+func (c *Collection) UpdateMany(ctx context.Context, filter, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return c.Store.UpdateMany(ctx, scope(ctx, filter), update, opts...)
+}
+
+func (c *Collection) ReplaceOne(ctx context.Context, filter, replacement interface{}, opts ...*options.ReplaceOptions) (*mongo.UpdateResult, error) {
+	stamped, err := stamp(ctx, replacement)
+	if err != nil {
+		return nil, err
+	}
+	return c.Store.ReplaceOne(ctx, scope(ctx, filter), stamped, opts...)
+}
+
+func (c *Collection) DeleteOne(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	return c.Store.DeleteOne(ctx, scope(ctx, filter), opts...)
+}
+
+func (c *Collection) DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	return c.Store.DeleteMany(ctx, scope(ctx, filter), opts...)
+}
@@ -0,0 +1,395 @@
+package db
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// errImmutableID is returned by updates that would change a document's _id
+var errImmutableID = fmt.Errorf("performing an update on the path '_id' would modify the immutable field '_id'")
+
+// update is a parsed update: either a document of update operators or an
+// aggregation pipeline
+type update struct {
+	operators bson.D
+	pipeline  []bson.D
+}
+
+// parseUpdate reads an update given as a document of operators such as
+// {$set: {...}} or as a pipeline (mongo.Pipeline, []bson.D or bson.A)
+func parseUpdate(v interface{}) (update, error) {
+	if stages, ok, err := toPipeline(v); ok {
+		return update{pipeline: stages}, err
+	}
+	doc, err := toDocument(v)
+	if err != nil {
+		return update{}, err
+	}
+	if len(doc) == 0 {
+		return update{}, fmt.Errorf("update document must not be empty")
+	}
+	for _, element := range doc {
+		if !strings.HasPrefix(element.Key, "$") {
+			return update{}, fmt.Errorf("update document requires atomic operators")
+		}
+	}
+	return update{operators: doc}, nil
+}
+
+// apply returns doc changed by the update. inserting applies $setOnInsert,
+// for upserts.
+func (u update) apply(doc bson.D, inserting bool) (bson.D, error) {
+	id, hadID := lookupPath(doc, []string{"_id"})
+	var out bson.D
+	var err error
+	if u.pipeline != nil {
+		out, err = runStages(cloneDocument(doc), u.pipeline)
+	} else {
+		out, err = applyOperators(cloneDocument(doc), u.operators, inserting)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if newID, ok := lookupPath(out, []string{"_id"}); hadID && (!ok || compareValues(id, newID) != 0) {
+		return nil, errImmutableID
+	}
+	return out, nil
+}
+
+func applyOperators(doc bson.D, operators bson.D, inserting bool) (bson.D, error) {
+	for _, op := range operators {
+		fields, ok := op.Value.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("%s takes a document", op.Key)
+		}
+		if op.Key == "$setOnInsert" && !inserting {
+			continue
+		}
+		for _, field := range fields {
+			path := splitPath(field.Key)
+			current, exists := lookupPath(doc, path)
+			var err error
+			switch op.Key {
+			case "$set", "$setOnInsert":
+				doc, err = setPath(doc, path, field.Value)
+			case "$unset":
+				doc = unsetPath(doc, path)
+			case "$inc", "$mul":
+				if exists && typeRank(current) != rankNumber {
+					return nil, fmt.Errorf("cannot apply %s to non-numeric field %s", op.Key, field.Key)
+				}
+				if typeRank(field.Value) != rankNumber {
+					return nil, fmt.Errorf("%s of %s takes a number", op.Key, field.Key)
+				}
+				var value interface{}
+				if op.Key == "$inc" {
+					if !exists {
+						current = int32(0)
+					}
+					value = addNumbers(current, field.Value)
+				} else {
+					if !exists {
+						current = int32(0)
+					}
+					value = multiplyNumbers(current, field.Value)
+				}
+				doc, err = setPath(doc, path, value)
+			case "$min", "$max":
+				c := compareValues(field.Value, current)
+				if !exists || (op.Key == "$min" && c < 0) || (op.Key == "$max" && c > 0) {
+					doc, err = setPath(doc, path, field.Value)
+				}
+			case "$currentDate":
+				doc, err = setPath(doc, path, primitive.NewDateTimeFromTime(time.Now()))
+			case "$push", "$addToSet":
+				doc, err = appendToArray(doc, op.Key, path, current, exists, field.Value)
+			case "$pull":
+				if !exists {
+					continue
+				}
+				list, ok := current.(bson.A)
+				if !ok {
+					return nil, fmt.Errorf("cannot apply $pull to non-array field %s", field.Key)
+				}
+				remove, err := compileElementMatch(field.Value)
+				if err != nil {
+					return nil, err
+				}
+				kept := bson.A{}
+				for _, item := range list {
+					if !remove(item) {
+						kept = append(kept, item)
+					}
+				}
+				doc, err = setPath(doc, path, kept)
+				if err != nil {
+					return nil, err
+				}
+			case "$rename":
+				to, ok := field.Value.(string)
+				if !ok {
+					return nil, fmt.Errorf("$rename of %s takes a field name", field.Key)
+				}
+				if exists {
+					doc = unsetPath(doc, path)
+					doc, err = setPath(doc, splitPath(to), current)
+				}
+			default:
+				return nil, fmt.Errorf("unsupported update operator %s", op.Key)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return doc, nil
+}
+
+// appendToArray applies $push or $addToSet, either of one value or of
+// {$each: [...]}; $push also takes $slice
+func appendToArray(doc bson.D, op string, path []string, current interface{}, exists bool, value interface{}) (bson.D, error) {
+	list := bson.A{}
+	if exists {
+		existing, ok := current.(bson.A)
+		if !ok {
+			return nil, fmt.Errorf("cannot apply %s to non-array field %s", op, strings.Join(path, "."))
+		}
+		list = append(list, existing...)
+	}
+
+	items := bson.A{value}
+	slice, sliced := int64(0), false
+	if modifiers, ok := value.(bson.D); ok && isOperatorDocument(modifiers) {
+		items = nil
+		for _, modifier := range modifiers {
+			switch modifier.Key {
+			case "$each":
+				each, ok := modifier.Value.(bson.A)
+				if !ok {
+					return nil, fmt.Errorf("$each takes an array")
+				}
+				items = each
+			case "$slice":
+				if op != "$push" {
+					return nil, fmt.Errorf("$slice is only supported with $push")
+				}
+				if slice, sliced = toInt64(modifier.Value); !sliced {
+					return nil, fmt.Errorf("$slice takes a number")
+				}
+			default:
+				return nil, fmt.Errorf("unsupported %s modifier %s", op, modifier.Key)
+			}
+		}
+	}
+
+	for _, item := range items {
+		if op == "$addToSet" && containsValue(list, item) {
+			continue
+		}
+		list = append(list, item)
+	}
+	if sliced {
+		switch {
+		case slice >= 0 && int64(len(list)) > slice:
+			list = list[:slice]
+		case slice < 0 && int64(len(list)) > -slice:
+			list = list[int64(len(list))+slice:]
+		}
+	}
+	return setPath(doc, path, list)
+}
+
+func containsValue(list bson.A, value interface{}) bool {
+	for _, item := range list {
+		if compareValues(item, value) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// addNumbers adds two numbers, keeping integers integers. int32 results
+// that overflow become int64.
+func addNumbers(a, b interface{}) interface{} {
+	return arithmetic(a, b, func(x, y int64) (int64, bool) {
+		sum := x + y
+		return sum, (sum > x) == (y > 0)
+	}, func(x, y float64) float64 { return x + y })
+}
+
+func multiplyNumbers(a, b interface{}) interface{} {
+	return arithmetic(a, b, func(x, y int64) (int64, bool) {
+		if x == 0 || y == 0 {
+			return 0, true
+		}
+		product := x * y
+		return product, product/y == x
+	}, func(x, y float64) float64 { return x * y })
+}
+
+func arithmetic(a, b interface{}, ints func(x, y int64) (int64, bool), floats func(x, y float64) float64) interface{} {
+	_, aFloat := a.(float64)
+	_, bFloat := b.(float64)
+	ia, aInt := toInt64(a)
+	ib, bInt := toInt64(b)
+	if !aFloat && !bFloat && aInt && bInt {
+		if result, ok := ints(ia, ib); ok {
+			_, a32 := a.(int32)
+			_, b32 := b.(int32)
+			if a32 && b32 && result >= math.MinInt32 && result <= math.MaxInt32 {
+				return int32(result)
+			}
+			return result
+		}
+	}
+	fa, _ := toFloat64(a)
+	fb, _ := toFloat64(b)
+	return floats(fa, fb)
+}
+
+// setPath sets the value at a dotted path, creating documents along the way
+func setPath(doc bson.D, path []string, value interface{}) (bson.D, error) {
+	out, err := setIn(doc, path, value)
+	if err != nil {
+		return nil, err
+	}
+	return out.(bson.D), nil
+}
+
+func setIn(container interface{}, path []string, value interface{}) (interface{}, error) {
+	switch c := container.(type) {
+	case bson.D:
+		for i, element := range c {
+			if element.Key != path[0] {
+				continue
+			}
+			if len(path) == 1 {
+				c[i].Value = value
+				return c, nil
+			}
+			child := element.Value
+			if child == nil {
+				child = bson.D{}
+			}
+			updated, err := setIn(child, path[1:], value)
+			if err != nil {
+				return nil, err
+			}
+			c[i].Value = updated
+			return c, nil
+		}
+		if len(path) == 1 {
+			return append(c, bson.E{Key: path[0], Value: value}), nil
+		}
+		child, err := setIn(bson.D{}, path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		return append(c, bson.E{Key: path[0], Value: child}), nil
+	case bson.A:
+		index, err := strconv.Atoi(path[0])
+		if err != nil || index < 0 {
+			return nil, fmt.Errorf("cannot create field %s in an array", path[0])
+		}
+		for len(c) <= index {
+			c = append(c, nil)
+		}
+		if len(path) == 1 {
+			c[index] = value
+			return c, nil
+		}
+		child := c[index]
+		if child == nil {
+			child = bson.D{}
+		}
+		updated, err := setIn(child, path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		c[index] = updated
+		return c, nil
+	}
+	return nil, fmt.Errorf("cannot create field %s in a %T", path[0], container)
+}
+
+// unsetPath removes the field at a dotted path. Array elements are set to
+// null rather than removed, as in MongoDB.
+func unsetPath(doc bson.D, path []string) bson.D {
+	return unsetIn(doc, path).(bson.D)
+}
+
+func unsetIn(container interface{}, path []string) interface{} {
+	switch c := container.(type) {
+	case bson.D:
+		for i, element := range c {
+			if element.Key != path[0] {
+				continue
+			}
+			if len(path) == 1 {
+				return append(c[:i:i], c[i+1:]...)
+			}
+			c[i].Value = unsetIn(element.Value, path[1:])
+			return c
+		}
+	case bson.A:
+		index, err := strconv.Atoi(path[0])
+		if err != nil || index < 0 || index >= len(c) {
+			return c
+		}
+		if len(path) == 1 {
+			c[index] = nil
+		} else {
+			c[index] = unsetIn(c[index], path[1:])
+		}
+		return c
+	}
+	return container
+}
+
+// upsertDocument starts the document an upsert inserts with the equality
+// conditions of its filter, including those under a top-level $and
+func upsertDocument(filter bson.D) (bson.D, error) {
+	doc := bson.D{}
+	var collect func(filter bson.D) error
+	collect = func(filter bson.D) error {
+		for _, element := range filter {
+			if element.Key == "$and" {
+				list, _ := element.Value.(bson.A)
+				for _, item := range list {
+					if sub, ok := item.(bson.D); ok {
+						if err := collect(sub); err != nil {
+							return err
+						}
+					}
+				}
+				continue
+			}
+			if strings.HasPrefix(element.Key, "$") {
+				continue
+			}
+			value := element.Value
+			if isOperatorDocument(value) {
+				eq, ok := lookupPath(value.(bson.D), []string{"$eq"})
+				if !ok {
+					continue
+				}
+				value = eq
+			}
+			if _, ok := value.(primitive.Regex); ok {
+				continue
+			}
+			var err error
+			if doc, err = setPath(doc, splitPath(element.Key), cloneValue(value)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return doc, collect(filter)
+}
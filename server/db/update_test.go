@@ -0,0 +1,220 @@
+package db
+
+import (
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestApplyUpdate(t *testing.T) {
+	tests := []struct {
+		name   string
+		doc    string
+		update string
+		want   string
+	}{
+		{"$set new field", `{"_id": 1}`, `{"$set": {"a": 1}}`, `{"_id": 1, "a": 1}`},
+		{"$set existing field in place", `{"_id": 1, "a": 1, "b": 2}`, `{"$set": {"a": "x"}}`, `{"_id": 1, "a": "x", "b": 2}`},
+		{"$set creates documents", `{"_id": 1}`, `{"$set": {"a.b.c": 1}}`, `{"_id": 1, "a": {"b": {"c": 1}}}`},
+		{"$set in document", `{"_id": 1, "a": {"b": 1, "c": 2}}`, `{"$set": {"a.c": 3}}`, `{"_id": 1, "a": {"b": 1, "c": 3}}`},
+		{"$set array index", `{"_id": 1, "a": [1, 2, 3]}`, `{"$set": {"a.1": 9}}`, `{"_id": 1, "a": [1, 9, 3]}`},
+		{"$set past array end pads with null", `{"_id": 1, "a": [1]}`, `{"$set": {"a.2": 3}}`, `{"_id": 1, "a": [1, null, 3]}`},
+		{"$set same _id", `{"_id": 1, "a": 1}`, `{"$set": {"_id": 1}}`, `{"_id": 1, "a": 1}`},
+
+		{"$unset", `{"_id": 1, "a": 1, "b": 2}`, `{"$unset": {"a": ""}}`, `{"_id": 1, "b": 2}`},
+		{"$unset missing", `{"_id": 1}`, `{"$unset": {"a": ""}}`, `{"_id": 1}`},
+		{"$unset dotted", `{"_id": 1, "a": {"b": 1, "c": 2}}`, `{"$unset": {"a.b": ""}}`, `{"_id": 1, "a": {"c": 2}}`},
+		{"$unset array element leaves null", `{"_id": 1, "a": [1, 2]}`, `{"$unset": {"a.0": ""}}`, `{"_id": 1, "a": [null, 2]}`},
+
+		{"$inc", `{"_id": 1, "n": 1}`, `{"$inc": {"n": 2}}`, `{"_id": 1, "n": 3}`},
+		{"$inc missing", `{"_id": 1}`, `{"$inc": {"n": 2}}`, `{"_id": 1, "n": 2}`},
+		{"$inc negative", `{"_id": 1, "n": 1}`, `{"$inc": {"n": -3}}`, `{"_id": 1, "n": -2}`},
+		{"$inc int by long", `{"_id": 1, "n": 1}`, `{"$inc": {"n": {"$numberLong": "2"}}}`, `{"_id": 1, "n": {"$numberLong": "3"}}`},
+		{"$inc int by double", `{"_id": 1, "n": 1}`, `{"$inc": {"n": 0.5}}`, `{"_id": 1, "n": 1.5}`},
+		{"$inc int overflows to long", `{"_id": 1, "n": 2147483647}`, `{"$inc": {"n": 1}}`, `{"_id": 1, "n": {"$numberLong": "2147483648"}}`},
+		{"$inc dotted", `{"_id": 1, "a": {"n": 1}}`, `{"$inc": {"a.n": 1}}`, `{"_id": 1, "a": {"n": 2}}`},
+		{"$mul", `{"_id": 1, "n": 3}`, `{"$mul": {"n": 2}}`, `{"_id": 1, "n": 6}`},
+		{"$mul missing", `{"_id": 1}`, `{"$mul": {"n": 2}}`, `{"_id": 1, "n": 0}`},
+		{"$mul by double", `{"_id": 1, "n": 3}`, `{"$mul": {"n": 0.5}}`, `{"_id": 1, "n": 1.5}`},
+
+		{"$min lower", `{"_id": 1, "n": 5}`, `{"$min": {"n": 3}}`, `{"_id": 1, "n": 3}`},
+		{"$min higher", `{"_id": 1, "n": 5}`, `{"$min": {"n": 7}}`, `{"_id": 1, "n": 5}`},
+		{"$min missing", `{"_id": 1}`, `{"$min": {"n": 7}}`, `{"_id": 1, "n": 7}`},
+		{"$max higher", `{"_id": 1, "n": 5}`, `{"$max": {"n": 7}}`, `{"_id": 1, "n": 7}`},
+		{"$max lower", `{"_id": 1, "n": 5}`, `{"$max": {"n": 3}}`, `{"_id": 1, "n": 5}`},
+		{"$max dates", `{"_id": 1, "d": {"$date": "2026-01-01T00:00:00Z"}}`, `{"$max": {"d": {"$date": "2026-02-01T00:00:00Z"}}}`, `{"_id": 1, "d": {"$date": "2026-02-01T00:00:00Z"}}`},
+
+		{"$push", `{"_id": 1, "a": [1]}`, `{"$push": {"a": 2}}`, `{"_id": 1, "a": [1, 2]}`},
+		{"$push missing", `{"_id": 1}`, `{"$push": {"a": 2}}`, `{"_id": 1, "a": [2]}`},
+		{"$push array as one element", `{"_id": 1, "a": [1]}`, `{"$push": {"a": [2, 3]}}`, `{"_id": 1, "a": [1, [2, 3]]}`},
+		{"$push duplicate", `{"_id": 1, "a": [1]}`, `{"$push": {"a": 1}}`, `{"_id": 1, "a": [1, 1]}`},
+		{"$push $each", `{"_id": 1, "a": [1]}`, `{"$push": {"a": {"$each": [2, 3]}}}`, `{"_id": 1, "a": [1, 2, 3]}`},
+		{"$push $slice keeps the first", `{"_id": 1, "a": [1, 2]}`, `{"$push": {"a": {"$each": [3], "$slice": 2}}}`, `{"_id": 1, "a": [1, 2]}`},
+		{"$push $slice keeps the last", `{"_id": 1, "a": [1, 2]}`, `{"$push": {"a": {"$each": [3], "$slice": -2}}}`, `{"_id": 1, "a": [2, 3]}`},
+		{"$push $slice 0", `{"_id": 1, "a": [1, 2]}`, `{"$push": {"a": {"$each": [3], "$slice": 0}}}`, `{"_id": 1, "a": []}`},
+		{"$push document", `{"_id": 1}`, `{"$push": {"a": {"b": 1}}}`, `{"_id": 1, "a": [{"b": 1}]}`},
+		{"$addToSet", `{"_id": 1, "a": [1]}`, `{"$addToSet": {"a": 2}}`, `{"_id": 1, "a": [1, 2]}`},
+		{"$addToSet present", `{"_id": 1, "a": [1, 2]}`, `{"$addToSet": {"a": 2}}`, `{"_id": 1, "a": [1, 2]}`},
+		{"$addToSet number of another type", `{"_id": 1, "a": [1]}`, `{"$addToSet": {"a": 1.0}}`, `{"_id": 1, "a": [1]}`},
+		{"$addToSet $each", `{"_id": 1, "a": [1]}`, `{"$addToSet": {"a": {"$each": [1, 2, 2]}}}`, `{"_id": 1, "a": [1, 2]}`},
+
+		{"$pull value", `{"_id": 1, "a": [1, 2, 1]}`, `{"$pull": {"a": 1}}`, `{"_id": 1, "a": [2]}`},
+		{"$pull condition", `{"_id": 1, "a": [1, 5, 3]}`, `{"$pull": {"a": {"$gte": 3}}}`, `{"_id": 1, "a": [1]}`},
+		{"$pull document query", `{"_id": 1, "a": [{"b": 1, "c": 1}, {"b": 2}]}`, `{"$pull": {"a": {"b": 1}}}`, `{"_id": 1, "a": [{"b": 2}]}`},
+		{"$pull $in", `{"_id": 1, "a": ["x", "y", "z"]}`, `{"$pull": {"a": {"$in": ["x", "z"]}}}`, `{"_id": 1, "a": ["y"]}`},
+		{"$pull missing", `{"_id": 1}`, `{"$pull": {"a": 1}}`, `{"_id": 1}`},
+
+		{"$rename", `{"_id": 1, "a": 1, "b": 2}`, `{"$rename": {"a": "c"}}`, `{"_id": 1, "b": 2, "c": 1}`},
+		{"$rename missing", `{"_id": 1, "b": 2}`, `{"$rename": {"a": "c"}}`, `{"_id": 1, "b": 2}`},
+		{"$rename into document", `{"_id": 1, "a": 1}`, `{"$rename": {"a": "b.c"}}`, `{"_id": 1, "b": {"c": 1}}`},
+
+		{"$setOnInsert when updating", `{"_id": 1}`, `{"$setOnInsert": {"a": 1}}`, `{"_id": 1}`},
+		{"operators in order", `{"_id": 1, "n": 1}`, `{"$inc": {"n": 1}, "$set": {"m": 2}, "$unset": {"x": ""}}`, `{"_id": 1, "n": 2, "m": 2}`},
+
+		{"pipeline", `{"_id": 1, "a": 1, "b": 2}`, `[{"$set": {"sum": {"$add": ["$a", "$b"]}}}, {"$unset": "a"}]`, `{"_id": 1, "b": 2, "sum": 3}`},
+		{"pipeline from the old values", `{"_id": 1, "a": 1}`, `[{"$set": {"a": 2, "b": "$a"}}]`, `{"_id": 1, "a": 2, "b": 1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := extJSON(t, `{"u": `+tt.update+`}`)[0].Value
+			u, err := parseUpdate(spec)
+			if err != nil {
+				t.Fatalf("parseUpdate(%s): %v", tt.update, err)
+			}
+			doc := extJSON(t, tt.doc)
+			before := canonical(t, doc)
+			got, err := u.apply(doc, false)
+			if err != nil {
+				t.Fatalf("applying %s to %s: %v", tt.update, tt.doc, err)
+			}
+			if canonical(t, got) != canonical(t, extJSON(t, tt.want)) {
+				t.Errorf("applying %s to %s = %s, want %s", tt.update, tt.doc, canonical(t, got), tt.want)
+			}
+			if canonical(t, doc) != before {
+				t.Errorf("applying %s changed the original document to %s", tt.update, canonical(t, doc))
+			}
+		})
+	}
+}
+
+func TestApplyUpdateSetOnInsert(t *testing.T) {
+	u, err := parseUpdate(extJSON(t, `{"$set": {"a": 1}, "$setOnInsert": {"b": 2}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := u.apply(extJSON(t, `{"_id": 1}`), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"_id": 1, "a": 1, "b": 2}`; canonical(t, got) != canonical(t, extJSON(t, want)) {
+		t.Errorf("inserting = %s, want %s", canonical(t, got), want)
+	}
+}
+
+func TestApplyUpdateCurrentDate(t *testing.T) {
+	u, err := parseUpdate(extJSON(t, `{"$currentDate": {"at": true}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := u.apply(extJSON(t, `{"_id": 1}`), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at, _ := lookupPath(got, []string{"at"})
+	if _, ok := at.(primitive.DateTime); !ok {
+		t.Errorf("$currentDate set at to %T, want a date", at)
+	}
+}
+
+func TestApplyUpdateErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		doc    string
+		update string
+	}{
+		{"$inc of a string", `{"_id": 1, "n": "1"}`, `{"$inc": {"n": 1}}`},
+		{"$inc by a string", `{"_id": 1, "n": 1}`, `{"$inc": {"n": "1"}}`},
+		{"$push to a non-array", `{"_id": 1, "a": 1}`, `{"$push": {"a": 2}}`},
+		{"$pull from a non-array", `{"_id": 1, "a": 1}`, `{"$pull": {"a": 1}}`},
+		{"$slice with $addToSet", `{"_id": 1}`, `{"$addToSet": {"a": {"$each": [1], "$slice": 1}}}`},
+		{"$set inside a scalar", `{"_id": 1, "a": 1}`, `{"$set": {"a.b": 1}}`},
+		{"$set field of an array", `{"_id": 1, "a": [1]}`, `{"$set": {"a.b": 1}}`},
+		{"unknown operator", `{"_id": 1}`, `{"$bit": {"a": {"and": 1}}}`},
+		{"operator without a document", `{"_id": 1}`, `{"$set": 1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := parseUpdate(extJSON(t, tt.update))
+			if err == nil {
+				_, err = u.apply(extJSON(t, tt.doc), false)
+			}
+			if err == nil {
+				t.Errorf("applying %s to %s succeeded, want an error", tt.update, tt.doc)
+			}
+		})
+	}
+
+	for _, update := range []string{`{"$set": {"_id": 2}}`, `{"$unset": {"_id": ""}}`, `{"$rename": {"_id": "id"}}`} {
+		u, err := parseUpdate(extJSON(t, update))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := u.apply(extJSON(t, `{"_id": 1}`), false); !errors.Is(err, errImmutableID) {
+			t.Errorf("applying %s = %v, want errImmutableID", update, err)
+		}
+	}
+}
+
+func TestParseUpdate(t *testing.T) {
+	for _, update := range []interface{}{
+		bson.D{},
+		nil,
+		bson.D{{Key: "a", Value: 1}},
+		bson.M{"$set": bson.M{"a": 1}, "b": 1},
+	} {
+		if _, err := parseUpdate(update); err == nil {
+			t.Errorf("parseUpdate(%v) succeeded, want an error", update)
+		}
+	}
+
+	u, err := parseUpdate([]bson.D{{{Key: "$set", Value: bson.D{{Key: "a", Value: 1}}}}})
+	if err != nil || len(u.pipeline) != 1 {
+		t.Errorf("parseUpdate(pipeline) = %v, %v, want one stage", u, err)
+	}
+	if _, err := parseUpdate(bson.A{bson.D{{Key: "$match", Value: bson.D{}}}}); err != nil {
+		t.Fatal(err)
+	}
+	u, _ = parseUpdate(bson.A{bson.D{{Key: "$match", Value: bson.D{}}}})
+	if _, err := u.apply(bson.D{{Key: "_id", Value: 1}}, false); err == nil {
+		t.Error("$match in an update pipeline succeeded, want an error")
+	}
+}
+
+func TestUpsertDocument(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   string
+	}{
+		{`{}`, `{}`},
+		{`{"a": 1}`, `{"a": 1}`},
+		{`{"a.b": 1}`, `{"a": {"b": 1}}`},
+		{`{"a": {"$eq": 1}}`, `{"a": 1}`},
+		{`{"a": {"$gt": 1}}`, `{}`},
+		{`{"a": {"$in": [1]}}`, `{}`},
+		{`{"a": {"$regularExpression": {"pattern": "x", "options": ""}}}`, `{}`},
+		{`{"$and": [{"a": 1}, {"b": 2}]}`, `{"a": 1, "b": 2}`},
+		{`{"$or": [{"a": 1}]}`, `{}`},
+		{`{"_id": "x", "n": {"$lt": 5}, "k": "v"}`, `{"_id": "x", "k": "v"}`},
+	}
+	for _, tt := range tests {
+		got, err := upsertDocument(extJSON(t, tt.filter))
+		if err != nil {
+			t.Fatalf("upsertDocument(%s): %v", tt.filter, err)
+		}
+		if canonical(t, got) != canonical(t, extJSON(t, tt.want)) {
+			t.Errorf("upsertDocument(%s) = %s, want %s", tt.filter, canonical(t, got), tt.want)
+		}
+	}
+}
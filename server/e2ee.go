@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// In an end-to-end encrypted meeting, clients encrypt each encoded frame with
+// a media key of their own (SFrame through insertable streams) before it is
+// packetized, so the SFU only ever forwards ciphertext. The server's part is
+// relaying the key exchange, which it cannot read either:
+//
+//   - e2ee-public-key: a peer announces its public key to the meeting
+//   - e2ee-key: a peer sends its media key, encrypted to the recipient's
+//     public key, to one peer; keyId is the key's generation
+//   - e2ee-key-request: a peer asks another to (re)send its media key
+//
+// Peers rotate their media key when someone leaves, so the leaver can't
+// decrypt what follows. Clients must leave the codec's frame header in the
+// clear (the first bytes of a VP8 frame, H.264 NAL headers), as the SFU
+// reads it to find key frames when switching simulcast layers.
+
+// MaxE2EEKeySize bounds the public and encrypted keys relayed, in base64
+const MaxE2EEKeySize = 2048
+
+type e2eePublicKeyData struct {
+	PublicKey  string `json:"publicKey"`
+	FromPeerID string `json:"fromPeerId,omitempty"`
+}
+
+type e2eeKeyData struct {
+	ToPeerID     string `json:"toPeerId"`
+	FromPeerID   string `json:"fromPeerId,omitempty"`
+	KeyID        int    `json:"keyId"`
+	EncryptedKey string `json:"encryptedKey"`
+	// The sender's public key, so a late joiner can decrypt without
+	// waiting for the sender's announcement
+	PublicKey string `json:"publicKey,omitempty"`
+}
+
+type e2eeKeyRequestData struct {
+	ToPeerID   string `json:"toPeerId"`
+	FromPeerID string `json:"fromPeerId,omitempty"`
+}
+
+// requireE2EEMeeting reports whether the client's meeting is end-to-end
+// encrypted, telling the client if not; key messages are only relayed there
+func requireE2EEMeeting(ctx context.Context, c *Client) bool {
+	meeting, err := findMeeting(ctx, c.meetingID)
+	if err != nil {
+		c.sendError("Meeting not found")
+		return false
+	}
+	if !meeting.E2EE {
+		c.sendError("This meeting is not end-to-end encrypted")
+		return false
+	}
+	return true
+}
+
+// handleE2EEPublicKey announces the sender's public key to the rest of the
+// meeting
+func handleE2EEPublicKey(ctx context.Context, c *Client, data json.RawMessage) {
+	var announcement e2eePublicKeyData
+	if err := json.Unmarshal(data, &announcement); err != nil || announcement.PublicKey == "" ||
+		len(announcement.PublicKey) > MaxE2EEKeySize {
+		c.sendError("Invalid public key")
+		return
+	}
+	if !requireE2EEMeeting(ctx, c) {
+		return
+	}
+
+	// The server is authoritative about who is sending
+	announcement.FromPeerID = c.peerID
+	hub.BroadcastToMeeting(c.meetingID, WebSocketMessage{
+		Type:   "e2ee-public-key",
+		Data:   announcement,
+		UserID: c.userID,
+	}, c)
+}
+
+// handleE2EEKey relays an encrypted media key to its recipient
+func handleE2EEKey(ctx context.Context, c *Client, data json.RawMessage) {
+	var key e2eeKeyData
+	if err := json.Unmarshal(data, &key); err != nil || key.ToPeerID == "" || key.EncryptedKey == "" ||
+		len(key.EncryptedKey) > MaxE2EEKeySize || len(key.PublicKey) > MaxE2EEKeySize || key.KeyID < 0 {
+		c.sendError("Invalid key message")
+		return
+	}
+	if !requireE2EEMeeting(ctx, c) {
+		return
+	}
+
+	key.FromPeerID = c.peerID
+	hub.SendToPeer(c.meetingID, key.ToPeerID, WebSocketMessage{
+		Type:   "e2ee-key",
+		Data:   key,
+		UserID: c.userID,
+	})
+}
+
+// handleE2EEKeyRequest asks a peer for its current media key, e.g. after
+// the sender failed to decrypt one of its frames
+func handleE2EEKeyRequest(ctx context.Context, c *Client, data json.RawMessage) {
+	var request e2eeKeyRequestData
+	if err := json.Unmarshal(data, &request); err != nil || request.ToPeerID == "" {
+		c.sendError("Invalid key request")
+		return
+	}
+	if !requireE2EEMeeting(ctx, c) {
+		return
+	}
+
+	request.FromPeerID = c.peerID
+	hub.SendToPeer(c.meetingID, request.ToPeerID, WebSocketMessage{
+		Type:   "e2ee-key-request",
+		Data:   request,
+		UserID: c.userID,
+	})
+}
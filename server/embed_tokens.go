@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+)
+
+// Partner sites embed the meeting client in an iframe, where the user has no
+// session of ours and third-party cookies are blocked. Instead the meeting's
+// host, usually the partner's service account, mints an embed token: a
+// short-lived JWT that lets one guest into one meeting, and nothing else.
+
+const (
+	EmbedTokenAudience    = "embed"
+	EmbedGuestPrefix      = "embed_"
+	MaxEmbedGuestNameSize = 100
+)
+
+// embedTokenHeader is the JOSE header of every embed token; tokens are
+// signed with the link signing keys
+var embedTokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// embedTokenRoutes are the routes an embed token can use: joining, taking
+// part in and leaving its meeting
+var embedTokenRoutes = map[string]bool{
+	"GET /api/meetings/{id}":                    true,
+	"POST /api/meetings/{id}/join":              true,
+	"GET /api/meetings/{id}/participants":       true,
+	"PUT /api/meetings/{id}/participants":       true,
+	"PATCH /api/meetings/{id}/participants":     true,
+	"DELETE /api/meetings/{id}/participants/me": true,
+	"POST /api/meetings/{id}/heartbeat":         true,
+	"GET /api/meetings/{id}/chat":               true,
+	"GET /api/meetings/{id}/whiteboard":         true,
+	"GET /api/meetings/{id}/notes":              true,
+	"GET /api/meetings/{id}/polls":              true,
+	"GET /api/ws/{meetingId}":                   true,
+	"GET /api/sse/{meetingId}":                  true,
+	"POST /api/sse/{meetingId}/{sessionId}":     true,
+}
+
+// EmbedClaims are the claims of an embed token. The subject is a guest ID
+// made for the token, never a real user.
+type EmbedClaims struct {
+	ID        string `json:"jti"`
+	Subject   string `json:"sub"`
+	Audience  string `json:"aud"`
+	MeetingID string `json:"mid"`
+	Name      string `json:"name"`
+	// The partner page the token was minted for
+	Origin    string `json:"origin"`
+	IssuedBy  string `json:"iss"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+type embedTokenContextKey struct{}
+
+// requestEmbedToken returns the claims of the embed token that authenticated
+// the request, if any
+func requestEmbedToken(r *http.Request) *EmbedClaims {
+	claims, _ := r.Context().Value(embedTokenContextKey{}).(*EmbedClaims)
+	return claims
+}
+
+// mintEmbedToken signs claims as a JWT
+func mintEmbedToken(claims EmbedClaims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := embedTokenHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + signValue(signed), nil
+}
+
+// parseEmbedToken verifies an embed token's signature and returns its
+// claims; whether it has expired is up to the caller
+func parseEmbedToken(token string) (*EmbedClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != embedTokenHeader {
+		return nil, errors.New("malformed embed token")
+	}
+	if !verifySignature(parts[0]+"."+parts[1], parts[2]) {
+		return nil, errors.New("invalid embed token signature")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("malformed embed token")
+	}
+	var claims EmbedClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.New("malformed embed token")
+	}
+	if claims.Audience != EmbedTokenAudience || claims.MeetingID == "" ||
+		!strings.HasPrefix(claims.Subject, EmbedGuestPrefix) {
+		return nil, errors.New("not an embed token")
+	}
+	return &claims, nil
+}
+
+// embedTokenPresented returns the embed token sent as a bearer token or, for
+// connections that cannot set headers, as ?embed_token=
+func embedTokenPresented(r *http.Request) string {
+	if token := r.URL.Query().Get("embed_token"); token != "" {
+		return token
+	}
+	presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || strings.HasPrefix(presented, APIKeyPrefix) {
+		return ""
+	}
+	return presented
+}
+
+// isEmbeddingDomain reports whether host matches one of the configured
+// embed domains; *.partner.com matches its subdomains only
+func isEmbeddingDomain(host string) bool {
+	host = strings.ToLower(host)
+	for _, domain := range appConfig.Embed.AllowedDomains {
+		domain = strings.ToLower(domain)
+		if parent, ok := strings.CutPrefix(domain, "*."); ok {
+			if strings.HasSuffix(host, "."+parent) {
+				return true
+			}
+		} else if host == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// embedGuestPresent reports whether the guest is in the meeting and hasn't left
+func embedGuestPresent(ctx context.Context, meetingID, guestID string) bool {
+	count, err := db.Participants.CountDocuments(ctx,
+		bson.M{"meetingId": meetingID, "userId": guestID, "leftAt": bson.M{"$exists": false}},
+		options.Count().SetLimit(1))
+	return err == nil && count > 0
+}
+
+// embedTokenMiddleware authenticates requests bearing an embed token and
+// holds them to the token's meeting and embedTokenRoutes. A token can only be
+// used to join until it expires, but keeps working for a guest who joined
+// until they leave, so embedded meetings aren't cut short.
+func embedTokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented := embedTokenPresented(r)
+		if presented == "" || requestAPIKey(r) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		claims, err := parseEmbedToken(presented)
+		if err != nil {
+			sendErrorResponse(w, "Invalid embed token", http.StatusUnauthorized)
+			return
+		}
+
+		template := ""
+		if route := mux.CurrentRoute(r); route != nil {
+			template, _ = route.GetPathTemplate()
+		}
+		operation := r.Method + " " + template
+		if !embedTokenRoutes[operation] {
+			sendCodedError(w, ErrCodeForbidden, "Embed tokens cannot use this endpoint")
+			return
+		}
+
+		vars := mux.Vars(r)
+		routeMeeting := vars["id"]
+		if routeMeeting == "" {
+			routeMeeting = vars["meetingId"]
+		}
+		if routeMeeting != claims.MeetingID {
+			// The route may carry the meeting's code
+			meeting, err := findMeeting(r.Context(), routeMeeting)
+			if err != nil || meeting.ID != claims.MeetingID {
+				sendCodedError(w, ErrCodeForbidden, "This embed token is for another meeting")
+				return
+			}
+		}
+
+		expired := time.Now().Unix() >= claims.ExpiresAt
+		if expired && (operation == "POST /api/meetings/{id}/join" ||
+			!embedGuestPresent(r.Context(), claims.MeetingID, claims.Subject)) {
+			sendErrorResponse(w, "Embed token has expired", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), embedTokenContextKey{}, claims)))
+	})
+}
+
+type createEmbedTokenRequest struct {
+	// The guest's display name in the meeting
+	Name string `json:"name"`
+	// The partner page embedding the meeting, e.g. https://app.partner.com
+	Origin string `json:"origin"`
+}
+
+type embedTokenResponse struct {
+	Token     string    `json:"token"`
+	GuestID   string    `json:"guestId"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// createEmbedTokenHandler mints an embed token for one guest of the host's
+// meeting, for a page on one of the configured embed domains
+func createEmbedTokenHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if len(appConfig.Embed.AllowedDomains) == 0 {
+		sendCodedError(w, ErrCodeForbidden, "Embedding is not enabled")
+		return
+	}
+
+	meeting, err := findMeeting(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+	if meeting.CreatedBy != userID {
+		sendCodedError(w, ErrCodeHostOnly, "Only the host can create embed tokens")
+		return
+	}
+
+	var req createEmbedTokenRequest
+	if !decodeStrictJSONBody(w, r, &req) {
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len([]rune(name)) > MaxEmbedGuestNameSize {
+		sendErrorResponse(w, "Name must be between 1 and 100 characters", http.StatusBadRequest)
+		return
+	}
+	origin, err := url.Parse(req.Origin)
+	if err != nil || origin.Scheme != "https" || origin.Host == "" || strings.Trim(origin.Path, "/") != "" {
+		sendErrorResponse(w, "origin must be an https scheme and host, e.g. https://partner.com", http.StatusBadRequest)
+		return
+	}
+	if !isEmbeddingDomain(origin.Hostname()) {
+		sendCodedError(w, ErrCodeForbidden, "This site is not allowed to embed meetings")
+		return
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(time.Duration(appConfig.Embed.TokenTTL))
+	id := uuid.New().String()
+	claims := EmbedClaims{
+		ID:        id,
+		Subject:   EmbedGuestPrefix + id,
+		Audience:  EmbedTokenAudience,
+		MeetingID: meeting.ID,
+		Name:      name,
+		Origin:    origin.Scheme + "://" + origin.Host,
+		IssuedBy:  userID,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expiresAt.Unix(),
+	}
+	token, err := mintEmbedToken(claims)
+	if err != nil {
+		log.Printf("Error minting embed token for meeting %s: %v", meeting.ID, err)
+		sendErrorResponse(w, "Failed to create embed token", http.StatusInternalServerError)
+		return
+	}
+
+	sendSuccessResponse(w, embedTokenResponse{
+		Token:     token,
+		GuestID:   claims.Subject,
+		URL:       meetingJoinURL(meeting) + "?embed_token=" + url.QueryEscape(token),
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0).UTC(),
+	})
+}
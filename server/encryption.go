@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/config"
+	"video-meeting-app/db"
+)
+
+// Chat messages and transcripts are encrypted at rest with envelope
+// encryption: text is sealed with AES-256-GCM under a data key, and data keys
+// are stored wrapped by the master key, which stays in the environment or in
+// Vault. Sealed text is stored as enc:v1:<data key ID>:<base64 nonce and
+// ciphertext>, bound to its meeting; text without the prefix, from before
+// encryption was turned on, is read as is.
+
+const (
+	SealedTextPrefix        = "enc:v1:"
+	DataKeysRefreshInterval = time.Minute
+	DataKeyLoadTimeout      = 5 * time.Second
+	VaultHTTPTimeout        = 10 * time.Second
+	dataKeySize             = 32
+)
+
+// KeyWrapper encrypts and decrypts data keys with the master key
+type KeyWrapper interface {
+	Name() string
+	Wrap(ctx context.Context, key []byte) (string, error)
+	Unwrap(ctx context.Context, wrapped string) ([]byte, error)
+}
+
+// keyWrapper is the configured master key; nil when encryption is off
+var keyWrapper KeyWrapper
+
+// newKeyWrapper returns the configured provider's key wrapper
+func newKeyWrapper(cfg config.EncryptionConfig) (KeyWrapper, error) {
+	switch cfg.Provider {
+	case config.EncryptionProviderEnv:
+		key, err := base64.StdEncoding.DecodeString(cfg.MasterKey)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := newAESGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		return &localKeyWrapper{aead: aead}, nil
+	case config.EncryptionProviderVault:
+		return &vaultKeyWrapper{
+			address: strings.TrimSuffix(cfg.VaultAddress, "/"),
+			token:   cfg.VaultToken,
+			keyName: cfg.VaultKey,
+			client:  &http.Client{Timeout: VaultHTTPTimeout},
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown encryption provider %q", cfg.Provider)
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealBytes encrypts plaintext with a random nonce, which it prepends
+func sealBytes(aead cipher.AEAD, plaintext, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+func openBytes(aead cipher.AEAD, sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed value is too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additional)
+}
+
+// localKeyWrapper wraps data keys with a master key from the configuration
+type localKeyWrapper struct {
+	aead cipher.AEAD
+}
+
+func (w *localKeyWrapper) Name() string { return config.EncryptionProviderEnv }
+
+func (w *localKeyWrapper) Wrap(ctx context.Context, key []byte) (string, error) {
+	sealed, err := sealBytes(w.aead, key, nil)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (w *localKeyWrapper) Unwrap(ctx context.Context, wrapped string) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	return openBytes(w.aead, sealed, nil)
+}
+
+// vaultKeyWrapper wraps data keys with a key in Vault's transit engine
+type vaultKeyWrapper struct {
+	address string
+	token   string
+	keyName string
+	client  *http.Client
+}
+
+func (w *vaultKeyWrapper) Name() string { return config.EncryptionProviderVault }
+
+// transit posts to the transit engine's encrypt or decrypt endpoint and
+// returns the response's data
+func (w *vaultKeyWrapper) transit(ctx context.Context, operation string, body map[string]string) (map[string]string, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		w.address+"/v1/transit/"+operation+"/"+w.keyName, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", w.token)
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault %s responded with status %d", operation, resp.StatusCode)
+	}
+	var result struct {
+		Data map[string]string `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return result.Data, nil
+}
+
+func (w *vaultKeyWrapper) Wrap(ctx context.Context, key []byte) (string, error) {
+	data, err := w.transit(ctx, "encrypt", map[string]string{"plaintext": base64.StdEncoding.EncodeToString(key)})
+	if err != nil {
+		return "", err
+	}
+	if data["ciphertext"] == "" {
+		return "", errors.New("vault returned no ciphertext")
+	}
+	return data["ciphertext"], nil
+}
+
+func (w *vaultKeyWrapper) Unwrap(ctx context.Context, wrapped string) ([]byte, error) {
+	data, err := w.transit(ctx, "decrypt", map[string]string{"ciphertext": wrapped})
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(data["plaintext"])
+}
+
+// DataKey is a data key as stored, wrapped by the master key and shared by
+// all instances through the database
+type DataKey struct {
+	ID         string    `json:"id" bson:"_id"`
+	WrappedKey string    `json:"-" bson:"wrappedKey"`
+	Provider   string    `json:"provider" bson:"provider"`
+	CreatedAt  time.Time `json:"createdAt" bson:"createdAt"`
+}
+
+// dataKeyring holds the unwrapped data keys in use. The newest key seals new
+// text; older keys are loaded when text sealed with them is read. Each
+// instance reloads the newest key periodically, so a rotation made elsewhere
+// applies within DataKeysRefreshInterval.
+type dataKeyring struct {
+	mu      sync.RWMutex
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+var dataKeys = &dataKeyring{keys: make(map[string]cipher.AEAD)}
+
+// Current returns the key new text is sealed with
+func (k *dataKeyring) Current() (string, cipher.AEAD, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	aead, ok := k.keys[k.current]
+	return k.current, aead, ok
+}
+
+// Get returns the data key with the ID, unwrapping it if it isn't loaded
+func (k *dataKeyring) Get(ctx context.Context, id string) (cipher.AEAD, error) {
+	k.mu.RLock()
+	aead, ok := k.keys[id]
+	k.mu.RUnlock()
+	if ok {
+		return aead, nil
+	}
+
+	var stored DataKey
+	if err := db.DataKeys.FindOne(ctx, bson.M{"_id": id}).Decode(&stored); err != nil {
+		return nil, fmt.Errorf("loading data key %s: %w", id, err)
+	}
+	return k.add(ctx, stored)
+}
+
+func (k *dataKeyring) add(ctx context.Context, stored DataKey) (cipher.AEAD, error) {
+	if keyWrapper == nil {
+		return nil, errors.New("encryption at rest is not configured")
+	}
+	key, err := keyWrapper.Unwrap(ctx, stored.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("unwrapping data key %s: %w", stored.ID, err)
+	}
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	k.mu.Lock()
+	k.keys[stored.ID] = aead
+	k.mu.Unlock()
+	return aead, nil
+}
+
+// refresh makes the newest data key current, creating the first one
+func (k *dataKeyring) refresh(ctx context.Context) error {
+	var newest DataKey
+	err := db.DataKeys.FindOne(ctx, bson.M{}, options.FindOne().SetSort(bson.D{{Key: "createdAt", Value: -1}})).Decode(&newest)
+	if err == mongo.ErrNoDocuments {
+		_, err = rotateDataKey(ctx, time.Now())
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := k.Get(ctx, newest.ID); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	k.current = newest.ID
+	k.mu.Unlock()
+	return nil
+}
+
+// startDataKeysRefresh reloads the current data key until ctx is cancelled
+func startDataKeysRefresh(ctx context.Context) {
+	ticker := time.NewTicker(DataKeysRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := dataKeys.refresh(ctx); err != nil {
+				log.Printf("Error refreshing data keys: %v", err)
+			}
+		}
+	}
+}
+
+// rotateDataKey makes a new data key seal text from now on. Text sealed with
+// earlier keys stays readable; it is not re-encrypted.
+func rotateDataKey(ctx context.Context, now time.Time) (DataKey, error) {
+	if keyWrapper == nil {
+		return DataKey{}, errors.New("encryption at rest is not configured")
+	}
+	key := make([]byte, dataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return DataKey{}, err
+	}
+	wrapped, err := keyWrapper.Wrap(ctx, key)
+	if err != nil {
+		return DataKey{}, err
+	}
+	stored := DataKey{ID: uuid.New().String(), WrappedKey: wrapped, Provider: keyWrapper.Name(), CreatedAt: now}
+	if _, err := db.DataKeys.InsertOne(ctx, stored); err != nil {
+		return DataKey{}, err
+	}
+	if _, err := dataKeys.add(ctx, stored); err != nil {
+		return DataKey{}, err
+	}
+	dataKeys.mu.Lock()
+	dataKeys.current = stored.ID
+	dataKeys.mu.Unlock()
+	return stored, nil
+}
+
+// sealText encrypts text of the meeting with the current data key. Text is
+// returned as is while encryption is off.
+func sealText(meetingID, text string) (string, error) {
+	if keyWrapper == nil || text == "" {
+		return text, nil
+	}
+	id, aead, ok := dataKeys.Current()
+	if !ok {
+		return "", errors.New("no data key is loaded")
+	}
+	sealed, err := sealBytes(aead, []byte(text), []byte(meetingID))
+	if err != nil {
+		return "", err
+	}
+	return SealedTextPrefix + id + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// openText decrypts text sealed by sealText; other text is returned as is
+func openText(meetingID, value string) (string, error) {
+	rest, ok := strings.CutPrefix(value, SealedTextPrefix)
+	if !ok {
+		return value, nil
+	}
+	id, encoded, ok := strings.Cut(rest, ":")
+	if !ok {
+		return "", errors.New("malformed sealed text")
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", errors.New("malformed sealed text")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DataKeyLoadTimeout)
+	defer cancel()
+	aead, err := dataKeys.Get(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := openBytes(aead, sealed, []byte(meetingID))
+	if err != nil {
+		return "", fmt.Errorf("decrypting with data key %s: %w", id, err)
+	}
+	return string(plaintext), nil
+}
+
+// storedChatMessage and storedUtterance have the fields of the types they
+// convert from, without their BSON methods
+type (
+	storedChatMessage ChatMessage
+	storedUtterance   TranscriptUtterance
+)
+
+// MarshalBSON seals the message's text, and its edit history, for storage
+func (m ChatMessage) MarshalBSON() ([]byte, error) {
+	stored := storedChatMessage(m)
+	var err error
+	if stored.Message, err = sealText(m.MeetingID, m.Message); err != nil {
+		return nil, err
+	}
+	if m.History != nil {
+		stored.History = make([]ChatEdit, len(m.History))
+		for i, edit := range m.History {
+			if edit.Message, err = sealText(m.MeetingID, edit.Message); err != nil {
+				return nil, err
+			}
+			stored.History[i] = edit
+		}
+	}
+	return bson.Marshal(stored)
+}
+
+// UnmarshalBSON opens a stored message's text and edit history
+func (m *ChatMessage) UnmarshalBSON(data []byte) error {
+	var stored storedChatMessage
+	if err := bson.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	var err error
+	if stored.Message, err = openText(stored.MeetingID, stored.Message); err != nil {
+		return err
+	}
+	for i := range stored.History {
+		if stored.History[i].Message, err = openText(stored.MeetingID, stored.History[i].Message); err != nil {
+			return err
+		}
+	}
+	*m = ChatMessage(stored)
+	return nil
+}
+
+// MarshalBSON seals the utterance's text for storage
+func (u TranscriptUtterance) MarshalBSON() ([]byte, error) {
+	stored := storedUtterance(u)
+	var err error
+	if stored.Text, err = sealText(u.MeetingID, u.Text); err != nil {
+		return nil, err
+	}
+	return bson.Marshal(stored)
+}
+
+// UnmarshalBSON opens a stored utterance's text
+func (u *TranscriptUtterance) UnmarshalBSON(data []byte) error {
+	var stored storedUtterance
+	if err := bson.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	var err error
+	if stored.Text, err = openText(stored.MeetingID, stored.Text); err != nil {
+		return err
+	}
+	*u = TranscriptUtterance(stored)
+	return nil
+}
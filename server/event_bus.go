@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+)
+
+// Domain events decouple what happened from who reacts to it. Code that
+// changes state publishes an event to the outbox collection; a background
+// dispatcher hands each event to its subscribers (webhooks, email, usage
+// metering), retrying the ones that fail. An event survives restarts until
+// every subscriber has handled it, and a subscriber that succeeded isn't
+// called again when the event is retried for another.
+
+const (
+	EventDispatchInterval = 5 * time.Second
+	EventDispatchLease    = 1 * time.Minute
+	EventRetryBaseDelay   = 30 * time.Second
+	EventMaxAttempts      = 5
+	maxEventErrorLength   = 500
+)
+
+// Domain event types. They double as the webhook event names.
+const (
+	DomainMeetingStarted    = WebhookMeetingStarted
+	DomainMeetingEnded      = WebhookMeetingEnded
+	DomainParticipantJoined = WebhookParticipantJoined
+	DomainRecordingReady    = WebhookRecordingReady
+)
+
+// Outbox event statuses
+const (
+	OutboxPending   = "pending"
+	OutboxProcessed = "processed"
+	OutboxFailed    = "failed"
+)
+
+// DomainEvent is one published event, as stored in the outbox
+type DomainEvent struct {
+	ID        string `json:"id" bson:"_id"`
+	Type      string `json:"type" bson:"type"`
+	MeetingID string `json:"meetingId,omitempty" bson:"meetingId,omitempty"`
+	// The user whose resource the event is about, e.g. the meeting's host
+	OwnerID string `json:"ownerId,omitempty" bson:"ownerId,omitempty"`
+	// The user who caused the event, if any
+	ActorID string `json:"actorId,omitempty" bson:"actorId,omitempty"`
+	// JSON-encoded event data
+	Payload string `json:"payload" bson:"payload"`
+	Status  string `json:"status" bson:"status"`
+	// Subscribers that have handled the event
+	Handled       []string   `json:"handled,omitempty" bson:"handled,omitempty"`
+	Attempts      int        `json:"attempts" bson:"attempts"`
+	NextAttemptAt time.Time  `json:"nextAttemptAt" bson:"nextAttemptAt"`
+	LastError     string     `json:"lastError,omitempty" bson:"lastError,omitempty"`
+	CreatedAt     time.Time  `json:"createdAt" bson:"createdAt"`
+	ProcessedAt   *time.Time `json:"processedAt,omitempty" bson:"processedAt,omitempty"`
+	TenantID      string     `json:"-" bson:"tenantId,omitempty"`
+}
+
+// eventSubscriber reacts to some types of domain event. Handle runs in the
+// event's tenant and may run more than once for an event, so it must
+// tolerate repeats.
+type eventSubscriber struct {
+	Name   string
+	Events []string
+	Handle func(ctx context.Context, event DomainEvent) error
+}
+
+var eventSubscribers = []eventSubscriber{
+	{
+		Name:   "webhooks",
+		Events: []string{DomainMeetingStarted, DomainMeetingEnded, DomainParticipantJoined, DomainRecordingReady},
+		Handle: webhookEventSubscriber,
+	},
+	{
+		Name:   "email",
+		Events: []string{DomainRecordingReady},
+		Handle: emailEventSubscriber,
+	},
+	{
+		Name:   "usage",
+		Events: []string{DomainRecordingReady},
+		Handle: usageEventSubscriber,
+	},
+}
+
+// eventWake nudges the dispatcher when events are published
+var eventWake = make(chan struct{}, 1)
+
+// publishEvent stores an event in the outbox, in ctx's tenant, for the
+// dispatcher; failures are logged only
+func publishEvent(ctx context.Context, event DomainEvent, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Error marshaling %s event: %v", event.Type, err)
+		return
+	}
+
+	now := time.Now()
+	event.ID = uuid.New().String()
+	event.Payload = string(payload)
+	event.Status = OutboxPending
+	event.NextAttemptAt = now
+	event.CreatedAt = now
+	if _, err := db.Outbox.InsertOne(ctx, event); err != nil {
+		log.Printf("Error publishing %s event: %v", event.Type, err)
+		return
+	}
+
+	select {
+	case eventWake <- struct{}{}:
+	default:
+	}
+}
+
+// publishMeetingEvent publishes a meeting lifecycle event in the meeting's
+// tenant, adding the meeting's ID and title to its data
+func publishMeetingEvent(ctx context.Context, meeting Meeting, eventType, actorID string, data map[string]interface{}) {
+	if _, scoped := db.TenantFromContext(ctx); !scoped {
+		ctx = db.WithTenant(ctx, meeting.TenantID)
+	}
+	data["meetingId"] = meeting.ID
+	data["title"] = meeting.Title
+	publishEvent(ctx, DomainEvent{
+		Type:      eventType,
+		MeetingID: meeting.ID,
+		OwnerID:   meeting.CreatedBy,
+		ActorID:   actorID,
+	}, data)
+}
+
+// startEventDispatchJob hands published events to their subscribers until
+// ctx is cancelled
+func startEventDispatchJob(ctx context.Context) {
+	ticker := time.NewTicker(EventDispatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-eventWake:
+		}
+		dispatchDueEvents(ctx)
+	}
+}
+
+// dispatchDueEvents dispatches every due event. Each one is leased first so
+// concurrent instances don't dispatch it twice.
+func dispatchDueEvents(ctx context.Context) {
+	for ctx.Err() == nil {
+		now := time.Now()
+		var event DomainEvent
+		err := db.Outbox.FindOneAndUpdate(
+			ctx,
+			bson.M{"status": OutboxPending, "nextAttemptAt": bson.M{"$lte": now}},
+			bson.M{"$set": bson.M{"nextAttemptAt": now.Add(EventDispatchLease)}},
+			options.FindOneAndUpdate().
+				SetSort(bson.D{{Key: "nextAttemptAt", Value: 1}}).
+				SetReturnDocument(options.After),
+		).Decode(&event)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return
+		}
+		if err != nil {
+			log.Printf("Events: failed to claim event: %v", err)
+			return
+		}
+		dispatchEvent(ctx, event)
+	}
+}
+
+// dispatchEvent calls the event's subscribers that haven't handled it yet,
+// then marks it processed or schedules a retry of those that failed
+func dispatchEvent(ctx context.Context, event DomainEvent) {
+	ctx, span := tracer.Start(ctx, "events.dispatch")
+	defer span.End()
+
+	handled := make(map[string]bool, len(event.Handled))
+	for _, name := range event.Handled {
+		handled[name] = true
+	}
+
+	tenantCtx := db.WithTenant(ctx, event.TenantID)
+	var failures []string
+	for _, subscriber := range eventSubscribers {
+		if handled[subscriber.Name] || !subscribesTo(subscriber, event.Type) {
+			continue
+		}
+		if err := subscriber.Handle(tenantCtx, event); err != nil {
+			log.Printf("Events: %s failed to handle %s event %s: %v", subscriber.Name, event.Type, event.ID, err)
+			failures = append(failures, subscriber.Name+": "+err.Error())
+			continue
+		}
+		_, err := db.Outbox.UpdateOne(ctx,
+			bson.M{"_id": event.ID},
+			bson.M{"$addToSet": bson.M{"handled": subscriber.Name}},
+		)
+		if err != nil {
+			log.Printf("Events: failed to record %s handling event %s: %v", subscriber.Name, event.ID, err)
+		}
+	}
+
+	now := time.Now()
+	set := bson.M{"status": OutboxProcessed, "processedAt": now}
+	if len(failures) > 0 {
+		set = bson.M{
+			"lastError":     truncateString(strings.Join(failures, "; "), maxEventErrorLength),
+			"nextAttemptAt": now.Add(EventRetryBaseDelay << event.Attempts),
+		}
+		if event.Attempts+1 >= EventMaxAttempts {
+			set["status"] = OutboxFailed
+			set["processedAt"] = now
+		}
+	}
+	_, err := db.Outbox.UpdateOne(ctx,
+		bson.M{"_id": event.ID},
+		bson.M{"$inc": bson.M{"attempts": 1}, "$set": set},
+	)
+	if err != nil {
+		log.Printf("Events: failed to update event %s: %v", event.ID, err)
+	}
+}
+
+func subscribesTo(subscriber eventSubscriber, eventType string) bool {
+	for _, t := range subscriber.Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookEventSubscriber queues the event for the owner's webhooks
+func webhookEventSubscriber(ctx context.Context, event DomainEvent) error {
+	if event.OwnerID == "" {
+		return nil
+	}
+	return emitWebhookEvent(ctx, event.OwnerID, event.Type, json.RawMessage(event.Payload))
+}
+
+// recordingReadyData is the data of a recording ready event
+type recordingReadyData struct {
+	RecordingID     string    `json:"recordingId"`
+	MeetingID       string    `json:"meetingId"`
+	Title           string    `json:"title"`
+	DurationSeconds int64     `json:"durationSeconds"`
+	ContentType     string    `json:"contentType"`
+	Size            int64     `json:"size"`
+	RecordedBy      string    `json:"recordedBy"`
+	CreatedAt       time.Time `json:"createdAt"`
+}
+
+// publishRecordingReady announces a stored recording to the meeting's host
+func publishRecordingReady(ctx context.Context, recording Recording) {
+	meeting, err := findMeeting(ctx, recording.MeetingID)
+	if err != nil {
+		log.Printf("Error loading meeting %s of recording %s: %v", recording.MeetingID, recording.ID, err)
+		return
+	}
+	publishMeetingEvent(ctx, meeting, DomainRecordingReady, recording.RecordedBy, map[string]interface{}{
+		"recordingId":     recording.ID,
+		"durationSeconds": recording.DurationSeconds,
+		"contentType":     recording.ContentType,
+		"size":            recording.Size,
+		"recordedBy":      recording.RecordedBy,
+		"createdAt":       recording.CreatedAt,
+	})
+}
+
+// emailEventSubscriber tells the host by email that their recording is ready
+func emailEventSubscriber(ctx context.Context, event DomainEvent) error {
+	var data recordingReadyData
+	if err := json.Unmarshal([]byte(event.Payload), &data); err != nil {
+		return err
+	}
+
+	var host User
+	err := db.Users.FindOne(ctx, bson.M{"_id": event.OwnerID}).Decode(&host)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	meeting, err := findMeeting(ctx, data.MeetingID)
+	if err != nil {
+		return nil
+	}
+
+	return mail.EnqueueTemplate(host.Email, "recording_ready", map[string]string{
+		"HostName":     host.Name,
+		"MeetingTitle": data.Title,
+		"Duration":     (time.Duration(data.DurationSeconds) * time.Second).String(),
+		"MeetingURL":   meetingJoinURL(meeting),
+	})
+}
+
+// usageEventSubscriber counts a recording's minutes towards the host's
+// monthly recording quota
+func usageEventSubscriber(ctx context.Context, event DomainEvent) error {
+	var data recordingReadyData
+	if err := json.Unmarshal([]byte(event.Payload), &data); err != nil {
+		return err
+	}
+	if event.OwnerID == "" {
+		return nil
+	}
+	minutes := int(math.Ceil(float64(data.DurationSeconds) / 60))
+	return addRecordingUsage(ctx, event.OwnerID, minutes, data.CreatedAt)
+}
@@ -0,0 +1,475 @@
+// Package graphql executes read-only GraphQL queries against a schema of Go
+// resolvers. It supports the query language's selections, aliases,
+// arguments, variables and fragments, but not directives, mutations,
+// subscriptions or introspection beyond __typename.
+//
+// Fields are resolved breadth first: a field is resolved for every object
+// at its level of the response before descending, so a Batch resolver loads
+// the field for a whole list of parents with one query instead of one per
+// parent.
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// DefaultMaxDepth bounds how deeply selections may nest when a schema sets no limit
+const DefaultMaxDepth = 10
+
+// ErrNotAuthorized is reported for fields the caller may not see
+var ErrNotAuthorized = errors.New("not authorized")
+
+// Schema is the set of types a query can select from
+type Schema struct {
+	Query *Object
+	// MaxDepth bounds selection nesting; DefaultMaxDepth when zero
+	MaxDepth int
+}
+
+// Object is an object type: a named set of fields
+type Object struct {
+	Name   string
+	Fields map[string]*Field
+}
+
+// Field resolves one field of an object. Exactly one of Resolve and Batch
+// must be set.
+type Field struct {
+	// Type is the object type the field returns, or nil for scalars. A field
+	// with an object type may return a slice, which becomes a list.
+	Type *Object
+	// Args are the argument names the field accepts
+	Args []string
+	// Resolve returns the field's value for one parent
+	Resolve func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error)
+	// Batch returns the field's value for each of many parents, in order
+	Batch func(ctx context.Context, sources []interface{}, args map[string]interface{}) ([]interface{}, error)
+	// Authorize reports whether the caller may see the field of source;
+	// unauthorized fields resolve to null with an error
+	Authorize func(ctx context.Context, source interface{}) bool
+}
+
+// Request is a GraphQL request as posted by clients
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// Error is an error in a response; Path leads to the field that failed.
+// Fields are resolved for all list items at once, so paths leave out list
+// indices and one error covers every item the field failed for.
+type Error struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+// Result is the response to a request
+type Result struct {
+	Data   *OrderedMap `json:"data"`
+	Errors []Error     `json:"errors,omitempty"`
+}
+
+// OrderedMap is a JSON object that keeps its keys in insertion order, as
+// GraphQL responses follow the order of the query's fields
+type OrderedMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newOrderedMap() *OrderedMap {
+	return &OrderedMap{values: make(map[string]interface{})}
+}
+
+// Set adds or replaces a key, keeping its original position
+func (m *OrderedMap) Set(key string, value interface{}) {
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+// Get returns the value of a key
+func (m *OrderedMap) Get(key string) (interface{}, bool) {
+	value, ok := m.values[key]
+	return value, ok
+}
+
+func (m *OrderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(m.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Execute runs a query. Errors that prevent execution are returned as the
+// result's only error, with no data.
+func (s *Schema) Execute(ctx context.Context, req Request) Result {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return Result{Errors: []Error{{Message: "Syntax error: " + err.Error()}}}
+	}
+	op, err := doc.operation(req.OperationName)
+	if err != nil {
+		return Result{Errors: []Error{{Message: err.Error()}}}
+	}
+	if op.kind != "query" {
+		return Result{Errors: []Error{{Message: op.kind + " operations are not supported"}}}
+	}
+	variables, err := coerceVariables(op.variables, req.Variables)
+	if err != nil {
+		return Result{Errors: []Error{{Message: err.Error()}}}
+	}
+
+	maxDepth := s.MaxDepth
+	if maxDepth == 0 {
+		maxDepth = DefaultMaxDepth
+	}
+	e := &executor{doc: doc, variables: variables, maxDepth: maxDepth}
+	results := e.selectAll(ctx, s.Query, []interface{}{nil}, op.selection, nil, 1)
+	if e.fatal != nil {
+		return Result{Errors: []Error{{Message: e.fatal.Error()}}}
+	}
+	return Result{Data: results[0], Errors: e.errors}
+}
+
+func (d *document) operation(name string) (*operation, error) {
+	if name == "" {
+		if len(d.operations) > 1 {
+			return nil, fmt.Errorf("operationName is required when the document has several operations")
+		}
+		return d.operations[0], nil
+	}
+	for _, op := range d.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown operation %q", name)
+}
+
+// coerceVariables applies defaults and checks required variables. JSON
+// numbers are converted to int for Int variables.
+func coerceVariables(defs []variableDefinition, supplied map[string]interface{}) (map[string]interface{}, error) {
+	values := make(map[string]interface{}, len(defs))
+	for _, def := range defs {
+		value, ok := supplied[def.name]
+		if !ok && def.hasDefault {
+			value, ok = def.defaultValue, true
+		}
+		if !ok || value == nil {
+			if def.typ.nonNull {
+				return nil, fmt.Errorf("variable $%s is required", def.name)
+			}
+			continue
+		}
+		if def.typ.name == "Int" {
+			if f, isFloat := value.(float64); isFloat {
+				if f != float64(int(f)) {
+					return nil, fmt.Errorf("variable $%s must be an integer", def.name)
+				}
+				value = int(f)
+			}
+		}
+		values[def.name] = value
+	}
+	return values, nil
+}
+
+type executor struct {
+	doc       *document
+	variables map[string]interface{}
+	maxDepth  int
+	errors    []Error
+	// fatal is a problem with the query itself, which voids the whole result
+	fatal error
+}
+
+func (e *executor) addError(err error, path []interface{}) {
+	for _, existing := range e.errors {
+		if existing.Message == err.Error() && reflect.DeepEqual(existing.Path, path) {
+			return
+		}
+	}
+	e.errors = append(e.errors, Error{Message: err.Error(), Path: path})
+}
+
+// selectAll resolves the selection on each of sources, which are all of
+// type obj, returning one result object per source
+func (e *executor) selectAll(ctx context.Context, obj *Object, sources []interface{}, sel []selection, path []interface{}, depth int) []*OrderedMap {
+	results := make([]*OrderedMap, len(sources))
+	if e.fatal != nil {
+		return results
+	}
+	if depth > e.maxDepth {
+		e.fatal = fmt.Errorf("query is nested more than %d levels deep", e.maxDepth)
+		return results
+	}
+	for i := range sources {
+		results[i] = newOrderedMap()
+	}
+
+	fields, err := e.collectFields(obj, sel, nil, map[string]bool{})
+	if err != nil {
+		e.fatal = err
+		return results
+	}
+	for _, f := range fields {
+		key := f.responseKey()
+		fieldPath := append(append([]interface{}{}, path...), key)
+
+		if f.name == "__typename" {
+			for _, result := range results {
+				result.Set(key, obj.Name)
+			}
+			continue
+		}
+		def, ok := obj.Fields[f.name]
+		if !ok {
+			e.fatal = fmt.Errorf("cannot query field %q on type %q", f.name, obj.Name)
+			return results
+		}
+		if def.Type == nil && f.selection != nil {
+			e.fatal = fmt.Errorf("field %q of type %q must not have a selection", f.name, obj.Name)
+			return results
+		}
+		if def.Type != nil && f.selection == nil {
+			e.fatal = fmt.Errorf("field %q of type %q must have a selection", f.name, obj.Name)
+			return results
+		}
+		args, err := e.arguments(def, f)
+		if err != nil {
+			e.fatal = err
+			return results
+		}
+
+		values := e.resolve(ctx, def, sources, args, fieldPath)
+		if def.Type == nil {
+			for i, result := range results {
+				result.Set(key, values[i])
+			}
+			continue
+		}
+
+		// Resolve the children of every parent together so batches span them all
+		children, rebuild := flatten(values)
+		childResults := e.selectAll(ctx, def.Type, children, f.selection, fieldPath, depth+1)
+		for i, result := range results {
+			result.Set(key, rebuild(i, childResults))
+		}
+	}
+	return results
+}
+
+// resolve returns the field's value for each source. Sources the caller
+// may not see, and failed resolutions, give nil.
+func (e *executor) resolve(ctx context.Context, def *Field, sources []interface{}, args map[string]interface{}, path []interface{}) []interface{} {
+	values := make([]interface{}, len(sources))
+	var allowed []int
+	for i, source := range sources {
+		if def.Authorize != nil && !def.Authorize(ctx, source) {
+			e.addError(ErrNotAuthorized, path)
+			continue
+		}
+		allowed = append(allowed, i)
+	}
+	if len(allowed) == 0 {
+		return values
+	}
+
+	if def.Batch != nil {
+		batch := make([]interface{}, len(allowed))
+		for j, i := range allowed {
+			batch[j] = sources[i]
+		}
+		resolved, err := def.Batch(ctx, batch, args)
+		if err == nil && len(resolved) != len(batch) {
+			err = fmt.Errorf("batch returned %d values for %d parents", len(resolved), len(batch))
+		}
+		if err != nil {
+			e.addError(err, path)
+			return values
+		}
+		for j, i := range allowed {
+			values[i] = resolved[j]
+		}
+		return values
+	}
+
+	for _, i := range allowed {
+		value, err := def.Resolve(ctx, sources[i], args)
+		if err != nil {
+			e.addError(err, path)
+			continue
+		}
+		values[i] = value
+	}
+	return values
+}
+
+// flatten gathers the objects in values, where each value is an object, a
+// slice of objects or nil, and returns a function that puts the results for
+// the value at index i back into the same shape
+func flatten(values []interface{}) ([]interface{}, func(i int, results []*OrderedMap) interface{}) {
+	var children []interface{}
+	type span struct {
+		start, end int
+		list, null bool
+	}
+	spans := make([]span, len(values))
+
+	for i, value := range values {
+		if isNil(value) {
+			spans[i] = span{null: true}
+			continue
+		}
+		v := reflect.ValueOf(value)
+		if v.Kind() != reflect.Slice {
+			spans[i] = span{start: len(children), end: len(children) + 1}
+			children = append(children, value)
+			continue
+		}
+		start := len(children)
+		for j := 0; j < v.Len(); j++ {
+			children = append(children, v.Index(j).Interface())
+		}
+		spans[i] = span{start: start, end: len(children), list: true}
+	}
+
+	return children, func(i int, results []*OrderedMap) interface{} {
+		s := spans[i]
+		switch {
+		case s.null:
+			return nil
+		case !s.list:
+			return results[s.start]
+		}
+		list := make([]*OrderedMap, 0, s.end-s.start)
+		return append(list, results[s.start:s.end]...)
+	}
+}
+
+func isNil(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+// collectFields expands fragments into the list of fields to resolve
+func (e *executor) collectFields(obj *Object, sel []selection, fields []*field, visited map[string]bool) ([]*field, error) {
+	for _, s := range sel {
+		switch s := s.(type) {
+		case *field:
+			fields = append(fields, s)
+		case *inlineFragment:
+			if s.typeCondition != "" && s.typeCondition != obj.Name {
+				continue
+			}
+			var err error
+			if fields, err = e.collectFields(obj, s.selection, fields, visited); err != nil {
+				return nil, err
+			}
+		case *fragmentSpread:
+			frag, ok := e.doc.fragments[s.name]
+			if !ok {
+				return nil, fmt.Errorf("unknown fragment %q", s.name)
+			}
+			if visited[s.name] {
+				return nil, fmt.Errorf("fragment %q spreads itself", s.name)
+			}
+			if frag.typeCondition != obj.Name {
+				continue
+			}
+			visited[s.name] = true
+			var err error
+			fields, err = e.collectFields(obj, frag.selection, fields, visited)
+			delete(visited, s.name)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return fields, nil
+}
+
+// arguments resolves a field's argument values, substituting variables
+func (e *executor) arguments(def *Field, f *field) (map[string]interface{}, error) {
+	args := make(map[string]interface{}, len(f.arguments))
+	for name, value := range f.arguments {
+		known := false
+		for _, arg := range def.Args {
+			if arg == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown argument %q on field %q", name, f.name)
+		}
+		resolved, err := e.value(value)
+		if err != nil {
+			return nil, err
+		}
+		if resolved != nil {
+			args[name] = resolved
+		}
+	}
+	return args, nil
+}
+
+func (e *executor) value(ast interface{}) (interface{}, error) {
+	switch v := ast.(type) {
+	case variableRef:
+		return e.variables[string(v)], nil
+	case enumValue:
+		return string(v), nil
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			value, err := e.value(item)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = value
+		}
+		return list, nil
+	case map[string]interface{}:
+		object := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			value, err := e.value(item)
+			if err != nil {
+				return nil, err
+			}
+			object[key] = value
+		}
+		return object, nil
+	}
+	return ast, nil
+}
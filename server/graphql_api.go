@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+	"video-meeting-app/graphql"
+)
+
+const (
+	MaxGraphQLRequestSize = 64 << 10
+	GraphQLMaxDepth       = 6
+)
+
+// graphQLCaller is the signed-in user a query runs for
+type graphQLCaller struct {
+	UserID string
+	// CanViewUsers is set for admins allowed to see every user's private fields
+	CanViewUsers bool
+}
+
+type graphQLCallerKey struct{}
+
+func callerFromContext(ctx context.Context) graphQLCaller {
+	caller, _ := ctx.Value(graphQLCallerKey{}).(graphQLCaller)
+	return caller
+}
+
+// scalar is a field read straight from its parent
+func scalar[T any](get func(T) interface{}) *graphql.Field {
+	return &graphql.Field{
+		Resolve: func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return get(source.(T)), nil
+		},
+	}
+}
+
+// intArg returns an Int argument clamped to [1, max], or def when absent
+func intArg(args map[string]interface{}, name string, def, max int) (int, error) {
+	value, ok := args[name]
+	if !ok {
+		return def, nil
+	}
+	n, ok := value.(int)
+	if !ok || n <= 0 {
+		return 0, fmt.Errorf("%s must be a positive integer", name)
+	}
+	return min(n, max), nil
+}
+
+// loadUsers fetches the users with the given IDs in one query
+func loadUsers(ctx context.Context, ids []string) (map[string]User, error) {
+	cursor, err := db.Users.Find(ctx, bson.M{"_id": bson.M{"$in": uniqueStrings(ids)}},
+		options.Find().SetProjection(bson.M{"password": 0}))
+	if err != nil {
+		return nil, err
+	}
+	var users []User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	byID := make(map[string]User, len(users))
+	for _, user := range users {
+		byID[user.ID] = user
+	}
+	return byID, nil
+}
+
+// batchUsers resolves a user field for many parents with one query; userID
+// picks the user out of each parent
+func batchUsers[T any](userID func(T) string) *graphql.Field {
+	return &graphql.Field{
+		Batch: func(ctx context.Context, sources []interface{}, _ map[string]interface{}) ([]interface{}, error) {
+			ids := make([]string, len(sources))
+			for i, source := range sources {
+				ids[i] = userID(source.(T))
+			}
+			users, err := loadUsers(ctx, ids)
+			if err != nil {
+				return nil, err
+			}
+			values := make([]interface{}, len(sources))
+			for i, id := range ids {
+				if user, ok := users[id]; ok {
+					values[i] = user
+				}
+			}
+			return values, nil
+		},
+	}
+}
+
+// meetingIDs returns the IDs of a batch of meetings
+func meetingIDs(sources []interface{}) []string {
+	ids := make([]string, len(sources))
+	for i, source := range sources {
+		ids[i] = source.(Meeting).ID
+	}
+	return ids
+}
+
+// newGraphQLSchema builds the schema served at /api/graphql
+func newGraphQLSchema() *graphql.Schema {
+	user := &graphql.Object{Name: "User"}
+	meeting := &graphql.Object{Name: "Meeting"}
+	participant := &graphql.Object{Name: "Participant"}
+	chatMessage := &graphql.Object{Name: "ChatMessage"}
+	analytics := &graphql.Object{Name: "MeetingAnalytics"}
+	participantAnalytics := &graphql.Object{Name: "ParticipantAnalytics"}
+
+	// Users see their own private fields; admins with view-users see everyone's
+	selfOrAdmin := func(ctx context.Context, source interface{}) bool {
+		caller := callerFromContext(ctx)
+		return caller.CanViewUsers || source.(User).ID == caller.UserID
+	}
+	user.Fields = map[string]*graphql.Field{
+		"id":        scalar(func(u User) interface{} { return u.ID }),
+		"name":      scalar(func(u User) interface{} { return u.Name }),
+		"avatarUrl": scalar(func(u User) interface{} { return u.AvatarURL }),
+		"email": {
+			Authorize: selfOrAdmin,
+			Resolve: func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+				return source.(User).Email, nil
+			},
+		},
+		"plan": {
+			Authorize: selfOrAdmin,
+			Resolve: func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+				return planOf(source.(User)), nil
+			},
+		},
+		"createdAt": scalar(func(u User) interface{} { return u.CreatedAt }),
+	}
+
+	hostOnly := func(ctx context.Context, source interface{}) bool {
+		return source.(Meeting).CreatedBy == callerFromContext(ctx).UserID
+	}
+	host := batchUsers(func(m Meeting) string { return m.CreatedBy })
+	host.Type = user
+	meeting.Fields = map[string]*graphql.Field{
+		"id":              scalar(func(m Meeting) interface{} { return m.ID }),
+		"code":            scalar(func(m Meeting) interface{} { return m.Code }),
+		"title":           scalar(func(m Meeting) interface{} { return m.Title }),
+		"description":     scalar(func(m Meeting) interface{} { return m.Description }),
+		"isPrivate":       scalar(func(m Meeting) interface{} { return m.IsPrivate }),
+		"isActive":        scalar(func(m Meeting) interface{} { return m.IsActive }),
+		"isLocked":        scalar(func(m Meeting) interface{} { return m.IsLocked }),
+		"maxParticipants": scalar(func(m Meeting) interface{} { return m.MaxParticipants }),
+		"scheduledFor":    scalar(func(m Meeting) interface{} { return m.ScheduledFor }),
+		"startedAt":       scalar(func(m Meeting) interface{} { return m.StartedAt }),
+		"createdAt":       scalar(func(m Meeting) interface{} { return m.CreatedAt }),
+		"host":            host,
+		"participants": {
+			Type: participant,
+			Args: []string{"includeLeft"},
+			Batch: func(ctx context.Context, sources []interface{}, args map[string]interface{}) ([]interface{}, error) {
+				return batchMeetingParticipants(ctx, meetingIDs(sources), args["includeLeft"] == true)
+			},
+		},
+		"chat": {
+			Type: chatMessage,
+			Args: []string{"limit"},
+			Batch: func(ctx context.Context, sources []interface{}, args map[string]interface{}) ([]interface{}, error) {
+				limit, err := intArg(args, "limit", DefaultChatHistoryLimit, MaxChatHistoryLimit)
+				if err != nil {
+					return nil, err
+				}
+				return batchMeetingChat(ctx, meetingIDs(sources), limit)
+			},
+		},
+		"analytics": {
+			Type:      analytics,
+			Authorize: hostOnly,
+			Resolve: func(ctx context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+				return loadMeetingAnalytics(ctx, source.(Meeting))
+			},
+		},
+	}
+
+	participantUser := batchUsers(func(p Participant) string { return p.UserID })
+	participantUser.Type = user
+	participant.Fields = map[string]*graphql.Field{
+		"id":              scalar(func(p Participant) interface{} { return p.ID }),
+		"userId":          scalar(func(p Participant) interface{} { return p.UserID }),
+		"userName":        scalar(func(p Participant) interface{} { return p.UserName }),
+		"role":            scalar(func(p Participant) interface{} { return p.Role }),
+		"isHost":          scalar(func(p Participant) interface{} { return p.IsHost }),
+		"isAudioEnabled":  scalar(func(p Participant) interface{} { return p.IsAudioEnabled }),
+		"isVideoEnabled":  scalar(func(p Participant) interface{} { return p.IsVideoEnabled }),
+		"isScreenSharing": scalar(func(p Participant) interface{} { return p.IsScreenSharing }),
+		"joinedAt":        scalar(func(p Participant) interface{} { return p.JoinedAt }),
+		"leftAt":          scalar(func(p Participant) interface{} { return p.LeftAt }),
+		"status":          scalar(func(p Participant) interface{} { return participantStatus(p, time.Now()) }),
+		"user":            participantUser,
+	}
+
+	author := batchUsers(func(m ChatMessage) string { return m.UserID })
+	author.Type = user
+	chatMessage.Fields = map[string]*graphql.Field{
+		"id":        scalar(func(m ChatMessage) interface{} { return m.ID }),
+		"userId":    scalar(func(m ChatMessage) interface{} { return m.UserID }),
+		"userName":  scalar(func(m ChatMessage) interface{} { return m.UserName }),
+		"message":   scalar(func(m ChatMessage) interface{} { return m.Message }),
+		"timestamp": scalar(func(m ChatMessage) interface{} { return m.Timestamp }),
+		"editedAt":  scalar(func(m ChatMessage) interface{} { return m.EditedAt }),
+		"author":    author,
+	}
+
+	analytics.Fields = map[string]*graphql.Field{
+		"startedAt":        scalar(func(a MeetingAnalytics) interface{} { return a.StartedAt }),
+		"speakingSeconds":  scalar(func(a MeetingAnalytics) interface{} { return a.SpeakingSeconds }),
+		"interruptions":    scalar(func(a MeetingAnalytics) interface{} { return a.Interruptions }),
+		"talkTimeMeasured": scalar(func(a MeetingAnalytics) interface{} { return a.TalkTimeMeasured }),
+		"participants": {
+			Type: participantAnalytics,
+			Resolve: func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+				return source.(MeetingAnalytics).Participants, nil
+			},
+		},
+	}
+	participantAnalytics.Fields = map[string]*graphql.Field{
+		"userId":          scalar(func(p ParticipantAnalytics) interface{} { return p.UserID }),
+		"userName":        scalar(func(p ParticipantAnalytics) interface{} { return p.UserName }),
+		"attendedSeconds": scalar(func(p ParticipantAnalytics) interface{} { return p.AttendedSeconds }),
+		"speakingSeconds": scalar(func(p ParticipantAnalytics) interface{} { return p.SpeakingSeconds }),
+		"speakingShare":   scalar(func(p ParticipantAnalytics) interface{} { return p.SpeakingShare }),
+		"turns":           scalar(func(p ParticipantAnalytics) interface{} { return p.Turns }),
+		"interruptions":   scalar(func(p ParticipantAnalytics) interface{} { return p.Interruptions }),
+		"interrupted":     scalar(func(p ParticipantAnalytics) interface{} { return p.Interrupted }),
+	}
+
+	query := &graphql.Object{Name: "Query", Fields: map[string]*graphql.Field{
+		"me": {
+			Type: user,
+			Resolve: func(ctx context.Context, _ interface{}, _ map[string]interface{}) (interface{}, error) {
+				return resolveUser(ctx, callerFromContext(ctx).UserID)
+			},
+		},
+		"user": {
+			Type: user,
+			Args: []string{"id"},
+			Resolve: func(ctx context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+				id, _ := args["id"].(string)
+				return resolveUser(ctx, id)
+			},
+		},
+		"meeting": {
+			Type: meeting,
+			Args: []string{"id"},
+			Resolve: func(ctx context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+				id, _ := args["id"].(string)
+				found, err := findMeeting(ctx, id)
+				if err != nil || !canViewMeeting(found, callerFromContext(ctx).UserID) {
+					return nil, fmt.Errorf("meeting not found")
+				}
+				return found, nil
+			},
+		},
+		"meetings": {
+			Type: meeting,
+			Args: []string{"limit", "active", "hosted"},
+			Resolve: func(ctx context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+				limit, err := intArg(args, "limit", DefaultMeetingsPageSize, MaxMeetingsPageSize)
+				if err != nil {
+					return nil, err
+				}
+				return findVisibleMeetings(ctx, callerFromContext(ctx).UserID, args, limit)
+			},
+		},
+	}}
+
+	return &graphql.Schema{Query: query, MaxDepth: GraphQLMaxDepth}
+}
+
+func resolveUser(ctx context.Context, id string) (interface{}, error) {
+	users, err := loadUsers(ctx, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	if user, ok := users[id]; ok {
+		return user, nil
+	}
+	return nil, nil
+}
+
+// findVisibleMeetings lists the newest meetings the user may see, as the
+// meetings endpoint does
+func findVisibleMeetings(ctx context.Context, userID string, args map[string]interface{}, limit int) ([]Meeting, error) {
+	conditions := []bson.M{{"$or": []bson.M{
+		{"isPrivate": false},
+		{"createdBy": userID},
+		{"invitees": userID},
+	}}}
+	if active, ok := args["active"].(bool); ok {
+		conditions = append(conditions, bson.M{"isActive": active})
+	}
+	if args["hosted"] == true {
+		conditions = append(conditions, bson.M{"createdBy": userID})
+	}
+
+	cursor, err := db.Meetings.Find(ctx, bson.M{"$and": conditions}, options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(limit)))
+	if err != nil {
+		return nil, err
+	}
+	meetings := []Meeting{}
+	err = cursor.All(ctx, &meetings)
+	return meetings, err
+}
+
+// batchMeetingParticipants loads the participants of many meetings in one query
+func batchMeetingParticipants(ctx context.Context, ids []string, includeLeft bool) ([]interface{}, error) {
+	filter := bson.M{"meetingId": bson.M{"$in": ids}}
+	if !includeLeft {
+		filter["leftAt"] = bson.M{"$exists": false}
+	}
+	cursor, err := db.Participants.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "joinedAt", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var participants []Participant
+	if err := cursor.All(ctx, &participants); err != nil {
+		return nil, err
+	}
+
+	byMeeting := make(map[string][]Participant)
+	for _, p := range participants {
+		byMeeting[p.MeetingID] = append(byMeeting[p.MeetingID], p)
+	}
+	values := make([]interface{}, len(ids))
+	for i, id := range ids {
+		values[i] = append([]Participant{}, byMeeting[id]...)
+	}
+	return values, nil
+}
+
+// batchMeetingChat loads the newest limit messages of many meetings in one
+// aggregation, oldest first
+func batchMeetingChat(ctx context.Context, ids []string, limit int) ([]interface{}, error) {
+	cursor, err := db.ChatMessages.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"meetingId": bson.M{"$in": ids}, "deletedAt": bson.M{"$exists": false}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "timestamp", Value: -1}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$meetingId", "messages": bson.M{"$push": "$$ROOT"}}}},
+		{{Key: "$project", Value: bson.M{"messages": bson.M{"$slice": bson.A{"$messages", limit}}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var groups []struct {
+		MeetingID string        `bson:"_id"`
+		Messages  []ChatMessage `bson:"messages"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+
+	byMeeting := make(map[string][]ChatMessage, len(groups))
+	for _, group := range groups {
+		messages := group.Messages
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+		byMeeting[group.MeetingID] = messages
+	}
+	values := make([]interface{}, len(ids))
+	for i, id := range ids {
+		values[i] = append([]ChatMessage{}, byMeeting[id]...)
+	}
+	return values, nil
+}
+
+var graphQLSchema = newGraphQLSchema()
+
+// graphQLHandler runs a GraphQL query for the signed-in user. Responses use
+// GraphQL's own {data, errors} format rather than the API's envelope.
+func graphQLHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req graphql.Request
+	r.Body = http.MaxBytesReader(w, r.Body, MaxGraphQLRequestSize)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Query == "" {
+		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	caller := graphQLCaller{UserID: userID}
+	var user User
+	if err := db.Users.FindOne(r.Context(), bson.M{"_id": userID}).Decode(&user); err == nil {
+		caller.CanViewUsers = adminRolePermissions[adminRoleOf(user)][AdminPermissionViewUsers]
+	}
+
+	ctx, span := tracer.Start(context.WithValue(r.Context(), graphQLCallerKey{}, caller), "graphql.execute")
+	result := graphQLSchema.Execute(ctx, req)
+	span.End()
+
+	w.Header().Set("Content-Type", "application/json")
+	if result.Data == nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	json.NewEncoder(w).Encode(result)
+}
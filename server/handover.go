@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// An instance being replaced, e.g. in a blue/green deploy, is drained rather
+// than just stopped. It stops taking connections, sends each client a
+// "reconnect" message naming where to reconnect, and closes the connections.
+// When a handover store is configured it first saves the in-memory state of
+// its meetings there: the replay buffers clients resume from and the screen
+// shares in progress. The instance the clients reconnect to adopts that state
+// when the first of them arrives, so a client resuming from its last seq
+// misses nothing and a presenter keeps their share. Raised hands and
+// participants live in the database already.
+
+const handoverKeyPrefix = "handover:meeting:"
+
+// handoverStore holds meeting state between instances; nil when not configured
+var handoverStore *redis.Client
+
+// draining is set once the instance starts handing its meetings over
+var draining atomic.Bool
+
+var errAlreadyDraining = errors.New("the instance is already draining")
+
+// meetingHandover is the in-memory state of one meeting
+type meetingHandover struct {
+	MeetingID    string          `json:"meetingId"`
+	LastSeq      uint64          `json:"lastSeq"`
+	Entries      []handoverEntry `json:"entries,omitempty"`
+	ScreenShares []handoverGrant `json:"screenShares,omitempty"`
+}
+
+// handoverEntry is a replay buffer entry, with its message as sent
+type handoverEntry struct {
+	Seq         uint64          `json:"seq"`
+	Message     json.RawMessage `json:"message"`
+	PeerID      string          `json:"peerId,omitempty"`
+	UserID      string          `json:"userId,omitempty"`
+	ExcludePeer string          `json:"excludePeer,omitempty"`
+}
+
+type handoverGrant struct {
+	Token     string    `json:"token"`
+	UserID    string    `json:"userId"`
+	PeerID    string    `json:"peerId"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// handoverRequest asks a shard to send its clients a reconnect message,
+// disconnect them and return its meetings' state; only meetingID's when set
+type handoverRequest struct {
+	meetingID string
+	message   WebSocketMessage
+	reply     chan []meetingHandover
+}
+
+// adoptRequest gives a shard a meeting's handed over state
+type adoptRequest struct {
+	state meetingHandover
+	done  chan struct{}
+}
+
+// handOver tells the clients of the request's meetings to reconnect,
+// disconnects them and returns the meetings' state; must run on the shard's
+// goroutine
+func (h *hubShard) handOver(req handoverRequest) []meetingHandover {
+	meetingIDs := make(map[string]bool, len(h.replay))
+	if req.meetingID != "" {
+		meetingIDs[req.meetingID] = true
+	} else {
+		for meetingID := range h.meetings {
+			meetingIDs[meetingID] = true
+		}
+		for meetingID := range h.replay {
+			meetingIDs[meetingID] = true
+		}
+	}
+
+	states := make([]meetingHandover, 0, len(meetingIDs))
+	for meetingID := range meetingIDs {
+		if len(h.meetings[meetingID]) > 0 {
+			reconnect := req.message
+			reconnect.MeetingID = meetingID
+			h.broadcastToMeeting(meetingID, reconnect, nil)
+		}
+		for client := range h.meetings[meetingID] {
+			client.handedOver.Store(true)
+		}
+
+		state := meetingHandover{MeetingID: meetingID}
+		if buffer := h.replay[meetingID]; buffer != nil {
+			state.LastSeq = buffer.lastSeq
+			for _, entry := range buffer.ordered() {
+				state.Entries = append(state.Entries, handoverEntry{
+					Seq:         entry.seq,
+					Message:     entry.frame.text,
+					PeerID:      entry.peerID,
+					UserID:      entry.userID,
+					ExcludePeer: entry.excludePeer,
+				})
+			}
+		}
+		states = append(states, state)
+		h.disconnectMeeting(meetingID)
+	}
+	return states
+}
+
+// adopt installs a meeting's handed over replay buffer, unless the meeting
+// has moved on here already; must run on the shard's goroutine
+func (h *hubShard) adopt(state meetingHandover) {
+	if buffer := h.replay[state.MeetingID]; buffer != nil && buffer.lastSeq >= state.LastSeq {
+		return
+	}
+	buffer := &replayBuffer{lastSeq: state.LastSeq, lastUsed: time.Now()}
+	for _, entry := range state.Entries {
+		// Payloads stay JSON, also for binary clients
+		var decoded struct {
+			WebSocketMessage
+			Data json.RawMessage `json:"data,omitempty"`
+		}
+		if err := json.Unmarshal(entry.Message, &decoded); err != nil {
+			continue
+		}
+		message := decoded.WebSocketMessage
+		if len(decoded.Data) > 0 {
+			message.Data = decoded.Data
+		}
+		h.replayBytes += buffer.add(replayEntry{
+			seq:         entry.Seq,
+			frame:       &encodedFrame{message: message, text: entry.Message},
+			peerID:      entry.PeerID,
+			userID:      entry.UserID,
+			excludePeer: entry.ExcludePeer,
+		}, h.replayMaxBytes)
+	}
+	h.dropReplay(state.MeetingID)
+	h.replay[state.MeetingID] = buffer
+	if h.replayBytes > h.replayLimit {
+		h.shrinkReplay()
+	}
+}
+
+// HandOver drains every shard and returns the state of their meetings. Safe
+// to call from any goroutine.
+func (h *Hub) HandOver(message WebSocketMessage) []meetingHandover {
+	if message.Timestamp.IsZero() {
+		message.Timestamp = time.Now()
+	}
+	var states []meetingHandover
+	for _, shard := range h.shards {
+		reply := make(chan []meetingHandover, 1)
+		shard.handovers <- handoverRequest{message: message, reply: reply}
+		states = append(states, <-reply...)
+	}
+	return states
+}
+
+// HandOverMeeting hands one meeting over and returns its state. Safe to call
+// from any goroutine.
+func (h *Hub) HandOverMeeting(meetingID string, message WebSocketMessage) meetingHandover {
+	if message.Timestamp.IsZero() {
+		message.Timestamp = time.Now()
+	}
+	reply := make(chan []meetingHandover, 1)
+	h.shardFor(meetingID).handovers <- handoverRequest{meetingID: meetingID, message: message, reply: reply}
+	return (<-reply)[0]
+}
+
+// Adopt gives a meeting's handed over state to its shard and waits until it
+// is installed. Safe to call from any goroutine.
+func (h *Hub) Adopt(state meetingHandover) {
+	done := make(chan struct{})
+	h.shardFor(state.MeetingID).adoptions <- adoptRequest{state: state, done: done}
+	<-done
+}
+
+// drainInstance hands every meeting over and disconnects its clients, which
+// reconnect to reconnectURL, or where they connected when it is empty. It
+// returns how many meetings were handed over.
+func drainInstance(ctx context.Context, reconnectURL string) (int, error) {
+	if !draining.CompareAndSwap(false, true) {
+		return 0, errAlreadyDraining
+	}
+
+	data := map[string]interface{}{
+		"reason":   "server-drain",
+		"windowMs": time.Duration(appConfig.Handover.ReconnectWindow).Milliseconds(),
+	}
+	if reconnectURL != "" {
+		data["url"] = reconnectURL
+	}
+	states := hub.HandOver(WebSocketMessage{Type: "reconnect", Data: data})
+	if err := saveHandovers(ctx, states); err != nil {
+		return len(states), err
+	}
+	log.Printf("Handover: drained %d meetings", len(states))
+	return len(states), nil
+}
+
+// saveHandovers stores the meetings' state, with their screen shares, for
+// the instance their clients reconnect to
+func saveHandovers(ctx context.Context, states []meetingHandover) error {
+	// Screen shares stay with their presenters for the grants' tokens to
+	// keep working after the reconnect
+	for i := range states {
+		for _, grant := range screenShares.Active(states[i].MeetingID) {
+			states[i].ScreenShares = append(states[i].ScreenShares, handoverGrant{
+				Token:     grant.Token,
+				UserID:    grant.UserID,
+				PeerID:    grant.PeerID,
+				StartedAt: grant.StartedAt,
+			})
+		}
+	}
+
+	if handoverStore != nil && len(states) > 0 {
+		ttl := time.Duration(appConfig.Handover.StateTTL)
+		pipe := handoverStore.Pipeline()
+		for _, state := range states {
+			payload, err := json.Marshal(state)
+			if err != nil {
+				log.Printf("Handover: error encoding meeting %s: %v", state.MeetingID, err)
+				continue
+			}
+			pipe.Set(ctx, handoverKeyPrefix+state.MeetingID, payload, ttl)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// adoptHandover takes over the state a drained instance left for the
+// meeting, if any. The state is taken once; clients of the meeting
+// reconnecting later find it in the hub.
+func adoptHandover(ctx context.Context, meetingID string) {
+	if handoverStore == nil {
+		return
+	}
+	payload, err := handoverStore.GetDel(ctx, handoverKeyPrefix+meetingID).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return
+	}
+	if err != nil {
+		log.Printf("Handover: error loading meeting %s: %v", meetingID, err)
+		return
+	}
+	var state meetingHandover
+	if err := json.Unmarshal(payload, &state); err != nil {
+		log.Printf("Handover: error decoding meeting %s: %v", meetingID, err)
+		return
+	}
+
+	hub.Adopt(state)
+	for _, grant := range state.ScreenShares {
+		screenShares.Reserve(meetingID, grant, time.Duration(appConfig.Handover.StateTTL))
+	}
+}
+
+// adminDrainHandler drains the instance; the body may name the URL clients
+// reconnect to in place of the configured one
+func adminDrainHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ReconnectURL string `json:"reconnectUrl"`
+	}
+	if r.ContentLength != 0 && !decodeStrictJSONBody(w, r, &req) {
+		return
+	}
+	if req.ReconnectURL == "" {
+		req.ReconnectURL = appConfig.Handover.ReconnectURL
+	}
+
+	meetings, err := drainInstance(r.Context(), req.ReconnectURL)
+	if errors.Is(err, errAlreadyDraining) {
+		sendCodedError(w, ErrCodeConflict, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Handover: error saving meeting state: %v", err)
+		sendErrorResponse(w, "Clients were disconnected but their meeting state could not be saved", http.StatusInternalServerError)
+		return
+	}
+
+	admin := adminFromContext(r.Context())
+	recordSecurityEvent(r.Context(), SecurityEvent{
+		Type:    SecurityInstanceDrained,
+		IP:      getClientIP(r),
+		ActorID: admin.ID,
+		Details: map[string]interface{}{"meetings": meetings, "reconnectUrl": req.ReconnectURL},
+	})
+	sendSuccessResponse(w, map[string]interface{}{"meetings": meetings})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"video-meeting-app/db"
+)
+
+// Participant statuses, by the age of their last heartbeat
+const (
+	ParticipantActive       = "active"
+	ParticipantInactive     = "inactive"
+	ParticipantDisconnected = "disconnected" // past the timeout; the cleanup job will mark them left
+	ParticipantLeft         = "left"
+)
+
+// participantIdleTimeout is how long a participant may go without a
+// heartbeat before they are listed as inactive
+func participantIdleTimeout() time.Duration {
+	return time.Duration(appConfig.Meetings.ParticipantIdleTimeout)
+}
+
+// participantStatus reports whether a participant is still sending heartbeats
+func participantStatus(participant Participant, now time.Time) string {
+	if participant.LeftAt != nil {
+		return ParticipantLeft
+	}
+	age := now.Sub(participant.LastActive)
+	switch {
+	case age <= participantIdleTimeout():
+		return ParticipantActive
+	case age <= participantTimeout():
+		return ParticipantInactive
+	default:
+		return ParticipantDisconnected
+	}
+}
+
+// heartbeatHandler refreshes the caller's heartbeat in a meeting, for clients
+// that are not connected over the WebSocket, whose pongs refresh it
+func heartbeatHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	meeting, err := findMeeting(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+	if !meeting.IsActive {
+		sendCodedError(w, ErrCodeMeetingEnded, "Meeting has ended")
+		return
+	}
+
+	// A participant marked left must join again rather than be revived here
+	now := time.Now()
+	result, err := db.Participants.UpdateOne(
+		r.Context(),
+		bson.M{"meetingId": meeting.ID, "userId": userID, "leftAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"lastActive": now}},
+	)
+	if err != nil {
+		sendErrorResponse(w, "Failed to record heartbeat", http.StatusInternalServerError)
+		return
+	}
+	if result.MatchedCount == 0 {
+		sendErrorResponse(w, "Not a participant of this meeting; join it again", http.StatusNotFound)
+		return
+	}
+
+	sendSuccessResponse(w, map[string]interface{}{
+		"status":             ParticipantActive,
+		"lastActive":         now,
+		"idleTimeoutSeconds": int(participantIdleTimeout().Seconds()),
+	})
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"video-meeting-app/db"
+)
+
+// isParticipant reports whether the user already has a participant record in the meeting
+func isParticipant(ctx context.Context, meetingID, userID string) bool {
+	count, err := db.Participants.CountDocuments(
+		ctx,
+		bson.M{"meetingId": meetingID, "userId": userID},
+	)
+	if err != nil {
+		log.Printf("Error checking participant: %v", err)
+		return false
+	}
+	return count > 0
+}
+
+// canEnterMeeting reports whether the user may join or connect to the meeting.
+// Locked meetings only admit the host and users who joined before the lock.
+func canEnterMeeting(ctx context.Context, meeting Meeting, userID string) bool {
+	if !meeting.IsLocked || meeting.CreatedBy == userID {
+		return true
+	}
+	return isParticipant(ctx, meeting.ID, userID)
+}
+
+// lockMeetingHandler returns a handler that locks or unlocks a meeting for new joins
+func lockMeetingHandler(locked bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		meetingID := vars["id"]
+		userID := getUserIDFromToken(r)
+		if userID == "" {
+			sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		meeting, err := findMeeting(r.Context(), meetingID)
+		if err != nil {
+			sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+			return
+		}
+		meetingID = meeting.ID
+		if meeting.CreatedBy != userID {
+			sendCodedError(w, ErrCodeHostOnly, "Only the host can lock the meeting")
+			return
+		}
+
+		_, err = db.Meetings.UpdateOne(
+			r.Context(),
+			bson.M{"_id": meetingID},
+			bson.M{"$set": bson.M{"isLocked": locked, "updatedAt": time.Now()}},
+		)
+		if err != nil {
+			log.Printf("Error updating meeting lock: %v", err)
+			sendErrorResponse(w, "Failed to update meeting", http.StatusInternalServerError)
+			return
+		}
+		meetingLookups.Invalidate(r.Context(), meetingID)
+
+		eventType := "meeting-unlocked"
+		if locked {
+			eventType = "meeting-locked"
+		}
+		hub.BroadcastToMeeting(meetingID, WebSocketMessage{
+			Type:   eventType,
+			Data:   map[string]bool{"isLocked": locked},
+			UserID: userID,
+		}, nil)
+
+		sendSuccessResponse(w, map[string]interface{}{"id": meetingID, "isLocked": locked})
+	}
+}
+
+// endMeetingHandler ends the meeting for everyone: it deactivates the meeting,
+// notifies and disconnects all connected clients, and removes participants
+func endMeetingHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	meetingID := vars["id"]
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	meeting, err := findMeeting(r.Context(), meetingID)
+	if err != nil {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+	meetingID = meeting.ID
+	if meeting.CreatedBy != userID {
+		sendCodedError(w, ErrCodeHostOnly, "Only the host can end the meeting")
+		return
+	}
+	if !meeting.IsActive {
+		sendErrorResponse(w, "Meeting has already ended", http.StatusConflict)
+		return
+	}
+
+	now := time.Now()
+	ended, err := finishMeeting(r.Context(), meeting, userID, map[string]interface{}{"endedBy": userID, "endedAt": now}, now)
+	if err != nil {
+		log.Printf("Error ending meeting: %v", err)
+		sendErrorResponse(w, "Failed to end meeting", http.StatusInternalServerError)
+		return
+	}
+	if !ended {
+		sendErrorResponse(w, "Meeting has already ended", http.StatusConflict)
+		return
+	}
+
+	sendSuccessResponse(w, map[string]interface{}{"id": meetingID, "isActive": false, "endedAt": now})
+}
+
+// finishMeeting deactivates an active meeting, notifies and disconnects its
+// clients and removes its participants. details describe the end to clients
+// and subscribers of the meeting ended event. It reports false if the
+// meeting had already ended.
+func finishMeeting(ctx context.Context, meeting Meeting, endedBy string, details map[string]interface{}, now time.Time) (bool, error) {
+	result, err := db.Meetings.UpdateOne(
+		ctx,
+		bson.M{"_id": meeting.ID, "isActive": true},
+		bson.M{"$set": bson.M{"isActive": false, "updatedAt": now}, "$unset": bson.M{"recording": ""}},
+	)
+	if err != nil {
+		return false, err
+	}
+	meetingLookups.Invalidate(ctx, meeting.ID)
+	if result.ModifiedCount == 0 {
+		return false, nil
+	}
+
+	screenShares.ReleaseMeeting(meeting.ID)
+	hub.EndMeeting(meeting.ID, WebSocketMessage{
+		Type:      "meeting-ended",
+		Data:      details,
+		UserID:    endedBy,
+		Timestamp: now,
+	})
+
+	recordMeetingEndAttendance(ctx, meeting.ID, now)
+	publishMeetingEvent(ctx, meeting, DomainMeetingEnded, endedBy, details)
+
+	removed, err := db.Participants.DeleteMany(ctx, bson.M{"meetingId": meeting.ID})
+	if err != nil {
+		log.Printf("Error removing participants for ended meeting %s: %v", meeting.ID, err)
+	} else {
+		log.Printf("Meeting %s ended, removed %d participants", meeting.ID, removed.DeletedCount)
+	}
+	if _, err := db.OverflowQueue.DeleteMany(ctx, bson.M{"meetingId": meeting.ID}); err != nil {
+		log.Printf("Error clearing the queue of ended meeting %s: %v", meeting.ID, err)
+	}
+	return true, nil
+}
+
+func setSpotlightHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		UserID string `json:"userId"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if req.UserID == "" {
+		sendErrorResponse(w, "userId is required", http.StatusBadRequest)
+		return
+	}
+	updateSpotlight(w, r, req.UserID)
+}
+
+func clearSpotlightHandler(w http.ResponseWriter, r *http.Request) {
+	updateSpotlight(w, r, "")
+}
+
+// updateSpotlight records the spotlighted participant on the meeting (or
+// clears it when spotlightUserID is empty) and broadcasts the change
+func updateSpotlight(w http.ResponseWriter, r *http.Request, spotlightUserID string) {
+	vars := mux.Vars(r)
+	meetingID := vars["id"]
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	meeting, err := findMeeting(r.Context(), meetingID)
+	if err != nil {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+	meetingID = meeting.ID
+	if !hasPermission(r.Context(), meeting, userID, PermissionSpotlight) {
+		sendCodedError(w, ErrCodePermissionDenied, permissionDeniedMessage(PermissionSpotlight))
+		return
+	}
+
+	update := bson.M{"$set": bson.M{"spotlightUserId": spotlightUserID, "updatedAt": time.Now()}}
+	if spotlightUserID == "" {
+		update = bson.M{
+			"$set":   bson.M{"updatedAt": time.Now()},
+			"$unset": bson.M{"spotlightUserId": ""},
+		}
+	} else if !isParticipant(r.Context(), meetingID, spotlightUserID) {
+		sendErrorResponse(w, "Participant not found", http.StatusNotFound)
+		return
+	}
+
+	if _, err := db.Meetings.UpdateOne(r.Context(), bson.M{"_id": meetingID}, update); err != nil {
+		log.Printf("Error updating spotlight: %v", err)
+		sendErrorResponse(w, "Failed to update spotlight", http.StatusInternalServerError)
+		return
+	}
+	meetingLookups.Invalidate(r.Context(), meetingID)
+
+	hub.BroadcastToMeeting(meetingID, WebSocketMessage{
+		Type:   "spotlight-changed",
+		Data:   map[string]string{"spotlightUserId": spotlightUserID},
+		UserID: userID,
+	}, nil)
+
+	sendSuccessResponse(w, map[string]string{"id": meetingID, "spotlightUserId": spotlightUserID})
+}
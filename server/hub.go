@@ -0,0 +1,161 @@
+package main
+
+import (
+	"hash/fnv"
+	"time"
+
+	"video-meeting-app/config"
+)
+
+// Hub routes WebSocket messages to clients. Meetings are spread over shards
+// by a hash of their ID, each with its own goroutine, so one huge meeting
+// can't stall signaling for every other room.
+type Hub struct {
+	cfg    *config.Config
+	shards []*hubShard
+}
+
+func newHub(cfg *config.Config) *Hub {
+	h := &Hub{cfg: cfg, shards: make([]*hubShard, max(cfg.Server.HubShards, 1))}
+	// Each shard holds its meetings' share of the replay memory cap
+	replayLimit := cfg.Hub.ReplayTotalMaxBytes / len(h.shards)
+	for i := range h.shards {
+		h.shards[i] = newHubShard(replayLimit, cfg.Hub.ReplayMaxBytes)
+	}
+	return h
+}
+
+// run starts every shard's goroutine
+func (h *Hub) run() {
+	for _, shard := range h.shards {
+		go shard.run()
+	}
+}
+
+// shardFor returns the shard that owns a meeting
+func (h *Hub) shardFor(meetingID string) *hubShard {
+	hash := fnv.New32a()
+	hash.Write([]byte(meetingID))
+	return h.shards[hash.Sum32()%uint32(len(h.shards))]
+}
+
+// Register adds a client to its meeting's shard
+func (h *Hub) Register(client *Client) {
+	h.shardFor(client.meetingID).register <- client
+}
+
+// Unregister removes a client and closes its send queue
+func (h *Hub) Unregister(client *Client) {
+	h.shardFor(client.meetingID).unregister <- client
+}
+
+// Alive reports whether every shard is still processing events
+func (h *Hub) Alive() bool {
+	for _, shard := range h.shards {
+		last := shard.lastBeat.Load()
+		if last == 0 || time.Since(time.Unix(0, last)) >= 3*HubHeartbeatInterval {
+			return false
+		}
+	}
+	return true
+}
+
+// BroadcastToMeeting queues a message for every client in a meeting. Safe to
+// call from any goroutine.
+func (h *Hub) BroadcastToMeeting(meetingID string, message WebSocketMessage, excludeClient *Client) {
+	if message.MeetingID == "" {
+		message.MeetingID = meetingID
+	}
+	if message.Timestamp.IsZero() {
+		message.Timestamp = time.Now()
+	}
+	h.shardFor(meetingID).outbound <- &hubMessage{meetingID: meetingID, message: message, exclude: excludeClient}
+}
+
+// EndMeeting queues a final message for every client in a meeting and then
+// closes their connections. Safe to call from any goroutine.
+func (h *Hub) EndMeeting(meetingID string, message WebSocketMessage) {
+	if message.MeetingID == "" {
+		message.MeetingID = meetingID
+	}
+	if message.Timestamp.IsZero() {
+		message.Timestamp = time.Now()
+	}
+	h.shardFor(meetingID).outbound <- &hubMessage{meetingID: meetingID, message: message, disconnect: true}
+}
+
+// RelayToMeeting queues a message about a change made elsewhere, such as on
+// another server instance, for a meeting's clients on this instance. Unlike
+// BroadcastToMeeting it does nothing when the meeting has no clients here.
+// With end set the clients are then disconnected, as by EndMeeting. Safe to
+// call from any goroutine.
+func (h *Hub) RelayToMeeting(meetingID string, message WebSocketMessage, end bool) {
+	if message.MeetingID == "" {
+		message.MeetingID = meetingID
+	}
+	if message.Timestamp.IsZero() {
+		message.Timestamp = time.Now()
+	}
+	h.shardFor(meetingID).outbound <- &hubMessage{meetingID: meetingID, message: message, disconnect: end, connectedOnly: true}
+}
+
+// SendToClient queues a message for a single client. Safe to call from any
+// goroutine.
+func (h *Hub) SendToClient(client *Client, message WebSocketMessage) {
+	if message.MeetingID == "" {
+		message.MeetingID = client.meetingID
+	}
+	if message.Timestamp.IsZero() {
+		message.Timestamp = time.Now()
+	}
+	h.shardFor(client.meetingID).outbound <- &hubMessage{message: message, target: client}
+}
+
+// SendToUser queues a message for every connection the user has open, in any
+// meeting. The user's meetings may be on any shard, so every shard gets it.
+// Safe to call from any goroutine.
+func (h *Hub) SendToUser(userID string, message WebSocketMessage) {
+	if message.Timestamp.IsZero() {
+		message.Timestamp = time.Now()
+	}
+	for _, shard := range h.shards {
+		shard.outbound <- &hubMessage{message: message, targetUser: userID}
+	}
+}
+
+// SendToUserInMeeting queues a message for the user's connections in one
+// meeting. Safe to call from any goroutine.
+func (h *Hub) SendToUserInMeeting(meetingID, userID string, message WebSocketMessage) {
+	if message.MeetingID == "" {
+		message.MeetingID = meetingID
+	}
+	if message.Timestamp.IsZero() {
+		message.Timestamp = time.Now()
+	}
+	h.shardFor(meetingID).outbound <- &hubMessage{meetingID: meetingID, message: message, targetUser: userID}
+}
+
+// DisconnectUser queues a final message for the user's connections in a
+// meeting and then closes them, telling the rest of the meeting they left.
+// Safe to call from any goroutine.
+func (h *Hub) DisconnectUser(meetingID, userID string, message WebSocketMessage) {
+	if message.MeetingID == "" {
+		message.MeetingID = meetingID
+	}
+	if message.Timestamp.IsZero() {
+		message.Timestamp = time.Now()
+	}
+	h.shardFor(meetingID).outbound <- &hubMessage{meetingID: meetingID, message: message, targetUser: userID, disconnect: true}
+}
+
+// SendToPeer queues a message for the client with the given peer ID in a
+// meeting. Safe to call from any goroutine.
+func (h *Hub) SendToPeer(meetingID, peerID string, message WebSocketMessage) {
+	if message.MeetingID == "" {
+		message.MeetingID = meetingID
+	}
+	if message.Timestamp.IsZero() {
+		message.Timestamp = time.Now()
+	}
+	h.shardFor(meetingID).outbound <- &hubMessage{meetingID: meetingID, message: message, targetPeer: peerID}
+}
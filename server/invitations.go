@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+)
+
+const (
+	DefaultInvitationTTL  = 7 * 24 * time.Hour
+	MaxInvitationTTL      = 30 * 24 * time.Hour
+	MaxInvitationsPerCall = 50
+)
+
+// Invitation statuses
+const (
+	InvitationSent     = "sent"
+	InvitationAccepted = "accepted"
+	InvitationDeclined = "declined"
+)
+
+// Invitation records an emailed invite to a meeting
+type Invitation struct {
+	ID          string     `json:"id" bson:"_id"`
+	MeetingID   string     `json:"meetingId" bson:"meetingId"`
+	Email       string     `json:"email" bson:"email"`
+	InvitedBy   string     `json:"invitedBy" bson:"invitedBy"`
+	Status      string     `json:"status" bson:"status"`
+	ExpiresAt   time.Time  `json:"expiresAt" bson:"expiresAt"`
+	CreatedAt   time.Time  `json:"createdAt" bson:"createdAt"`
+	RespondedAt *time.Time `json:"respondedAt,omitempty" bson:"respondedAt,omitempty"`
+}
+
+// invitationToken returns the signed token embedded in an invitation join
+// link. The signed payload is prefixed so other signed tokens cannot be
+// passed off as invitation tokens.
+func invitationToken(invitation Invitation) string {
+	payload := invitation.ID + "." + strconv.FormatInt(invitation.ExpiresAt.Unix(), 10)
+	return payload + "." + signValue("invite."+payload)
+}
+
+// parseInvitationToken verifies a token's signature and expiry and returns the invitation ID
+func parseInvitationToken(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("malformed invitation token")
+	}
+	payload := parts[0] + "." + parts[1]
+	if !verifySignature("invite."+payload, parts[2]) {
+		return "", fmt.Errorf("invalid invitation signature")
+	}
+	expiresAt, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("malformed invitation token")
+	}
+	if time.Now().Unix() > expiresAt {
+		return "", fmt.Errorf("invitation has expired")
+	}
+	return parts[0], nil
+}
+
+// invitationJoinURL returns the signed link sent to the invitee
+func invitationJoinURL(meeting Meeting, invitation Invitation) string {
+	return meetingJoinURL(meeting) + "?invite=" + url.QueryEscape(invitationToken(invitation))
+}
+
+func createInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	meetingID := vars["id"]
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	meeting, err := findMeeting(r.Context(), meetingID)
+	if err != nil {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+	meetingID = meeting.ID
+	if meeting.CreatedBy != userID {
+		sendCodedError(w, ErrCodeHostOnly, "Only the host can invite people")
+		return
+	}
+
+	var req struct {
+		Emails         []string `json:"emails"`
+		ExpiresInHours int      `json:"expiresInHours,omitempty"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+
+	var emails []string
+	for _, email := range req.Emails {
+		email = strings.ToLower(strings.TrimSpace(email))
+		if !validateEmail(email) {
+			sendErrorResponse(w, fmt.Sprintf("Invalid email: %s", email), http.StatusBadRequest)
+			return
+		}
+		emails = append(emails, email)
+	}
+	emails = uniqueStrings(emails)
+	if len(emails) == 0 || len(emails) > MaxInvitationsPerCall {
+		sendErrorResponse(w, "Between 1 and 50 emails are required", http.StatusBadRequest)
+		return
+	}
+
+	ttl := DefaultInvitationTTL
+	if req.ExpiresInHours > 0 {
+		ttl = min(time.Duration(req.ExpiresInHours)*time.Hour, MaxInvitationTTL)
+	}
+
+	var host User
+	db.Users.FindOne(r.Context(), bson.M{"_id": userID}).Decode(&host)
+
+	now := time.Now()
+	invitations := make([]Invitation, 0, len(emails))
+	for _, email := range emails {
+		// Re-inviting the same address refreshes the existing invitation
+		invitation := Invitation{
+			ID:        uuid.New().String(),
+			MeetingID: meetingID,
+			Email:     email,
+			InvitedBy: userID,
+			Status:    InvitationSent,
+			ExpiresAt: now.Add(ttl),
+			CreatedAt: now,
+		}
+		err := db.Invitations.FindOneAndUpdate(
+			r.Context(),
+			bson.M{"meetingId": meetingID, "email": email},
+			bson.M{
+				"$set": bson.M{
+					"invitedBy": userID,
+					"status":    InvitationSent,
+					"expiresAt": invitation.ExpiresAt,
+				},
+				"$unset":       bson.M{"respondedAt": ""},
+				"$setOnInsert": bson.M{"_id": invitation.ID, "createdAt": now},
+			},
+			options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+		).Decode(&invitation)
+		if err != nil {
+			log.Printf("Error saving invitation for %s: %v", email, err)
+			sendErrorResponse(w, "Failed to create invitations", http.StatusInternalServerError)
+			return
+		}
+
+		err = mail.EnqueueTemplate(email, "invitation", map[string]string{
+			"HostName":     host.Name,
+			"MeetingTitle": meeting.Title,
+			"JoinURL":      invitationJoinURL(meeting, invitation),
+			"ExpiresAt":    invitation.ExpiresAt.UTC().Format(time.RFC1123),
+		})
+		if err != nil {
+			log.Printf("Error sending invitation email to %s: %v", email, err)
+		}
+
+		invitations = append(invitations, invitation)
+	}
+
+	// Existing users become invitees so they can see private meetings
+	cursor, err := db.Users.Find(r.Context(), bson.M{"email": bson.M{"$in": emails}})
+	if err == nil {
+		var users []User
+		if err := cursor.All(r.Context(), &users); err == nil && len(users) > 0 {
+			ids := make([]string, 0, len(users))
+			for _, user := range users {
+				ids = append(ids, user.ID)
+				sendPushToUser(user.ID, PushNotification{
+					Type:  PushInvited,
+					Title: "You were invited",
+					Body:  fmt.Sprintf("%s invited you to \"%s\"", host.Name, meeting.Title),
+					Data:  map[string]string{"meetingId": meetingID, "joinUrl": meetingJoinURL(meeting)},
+				})
+			}
+			db.Meetings.UpdateOne(
+				r.Context(),
+				bson.M{"_id": meetingID},
+				bson.M{"$addToSet": bson.M{"invitees": bson.M{"$each": ids}}},
+			)
+			meetingLookups.Invalidate(r.Context(), meetingID)
+		}
+	}
+
+	sendSuccessResponse(w, invitations)
+}
+
+func getInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	meetingID := vars["id"]
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	meeting, err := findMeeting(r.Context(), meetingID)
+	if err != nil {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+	if meeting.CreatedBy != userID {
+		sendCodedError(w, ErrCodeHostOnly, "Only the host can view invitations")
+		return
+	}
+
+	cursor, err := db.Invitations.Find(
+		r.Context(),
+		bson.M{"meetingId": meeting.ID},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}),
+	)
+	if err != nil {
+		sendErrorResponse(w, "Failed to fetch invitations", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(r.Context())
+
+	invitations := []Invitation{}
+	if err := cursor.All(r.Context(), &invitations); err != nil {
+		sendErrorResponse(w, "Failed to parse invitations", http.StatusInternalServerError)
+		return
+	}
+
+	sendSuccessResponse(w, invitations)
+}
+
+// respondInvitationHandler returns a handler that accepts or declines the
+// invitation identified by a signed token
+func respondInvitationHandler(status string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		invitationID, err := parseInvitationToken(vars["token"])
+		if err != nil {
+			sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		var invitation Invitation
+		err = db.Invitations.FindOneAndUpdate(
+			r.Context(),
+			bson.M{"_id": invitationID},
+			bson.M{"$set": bson.M{"status": status, "respondedAt": now}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&invitation)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				sendErrorResponse(w, "Invitation not found", http.StatusNotFound)
+			} else {
+				log.Printf("Error updating invitation: %v", err)
+				sendErrorResponse(w, "Failed to update invitation", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		meeting, err := findMeeting(r.Context(), invitation.MeetingID)
+		if err != nil {
+			sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+			return
+		}
+
+		// A signed-in user accepting the invite gains access to the meeting
+		if userID := getUserIDFromToken(r); userID != "" && status == InvitationAccepted {
+			db.Meetings.UpdateOne(
+				r.Context(),
+				bson.M{"_id": meeting.ID},
+				bson.M{"$addToSet": bson.M{"invitees": userID}},
+			)
+			meetingLookups.Invalidate(r.Context(), meeting.ID)
+		}
+
+		sendSuccessResponse(w, map[string]interface{}{
+			"invitation": invitation,
+			"meeting":    meeting,
+		})
+	}
+}
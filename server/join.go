@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+)
+
+// errCodeIllegalOperation is returned for transactions on a standalone server
+const errCodeIllegalOperation = 20
+
+// joinError is a join refused for a reason the client should see
+type joinError struct {
+	code    ErrorCode
+	message string
+}
+
+func (e *joinError) Error() string { return e.message }
+
+var transactionsUnsupported sync.Once
+
+// inJoinTransaction runs fn in a transaction. fn should start with
+// claimMeetingSeats, so concurrent changes to who holds the meeting's seats
+// conflict on the meeting and retry instead of both taking the last seat.
+// Standalone MongoDB servers have no transactions; there fn runs without
+// one.
+func inJoinTransaction(ctx context.Context, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	if db.Client == nil {
+		return db.InTransaction(ctx, fn)
+	}
+	session, err := db.Client.StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+
+	result, err := session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return fn(sc)
+	})
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Code == errCodeIllegalOperation {
+		transactionsUnsupported.Do(func() {
+			log.Printf("MongoDB transactions are unavailable; joins are not protected against capacity races")
+		})
+		return fn(ctx)
+	}
+	return result, err
+}
+
+// claimMeetingSeats loads the meeting, bumping its joinSeq so transactions
+// deciding who gets its seats conflict
+func claimMeetingSeats(ctx context.Context, meetingID string) (Meeting, error) {
+	var meeting Meeting
+	err := db.Meetings.FindOneAndUpdate(ctx,
+		bson.M{"_id": meetingID},
+		bson.M{"$inc": bson.M{"joinSeq": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&meeting)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return meeting, &joinError{ErrCodeMeetingNotFound, "Meeting not found"}
+	}
+	return meeting, err
+}
+
+// admitParticipant re-checks that the meeting can take the participant and
+// inserts it, returning how many participants were present before. Seats
+// held for users let in from the overflow queue count as taken by others.
+func admitParticipant(ctx context.Context, participant Participant) (int64, error) {
+	admit := func(ctx context.Context) (interface{}, error) {
+		meeting, err := claimMeetingSeats(ctx, participant.MeetingID)
+		if err != nil {
+			return nil, err
+		}
+		if !meeting.IsActive {
+			return nil, &joinError{ErrCodeMeetingEnded, "Meeting has ended"}
+		}
+		if meeting.EndsAt != nil && !time.Now().Before(*meeting.EndsAt) {
+			return nil, &joinError{ErrCodeMeetingTimeLimit, "Meeting has reached its time limit"}
+		}
+		if !canEnterMeeting(ctx, meeting, participant.UserID) {
+			return nil, &joinError{ErrCodeMeetingLocked, "Meeting is locked"}
+		}
+
+		// Clear a previous record left behind by a disconnect so the user can rejoin
+		if _, err := db.Participants.DeleteOne(ctx, bson.M{
+			"meetingId": meeting.ID,
+			"userId":    participant.UserID,
+			"leftAt":    bson.M{"$exists": true},
+		}); err != nil {
+			return nil, err
+		}
+
+		present, err := db.Participants.CountDocuments(ctx, bson.M{
+			"meetingId": meeting.ID,
+			"leftAt":    bson.M{"$exists": false},
+		})
+		if err != nil {
+			return nil, err
+		}
+		held, err := heldOverflowSeats(ctx, meeting.ID, participant.UserID, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if meeting.MaxParticipants > 0 && present+held >= int64(meeting.MaxParticipants) {
+			return nil, &joinError{ErrCodeCapacityReached, "Meeting is full"}
+		}
+
+		if _, err := db.Participants.InsertOne(ctx, participant); err != nil {
+			return nil, err
+		}
+		// A seat held for the user is taken now
+		if _, err := db.OverflowQueue.DeleteOne(ctx, bson.M{"meetingId": meeting.ID, "userId": participant.UserID}); err != nil {
+			return nil, err
+		}
+		return present, nil
+	}
+
+	present, err := inJoinTransaction(ctx, admit)
+	if err != nil {
+		return 0, err
+	}
+	return present.(int64), nil
+}
+
+// hasSeat reports whether the user may connect to the meeting: they are
+// present in it already, or it has a seat free for them. Connecting revives
+// a participant marked left, which takes a seat like a join does.
+func hasSeat(ctx context.Context, meeting Meeting, userID string) (bool, error) {
+	if meeting.MaxParticipants <= 0 {
+		return true, nil
+	}
+	mine, err := db.Participants.CountDocuments(ctx, bson.M{
+		"meetingId": meeting.ID,
+		"userId":    userID,
+		"leftAt":    bson.M{"$exists": false},
+	})
+	if err != nil || mine > 0 {
+		return mine > 0, err
+	}
+	present, err := db.Participants.CountDocuments(ctx, bson.M{
+		"meetingId": meeting.ID,
+		"leftAt":    bson.M{"$exists": false},
+	})
+	if err != nil {
+		return false, err
+	}
+	held, err := heldOverflowSeats(ctx, meeting.ID, userID, time.Now())
+	if err != nil {
+		return false, err
+	}
+	return present+held < int64(meeting.MaxParticipants), nil
+}
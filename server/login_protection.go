@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+)
+
+const (
+	// Failed attempts allowed before the account or IP is locked
+	AccountLockoutThreshold = 5
+	IPLockoutThreshold      = 20
+	// The first lockout lasts LockoutBaseDuration and doubles with every
+	// further failure, up to MaxLockoutDuration
+	LockoutBaseDuration = 1 * time.Minute
+	MaxLockoutDuration  = 1 * time.Hour
+)
+
+// LoginAttempt counts recent failed logins for one account or client IP
+type LoginAttempt struct {
+	ID          string     `json:"id" bson:"_id"` // "account:<email>" or "ip:<address>"
+	Failures    int        `json:"failures" bson:"failures"`
+	LastFailure time.Time  `json:"lastFailure" bson:"lastFailure"`
+	LockedUntil *time.Time `json:"lockedUntil,omitempty" bson:"lockedUntil,omitempty"`
+}
+
+func accountAttemptKey(email string) string {
+	return "account:" + email
+}
+
+func ipAttemptKey(ip string) string {
+	return "ip:" + clientNetwork(ip)
+}
+
+// lockoutDuration returns how long to lock after the given number of failures
+func lockoutDuration(failures, threshold int) time.Duration {
+	if failures < threshold {
+		return 0
+	}
+	doublings := failures - threshold
+	if doublings >= 16 {
+		return MaxLockoutDuration
+	}
+	return min(LockoutBaseDuration<<doublings, MaxLockoutDuration)
+}
+
+// loginLockedFor returns how long the account and IP must wait before the
+// next login attempt; zero when neither is locked
+func loginLockedFor(ctx context.Context, email, ip string, now time.Time) (time.Duration, error) {
+	cursor, err := db.LoginAttempts.Find(ctx, bson.M{
+		"_id":         bson.M{"$in": []string{accountAttemptKey(email), ipAttemptKey(ip)}},
+		"lockedUntil": bson.M{"$gt": now},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var locked []LoginAttempt
+	if err := cursor.All(ctx, &locked); err != nil {
+		return 0, err
+	}
+
+	var wait time.Duration
+	for _, attempt := range locked {
+		wait = max(wait, attempt.LockedUntil.Sub(now))
+	}
+	return wait, nil
+}
+
+// recordLoginFailure counts a failed login against the account and the IP and
+// locks whichever crossed its threshold
+func recordLoginFailure(ctx context.Context, email, userID, ip string, now time.Time) {
+	recordSecurityEvent(ctx, SecurityEvent{Type: SecurityLoginFailed, UserID: userID, Email: email, IP: ip, At: now})
+
+	targets := []struct {
+		key       string
+		threshold int
+		event     string
+	}{
+		{accountAttemptKey(email), AccountLockoutThreshold, SecurityAccountLocked},
+		{ipAttemptKey(ip), IPLockoutThreshold, SecurityIPLocked},
+	}
+
+	for _, target := range targets {
+		var attempt LoginAttempt
+		err := db.LoginAttempts.FindOneAndUpdate(
+			ctx,
+			bson.M{"_id": target.key},
+			bson.M{
+				"$inc": bson.M{"failures": 1},
+				"$set": bson.M{"lastFailure": now},
+			},
+			options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+		).Decode(&attempt)
+		if err != nil {
+			log.Printf("Error recording failed login for %s: %v", target.key, err)
+			continue
+		}
+
+		duration := lockoutDuration(attempt.Failures, target.threshold)
+		if duration == 0 {
+			continue
+		}
+		lockedUntil := now.Add(duration)
+		if _, err := db.LoginAttempts.UpdateOne(
+			ctx,
+			bson.M{"_id": target.key},
+			bson.M{"$set": bson.M{"lockedUntil": lockedUntil}},
+		); err != nil {
+			log.Printf("Error locking %s: %v", target.key, err)
+			continue
+		}
+
+		recordSecurityEvent(ctx, SecurityEvent{
+			Type:   target.event,
+			UserID: userID,
+			Email:  email,
+			IP:     ip,
+			Details: map[string]interface{}{
+				"failures":    attempt.Failures,
+				"lockedUntil": lockedUntil,
+			},
+			At: now,
+		})
+	}
+}
+
+// clearAccountLockout forgets an account's failed logins after a successful
+// login or an admin unlock. IP counters are left to expire on their own.
+func clearAccountLockout(ctx context.Context, email string) error {
+	_, err := db.LoginAttempts.DeleteOne(ctx, bson.M{"_id": accountAttemptKey(email)})
+	return err
+}
+
+// sendLoginLocked rejects a login attempt during a lockout
+func sendLoginLocked(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	sendCodedError(w, ErrCodeLoginLocked, fmt.Sprintf("Too many failed login attempts. Try again in %d seconds", seconds))
+}
+
+// unlockAccountHandler lets an admin clear an account's lockout
+func unlockAccountHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var user User
+	if err := db.Users.FindOne(r.Context(), bson.M{"_id": vars["id"]}).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			sendCodedError(w, ErrCodeUserNotFound, "User not found")
+		} else {
+			sendErrorResponse(w, "Database error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if err := clearAccountLockout(r.Context(), user.Email); err != nil {
+		log.Printf("Error unlocking account %s: %v", user.ID, err)
+		sendErrorResponse(w, "Failed to unlock account", http.StatusInternalServerError)
+		return
+	}
+
+	recordSecurityEvent(r.Context(), SecurityEvent{
+		Type:    SecurityAccountUnlocked,
+		UserID:  user.ID,
+		Email:   user.Email,
+		IP:      getClientIP(r),
+		ActorID: adminFromContext(r.Context()).ID,
+	})
+
+	sendSuccessResponse(w, map[string]string{"message": "Account unlocked"})
+}
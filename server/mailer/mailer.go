@@ -0,0 +1,178 @@
+// Package mailer sends templated email through a pluggable provider. Messages
+// are queued and delivered by a background worker at a bounded rate; sends
+// that fail are written to a dead-letter collection for inspection.
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/config"
+)
+
+// Message is a single email. HTML is optional; Text is always sent.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Provider delivers a message with the given sender address
+type Provider interface {
+	Name() string
+	Send(ctx context.Context, from string, msg Message) error
+}
+
+// DeadLetter records a message that could not be delivered
+type DeadLetter struct {
+	To       string    `bson:"to"`
+	Subject  string    `bson:"subject"`
+	Template string    `bson:"template,omitempty"`
+	Provider string    `bson:"provider"`
+	Error    string    `bson:"error"`
+	FailedAt time.Time `bson:"failedAt"`
+}
+
+type queuedMessage struct {
+	Message
+	template string
+}
+
+// Mailer queues messages and delivers them through its provider
+type Mailer struct {
+	provider    Provider
+	from        string
+	interval    time.Duration
+	queue       chan queuedMessage
+	deadLetters DeadLetters
+
+	mu       sync.Mutex
+	nextSend time.Time
+}
+
+// DeadLetters stores messages that could not be sent
+type DeadLetters interface {
+	InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error)
+}
+
+// New returns a mailer for cfg. Failed sends are stored in deadLetters when
+// it is non-nil and logged either way.
+func New(cfg config.MailConfig, deadLetters DeadLetters) (*Mailer, error) {
+	var provider Provider
+	switch cfg.ResolvedProvider() {
+	case config.MailProviderLog:
+		provider = logProvider{}
+	case config.MailProviderSMTP:
+		provider = newSMTPProvider(cfg)
+	case config.MailProviderSendGrid:
+		provider = newSendGridProvider(cfg.SendGridAPIKey)
+	default:
+		return nil, fmt.Errorf("unknown mail provider %q", cfg.Provider)
+	}
+
+	return &Mailer{
+		provider:    provider,
+		from:        cfg.From,
+		interval:    time.Minute / time.Duration(cfg.PerMinute),
+		queue:       make(chan queuedMessage, cfg.QueueSize),
+		deadLetters: deadLetters,
+	}, nil
+}
+
+// Provider returns the name of the provider in use
+func (m *Mailer) Provider() string {
+	return m.provider.Name()
+}
+
+// Enqueue schedules msg for delivery without blocking. A full queue
+// dead-letters the message.
+func (m *Mailer) Enqueue(msg Message) {
+	m.enqueue(queuedMessage{Message: msg})
+}
+
+// EnqueueTemplate renders the named template with data and schedules it
+func (m *Mailer) EnqueueTemplate(to, name string, data interface{}) error {
+	msg, err := Render(name, data)
+	if err != nil {
+		return err
+	}
+	msg.To = to
+	m.enqueue(queuedMessage{Message: msg, template: name})
+	return nil
+}
+
+func (m *Mailer) enqueue(msg queuedMessage) {
+	select {
+	case m.queue <- msg:
+	default:
+		m.deadLetter(context.Background(), msg, fmt.Errorf("mail queue is full"))
+	}
+}
+
+// Run delivers queued messages until ctx is cancelled
+func (m *Mailer) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-m.queue:
+			if err := m.wait(ctx); err != nil {
+				m.deadLetter(context.Background(), msg, err)
+				return
+			}
+			if err := m.provider.Send(ctx, m.from, msg.Message); err != nil {
+				m.deadLetter(ctx, msg, err)
+			}
+		}
+	}
+}
+
+// wait blocks until the rate limit allows another send
+func (m *Mailer) wait(ctx context.Context) error {
+	m.mu.Lock()
+	now := time.Now()
+	if m.nextSend.Before(now) {
+		m.nextSend = now
+	}
+	delay := m.nextSend.Sub(now)
+	m.nextSend = m.nextSend.Add(m.interval)
+	m.mu.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (m *Mailer) deadLetter(ctx context.Context, msg queuedMessage, sendErr error) {
+	log.Printf("Mailer: %s delivery to %s failed: %v", m.provider.Name(), msg.To, sendErr)
+	if m.deadLetters == nil {
+		return
+	}
+
+	letter := DeadLetter{
+		To:       msg.To,
+		Subject:  msg.Subject,
+		Template: msg.template,
+		Provider: m.provider.Name(),
+		Error:    sendErr.Error(),
+		FailedAt: time.Now(),
+	}
+	if _, err := m.deadLetters.InsertOne(ctx, letter); err != nil {
+		log.Printf("Mailer: failed to record dead letter for %s: %v", msg.To, err)
+	}
+}
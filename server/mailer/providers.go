@@ -0,0 +1,127 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"video-meeting-app/config"
+)
+
+const (
+	SendGridURL         = "https://api.sendgrid.com/v3/mail/send"
+	SendGridHTTPTimeout = 10 * time.Second
+)
+
+// logProvider prints messages instead of sending them, which keeps local
+// development working without a mail server
+type logProvider struct{}
+
+func (logProvider) Name() string { return config.MailProviderLog }
+
+func (logProvider) Send(ctx context.Context, from string, msg Message) error {
+	log.Printf("Email (not sent) from=%s to=%s subject=%q\n%s", from, msg.To, msg.Subject, msg.Text)
+	return nil
+}
+
+// smtpProvider sends through an SMTP relay, using STARTTLS when offered
+type smtpProvider struct {
+	addr string
+	auth smtp.Auth
+}
+
+func newSMTPProvider(cfg config.MailConfig) *smtpProvider {
+	var auth smtp.Auth
+	if cfg.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPHost)
+	}
+	return &smtpProvider{addr: cfg.SMTPHost + ":" + cfg.SMTPPort, auth: auth}
+}
+
+func (p *smtpProvider) Name() string { return config.MailProviderSMTP }
+
+func (p *smtpProvider) Send(ctx context.Context, from string, msg Message) error {
+	return smtp.SendMail(p.addr, p.auth, from, []string{msg.To}, buildMIME(from, msg))
+}
+
+// buildMIME encodes msg as a plain-text or multipart/alternative message
+func buildMIME(from string, msg Message) []byte {
+	// Strip header-breaking characters from user-controlled values
+	clean := strings.NewReplacer("\r", "", "\n", "")
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\n",
+		from, clean.Replace(msg.To), clean.Replace(msg.Subject))
+
+	if msg.HTML == "" {
+		fmt.Fprintf(&buf, "Content-Type: text/plain; charset=UTF-8\r\n\r\n%s", msg.Text)
+		return buf.Bytes()
+	}
+
+	var nonce [12]byte
+	rand.Read(nonce[:])
+	boundary := "mail-" + hex.EncodeToString(nonce[:])
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", boundary)
+	fmt.Fprintf(&buf, "--%s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n", boundary, msg.Text)
+	fmt.Fprintf(&buf, "--%s\r\nContent-Type: text/html; charset=UTF-8\r\n\r\n%s\r\n", boundary, msg.HTML)
+	fmt.Fprintf(&buf, "--%s--\r\n", boundary)
+	return buf.Bytes()
+}
+
+// sendGridProvider sends through the SendGrid v3 Mail Send API
+type sendGridProvider struct {
+	apiKey string
+	client *http.Client
+}
+
+func newSendGridProvider(apiKey string) *sendGridProvider {
+	return &sendGridProvider{apiKey: apiKey, client: &http.Client{Timeout: SendGridHTTPTimeout}}
+}
+
+func (p *sendGridProvider) Name() string { return config.MailProviderSendGrid }
+
+func (p *sendGridProvider) Send(ctx context.Context, from string, msg Message) error {
+	content := []map[string]string{{"type": "text/plain", "value": msg.Text}}
+	if msg.HTML != "" {
+		content = append(content, map[string]string{"type": "text/html", "value": msg.HTML})
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"personalizations": []map[string]interface{}{
+			{"to": []map[string]string{{"email": msg.To}}},
+		},
+		"from":    map[string]string{"email": from},
+		"subject": msg.Subject,
+		"content": content,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, SendGridURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("sendgrid responded with status %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Each template is templates/<name>.txt, holding the plain-text body and a
+// "<name>.subject" block, plus an optional templates/<name>.html body.
+//
+//go:embed templates/*
+var templateFS embed.FS
+
+var (
+	textTemplates = texttemplate.Must(texttemplate.ParseFS(templateFS, "templates/*.txt"))
+	htmlTemplates = htmltemplate.Must(htmltemplate.ParseFS(templateFS, "templates/*.html"))
+)
+
+// Render builds a message from the named template; the caller sets To
+func Render(name string, data interface{}) (Message, error) {
+	var subject, text bytes.Buffer
+
+	body := textTemplates.Lookup(name + ".txt")
+	if body == nil {
+		return Message{}, fmt.Errorf("unknown email template %q", name)
+	}
+	if err := body.Execute(&text, data); err != nil {
+		return Message{}, fmt.Errorf("rendering %s: %w", name, err)
+	}
+	if err := body.ExecuteTemplate(&subject, name+".subject", data); err != nil {
+		return Message{}, fmt.Errorf("rendering %s subject: %w", name, err)
+	}
+
+	msg := Message{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    text.String(),
+	}
+
+	if page := htmlTemplates.Lookup(name + ".html"); page != nil {
+		var html bytes.Buffer
+		if err := page.Execute(&html, data); err != nil {
+			return Message{}, fmt.Errorf("rendering %s html: %w", name, err)
+		}
+		msg.HTML = html.String()
+	}
+	return msg, nil
+}
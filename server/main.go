@@ -2,13 +2,17 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -16,18 +20,21 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	"github.com/pion/webrtc/v3"
-	"github.com/rs/cors"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/trace"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"golang.org/x/crypto/bcrypt"
 
+	"video-meeting-app/config"
+	"video-meeting-app/mailer"
+	"video-meeting-app/storage"
+	"video-meeting-app/wsproto"
 	"video-meeting-app/db"
 )
 
 // Configuration
 const (
-	DefaultPort           = "8080"
 	MaxRetries           = 3
 	RetryDelay           = 1 * time.Second
 	CookieName           = "session_token"
@@ -35,25 +42,29 @@ const (
 	WriteWait            = 10 * time.Second
 	PongWait             = 60 * time.Second
 	PingPeriod           = (PongWait * 9) / 10
-	ParticipantTimeout   = 5 * time.Minute
+	HubHeartbeatInterval = 5 * time.Second
+	IndexBuildTimeout    = 5 * time.Minute
 )
 
-// Updated allowed origins
-var allowedOrigins = []string{
-	"https://famous-sprite-14c531.netlify.app",
-	"https://google-meet-clone-lovat.vercel.app",
-	"https://google-meet-clone-ma9v.onrender.com",
-	"http://localhost:5173",
-	"http://localhost:3000",
-}
+// appConfig is loaded in main before any handler runs
+var appConfig = config.Default()
+
+// mail and blobStore are created in main once the database is connected
+var (
+	mail      *mailer.Mailer
+	blobStore storage.Store
+)
 
 // WebSocket upgrader
 var wsUpgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
+	// Preferred first; clients offering neither get JSON
+	Subprotocols: []string{wsproto.SubprotocolProto, wsproto.SubprotocolJSON},
+	// Organization origins only connect with embed tokens, not sessions
 	CheckOrigin: func(r *http.Request) bool {
-		origin := r.Header.Get("Origin")
-		return isAllowedOrigin(origin)
+		allowed, credentials := allowedOrigin(r, r.Header.Get("Origin"))
+		return allowed && (credentials || requestEmbedToken(r) != nil)
 	},
 }
 
@@ -65,19 +76,47 @@ type User struct {
 	Password  string    `json:"-" bson:"password"`
 	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"`
+	ReminderPreferences *ReminderPreferences `json:"reminderPreferences,omitempty" bson:"reminderPreferences,omitempty"`
+	IsAdmin   bool      `json:"isAdmin,omitempty" bson:"isAdmin,omitempty"`
+	AvatarURL string    `json:"avatarUrl,omitempty" bson:"avatarUrl,omitempty"`
+	AvatarKey string    `json:"-" bson:"avatarKey,omitempty"`
+	AdminRole string    `json:"adminRole,omitempty" bson:"adminRole,omitempty"`
+	Plan      string    `json:"plan,omitempty" bson:"plan,omitempty"`
+	DisabledAt     *time.Time `json:"disabledAt,omitempty" bson:"disabledAt,omitempty"`
+	DisabledReason string     `json:"disabledReason,omitempty" bson:"disabledReason,omitempty"`
+	// Set on service accounts to the user who manages them
+	ServiceAccountOf string `json:"serviceAccountOf,omitempty" bson:"serviceAccountOf,omitempty"`
 }
 
 type Meeting struct {
 	ID           string    `json:"id" bson:"_id"`
+	Code         string    `json:"code,omitempty" bson:"code,omitempty"`
 	Title        string    `json:"title" bson:"title"`
 	Description  string    `json:"description,omitempty" bson:"description,omitempty"`
 	CreatedBy    string    `json:"createdBy" bson:"createdBy"`
-	ScheduledFor string    `json:"scheduledFor,omitempty" bson:"scheduledFor,omitempty"`
+	ScheduledFor *time.Time `json:"scheduledFor,omitempty" bson:"scheduledFor,omitempty"`
 	CreatedAt    time.Time `json:"createdAt" bson:"createdAt"`
 	UpdatedAt    time.Time `json:"updatedAt" bson:"updatedAt"`
 	IsPrivate    bool      `json:"isPrivate" bson:"isPrivate"`
 	IsActive     bool      `json:"isActive" bson:"isActive"`
+	IsLocked     bool      `json:"isLocked" bson:"isLocked"`
 	MaxParticipants int    `json:"maxParticipants" bson:"maxParticipants"`
+	Invitees     []string  `json:"invitees,omitempty" bson:"invitees,omitempty"` // user IDs allowed to see a private meeting
+	RemindersSentAt *time.Time `json:"remindersSentAt,omitempty" bson:"remindersSentAt,omitempty"`
+	SpotlightUserID string `json:"spotlightUserId,omitempty" bson:"spotlightUserId,omitempty"`
+	OrgID        string    `json:"orgId,omitempty" bson:"orgId,omitempty"`
+	RecordingPolicy string `json:"recordingPolicy,omitempty" bson:"recordingPolicy,omitempty"`
+	StartedAt    *time.Time `json:"startedAt,omitempty" bson:"startedAt,omitempty"`
+	EndsAt       *time.Time `json:"endsAt,omitempty" bson:"endsAt,omitempty"` // time limit of the host's plan
+	TranscriptionEnabled bool `json:"transcriptionEnabled,omitempty" bson:"transcriptionEnabled,omitempty"`
+	E2EE         bool      `json:"e2ee,omitempty" bson:"e2ee,omitempty"` // media is end-to-end encrypted; fixed at creation
+	ChatFilter   string    `json:"chatFilter,omitempty" bson:"chatFilter,omitempty"` // off, moderate or strict; the configured default when empty
+	JoinSeq      int64     `json:"-" bson:"joinSeq,omitempty"` // bumped by each join so concurrent joins conflict
+	Recording    *RecordingSession `json:"recording,omitempty" bson:"recording,omitempty"` // set while the meeting is being recorded
+	IPRules      *MeetingIPRules `json:"-" bson:"ipRules,omitempty"` // only shown to organization admins
+	Region       string    `json:"region,omitempty" bson:"region,omitempty"` // serves the meeting's realtime connections in a global deployment
+	TenantID     string    `json:"-" bson:"tenantId,omitempty"` // stamped by the db package; empty for the default tenant
+	ArchivedAt   *time.Time `json:"archivedAt,omitempty" bson:"archivedAt,omitempty"` // set once the meeting moved to the archive
 }
 
 type Participant struct {
@@ -87,11 +126,19 @@ type Participant struct {
 	UserName        string    `json:"userName" bson:"userName"`
 	PeerID          string    `json:"peerId" bson:"peerId"`
 	IsHost          bool      `json:"isHost" bson:"isHost"`
+	Role            string    `json:"role" bson:"role"`
 	IsAudioEnabled  bool      `json:"isAudioEnabled" bson:"isAudioEnabled"`
 	IsVideoEnabled  bool      `json:"isVideoEnabled" bson:"isVideoEnabled"`
 	IsScreenSharing bool      `json:"isScreenSharing" bson:"isScreenSharing"`
+	Online          bool      `json:"online" bson:"-"` // presence, filled in when listing
+	Status          string    `json:"status" bson:"-"` // heartbeat age, filled in when listing
 	JoinedAt        time.Time `json:"joinedAt" bson:"joinedAt"`
 	LastActive      time.Time `json:"lastActive" bson:"lastActive"`
+	LeftAt          *time.Time `json:"leftAt,omitempty" bson:"leftAt,omitempty"`
+	HandRaisedAt    *time.Time `json:"handRaisedAt,omitempty" bson:"handRaisedAt,omitempty"`
+	Region          string    `json:"region,omitempty" bson:"region,omitempty"` // where the participant joined from, when known
+	// Set by a host; the organization's cap applies too
+	MaxVideoBitrateKbps int `json:"maxVideoBitrateKbps,omitempty" bson:"maxVideoBitrateKbps,omitempty"`
 }
 
 type ChatMessage struct {
@@ -100,7 +147,19 @@ type ChatMessage struct {
 	UserID    string    `json:"userId" bson:"userId"`
 	UserName  string    `json:"userName" bson:"userName"`
 	Message   string    `json:"message" bson:"message"`
+	Attachments []ChatAttachment `json:"attachments,omitempty" bson:"attachments,omitempty"`
 	Timestamp time.Time `json:"timestamp" bson:"timestamp"`
+	EditedAt  *time.Time `json:"editedAt,omitempty" bson:"editedAt,omitempty"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty" bson:"deletedAt,omitempty"`
+	DeletedBy string     `json:"deletedBy,omitempty" bson:"deletedBy,omitempty"`
+	// Earlier versions of the message, oldest first; served by the history endpoint only
+	History []ChatEdit `json:"-" bson:"history,omitempty"`
+}
+
+// ChatEdit is a previous version of an edited chat message
+type ChatEdit struct {
+	Message  string    `json:"message" bson:"message"`
+	EditedAt time.Time `json:"editedAt" bson:"editedAt"`
 }
 
 type WebSocketMessage struct {
@@ -109,6 +168,8 @@ type WebSocketMessage struct {
 	MeetingID string      `json:"meetingId,omitempty"`
 	UserID    string      `json:"userId,omitempty"`
 	Timestamp time.Time   `json:"timestamp"`
+	// Per-meeting sequence number, assigned by the hub; see resume-from
+	Seq uint64 `json:"seq,omitempty"`
 }
 
 type SignalingData struct {
@@ -118,6 +179,8 @@ type SignalingData struct {
 	Offer      *webrtc.SessionDescription `json:"offer,omitempty"`
 	Answer     *webrtc.SessionDescription `json:"answer,omitempty"`
 	Candidate  *webrtc.ICECandidateInit   `json:"candidate,omitempty"`
+	StreamType string                 `json:"streamType,omitempty"` // camera or screen
+	ShareToken string                 `json:"shareToken,omitempty"` // required for screen streams
 }
 
 type Response struct {
@@ -125,48 +188,184 @@ type Response struct {
 	Data    interface{} `json:"data,omitempty"`
 	Message string      `json:"message,omitempty"`
 	Error   string      `json:"error,omitempty"`
+	Code    ErrorCode   `json:"code,omitempty"` // set on errors; see api_errors.go
+}
+
+// Request bodies of the REST handlers; named so the OpenAPI document can describe them
+type registerRequest struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	// Required when the server answers CAPTCHA_REQUIRED
+	CaptchaToken string `json:"captchaToken,omitempty"`
+}
+
+type loginRequest struct {
+	Email        string `json:"email"`
+	Password     string `json:"password"`
+	CaptchaToken string `json:"captchaToken,omitempty"`
+}
+
+type createMeetingRequest struct {
+	Title           string   `json:"title"`
+	Description     string   `json:"description,omitempty"`
+	ScheduledFor    string   `json:"scheduledFor,omitempty"`
+	Timezone        string   `json:"timezone,omitempty"`
+	IsPrivate       bool     `json:"isPrivate"`
+	MaxParticipants int      `json:"maxParticipants,omitempty"`
+	Invitees        []string `json:"invitees,omitempty"`
+	OrgID           string   `json:"orgId,omitempty"`
+	E2EE            bool     `json:"e2ee,omitempty"`
+	Region          string   `json:"region,omitempty"`
+}
+
+type joinMeetingRequest struct {
+	UserName string `json:"userName"`
+	PeerID   string `json:"peerId"`
+	// Wait in the overflow queue when the meeting is full, if it is enabled
+	Queue bool `json:"queue,omitempty"`
+	// From the recent meetings listing; joins under the name last used when
+	// userName is empty
+	RejoinToken string `json:"rejoinToken,omitempty"`
+}
+
+type updateParticipantRequest struct {
+	IsAudioEnabled  bool `json:"isAudioEnabled"`
+	IsVideoEnabled  bool `json:"isVideoEnabled"`
+	IsScreenSharing bool `json:"isScreenSharing"`
+}
+
+// Incoming message from a WebSocket client; Data is decoded per message type
+type ClientMessage struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data,omitempty"`
 }
 
-// WebSocket connection manager
-type Hub struct {
+// hubShard manages the connections of the meetings hashed to it. Its state
+// is only touched on its own run goroutine, so a busy meeting only holds up
+// the meetings sharing its shard.
+type hubShard struct {
 	clients    map[*Client]bool
-	broadcast  chan []byte
 	register   chan *Client
 	unregister chan *Client
+	outbound   chan *hubMessage
+	stats      chan chan HubStats
 	meetings   map[string]map[*Client]bool // meetingId -> clients
+	lastBeat   atomic.Int64                // unix nanoseconds, refreshed by run()
+	replay     map[string]*replayBuffer    // meetingId -> recent messages, for resuming clients
+	resumes    chan resumeRequest
+	handovers  chan handoverRequest
+	adoptions  chan adoptRequest
+
+	// Bytes held by replay, and this shard's share of hub.replayTotalMaxBytes
+	replayBytes int
+	replayLimit int
+	// hub.replayMaxBytes, the cap on any one meeting's buffer
+	replayMaxBytes int
+}
+
+// hubMessage is a message queued for delivery by a shard's goroutine, either to
+// a whole meeting (optionally excluding one client) or to a single client
+type hubMessage struct {
+	meetingID string
+	message   WebSocketMessage
+	exclude   *Client
+	target    *Client
+	targetUser string
+	targetPeer string
+	// disconnect closes every recipient's connection after delivery
+	disconnect bool
+	// connectedOnly drops the message when the meeting has no clients here
+	connectedOnly bool
 }
 
 type Client struct {
 	hub       *Hub
 	conn      *websocket.Conn
-	send      chan []byte
+	send      *sendQueue
 	userID    string
+	userName  string
 	meetingID string
 	peerID    string
+	// When a typing event was last relayed for this client
+	lastTypingAt time.Time
+	// Recent connection stats reports, used by the read loop only
+	quality *qualityWindow
+	// Meeting seq when the client registered; owned by its shard's goroutine
+	registeredSeq uint64
+	// Negotiated the protobuf subprotocol; frames are binary envelopes
+	binary bool
+	// Message budgets, used by the read loop only
+	guard *messageGuard
+	// Span of the upgrade request, linked from each message span
+	connSpan trace.SpanContext
+	// Set instead of conn for clients on the server-sent events fallback
+	sse *sseSession
+	// Cancelled when the client leaves, abandoning its handlers' queries
+	ctx    context.Context
+	cancel context.CancelFunc
+	// Set for service accounts connected with an API key
+	apiKey *APIKey
+	// The caller's region, when known
+	region string
+	// Set when the client's meeting is handed over to another instance
+	handedOver atomic.Bool
 }
 
-// Initialize hub
-func newHub() *Hub {
-	return &Hub{
+func newHubShard(replayLimit, replayMaxBytes int) *hubShard {
+	return &hubShard{
 		clients:    make(map[*Client]bool),
-		broadcast:  make(chan []byte),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		outbound:   make(chan *hubMessage, 256),
+		stats:      make(chan chan HubStats),
 		meetings:   make(map[string]map[*Client]bool),
+		replay:     make(map[string]*replayBuffer),
+		resumes:    make(chan resumeRequest, 16),
+		handovers:  make(chan handoverRequest),
+		adoptions:  make(chan adoptRequest),
+
+		replayLimit:    replayLimit,
+		replayMaxBytes: replayMaxBytes,
 	}
 }
 
-func (h *Hub) run() {
+func (h *hubShard) run() {
+	heartbeat := time.NewTicker(HubHeartbeatInterval)
+	defer heartbeat.Stop()
+	h.lastBeat.Store(time.Now().UnixNano())
+
 	for {
 		select {
+		case now := <-heartbeat.C:
+			h.lastBeat.Store(now.UnixNano())
+			h.pruneReplay(now)
+
+		case req := <-h.resumes:
+			h.resume(req)
+
+		case req := <-h.handovers:
+			req.reply <- h.handOver(req)
+
+		case req := <-h.adoptions:
+			h.adopt(req.state)
+			close(req.done)
+
+		case reply := <-h.stats:
+			reply <- h.snapshot()
+
 		case client := <-h.register:
 			h.clients[client] = true
 			if h.meetings[client.meetingID] == nil {
 				h.meetings[client.meetingID] = make(map[*Client]bool)
 			}
 			h.meetings[client.meetingID][client] = true
+			if buffer := h.replay[client.meetingID]; buffer != nil {
+				client.registeredSeq = buffer.lastSeq
+			}
 			
 			log.Printf("Client registered: %s in meeting %s", client.userID, client.meetingID)
+			go markParticipantActive(context.Background(), client.meetingID, client.userID)
 			
 			// Notify other participants about new user
 			h.broadcastToMeeting(client.meetingID, WebSocketMessage{
@@ -180,7 +379,7 @@ func (h *Hub) run() {
 			if _, ok := h.clients[client]; ok {
 				delete(h.clients, client)
 				delete(h.meetings[client.meetingID], client)
-				close(client.send)
+				client.send.close()
 				
 				log.Printf("Client unregistered: %s from meeting %s", client.userID, client.meetingID)
 				
@@ -198,74 +397,191 @@ func (h *Hub) run() {
 				}
 			}
 
-		case message := <-h.broadcast:
-			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, client)
+			// Only mark the participant as left once their last connection is
+			// gone, and not when it was handed over to another instance
+			if !client.handedOver.Load() && !h.hasUserInMeeting(client.meetingID, client.userID) {
+				go markParticipantLeft(context.Background(), client.meetingID, client.userID, time.Now())
+			}
+
+		case out := <-h.outbound:
+			if out.connectedOnly && len(h.meetings[out.meetingID]) == 0 {
+				continue
+			}
+			if out.target != nil {
+				h.sendToClient(out.target, out.message)
+			} else if out.targetPeer != "" {
+				for client := range h.meetings[out.meetingID] {
+					if client.peerID == out.targetPeer {
+						h.sendToClient(client, out.message)
+					}
+				}
+			} else if out.targetUser != "" {
+				for client := range h.clients {
+					if client.userID != out.targetUser || (out.meetingID != "" && client.meetingID != out.meetingID) {
+						continue
+					}
+					h.sendToClient(client, out.message)
+					if out.disconnect {
+						h.disconnectClient(client)
+					}
 				}
+				continue
+			} else {
+				h.broadcastToMeeting(out.meetingID, out.message, out.exclude)
+			}
+			if out.disconnect {
+				h.disconnectMeeting(out.meetingID)
 			}
 		}
 	}
 }
 
-func (h *Hub) broadcastToMeeting(meetingID string, message WebSocketMessage, excludeClient *Client) {
-	messageBytes, err := json.Marshal(message)
+// hasUserInMeeting reports whether the user still has a connected client in the meeting
+func (h *hubShard) hasUserInMeeting(meetingID, userID string) bool {
+	for client := range h.meetings[meetingID] {
+		if client.userID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// disconnectMeeting closes the send channel of every client in a meeting;
+// each writePump then sends a close frame after draining queued messages
+func (h *hubShard) disconnectMeeting(meetingID string) {
+	for client := range h.meetings[meetingID] {
+		if _, ok := h.clients[client]; ok {
+			delete(h.clients, client)
+			client.send.close()
+		}
+	}
+	delete(h.meetings, meetingID)
+	h.dropReplay(meetingID)
+	log.Printf("Disconnected all clients from meeting %s", meetingID)
+}
+
+// disconnectClient closes one client's send channel, after which its
+// writePump sends a close frame, and tells the rest of its meeting it left
+func (h *hubShard) disconnectClient(client *Client) {
+	if _, ok := h.clients[client]; !ok {
+		return
+	}
+	delete(h.clients, client)
+	delete(h.meetings[client.meetingID], client)
+	client.send.close()
+	if len(h.meetings[client.meetingID]) == 0 {
+		delete(h.meetings, client.meetingID)
+		return
+	}
+	h.broadcastToMeeting(client.meetingID, WebSocketMessage{
+		Type:      "user-left",
+		Data:      map[string]string{"userId": client.userID, "peerId": client.peerID},
+		MeetingID: client.meetingID,
+		Timestamp: time.Now(),
+	}, nil)
+}
+
+func (h *hubShard) sendToClient(client *Client, message WebSocketMessage) {
+	if _, ok := h.clients[client]; !ok {
+		return
+	}
+	frame, buffer, err := h.sequence(client.meetingID, message)
+	if err != nil {
+		log.Printf("Error marshaling websocket message: %v", err)
+		return
+	}
+	h.record(buffer, replayEntry{seq: frame.message.Seq, frame: frame, peerID: client.peerID, userID: client.userID})
+	h.deliver(client, frame)
+}
+
+// deliver queues a frame on a client in its negotiated encoding. A full queue
+// sheds the frame by its class; only a client too far behind to take
+// signaling is dropped. Reports whether the client is still connected.
+func (h *hubShard) deliver(client *Client, frame *encodedFrame) bool {
+	class, key := classifyMessage(frame.message)
+	if client.send.push(frame.bytesFor(client), class, key) {
+		return true
+	}
+	h.dropSlowClient(client)
+	return false
+}
+
+// dropSlowClient disconnects a client whose send queue has overflowed; it
+// can reconnect and resume from its last seq
+func (h *hubShard) dropSlowClient(client *Client) {
+	log.Printf("Disconnecting slow client %s from meeting %s", client.userID, client.meetingID)
+	sendQueueDisconnected.Add(1)
+	client.send.close()
+	delete(h.clients, client)
+	delete(h.meetings[client.meetingID], client)
+}
+
+func (h *hubShard) broadcastToMeeting(meetingID string, message WebSocketMessage, excludeClient *Client) {
+	frame, buffer, err := h.sequence(meetingID, message)
 	if err != nil {
 		log.Printf("Error marshaling websocket message: %v", err)
 		return
 	}
+	entry := replayEntry{seq: frame.message.Seq, frame: frame}
+	if excludeClient != nil {
+		entry.excludePeer = excludeClient.peerID
+	}
+	h.record(buffer, entry)
 
 	if clients, exists := h.meetings[meetingID]; exists {
 		for client := range clients {
 			if excludeClient != nil && client == excludeClient {
 				continue
 			}
-			select {
-			case client.send <- messageBytes:
-			default:
-				close(client.send)
-				delete(h.clients, client)
-				delete(h.meetings[meetingID], client)
-			}
+			h.deliver(client, frame)
 		}
 	}
 }
 
-// Global hub instance
-var hub = newHub()
+// Global hub instance, created in main once the configuration is loaded
+var hub *Hub
 
-// Initialize MongoDB connection with retry logic
-func initMongoDB() error {
+// Initialize the database connection with retry logic
+func initDatabase() error {
 	var err error
 	for i := 0; i < MaxRetries; i++ {
-		err = db.ConnectDB()
+		switch appConfig.Database.Driver {
+		case config.DatabaseDriverPostgres:
+			err = db.ConnectPostgres(appConfig.Postgres)
+		case config.DatabaseDriverMemory:
+			err = db.ConnectMemory()
+		default:
+			err = db.ConnectDB(appConfig.Mongo)
+		}
 		if err == nil {
-			log.Println("Successfully connected to MongoDB")
+			log.Printf("Successfully connected to the %s database", appConfig.Database.Driver)
 			
 			// Create indexes for better performance
-			createIndexes()
+			if appConfig.Database.Driver == config.DatabaseDriverMongo {
+				createIndexes()
+			}
+			if appConfig.Database.Seed != "" {
+				if err := db.Seed(context.Background(), appConfig.Database.Seed); err != nil {
+					return fmt.Errorf("failed to seed the database: %v", err)
+				}
+			}
 			return nil
 		}
-		log.Printf("Failed to connect to MongoDB (attempt %d/%d): %v", i+1, MaxRetries, err)
+		log.Printf("Failed to connect to the %s database (attempt %d/%d): %v", appConfig.Database.Driver, i+1, MaxRetries, err)
 		if i < MaxRetries-1 {
 			time.Sleep(RetryDelay)
 		}
 	}
-	return fmt.Errorf("failed to connect to MongoDB after %d attempts: %v", MaxRetries, err)
+	return fmt.Errorf("failed to connect to the %s database after %d attempts: %v", appConfig.Database.Driver, MaxRetries, err)
 }
 
 func createIndexes() {
-	ctx := context.Background()
-	
-	// Create indexes for better query performance
-	userEmailIndex := mongo.IndexModel{
-		Keys:    bson.D{{Key: "email", Value: 1}},
-		Options: options.Index().SetUnique(true),
-	}
+	// Index builds can outlast the per-query timeout
+	ctx, cancel := context.WithTimeout(context.Background(), IndexBuildTimeout)
+	defer cancel()
 	
+	// Create indexes for better query performance. Account emails are unique
+	// per tenant, which db.createIndexes and migration 3 take care of.
 	meetingCreatedByIndex := mongo.IndexModel{
 		Keys: bson.D{{Key: "createdBy", Value: 1}},
 	}
@@ -280,10 +596,9 @@ func createIndexes() {
 	
 
 	// Create indexes
-	db.Users.Indexes().CreateOne(ctx, userEmailIndex)
-	db.Meetings.Indexes().CreateOne(ctx, meetingCreatedByIndex)
-	db.Participants.Indexes().CreateOne(ctx, participantMeetingIndex)
-	db.Participants.Indexes().CreateOne(ctx, participantLastActiveIndex)
+	db.Meetings.Mongo().Indexes().CreateOne(ctx, meetingCreatedByIndex)
+	db.Participants.Mongo().Indexes().CreateOne(ctx, participantMeetingIndex)
+	db.Participants.Mongo().Indexes().CreateOne(ctx, participantLastActiveIndex)
 	
 }
 
@@ -298,22 +613,6 @@ func loggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func getClientIP(r *http.Request) string {
-	// Check X-Forwarded-For header
-	forwarded := r.Header.Get("X-Forwarded-For")
-	if forwarded != "" {
-		return strings.Split(forwarded, ",")[0]
-	}
-	
-	// Check X-Real-Ip header
-	realIP := r.Header.Get("X-Real-Ip")
-	if realIP != "" {
-		return realIP
-	}
-	
-	return r.RemoteAddr
-}
-
 // Improved CORS middleware
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -322,21 +621,32 @@ func corsMiddleware(next http.Handler) http.Handler {
 		// Always set basic headers
 		w.Header().Set("Content-Type", "application/json; charset=utf-8")
 		
-		// Handle CORS
-		if origin != "" && isAllowedOrigin(origin) {
-			w.Header().Set("Access-Control-Allow-Origin", origin)
-		} else if origin == "" {
-			w.Header().Set("Access-Control-Allow-Origin", allowedOrigins[0])
+		// Handle CORS; organization origins get no credentials
+		credentials := true
+		if origin != "" {
+			var allowed bool
+			if allowed, credentials = allowedOrigin(r, origin); allowed {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+		} else if tenant := requestTenant(r); tenant != nil {
+			if len(tenant.origins) > 0 {
+				w.Header().Set("Access-Control-Allow-Origin", tenant.origins[0].String())
+			}
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", appConfig.CORS.AllowedOrigins[0])
 		}
 		
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, PATCH, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Accept, Origin, X-Requested-With")
-		w.Header().Set("Access-Control-Allow-Credentials", "true")
-		w.Header().Set("Access-Control-Expose-Headers", "Content-Type, Authorization, Set-Cookie")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Accept, Origin, X-Requested-With, Range")
+		if credentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+		w.Header().Set("Access-Control-Expose-Headers", "Content-Type, Authorization, Set-Cookie, Content-Range, Accept-Ranges, Content-Length")
 		w.Header().Set("Vary", "Origin")
 		
 		// Handle preflight requests
 		if r.Method == "OPTIONS" {
+			w.Header().Set("Access-Control-Max-Age", "300")
 			w.WriteHeader(http.StatusOK)
 			return
 		}
@@ -345,43 +655,6 @@ func corsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// Rate limiting middleware (simple in-memory implementation)
-var requestCounts = make(map[string]int)
-var lastReset = time.Now()
-
-func rateLimitMiddleware(requestsPerMinute int) func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			clientIP := getClientIP(r)
-			
-			// Reset counts every minute
-			if time.Since(lastReset) > time.Minute {
-				requestCounts = make(map[string]int)
-				lastReset = time.Now()
-			}
-			
-			requestCounts[clientIP]++
-			
-			if requestCounts[clientIP] > requestsPerMinute {
-				sendErrorResponse(w, "Rate limit exceeded", http.StatusTooManyRequests)
-				return
-			}
-			
-			next.ServeHTTP(w, r)
-		})
-	}
-}
-
-// Helper functions
-func isAllowedOrigin(origin string) bool {
-	for _, allowed := range allowedOrigins {
-		if origin == allowed {
-			return true
-		}
-	}
-	return false
-}
-
 func setSessionCookie(w http.ResponseWriter, token string) {
 	cookie := &http.Cookie{
 		Name:     CookieName,
@@ -390,7 +663,8 @@ func setSessionCookie(w http.ResponseWriter, token string) {
 		HttpOnly: true,
 		Secure:   true,
 		SameSite: http.SameSiteNoneMode,
-		MaxAge:   86400 * 7, // 7 days
+		// The session itself may expire sooner when left idle
+		MaxAge: int(time.Duration(appConfig.Sessions.MaxLifetime).Seconds()),
 	}
 	http.SetCookie(w, cookie)
 }
@@ -435,10 +709,13 @@ func sendSuccessResponse(w http.ResponseWriter, data interface{}) {
 	})
 }
 
+// sendErrorResponse sends an error with the generic code of its status; use
+// sendCodedError for errors clients need to tell apart
 func sendErrorResponse(w http.ResponseWriter, message string, statusCode int) {
 	sendJSONResponse(w, statusCode, Response{
 		Success: false,
 		Error:   message,
+		Code:    errorCodeForStatus(statusCode),
 	})
 }
 
@@ -446,6 +723,38 @@ func validateEmail(email string) bool {
 	return strings.Contains(email, "@") && len(email) > 5
 }
 
+// parseScheduledFor validates a meeting start time. RFC3339 input with an
+// offset is used as-is; a local date-time without an offset is interpreted in
+// the given IANA timezone. The result is stored in UTC.
+func parseScheduledFor(value, timezone string) (*time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		t = t.UTC()
+		return &t, nil
+	}
+
+	if timezone == "" {
+		return nil, fmt.Errorf("scheduledFor must be an RFC3339 timestamp, or include a timezone")
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone: %s", timezone)
+	}
+	t, err := time.ParseInLocation("2006-01-02T15:04:05", value, loc)
+	if err != nil {
+		t, err = time.ParseInLocation("2006-01-02T15:04", value, loc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("scheduledFor must be an RFC3339 timestamp")
+	}
+	t = t.UTC()
+	return &t, nil
+}
+
 func validatePassword(password string) error {
 	if len(password) < 8 {
 		return fmt.Errorf("password must be at least 8 characters long")
@@ -455,40 +764,41 @@ func validatePassword(password string) error {
 
 // Enhanced handlers with better validation and error handling
 func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 
-	// Check MongoDB connection
-	err := db.Client.Ping(ctx, nil)
+	// Check the database connection
+	err := db.Ping(ctx)
 	dbStatus := "connected"
 	if err != nil {
-		log.Printf("MongoDB health check failed: %v", err)
+		log.Printf("Database health check failed: %v", err)
 		dbStatus = "disconnected"
 	}
 
 	// Check active connections
-	activeConnections := len(hub.clients)
-	activeMeetings := len(hub.meetings)
+	var activeConnections, activeMeetings int
+	if hubStats, err := hub.Stats(); err != nil {
+		log.Printf("Hub health check failed: %v", err)
+	} else {
+		activeConnections = hubStats.Clients
+		activeMeetings = hubStats.Meetings
+	}
 
 	sendSuccessResponse(w, map[string]interface{}{
 		"status":            "ok",
 		"database":          dbStatus,
 		"activeConnections": activeConnections,
 		"activeMeetings":    activeMeetings,
+		"cleanup":           getCleanupStats(),
 		"timestamp":         time.Now().Format(time.RFC3339),
-		"version":           "1.0.0",
+		"version":           APIVersion,
 	})
 }
 
 func registerHandler(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		Name     string `json:"name"`
-		Email    string `json:"email"`
-		Password string `json:"password"`
-	}
+	var req registerRequest
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+	if !decodeStrictJSONBody(w, r, &req) {
 		return
 	}
 
@@ -510,11 +820,16 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 	req.Name = strings.TrimSpace(req.Name)
 	req.Email = strings.ToLower(strings.TrimSpace(req.Email))
 
+	clientIP := getClientIP(r)
+	if signupCaptchaRequired(r.Context(), clientIP, time.Now()) && !checkCaptcha(w, r, req.CaptchaToken) {
+		return
+	}
+
 	// Check if email exists
 	var existingUser User
-	err := db.Users.FindOne(context.Background(), bson.M{"email": req.Email}).Decode(&existingUser)
+	err := db.Users.FindOne(r.Context(), bson.M{"email": req.Email}).Decode(&existingUser)
 	if err == nil {
-		sendErrorResponse(w, "Email already in use", http.StatusConflict)
+		sendCodedError(w, ErrCodeEmailTaken, "Email already in use")
 		return
 	} else if err != mongo.ErrNoDocuments {
 		log.Printf("Database error during email check: %v", err)
@@ -523,7 +838,7 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	hashedPassword, err := hashPassword(req.Password)
 	if err != nil {
 		log.Printf("Error hashing password: %v", err)
 		sendErrorResponse(w, "Error processing password", http.StatusInternalServerError)
@@ -537,19 +852,30 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 		ID:        userID,
 		Name:      req.Name,
 		Email:     req.Email,
-		Password:  string(hashedPassword),
+		Password:  hashedPassword,
 		CreatedAt: now,
 		UpdatedAt: now,
 	}
 
-	_, err = db.Users.InsertOne(context.Background(), user)
+	_, err = db.Users.InsertOne(r.Context(), user)
 	if err != nil {
 		log.Printf("Error creating user: %v", err)
 		sendErrorResponse(w, "Error creating user", http.StatusInternalServerError)
 		return
 	}
+	recordSecurityEvent(r.Context(), SecurityEvent{Type: SecurityAccountRegistered, UserID: user.ID, Email: user.Email, IP: clientIP, At: now})
 
-	token := fmt.Sprintf("token_%s", userID)
+	// Link contact entries other users saved for this address
+	if _, err := db.Contacts.UpdateMany(r.Context(), bson.M{"email": user.Email}, bson.M{"$set": bson.M{"userId": user.ID}}); err != nil {
+		log.Printf("Error linking contacts for %s: %v", user.ID, err)
+	}
+
+	token, err := createSession(r, userID)
+	if err != nil {
+		log.Printf("Error creating session for %s: %v", userID, err)
+		sendErrorResponse(w, "Error creating session", http.StatusInternalServerError)
+		return
+	}
 	setSessionCookie(w, token)
 
 	sendSuccessResponse(w, map[string]interface{}{
@@ -564,13 +890,9 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func loginHandler(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
-	}
+	var req loginRequest
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+	if !decodeStrictJSONBody(w, r, &req) {
 		return
 	}
 
@@ -581,12 +903,31 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Clean email
 	req.Email = strings.ToLower(strings.TrimSpace(req.Email))
+	clientIP := getClientIP(r)
+	now := time.Now()
+
+	// Refuse attempts while the account or IP is locked out
+	wait, err := loginLockedFor(r.Context(), req.Email, clientIP, now)
+	if err != nil {
+		log.Printf("Database error checking login lockout: %v", err)
+		sendErrorResponse(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		recordSecurityEvent(r.Context(), SecurityEvent{Type: SecurityLoginBlocked, Email: req.Email, IP: clientIP, At: now})
+		sendLoginLocked(w, wait)
+		return
+	}
+	if loginCaptchaRequired(r.Context(), req.Email, clientIP) && !checkCaptcha(w, r, req.CaptchaToken) {
+		return
+	}
 
 	var user User
-	err := db.Users.FindOne(context.Background(), bson.M{"email": req.Email}).Decode(&user)
+	err = db.Users.FindOne(r.Context(), bson.M{"email": req.Email}).Decode(&user)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			sendErrorResponse(w, "Invalid email or password", http.StatusUnauthorized)
+			recordLoginFailure(r.Context(), req.Email, "", clientIP, now)
+			sendCodedError(w, ErrCodeInvalidCredentials, "Invalid email or password")
 		} else {
 			log.Printf("Database error during login: %v", err)
 			sendErrorResponse(w, "Database error", http.StatusInternalServerError)
@@ -594,20 +935,53 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password))
-	if err != nil {
-		sendErrorResponse(w, "Invalid email or password", http.StatusUnauthorized)
+	passwordOK, needsRehash := verifyPassword(user.Password, req.Password)
+	if !passwordOK {
+		recordLoginFailure(r.Context(), req.Email, user.ID, clientIP, now)
+		sendCodedError(w, ErrCodeInvalidCredentials, "Invalid email or password")
 		return
 	}
 
-	// Update last login time
+	if user.DisabledAt != nil {
+		recordSecurityEvent(r.Context(), SecurityEvent{
+			Type:    SecurityLoginBlocked,
+			UserID:  user.ID,
+			Email:   req.Email,
+			IP:      clientIP,
+			Details: map[string]interface{}{"reason": "account disabled"},
+			At:      now,
+		})
+		sendCodedError(w, ErrCodeAccountDisabled, "This account has been disabled")
+		return
+	}
+
+	if err := clearAccountLockout(r.Context(), req.Email); err != nil {
+		log.Printf("Error clearing failed logins for %s: %v", user.ID, err)
+	}
+	recordSecurityEvent(r.Context(), SecurityEvent{Type: SecurityLoginSucceeded, UserID: user.ID, Email: req.Email, IP: clientIP, At: now})
+
+	// Update last login time, upgrading bcrypt and outdated hashes while the
+	// password is at hand
+	loginUpdate := bson.M{"updatedAt": time.Now()}
+	if needsRehash {
+		if rehashed, err := hashPassword(req.Password); err != nil {
+			log.Printf("Error rehashing password for %s: %v", user.ID, err)
+		} else {
+			loginUpdate["password"] = rehashed
+		}
+	}
 	db.Users.UpdateOne(
-		context.Background(),
+		r.Context(),
 		bson.M{"_id": user.ID},
-		bson.M{"$set": bson.M{"updatedAt": time.Now()}},
+		bson.M{"$set": loginUpdate},
 	)
 
-	token := fmt.Sprintf("token_%s", user.ID)
+	token, err := createSession(r, user.ID)
+	if err != nil {
+		log.Printf("Error creating session for %s: %v", user.ID, err)
+		sendErrorResponse(w, "Error creating session", http.StatusInternalServerError)
+		return
+	}
 	setSessionCookie(w, token)
 
 	sendSuccessResponse(w, map[string]interface{}{
@@ -621,6 +995,7 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	endSession(r)
 	clearSessionCookie(w)
 	sendSuccessResponse(w, map[string]string{"message": "Logged out successfully"})
 }
@@ -632,16 +1007,9 @@ func createMeetingHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req struct {
-		Title           string `json:"title"`
-		Description     string `json:"description,omitempty"`
-		ScheduledFor    string `json:"scheduledFor,omitempty"`
-		IsPrivate       bool   `json:"isPrivate"`
-		MaxParticipants int    `json:"maxParticipants,omitempty"`
-	}
+	var req createMeetingRequest
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+	if !decodeStrictJSONBody(w, r, &req) {
 		return
 	}
 
@@ -650,11 +1018,44 @@ func createMeetingHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	scheduledFor, err := parseScheduledFor(req.ScheduledFor, req.Timezone)
+	if err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Organization settings override the server's meeting defaults
+	org, err := loadMeetingOrg(r.Context(), req.OrgID, userID)
+	if err != nil {
+		if err == errNotOrgMember {
+			sendErrorResponse(w, err.Error(), http.StatusForbidden)
+		} else {
+			sendErrorResponse(w, "Organization not found", http.StatusNotFound)
+		}
+		return
+	}
+	defaults := meetingDefaults(org)
+
+	quota, err := loadQuotaState(r.Context(), userID, time.Now())
+	if err != nil {
+		sendErrorResponse(w, "Failed to load quota", http.StatusInternalServerError)
+		return
+	}
+
 	// Set default max participants if not provided
 	if req.MaxParticipants <= 0 {
-		req.MaxParticipants = 50
-	} else if req.MaxParticipants > 100 {
-		req.MaxParticipants = 100 // Cap at 100 participants
+		req.MaxParticipants = min(defaults.DefaultParticipants, quota.Limits.MaxParticipants)
+	} else if req.MaxParticipants > defaults.MaxParticipants {
+		req.MaxParticipants = defaults.MaxParticipants
+	}
+	if err := quota.CheckParticipants(req.MaxParticipants); err != nil {
+		sendCodedError(w, ErrCodeQuotaExceeded, err.Error())
+		return
+	}
+	region, err := initialMeetingRegion(r, req.Region)
+	if err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
 	meetingID := uuid.New().String()
@@ -664,16 +1065,20 @@ func createMeetingHandler(w http.ResponseWriter, r *http.Request) {
 		Title:           strings.TrimSpace(req.Title),
 		Description:     strings.TrimSpace(req.Description),
 		CreatedBy:       userID,
-		ScheduledFor:    req.ScheduledFor,
+		ScheduledFor:    scheduledFor,
 		CreatedAt:       now,
 		UpdatedAt:       now,
 		IsPrivate:       req.IsPrivate,
 		IsActive:        true,
 		MaxParticipants: req.MaxParticipants,
+		Invitees:        req.Invitees,
+		OrgID:           req.OrgID,
+		RecordingPolicy: defaults.RecordingPolicy,
+		E2EE:            req.E2EE,
+		Region:          region,
 	}
 
-	_, err := db.Meetings.InsertOne(context.Background(), meeting)
-	if err != nil {
+	if err := insertMeeting(r.Context(), &meeting); err != nil {
 		log.Printf("Error creating meeting: %v", err)
 		sendErrorResponse(w, "Error creating meeting", http.StatusInternalServerError)
 		return
@@ -682,69 +1087,490 @@ func createMeetingHandler(w http.ResponseWriter, r *http.Request) {
 	sendSuccessResponse(w, meeting)
 }
 
+// insertMeeting assigns the meeting a short code and stores it, retrying with
+// a fresh code on the rare collision with an existing one
+func insertMeeting(ctx context.Context, meeting *Meeting) error {
+	var err error
+	for i := 0; i < MaxRetries; i++ {
+		meeting.Code, err = generateMeetingCode()
+		if err != nil {
+			return err
+		}
+		_, err = db.Meetings.InsertOne(ctx, meeting)
+		if !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+	return err
+}
+
 // Continue with other handlers...
 // (The rest of the handlers would follow similar patterns with enhanced validation and error handling)
 
 func getUserIDFromToken(r *http.Request) string {
-	cookie, err := r.Cookie(CookieName)
-	if err != nil {
+	// apiKeyMiddleware has already checked the key and its account
+	if key := requestAPIKey(r); key != nil {
+		return key.AccountID
+	}
+	// embedTokenMiddleware has already checked the token and its meeting
+	if claims := requestEmbedToken(r); claims != nil {
+		return claims.Subject
+	}
+	userID := sessionUserID(r)
+	if userID == "" {
 		return ""
 	}
-	token := cookie.Value
-	// Example: token format is "token_<userID>"
-	if strings.HasPrefix(token, "token_") {
-		return strings.TrimPrefix(token, "token_")
+	// Sessions of disabled accounts stop working immediately
+	if disabledUsers.Contains(userID) {
+		return ""
+	}
+	return userID
+}
+
+// authorizeMeetingConnection checks that the caller may open a realtime
+// connection to the meeting in the route, writing the error response if not.
+// Returns the user ID and the meeting's ID, since the route may carry a
+// meeting code instead.
+func authorizeMeetingConnection(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return "", "", false
+	}
+
+	meeting, err := findMeeting(r.Context(), mux.Vars(r)["meetingId"])
+	if err != nil {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return "", "", false
+	}
+	if !meeting.IsActive {
+		sendCodedError(w, ErrCodeMeetingEnded, "Meeting has ended")
+		return "", "", false
+	}
+	if !canEnterMeeting(r.Context(), meeting, userID) {
+		sendCodedError(w, ErrCodeMeetingLocked, "Meeting is locked")
+		return "", "", false
+	}
+	if !meetingAllowsClientIP(meeting, r) {
+		sendCodedError(w, ErrCodeIPNotAllowed, "This meeting cannot be joined from your network")
+		return "", "", false
+	}
+	if seated, err := hasSeat(r.Context(), meeting, userID); err != nil {
+		log.Printf("Error checking capacity of meeting %s: %v", meeting.ID, err)
+		sendErrorResponse(w, "Failed to connect to meeting", http.StatusInternalServerError)
+		return "", "", false
+	} else if !seated {
+		sendCodedError(w, ErrCodeCapacityReached, "Meeting is full")
+		return "", "", false
+	}
+	if !routeMeetingRegion(w, r, meeting) {
+		return "", "", false
+	}
+	return userID, meeting.ID, true
+}
+
+// newMeetingClient builds the hub client for a connection request
+func newMeetingClient(r *http.Request, userID, meetingID string) *Client {
+	// The connection outlives the request, so it gets its own context, in
+	// the request's tenant
+	ctx, cancel := context.WithCancel(db.CopyTenant(context.Background(), r.Context()))
+
+	var user User
+	db.Users.FindOne(r.Context(), bson.M{"_id": userID}).Decode(&user)
+	// Embedded guests have no account, only the name they were given
+	if claims := requestEmbedToken(r); claims != nil {
+		user.Name = claims.Name
+	}
+
+	return &Client{
+		hub:       hub,
+		send:      newSendQueue(hub.cfg.Hub.SendQueueMaxBytes),
+		userID:    userID,
+		userName:  user.Name,
+		meetingID: meetingID,
+		peerID:    r.URL.Query().Get("peerId"),
+		guard:     newMessageGuard(hub.cfg.RateLimit.WebSocket),
+		connSpan:  trace.SpanContextFromContext(r.Context()),
+		ctx:       ctx,
+		cancel:    cancel,
+		apiKey:    requestAPIKey(r),
+		region:    clientRegion(r),
 	}
-	return ""
 }
 
 func websocketHandler(w http.ResponseWriter, r *http.Request) {
+	if draining.Load() {
+		sendCodedError(w, ErrCodeUnavailable, "This server is shutting down; reconnect to another")
+		return
+	}
+	userID, meetingID, ok := authorizeMeetingConnection(w, r)
+	if !ok {
+		return
+	}
+	adoptHandover(r.Context(), meetingID)
+
 	// Upgrade HTTP connection to WebSocket
 	conn, err := wsUpgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade error: %v", err)
 		return
 	}
-	defer conn.Close()
 
-	// Example: simple echo loop (replace with your logic)
+	client := newMeetingClient(r, userID, meetingID)
+	client.conn = conn
+	client.binary = conn.Subprotocol() == wsproto.SubprotocolProto
+	client.hub.Register(client)
+	regionTracker.Join(client)
+
+	go client.writePump()
+	go client.readPump()
+	go sendRoomState(client.ctx, client)
+}
+
+// leave releases what the client holds in the meeting and unregisters it
+func (c *Client) leave() {
+	regionTracker.Leave(c)
+	// A handed over screen share carries on after the reconnect
+	if grant := screenShares.Release(c); grant != nil && !c.handedOver.Load() {
+		announceScreenShareStopped(context.Background(), c, grant)
+	}
+	if sfu != nil {
+		sfu.Leave(c)
+	}
+	c.hub.Unregister(c)
+	c.cancel()
+}
+
+// receive applies budgets and validation to a decoded message and handles
+// it; reports false when the client should be disconnected
+func (c *Client) receive(msg ClientMessage, size int) bool {
+	if allowed, keepOpen := c.allowMessage(msg.Type, size); !keepOpen {
+		return false
+	} else if !allowed {
+		return true
+	}
+	if err := validateClientMessage(msg); err != nil {
+		c.sendValidationError(err)
+		return true
+	}
+	handleClientMessage(c, msg)
+	return true
+}
+
+// readPump reads messages from the WebSocket connection and dispatches them
+func (c *Client) readPump() {
+	defer func() {
+		c.leave()
+		c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(MaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(PongWait))
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(PongWait))
+		// Pongs double as the participant heartbeat for the cleanup job
+		go markParticipantActive(context.Background(), c.meetingID, c.userID)
+		return nil
+	})
+
 	for {
-		_, msg, err := conn.ReadMessage()
+		messageType, data, err := c.conn.ReadMessage()
 		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				log.Printf("WebSocket read error for %s: %v", c.userID, err)
+			}
 			break
 		}
-		if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+
+		msg, err := decodeClientFrame(messageType, data)
+		if err != nil {
+			c.sendError("Invalid message format")
+			continue
+		}
+		if !c.receive(msg, len(data)) {
 			break
 		}
 	}
 }
 
+// writePump writes queued messages and periodic pings to the WebSocket connection
+func (c *Client) writePump() {
+	ticker := time.NewTicker(PingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case <-c.send.ready:
+			frameType := websocket.TextMessage
+			if c.binary {
+				frameType = websocket.BinaryMessage
+			}
+			for {
+				message, ok, done := c.send.pop()
+				c.conn.SetWriteDeadline(time.Now().Add(WriteWait))
+				if done {
+					// Hub closed the queue
+					c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+					return
+				}
+				if !ok {
+					break
+				}
+				if err := c.conn.WriteMessage(frameType, message); err != nil {
+					return
+				}
+			}
+
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(WriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// sendError sends an error frame to this client only
+func (c *Client) sendError(message string) {
+	c.hub.SendToClient(c, WebSocketMessage{
+		Type: "error",
+		Data: map[string]string{"error": message},
+	})
+}
+
+// handleClientMessage routes an incoming WebSocket message by type
+func handleClientMessage(c *Client, msg ClientMessage) {
+	ctx, span := startMessageSpan(c, msg.Type)
+	defer span.End()
+	defer recoverMessagePanic(ctx, c, msg.Type)
+
+	if !apiKeyAllowsMessage(c, msg.Type) {
+		c.sendError("This API key lacks the chat:write scope")
+		return
+	}
+
+	switch msg.Type {
+	case "poll-vote":
+		handlePollVote(ctx, c, msg.Data)
+	case "chat-message":
+		handleChatMessage(ctx, c, msg.Data)
+	case "chat-clear":
+		handleChatClear(ctx, c, msg.Data)
+	case "chat-edit":
+		handleChatEdit(ctx, c, msg.Data)
+	case "chat-delete":
+		handleChatDelete(ctx, c, msg.Data)
+	case "chat-read":
+		handleChatRead(ctx, c, msg.Data)
+	case "typing":
+		handleTyping(ctx, c, msg.Data)
+	case "stats":
+		handleStats(ctx, c, msg.Data)
+	case "resume-from":
+		handleResumeFrom(ctx, c, msg.Data)
+	case "raise-hand":
+		handleRaiseHand(ctx, c, msg.Data)
+	case "lower-hand":
+		handleLowerHand(ctx, c, msg.Data)
+	case "mute-participant":
+		handleMuteParticipant(ctx, c, msg.Data)
+	case "unmute-participant":
+		handleUnmuteParticipant(ctx, c, msg.Data)
+	case "signal":
+		handleSignal(ctx, c, msg.Data)
+	case "screenshare-start":
+		handleScreenShareStart(ctx, c, msg.Data)
+	case "screenshare-stop":
+		handleScreenShareStop(ctx, c, msg.Data)
+	case "sfu-join":
+		handleSFUJoin(ctx, c, msg.Data)
+	case "sfu-answer":
+		handleSFUAnswer(ctx, c, msg.Data)
+	case "sfu-candidate":
+		handleSFUCandidate(ctx, c, msg.Data)
+	case "sfu-preferences":
+		handleSFUPreferences(ctx, c, msg.Data)
+	case "sfu-ice-restart":
+		handleSFUICERestart(ctx, c, msg.Data)
+	case "whiteboard":
+		handleWhiteboard(ctx, c, msg.Data)
+	case "notes-op":
+		handleNotesOp(ctx, c, msg.Data)
+	case "e2ee-public-key":
+		handleE2EEPublicKey(ctx, c, msg.Data)
+	case "e2ee-key":
+		handleE2EEKey(ctx, c, msg.Data)
+	case "e2ee-key-request":
+		handleE2EEKeyRequest(ctx, c, msg.Data)
+	default:
+		c.sendError(fmt.Sprintf("Unknown message type: %s", msg.Type))
+	}
+}
+
+const (
+	DefaultMeetingsPageSize = 20
+	MaxMeetingsPageSize     = 100
+)
+
+// getMeetingsHandler lists meetings visible to the caller. Supported query
+// params: createdBy=me, active=true|false, upcoming=true, limit, and cursor
+// (the nextCursor value from a previous page).
 func getMeetingsHandler(w http.ResponseWriter, r *http.Request) {
-	// Example: fetch all meetings from the database
-	cursor, err := db.Meetings.Find(context.Background(), bson.M{})
+	userID := getUserIDFromToken(r)
+	query := r.URL.Query()
+
+	// Private meetings are only visible to their owner and invitees
+	conditions := []bson.M{}
+	if userID == "" {
+		conditions = append(conditions, bson.M{"isPrivate": false})
+	} else {
+		conditions = append(conditions, bson.M{"$or": []bson.M{
+			{"isPrivate": false},
+			{"createdBy": userID},
+			{"invitees": userID},
+		}})
+	}
+
+	if query.Get("createdBy") == "me" {
+		if userID == "" {
+			sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		conditions = append(conditions, bson.M{"createdBy": userID})
+	}
+
+	// Organization members see the organization's meetings
+	if orgID := query.Get("orgId"); orgID != "" {
+		if userID == "" {
+			sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if _, err := findOrgMembership(r.Context(), orgID, userID); err != nil {
+			sendErrorResponse(w, "Organization not found", http.StatusNotFound)
+			return
+		}
+		conditions = append(conditions, bson.M{"orgId": orgID})
+	}
+
+	if active := query.Get("active"); active != "" {
+		conditions = append(conditions, bson.M{"isActive": active == "true"})
+	}
+
+	if query.Get("upcoming") == "true" {
+		conditions = append(conditions, bson.M{"scheduledFor": bson.M{"$gt": time.Now()}})
+	}
+
+	limit := DefaultMeetingsPageSize
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			sendErrorResponse(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(parsed, MaxMeetingsPageSize)
+	}
+
+	if value := query.Get("cursor"); value != "" {
+		createdAt, id, err := decodeMeetingsCursor(value)
+		if err != nil {
+			sendErrorResponse(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		conditions = append(conditions, bson.M{"$or": []bson.M{
+			{"createdAt": bson.M{"$lt": createdAt}},
+			{"createdAt": createdAt, "_id": bson.M{"$lt": id}},
+		}})
+	}
+
+	// Fetch one extra document to know whether another page exists
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(limit + 1))
+
+	cursor, err := db.Meetings.Find(r.Context(), bson.M{"$and": conditions}, findOptions)
 	if err != nil {
 		sendErrorResponse(w, "Failed to fetch meetings", http.StatusInternalServerError)
 		return
 	}
-	defer cursor.Close(context.Background())
+	defer cursor.Close(r.Context())
 
-	var meetings []Meeting
-	if err := cursor.All(context.Background(), &meetings); err != nil {
+	meetings := []Meeting{}
+	if err := cursor.All(r.Context(), &meetings); err != nil {
 		sendErrorResponse(w, "Failed to parse meetings", http.StatusInternalServerError)
 		return
 	}
 
-	sendSuccessResponse(w, meetings)
+	nextCursor := ""
+	if len(meetings) > limit {
+		meetings = meetings[:limit]
+		last := meetings[limit-1]
+		nextCursor = encodeMeetingsCursor(last.CreatedAt, last.ID)
+	}
+
+	sendSuccessResponse(w, map[string]interface{}{
+		"meetings":   meetings,
+		"nextCursor": nextCursor,
+	})
+}
+
+// encodeMeetingsCursor builds an opaque pagination cursor from the sort keys
+func encodeMeetingsCursor(createdAt time.Time, id string) string {
+	raw := fmt.Sprintf("%d|%s", createdAt.UnixMilli(), id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeMeetingsCursor(cursor string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	millis, id, found := strings.Cut(string(raw), "|")
+	if !found || id == "" {
+		return time.Time{}, "", fmt.Errorf("malformed cursor")
+	}
+	ms, err := strconv.ParseInt(millis, 10, 64)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	return time.UnixMilli(ms), id, nil
+}
+
+// findMeeting loads a meeting by ID or by its short meeting code
+func findMeeting(ctx context.Context, meetingID string) (Meeting, error) {
+	code := normalizeMeetingCode(meetingID)
+	// The cache is shared by all tenants
+	if meeting, ok := meetingLookups.Get(ctx, meetingID, code); ok && db.InTenant(ctx, meeting.TenantID) {
+		return meeting, nil
+	}
+
+	filter := bson.M{"_id": meetingID}
+	if code != "" {
+		filter = bson.M{"code": code}
+	}
+
+	var meeting Meeting
+	err := db.Meetings.FindOne(ctx, filter).Decode(&meeting)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// Ended meetings' history stays reachable once archived
+		meeting, err = findArchivedMeeting(ctx, filter)
+	}
+	if err != nil {
+		return meeting, err
+	}
+	meetingLookups.Put(ctx, meeting)
+	return meeting, nil
 }
 
 func getMeetingHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	meetingID := vars["id"]
 
-	var meeting Meeting
-	err := db.Meetings.FindOne(context.Background(), bson.M{"_id": meetingID}).Decode(&meeting)
+	meeting, err := findMeeting(r.Context(), meetingID)
 	if err != nil {
-		sendErrorResponse(w, "Meeting not found", http.StatusNotFound)
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
 		return
 	}
 
@@ -760,32 +1586,103 @@ func notifyJoinHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Example: add participant to meeting (expand as needed)
-	var req struct {
-		UserName string `json:"userName"`
-		PeerID   string `json:"peerId"`
+	meeting, err := findMeeting(r.Context(), meetingID)
+	if err != nil {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+	meetingID = meeting.ID
+	if !meeting.IsActive {
+		sendCodedError(w, ErrCodeMeetingEnded, "Meeting has ended")
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+	if !canEnterMeeting(r.Context(), meeting, userID) {
+		sendCodedError(w, ErrCodeMeetingLocked, "Meeting is locked")
+		return
+	}
+	if !meetingAllowsClientIP(meeting, r) {
+		sendCodedError(w, ErrCodeIPNotAllowed, "This meeting cannot be joined from your network")
 		return
 	}
 
+	// Example: add participant to meeting (expand as needed)
+	var req joinMeetingRequest
+	if !decodeStrictJSONBody(w, r, &req) {
+		return
+	}
+	if claims := requestEmbedToken(r); claims != nil {
+		req.UserName = claims.Name
+	}
+	if req.RejoinToken != "" {
+		tokenMeetingID, tokenUserID, err := parseRejoinToken(req.RejoinToken)
+		if err == nil && (tokenMeetingID != meetingID || tokenUserID != userID) {
+			err = fmt.Errorf("rejoin token is for another meeting")
+		}
+		if err != nil {
+			sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.UserName == "" {
+			if req.UserName, err = lastParticipantName(r.Context(), meetingID, userID); err != nil {
+				log.Printf("Error loading last name of %s in meeting %s: %v", userID, meetingID, err)
+			}
+		}
+	}
+
+	role := RoleAttendee
+	if meeting.CreatedBy == userID {
+		role = RoleHost
+	}
+
 	participant := Participant{
 		ID:         uuid.New().String(),
 		MeetingID:  meetingID,
 		UserID:     userID,
 		UserName:   req.UserName,
 		PeerID:     req.PeerID,
-		IsHost:     false,
+		IsHost:     role == RoleHost,
+		Role:       role,
 		JoinedAt:   time.Now(),
 		LastActive: time.Now(),
+		Region:     clientRegion(r),
 	}
 
-	_, err := db.Participants.InsertOne(context.Background(), participant)
+	present, err := admitParticipant(r.Context(), participant)
+	var joinErr *joinError
+	if errors.As(err, &joinErr) && joinErr.code == ErrCodeCapacityReached && req.Queue && appConfig.Meetings.OverflowQueue {
+		entry, err := enqueueOverflow(r.Context(), meetingID, userID, time.Now())
+		if err != nil {
+			log.Printf("Error queueing for meeting %s: %v", meetingID, err)
+			sendErrorResponse(w, "Failed to join meeting", http.StatusInternalServerError)
+			return
+		}
+		sendJSONResponse(w, http.StatusAccepted, Response{Success: true, Data: entry})
+		return
+	}
+	if errors.As(err, &joinErr) {
+		sendCodedError(w, joinErr.code, joinErr.message)
+		return
+	}
 	if err != nil {
+		log.Printf("Error joining meeting %s: %v", meetingID, err)
 		sendErrorResponse(w, "Failed to join meeting", http.StatusInternalServerError)
 		return
 	}
+	recordAttendance(r.Context(), meetingID, userID, participant.UserName, AttendanceJoin, participant.JoinedAt)
+
+	// The first participant in starts the meeting
+	if present == 0 {
+		startMeetingClock(r.Context(), meeting, participant.JoinedAt)
+		publishMeetingEvent(r.Context(), meeting, DomainMeetingStarted, userID, map[string]interface{}{
+			"startedAt": participant.JoinedAt,
+		})
+	}
+	publishMeetingEvent(r.Context(), meeting, DomainParticipantJoined, userID, map[string]interface{}{
+		"userId":   userID,
+		"userName": participant.UserName,
+		"role":     participant.Role,
+		"joinedAt": participant.JoinedAt,
+	})
 
 	sendSuccessResponse(w, participant)
 }
@@ -794,19 +1691,33 @@ func getParticipantsHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	meetingID := vars["id"]
 
-	cursor, err := db.Participants.Find(context.Background(), bson.M{"meetingId": meetingID})
+	cursor, err := db.Participants.Find(r.Context(), bson.M{
+		"meetingId": meetingID,
+		"leftAt":    bson.M{"$exists": false},
+	})
 	if err != nil {
 		sendErrorResponse(w, "Failed to fetch participants", http.StatusInternalServerError)
 		return
 	}
-	defer cursor.Close(context.Background())
+	defer cursor.Close(r.Context())
 
 	var participants []Participant
-	if err := cursor.All(context.Background(), &participants); err != nil {
+	if err := cursor.All(r.Context(), &participants); err != nil {
 		sendErrorResponse(w, "Failed to parse participants", http.StatusInternalServerError)
 		return
 	}
 
+	userIDs := make([]string, 0, len(participants))
+	for _, participant := range participants {
+		userIDs = append(userIDs, participant.UserID)
+	}
+	online := presence.Online(r.Context(), userIDs)
+	now := time.Now()
+	for i := range participants {
+		participants[i].Online = online[participants[i].UserID]
+		participants[i].Status = participantStatus(participants[i], now)
+	}
+
 	sendSuccessResponse(w, participants)
 }
 
@@ -819,16 +1730,30 @@ func updateParticipantHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req struct {
-		IsAudioEnabled  bool `json:"isAudioEnabled"`
-		IsVideoEnabled  bool `json:"isVideoEnabled"`
-		IsScreenSharing bool `json:"isScreenSharing"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+	var req updateParticipantRequest
+	if !decodeStrictJSONBody(w, r, &req) {
 		return
 	}
 
+	// Only roles with the screen-share permission may start sharing
+	if req.IsScreenSharing {
+		meeting, err := findMeeting(r.Context(), meetingID)
+		if err != nil {
+			sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+			return
+		}
+		meetingID = meeting.ID
+		if !hasPermission(r.Context(), meeting, userID, PermissionScreenShare) {
+			sendCodedError(w, ErrCodePermissionDenied, permissionDeniedMessage(PermissionScreenShare))
+			return
+		}
+		// The flag must reflect a slot granted by the screen share arbiter
+		if !screenShares.IsSharing(meetingID, userID) {
+			sendErrorResponse(w, "Request a screen share over the WebSocket before sharing", http.StatusConflict)
+			return
+		}
+	}
+
 	update := bson.M{
 		"$set": bson.M{
 			"isAudioEnabled":  req.IsAudioEnabled,
@@ -839,7 +1764,7 @@ func updateParticipantHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	_, err := db.Participants.UpdateOne(
-		context.Background(),
+		r.Context(),
 		bson.M{"meetingId": meetingID, "userId": userID},
 		update,
 	)
@@ -851,23 +1776,312 @@ func updateParticipantHandler(w http.ResponseWriter, r *http.Request) {
 	sendSuccessResponse(w, map[string]string{"message": "Participant updated successfully"})
 }
 
+func leaveMeetingHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	meetingID := vars["id"]
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// The route may carry the meeting's code rather than its ID
+	meeting, err := findMeeting(r.Context(), meetingID)
+	if err != nil {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+	meetingID = meeting.ID
+
+	var participant Participant
+	err = db.Participants.FindOneAndDelete(
+		r.Context(),
+		bson.M{"meetingId": meetingID, "userId": userID},
+	).Decode(&participant)
+	if err == mongo.ErrNoDocuments {
+		sendErrorResponse(w, "Not a participant of this meeting", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		sendErrorResponse(w, "Failed to leave meeting", http.StatusInternalServerError)
+		return
+	}
+
+	// A participant whose connection already dropped has their leave recorded
+	if participant.LeftAt == nil {
+		recordAttendance(r.Context(), meetingID, userID, participant.UserName, AttendanceLeave, time.Now())
+		seatFreed(r.Context(), meetingID)
+	}
+
+	sendSuccessResponse(w, map[string]string{"message": "Left meeting successfully"})
+}
+
+// markParticipantActive clears the left marker when a participant (re)connects
+// and refreshes their heartbeat
+func markParticipantActive(ctx context.Context, meetingID, userID string) {
+	now := time.Now()
+
+	// Coming back after being marked left counts as a rejoin
+	var participant Participant
+	err := db.Participants.FindOneAndUpdate(
+		ctx,
+		bson.M{"meetingId": meetingID, "userId": userID, "leftAt": bson.M{"$exists": true}},
+		bson.M{
+			"$set":   bson.M{"lastActive": now},
+			"$unset": bson.M{"leftAt": ""},
+		},
+	).Decode(&participant)
+	if err == nil {
+		recordAttendance(ctx, meetingID, userID, participant.UserName, AttendanceJoin, now)
+		return
+	}
+	if err != mongo.ErrNoDocuments {
+		log.Printf("Error marking participant %s active in meeting %s: %v", userID, meetingID, err)
+		return
+	}
+
+	_, err = db.Participants.UpdateOne(
+		ctx,
+		bson.M{"meetingId": meetingID, "userId": userID},
+		bson.M{"$set": bson.M{"lastActive": now}},
+	)
+	if err != nil {
+		log.Printf("Error marking participant %s active in meeting %s: %v", userID, meetingID, err)
+	}
+}
+
+// markParticipantLeft flags a participant as left after their connection closes.
+// Records refreshed after disconnectedAt (a quick reconnect) are left untouched.
+func markParticipantLeft(ctx context.Context, meetingID, userID string, disconnectedAt time.Time) {
+	var participant Participant
+	err := db.Participants.FindOneAndUpdate(
+		ctx,
+		bson.M{
+			"meetingId":  meetingID,
+			"userId":     userID,
+			"lastActive": bson.M{"$lt": disconnectedAt},
+			"leftAt":     bson.M{"$exists": false},
+		},
+		bson.M{"$set": bson.M{"leftAt": disconnectedAt}},
+	).Decode(&participant)
+	if err == nil {
+		recordAttendance(ctx, meetingID, userID, participant.UserName, AttendanceLeave, disconnectedAt)
+		seatFreed(ctx, meetingID)
+	} else if err != mongo.ErrNoDocuments {
+		log.Printf("Error marking participant %s left in meeting %s: %v", userID, meetingID, err)
+	}
+}
+
 func main() {
-	// Initialize MongoDB with retry logic
-	if err := initMongoDB(); err != nil {
-		log.Fatalf("Failed to initialize MongoDB: %v", err)
+	// Load configuration (defaults, optional CONFIG_FILE, environment overrides)
+	loaded, err := config.Load(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	appConfig = loaded
+	screenShares = newScreenShareArbiter(appConfig.Meetings.MaxScreenShares)
+	hub = newHub(appConfig)
+	trustedProxies = parseTrustedProxies(appConfig.Server.TrustedProxies)
+
+	// Initialize the database with retry logic
+	if err := initDatabase(); err != nil {
+		log.Fatalf("Failed to initialize the database: %v", err)
 	}
 	defer db.CloseDB()
 
+	// "server migrate ..." manages migrations instead of serving
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		code := runMigrateCommand(os.Args[2:])
+		db.CloseDB()
+		os.Exit(code)
+	}
+	if err := runStartupMigrations(); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	// Initialize email delivery
+	if mail, err = mailer.New(appConfig.Mail, db.EmailDeadLetters); err != nil {
+		log.Fatalf("Failed to initialize mailer: %v", err)
+	}
+	log.Printf("Email provider: %s", mail.Provider())
+
+	// Initialize blob storage for uploads
+	if blobStore, err = storage.New(appConfig.Storage, db.Database); err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	attachmentScanner = newAttachmentScanner(appConfig.Chat.AttachmentScanURL)
+	if chatModerator, err = newChatModerator(appConfig.Chat); err != nil {
+		log.Fatalf("Failed to initialize chat filter: %v", err)
+	}
+
+	// Initialize tracing
+	shutdownTracing, err := initTracing(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to initialize tracing: %v", err)
+	}
+
+	// Initialize error reporting
+	if err := initErrorReporter(); err != nil {
+		log.Fatalf("Failed to initialize error reporting: %v", err)
+	}
+	defer errorReporter.Flush(ErrorReporterFlushTimeout)
+
+	// Initialize push notification providers
+	if err := initPushProviders(context.Background(), appConfig.Push); err != nil {
+		log.Fatalf("Failed to initialize push notifications: %v", err)
+	}
+
+	// Initialize presence, shared through Redis when configured
+	var presenceStore PresenceStore = newMemoryPresenceStore()
+	if appConfig.Presence.RedisURL != "" {
+		if presenceStore, err = newRedisPresenceStore(appConfig.Presence.RedisURL); err != nil {
+			log.Fatalf("Failed to connect to Redis: %v", err)
+		}
+		log.Println("Presence shared through Redis")
+	}
+	presence = newPresenceService(presenceStore,
+		time.Duration(appConfig.Presence.TTL), time.Duration(appConfig.Presence.HeartbeatInterval))
+
+	// Initialize the meeting lookup cache, shared through Redis when configured
+	var cacheClient *redis.Client
+	if appConfig.Cache.RedisURL != "" {
+		if cacheClient, err = newRedisClient(appConfig.Cache.RedisURL); err != nil {
+			log.Fatalf("Failed to connect to the cache's Redis: %v", err)
+		}
+		log.Println("Meeting cache shared through Redis")
+	}
+	meetingLookups = newMeetingCache(appConfig.Cache.MeetingCacheSize, time.Duration(appConfig.Cache.MeetingTTL), cacheClient)
+
+	// Keep sessions where every instance can validate them
+	sessionStore = mongoSessionStore{}
+	if appConfig.Sessions.RedisURL != "" {
+		if sessionStore, err = newRedisSessionStore(appConfig.Sessions.RedisURL); err != nil {
+			log.Fatalf("Failed to connect to the sessions Redis: %v", err)
+		}
+		log.Println("Sessions shared through Redis")
+	}
+
+	// Share meeting state with the next instance when draining for a deploy
+	if appConfig.Handover.RedisURL != "" {
+		if handoverStore, err = newRedisClient(appConfig.Handover.RedisURL); err != nil {
+			log.Fatalf("Failed to connect to the handover Redis: %v", err)
+		}
+		log.Println("Meeting handover shared through Redis")
+	}
+
 	// Start WebSocket hub
-	go hub.run()
+	hub.run()
+
+	// Start the SFU when media should be routed through the server
+	if appConfig.Media.SFUMode {
+		var err error
+		if sfu, err = newSFU(); err != nil {
+			log.Fatalf("Failed to initialize SFU: %v", err)
+		}
+		log.Println("SFU mode enabled")
+	}
+	if appConfig.Transcription.Provider != "" {
+		provider, err := newSTTProvider(appConfig.Transcription)
+		if err != nil {
+			log.Fatalf("Failed to initialize transcription: %v", err)
+		}
+		transcriber = newTranscriptionService(provider, appConfig.Transcription.Language,
+			appConfig.Transcription.Workers, time.Duration(appConfig.Transcription.CaptionInterval))
+		log.Printf("Transcription enabled with %s", provider.Name())
+	}
+
+	if appConfig.Captcha.Provider != "" {
+		verifier, err := newCaptchaVerifier(appConfig.Captcha)
+		if err != nil {
+			log.Fatalf("Failed to initialize CAPTCHA: %v", err)
+		}
+		captchaVerifier = verifier
+		log.Printf("CAPTCHA enabled with %s", verifier.Name())
+	}
+
+	if err := loadTenants(appConfig.Tenants); err != nil {
+		log.Fatalf("Failed to load tenants: %v", err)
+	}
+	if tenancyEnabled() {
+		log.Printf("Hosting %d tenants besides the default", len(appConfig.Tenants))
+	}
+
+	if appConfig.Encryption.Provider != "" {
+		wrapper, err := newKeyWrapper(appConfig.Encryption)
+		if err != nil {
+			log.Fatalf("Failed to initialize encryption at rest: %v", err)
+		}
+		keyWrapper = wrapper
+		// Nothing may be stored before there is a key to seal it with
+		if err := dataKeys.refresh(context.Background()); err != nil {
+			log.Fatalf("Failed to load data keys: %v", err)
+		}
+		log.Printf("Encryption at rest enabled with %s", wrapper.Name())
+	}
+
+	// Start background jobs
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	if err := scheduleJobs(appConfig.Jobs); err != nil {
+		log.Fatalf("Failed to schedule jobs: %v", err)
+	}
+	startScheduler(jobsCtx)
+	go startRecordingPreviewJob(jobsCtx)
+	go startEventDispatchJob(jobsCtx)
+	go startWebhookDeliveryJob(jobsCtx)
+	go mail.Run(jobsCtx)
+	go presence.Run(jobsCtx)
+	go meetingLookups.Run(jobsCtx)
+	go startDisabledUsersRefresh(jobsCtx)
+	go startSigningKeysRefresh(jobsCtx)
+	go startCORSOriginsRefresh(jobsCtx)
+	if keyWrapper != nil {
+		go startDataKeysRefresh(jobsCtx)
+	}
+	if appConfig.Mongo.ChangeStreams {
+		watchChangeStreams(jobsCtx)
+	}
+	if transcriber != nil {
+		transcriber.Run(jobsCtx)
+	}
+
+	// Rate limiting: a per-client default with a stricter policy for logins
+	limiter := newRateLimiter(RateLimitPolicy{
+		Name:              "default",
+		RequestsPerMinute: appConfig.RateLimit.RequestsPerMinute,
+		Burst:             appConfig.RateLimit.Burst,
+		PerUser:           true,
+	}, time.Duration(appConfig.RateLimit.EvictAfter))
+	limiter.SetRoutePolicy("/api/auth/login", RateLimitPolicy{
+		Name:              "login",
+		RequestsPerMinute: appConfig.RateLimit.LoginPerMinute,
+		Burst:             appConfig.RateLimit.LoginBurst,
+	})
+	// Event stream sends carry signaling, so they get its WebSocket budget
+	// rather than the REST default; per-type budgets still apply on top
+	_, signalBudget := appConfig.RateLimit.WebSocket.Budget("signal")
+	limiter.SetRoutePolicy("/api/sse/{meetingId}/{sessionId}", RateLimitPolicy{
+		Name:              "sse-send",
+		RequestsPerMinute: signalBudget.PerMinute,
+		Burst:             signalBudget.Burst,
+		PerUser:           true,
+	})
+	go limiter.StartEviction(jobsCtx)
+	apiKeyLimiter = newRateLimiter(RateLimitPolicy{}, time.Duration(appConfig.RateLimit.EvictAfter))
+	go apiKeyLimiter.StartEviction(jobsCtx)
 
 	// Create router
 	r := mux.NewRouter()
 
 	// Apply middleware
+	r.Use(tracingMiddleware)
+	r.Use(compressionMiddleware)
+	r.Use(recoveryMiddleware)
 	r.Use(loggingMiddleware)
-	r.Use(corsMiddleware)
-	r.Use(rateLimitMiddleware(100)) // 100 requests per minute per IP
+	r.Use(apiKeyMiddleware)
+	r.Use(embedTokenMiddleware)
+	r.Use(rateLimitMiddleware(limiter))
+	r.Use(bodyLimitMiddleware)
 
 	// API routes
 	api := r.PathPrefix("/api").Subrouter()
@@ -876,49 +2090,206 @@ func main() {
 	api.HandleFunc("/auth/register", registerHandler).Methods("POST", "OPTIONS")
 	api.HandleFunc("/auth/login", loginHandler).Methods("POST", "OPTIONS")
 	api.HandleFunc("/auth/logout", logoutHandler).Methods("POST", "OPTIONS")
+	api.HandleFunc("/auth/captcha", getCaptchaSettingsHandler).Methods("GET", "OPTIONS")
+	api.HandleFunc("/tenant", getTenantHandler).Methods("GET", "OPTIONS")
+
+	// User routes
+	api.HandleFunc("/users/me/reminder-preferences", getReminderPreferencesHandler).Methods("GET", "OPTIONS")
+	api.HandleFunc("/users/me/reminder-preferences", updateReminderPreferencesHandler).Methods("PUT", "OPTIONS")
+	api.HandleFunc("/organizations", createOrganizationHandler).Methods("POST", "OPTIONS")
+	api.HandleFunc("/organizations", getOrganizationsHandler).Methods("GET", "OPTIONS")
+	api.HandleFunc("/organizations/invites/{inviteId}/accept", acceptOrgInviteHandler).Methods("POST", "OPTIONS")
+	api.HandleFunc("/organizations/{id}", getOrganizationHandler).Methods("GET", "OPTIONS")
+	api.HandleFunc("/organizations/{id}", updateOrganizationHandler).Methods("PUT", "OPTIONS")
+	api.HandleFunc("/organizations/{id}/members", getOrgMembersHandler).Methods("GET", "OPTIONS")
+	api.HandleFunc("/organizations/{id}/members/{userId}", removeOrgMemberHandler).Methods("DELETE", "OPTIONS")
+	api.HandleFunc("/organizations/{id}/invites", createOrgInvitesHandler).Methods("POST", "OPTIONS")
+	api.HandleFunc("/organizations/{id}/logo", uploadOrgLogoHandler).Methods("POST", "OPTIONS")
+	api.HandleFunc("/organizations/{id}/logo", deleteOrgLogoHandler).Methods("DELETE", "OPTIONS")
+	api.HandleFunc("/organizations/{id}/cors-origins", getOrgCORSOriginsHandler).Methods("GET", "OPTIONS")
+	api.HandleFunc("/organizations/{id}/cors-origins", addOrgCORSOriginHandler).Methods("POST", "OPTIONS")
+	api.HandleFunc("/organizations/{id}/cors-origins/{originId}", removeOrgCORSOriginHandler).Methods("DELETE", "OPTIONS")
+	api.HandleFunc("/logos/{orgId}/{file}", serveOrgLogoHandler).Methods("GET")
+	api.HandleFunc("/contacts", getContactsHandler).Methods("GET", "OPTIONS")
+	api.HandleFunc("/contacts", addContactHandler).Methods("POST", "OPTIONS")
+	api.HandleFunc("/contacts/import", importContactsHandler).Methods("POST", "OPTIONS")
+	api.HandleFunc("/contacts/{id}", deleteContactHandler).Methods("DELETE", "OPTIONS")
+	api.HandleFunc("/users/me/quota", getQuotaHandler).Methods("GET", "OPTIONS")
+	api.HandleFunc("/users/me/avatar", uploadAvatarHandler).Methods("POST", "OPTIONS")
+	api.HandleFunc("/users/me/avatar", deleteAvatarHandler).Methods("DELETE", "OPTIONS")
+	api.HandleFunc("/avatars/{userId}/{file}", serveAvatarHandler).Methods("GET")
+	api.HandleFunc("/users/me/devices", registerDeviceHandler).Methods("POST", "OPTIONS")
+	api.HandleFunc("/users/me/devices", getDevicesHandler).Methods("GET", "OPTIONS")
+	api.HandleFunc("/users/me/devices/{token}", unregisterDeviceHandler).Methods("DELETE", "OPTIONS")
+	api.HandleFunc("/users/me/export", exportUserDataHandler).Methods("GET", "OPTIONS")
+	api.HandleFunc("/users/me/recent-meetings", getRecentMeetingsHandler).Methods("GET", "OPTIONS")
+	api.HandleFunc("/users/me", eraseUserHandler).Methods("DELETE", "OPTIONS")
+	api.HandleFunc("/service-accounts", createServiceAccountHandler).Methods("POST", "OPTIONS")
+	api.HandleFunc("/service-accounts", getServiceAccountsHandler).Methods("GET", "OPTIONS")
+	api.HandleFunc("/service-accounts/{id}/keys", createAPIKeyHandler).Methods("POST", "OPTIONS")
+	api.HandleFunc("/service-accounts/{id}/keys", getAPIKeysHandler).Methods("GET", "OPTIONS")
+	api.HandleFunc("/service-accounts/{id}/keys/{keyId}/rotate", rotateAPIKeyHandler).Methods("POST", "OPTIONS")
+	api.HandleFunc("/service-accounts/{id}/keys/{keyId}", deleteAPIKeyHandler).Methods("DELETE", "OPTIONS")
 
 	// Meeting routes
 	api.HandleFunc("/meetings", createMeetingHandler).Methods("POST", "OPTIONS")
-	api.HandleFunc("/meetings", getMeetingsHandler).Methods("GET", "OPTIONS")
+	api.HandleFunc("/meetings", withETag(getMeetingsHandler)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/meetings/code/{code}", getMeetingByCodeHandler).Methods("GET", "OPTIONS")
+	api.HandleFunc("/meetings/archived", getArchivedMeetingsHandler).Methods("GET", "OPTIONS")
 	api.HandleFunc("/meetings/{id}", getMeetingHandler).Methods("GET", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/invite.ics", meetingInviteICSHandler).Methods("GET", "OPTIONS")
 	api.HandleFunc("/meetings/{id}/join", notifyJoinHandler).Methods("POST", "OPTIONS")
-	api.HandleFunc("/meetings/{id}/participants", getParticipantsHandler).Methods("GET", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/queue", getOverflowEntryHandler).Methods("GET", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/queue", leaveOverflowHandler).Methods("DELETE", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/embed-token", createEmbedTokenHandler).Methods("POST", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/ip-rules", getMeetingIPRulesHandler).Methods("GET", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/ip-rules", setMeetingIPRulesHandler).Methods("PUT", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/lock", lockMeetingHandler(true)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/unlock", lockMeetingHandler(false)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/end", endMeetingHandler).Methods("POST", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/clone", cloneMeetingHandler).Methods("POST", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/spotlight", setSpotlightHandler).Methods("POST", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/spotlight", clearSpotlightHandler).Methods("DELETE", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/participants", withETag(getParticipantsHandler)).Methods("GET", "OPTIONS")
 	api.HandleFunc("/meetings/{id}/participants", updateParticipantHandler).Methods("PUT", "PATCH", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/participants/me", leaveMeetingHandler).Methods("DELETE", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/heartbeat", heartbeatHandler).Methods("POST", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/quality", getMeetingQualityHandler).Methods("GET", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/whiteboard", getWhiteboardHandler).Methods("GET", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/notes", getNotesHandler).Methods("GET", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/summary", getMeetingSummaryHandler).Methods("GET", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/attendance", getAttendanceHandler).Methods("GET", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/timeline", getMeetingTimelineHandler).Methods("GET", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/state", withETag(getMeetingStateHandler)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/recordings", getMeetingRecordingsHandler).Methods("GET", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/recordings", uploadRecordingHandler).Methods("POST", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/recording/start", startRecordingHandler).Methods("POST", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/recording/layout", updateRecordingLayoutHandler).Methods("PUT", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/recording/stop", stopRecordingHandler).Methods("POST", "OPTIONS")
+	api.HandleFunc("/recordings/{id}", getRecordingHandler).Methods("GET", "OPTIONS")
+	api.HandleFunc("/recordings/{id}/stream", streamRecordingHandler).Methods("GET", "HEAD", "OPTIONS")
+	api.HandleFunc("/recordings/{id}/{media:poster|preview}", recordingPreviewMediaHandler).Methods("GET", "HEAD", "OPTIONS")
+	api.HandleFunc("/recordings/{id}/captions.vtt", recordingCaptionsHandler).Methods("GET", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/analytics", getMeetingAnalyticsHandler).Methods("GET", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/transcript", getTranscriptHandler).Methods("GET", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/transcription/start", transcriptionHandler(true)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/transcription/stop", transcriptionHandler(false)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/participants/{userId}/role", updateParticipantRoleHandler).Methods("PUT", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/participants/{userId}/bandwidth", updateBandwidthCapHandler).Methods("PUT", "OPTIONS")
+
+	// Webhook routes
+	api.HandleFunc("/webhooks", createWebhookHandler).Methods("POST", "OPTIONS")
+	api.HandleFunc("/webhooks", getWebhooksHandler).Methods("GET", "OPTIONS")
+	api.HandleFunc("/webhooks/{id}", deleteWebhookHandler).Methods("DELETE", "OPTIONS")
+	api.HandleFunc("/webhooks/{id}/deliveries", getWebhookDeliveriesHandler).Methods("GET", "OPTIONS")
+
+	// Slack integration routes
+	slack := api.PathPrefix("/integrations/slack").Subrouter()
+	slack.Use(requireSlack)
+	slack.HandleFunc("/install", slackInstallHandler).Methods("GET")
+	slack.HandleFunc("/oauth/callback", slackOAuthCallbackHandler).Methods("GET")
+	slack.HandleFunc("/commands", slackCommandHandler).Methods("POST")
+
+	// Chat routes
+	api.HandleFunc("/meetings/{id}/chat", withETag(getChatHistoryHandler)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/chat/filter", setChatFilterHandler).Methods("PUT", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/chat/read", markChatReadHandler).Methods("POST", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/chat/attachments", uploadChatAttachmentHandler).Methods("POST", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/chat/attachments/{attachmentId}", downloadChatAttachmentHandler).Methods("GET", "HEAD", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/chat/{messageId}", editChatMessageHandler).Methods("PUT", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/chat/{messageId}", deleteChatMessageHandler).Methods("DELETE", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/chat/{messageId}/history", getChatMessageHistoryHandler).Methods("GET", "OPTIONS")
+
+	// Invitation routes
+	api.HandleFunc("/meetings/{id}/invitations", createInvitationsHandler).Methods("POST", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/invitations", getInvitationsHandler).Methods("GET", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/quick-invite", quickInviteHandler).Methods("POST", "OPTIONS")
+	api.HandleFunc("/invitations/{token}/accept", respondInvitationHandler(InvitationAccepted)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/invitations/{token}/decline", respondInvitationHandler(InvitationDeclined)).Methods("POST", "OPTIONS")
+
+	// Poll routes
+	api.HandleFunc("/meetings/{id}/polls", createPollHandler).Methods("POST", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/polls", getPollsHandler).Methods("GET", "OPTIONS")
+	api.HandleFunc("/meetings/{id}/polls/{pollId}/close", closePollHandler).Methods("POST", "OPTIONS")
+
+	// Admin routes
+	admin := api.PathPrefix("/admin").Subrouter()
+	admin.Use(requireAdmin)
+	admin.Handle("/debug", withAdminPermission(AdminPermissionDebug, adminDebugHandler)).Methods("GET")
+	admin.Handle("/users", withAdminPermission(AdminPermissionViewUsers, adminListUsersHandler)).Methods("GET", "OPTIONS")
+	admin.Handle("/users/{id}", withAdminPermission(AdminPermissionViewUsers, adminGetUserHandler)).Methods("GET", "OPTIONS")
+	admin.Handle("/users/{id}/disable", withAdminPermission(AdminPermissionManageUsers, adminSetDisabledHandler(true))).Methods("POST", "OPTIONS")
+	admin.Handle("/users/{id}/enable", withAdminPermission(AdminPermissionManageUsers, adminSetDisabledHandler(false))).Methods("POST", "OPTIONS")
+	admin.Handle("/users/{id}/reset-password", withAdminPermission(AdminPermissionManageUsers, adminResetPasswordHandler)).Methods("POST", "OPTIONS")
+	admin.Handle("/users/{id}/plan", withAdminPermission(AdminPermissionManageUsers, adminSetPlanHandler)).Methods("PUT", "OPTIONS")
+	admin.Handle("/users/{id}/unlock", withAdminPermission(AdminPermissionManageUsers, unlockAccountHandler)).Methods("POST", "OPTIONS")
+	admin.Handle("/meetings", withAdminPermission(AdminPermissionViewMeetings, adminListMeetingsHandler)).Methods("GET", "OPTIONS")
+	admin.Handle("/meetings/{id}/end", withAdminPermission(AdminPermissionManageMeetings, adminEndMeetingHandler)).Methods("POST", "OPTIONS")
+	admin.Handle("/meetings/{id}/participants/{userId}/kick", withAdminPermission(AdminPermissionManageMeetings, adminKickParticipantHandler)).Methods("POST", "OPTIONS")
+	admin.Handle("/hub", withAdminPermission(AdminPermissionViewMeetings, adminHubStatsHandler)).Methods("GET", "OPTIONS")
+	admin.Handle("/drain", withAdminPermission(AdminPermissionManageMeetings, adminDrainHandler)).Methods("POST", "OPTIONS")
+	admin.Handle("/jobs", withAdminPermission(AdminPermissionDebug, adminJobsHandler)).Methods("GET", "OPTIONS")
+	admin.Handle("/analytics/daily", withAdminPermission(AdminPermissionViewMeetings, adminDailyAnalyticsHandler)).Methods("GET", "OPTIONS")
+	admin.Handle("/signing-keys/rotate", withAdminPermission(AdminPermissionManageSecrets, adminRotateSigningKeyHandler)).Methods("POST", "OPTIONS")
+	admin.Handle("/data-keys/rotate", withAdminPermission(AdminPermissionManageSecrets, adminRotateDataKeyHandler)).Methods("POST", "OPTIONS")
+	admin.Handle("/cors-origins", withAdminPermission(AdminPermissionManageOrigins, adminListCORSOriginsHandler)).Methods("GET", "OPTIONS")
+	admin.Handle("/cors-origins", withAdminPermission(AdminPermissionManageOrigins, adminAddCORSOriginHandler)).Methods("POST", "OPTIONS")
+	admin.Handle("/cors-origins/{id}", withAdminPermission(AdminPermissionManageOrigins, adminRemoveCORSOriginHandler)).Methods("DELETE", "OPTIONS")
+	registerPprofRoutes(admin)
 
 	// WebSocket endpoint
 	api.HandleFunc("/ws/{meetingId}", websocketHandler).Methods("GET")
+	api.HandleFunc("/sse/{meetingId}", sseStreamHandler).Methods("GET")
+	api.HandleFunc("/sse/{meetingId}/{sessionId}", sseSendHandler).Methods("POST", "OPTIONS")
+	api.HandleFunc("/presence/ws", presenceWebSocketHandler).Methods("GET")
+	api.HandleFunc("/presence", getPresenceHandler).Methods("GET", "OPTIONS")
+
+	// GraphQL
+	api.HandleFunc("/graphql", graphQLHandler).Methods("POST", "OPTIONS")
+
+	// API description
+	api.HandleFunc("/openapi.json", openAPIHandler(r)).Methods("GET", "OPTIONS")
+	if appConfig.Server.SwaggerUI {
+		api.HandleFunc("/docs", swaggerUIHandler).Methods("GET")
+	}
 
 	// Health check endpoints
 	api.HandleFunc("/health", healthCheckHandler).Methods("GET", "OPTIONS")
 	r.HandleFunc("/health", healthCheckHandler).Methods("GET", "OPTIONS")
-	r.HandleFunc("/", healthCheckHandler).Methods("GET", "OPTIONS")
-
-	// Additional CORS setup
-	c := cors.New(cors.Options{
-		AllowedOrigins:   allowedOrigins,
-		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Content-Type", "Authorization", "Accept", "Origin", "X-Requested-With"},
-		ExposedHeaders:   []string{"Content-Type", "Authorization", "Set-Cookie"},
-		AllowCredentials: true,
-		MaxAge:           300,
-		Debug:            false,
-	})
 
-	handler := c.Handler(r)
+	// Kubernetes probes
+	r.HandleFunc("/live", livenessHandler).Methods("GET")
+	r.HandleFunc("/ready", readinessHandler).Methods("GET")
+	r.HandleFunc("/", healthCheckHandler).Methods("GET", "OPTIONS")
 
-	// Determine port
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = DefaultPort
+	// CORS wraps the router so preflights are answered for every path
+	handler := tenantMiddleware(corsMiddleware(r))
+	if appConfig.TLS.Enabled() {
+		handler = withHSTS(appConfig.TLS, handler)
 	}
 
 	// Create server with timeouts
+	port := appConfig.Server.Port
 	server := &http.Server{
 		Addr:         ":" + port,
 		Handler:      handler,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		ReadTimeout:  time.Duration(appConfig.Server.ReadTimeout),
+		WriteTimeout: time.Duration(appConfig.Server.WriteTimeout),
+		IdleTimeout:  time.Duration(appConfig.Server.IdleTimeout),
+	}
+
+	// Terminate TLS here when configured, with certificates from Let's Encrypt
+	var redirectServer *http.Server
+	if appConfig.TLS.Enabled() {
+		certManager := newCertManager(appConfig.TLS)
+		server.TLSConfig = certManager.TLSConfig()
+		redirectServer = newHTTPRedirectServer(certManager, appConfig.TLS, port)
+		go func() {
+			log.Printf("Redirecting HTTP on port %s to HTTPS", appConfig.TLS.HTTPPort)
+			if err := redirectServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Failed to start HTTP redirect server: %v", err)
+			}
+		}()
 	}
 
 	// Create channel for shutdown signals
@@ -928,8 +2299,15 @@ func main() {
 	// Start server
 	go func() {
 		log.Printf("Server starting on port %s", port)
-		log.Printf("Allowed origins: %v", allowedOrigins)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("Allowed origins: %v", appConfig.CORS.AllowedOrigins)
+		var err error
+		if server.TLSConfig != nil {
+			log.Printf("Serving HTTPS for %v", appConfig.TLS.Domains)
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Failed to start server: %v", err)
 		}
 	}()
@@ -938,13 +2316,26 @@ func main() {
 	<-quit
 	log.Println("Shutting down server...")
 
+	// Hand meetings over before the connections go down
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), time.Duration(appConfig.Server.ShutdownTimeout))
+	if _, err := drainInstance(drainCtx, appConfig.Handover.ReconnectURL); err != nil && !errors.Is(err, errAlreadyDraining) {
+		log.Printf("Error handing meetings over: %v", err)
+	}
+	cancelDrain()
+
 	// Graceful shutdown
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(appConfig.Server.ShutdownTimeout))
 	defer cancel()
 
 	if err := server.Shutdown(ctx); err != nil {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
+	if redirectServer != nil {
+		redirectServer.Shutdown(ctx)
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		log.Printf("Error flushing traces: %v", err)
+	}
 
 	log.Println("Server exiting")
 }
\ No newline at end of file
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+)
+
+// MeetingArchiveBatchSize caps how many meetings one retention run archives,
+// so a backlog is worked off over several runs
+const MeetingArchiveBatchSize = 500
+
+// ArchivedMeeting is an ended meeting moved out of the meetings collection.
+// It is listed for its members: the host, invitees and everyone who joined.
+type ArchivedMeeting struct {
+	Meeting   `bson:",inline"`
+	MemberIDs []string `json:"-" bson:"memberIds"`
+}
+
+// archiveEndedMeetings moves meetings that ended more than
+// retention.archiveAfter ago to the archive, returning how many moved
+func archiveEndedMeetings(ctx context.Context, now time.Time) (int64, error) {
+	archiveAfter := time.Duration(appConfig.Retention.ArchiveAfter)
+	if archiveAfter <= 0 {
+		return 0, nil
+	}
+
+	cursor, err := db.Meetings.Find(ctx,
+		bson.M{"isActive": false, "updatedAt": bson.M{"$lt": now.Add(-archiveAfter)}},
+		options.Find().SetLimit(MeetingArchiveBatchSize))
+	if err != nil {
+		return 0, err
+	}
+	var meetings []Meeting
+	if err := cursor.All(ctx, &meetings); err != nil {
+		return 0, err
+	}
+
+	var archived int64
+	for _, meeting := range meetings {
+		members, err := meetingMemberIDs(ctx, meeting)
+		if err != nil {
+			return archived, err
+		}
+		meeting.ArchivedAt = &now
+		// Copied before the original goes, so a failed run loses nothing and
+		// the next one redoes the copy
+		_, err = db.MeetingsArchive.ReplaceOne(ctx, bson.M{"_id": meeting.ID},
+			ArchivedMeeting{Meeting: meeting, MemberIDs: members}, options.Replace().SetUpsert(true))
+		if err != nil {
+			return archived, err
+		}
+		// A meeting reactivated meanwhile stays
+		result, err := db.Meetings.DeleteOne(ctx, bson.M{"_id": meeting.ID, "isActive": false, "updatedAt": meeting.UpdatedAt})
+		if err != nil {
+			return archived, err
+		}
+		if result.DeletedCount == 0 {
+			if _, err := db.MeetingsArchive.DeleteOne(ctx, bson.M{"_id": meeting.ID}); err != nil {
+				return archived, err
+			}
+			continue
+		}
+		meetingLookups.Invalidate(ctx, meeting.ID)
+		archived++
+	}
+	return archived, nil
+}
+
+// meetingMemberIDs lists the users an archived meeting is listed for
+func meetingMemberIDs(ctx context.Context, meeting Meeting) ([]string, error) {
+	attendees, err := db.AttendanceEvents.Distinct(ctx, "userId", bson.M{"meetingId": meeting.ID})
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var members []string
+	add := func(userID string) {
+		if userID != "" && !seen[userID] {
+			seen[userID] = true
+			members = append(members, userID)
+		}
+	}
+	add(meeting.CreatedBy)
+	for _, userID := range meeting.Invitees {
+		add(userID)
+	}
+	for _, userID := range attendees {
+		if userID, ok := userID.(string); ok {
+			add(userID)
+		}
+	}
+	return members, nil
+}
+
+// findArchivedMeeting loads an archived meeting matching filter; codes are
+// freed for reuse once archived, so the newest holder of a code wins
+func findArchivedMeeting(ctx context.Context, filter bson.M) (Meeting, error) {
+	var archived ArchivedMeeting
+	err := db.MeetingsArchive.FindOne(ctx, filter,
+		options.FindOne().SetSort(bson.D{{Key: "createdAt", Value: -1}})).Decode(&archived)
+	return archived.Meeting, err
+}
+
+// getArchivedMeetingsHandler lists the archived meetings the caller hosted,
+// was invited to or joined, newest first, paged like the meetings listing
+func getArchivedMeetingsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	query := r.URL.Query()
+
+	limit := DefaultMeetingsPageSize
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			sendErrorResponse(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(parsed, MaxMeetingsPageSize)
+	}
+
+	filter := bson.M{"memberIds": userID}
+	if value := query.Get("cursor"); value != "" {
+		createdAt, id, err := decodeMeetingsCursor(value)
+		if err != nil {
+			sendErrorResponse(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		filter["$or"] = []bson.M{
+			{"createdAt": bson.M{"$lt": createdAt}},
+			{"createdAt": createdAt, "_id": bson.M{"$lt": id}},
+		}
+	}
+
+	// Fetch one extra document to know whether another page exists
+	cursor, err := db.MeetingsArchive.Find(r.Context(), filter, options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(limit+1)))
+	if err != nil {
+		sendErrorResponse(w, "Failed to fetch meetings", http.StatusInternalServerError)
+		return
+	}
+	var archived []ArchivedMeeting
+	if err := cursor.All(r.Context(), &archived); err != nil {
+		sendErrorResponse(w, "Failed to parse meetings", http.StatusInternalServerError)
+		return
+	}
+
+	meetings := make([]Meeting, 0, len(archived))
+	for _, entry := range archived {
+		meetings = append(meetings, entry.Meeting)
+	}
+	nextCursor := ""
+	if len(meetings) > limit {
+		meetings = meetings[:limit]
+		last := meetings[limit-1]
+		nextCursor = encodeMeetingsCursor(last.CreatedAt, last.ID)
+	}
+
+	sendSuccessResponse(w, map[string]interface{}{
+		"meetings":   meetings,
+		"nextCursor": nextCursor,
+	})
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	meetingCacheKeyPrefix     = "meeting:id:"
+	meetingCodeCacheKeyPrefix = "meeting:code:"
+	meetingInvalidateChannel  = "meeting:invalidate"
+)
+
+// meetingLookups caches findMeeting; created in main from the cache config
+var meetingLookups = newMeetingCache(0, time.Minute, nil)
+
+type meetingCacheEntry struct {
+	meeting   Meeting
+	expiresAt time.Time
+}
+
+// meetingCache is a read-through cache of meetings by ID and code: an
+// in-process LRU in front of an optional Redis layer shared by every
+// instance. Writers call Invalidate after changing a meeting; entries also
+// expire after ttl, which bounds how stale a missed invalidation can leave
+// a lookup.
+type meetingCache struct {
+	size   int
+	ttl    time.Duration
+	client *redis.Client
+
+	mu      sync.Mutex
+	order   *list.List               // of *meetingCacheEntry, most recently used first
+	entries map[string]*list.Element // by meeting ID
+	codes   map[string]string        // meeting code -> ID, for entries in memory
+}
+
+// newMeetingCache returns a cache holding up to size meetings in memory,
+// backed by client when it is non-nil. A size of 0 disables the cache.
+func newMeetingCache(size int, ttl time.Duration, client *redis.Client) *meetingCache {
+	return &meetingCache{
+		size:    size,
+		ttl:     ttl,
+		client:  client,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+		codes:   make(map[string]string),
+	}
+}
+
+// newRedisClient connects to the Redis server at redisURL
+func newRedisClient(redisURL string) (*redis.Client, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// Get returns the cached meeting with the ID, or with the code when code is set
+func (c *meetingCache) Get(ctx context.Context, meetingID, code string) (Meeting, bool) {
+	if c.size == 0 {
+		return Meeting{}, false
+	}
+	if meeting, ok := c.getLocal(meetingID, code, time.Now()); ok {
+		return meeting, true
+	}
+	if c.client == nil {
+		return Meeting{}, false
+	}
+
+	meeting, err := c.getShared(ctx, meetingID, code)
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			log.Printf("Meeting cache: Redis lookup failed: %v", err)
+		}
+		return Meeting{}, false
+	}
+	c.putLocal(meeting, time.Now())
+	return meeting, true
+}
+
+func (c *meetingCache) getLocal(meetingID, code string, now time.Time) (Meeting, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if code != "" {
+		id, ok := c.codes[code]
+		if !ok {
+			return Meeting{}, false
+		}
+		meetingID = id
+	}
+	element, ok := c.entries[meetingID]
+	if !ok {
+		return Meeting{}, false
+	}
+	entry := element.Value.(*meetingCacheEntry)
+	if !now.Before(entry.expiresAt) {
+		c.removeLocked(element)
+		return Meeting{}, false
+	}
+	c.order.MoveToFront(element)
+	return entry.meeting, true
+}
+
+func (c *meetingCache) getShared(ctx context.Context, meetingID, code string) (Meeting, error) {
+	var meeting Meeting
+	if code != "" {
+		id, err := c.client.Get(ctx, meetingCodeCacheKeyPrefix+code).Result()
+		if err != nil {
+			return meeting, err
+		}
+		meetingID = id
+	}
+	data, err := c.client.Get(ctx, meetingCacheKeyPrefix+meetingID).Bytes()
+	if err != nil {
+		return meeting, err
+	}
+	err = bson.Unmarshal(data, &meeting)
+	return meeting, err
+}
+
+// Put caches a meeting just loaded from the database
+func (c *meetingCache) Put(ctx context.Context, meeting Meeting) {
+	if c.size == 0 {
+		return
+	}
+	c.putLocal(meeting, time.Now())
+	if c.client == nil {
+		return
+	}
+
+	data, err := bson.Marshal(meeting)
+	if err != nil {
+		log.Printf("Meeting cache: error encoding meeting %s: %v", meeting.ID, err)
+		return
+	}
+	pipe := c.client.Pipeline()
+	pipe.Set(ctx, meetingCacheKeyPrefix+meeting.ID, data, c.ttl)
+	if meeting.Code != "" {
+		pipe.Set(ctx, meetingCodeCacheKeyPrefix+meeting.Code, meeting.ID, c.ttl)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Meeting cache: Redis store failed: %v", err)
+	}
+}
+
+func (c *meetingCache) putLocal(meeting Meeting, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[meeting.ID]; ok {
+		c.removeLocked(element)
+	}
+	c.entries[meeting.ID] = c.order.PushFront(&meetingCacheEntry{meeting: meeting, expiresAt: now.Add(c.ttl)})
+	if meeting.Code != "" {
+		c.codes[meeting.Code] = meeting.ID
+	}
+	for c.order.Len() > c.size {
+		c.removeLocked(c.order.Back())
+	}
+}
+
+func (c *meetingCache) removeLocked(element *list.Element) {
+	entry := c.order.Remove(element).(*meetingCacheEntry)
+	delete(c.entries, entry.meeting.ID)
+	if entry.meeting.Code != "" {
+		delete(c.codes, entry.meeting.Code)
+	}
+}
+
+// Invalidate drops the meeting from the cache after it changed, telling
+// the other instances to do the same
+func (c *meetingCache) Invalidate(ctx context.Context, meetingID string) {
+	if c.size == 0 {
+		return
+	}
+	c.evictLocal(meetingID)
+	if c.client == nil {
+		return
+	}
+
+	// The code entry just points at the ID and is left to expire
+	pipe := c.client.Pipeline()
+	pipe.Del(ctx, meetingCacheKeyPrefix+meetingID)
+	pipe.Publish(ctx, meetingInvalidateChannel, meetingID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Meeting cache: Redis invalidation of %s failed: %v", meetingID, err)
+	}
+}
+
+func (c *meetingCache) evictLocal(meetingID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[meetingID]; ok {
+		c.removeLocked(element)
+	}
+}
+
+// Run evicts meetings invalidated by other instances until ctx is
+// cancelled. It returns at once when the cache isn't shared.
+func (c *meetingCache) Run(ctx context.Context) {
+	if c.size == 0 || c.client == nil {
+		return
+	}
+	sub := c.client.Subscribe(ctx, meetingInvalidateChannel)
+	defer sub.Close()
+
+	messages := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case message, ok := <-messages:
+			if !ok {
+				return
+			}
+			c.evictLocal(message.Payload)
+		}
+	}
+}
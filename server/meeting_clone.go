@@ -0,0 +1,105 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+type cloneMeetingRequest struct {
+	Title        string `json:"title,omitempty"`
+	ScheduledFor string `json:"scheduledFor,omitempty"`
+	Timezone     string `json:"timezone,omitempty"`
+}
+
+// cloneMeetingHandler creates a new meeting with the title, settings and
+// invitees of one the caller hosts. The body may rename it and schedule it;
+// otherwise the copy keeps the title and is unscheduled. Limits are
+// re-applied as for a new meeting, since the host's plan or organization may
+// have changed.
+func cloneMeetingHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req cloneMeetingRequest
+	if r.ContentLength != 0 && !decodeStrictJSONBody(w, r, &req) {
+		return
+	}
+
+	source, err := findMeeting(r.Context(), mux.Vars(r)["id"])
+	if err != nil || !canViewMeeting(source, userID) {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+	if source.CreatedBy != userID {
+		sendCodedError(w, ErrCodeHostOnly, "Only the host can clone the meeting")
+		return
+	}
+
+	title := strings.TrimSpace(req.Title)
+	if title == "" {
+		title = source.Title
+	}
+	scheduledFor, err := parseScheduledFor(req.ScheduledFor, req.Timezone)
+	if err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	org, err := loadMeetingOrg(r.Context(), source.OrgID, userID)
+	if err != nil {
+		if err == errNotOrgMember {
+			sendErrorResponse(w, err.Error(), http.StatusForbidden)
+		} else {
+			sendErrorResponse(w, "Organization not found", http.StatusNotFound)
+		}
+		return
+	}
+	defaults := meetingDefaults(org)
+
+	quota, err := loadQuotaState(r.Context(), userID, time.Now())
+	if err != nil {
+		sendErrorResponse(w, "Failed to load quota", http.StatusInternalServerError)
+		return
+	}
+	maxParticipants := min(source.MaxParticipants, defaults.MaxParticipants, quota.Limits.MaxParticipants)
+	if maxParticipants <= 0 {
+		maxParticipants = min(defaults.DefaultParticipants, quota.Limits.MaxParticipants)
+	}
+
+	now := time.Now()
+	meeting := Meeting{
+		ID:                   uuid.New().String(),
+		Title:                title,
+		Description:          source.Description,
+		CreatedBy:            userID,
+		ScheduledFor:         scheduledFor,
+		CreatedAt:            now,
+		UpdatedAt:            now,
+		IsPrivate:            source.IsPrivate,
+		IsActive:             true,
+		MaxParticipants:      maxParticipants,
+		Invitees:             source.Invitees,
+		OrgID:                source.OrgID,
+		RecordingPolicy:      defaults.RecordingPolicy,
+		TranscriptionEnabled: source.TranscriptionEnabled,
+		ChatFilter:           source.ChatFilter,
+		IPRules:              source.IPRules,
+		E2EE:                 source.E2EE,
+	}
+
+	if err := insertMeeting(r.Context(), &meeting); err != nil {
+		log.Printf("Error cloning meeting %s: %v", source.ID, err)
+		sendErrorResponse(w, "Error creating meeting", http.StatusInternalServerError)
+		return
+	}
+
+	sendSuccessResponse(w, meeting)
+}
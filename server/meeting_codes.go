@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/rand"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const meetingCodeAlphabet = "abcdefghijklmnopqrstuvwxyz"
+
+// Meet-style codes: three groups of 3, 4 and 3 lowercase letters
+var meetingCodePattern = regexp.MustCompile(`^[a-z]{3}-[a-z]{4}-[a-z]{3}$`)
+
+// generateMeetingCode returns a random code such as "abc-defg-hij"
+func generateMeetingCode() (string, error) {
+	letters := make([]byte, 10)
+	max := big.NewInt(int64(len(meetingCodeAlphabet)))
+	for i := range letters {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		letters[i] = meetingCodeAlphabet[n.Int64()]
+	}
+	return string(letters[:3]) + "-" + string(letters[3:7]) + "-" + string(letters[7:]), nil
+}
+
+// normalizeMeetingCode accepts codes typed with any case, spaces, or without
+// dashes and returns the canonical form, or "" if it is not a valid code
+func normalizeMeetingCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.NewReplacer(" ", "", "-", "").Replace(code)
+	if len(code) != 10 {
+		return ""
+	}
+	code = code[:3] + "-" + code[3:7] + "-" + code[7:]
+	if !meetingCodePattern.MatchString(code) {
+		return ""
+	}
+	return code
+}
+
+func getMeetingByCodeHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	code := normalizeMeetingCode(vars["code"])
+	if code == "" {
+		sendErrorResponse(w, "Invalid meeting code", http.StatusBadRequest)
+		return
+	}
+
+	meeting, err := findMeeting(r.Context(), code)
+	if err != nil {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+
+	sendSuccessResponse(w, meeting)
+}
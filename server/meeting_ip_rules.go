@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"video-meeting-app/db"
+)
+
+// MaxMeetingIPRules caps the allow and deny lists together
+const MaxMeetingIPRules = 50
+
+// MeetingIPRules restrict which client addresses may join an organization's
+// meeting, e.g. to a corporate VPN range. Deny rules win; when there are
+// allow rules, an address must match one of them.
+type MeetingIPRules struct {
+	Allow     []string  `json:"allow" bson:"allow,omitempty"`
+	Deny      []string  `json:"deny" bson:"deny,omitempty"`
+	UpdatedBy string    `json:"updatedBy,omitempty" bson:"updatedBy,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+// normalizeCIDRs parses CIDRs, and bare addresses as single-address ranges,
+// returning them in canonical form
+func normalizeCIDRs(entries []string) ([]string, error) {
+	normalized := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			normalized = append(normalized, netip.PrefixFrom(addr, addr.BitLen()).String())
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a CIDR range or IP address", entry)
+		}
+		normalized = append(normalized, prefix.Masked().String())
+	}
+	return normalized, nil
+}
+
+func matchesAnyCIDR(addr netip.Addr, cidrs []string) bool {
+	for _, cidr := range cidrs {
+		if prefix, err := netip.ParsePrefix(cidr); err == nil && prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// meetingAllowsClientIP reports whether the meeting's IP rules admit the
+// request's client. Addresses that can't be parsed only pass meetings
+// without rules.
+func meetingAllowsClientIP(meeting Meeting, r *http.Request) bool {
+	rules := meeting.IPRules
+	if rules == nil || (len(rules.Allow) == 0 && len(rules.Deny) == 0) {
+		return true
+	}
+	addr, ok := clientAddr(r)
+	if !ok || matchesAnyCIDR(addr, rules.Deny) {
+		return false
+	}
+	return len(rules.Allow) == 0 || matchesAnyCIDR(addr, rules.Allow)
+}
+
+// requireMeetingOrgAdmin loads the meeting in the route and checks that the
+// caller is an owner or admin of its organization, writing the error
+// response if not
+func requireMeetingOrgAdmin(w http.ResponseWriter, r *http.Request, userID string) (Meeting, bool) {
+	meeting, err := findMeeting(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return meeting, false
+	}
+	if meeting.OrgID == "" {
+		sendErrorResponse(w, "IP rules are only available for organization meetings", http.StatusBadRequest)
+		return meeting, false
+	}
+	if _, _, ok := requireOrgRole(w, r, meeting.OrgID, userID, OrgRoleOwner, OrgRoleAdmin); !ok {
+		return meeting, false
+	}
+	return meeting, true
+}
+
+// getMeetingIPRulesHandler returns the meeting's IP rules to its
+// organization's owners and admins
+func getMeetingIPRulesHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	meeting, ok := requireMeetingOrgAdmin(w, r, userID)
+	if !ok {
+		return
+	}
+	if meeting.IPRules == nil {
+		sendSuccessResponse(w, MeetingIPRules{Allow: []string{}, Deny: []string{}})
+		return
+	}
+	sendSuccessResponse(w, meeting.IPRules)
+}
+
+type meetingIPRulesRequest struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// setMeetingIPRulesHandler replaces the meeting's IP rules; empty lists
+// remove them. Participants already in the meeting are not removed.
+func setMeetingIPRulesHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req meetingIPRulesRequest
+	if !decodeStrictJSONBody(w, r, &req) {
+		return
+	}
+	if len(req.Allow)+len(req.Deny) > MaxMeetingIPRules {
+		sendErrorResponse(w, fmt.Sprintf("At most %d IP rules are allowed", MaxMeetingIPRules), http.StatusBadRequest)
+		return
+	}
+	allow, err := normalizeCIDRs(req.Allow)
+	if err != nil {
+		sendErrorResponse(w, "allow: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	deny, err := normalizeCIDRs(req.Deny)
+	if err != nil {
+		sendErrorResponse(w, "deny: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	meeting, ok := requireMeetingOrgAdmin(w, r, userID)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	rules := MeetingIPRules{Allow: allow, Deny: deny, UpdatedBy: userID, UpdatedAt: now}
+	update := bson.M{"$set": bson.M{"ipRules": rules, "updatedAt": now}}
+	if len(allow) == 0 && len(deny) == 0 {
+		update = bson.M{"$unset": bson.M{"ipRules": ""}, "$set": bson.M{"updatedAt": now}}
+	}
+	if _, err := db.Meetings.UpdateOne(r.Context(), bson.M{"_id": meeting.ID}, update); err != nil {
+		log.Printf("Error updating IP rules of meeting %s: %v", meeting.ID, err)
+		sendErrorResponse(w, "Failed to update meeting", http.StatusInternalServerError)
+		return
+	}
+	meetingLookups.Invalidate(r.Context(), meeting.ID)
+
+	sendSuccessResponse(w, rules)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+)
+
+// MeetingSummary is what a meeting leaves behind: who attended and the
+// shared notes
+type MeetingSummary struct {
+	MeetingID string             `json:"meetingId"`
+	Title     string             `json:"title"`
+	StartedAt *time.Time         `json:"startedAt,omitempty"`
+	EndedAt   *time.Time         `json:"endedAt,omitempty"` // unset while the meeting is active
+	Attendees []AttendanceRecord `json:"attendees"`
+	Notes     MeetingNotes       `json:"notes"`
+}
+
+// loadMeetingSummary assembles the meeting's summary
+func loadMeetingSummary(ctx context.Context, meeting Meeting) (MeetingSummary, error) {
+	summary := MeetingSummary{MeetingID: meeting.ID, Title: meeting.Title, StartedAt: meeting.StartedAt}
+
+	until := time.Now()
+	if !meeting.IsActive {
+		until = meeting.UpdatedAt
+		summary.EndedAt = &until
+	}
+
+	cursor, err := db.AttendanceEvents.Find(
+		ctx,
+		bson.M{"meetingId": meeting.ID},
+		options.Find().SetSort(bson.D{{Key: "at", Value: 1}}),
+	)
+	if err != nil {
+		return summary, err
+	}
+	var events []AttendanceEvent
+	if err := cursor.All(ctx, &events); err != nil {
+		return summary, err
+	}
+	summary.Attendees = summarizeAttendance(events, until)
+
+	summary.Notes, err = loadNotes(ctx, meeting.ID)
+	return summary, err
+}
+
+// writeSummaryMarkdown writes the summary as a Markdown document
+func writeSummaryMarkdown(w http.ResponseWriter, summary MeetingSummary) {
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="summary-%s.md"`, summary.MeetingID))
+	w.WriteHeader(http.StatusOK)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", summary.Title)
+	if summary.StartedAt != nil {
+		fmt.Fprintf(&b, "Started: %s\n", summary.StartedAt.UTC().Format(time.RFC3339))
+	}
+	if summary.EndedAt != nil {
+		fmt.Fprintf(&b, "Ended: %s\n", summary.EndedAt.UTC().Format(time.RFC3339))
+	}
+
+	b.WriteString("\n## Attendees\n\n")
+	for _, record := range summary.Attendees {
+		fmt.Fprintf(&b, "- %s (%.0f min)\n", record.UserName, float64(record.TotalSeconds)/60)
+	}
+
+	b.WriteString("\n## Notes\n\n")
+	if notes := strings.TrimSpace(summary.Notes.Text); notes != "" {
+		b.WriteString(notes + "\n")
+	} else {
+		b.WriteString("_No notes were taken._\n")
+	}
+	w.Write([]byte(b.String()))
+}
+
+// getMeetingSummaryHandler returns the meeting's summary to the host, as
+// JSON or as a Markdown download with ?format=md
+func getMeetingSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	meeting, err := findMeeting(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+	if meeting.CreatedBy != userID {
+		sendCodedError(w, ErrCodeHostOnly, "Only the host can view the meeting summary")
+		return
+	}
+
+	summary, err := loadMeetingSummary(r.Context(), meeting)
+	if err != nil {
+		log.Printf("Error loading summary of meeting %s: %v", meeting.ID, err)
+		sendErrorResponse(w, "Failed to fetch meeting summary", http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "md" {
+		writeSummaryMarkdown(w, summary)
+		return
+	}
+	sendSuccessResponse(w, summary)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"video-meeting-app/config"
+	"video-meeting-app/db"
+	"video-meeting-app/migrations"
+)
+
+const MigrationTimeout = 30 * time.Minute
+
+// runStartupMigrations applies pending migrations when configured to, and
+// otherwise warns about any that are pending
+func runStartupMigrations() error {
+	// Migrations rewrite MongoDB collections and indexes; other drivers
+	// create their tables as they are now
+	if appConfig.Database.Driver != config.DatabaseDriverMongo {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), MigrationTimeout)
+	defer cancel()
+
+	if !appConfig.Database.MigrateOnStartup {
+		pending, err := migrations.Pending(ctx, db.Database)
+		if err != nil {
+			return err
+		}
+		if len(pending) > 0 {
+			log.Printf("Warning: %d database migrations are pending; run \"server migrate up\"", len(pending))
+		}
+		return nil
+	}
+
+	applied, err := migrations.Up(ctx, db.Database)
+	if applied > 0 {
+		log.Printf("Applied %d database migrations", applied)
+	}
+	return err
+}
+
+// runMigrateCommand handles "server migrate up|down <version>|status" and
+// returns the process exit code
+func runMigrateCommand(args []string) int {
+	ctx, cancel := context.WithTimeout(context.Background(), MigrationTimeout)
+	defer cancel()
+
+	usage := func() int {
+		fmt.Fprintln(os.Stderr, "usage: server migrate up | down <version> | status")
+		return 2
+	}
+	if len(args) == 0 {
+		return usage()
+	}
+	if appConfig.Database.Driver != config.DatabaseDriverMongo {
+		fmt.Fprintf(os.Stderr, "migrations only apply to the %s database driver\n", config.DatabaseDriverMongo)
+		return 1
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrations.Up(ctx, db.Database)
+		fmt.Printf("Applied %d migrations\n", applied)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	case "down":
+		if len(args) != 2 {
+			return usage()
+		}
+		target, err := strconv.Atoi(args[1])
+		if err != nil || target < 0 {
+			return usage()
+		}
+		reverted, err := migrations.Down(ctx, db.Database, target)
+		fmt.Printf("Reverted %d migrations\n", reverted)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	case "status":
+		statuses, err := migrations.List(ctx, db.Database)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = "applied " + status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%4d  %-30s %s\n", status.Version, status.Name, applied)
+		}
+	default:
+		return usage()
+	}
+	return 0
+}
@@ -0,0 +1,213 @@
+// Package migrations applies versioned schema and data changes to the
+// database. Each migration has an up and a down step; applied versions are
+// recorded in the schema_migrations collection so each runs once.
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	collectionName = "schema_migrations"
+	lockID         = "lock"
+	// A lock older than this was left by a run that crashed
+	staleLockAfter = 10 * time.Minute
+)
+
+// ErrLocked is returned while another instance is running migrations
+var ErrLocked = errors.New("migrations are being run by another instance")
+
+// Migration is one versioned change. Down must undo Up, so a release can be
+// rolled back.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, database *mongo.Database) error
+	Down    func(ctx context.Context, database *mongo.Database) error
+}
+
+// Status is a migration and whether it has been applied
+type Status struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"appliedAt,omitempty"`
+}
+
+// record is a migration's entry in schema_migrations
+type record struct {
+	Version   int       `bson:"_id"`
+	Name      string    `bson:"name"`
+	AppliedAt time.Time `bson:"appliedAt"`
+}
+
+// all lists every migration in version order
+func all() []Migration {
+	list := []Migration{
+		scheduledForDates,
+		dropParticipantTTL,
+		tenantUserEmails,
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list
+}
+
+func applied(ctx context.Context, database *mongo.Database) (map[int]record, error) {
+	cursor, err := database.Collection(collectionName).Find(ctx, bson.M{"_id": bson.M{"$type": "number"}})
+	if err != nil {
+		return nil, err
+	}
+	var records []record
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]record, len(records))
+	for _, r := range records {
+		byVersion[r.Version] = r
+	}
+	return byVersion, nil
+}
+
+// lock claims the right to run migrations; the returned func releases it
+func lock(ctx context.Context, database *mongo.Database) (func(), error) {
+	collection := database.Collection(collectionName)
+	now := time.Now()
+	collection.DeleteOne(ctx, bson.M{"_id": lockID, "lockedAt": bson.M{"$lt": now.Add(-staleLockAfter)}})
+	if _, err := collection.InsertOne(ctx, bson.M{"_id": lockID, "lockedAt": now}); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrLocked
+		}
+		return nil, err
+	}
+	return func() {
+		collection.DeleteOne(context.Background(), bson.M{"_id": lockID})
+	}, nil
+}
+
+// List reports every migration and when it was applied
+func List(ctx context.Context, database *mongo.Database) ([]Status, error) {
+	done, err := applied(ctx, database)
+	if err != nil {
+		return nil, err
+	}
+	var statuses []Status
+	for _, m := range all() {
+		status := Status{Version: m.Version, Name: m.Name}
+		if r, ok := done[m.Version]; ok {
+			appliedAt := r.AppliedAt
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Pending returns the migrations not yet applied
+func Pending(ctx context.Context, database *mongo.Database) ([]Migration, error) {
+	done, err := applied(ctx, database)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, m := range all() {
+		if _, ok := done[m.Version]; !ok {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies every pending migration in version order, stopping at the
+// first failure, and returns how many were applied
+func Up(ctx context.Context, database *mongo.Database) (int, error) {
+	unlock, err := lock(ctx, database)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	pending, err := Pending(ctx, database)
+	if err != nil {
+		return 0, err
+	}
+	for i, m := range pending {
+		log.Printf("Applying migration %d (%s)", m.Version, m.Name)
+		if err := m.Up(ctx, database); err != nil {
+			return i, fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		r := record{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}
+		if _, err := database.Collection(collectionName).InsertOne(ctx, r); err != nil {
+			return i, fmt.Errorf("recording migration %d: %w", m.Version, err)
+		}
+	}
+	return len(pending), nil
+}
+
+// Down reverts applied migrations newer than target, newest first, and
+// returns how many were reverted
+func Down(ctx context.Context, database *mongo.Database, target int) (int, error) {
+	unlock, err := lock(ctx, database)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	done, err := applied(ctx, database)
+	if err != nil {
+		return 0, err
+	}
+	list := all()
+	reverted := 0
+	for i := len(list) - 1; i >= 0; i-- {
+		m := list[i]
+		if m.Version <= target {
+			break
+		}
+		if _, ok := done[m.Version]; !ok {
+			continue
+		}
+		log.Printf("Reverting migration %d (%s)", m.Version, m.Name)
+		if err := m.Down(ctx, database); err != nil {
+			return reverted, fmt.Errorf("reverting migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		if _, err := database.Collection(collectionName).DeleteOne(ctx, bson.M{"_id": m.Version}); err != nil {
+			return reverted, fmt.Errorf("unrecording migration %d: %w", m.Version, err)
+		}
+		reverted++
+	}
+	return reverted, nil
+}
+
+// updateEach applies update to every document matching filter, one at a
+// time; update returns nil to leave a document alone
+func updateEach(ctx context.Context, collection *mongo.Collection, filter bson.M, update func(bson.Raw) (bson.M, error)) (int, error) {
+	cursor, err := collection.Find(ctx, filter, options.Find().SetBatchSize(500))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	updated := 0
+	for cursor.Next(ctx) {
+		change, err := update(cursor.Current)
+		if err != nil {
+			return updated, err
+		}
+		if change == nil {
+			continue
+		}
+		if _, err := collection.UpdateByID(ctx, cursor.Current.Lookup("_id"), change); err != nil {
+			return updated, err
+		}
+		updated++
+	}
+	return updated, cursor.Err()
+}
@@ -0,0 +1,46 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// errCodeIndexNotFound is returned when dropping an index that doesn't exist
+const errCodeIndexNotFound = 27
+
+const participantLastActiveIndex = "lastActive_1"
+
+// dropParticipantTTL replaces the index that expired participants 24 hours
+// after their last heartbeat with a plain one for the cleanup job's stale
+// participant query. The retention purge now removes participants once
+// their meeting has ended.
+var dropParticipantTTL = Migration{
+	Version: 2,
+	Name:    "drop-participant-ttl",
+	Up: func(ctx context.Context, database *mongo.Database) error {
+		return replaceLastActiveIndex(ctx, database, options.Index().SetName(participantLastActiveIndex))
+	},
+	Down: func(ctx context.Context, database *mongo.Database) error {
+		return replaceLastActiveIndex(ctx, database,
+			options.Index().SetName(participantLastActiveIndex).SetExpireAfterSeconds(86400))
+	},
+}
+
+func replaceLastActiveIndex(ctx context.Context, database *mongo.Database, opts *options.IndexOptions) error {
+	indexes := database.Collection("participants").Indexes()
+	if _, err := indexes.DropOne(ctx, participantLastActiveIndex); err != nil {
+		var cmdErr mongo.CommandError
+		if !errors.As(err, &cmdErr) || cmdErr.Code != errCodeIndexNotFound {
+			return err
+		}
+	}
+	_, err := indexes.CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "lastActive", Value: 1}},
+		Options: opts,
+	})
+	return err
+}
@@ -0,0 +1,60 @@
+package migrations
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// scheduledForDates converts meetings whose scheduledFor is still stored as
+// a string into BSON dates. Values that cannot be parsed are removed and
+// logged.
+var scheduledForDates = Migration{
+	Version: 1,
+	Name:    "scheduled-for-dates",
+	Up: func(ctx context.Context, database *mongo.Database) error {
+		cleared := 0
+		migrated, err := updateEach(ctx, database.Collection("meetings"),
+			bson.M{"scheduledFor": bson.M{"$type": "string"}},
+			func(doc bson.Raw) (bson.M, error) {
+				value := doc.Lookup("scheduledFor").StringValue()
+				if scheduled, ok := parseLegacyTime(value); ok {
+					return bson.M{"$set": bson.M{"scheduledFor": scheduled}}, nil
+				}
+				if value != "" {
+					log.Printf("Clearing unparseable scheduledFor %q on meeting %v", value, doc.Lookup("_id"))
+				}
+				cleared++
+				return bson.M{"$unset": bson.M{"scheduledFor": ""}}, nil
+			})
+		if migrated > 0 {
+			log.Printf("Migrated scheduledFor on %d meetings, cleared %d", migrated-cleared, cleared)
+		}
+		return err
+	},
+	// Cleared values are gone; the rest go back to RFC 3339 strings
+	Down: func(ctx context.Context, database *mongo.Database) error {
+		_, err := updateEach(ctx, database.Collection("meetings"),
+			bson.M{"scheduledFor": bson.M{"$type": "date"}},
+			func(doc bson.Raw) (bson.M, error) {
+				scheduled := doc.Lookup("scheduledFor").Time().UTC()
+				return bson.M{"$set": bson.M{"scheduledFor": scheduled.Format(time.RFC3339)}}, nil
+			})
+		return err
+	},
+}
+
+// parseLegacyTime parses the formats the frontend has historically sent,
+// treating values without an offset as UTC
+func parseLegacyTime(value string) (time.Time, bool) {
+	layouts := []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04"}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
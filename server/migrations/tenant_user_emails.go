@@ -0,0 +1,47 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	userEmailIndex       = "email_1"
+	tenantUserEmailIndex = "tenantId_1_email_1"
+)
+
+// tenantUserEmails makes account emails unique per tenant rather than across
+// the server, so the same person can have an account with several tenants.
+// Rolling back fails while two tenants share an email.
+var tenantUserEmails = Migration{
+	Version: 3,
+	Name:    "tenant-user-emails",
+	Up: func(ctx context.Context, database *mongo.Database) error {
+		return replaceUniqueIndex(ctx, database.Collection("users"), userEmailIndex,
+			bson.D{{Key: "tenantId", Value: 1}, {Key: "email", Value: 1}}, tenantUserEmailIndex)
+	},
+	Down: func(ctx context.Context, database *mongo.Database) error {
+		return replaceUniqueIndex(ctx, database.Collection("users"), tenantUserEmailIndex,
+			bson.D{{Key: "email", Value: 1}}, userEmailIndex)
+	},
+}
+
+// replaceUniqueIndex drops an index, if it exists, and creates a unique one
+func replaceUniqueIndex(ctx context.Context, collection *mongo.Collection, drop string, keys bson.D, name string) error {
+	indexes := collection.Indexes()
+	if _, err := indexes.DropOne(ctx, drop); err != nil {
+		var cmdErr mongo.CommandError
+		if !errors.As(err, &cmdErr) || cmdErr.Code != errCodeIndexNotFound {
+			return err
+		}
+	}
+	_, err := indexes.CreateOne(ctx, mongo.IndexModel{
+		Keys:    keys,
+		Options: options.Index().SetUnique(true).SetName(name),
+	})
+	return err
+}
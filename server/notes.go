@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf16"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+)
+
+// Each meeting has a shared notes document that participants edit live.
+// Clients send operations against the version they last saw; the server
+// transforms them past the operations applied since, numbers them and
+// relays them. Numbered operations are stored, one document per version, so
+// instances agree on the order; the notes document caches their result.
+
+const (
+	MaxNotesLength = 100000 // UTF-16 code units
+	// NotesOpHistory is how many past operations are kept to transform late
+	// edits against; clients further behind must reload the notes
+	NotesOpHistory     = 500
+	notesCommitRetries = 5
+)
+
+// MeetingNotes is a meeting's shared notes document
+type MeetingNotes struct {
+	MeetingID string    `json:"meetingId" bson:"_id"`
+	Text      string    `json:"text" bson:"text"`
+	Version   int64     `json:"version" bson:"version"`
+	UpdatedBy string    `json:"updatedBy,omitempty" bson:"updatedBy,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+// notesOp is an operation applied to a meeting's notes
+type notesOp struct {
+	ID        string        `bson:"_id"` // meetingId:version
+	MeetingID string        `bson:"meetingId"`
+	Version   int64         `bson:"version"` // the version it produced
+	Ops       textOperation `bson:"-"`
+	RawOps    string        `bson:"ops"` // JSON of Ops
+	UserID    string        `bson:"userId"`
+	CreatedAt time.Time     `bson:"createdAt"`
+}
+
+var (
+	errNotesOutdated = errors.New("notes have changed too much since that version")
+	errNotesTooLong  = errors.New("notes are too long")
+)
+
+// loadNotes returns the meeting's notes, with any stored operations the
+// cached text is missing applied to it
+func loadNotes(ctx context.Context, meetingID string) (MeetingNotes, error) {
+	notes := MeetingNotes{MeetingID: meetingID}
+	err := db.MeetingNotes.FindOne(ctx, bson.M{"_id": meetingID}).Decode(&notes)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return notes, err
+	}
+
+	later, err := loadNotesOps(ctx, meetingID, notes.Version)
+	if err != nil {
+		return notes, err
+	}
+	doc := utf16.Encode([]rune(notes.Text))
+	for _, op := range later {
+		if op.Version != notes.Version+1 {
+			break
+		}
+		if doc, err = op.Ops.apply(doc); err != nil {
+			return notes, err
+		}
+		notes.Version = op.Version
+		notes.UpdatedBy = op.UserID
+		notes.UpdatedAt = op.CreatedAt
+	}
+	notes.Text = string(utf16.Decode(doc))
+	return notes, nil
+}
+
+// loadNotesOps returns the meeting's stored operations after a version, in order
+func loadNotesOps(ctx context.Context, meetingID string, after int64) ([]notesOp, error) {
+	cursor, err := db.NotesOps.Find(ctx,
+		bson.M{"meetingId": meetingID, "version": bson.M{"$gt": after}},
+		options.Find().SetSort(bson.D{{Key: "version", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var ops []notesOp
+	if err := cursor.All(ctx, &ops); err != nil {
+		return nil, err
+	}
+	for i := range ops {
+		if err := json.Unmarshal([]byte(ops[i].RawOps), &ops[i].Ops); err != nil {
+			return nil, err
+		}
+	}
+	return ops, nil
+}
+
+// commitNotesOp transforms an operation made against a version of the notes
+// past everything applied since and applies it, returning the operation as
+// applied and the notes it produced. Writers on any instance race for the
+// next version number; the loser transforms again and retries.
+func commitNotesOp(ctx context.Context, meetingID, userID string, base int64, op textOperation) (textOperation, MeetingNotes, error) {
+	for attempt := 0; ; attempt++ {
+		notes, err := loadNotes(ctx, meetingID)
+		if err != nil {
+			return nil, notes, err
+		}
+		if base > notes.Version || base < notes.Version-NotesOpHistory {
+			return nil, notes, errNotesOutdated
+		}
+
+		concurrent, err := loadNotesOps(ctx, meetingID, base)
+		if err != nil {
+			return nil, notes, err
+		}
+		if int64(len(concurrent)) < notes.Version-base || (len(concurrent) > 0 && concurrent[0].Version != base+1) {
+			return nil, notes, errNotesOutdated
+		}
+		transformed := op
+		for _, applied := range concurrent[:notes.Version-base] {
+			if transformed, err = transformOperation(transformed, applied.Ops); err != nil {
+				return nil, notes, err
+			}
+		}
+
+		doc, err := transformed.apply(utf16.Encode([]rune(notes.Text)))
+		if err != nil {
+			return nil, notes, err
+		}
+		if len(doc) > MaxNotesLength {
+			return nil, notes, errNotesTooLong
+		}
+
+		raw, err := json.Marshal(transformed)
+		if err != nil {
+			return nil, notes, err
+		}
+		now := time.Now()
+		version := notes.Version + 1
+		_, err = db.NotesOps.InsertOne(ctx, notesOp{
+			ID:        meetingID + ":" + strconv.FormatInt(version, 10),
+			MeetingID: meetingID,
+			Version:   version,
+			RawOps:    string(raw),
+			UserID:    userID,
+			CreatedAt: now,
+		})
+		if mongo.IsDuplicateKeyError(err) && attempt < notesCommitRetries {
+			continue
+		}
+		if err != nil {
+			return nil, notes, err
+		}
+
+		notes.Text = string(utf16.Decode(doc))
+		notes.Version = version
+		notes.UpdatedBy = userID
+		notes.UpdatedAt = now
+		// The cache only moves forward; a missed update is caught up on load
+		if _, err := db.MeetingNotes.UpdateOne(ctx,
+			bson.M{"_id": meetingID, "version": bson.M{"$lt": version}},
+			bson.M{"$set": bson.M{"text": notes.Text, "version": version, "updatedBy": userID, "updatedAt": now}},
+			options.Update().SetUpsert(true),
+		); err != nil && !mongo.IsDuplicateKeyError(err) {
+			log.Printf("Error caching notes of meeting %s: %v", meetingID, err)
+		}
+		if version%NotesOpHistory == 0 {
+			go trimNotesOps(context.Background(), meetingID, version-NotesOpHistory)
+		}
+		return transformed, notes, nil
+	}
+}
+
+// trimNotesOps deletes operations too old to transform against
+func trimNotesOps(ctx context.Context, meetingID string, upTo int64) {
+	if _, err := db.NotesOps.DeleteMany(ctx, bson.M{
+		"meetingId": meetingID,
+		"version":   bson.M{"$lte": upTo},
+	}); err != nil {
+		log.Printf("Error trimming notes history of meeting %s: %v", meetingID, err)
+	}
+}
+
+// handleNotesOp applies an edit to the meeting's notes. The sender gets a
+// "notes-ack" with the new version and everyone else the transformed
+// operation; a sender too far behind gets "notes-resync" with the notes.
+func handleNotesOp(ctx context.Context, c *Client, data json.RawMessage) {
+	var req struct {
+		Version int64         `json:"version"`
+		Ops     textOperation `json:"ops"`
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		c.sendError("Invalid notes operation")
+		return
+	}
+
+	applied, notes, err := commitNotesOp(ctx, c.meetingID, c.userID, req.Version, req.Ops)
+	switch {
+	case errors.Is(err, errNotesOutdated), errors.Is(err, errOperationMismatch):
+		c.hub.SendToClient(c, WebSocketMessage{Type: "notes-resync", Data: notes})
+		return
+	case errors.Is(err, errNotesTooLong):
+		c.sendError("Notes are limited to 100000 characters")
+		return
+	case err != nil:
+		log.Printf("Error applying notes operation in meeting %s: %v", c.meetingID, err)
+		c.sendError("Failed to update notes: " + err.Error())
+		return
+	}
+
+	c.hub.SendToClient(c, WebSocketMessage{
+		Type: "notes-ack",
+		Data: map[string]interface{}{"version": notes.Version},
+	})
+	hub.BroadcastToMeeting(c.meetingID, WebSocketMessage{
+		Type:   "notes-op",
+		Data:   map[string]interface{}{"version": notes.Version, "ops": applied, "userId": c.userID},
+		UserID: c.userID,
+	}, c)
+}
+
+// getNotesHandler returns the meeting's shared notes
+func getNotesHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	meeting, err := findMeeting(r.Context(), mux.Vars(r)["id"])
+	if err != nil || !canViewMeeting(meeting, userID) {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+
+	notes, err := loadNotes(r.Context(), meeting.ID)
+	if err != nil {
+		sendErrorResponse(w, "Failed to load notes", http.StatusInternalServerError)
+		return
+	}
+	sendSuccessResponse(w, notes)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"unicode/utf16"
+)
+
+// Operational transformation for the shared notes. An operation walks the
+// whole document as a list of components, in the JSON form of ot.js: a
+// positive number retains that many characters, a negative number deletes
+// them, and a string inserts it. Lengths count UTF-16 code units, as
+// browsers' strings do.
+
+var errOperationMismatch = errors.New("operation does not fit the document")
+
+// opComponent is one step of an operation; exactly one field is set
+type opComponent struct {
+	retain int
+	insert []uint16
+	delete int
+}
+
+// textOperation is a sequence of components covering a whole document
+type textOperation []opComponent
+
+func (op *textOperation) UnmarshalJSON(data []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	var parsed textOperation
+	for _, value := range raw {
+		var text string
+		if err := json.Unmarshal(value, &text); err == nil {
+			parsed.insertText(utf16.Encode([]rune(text)))
+			continue
+		}
+		var n int
+		if err := json.Unmarshal(value, &n); err != nil || n == 0 {
+			return errors.New("operation components must be strings or non-zero integers")
+		}
+		if n > 0 {
+			parsed.retainN(n)
+		} else {
+			parsed.deleteN(-n)
+		}
+	}
+	*op = parsed
+	return nil
+}
+
+func (op textOperation) MarshalJSON() ([]byte, error) {
+	out := make([]interface{}, 0, len(op))
+	for _, c := range op {
+		switch {
+		case c.retain > 0:
+			out = append(out, c.retain)
+		case c.delete > 0:
+			out = append(out, -c.delete)
+		default:
+			out = append(out, string(utf16.Decode(c.insert)))
+		}
+	}
+	return json.Marshal(out)
+}
+
+func (op *textOperation) retainN(n int) {
+	if n <= 0 {
+		return
+	}
+	if last := len(*op) - 1; last >= 0 && (*op)[last].retain > 0 {
+		(*op)[last].retain += n
+		return
+	}
+	*op = append(*op, opComponent{retain: n})
+}
+
+func (op *textOperation) deleteN(n int) {
+	if n <= 0 {
+		return
+	}
+	if last := len(*op) - 1; last >= 0 && (*op)[last].delete > 0 {
+		(*op)[last].delete += n
+		return
+	}
+	*op = append(*op, opComponent{delete: n})
+}
+
+// insertText appends an insert, keeping inserts ahead of an adjacent delete
+// so equal operations always have the same components
+func (op *textOperation) insertText(text []uint16) {
+	if len(text) == 0 {
+		return
+	}
+	ops := *op
+	last := len(ops) - 1
+	switch {
+	case last >= 0 && ops[last].insert != nil:
+		ops[last].insert = append(ops[last].insert, text...)
+	case last >= 0 && ops[last].delete > 0:
+		if last > 0 && ops[last-1].insert != nil {
+			ops[last-1].insert = append(ops[last-1].insert, text...)
+		} else {
+			deleted := ops[last]
+			ops[last] = opComponent{insert: append([]uint16(nil), text...)}
+			ops = append(ops, deleted)
+		}
+	default:
+		ops = append(ops, opComponent{insert: append([]uint16(nil), text...)})
+	}
+	*op = ops
+}
+
+// baseLength is the length of the documents the operation applies to
+func (op textOperation) baseLength() int {
+	n := 0
+	for _, c := range op {
+		n += c.retain + c.delete
+	}
+	return n
+}
+
+// apply returns the document with the operation applied
+func (op textOperation) apply(doc []uint16) ([]uint16, error) {
+	if op.baseLength() != len(doc) {
+		return nil, errOperationMismatch
+	}
+	out := make([]uint16, 0, len(doc))
+	pos := 0
+	for _, c := range op {
+		switch {
+		case c.retain > 0:
+			out = append(out, doc[pos:pos+c.retain]...)
+			pos += c.retain
+		case c.delete > 0:
+			pos += c.delete
+		default:
+			out = append(out, c.insert...)
+		}
+	}
+	return out, nil
+}
+
+// transformOperation rewrites a so that it applies after b, where both were
+// made against the same document. When both insert at the same place, a's
+// text goes first.
+func transformOperation(a, b textOperation) (textOperation, error) {
+	if a.baseLength() != b.baseLength() {
+		return nil, errOperationMismatch
+	}
+
+	var out textOperation
+	i, j := 0, 0
+	var ca, cb opComponent
+	next := func(op textOperation, k *int, c *opComponent) {
+		if *k < len(op) {
+			*c = op[*k]
+			*k++
+		} else {
+			*c = opComponent{}
+		}
+	}
+	empty := func(c opComponent) bool { return c.retain == 0 && c.delete == 0 && c.insert == nil }
+	next(a, &i, &ca)
+	next(b, &j, &cb)
+
+	for !empty(ca) || !empty(cb) {
+		if ca.insert != nil {
+			out.insertText(ca.insert)
+			next(a, &i, &ca)
+			continue
+		}
+		if cb.insert != nil {
+			out.retainN(len(cb.insert))
+			next(b, &j, &cb)
+			continue
+		}
+		if empty(ca) || empty(cb) {
+			return nil, errOperationMismatch
+		}
+
+		// Both retain or delete; consume the shorter of the two
+		n := min(ca.retain+ca.delete, cb.retain+cb.delete)
+		switch {
+		case ca.retain > 0 && cb.retain > 0:
+			out.retainN(n)
+		case ca.delete > 0 && cb.retain > 0:
+			out.deleteN(n)
+		}
+		// Characters b deleted are gone, whatever a did with them
+		for _, side := range []*opComponent{&ca, &cb} {
+			if side.retain > 0 {
+				side.retain -= n
+			} else {
+				side.delete -= n
+			}
+		}
+		if ca.retain == 0 && ca.delete == 0 {
+			next(a, &i, &ca)
+		}
+		if cb.retain == 0 && cb.delete == 0 {
+			next(b, &j, &cb)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"video-meeting-app/graphql"
+)
+
+// APIVersion is reported by the health check and the OpenAPI document
+const APIVersion = "1.0.0"
+
+// apiOperation documents one route for the OpenAPI document. Request and
+// Response are zero values of the body types; their schemas are derived from
+// the types' JSON tags. Response is what the success envelope's data holds.
+type apiOperation struct {
+	Summary  string
+	Request  interface{}
+	Response interface{}
+}
+
+// apiOperations documents routes by "METHOD /path template". Routes missing
+// here still appear in the document, with a generic response.
+var apiOperations = map[string]apiOperation{
+	"POST /api/auth/register": {Summary: "Create an account and sign in", Request: registerRequest{}},
+	"POST /api/auth/login":    {Summary: "Sign in", Request: loginRequest{}},
+	"POST /api/auth/logout":   {Summary: "Sign out"},
+	"GET /api/auth/captcha":   {Summary: "Get the CAPTCHA widget to show when registration or login answers CAPTCHA_REQUIRED", Response: captchaSettingsResponse{}},
+
+	"GET /api/tenant": {Summary: "Get the tenant served on this host and its branding", Response: tenantResponse{}},
+
+	"GET /api/users/me/reminder-preferences": {Summary: "Get meeting reminder preferences", Response: ReminderPreferences{}},
+	"PUT /api/users/me/reminder-preferences": {Summary: "Update meeting reminder preferences", Request: ReminderPreferences{}, Response: ReminderPreferences{}},
+	"GET /api/users/me/quota":                {Summary: "Get plan usage and limits", Response: QuotaState{}},
+	"GET /api/users/me/devices":              {Summary: "List registered push devices", Response: []DeviceToken{}},
+	"GET /api/users/me/export":               {Summary: "Export all of the caller's data as JSON, or as a ZIP with format=zip", Response: UserDataExport{}},
+	"GET /api/users/me/recent-meetings":      {Summary: "List the meetings the caller joined, most recent first, with rejoin tokens for running ones", Response: []RecentMeeting{}},
+	"DELETE /api/users/me":                   {Summary: "Erase the caller's account"},
+
+	"POST /api/meetings":                                     {Summary: "Create a meeting", Request: createMeetingRequest{}, Response: Meeting{}},
+	"POST /api/meetings/{id}/clone":                          {Summary: "Create a copy of a meeting the caller hosts", Request: cloneMeetingRequest{}, Response: Meeting{}},
+	"GET /api/meetings":                                      {Summary: "List the caller's meetings"},
+	"GET /api/meetings/code/{code}":                          {Summary: "Find a meeting by its code", Response: Meeting{}},
+	"GET /api/meetings/archived":                             {Summary: "List archived meetings the caller hosted, was invited to or joined, newest first; paged with limit and cursor"},
+	"GET /api/meetings/{id}":                                 {Summary: "Get a meeting by ID or code", Response: Meeting{}},
+	"POST /api/meetings/{id}/join":                           {Summary: "Join a meeting; a full meeting answers CAPACITY_REACHED, or 202 with a place in the overflow queue when queue is set", Request: joinMeetingRequest{}, Response: Participant{}},
+	"GET /api/meetings/{id}/queue":                           {Summary: "Get the caller's place in the meeting's overflow queue; checking keeps it", Response: OverflowEntry{}},
+	"DELETE /api/meetings/{id}/queue":                        {Summary: "Leave the meeting's overflow queue"},
+	"GET /api/meetings/{id}/ip-rules":                        {Summary: "Get the meeting's IP allow and deny rules (organization owners and admins)", Response: MeetingIPRules{}},
+	"PUT /api/meetings/{id}/ip-rules":                        {Summary: "Replace the meeting's IP allow and deny rules; empty lists remove them", Request: meetingIPRulesRequest{}, Response: MeetingIPRules{}},
+	"POST /api/meetings/{id}/embed-token":                    {Summary: "Mint a short-lived token that lets one guest join the meeting from a partner site's iframe", Request: createEmbedTokenRequest{}, Response: embedTokenResponse{}},
+	"POST /api/meetings/{id}/end":                            {Summary: "End a meeting for everyone"},
+	"GET /api/meetings/{id}/participants":                    {Summary: "List a meeting's participants", Response: []Participant{}},
+	"PUT /api/meetings/{id}/participants":                    {Summary: "Update the caller's media state", Request: updateParticipantRequest{}},
+	"PATCH /api/meetings/{id}/participants":                  {Summary: "Update the caller's media state", Request: updateParticipantRequest{}},
+	"DELETE /api/meetings/{id}/participants/me":              {Summary: "Leave a meeting"},
+	"PUT /api/meetings/{id}/participants/{userId}/bandwidth": {Summary: "Cap a participant's video bitrate", Request: bandwidthCapRequest{}},
+	"POST /api/meetings/{id}/heartbeat":                      {Summary: "Refresh the caller's heartbeat without a WebSocket"},
+	"GET /api/meetings/{id}/notes":                           {Summary: "Get the meeting's shared notes", Response: MeetingNotes{}},
+	"GET /api/meetings/{id}/summary":                         {Summary: "Get the meeting summary (host only); ?format=md for Markdown", Response: MeetingSummary{}},
+	"GET /api/meetings/{id}/whiteboard":                      {Summary: "Get the meeting's whiteboard", Response: WhiteboardSnapshot{}},
+	"GET /api/meetings/{id}/state":                           {Summary: "Get a live snapshot of the meeting for the host, with ETag support", Response: MeetingLiveState{}},
+	"GET /api/meetings/{id}/timeline":                        {Summary: "Get the meeting's timeline; ?recordingId= gives offsets into a recording", Response: []MeetingEvent{}},
+	"GET /api/meetings/{id}/quality":                         {Summary: "List participants' connection quality, worst first", Response: []ConnectionQuality{}},
+	"POST /api/meetings/{id}/recording/start":                {Summary: "Start recording the meeting with a layout", Request: recordingLayoutRequest{}, Response: RecordingSession{}},
+	"PUT /api/meetings/{id}/recording/layout":                {Summary: "Switch the active recording's layout", Request: recordingLayoutRequest{}, Response: RecordingSession{}},
+	"POST /api/meetings/{id}/recording/stop":                 {Summary: "Stop the active recording; returns the token its file is uploaded with", Response: StoppedRecording{}},
+	"GET /api/meetings/{id}/recordings":                      {Summary: "List a meeting's recordings", Response: []Recording{}},
+	"POST /api/meetings/{id}/recordings":                     {Summary: "Upload a stopped recording's .webm or .mp4 file as multipart file, with its uploadToken", Response: Recording{}},
+	"GET /api/recordings/{id}":                               {Summary: "Get a recording", Response: Recording{}},
+	"GET /api/recordings/{id}/captions.vtt":                  {Summary: "Get WebVTT subtitles for a recording from the meeting's transcript"},
+	"GET /api/meetings/{id}/chat":                            {Summary: "Get chat history"},
+	"GET /api/meetings/{id}/invitations":                     {Summary: "List a meeting's invitations", Response: []Invitation{}},
+	"GET /api/meetings/{id}/polls":                           {Summary: "List a meeting's polls, with who voted for what to the host of polls that aren't anonymous", Response: []PollView{}},
+
+	"POST /api/service-accounts":                          {Summary: "Create a service account for bots and integrations", Response: User{}},
+	"GET /api/service-accounts":                           {Summary: "List the caller's service accounts", Response: []User{}},
+	"POST /api/service-accounts/{id}/keys":                {Summary: "Create a scoped API key; the key is only shown in this response", Request: createAPIKeyRequest{}, Response: APIKey{}},
+	"GET /api/service-accounts/{id}/keys":                 {Summary: "List a service account's API keys", Response: []APIKey{}},
+	"POST /api/service-accounts/{id}/keys/{keyId}/rotate": {Summary: "Replace an API key's secret; the previous one works for another day", Response: APIKey{}},
+
+	"GET /api/webhooks":                         {Summary: "List the caller's webhooks", Response: []Webhook{}},
+	"GET /api/contacts":                         {Summary: "List the caller's contacts", Response: []Contact{}},
+	"POST /api/organizations":                   {Summary: "Create an organization", Response: Organization{}},
+	"GET /api/organizations/{id}/members":       {Summary: "List an organization's members", Response: []OrgMember{}},
+	"GET /api/organizations/{id}/cors-origins":  {Summary: "List the origins an organization has allowed", Response: []CORSOrigin{}},
+	"POST /api/organizations/{id}/cors-origins": {Summary: "Allow an https origin, without credentials, for the organization's integrations", Request: corsOriginRequest{}, Response: CORSOrigin{}},
+	"GET /api/admin/cors-origins":               {Summary: "List the origins allowed for the whole service", Response: adminCORSOriginsResponse{}},
+	"POST /api/admin/cors-origins":              {Summary: "Allow an origin for the whole service", Request: corsOriginRequest{}, Response: CORSOrigin{}},
+
+	"POST /api/graphql": {Summary: "Run a GraphQL query over meetings, participants, chat and analytics", Request: graphql.Request{}},
+	"GET /api/health":   {Summary: "Service health"},
+}
+
+// openAPISpecBuilder derives an OpenAPI document from the router's routes
+// and the types documented in apiOperations
+type openAPISpecBuilder struct {
+	schemas map[string]interface{}
+}
+
+var pathParamPattern = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// buildOpenAPISpec describes every /api route registered on router
+func buildOpenAPISpec(router *mux.Router) (map[string]interface{}, error) {
+	b := &openAPISpecBuilder{schemas: map[string]interface{}{
+		"Error": map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"success": map[string]string{"type": "boolean"},
+				"error":   map[string]string{"type": "string"},
+				"code":    errorCodeSchema(),
+			},
+			"required": []string{"success", "error", "code"},
+		},
+	}}
+	paths := make(map[string]map[string]interface{})
+
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil || !strings.HasPrefix(template, "/api/") {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+
+		// Strip regexp constraints from parameters, which OpenAPI doesn't allow
+		path := pathParamPattern.ReplaceAllString(template, "{$1}")
+		for _, method := range methods {
+			if method == http.MethodOptions || method == http.MethodHead {
+				continue
+			}
+			if paths[path] == nil {
+				paths[path] = make(map[string]interface{})
+			}
+			paths[path][strings.ToLower(method)] = b.operation(method, template, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Video Meeting API",
+			"version": APIVersion,
+		},
+		"servers": []map[string]string{{"url": "/"}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": b.schemas,
+			"securitySchemes": map[string]interface{}{
+				"session": map[string]string{"type": "apiKey", "in": "cookie", "name": CookieName},
+			},
+		},
+		"security": []map[string][]string{{"session": {}}},
+	}, nil
+}
+
+func (b *openAPISpecBuilder) operation(method, template, path string) map[string]interface{} {
+	doc := apiOperations[method+" "+template]
+
+	// Tag by the first path segment after /api, e.g. "meetings"
+	tag := strings.SplitN(strings.TrimPrefix(path, "/api/"), "/", 2)[0]
+	op := map[string]interface{}{
+		"tags":        []string{tag},
+		"operationId": operationID(method, path),
+	}
+	if doc.Summary != "" {
+		op["summary"] = doc.Summary
+	}
+
+	var params []map[string]interface{}
+	for _, match := range pathParamPattern.FindAllStringSubmatch(path, -1) {
+		params = append(params, map[string]interface{}{
+			"name":     match[1],
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]string{"type": "string"},
+		})
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+
+	if doc.Request != nil {
+		op["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": b.schemaFor(reflect.TypeOf(doc.Request))},
+			},
+		}
+	}
+
+	envelope := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"success": map[string]string{"type": "boolean"},
+			"data":    map[string]interface{}{},
+			"message": map[string]string{"type": "string"},
+		},
+		"required": []string{"success"},
+	}
+	if doc.Response != nil {
+		envelope["properties"].(map[string]interface{})["data"] = b.schemaFor(reflect.TypeOf(doc.Response))
+	}
+	op["responses"] = map[string]interface{}{
+		"200": map[string]interface{}{
+			"description": "Success",
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": envelope},
+			},
+		},
+		"default": map[string]interface{}{
+			"description": "Error",
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": map[string]string{"$ref": "#/components/schemas/Error"}},
+			},
+		},
+	}
+	return op
+}
+
+// operationID names an operation after its method and path, e.g.
+// "get_meetings_id_participants"
+func operationID(method, path string) string {
+	parts := []string{strings.ToLower(method)}
+	for _, segment := range strings.Split(strings.TrimPrefix(path, "/api/"), "/") {
+		segment = strings.Trim(segment, "{}")
+		segment = strings.NewReplacer("-", "_", ".", "_").Replace(segment)
+		if segment != "" {
+			parts = append(parts, segment)
+		}
+	}
+	return strings.Join(parts, "_")
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaFor returns the JSON schema of t. Named structs are added to the
+// components and referenced, which also ends recursion.
+func (b *openAPISpecBuilder) schemaFor(t reflect.Type) map[string]interface{} {
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Ptr:
+		schema := b.schemaFor(t.Elem())
+		if _, isRef := schema["$ref"]; !isRef {
+			schema["nullable"] = true
+		}
+		return schema
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": b.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schemaFor(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		name := t.Name()
+		if _, seen := b.schemas[name]; !seen {
+			b.schemas[name] = nil // placeholder for self-references
+			b.schemas[name] = b.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	default:
+		// interface{} and anything else may hold any value
+		return map[string]interface{}{}
+	}
+}
+
+func (b *openAPISpecBuilder) structSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+	b.addFields(t, properties, &required)
+	sort.Strings(required)
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// addFields adds the JSON-visible fields of t, including those promoted from
+// embedded structs
+func (b *openAPISpecBuilder) addFields(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			b.addFields(field.Type, properties, required)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = b.schemaFor(field.Type)
+		if !strings.Contains(opts, "omitempty") && field.Type.Kind() != reflect.Ptr {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// openAPIHandler serves the document built from router. It is built on the
+// first request, once every route has been registered.
+func openAPIHandler(router *mux.Router) http.HandlerFunc {
+	var (
+		once sync.Once
+		spec []byte
+		err  error
+	)
+	return func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() {
+			var doc map[string]interface{}
+			if doc, err = buildOpenAPISpec(router); err == nil {
+				spec, err = json.Marshal(doc)
+			}
+		})
+		if err != nil {
+			log.Printf("Error building OpenAPI document: %v", err)
+			sendErrorResponse(w, "Failed to build API document", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(spec)
+	}
+}
+
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Video Meeting API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js" crossorigin></script>
+  <script>
+    window.onload = () => {
+      window.ui = SwaggerUIBundle({ url: "/api/openapi.json", dom_id: "#swagger-ui", withCredentials: true });
+    };
+  </script>
+</body>
+</html>
+`
+
+// swaggerUIHandler serves an API browser for the OpenAPI document
+func swaggerUIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(swaggerUIPage))
+}
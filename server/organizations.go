@@ -0,0 +1,619 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/config"
+	"video-meeting-app/db"
+)
+
+const (
+	OrgInviteTTL          = 7 * 24 * time.Hour
+	MaxOrgInvitesPerCall  = 50
+	MaxOrgNameLength      = 100
+	MaxOrgParticipantsCap = 1000
+	MaxOrgRetentionDays   = 3650
+)
+
+// Organization member roles
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+// OrgSettings override the server's meeting defaults for meetings created in
+// the organization; zero values fall back to the server configuration
+type OrgSettings struct {
+	DefaultParticipants int    `json:"defaultParticipants,omitempty" bson:"defaultParticipants,omitempty"`
+	MaxParticipants     int    `json:"maxParticipants,omitempty" bson:"maxParticipants,omitempty"`
+	RecordingPolicy     string `json:"recordingPolicy,omitempty" bson:"recordingPolicy,omitempty"`
+	// Days before chat and recordings are purged, overriding the server's
+	// retention settings
+	ChatRetentionDays      int `json:"chatRetentionDays,omitempty" bson:"chatRetentionDays,omitempty"`
+	RecordingRetentionDays int `json:"recordingRetentionDays,omitempty" bson:"recordingRetentionDays,omitempty"`
+	// Cap on every participant's video bitrate in the organization's meetings
+	MaxVideoBitrateKbps int `json:"maxVideoBitrateKbps,omitempty" bson:"maxVideoBitrateKbps,omitempty"`
+	// Overlay burned into the organization's meeting recordings
+	RecordingBranding *RecordingBrandingSettings `json:"recordingBranding,omitempty" bson:"recordingBranding,omitempty"`
+}
+
+type Organization struct {
+	ID        string      `json:"id" bson:"_id"`
+	Name      string      `json:"name" bson:"name"`
+	CreatedBy string      `json:"createdBy" bson:"createdBy"`
+	Settings  OrgSettings `json:"settings" bson:"settings"`
+	LogoURL   string      `json:"logoUrl,omitempty" bson:"logoUrl,omitempty"`
+	LogoKey   string      `json:"-" bson:"logoKey,omitempty"`
+	CreatedAt time.Time   `json:"createdAt" bson:"createdAt"`
+	UpdatedAt time.Time   `json:"updatedAt" bson:"updatedAt"`
+}
+
+type OrgMember struct {
+	ID       string    `json:"id" bson:"_id"`
+	OrgID    string    `json:"orgId" bson:"orgId"`
+	UserID   string    `json:"userId" bson:"userId"`
+	Role     string    `json:"role" bson:"role"`
+	JoinedAt time.Time `json:"joinedAt" bson:"joinedAt"`
+	// Filled in when listing members
+	Name  string `json:"name,omitempty" bson:"-"`
+	Email string `json:"email,omitempty" bson:"-"`
+}
+
+// OrgInvite lets the holder of an email address join an organization
+type OrgInvite struct {
+	ID         string     `json:"id" bson:"_id"`
+	OrgID      string     `json:"orgId" bson:"orgId"`
+	Email      string     `json:"email" bson:"email"`
+	Role       string     `json:"role" bson:"role"`
+	InvitedBy  string     `json:"invitedBy" bson:"invitedBy"`
+	ExpiresAt  time.Time  `json:"expiresAt" bson:"expiresAt"`
+	AcceptedAt *time.Time `json:"acceptedAt,omitempty" bson:"acceptedAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt" bson:"createdAt"`
+}
+
+// MeetingDefaults are the participant limits and recording policy that apply
+// to a new meeting
+type MeetingDefaults struct {
+	DefaultParticipants int
+	MaxParticipants     int
+	RecordingPolicy     string
+}
+
+// meetingDefaults returns the server defaults overridden by the org settings
+func meetingDefaults(org *Organization) MeetingDefaults {
+	defaults := MeetingDefaults{
+		DefaultParticipants: appConfig.Meetings.DefaultParticipants,
+		MaxParticipants:     appConfig.Meetings.MaxParticipants,
+		RecordingPolicy:     appConfig.Meetings.RecordingPolicy,
+	}
+	if org == nil {
+		return defaults
+	}
+	if org.Settings.MaxParticipants > 0 {
+		defaults.MaxParticipants = org.Settings.MaxParticipants
+	}
+	if org.Settings.DefaultParticipants > 0 {
+		defaults.DefaultParticipants = org.Settings.DefaultParticipants
+	}
+	defaults.DefaultParticipants = min(defaults.DefaultParticipants, defaults.MaxParticipants)
+	if org.Settings.RecordingPolicy != "" {
+		defaults.RecordingPolicy = org.Settings.RecordingPolicy
+	}
+	return defaults
+}
+
+func isValidOrgRole(role string) bool {
+	return role == OrgRoleOwner || role == OrgRoleAdmin || role == OrgRoleMember
+}
+
+func isValidRecordingPolicy(policy string) bool {
+	switch policy {
+	case config.RecordingPolicyDisabled, config.RecordingPolicyHostOnly, config.RecordingPolicyAnyone:
+		return true
+	}
+	return false
+}
+
+// validateOrgSettings checks settings supplied by a client
+func validateOrgSettings(settings OrgSettings) error {
+	if settings.MaxParticipants < 0 || settings.MaxParticipants > MaxOrgParticipantsCap {
+		return fmt.Errorf("maxParticipants must be between 1 and %d", MaxOrgParticipantsCap)
+	}
+	if settings.DefaultParticipants < 0 {
+		return fmt.Errorf("defaultParticipants must be positive")
+	}
+	if settings.MaxParticipants > 0 && settings.DefaultParticipants > settings.MaxParticipants {
+		return fmt.Errorf("defaultParticipants must not exceed maxParticipants")
+	}
+	if settings.RecordingPolicy != "" && !isValidRecordingPolicy(settings.RecordingPolicy) {
+		return fmt.Errorf("recordingPolicy must be disabled, host-only or anyone")
+	}
+	if settings.ChatRetentionDays < 0 || settings.ChatRetentionDays > MaxOrgRetentionDays {
+		return fmt.Errorf("chatRetentionDays must be between 1 and %d", MaxOrgRetentionDays)
+	}
+	if settings.RecordingRetentionDays < 0 || settings.RecordingRetentionDays > MaxOrgRetentionDays {
+		return fmt.Errorf("recordingRetentionDays must be between 1 and %d", MaxOrgRetentionDays)
+	}
+	if !validVideoBitrateCap(settings.MaxVideoBitrateKbps) {
+		return fmt.Errorf("maxVideoBitrateKbps must be between %d and %d", MinVideoBitrateKbps, MaxVideoBitrateKbps)
+	}
+	return validateRecordingBranding(settings.RecordingBranding)
+}
+
+// findOrgMembership returns the user's membership, or mongo.ErrNoDocuments
+func findOrgMembership(ctx context.Context, orgID, userID string) (OrgMember, error) {
+	var member OrgMember
+	err := db.OrgMembers.FindOne(ctx, bson.M{"orgId": orgID, "userId": userID}).Decode(&member)
+	return member, err
+}
+
+// requireOrgRole loads the organization and the caller's membership, writing
+// an error response and returning false unless the caller holds one of roles
+// (any role when none are given)
+func requireOrgRole(w http.ResponseWriter, r *http.Request, orgID, userID string, roles ...string) (Organization, OrgMember, bool) {
+	var org Organization
+	if err := db.Organizations.FindOne(r.Context(), bson.M{"_id": orgID}).Decode(&org); err != nil {
+		sendErrorResponse(w, "Organization not found", http.StatusNotFound)
+		return org, OrgMember{}, false
+	}
+
+	member, err := findOrgMembership(r.Context(), orgID, userID)
+	if err != nil {
+		// Non-members cannot tell whether the organization exists
+		sendErrorResponse(w, "Organization not found", http.StatusNotFound)
+		return org, member, false
+	}
+	if len(roles) == 0 {
+		return org, member, true
+	}
+	for _, role := range roles {
+		if member.Role == role {
+			return org, member, true
+		}
+	}
+	sendErrorResponse(w, "You do not have permission to manage this organization", http.StatusForbidden)
+	return org, member, false
+}
+
+func createOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Name     string      `json:"name"`
+		Settings OrgSettings `json:"settings"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > MaxOrgNameLength {
+		sendErrorResponse(w, "Organization name must be between 1 and 100 characters", http.StatusBadRequest)
+		return
+	}
+	if err := validateOrgSettings(req.Settings); err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	org := Organization{
+		ID:        uuid.New().String(),
+		Name:      req.Name,
+		CreatedBy: userID,
+		Settings:  req.Settings,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if _, err := db.Organizations.InsertOne(r.Context(), org); err != nil {
+		log.Printf("Error creating organization: %v", err)
+		sendErrorResponse(w, "Failed to create organization", http.StatusInternalServerError)
+		return
+	}
+
+	owner := OrgMember{ID: uuid.New().String(), OrgID: org.ID, UserID: userID, Role: OrgRoleOwner, JoinedAt: now}
+	if _, err := db.OrgMembers.InsertOne(r.Context(), owner); err != nil {
+		log.Printf("Error adding organization owner: %v", err)
+		db.Organizations.DeleteOne(r.Context(), bson.M{"_id": org.ID})
+		sendErrorResponse(w, "Failed to create organization", http.StatusInternalServerError)
+		return
+	}
+
+	sendSuccessResponse(w, org)
+}
+
+// getOrganizationsHandler lists the organizations the user belongs to
+func getOrganizationsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	cursor, err := db.OrgMembers.Find(r.Context(), bson.M{"userId": userID})
+	if err != nil {
+		sendErrorResponse(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	var memberships []OrgMember
+	if err := cursor.All(r.Context(), &memberships); err != nil {
+		sendErrorResponse(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	roles := make(map[string]string, len(memberships))
+	orgIDs := make([]string, 0, len(memberships))
+	for _, membership := range memberships {
+		roles[membership.OrgID] = membership.Role
+		orgIDs = append(orgIDs, membership.OrgID)
+	}
+
+	type orgWithRole struct {
+		Organization
+		Role string `json:"role"`
+	}
+	result := []orgWithRole{}
+	if len(orgIDs) > 0 {
+		cursor, err := db.Organizations.Find(r.Context(), bson.M{"_id": bson.M{"$in": orgIDs}},
+			options.Find().SetSort(bson.M{"name": 1}))
+		if err != nil {
+			sendErrorResponse(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		var orgs []Organization
+		if err := cursor.All(r.Context(), &orgs); err != nil {
+			sendErrorResponse(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		for _, org := range orgs {
+			result = append(result, orgWithRole{Organization: org, Role: roles[org.ID]})
+		}
+	}
+
+	sendSuccessResponse(w, result)
+}
+
+func getOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	org, member, ok := requireOrgRole(w, r, mux.Vars(r)["id"], userID)
+	if !ok {
+		return
+	}
+
+	defaults := meetingDefaults(&org)
+	sendSuccessResponse(w, map[string]interface{}{
+		"organization": org,
+		"role":         member.Role,
+		"meetingDefaults": map[string]interface{}{
+			"defaultParticipants": defaults.DefaultParticipants,
+			"maxParticipants":     defaults.MaxParticipants,
+			"recordingPolicy":     defaults.RecordingPolicy,
+		},
+	})
+}
+
+func updateOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	org, _, ok := requireOrgRole(w, r, mux.Vars(r)["id"], userID, OrgRoleOwner, OrgRoleAdmin)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Name     *string      `json:"name,omitempty"`
+		Settings *OrgSettings `json:"settings,omitempty"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+
+	update := bson.M{"updatedAt": time.Now()}
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" || len(name) > MaxOrgNameLength {
+			sendErrorResponse(w, "Organization name must be between 1 and 100 characters", http.StatusBadRequest)
+			return
+		}
+		update["name"] = name
+		org.Name = name
+	}
+	if req.Settings != nil {
+		if err := validateOrgSettings(*req.Settings); err != nil {
+			sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		update["settings"] = *req.Settings
+		org.Settings = *req.Settings
+	}
+
+	if _, err := db.Organizations.UpdateOne(r.Context(), bson.M{"_id": org.ID}, bson.M{"$set": update}); err != nil {
+		log.Printf("Error updating organization %s: %v", org.ID, err)
+		sendErrorResponse(w, "Failed to update organization", http.StatusInternalServerError)
+		return
+	}
+
+	sendSuccessResponse(w, org)
+}
+
+func getOrgMembersHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	org, _, ok := requireOrgRole(w, r, mux.Vars(r)["id"], userID)
+	if !ok {
+		return
+	}
+
+	cursor, err := db.OrgMembers.Find(r.Context(), bson.M{"orgId": org.ID}, options.Find().SetSort(bson.M{"joinedAt": 1}))
+	if err != nil {
+		sendErrorResponse(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	members := []OrgMember{}
+	if err := cursor.All(r.Context(), &members); err != nil {
+		sendErrorResponse(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	userIDs := make([]string, 0, len(members))
+	for _, member := range members {
+		userIDs = append(userIDs, member.UserID)
+	}
+	cursor, err = db.Users.Find(r.Context(), bson.M{"_id": bson.M{"$in": userIDs}},
+		options.Find().SetProjection(bson.M{"name": 1, "email": 1}))
+	if err == nil {
+		var users []User
+		if cursor.All(r.Context(), &users) == nil {
+			byID := make(map[string]User, len(users))
+			for _, user := range users {
+				byID[user.ID] = user
+			}
+			for i := range members {
+				members[i].Name = byID[members[i].UserID].Name
+				members[i].Email = byID[members[i].UserID].Email
+			}
+		}
+	}
+
+	sendSuccessResponse(w, members)
+}
+
+// removeOrgMemberHandler removes a member; admins may remove others and
+// anyone may leave. The last owner cannot be removed.
+func removeOrgMemberHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	org, caller, ok := requireOrgRole(w, r, vars["id"], userID)
+	if !ok {
+		return
+	}
+	targetID := vars["userId"]
+	if targetID != userID && caller.Role != OrgRoleOwner && caller.Role != OrgRoleAdmin {
+		sendErrorResponse(w, "You do not have permission to manage this organization", http.StatusForbidden)
+		return
+	}
+
+	target, err := findOrgMembership(r.Context(), org.ID, targetID)
+	if err != nil {
+		sendErrorResponse(w, "Member not found", http.StatusNotFound)
+		return
+	}
+	if target.Role == OrgRoleOwner {
+		if caller.Role != OrgRoleOwner {
+			sendErrorResponse(w, "Only owners can remove an owner", http.StatusForbidden)
+			return
+		}
+		owners, err := db.OrgMembers.CountDocuments(r.Context(), bson.M{"orgId": org.ID, "role": OrgRoleOwner})
+		if err != nil || owners <= 1 {
+			sendErrorResponse(w, "An organization needs at least one owner", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if _, err := db.OrgMembers.DeleteOne(r.Context(), bson.M{"_id": target.ID}); err != nil {
+		sendErrorResponse(w, "Failed to remove member", http.StatusInternalServerError)
+		return
+	}
+
+	sendSuccessResponse(w, map[string]string{"message": "Member removed"})
+}
+
+// orgInviteURL returns the link an invitee opens to accept
+func orgInviteURL(invite OrgInvite) string {
+	return appBaseURL() + "/org-invites/" + invite.ID
+}
+
+// createOrgInvitesHandler invites email addresses to the organization with
+// the given role and emails each of them
+func createOrgInvitesHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	org, caller, ok := requireOrgRole(w, r, mux.Vars(r)["id"], userID, OrgRoleOwner, OrgRoleAdmin)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Emails []string `json:"emails"`
+		Role   string   `json:"role,omitempty"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if req.Role == "" {
+		req.Role = OrgRoleMember
+	}
+	if !isValidOrgRole(req.Role) {
+		sendErrorResponse(w, "Role must be owner, admin or member", http.StatusBadRequest)
+		return
+	}
+	if req.Role == OrgRoleOwner && caller.Role != OrgRoleOwner {
+		sendErrorResponse(w, "Only owners can invite owners", http.StatusForbidden)
+		return
+	}
+
+	var emails []string
+	for _, email := range req.Emails {
+		email = strings.ToLower(strings.TrimSpace(email))
+		if !validateEmail(email) {
+			sendErrorResponse(w, fmt.Sprintf("Invalid email: %s", email), http.StatusBadRequest)
+			return
+		}
+		emails = append(emails, email)
+	}
+	emails = uniqueStrings(emails)
+	if len(emails) == 0 || len(emails) > MaxOrgInvitesPerCall {
+		sendErrorResponse(w, fmt.Sprintf("Between 1 and %d emails are required", MaxOrgInvitesPerCall), http.StatusBadRequest)
+		return
+	}
+
+	var inviter User
+	db.Users.FindOne(r.Context(), bson.M{"_id": userID}).Decode(&inviter)
+
+	now := time.Now()
+	invites := make([]OrgInvite, 0, len(emails))
+	for _, email := range emails {
+		// Re-inviting an address refreshes its pending invite
+		var invite OrgInvite
+		err := db.OrgInvites.FindOneAndUpdate(
+			r.Context(),
+			bson.M{"orgId": org.ID, "email": email},
+			bson.M{
+				"$set": bson.M{
+					"role":      req.Role,
+					"invitedBy": userID,
+					"expiresAt": now.Add(OrgInviteTTL),
+				},
+				"$unset":       bson.M{"acceptedAt": ""},
+				"$setOnInsert": bson.M{"_id": uuid.New().String(), "createdAt": now},
+			},
+			options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+		).Decode(&invite)
+		if err != nil {
+			log.Printf("Error saving organization invite for %s: %v", email, err)
+			sendErrorResponse(w, "Failed to create invites", http.StatusInternalServerError)
+			return
+		}
+
+		err = mail.EnqueueTemplate(email, "org_invitation", map[string]string{
+			"InviterName": inviter.Name,
+			"OrgName":     org.Name,
+			"Role":        invite.Role,
+			"AcceptURL":   orgInviteURL(invite),
+			"ExpiresAt":   invite.ExpiresAt.UTC().Format(time.RFC1123),
+		})
+		if err != nil {
+			log.Printf("Error sending organization invite to %s: %v", email, err)
+		}
+		invites = append(invites, invite)
+	}
+
+	sendSuccessResponse(w, invites)
+}
+
+// acceptOrgInviteHandler adds the signed-in user to the organization when the
+// invite was addressed to their email
+func acceptOrgInviteHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var invite OrgInvite
+	if err := db.OrgInvites.FindOne(r.Context(), bson.M{"_id": mux.Vars(r)["inviteId"]}).Decode(&invite); err != nil {
+		sendErrorResponse(w, "Invite not found", http.StatusNotFound)
+		return
+	}
+	var user User
+	if err := db.Users.FindOne(r.Context(), bson.M{"_id": userID}).Decode(&user); err != nil {
+		sendCodedError(w, ErrCodeUserNotFound, "User not found")
+		return
+	}
+	if !strings.EqualFold(user.Email, invite.Email) {
+		sendErrorResponse(w, "This invite was sent to a different email address", http.StatusForbidden)
+		return
+	}
+	if invite.AcceptedAt != nil {
+		sendErrorResponse(w, "Invite has already been accepted", http.StatusConflict)
+		return
+	}
+	if time.Now().After(invite.ExpiresAt) {
+		sendErrorResponse(w, "Invite has expired", http.StatusGone)
+		return
+	}
+
+	now := time.Now()
+	member := OrgMember{ID: uuid.New().String(), OrgID: invite.OrgID, UserID: userID, Role: invite.Role, JoinedAt: now}
+	if _, err := db.OrgMembers.InsertOne(r.Context(), member); err != nil {
+		if !mongo.IsDuplicateKeyError(err) {
+			log.Printf("Error accepting organization invite %s: %v", invite.ID, err)
+			sendErrorResponse(w, "Failed to join organization", http.StatusInternalServerError)
+			return
+		}
+		// Already a member; the invite is still consumed
+		member, _ = findOrgMembership(r.Context(), invite.OrgID, userID)
+	}
+	db.OrgInvites.UpdateOne(r.Context(), bson.M{"_id": invite.ID}, bson.M{"$set": bson.M{"acceptedAt": now}})
+
+	sendSuccessResponse(w, member)
+}
+
+var errNotOrgMember = errors.New("you are not a member of this organization")
+
+// loadMeetingOrg returns the organization a new meeting belongs to, checking
+// that the creator is a member. A nil organization means a personal meeting.
+func loadMeetingOrg(ctx context.Context, orgID, userID string) (*Organization, error) {
+	if orgID == "" {
+		return nil, nil
+	}
+	if _, err := findOrgMembership(ctx, orgID, userID); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, errNotOrgMember
+		}
+		return nil, err
+	}
+	var org Organization
+	if err := db.Organizations.FindOne(ctx, bson.M{"_id": orgID}).Decode(&org); err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+)
+
+// A join to a full meeting fails with CAPACITY_REACHED. With the overflow
+// queue configured, a join that asks to queue waits in line instead. When a
+// participant leaves, the seat is held for the first user in line, who is
+// notified and has the admit window to join before the seat passes on.
+// Users still waiting keep their place by checking it at least once per
+// admit window.
+
+// OverflowEntry is a user waiting for a seat in a full meeting
+type OverflowEntry struct {
+	ID         string    `json:"-" bson:"_id"`
+	MeetingID  string    `json:"meetingId" bson:"meetingId"`
+	UserID     string    `json:"userId" bson:"userId"`
+	QueuedAt   time.Time `json:"queuedAt" bson:"queuedAt"`
+	LastSeenAt time.Time `json:"-" bson:"lastSeenAt"`
+	// Set once a seat is held for the user, who must join before AdmitUntil
+	AdmittedAt *time.Time `json:"admittedAt,omitempty" bson:"admittedAt,omitempty"`
+	AdmitUntil *time.Time `json:"admitUntil,omitempty" bson:"admitUntil,omitempty"`
+	// How many users are ahead in line; 0 once admitted
+	Position int64 `json:"position" bson:"-"`
+}
+
+func overflowAdmitWindow() time.Duration {
+	return time.Duration(appConfig.Meetings.OverflowAdmitWindow)
+}
+
+// heldOverflowSeats counts the seats held for users other than userID
+func heldOverflowSeats(ctx context.Context, meetingID, userID string, now time.Time) (int64, error) {
+	return db.OverflowQueue.CountDocuments(ctx, bson.M{
+		"meetingId":  meetingID,
+		"userId":     bson.M{"$ne": userID},
+		"admitUntil": bson.M{"$gt": now},
+	})
+}
+
+// enqueueOverflow puts the user in the meeting's queue, keeping their place
+// if they are in it already, and returns their entry
+func enqueueOverflow(ctx context.Context, meetingID, userID string, now time.Time) (OverflowEntry, error) {
+	var entry OverflowEntry
+	err := db.OverflowQueue.FindOneAndUpdate(ctx,
+		bson.M{"meetingId": meetingID, "userId": userID},
+		bson.M{
+			"$set":         bson.M{"lastSeenAt": now},
+			"$setOnInsert": bson.M{"_id": uuid.New().String(), "queuedAt": now},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&entry)
+	if err != nil {
+		return entry, err
+	}
+	entry.Position, err = overflowPosition(ctx, entry)
+	return entry, err
+}
+
+// overflowPosition counts the users waiting ahead of the entry
+func overflowPosition(ctx context.Context, entry OverflowEntry) (int64, error) {
+	if entry.AdmittedAt != nil {
+		return 0, nil
+	}
+	return db.OverflowQueue.CountDocuments(ctx, bson.M{
+		"meetingId":  entry.MeetingID,
+		"admittedAt": bson.M{"$exists": false},
+		"queuedAt":   bson.M{"$lt": entry.QueuedAt},
+	})
+}
+
+// promoteOverflow holds the meeting's free seats for the users first in
+// line and notifies them. Entries whose admit window passed, and waiting
+// users who stopped checking their place, are dropped first. Returns how
+// many users were admitted.
+func promoteOverflow(ctx context.Context, meetingID string) (int64, error) {
+	if !appConfig.Meetings.OverflowQueue {
+		return 0, nil
+	}
+	ctx = meetingTenantContext(ctx, meetingID)
+
+	result, err := inJoinTransaction(ctx, func(ctx context.Context) (interface{}, error) {
+		meeting, err := claimMeetingSeats(ctx, meetingID)
+		if err != nil {
+			return nil, err
+		}
+		if !meeting.IsActive {
+			_, err := db.OverflowQueue.DeleteMany(ctx, bson.M{"meetingId": meetingID})
+			return []OverflowEntry(nil), err
+		}
+
+		now := time.Now()
+		_, err = db.OverflowQueue.DeleteMany(ctx, bson.M{
+			"meetingId": meetingID,
+			"$or": bson.A{
+				bson.M{"admitUntil": bson.M{"$lte": now}},
+				bson.M{"admittedAt": bson.M{"$exists": false}, "lastSeenAt": bson.M{"$lt": now.Add(-overflowAdmitWindow())}},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		present, err := db.Participants.CountDocuments(ctx, bson.M{
+			"meetingId": meetingID,
+			"leftAt":    bson.M{"$exists": false},
+		})
+		if err != nil {
+			return nil, err
+		}
+		held, err := heldOverflowSeats(ctx, meetingID, "", now)
+		if err != nil {
+			return nil, err
+		}
+
+		var admitted []OverflowEntry
+		admitUntil := now.Add(overflowAdmitWindow())
+		for free := int64(meeting.MaxParticipants) - present - held; free > 0; free-- {
+			var entry OverflowEntry
+			err := db.OverflowQueue.FindOneAndUpdate(ctx,
+				bson.M{"meetingId": meetingID, "admittedAt": bson.M{"$exists": false}},
+				bson.M{"$set": bson.M{"admittedAt": now, "admitUntil": admitUntil}},
+				options.FindOneAndUpdate().
+					SetSort(bson.D{{Key: "queuedAt", Value: 1}}).
+					SetReturnDocument(options.After),
+			).Decode(&entry)
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			admitted = append(admitted, entry)
+		}
+		return admitted, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Told only once the seats are surely held
+	admitted := result.([]OverflowEntry)
+	for _, entry := range admitted {
+		notifySeatAvailable(ctx, entry)
+	}
+	return int64(len(admitted)), nil
+}
+
+// notifySeatAvailable tells a user let in from the queue to join
+func notifySeatAvailable(ctx context.Context, entry OverflowEntry) {
+	presence.Notify(ctx, entry.UserID, WebSocketMessage{
+		Type:      "seat-available",
+		Data:      entry,
+		MeetingID: entry.MeetingID,
+	})
+	sendPushToUser(entry.UserID, PushNotification{
+		Type:  PushSeatAvailable,
+		Title: "A seat is free",
+		Body:  "Join the meeting now to take it",
+		Data:  map[string]string{"meetingId": entry.MeetingID},
+	})
+}
+
+// seatFreed lets the next user in line into the meeting after a participant
+// left; ctx may be a request's, the work outlives it
+func seatFreed(ctx context.Context, meetingID string) {
+	if !appConfig.Meetings.OverflowQueue {
+		return
+	}
+	go func() {
+		if _, err := promoteOverflow(db.CopyTenant(context.Background(), ctx), meetingID); err != nil {
+			log.Printf("Error admitting from the queue of meeting %s: %v", meetingID, err)
+		}
+	}()
+}
+
+// promoteOverflowQueues runs promoteOverflow for every meeting with a queue,
+// so seats whose admit window passed go to the next in line even when
+// nobody leaves
+func promoteOverflowQueues(ctx context.Context) (int64, error) {
+	if !appConfig.Meetings.OverflowQueue {
+		return 0, nil
+	}
+	meetingIDs, err := db.OverflowQueue.Distinct(ctx, "meetingId", bson.M{})
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, id := range meetingIDs {
+		meetingID, ok := id.(string)
+		if !ok {
+			continue
+		}
+		admitted, err := promoteOverflow(ctx, meetingID)
+		if err != nil {
+			return total, err
+		}
+		total += admitted
+	}
+	return total, nil
+}
+
+// getOverflowEntryHandler returns the caller's place in the meeting's queue,
+// which also keeps it
+func getOverflowEntryHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	meeting, err := findMeeting(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+	if !meeting.IsActive {
+		sendCodedError(w, ErrCodeMeetingEnded, "Meeting has ended")
+		return
+	}
+
+	// A seat that freed up without anyone leaving, e.g. when the one held
+	// for the user ahead lapsed, is handed on here
+	if _, err := promoteOverflow(r.Context(), meeting.ID); err != nil {
+		log.Printf("Error admitting from the queue of meeting %s: %v", meeting.ID, err)
+	}
+
+	var entry OverflowEntry
+	err = db.OverflowQueue.FindOneAndUpdate(r.Context(),
+		bson.M{"meetingId": meeting.ID, "userId": userID},
+		bson.M{"$set": bson.M{"lastSeenAt": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&entry)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		sendErrorResponse(w, "Not waiting for this meeting; join it again", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		sendErrorResponse(w, "Failed to load queue", http.StatusInternalServerError)
+		return
+	}
+	if entry.Position, err = overflowPosition(r.Context(), entry); err != nil {
+		sendErrorResponse(w, "Failed to load queue", http.StatusInternalServerError)
+		return
+	}
+	sendSuccessResponse(w, entry)
+}
+
+// leaveOverflowHandler takes the caller out of the meeting's queue, giving
+// up a seat held for them
+func leaveOverflowHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	meeting, err := findMeeting(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+
+	var entry OverflowEntry
+	err = db.OverflowQueue.FindOneAndDelete(r.Context(), bson.M{"meetingId": meeting.ID, "userId": userID}).Decode(&entry)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		sendErrorResponse(w, "Not waiting for this meeting", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		sendErrorResponse(w, "Failed to leave queue", http.StatusInternalServerError)
+		return
+	}
+	if entry.AdmittedAt != nil {
+		seatFreed(r.Context(), meeting.ID)
+	}
+	sendSuccessResponse(w, map[string]string{"message": "Left the queue"})
+}
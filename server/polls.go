@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+)
+
+const (
+	MinPollOptions = 2
+	MaxPollOptions = 10
+)
+
+// Poll is a question the host asks during a meeting
+type Poll struct {
+	ID        string         `json:"id" bson:"_id"`
+	MeetingID string         `json:"meetingId" bson:"meetingId"`
+	CreatedBy string         `json:"createdBy" bson:"createdBy"`
+	Question  string         `json:"question" bson:"question"`
+	Options   []string       `json:"options" bson:"options"`
+	Votes     map[string]int `json:"-" bson:"votes"`   // userId -> option index
+	Results   []int          `json:"results" bson:"-"` // Counted from Votes when read
+	// Anonymous polls never show who voted for what, not even to the host
+	Anonymous bool       `json:"anonymous" bson:"anonymous"`
+	IsClosed  bool       `json:"isClosed" bson:"isClosed"`
+	CreatedAt time.Time  `json:"createdAt" bson:"createdAt"`
+	ClosedAt  *time.Time `json:"closedAt,omitempty" bson:"closedAt,omitempty"`
+}
+
+// PollView is a poll as listed to a meeting's viewers
+type PollView struct {
+	Poll
+	// Who voted for which option; only shown to the host of polls that
+	// aren't anonymous
+	Votes map[string]int `json:"votes,omitempty"`
+	// The caller's own vote, when they voted
+	MyVote *int `json:"myVote,omitempty"`
+}
+
+// storedPoll is a Poll as stored, without its results
+type storedPoll Poll
+
+// UnmarshalBSON decodes a poll and counts its results, so they always agree
+// with its votes
+func (p *Poll) UnmarshalBSON(data []byte) error {
+	var stored storedPoll
+	if err := bson.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	*p = Poll(stored)
+	p.Results = p.tally()
+	return nil
+}
+
+// tally counts the votes for each option
+func (p *Poll) tally() []int {
+	results := make([]int, len(p.Options))
+	for _, option := range p.Votes {
+		if option >= 0 && option < len(results) {
+			results[option]++
+		}
+	}
+	return results
+}
+
+func createPollHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	meetingID := vars["id"]
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	meeting, err := findMeeting(r.Context(), meetingID)
+	if err != nil {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+	meetingID = meeting.ID
+	if meeting.CreatedBy != userID {
+		sendCodedError(w, ErrCodeHostOnly, "Only the host can create polls")
+		return
+	}
+
+	var req struct {
+		Question  string   `json:"question"`
+		Options   []string `json:"options"`
+		Anonymous bool     `json:"anonymous,omitempty"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+
+	if strings.TrimSpace(req.Question) == "" {
+		sendErrorResponse(w, "Poll question is required", http.StatusBadRequest)
+		return
+	}
+
+	var pollOptions []string
+	for _, option := range req.Options {
+		if option = strings.TrimSpace(option); option != "" {
+			pollOptions = append(pollOptions, option)
+		}
+	}
+	if len(pollOptions) < MinPollOptions || len(pollOptions) > MaxPollOptions {
+		sendErrorResponse(w, "Poll must have between 2 and 10 options", http.StatusBadRequest)
+		return
+	}
+
+	poll := Poll{
+		ID:        uuid.New().String(),
+		MeetingID: meetingID,
+		CreatedBy: userID,
+		Question:  strings.TrimSpace(req.Question),
+		Options:   pollOptions,
+		Votes:     map[string]int{},
+		Results:   make([]int, len(pollOptions)),
+		Anonymous: req.Anonymous,
+		CreatedAt: time.Now(),
+	}
+
+	if _, err := db.Polls.InsertOne(r.Context(), poll); err != nil {
+		log.Printf("Error creating poll: %v", err)
+		sendErrorResponse(w, "Error creating poll", http.StatusInternalServerError)
+		return
+	}
+
+	recordMeetingEvent(r.Context(), meetingID, EventPollCreated, userID, "", map[string]interface{}{
+		"pollId":   poll.ID,
+		"question": poll.Question,
+	})
+	hub.BroadcastToMeeting(meetingID, WebSocketMessage{
+		Type:   "poll-created",
+		Data:   poll,
+		UserID: userID,
+	}, nil)
+
+	sendSuccessResponse(w, poll)
+}
+
+// getPollsHandler lists a meeting's polls to those who can view the meeting
+func getPollsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	meeting, err := findMeeting(r.Context(), vars["id"])
+	if err != nil || !canViewMeeting(meeting, userID) {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+
+	cursor, err := db.Polls.Find(
+		r.Context(),
+		bson.M{"meetingId": meeting.ID},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}),
+	)
+	if err != nil {
+		sendErrorResponse(w, "Failed to fetch polls", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(r.Context())
+
+	polls := []Poll{}
+	if err := cursor.All(r.Context(), &polls); err != nil {
+		sendErrorResponse(w, "Failed to parse polls", http.StatusInternalServerError)
+		return
+	}
+
+	views := make([]PollView, 0, len(polls))
+	for _, poll := range polls {
+		view := PollView{Poll: poll}
+		if vote, ok := poll.Votes[userID]; ok {
+			view.MyVote = &vote
+		}
+		if !poll.Anonymous && meeting.CreatedBy == userID {
+			view.Votes = poll.Votes
+		}
+		views = append(views, view)
+	}
+	sendSuccessResponse(w, views)
+}
+
+func closePollHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	meetingID := vars["id"]
+	pollID := vars["pollId"]
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	meeting, err := findMeeting(r.Context(), meetingID)
+	if err != nil {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+	meetingID = meeting.ID
+	if meeting.CreatedBy != userID {
+		sendCodedError(w, ErrCodeHostOnly, "Only the host can close polls")
+		return
+	}
+
+	var poll Poll
+	err = db.Polls.FindOne(r.Context(), bson.M{"_id": pollID, "meetingId": meetingID}).Decode(&poll)
+	if err != nil {
+		sendErrorResponse(w, "Poll not found", http.StatusNotFound)
+		return
+	}
+	if poll.IsClosed {
+		sendErrorResponse(w, "Poll is already closed", http.StatusConflict)
+		return
+	}
+
+	// Closing returns the final votes, since no vote lands once it is closed
+	now := time.Now()
+	err = db.Polls.FindOneAndUpdate(
+		r.Context(),
+		bson.M{"_id": pollID, "isClosed": false},
+		bson.M{"$set": bson.M{"isClosed": true, "closedAt": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&poll)
+	if err == mongo.ErrNoDocuments {
+		sendErrorResponse(w, "Poll is already closed", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error closing poll: %v", err)
+		sendErrorResponse(w, "Failed to close poll", http.StatusInternalServerError)
+		return
+	}
+
+	recordMeetingEvent(r.Context(), meetingID, EventPollClosed, userID, "", map[string]interface{}{
+		"pollId":   poll.ID,
+		"question": poll.Question,
+	})
+	hub.BroadcastToMeeting(meetingID, WebSocketMessage{
+		Type:   "poll-closed",
+		Data:   poll,
+		UserID: userID,
+	}, nil)
+
+	sendSuccessResponse(w, poll)
+}
+
+// handlePollVote records a participant's vote and broadcasts the updated results
+func handlePollVote(ctx context.Context, c *Client, data json.RawMessage) {
+	var vote struct {
+		PollID      string `json:"pollId"`
+		OptionIndex int    `json:"optionIndex"`
+	}
+	if err := json.Unmarshal(data, &vote); err != nil || vote.PollID == "" || vote.OptionIndex < 0 {
+		c.sendError("Invalid poll vote")
+		return
+	}
+
+	// Only match open polls that actually have the chosen option
+	filter := bson.M{
+		"_id":       vote.PollID,
+		"meetingId": c.meetingID,
+		"isClosed":  false,
+		"options." + strconv.Itoa(vote.OptionIndex): bson.M{"$exists": true},
+	}
+
+	var poll Poll
+	err := db.Polls.FindOneAndUpdate(
+		ctx,
+		filter,
+		bson.M{"$set": bson.M{"votes." + c.userID: vote.OptionIndex}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&poll)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.sendError("Poll not found, closed, or option invalid")
+		} else {
+			log.Printf("Error recording poll vote: %v", err)
+			c.sendError("Failed to record vote")
+		}
+		return
+	}
+
+	hub.BroadcastToMeeting(c.meetingID, WebSocketMessage{
+		Type: "poll-results",
+		Data: poll,
+	}, nil)
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"video-meeting-app/db"
+	"video-meeting-app/wsproto"
+)
+
+const MaxPresenceQueryUsers = 200
+
+// PresenceStore records which presence connections are alive and fans
+// notifications out to whichever instance holds a user's connections.
+// Connections expire unless touched again before their expiry.
+type PresenceStore interface {
+	Touch(ctx context.Context, userID, connID string, expiresAt time.Time) error
+	Remove(ctx context.Context, userID, connID string) error
+	Online(ctx context.Context, userIDs []string, now time.Time) (map[string]bool, error)
+	Publish(ctx context.Context, userID string, payload []byte) error
+	// Subscribe calls deliver for every published notification until ctx is cancelled
+	Subscribe(ctx context.Context, deliver func(userID string, payload []byte))
+}
+
+// PresenceService tracks the presence sockets open on this instance
+type PresenceService struct {
+	store     PresenceStore
+	ttl       time.Duration
+	heartbeat time.Duration
+
+	mu    sync.Mutex
+	conns map[string]map[*presenceConn]bool // userId -> connections
+}
+
+type presenceConn struct {
+	id     string
+	userID string
+	conn   *websocket.Conn
+	send   chan []byte
+	binary bool // negotiated the protobuf subprotocol
+}
+
+// presence is created in main; the store is in memory unless Redis is configured
+var presence *PresenceService
+
+func newPresenceService(store PresenceStore, ttl, heartbeat time.Duration) *PresenceService {
+	return &PresenceService{
+		store:     store,
+		ttl:       ttl,
+		heartbeat: heartbeat,
+		conns:     make(map[string]map[*presenceConn]bool),
+	}
+}
+
+// Run refreshes local connections and delivers notifications until ctx is cancelled
+func (p *PresenceService) Run(ctx context.Context) {
+	go p.store.Subscribe(ctx, p.deliver)
+
+	ticker := time.NewTicker(p.heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, pc := range p.localConns() {
+				if err := p.store.Touch(ctx, pc.userID, pc.id, now.Add(p.ttl)); err != nil {
+					log.Printf("Presence: heartbeat for %s failed: %v", pc.userID, err)
+				}
+			}
+		}
+	}
+}
+
+func (p *PresenceService) localConns() []*presenceConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var conns []*presenceConn
+	for _, userConns := range p.conns {
+		for pc := range userConns {
+			conns = append(conns, pc)
+		}
+	}
+	return conns
+}
+
+// Online reports which of the users have a presence socket open on any instance
+func (p *PresenceService) Online(ctx context.Context, userIDs []string) map[string]bool {
+	if len(userIDs) == 0 {
+		return map[string]bool{}
+	}
+	online, err := p.store.Online(ctx, userIDs, time.Now())
+	if err != nil {
+		log.Printf("Presence: lookup failed: %v", err)
+		return map[string]bool{}
+	}
+	return online
+}
+
+// Notify sends a message to every presence socket the user has open, on any instance
+func (p *PresenceService) Notify(ctx context.Context, userID string, message WebSocketMessage) {
+	if message.Timestamp.IsZero() {
+		message.Timestamp = time.Now()
+	}
+	payload, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Presence: error marshaling notification: %v", err)
+		return
+	}
+	if err := p.store.Publish(ctx, userID, payload); err != nil {
+		log.Printf("Presence: failed to publish to %s: %v", userID, err)
+	}
+}
+
+// deliver writes a published notification to the user's local sockets
+func (p *PresenceService) deliver(userID string, payload []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for pc := range p.conns[userID] {
+		select {
+		case pc.send <- payload:
+		default:
+			// A client this far behind is dropped; it reconnects and resyncs
+			delete(p.conns[userID], pc)
+			close(pc.send)
+		}
+	}
+}
+
+func (p *PresenceService) connect(ctx context.Context, pc *presenceConn) {
+	wasOnline := p.Online(ctx, []string{pc.userID})[pc.userID]
+
+	p.mu.Lock()
+	if p.conns[pc.userID] == nil {
+		p.conns[pc.userID] = make(map[*presenceConn]bool)
+	}
+	p.conns[pc.userID][pc] = true
+	p.mu.Unlock()
+
+	if err := p.store.Touch(ctx, pc.userID, pc.id, time.Now().Add(p.ttl)); err != nil {
+		log.Printf("Presence: failed to register %s: %v", pc.userID, err)
+	}
+	if !wasOnline {
+		p.announce(ctx, pc.userID, true)
+	}
+}
+
+func (p *PresenceService) disconnect(ctx context.Context, pc *presenceConn) {
+	p.mu.Lock()
+	if _, ok := p.conns[pc.userID][pc]; ok {
+		delete(p.conns[pc.userID], pc)
+		close(pc.send)
+	}
+	if len(p.conns[pc.userID]) == 0 {
+		delete(p.conns, pc.userID)
+	}
+	p.mu.Unlock()
+
+	if err := p.store.Remove(ctx, pc.userID, pc.id); err != nil {
+		log.Printf("Presence: failed to unregister %s: %v", pc.userID, err)
+	}
+	if !p.Online(ctx, []string{pc.userID})[pc.userID] {
+		p.announce(ctx, pc.userID, false)
+	}
+}
+
+// announce tells users who have this user in their contacts that they came
+// online or went offline
+func (p *PresenceService) announce(ctx context.Context, userID string, online bool) {
+	cursor, err := db.Contacts.Find(ctx, bson.M{"userId": userID})
+	if err != nil {
+		log.Printf("Presence: failed to load watchers of %s: %v", userID, err)
+		return
+	}
+	var contacts []Contact
+	if err := cursor.All(ctx, &contacts); err != nil {
+		log.Printf("Presence: failed to load watchers of %s: %v", userID, err)
+		return
+	}
+
+	for _, contact := range contacts {
+		p.Notify(ctx, contact.OwnerID, WebSocketMessage{
+			Type:   "presence-changed",
+			Data:   map[string]interface{}{"userId": userID, "online": online},
+			UserID: userID,
+		})
+	}
+}
+
+// presenceWebSocketHandler opens the user's app-wide presence socket. It
+// carries presence changes and notifications such as quick invites, and is
+// separate from per-meeting sockets.
+func presenceWebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Presence WebSocket upgrade error: %v", err)
+		return
+	}
+
+	pc := &presenceConn{
+		id:     uuid.New().String(),
+		userID: userID,
+		conn:   conn,
+		send:   make(chan []byte, 64),
+		binary: conn.Subprotocol() == wsproto.SubprotocolProto,
+	}
+	presence.connect(context.Background(), pc)
+
+	go pc.writePump()
+	go pc.readPump()
+}
+
+// readPump only watches for disconnects; clients send nothing but pongs
+func (pc *presenceConn) readPump() {
+	defer func() {
+		presence.disconnect(context.Background(), pc)
+		pc.conn.Close()
+	}()
+
+	pc.conn.SetReadLimit(MaxMessageSize)
+	pc.conn.SetReadDeadline(time.Now().Add(PongWait))
+	pc.conn.SetPongHandler(func(string) error {
+		pc.conn.SetReadDeadline(time.Now().Add(PongWait))
+		return nil
+	})
+
+	for {
+		if _, _, err := pc.conn.ReadMessage(); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				log.Printf("Presence WebSocket read error for %s: %v", pc.userID, err)
+			}
+			return
+		}
+	}
+}
+
+func (pc *presenceConn) writePump() {
+	ticker := time.NewTicker(PingPeriod)
+	defer func() {
+		ticker.Stop()
+		pc.conn.Close()
+	}()
+
+	for {
+		select {
+		case message, ok := <-pc.send:
+			pc.conn.SetWriteDeadline(time.Now().Add(WriteWait))
+			if !ok {
+				pc.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			frameType := websocket.TextMessage
+			if pc.binary {
+				// Presence messages are queued as JSON, possibly by another instance
+				frameType = websocket.BinaryMessage
+				if message = jsonFrameToProto(message); message == nil {
+					continue
+				}
+			}
+			if err := pc.conn.WriteMessage(frameType, message); err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			pc.conn.SetWriteDeadline(time.Now().Add(WriteWait))
+			if err := pc.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// getPresenceHandler reports the online status of up to MaxPresenceQueryUsers
+// users given as ?userIds=a,b,c
+func getPresenceHandler(w http.ResponseWriter, r *http.Request) {
+	if getUserIDFromToken(r) == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var userIDs []string
+	for _, id := range strings.Split(r.URL.Query().Get("userIds"), ",") {
+		userIDs = append(userIDs, strings.TrimSpace(id))
+	}
+	userIDs = uniqueStrings(userIDs)
+	if len(userIDs) == 0 || len(userIDs) > MaxPresenceQueryUsers {
+		sendErrorResponse(w, "Between 1 and 200 userIds are required", http.StatusBadRequest)
+		return
+	}
+
+	online := presence.Online(r.Context(), userIDs)
+	result := make(map[string]bool, len(userIDs))
+	for _, id := range userIDs {
+		result[id] = online[id]
+	}
+	sendSuccessResponse(w, result)
+}
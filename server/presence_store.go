@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	presenceKeyPrefix     = "presence:user:"
+	presenceNotifyChannel = "presence:notify"
+)
+
+// memoryPresenceStore keeps presence in process; correct only when a single
+// instance serves every presence socket
+type memoryPresenceStore struct {
+	mu      sync.Mutex
+	conns   map[string]map[string]time.Time // userId -> connId -> expiry
+	deliver func(userID string, payload []byte)
+}
+
+func newMemoryPresenceStore() *memoryPresenceStore {
+	return &memoryPresenceStore{conns: make(map[string]map[string]time.Time)}
+}
+
+func (s *memoryPresenceStore) Touch(ctx context.Context, userID, connID string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conns[userID] == nil {
+		s.conns[userID] = make(map[string]time.Time)
+	}
+	s.conns[userID][connID] = expiresAt
+	return nil
+}
+
+func (s *memoryPresenceStore) Remove(ctx context.Context, userID, connID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.conns[userID], connID)
+	if len(s.conns[userID]) == 0 {
+		delete(s.conns, userID)
+	}
+	return nil
+}
+
+func (s *memoryPresenceStore) Online(ctx context.Context, userIDs []string, now time.Time) (map[string]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	online := make(map[string]bool)
+	for _, userID := range userIDs {
+		for connID, expiresAt := range s.conns[userID] {
+			if expiresAt.After(now) {
+				online[userID] = true
+				break
+			}
+			delete(s.conns[userID], connID)
+		}
+	}
+	return online, nil
+}
+
+func (s *memoryPresenceStore) Publish(ctx context.Context, userID string, payload []byte) error {
+	s.mu.Lock()
+	deliver := s.deliver
+	s.mu.Unlock()
+
+	if deliver != nil {
+		deliver(userID, payload)
+	}
+	return nil
+}
+
+func (s *memoryPresenceStore) Subscribe(ctx context.Context, deliver func(userID string, payload []byte)) {
+	s.mu.Lock()
+	s.deliver = deliver
+	s.mu.Unlock()
+}
+
+// redisPresenceStore shares presence between instances. Each user has a
+// sorted set of connection IDs scored by expiry, and notifications travel
+// over a pub/sub channel every instance subscribes to.
+type redisPresenceStore struct {
+	client *redis.Client
+}
+
+type presenceNotification struct {
+	UserID  string          `json:"userId"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+func newRedisPresenceStore(redisURL string) (*redisPresenceStore, error) {
+	client, err := newRedisClient(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	return &redisPresenceStore{client: client}, nil
+}
+
+func (s *redisPresenceStore) Touch(ctx context.Context, userID, connID string, expiresAt time.Time) error {
+	key := presenceKeyPrefix + userID
+	pipe := s.client.TxPipeline()
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(expiresAt.UnixMilli()), Member: connID})
+	// Drop connections of instances that died without cleaning up
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(time.Now().UnixMilli(), 10))
+	pipe.PExpireAt(ctx, key, expiresAt)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisPresenceStore) Remove(ctx context.Context, userID, connID string) error {
+	return s.client.ZRem(ctx, presenceKeyPrefix+userID, connID).Err()
+}
+
+func (s *redisPresenceStore) Online(ctx context.Context, userIDs []string, now time.Time) (map[string]bool, error) {
+	pipe := s.client.Pipeline()
+	counts := make([]*redis.IntCmd, len(userIDs))
+	for i, userID := range userIDs {
+		counts[i] = pipe.ZCount(ctx, presenceKeyPrefix+userID, "("+strconv.FormatInt(now.UnixMilli(), 10), "+inf")
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	online := make(map[string]bool)
+	for i, count := range counts {
+		if count.Val() > 0 {
+			online[userIDs[i]] = true
+		}
+	}
+	return online, nil
+}
+
+func (s *redisPresenceStore) Publish(ctx context.Context, userID string, payload []byte) error {
+	message, err := json.Marshal(presenceNotification{UserID: userID, Payload: payload})
+	if err != nil {
+		return err
+	}
+	return s.client.Publish(ctx, presenceNotifyChannel, message).Err()
+}
+
+func (s *redisPresenceStore) Subscribe(ctx context.Context, deliver func(userID string, payload []byte)) {
+	sub := s.client.Subscribe(ctx, presenceNotifyChannel)
+	defer sub.Close()
+
+	messages := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case message, ok := <-messages:
+			if !ok {
+				return
+			}
+			var notification presenceNotification
+			if err := json.Unmarshal([]byte(message.Payload), &notification); err != nil {
+				log.Printf("Presence: invalid notification: %v", err)
+				continue
+			}
+			deliver(notification.UserID, notification.Payload)
+		}
+	}
+}
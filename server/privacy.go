@@ -0,0 +1,431 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+	"video-meeting-app/storage"
+)
+
+// ErasedUserName replaces the name of an erased user wherever it was copied
+const ErasedUserName = "Deleted user"
+
+// UserDataExport is everything stored about a user, as returned by the
+// export endpoint
+type UserDataExport struct {
+	ExportedAt     time.Time             `json:"exportedAt"`
+	User           User                  `json:"user"`
+	Meetings       []Meeting             `json:"meetings"`
+	Archived       []ArchivedMeeting     `json:"archivedMeetings"`
+	Participations []Participant         `json:"participations"`
+	Attendance     []AttendanceEvent     `json:"attendance"`
+	History        []MeetingHistoryEntry `json:"meetingHistory"`
+	MeetingEvents  []MeetingEvent        `json:"meetingEvents"`
+	ChatMessages   []ChatMessage         `json:"chatMessages"`
+	Attachments    []ChatAttachment      `json:"attachments"`
+	Recordings     []Recording           `json:"recordings"`
+	Transcript     []TranscriptUtterance `json:"transcript"`
+	TalkTime       []TalkTime            `json:"talkTime"`
+	Contacts       []Contact             `json:"contacts"`
+	Organizations  []OrgMember           `json:"organizations"`
+	Devices        []DeviceToken         `json:"devices"`
+	Webhooks       []Webhook             `json:"webhooks"`
+}
+
+// findAll decodes every document in coll matching filter into out
+func findAll(ctx context.Context, coll *db.Collection, filter bson.M, out interface{}) error {
+	cursor, err := coll.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	return cursor.All(ctx, out)
+}
+
+// loadUserDataExport gathers the user's data from every collection
+func loadUserDataExport(ctx context.Context, user User, now time.Time) (UserDataExport, error) {
+	export := UserDataExport{ExportedAt: now, User: user}
+	byUser := bson.M{"userId": user.ID}
+
+	queries := []struct {
+		coll   *db.Collection
+		filter bson.M
+		out    interface{}
+	}{
+		{db.Meetings, bson.M{"createdBy": user.ID}, &export.Meetings},
+		{db.MeetingsArchive, bson.M{"createdBy": user.ID}, &export.Archived},
+		{db.Participants, byUser, &export.Participations},
+		{db.AttendanceEvents, byUser, &export.Attendance},
+		{db.MeetingHistory, byUser, &export.History},
+		{db.MeetingEvents, byUser, &export.MeetingEvents},
+		{db.ChatMessages, byUser, &export.ChatMessages},
+		{db.ChatAttachments, bson.M{"uploadedBy": user.ID}, &export.Attachments},
+		{db.Recordings, bson.M{"recordedBy": user.ID}, &export.Recordings},
+		{db.TranscriptUtterances, byUser, &export.Transcript},
+		{db.TalkTime, byUser, &export.TalkTime},
+		{db.Contacts, bson.M{"ownerId": user.ID}, &export.Contacts},
+		{db.OrgMembers, byUser, &export.Organizations},
+		{db.DeviceTokens, byUser, &export.Devices},
+		{db.Webhooks, byUser, &export.Webhooks},
+	}
+	for _, query := range queries {
+		if err := findAll(ctx, query.coll, query.filter, query.out); err != nil {
+			return export, err
+		}
+	}
+	return export, nil
+}
+
+// exportUserDataHandler returns the caller's data as JSON, or with
+// ?format=zip as an archive that also holds their recordings and chat
+// attachments
+func exportUserDataHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "zip" {
+		sendErrorResponse(w, "format must be json or zip", http.StatusBadRequest)
+		return
+	}
+
+	var user User
+	if err := db.Users.FindOne(r.Context(), bson.M{"_id": userID}).Decode(&user); err != nil {
+		sendCodedError(w, ErrCodeUserNotFound, "User not found")
+		return
+	}
+
+	now := time.Now()
+	export, err := loadUserDataExport(r.Context(), user, now)
+	if err != nil {
+		log.Printf("Error exporting data for user %s: %v", userID, err)
+		sendErrorResponse(w, "Failed to export data", http.StatusInternalServerError)
+		return
+	}
+
+	recordSecurityEvent(r.Context(), SecurityEvent{
+		Type:    SecurityDataExported,
+		UserID:  userID,
+		IP:      getClientIP(r),
+		ActorID: userID,
+		Details: map[string]interface{}{"format": format},
+		At:      now,
+	})
+
+	filename := fmt.Sprintf("export-%s-%s", userID, now.Format("20060102"))
+	if format != "zip" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, filename))
+		json.NewEncoder(w).Encode(export)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, filename))
+	if err := writeUserDataArchive(r.Context(), w, export); err != nil {
+		// Headers are already sent, so the client sees a truncated archive
+		log.Printf("Error writing data archive for user %s: %v", userID, err)
+	}
+}
+
+// writeUserDataArchive writes data.json and the stored files of the user's
+// recordings and attachments as a ZIP archive
+func writeUserDataArchive(ctx context.Context, w io.Writer, export UserDataExport) error {
+	archive := zip.NewWriter(w)
+
+	data, err := archive.Create("data.json")
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(data)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(export); err != nil {
+		return err
+	}
+
+	for _, recording := range export.Recordings {
+		if err := copyBlobToArchive(ctx, archive, recording.Key, "recordings/"+recording.ID+path.Ext(recording.Key)); err != nil {
+			return err
+		}
+	}
+	for _, attachment := range export.Attachments {
+		if err := copyBlobToArchive(ctx, archive, attachment.Key, "attachments/"+attachment.ID+"/"+attachment.FileName); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// copyBlobToArchive adds a stored object to the archive; objects that have
+// since been deleted are skipped
+func copyBlobToArchive(ctx context.Context, archive *zip.Writer, key, name string) error {
+	object, _, err := blobStore.Get(ctx, key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	defer object.Close()
+
+	entry, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, object)
+	return err
+}
+
+// erasedEmail is a placeholder address that keeps the unique email index
+// satisfied once a user's real address is removed
+func erasedEmail(userID string) string {
+	return userID + "@erased.invalid"
+}
+
+// eraseUserData deletes the user's own data and anonymizes what they
+// contributed to other people's meetings, returning how many documents were
+// changed in each collection. Erased accounts stay behind as disabled
+// placeholders so references to the user ID keep resolving.
+func eraseUserData(ctx context.Context, user User, now time.Time) (map[string]int64, error) {
+	counts := make(map[string]int64)
+
+	// Stored files go first; a failure leaves the records so erasure can be retried
+	recordings, err := deleteStoredFiles(ctx, db.Recordings, bson.M{"recordedBy": user.ID})
+	counts["recordings"] = recordings
+	if err != nil {
+		return counts, err
+	}
+	attachments, err := deleteStoredFiles(ctx, db.ChatAttachments, bson.M{"uploadedBy": user.ID})
+	counts["chat_attachments"] = attachments
+	if err != nil {
+		return counts, err
+	}
+
+	byUser := bson.M{"userId": user.ID}
+	// Login attempts are kept outside tenants
+	deletes := []struct {
+		name string
+		coll interface {
+			DeleteMany(context.Context, interface{}, ...*options.DeleteOptions) (*mongo.DeleteResult, error)
+		}
+		filter bson.M
+	}{
+		{"transcript_utterances", db.TranscriptUtterances, byUser},
+		{"meeting_history", db.MeetingHistory, byUser},
+		{"contacts", db.Contacts, bson.M{"ownerId": user.ID}},
+		{"org_members", db.OrgMembers, byUser},
+		{"org_invites", db.OrgInvites, bson.M{"email": user.Email}},
+		{"invitations", db.Invitations, bson.M{"email": user.Email}},
+		{"device_tokens", db.DeviceTokens, byUser},
+		{"webhooks", db.Webhooks, byUser},
+		{"api_keys", db.APIKeys, bson.M{"ownerId": user.ID}},
+		{"chat_reads", db.ChatReads, byUser},
+		{"whiteboard_events", db.WhiteboardEvents, byUser},
+		{"login_attempts", db.LoginAttempts, bson.M{"email": user.Email}},
+	}
+	for _, d := range deletes {
+		result, err := d.coll.DeleteMany(ctx, d.filter)
+		if err != nil {
+			return counts, err
+		}
+		counts[d.name] = result.DeletedCount
+	}
+
+	// Other users' address books keep the email they entered but lose the link
+	result, err := db.Contacts.UpdateMany(ctx, byUser, bson.M{"$unset": bson.M{"userId": ""}})
+	if err != nil {
+		return counts, err
+	}
+	counts["contacts"] += result.ModifiedCount
+
+	// Compacted whiteboards lose the user's strokes
+	result, err = db.WhiteboardSnapshots.UpdateMany(ctx, bson.M{"strokes.userId": user.ID},
+		bson.M{"$pull": bson.M{"strokes": bson.M{"userId": user.ID}}})
+	if err != nil {
+		return counts, err
+	}
+	counts["whiteboard_snapshots"] = result.ModifiedCount
+
+	// Chat stays in the meeting's history, without the user's name or words
+	result, err = db.ChatMessages.UpdateMany(ctx, byUser, bson.M{
+		"$set":   bson.M{"userName": ErasedUserName, "message": "", "deletedAt": now, "deletedBy": user.ID},
+		"$unset": bson.M{"history": "", "attachments": ""},
+	})
+	if err != nil {
+		return counts, err
+	}
+	counts["chat_messages"] = result.ModifiedCount
+
+	renames := []struct {
+		name string
+		coll *db.Collection
+	}{
+		{"participants", db.Participants},
+		{"attendance_events", db.AttendanceEvents},
+		{"meeting_events", db.MeetingEvents},
+		{"talk_time", db.TalkTime},
+	}
+	for _, rename := range renames {
+		result, err := rename.coll.UpdateMany(ctx, byUser, bson.M{"$set": bson.M{"userName": ErasedUserName}})
+		if err != nil {
+			return counts, err
+		}
+		counts[rename.name] = result.ModifiedCount
+	}
+
+	var hosting []Meeting
+	if err := findAll(ctx, db.Meetings, bson.M{"createdBy": user.ID, "isActive": true}, &hosting); err != nil {
+		return counts, err
+	}
+	for _, meeting := range hosting {
+		ended, err := finishMeeting(ctx, meeting, "", map[string]interface{}{
+			"endedAt": now,
+			"reason":  "host_erased",
+		}, now)
+		if err != nil {
+			return counts, err
+		}
+		if ended {
+			counts["meetings"]++
+		}
+	}
+
+	result, err = db.Users.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{
+		"$set": bson.M{
+			"name":           ErasedUserName,
+			"email":          erasedEmail(user.ID),
+			"password":       "",
+			"disabledAt":     now,
+			"disabledReason": "Account erased at the user's request",
+			"updatedAt":      now,
+		},
+		"$unset": bson.M{"avatarUrl": "", "avatarKey": "", "reminderPreferences": ""},
+	})
+	if err != nil {
+		return counts, err
+	}
+	counts["users"] = result.ModifiedCount
+	if user.AvatarKey != "" {
+		if err := blobStore.Delete(ctx, user.AvatarKey); err != nil {
+			log.Printf("Error deleting avatar %s: %v", user.AvatarKey, err)
+		}
+	}
+	return counts, nil
+}
+
+// deleteStoredFiles deletes the documents in coll matching filter together
+// with the stored objects under their "key" field and any derived objects,
+// such as recording previews
+func deleteStoredFiles(ctx context.Context, coll *db.Collection, filter bson.M) (int64, error) {
+	var files []struct {
+		ID         string `bson:"_id"`
+		Key        string `bson:"key"`
+		PosterKey  string `bson:"posterKey"`
+		PreviewKey string `bson:"previewKey"`
+	}
+	if err := findAll(ctx, coll, filter, &files); err != nil {
+		return 0, err
+	}
+	var deleted int64
+	for _, file := range files {
+		for _, key := range []string{file.PosterKey, file.PreviewKey, file.Key} {
+			if key == "" {
+				continue
+			}
+			if err := blobStore.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+				return deleted, err
+			}
+		}
+		result, err := coll.DeleteOne(ctx, bson.M{"_id": file.ID})
+		if err != nil {
+			return deleted, err
+		}
+		deleted += result.DeletedCount
+	}
+	return deleted, nil
+}
+
+// eraseUserHandler permanently erases the caller's account after they
+// confirm their password. Sole owners must hand over their organizations
+// first.
+func eraseUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Password string `json:"password"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+
+	var user User
+	if err := db.Users.FindOne(r.Context(), bson.M{"_id": userID}).Decode(&user); err != nil {
+		sendCodedError(w, ErrCodeUserNotFound, "User not found")
+		return
+	}
+	if ok, _ := verifyPassword(user.Password, req.Password); !ok {
+		sendErrorResponse(w, "Password is incorrect", http.StatusForbidden)
+		return
+	}
+
+	var owned []OrgMember
+	if err := findAll(r.Context(), db.OrgMembers, bson.M{"userId": userID, "role": OrgRoleOwner}, &owned); err != nil {
+		sendErrorResponse(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	for _, membership := range owned {
+		owners, err := db.OrgMembers.CountDocuments(r.Context(), bson.M{"orgId": membership.OrgID, "role": OrgRoleOwner})
+		if err != nil {
+			sendErrorResponse(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if owners <= 1 {
+			sendErrorResponse(w, "Transfer ownership of your organizations before erasing your account", http.StatusConflict)
+			return
+		}
+	}
+
+	now := time.Now()
+	counts, err := eraseUserData(r.Context(), user, now)
+	details := map[string]interface{}{"counts": counts}
+	if err != nil {
+		details["error"] = err.Error()
+	}
+	recordSecurityEvent(r.Context(), SecurityEvent{
+		Type:    SecurityAccountErased,
+		UserID:  userID,
+		IP:      getClientIP(r),
+		ActorID: userID,
+		Details: details,
+		At:      now,
+	})
+	if err != nil {
+		log.Printf("Error erasing user %s: %v", userID, err)
+		sendErrorResponse(w, "Failed to erase account; please try again", http.StatusInternalServerError)
+		return
+	}
+
+	disabledUsers.Set(userID, true)
+	endUserSessions(r.Context(), userID)
+	clearSessionCookie(w)
+	sendSuccessResponse(w, map[string]interface{}{"message": "Account erased", "counts": counts})
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"video-meeting-app/db"
+)
+
+const (
+	// ReadinessCacheTTL bounds how often probes hit MongoDB and the TURN server
+	ReadinessCacheTTL     = 5 * time.Second
+	ReadinessCheckTimeout = 2 * time.Second
+	DefaultTURNPort       = "3478"
+)
+
+// ReadinessCheck is the outcome of one dependency check
+type ReadinessCheck struct {
+	Status string `json:"status"` // "ok", "failing" or "skipped"
+	Error  string `json:"error,omitempty"`
+}
+
+// ReadinessReport is the cached result of all readiness checks
+type ReadinessReport struct {
+	Ready     bool                      `json:"ready"`
+	Checks    map[string]ReadinessCheck `json:"checks"`
+	CheckedAt time.Time                 `json:"checkedAt"`
+}
+
+// readinessCache serializes checks so concurrent probes share one result
+var readinessCache struct {
+	mu     sync.Mutex
+	report *ReadinessReport
+}
+
+// livenessHandler only reports that the process is serving requests; it never
+// touches dependencies so a database outage doesn't get the pod restarted
+func livenessHandler(w http.ResponseWriter, r *http.Request) {
+	sendSuccessResponse(w, map[string]string{"status": "alive"})
+}
+
+// readinessHandler reports whether the instance can take traffic, answering
+// 503 while any dependency check fails
+func readinessHandler(w http.ResponseWriter, r *http.Request) {
+	report := currentReadiness(r.Context())
+	if !report.Ready {
+		sendJSONResponse(w, http.StatusServiceUnavailable, Response{
+			Success: false,
+			Data:    report,
+			Error:   "Service not ready",
+		})
+		return
+	}
+	sendSuccessResponse(w, report)
+}
+
+// currentReadiness returns the cached report, re-running the checks once it is
+// older than ReadinessCacheTTL
+func currentReadiness(ctx context.Context) ReadinessReport {
+	readinessCache.mu.Lock()
+	defer readinessCache.mu.Unlock()
+
+	if cached := readinessCache.report; cached != nil && time.Since(cached.CheckedAt) < ReadinessCacheTTL {
+		return *cached
+	}
+
+	report := ReadinessReport{
+		Ready: true,
+		Checks: map[string]ReadinessCheck{
+			"database": checkDatabase(ctx),
+			"hub":      checkHub(),
+			"turn":     checkTURN(ctx),
+			"draining": checkDraining(),
+		},
+		CheckedAt: time.Now(),
+	}
+	for _, check := range report.Checks {
+		if check.Status == "failing" {
+			report.Ready = false
+		}
+	}
+	readinessCache.report = &report
+	return report
+}
+
+func checkResult(err error) ReadinessCheck {
+	if err != nil {
+		return ReadinessCheck{Status: "failing", Error: err.Error()}
+	}
+	return ReadinessCheck{Status: "ok"}
+}
+
+func checkDatabase(ctx context.Context) ReadinessCheck {
+	ctx, cancel := context.WithTimeout(ctx, ReadinessCheckTimeout)
+	defer cancel()
+	return checkResult(db.Ping(ctx))
+}
+
+func checkHub() ReadinessCheck {
+	if !hub.Alive() {
+		return checkResult(fmt.Errorf("hub loop is not responding"))
+	}
+	return checkResult(nil)
+}
+
+// checkDraining fails once the instance hands its meetings over, so load
+// balancers stop sending it connections
+func checkDraining() ReadinessCheck {
+	if draining.Load() {
+		return checkResult(fmt.Errorf("instance is draining"))
+	}
+	return checkResult(nil)
+}
+
+// checkTURN dials the configured TURN server (e.g. "turn:turn.example.com:3478")
+// over TCP; it is skipped when no TURN server is configured
+func checkTURN(ctx context.Context) ReadinessCheck {
+	turnURL := appConfig.Media.TURNURL
+	if turnURL == "" {
+		return ReadinessCheck{Status: "skipped"}
+	}
+	address, err := turnAddress(turnURL)
+	if err != nil {
+		return checkResult(err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ReadinessCheckTimeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return checkResult(err)
+	}
+	conn.Close()
+	return checkResult(nil)
+}
+
+// turnAddress extracts host:port from a turn: or turns: URI
+func turnAddress(turnURL string) (string, error) {
+	parsed, err := url.Parse(turnURL)
+	if err != nil || (parsed.Scheme != "turn" && parsed.Scheme != "turns") || parsed.Opaque == "" {
+		return "", fmt.Errorf("invalid TURN URL %q", turnURL)
+	}
+	hostPort := parsed.Opaque
+	if _, _, err := net.SplitHostPort(hostPort); err != nil {
+		hostPort = net.JoinHostPort(hostPort, DefaultTURNPort)
+	}
+	return hostPort, nil
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+
+	"video-meeting-app/config"
+	"video-meeting-app/db"
+)
+
+const (
+	PushHTTPTimeout     = 10 * time.Second
+	PushDeliveryTimeout = 30 * time.Second
+	MaxDevicesPerUser   = 20
+	// APNs rejects provider tokens older than an hour and throttles
+	// refreshing more often than every 20 minutes
+	APNsTokenRefresh = 45 * time.Minute
+
+	FCMScope          = "https://www.googleapis.com/auth/firebase.messaging"
+	APNsProductionURL = "https://api.push.apple.com"
+	APNsSandboxURL    = "https://api.sandbox.push.apple.com"
+)
+
+// Push platforms
+const (
+	PushPlatformFCM  = "fcm"
+	PushPlatformAPNs = "apns"
+)
+
+// Push notification types, sent in the "type" data field so clients can route taps
+const (
+	PushMeetingStarting = "meeting_starting"
+	PushInvited         = "invited"
+	PushSeatAvailable   = "seat_available"
+	// PushLobbyWaiting is reserved for when meetings get a lobby; nothing sends it yet
+	PushLobbyWaiting = "lobby_waiting"
+)
+
+// errPushTokenInvalid is returned by providers when the device token is no
+// longer registered, so it can be forgotten
+var errPushTokenInvalid = errors.New("push token is no longer valid")
+
+// DeviceToken is a push token registered by one of the user's devices
+type DeviceToken struct {
+	Token     string    `json:"token" bson:"_id"`
+	UserID    string    `json:"userId" bson:"userId"`
+	Platform  string    `json:"platform" bson:"platform"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+// PushNotification is a platform-neutral alert
+type PushNotification struct {
+	Type  string
+	Title string
+	Body  string
+	Data  map[string]string
+}
+
+// PushProvider delivers notifications to one platform's devices
+type PushProvider interface {
+	Send(ctx context.Context, token string, notification PushNotification) error
+}
+
+// pushProviders holds the configured providers by platform
+var pushProviders = map[string]PushProvider{}
+
+var pushHTTPClient = &http.Client{Timeout: PushHTTPTimeout}
+
+// initPushProviders sets up every provider whose credentials are configured
+func initPushProviders(ctx context.Context, cfg config.PushConfig) error {
+	if cfg.FCMCredentialsFile != "" {
+		provider, err := newFCMProvider(ctx, cfg.FCMCredentialsFile)
+		if err != nil {
+			return fmt.Errorf("fcm: %w", err)
+		}
+		pushProviders[PushPlatformFCM] = provider
+		log.Println("Push notifications enabled for FCM")
+	}
+	if cfg.APNsEnabled() {
+		provider, err := newAPNsProvider(cfg)
+		if err != nil {
+			return fmt.Errorf("apns: %w", err)
+		}
+		pushProviders[PushPlatformAPNs] = provider
+		log.Println("Push notifications enabled for APNs")
+	}
+	return nil
+}
+
+// fcmProvider sends through the Firebase Cloud Messaging HTTP v1 API
+type fcmProvider struct {
+	client    *http.Client
+	projectID string
+}
+
+func newFCMProvider(ctx context.Context, credentialsFile string) (*fcmProvider, error) {
+	data, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := google.CredentialsFromJSON(ctx, data, FCMScope)
+	if err != nil {
+		return nil, err
+	}
+	if creds.ProjectID == "" {
+		return nil, fmt.Errorf("credentials file has no project_id")
+	}
+
+	client := oauth2.NewClient(context.WithValue(ctx, oauth2.HTTPClient, pushHTTPClient), creds.TokenSource)
+	client.Timeout = PushHTTPTimeout
+	return &fcmProvider{client: client, projectID: creds.ProjectID}, nil
+}
+
+func (p *fcmProvider) Send(ctx context.Context, token string, notification PushNotification) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"message": map[string]interface{}{
+			"token": token,
+			"notification": map[string]string{
+				"title": notification.Title,
+				"body":  notification.Body,
+			},
+			"data": pushData(notification),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	endpoint := fmt.Sprintf("https://fcm.googleapis.com/v1/projects/%s/messages:send", p.projectID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return nil
+	case resp.StatusCode == http.StatusNotFound:
+		// UNREGISTERED: the app was uninstalled or the token rotated
+		return errPushTokenInvalid
+	default:
+		return fmt.Errorf("fcm responded with status %d", resp.StatusCode)
+	}
+}
+
+// apnsProvider sends through Apple's HTTP/2 provider API using token-based auth
+type apnsProvider struct {
+	key     *ecdsa.PrivateKey
+	keyID   string
+	teamID  string
+	topic   string
+	baseURL string
+
+	mu          sync.Mutex
+	bearer      string
+	bearerIssue time.Time
+}
+
+func newAPNsProvider(cfg config.PushConfig) (*apnsProvider, error) {
+	data, err := os.ReadFile(cfg.APNsKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("key file is not PEM encoded")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("key file is not an ECDSA key")
+	}
+
+	baseURL := APNsSandboxURL
+	if cfg.APNsProduction {
+		baseURL = APNsProductionURL
+	}
+	return &apnsProvider{
+		key:     key,
+		keyID:   cfg.APNsKeyID,
+		teamID:  cfg.APNsTeamID,
+		topic:   cfg.APNsTopic,
+		baseURL: baseURL,
+	}, nil
+}
+
+// providerToken returns the cached ES256 JWT, signing a new one when stale
+func (p *apnsProvider) providerToken(now time.Time) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.bearer != "" && now.Sub(p.bearerIssue) < APNsTokenRefresh {
+		return p.bearer, nil
+	}
+
+	encode := func(v interface{}) string {
+		data, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	unsigned := encode(map[string]string{"alg": "ES256", "kid": p.keyID}) + "." +
+		encode(map[string]interface{}{"iss": p.teamID, "iat": now.Unix()})
+
+	digest := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, p.key, digest[:])
+	if err != nil {
+		return "", err
+	}
+	// JWS encodes the signature as fixed-width r || s
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	p.bearer = unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)
+	p.bearerIssue = now
+	return p.bearer, nil
+}
+
+func (p *apnsProvider) Send(ctx context.Context, token string, notification PushNotification) error {
+	payload := map[string]interface{}{
+		"aps": map[string]interface{}{
+			"alert": map[string]string{
+				"title": notification.Title,
+				"body":  notification.Body,
+			},
+			"sound": "default",
+		},
+	}
+	for key, value := range pushData(notification) {
+		payload[key] = value
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	bearer, err := p.providerToken(time.Now())
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/3/device/"+token, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "bearer "+bearer)
+	req.Header.Set("apns-topic", p.topic)
+	req.Header.Set("apns-push-type", "alert")
+	req.Header.Set("Content-Type", "application/json")
+
+	// net/http negotiates HTTP/2 over TLS, which APNs requires
+	resp, err := pushHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	var reason struct {
+		Reason string `json:"reason"`
+	}
+	json.NewDecoder(resp.Body).Decode(&reason)
+	if resp.StatusCode == http.StatusGone || reason.Reason == "BadDeviceToken" {
+		return errPushTokenInvalid
+	}
+	return fmt.Errorf("apns responded with status %d: %s", resp.StatusCode, reason.Reason)
+}
+
+// pushData returns the notification's data fields including its type
+func pushData(notification PushNotification) map[string]string {
+	data := make(map[string]string, len(notification.Data)+1)
+	for key, value := range notification.Data {
+		data[key] = value
+	}
+	data["type"] = notification.Type
+	return data
+}
+
+// sendPushToUser delivers a notification to each of the user's devices in the
+// background. Tokens the provider reports as unregistered are removed.
+func sendPushToUser(userID string, notification PushNotification) {
+	if len(pushProviders) == 0 {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), PushDeliveryTimeout)
+		defer cancel()
+
+		cursor, err := db.DeviceTokens.Find(ctx, bson.M{"userId": userID})
+		if err != nil {
+			log.Printf("Push: failed to load devices for %s: %v", userID, err)
+			return
+		}
+		var devices []DeviceToken
+		if err := cursor.All(ctx, &devices); err != nil {
+			log.Printf("Push: failed to load devices for %s: %v", userID, err)
+			return
+		}
+
+		for _, device := range devices {
+			provider, ok := pushProviders[device.Platform]
+			if !ok {
+				continue
+			}
+			err := provider.Send(ctx, device.Token, notification)
+			if errors.Is(err, errPushTokenInvalid) {
+				db.DeviceTokens.DeleteOne(ctx, bson.M{"_id": device.Token})
+				continue
+			}
+			if err != nil {
+				log.Printf("Push: %s delivery to user %s failed: %v", device.Platform, userID, err)
+			}
+		}
+	}()
+}
+
+// registerDeviceHandler stores a push token for the signed-in user. A token
+// already registered to another user moves to the caller.
+func registerDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Platform string `json:"platform"`
+		Token    string `json:"token"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	req.Token = strings.TrimSpace(req.Token)
+	if req.Platform != PushPlatformFCM && req.Platform != PushPlatformAPNs {
+		sendErrorResponse(w, "Platform must be fcm or apns", http.StatusBadRequest)
+		return
+	}
+	if req.Token == "" || len(req.Token) > 4096 {
+		sendErrorResponse(w, "A device token is required", http.StatusBadRequest)
+		return
+	}
+
+	count, err := db.DeviceTokens.CountDocuments(r.Context(), bson.M{"userId": userID, "_id": bson.M{"$ne": req.Token}})
+	if err != nil {
+		sendErrorResponse(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if count >= MaxDevicesPerUser {
+		sendErrorResponse(w, fmt.Sprintf("At most %d devices can be registered", MaxDevicesPerUser), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	var device DeviceToken
+	err = db.DeviceTokens.FindOneAndUpdate(
+		r.Context(),
+		bson.M{"_id": req.Token},
+		bson.M{
+			"$set":         bson.M{"userId": userID, "platform": req.Platform, "updatedAt": now},
+			"$setOnInsert": bson.M{"createdAt": now},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&device)
+	if err != nil {
+		log.Printf("Error registering device: %v", err)
+		sendErrorResponse(w, "Failed to register device", http.StatusInternalServerError)
+		return
+	}
+
+	sendSuccessResponse(w, device)
+}
+
+func getDevicesHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	cursor, err := db.DeviceTokens.Find(r.Context(), bson.M{"userId": userID})
+	if err != nil {
+		sendErrorResponse(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	devices := []DeviceToken{}
+	if err := cursor.All(r.Context(), &devices); err != nil {
+		sendErrorResponse(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	sendSuccessResponse(w, devices)
+}
+
+func unregisterDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	result, err := db.DeviceTokens.DeleteOne(r.Context(), bson.M{"_id": mux.Vars(r)["token"], "userId": userID})
+	if err != nil {
+		sendErrorResponse(w, "Failed to unregister device", http.StatusInternalServerError)
+		return
+	}
+	if result.DeletedCount == 0 {
+		sendErrorResponse(w, "Device not found", http.StatusNotFound)
+		return
+	}
+
+	sendSuccessResponse(w, map[string]string{"message": "Device unregistered"})
+}
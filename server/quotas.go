@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/config"
+	"video-meeting-app/db"
+)
+
+// Quotas named in QuotaExceededError
+const (
+	QuotaMeetingDuration  = "meeting-duration"
+	QuotaParticipants     = "participants"
+	QuotaRecordingMinutes = "recording-minutes"
+)
+
+// QuotaExceededError reports which plan limit an operation would exceed
+type QuotaExceededError struct {
+	Quota string
+	Limit int
+}
+
+func (e *QuotaExceededError) Error() string {
+	switch e.Quota {
+	case QuotaParticipants:
+		return fmt.Sprintf("Your plan allows up to %d participants per meeting", e.Limit)
+	case QuotaRecordingMinutes:
+		return fmt.Sprintf("Your plan allows %d recording minutes per month", e.Limit)
+	default:
+		return fmt.Sprintf("Your plan limit for %s has been reached", e.Quota)
+	}
+}
+
+// QuotaLimits are the effective limits of a user's plan
+type QuotaLimits struct {
+	MaxMeetingMinutes        int `json:"maxMeetingMinutes"`
+	MaxParticipants          int `json:"maxParticipants"`
+	RecordingMinutesPerMonth int `json:"recordingMinutesPerMonth"`
+}
+
+// QuotaUsage is what a user has consumed in the current period
+type QuotaUsage struct {
+	RecordingMinutes int `json:"recordingMinutes" bson:"recordingMinutes"`
+}
+
+// QuotaState is a user's plan, its limits and their usage this month
+type QuotaState struct {
+	UserID      string      `json:"userId"`
+	Plan        string      `json:"plan"`
+	Limits      QuotaLimits `json:"limits"`
+	Usage       QuotaUsage  `json:"usage"`
+	PeriodStart time.Time   `json:"periodStart"`
+	PeriodEnd   time.Time   `json:"periodEnd"`
+}
+
+// planOf returns the user's plan tier; accounts without one are on the free plan
+func planOf(user User) string {
+	if user.Plan == config.PlanPro {
+		return config.PlanPro
+	}
+	return config.PlanFree
+}
+
+func isValidPlan(plan string) bool {
+	return plan == config.PlanFree || plan == config.PlanPro
+}
+
+// usagePeriod returns the calendar month (UTC) containing now and the usage
+// document ID of the user for it
+func usagePeriod(userID string, now time.Time) (start, end time.Time, id string) {
+	now = now.UTC()
+	start = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	end = start.AddDate(0, 1, 0)
+	return start, end, userID + ":" + start.Format("2006-01")
+}
+
+// loadQuotaState looks up the user's plan limits and usage so handlers can
+// check them before expensive operations
+func loadQuotaState(ctx context.Context, userID string, now time.Time) (QuotaState, error) {
+	var user User
+	err := db.Users.FindOne(ctx, bson.M{"_id": userID}, options.FindOne().SetProjection(bson.M{"plan": 1})).Decode(&user)
+	if err != nil {
+		return QuotaState{}, err
+	}
+
+	plan := planOf(user)
+	limits := appConfig.Plans.Limits(plan)
+	start, end, usageID := usagePeriod(userID, now)
+	state := QuotaState{
+		UserID: userID,
+		Plan:   plan,
+		Limits: QuotaLimits{
+			MaxMeetingMinutes: int(time.Duration(limits.MaxMeetingDuration) / time.Minute),
+			// The server-wide cap applies to every plan
+			MaxParticipants:          min(limits.MaxParticipants, appConfig.Meetings.MaxParticipants),
+			RecordingMinutesPerMonth: limits.RecordingMinutesPerMonth,
+		},
+		PeriodStart: start,
+		PeriodEnd:   end,
+	}
+
+	err = db.Usage.FindOne(ctx, bson.M{"_id": usageID}).Decode(&state.Usage)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return QuotaState{}, err
+	}
+	return state, nil
+}
+
+// MaxMeetingDuration is how long a meeting hosted on this plan may run
+func (q QuotaState) MaxMeetingDuration() time.Duration {
+	return time.Duration(q.Limits.MaxMeetingMinutes) * time.Minute
+}
+
+// CheckParticipants reports whether a meeting may hold this many participants
+func (q QuotaState) CheckParticipants(count int) error {
+	if count > q.Limits.MaxParticipants {
+		return &QuotaExceededError{Quota: QuotaParticipants, Limit: q.Limits.MaxParticipants}
+	}
+	return nil
+}
+
+// CheckRecordingMinutes reports whether the user may record this many more
+// minutes this month
+func (q QuotaState) CheckRecordingMinutes(minutes int) error {
+	if q.Usage.RecordingMinutes+minutes > q.Limits.RecordingMinutesPerMonth {
+		return &QuotaExceededError{Quota: QuotaRecordingMinutes, Limit: q.Limits.RecordingMinutesPerMonth}
+	}
+	return nil
+}
+
+// addRecordingUsage adds recorded minutes to the user's usage for the month.
+// The usage event subscriber calls it once a recording is ready.
+func addRecordingUsage(ctx context.Context, userID string, minutes int, now time.Time) error {
+	start, _, usageID := usagePeriod(userID, now)
+	_, err := db.Usage.UpdateOne(
+		ctx,
+		bson.M{"_id": usageID},
+		bson.M{
+			"$inc":         bson.M{"recordingMinutes": minutes},
+			"$set":         bson.M{"updatedAt": now},
+			"$setOnInsert": bson.M{"userId": userID, "periodStart": start},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// startMeetingClock records when a meeting first started and when the host's
+// plan requires it to end. Later starts keep the original deadline.
+func startMeetingClock(ctx context.Context, meeting Meeting, now time.Time) {
+	quota, err := loadQuotaState(ctx, meeting.CreatedBy, now)
+	if err != nil {
+		log.Printf("Error loading quota for meeting %s host: %v", meeting.ID, err)
+		return
+	}
+
+	_, err = db.Meetings.UpdateOne(
+		ctx,
+		bson.M{"_id": meeting.ID, "startedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"startedAt": now, "endsAt": now.Add(quota.MaxMeetingDuration())}},
+	)
+	if err != nil {
+		log.Printf("Error starting clock for meeting %s: %v", meeting.ID, err)
+	}
+	meetingLookups.Invalidate(ctx, meeting.ID)
+}
+
+// endMeetingsPastTimeLimit ends active meetings that have run past the
+// duration allowed by their host's plan
+func endMeetingsPastTimeLimit(ctx context.Context, now time.Time) (int64, error) {
+	cursor, err := db.Meetings.Find(ctx, bson.M{
+		"isActive": true,
+		"endsAt":   bson.M{"$lte": now},
+	})
+	if err != nil {
+		return 0, err
+	}
+	var meetings []Meeting
+	if err := cursor.All(ctx, &meetings); err != nil {
+		return 0, err
+	}
+
+	var ended int64
+	for _, meeting := range meetings {
+		ok, err := finishMeeting(ctx, meeting, "", map[string]interface{}{
+			"endedAt": now,
+			"reason":  QuotaMeetingDuration,
+		}, now)
+		if err != nil {
+			return ended, err
+		}
+		if ok {
+			ended++
+		}
+	}
+	return ended, nil
+}
+
+// getQuotaHandler returns the caller's plan, limits and usage
+func getQuotaHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	quota, err := loadQuotaState(r.Context(), userID, time.Now())
+	if err != nil {
+		sendErrorResponse(w, "Failed to load quota", http.StatusInternalServerError)
+		return
+	}
+	sendSuccessResponse(w, quota)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const RateLimitEvictInterval = 1 * time.Minute
+
+// RateLimitPolicy is a token bucket refilled at RequestsPerMinute that holds
+// at most Burst tokens
+type RateLimitPolicy struct {
+	Name              string
+	RequestsPerMinute int
+	Burst             int
+	// PerUser keys the bucket by the signed-in user, falling back to the
+	// client IP for anonymous requests
+	PerUser bool
+}
+
+// clientNetwork returns what per-address limits count a client address
+// as. IPv6 clients are usually handed a whole /64 and can pick a fresh
+// address from it for each request, so the /64 counts as one address.
+func clientNetwork(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || !addr.Is6() {
+		return ip
+	}
+	return netip.PrefixFrom(addr, 64).Masked().String()
+}
+
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// RateLimiter tracks token buckets per policy and client; safe for concurrent use
+type RateLimiter struct {
+	mu            sync.Mutex
+	buckets       map[string]*tokenBucket
+	defaultPolicy RateLimitPolicy
+	routePolicies map[string]RateLimitPolicy // route template -> policy
+	evictAfter    time.Duration
+}
+
+func newRateLimiter(defaultPolicy RateLimitPolicy, evictAfter time.Duration) *RateLimiter {
+	return &RateLimiter{
+		buckets:       make(map[string]*tokenBucket),
+		defaultPolicy: defaultPolicy,
+		routePolicies: make(map[string]RateLimitPolicy),
+		evictAfter:    evictAfter,
+	}
+}
+
+// SetRoutePolicy overrides the default policy for one route template,
+// e.g. "/api/auth/login"
+func (l *RateLimiter) SetRoutePolicy(template string, policy RateLimitPolicy) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.routePolicies[template] = policy
+}
+
+// policyFor returns the policy of the matched route
+func (l *RateLimiter) policyFor(r *http.Request) RateLimitPolicy {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			if policy, ok := l.routePolicies[template]; ok {
+				return policy
+			}
+		}
+	}
+	return l.defaultPolicy
+}
+
+// Allow takes a token from the key's bucket. It returns whether the request
+// may proceed, the tokens left and how long until the bucket is full again
+// (or, when denied, until the next token is available).
+func (l *RateLimiter) Allow(policy RateLimitPolicy, key string, now time.Time) (bool, int, time.Duration) {
+	ratePerSecond := float64(policy.RequestsPerMinute) / 60
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucketKey := policy.Name + "|" + key
+	bucket, ok := l.buckets[bucketKey]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(policy.Burst), lastSeen: now}
+		l.buckets[bucketKey] = bucket
+	}
+
+	elapsed := now.Sub(bucket.lastSeen).Seconds()
+	bucket.tokens = math.Min(float64(policy.Burst), bucket.tokens+elapsed*ratePerSecond)
+	bucket.lastSeen = now
+
+	if bucket.tokens < 1 {
+		wait := time.Duration((1 - bucket.tokens) / ratePerSecond * float64(time.Second))
+		return false, 0, wait
+	}
+	bucket.tokens--
+	untilFull := time.Duration((float64(policy.Burst) - bucket.tokens) / ratePerSecond * float64(time.Second))
+	return true, int(bucket.tokens), untilFull
+}
+
+// evict drops buckets idle for longer than evictAfter
+func (l *RateLimiter) evict(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.lastSeen) > l.evictAfter {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// StartEviction periodically evicts idle buckets until ctx is cancelled
+func (l *RateLimiter) StartEviction(ctx context.Context) {
+	ticker := time.NewTicker(RateLimitEvictInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			l.evict(now)
+		}
+	}
+}
+
+// rateLimitMiddleware enforces the limiter's policies and reports the client's
+// budget in X-RateLimit-* headers
+func rateLimitMiddleware(limiter *RateLimiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// API keys have budgets of their own, enforced by apiKeyMiddleware
+			if requestAPIKey(r) != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			policy := limiter.policyFor(r)
+
+			key := "ip:" + clientNetwork(getClientIP(r))
+			if policy.PerUser {
+				if userID := getUserIDFromToken(r); userID != "" {
+					key = "user:" + userID
+				}
+			}
+
+			allowed, remaining, reset := limiter.Allow(policy, key, time.Now())
+			resetSeconds := strconv.Itoa(int(math.Ceil(reset.Seconds())))
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(policy.Burst))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+			w.Header().Set("X-RateLimit-Reset", resetSeconds)
+			if !allowed {
+				w.Header().Set("Retry-After", resetSeconds)
+				sendCodedError(w, ErrCodeRateLimited, "Rate limit exceeded")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+)
+
+// Every join updates the user's history entry for the meeting. The recent
+// meetings listing returns the entries newest first; meetings still running
+// come with a short-lived rejoin token, which lets the user join again
+// under the name they last used without retyping it.
+
+const (
+	DefaultRecentMeetings = 10
+	MaxRecentMeetings     = 50
+	RejoinTokenTTL        = 15 * time.Minute
+)
+
+// MeetingHistoryEntry records a user's participation in a meeting
+type MeetingHistoryEntry struct {
+	ID            string    `json:"-" bson:"_id"`
+	UserID        string    `json:"userId" bson:"userId"`
+	MeetingID     string    `json:"meetingId" bson:"meetingId"`
+	FirstJoinedAt time.Time `json:"firstJoinedAt" bson:"firstJoinedAt"`
+	LastJoinedAt  time.Time `json:"lastJoinedAt" bson:"lastJoinedAt"`
+	JoinCount     int       `json:"joinCount" bson:"joinCount"`
+}
+
+// RecentMeeting is a meeting in the caller's recent meetings listing
+type RecentMeeting struct {
+	MeetingID     string    `json:"meetingId"`
+	Code          string    `json:"code,omitempty"`
+	Title         string    `json:"title"`
+	IsActive      bool      `json:"isActive"`
+	IsHost        bool      `json:"isHost"`
+	FirstJoinedAt time.Time `json:"firstJoinedAt"`
+	LastJoinedAt  time.Time `json:"lastJoinedAt"`
+	JoinCount     int       `json:"joinCount"`
+	// Set while the meeting is running
+	RejoinToken     string     `json:"rejoinToken,omitempty"`
+	RejoinURL       string     `json:"rejoinUrl,omitempty"`
+	RejoinExpiresAt *time.Time `json:"rejoinExpiresAt,omitempty"`
+}
+
+// recordMeetingHistory counts a join in the user's history; failures are
+// logged only
+func recordMeetingHistory(ctx context.Context, meetingID, userID string, at time.Time) {
+	_, err := db.MeetingHistory.UpdateOne(ctx,
+		bson.M{"userId": userID, "meetingId": meetingID},
+		bson.M{
+			"$max":         bson.M{"lastJoinedAt": at},
+			"$min":         bson.M{"firstJoinedAt": at},
+			"$inc":         bson.M{"joinCount": 1},
+			"$setOnInsert": bson.M{"_id": uuid.New().String()},
+		},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		log.Printf("Error recording meeting history for %s in meeting %s: %v", userID, meetingID, err)
+	}
+}
+
+// rejoinToken returns a token letting the user rejoin the meeting until expiresAt
+func rejoinToken(meetingID, userID string, expiresAt time.Time) string {
+	payload := meetingID + "." + userID + "." + strconv.FormatInt(expiresAt.Unix(), 10)
+	return payload + "." + signValue("rejoin."+payload)
+}
+
+// parseRejoinToken verifies a token's signature and expiry and returns the
+// meeting and user it was issued for
+func parseRejoinToken(token string) (meetingID, userID string, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 4 {
+		return "", "", fmt.Errorf("malformed rejoin token")
+	}
+	payload := strings.Join(parts[:3], ".")
+	if !verifySignature("rejoin."+payload, parts[3]) {
+		return "", "", fmt.Errorf("invalid rejoin token signature")
+	}
+	expiresAt, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return "", "", fmt.Errorf("malformed rejoin token")
+	}
+	if time.Now().Unix() > expiresAt {
+		return "", "", fmt.Errorf("rejoin token has expired")
+	}
+	return parts[0], parts[1], nil
+}
+
+// lastParticipantName returns the name the user last joined the meeting under
+func lastParticipantName(ctx context.Context, meetingID, userID string) (string, error) {
+	var participant Participant
+	err := db.Participants.FindOne(ctx, bson.M{"meetingId": meetingID, "userId": userID},
+		options.FindOne().SetSort(bson.D{{Key: "joinedAt", Value: -1}})).Decode(&participant)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", nil
+	}
+	return participant.UserName, err
+}
+
+// loadHistoryMeetings loads the meetings of the history entries, archived
+// ones included; meetings since deleted are left out
+func loadHistoryMeetings(ctx context.Context, entries []MeetingHistoryEntry) (map[string]Meeting, error) {
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		ids = append(ids, entry.MeetingID)
+	}
+
+	var meetings []Meeting
+	if err := findAll(ctx, db.Meetings, bson.M{"_id": bson.M{"$in": ids}}, &meetings); err != nil {
+		return nil, err
+	}
+	var archived []ArchivedMeeting
+	if err := findAll(ctx, db.MeetingsArchive, bson.M{"_id": bson.M{"$in": ids}}, &archived); err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]Meeting, len(meetings)+len(archived))
+	for _, entry := range archived {
+		byID[entry.ID] = entry.Meeting
+	}
+	for _, meeting := range meetings {
+		byID[meeting.ID] = meeting
+	}
+	return byID, nil
+}
+
+// getRecentMeetingsHandler lists the meetings the caller joined, most
+// recently joined first
+func getRecentMeetingsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	limit := DefaultRecentMeetings
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			sendErrorResponse(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(parsed, MaxRecentMeetings)
+	}
+
+	cursor, err := db.MeetingHistory.Find(r.Context(), bson.M{"userId": userID}, options.Find().
+		SetSort(bson.D{{Key: "lastJoinedAt", Value: -1}}).
+		SetLimit(int64(limit)))
+	if err != nil {
+		sendErrorResponse(w, "Failed to fetch recent meetings", http.StatusInternalServerError)
+		return
+	}
+	var entries []MeetingHistoryEntry
+	if err := cursor.All(r.Context(), &entries); err != nil {
+		sendErrorResponse(w, "Failed to parse recent meetings", http.StatusInternalServerError)
+		return
+	}
+	meetings, err := loadHistoryMeetings(r.Context(), entries)
+	if err != nil {
+		sendErrorResponse(w, "Failed to fetch recent meetings", http.StatusInternalServerError)
+		return
+	}
+
+	expiresAt := time.Now().Add(RejoinTokenTTL)
+	recent := make([]RecentMeeting, 0, len(entries))
+	for _, entry := range entries {
+		meeting, ok := meetings[entry.MeetingID]
+		if !ok {
+			continue
+		}
+		item := RecentMeeting{
+			MeetingID:     meeting.ID,
+			Code:          meeting.Code,
+			Title:         meeting.Title,
+			IsActive:      meeting.IsActive,
+			IsHost:        meeting.CreatedBy == userID,
+			FirstJoinedAt: entry.FirstJoinedAt,
+			LastJoinedAt:  entry.LastJoinedAt,
+			JoinCount:     entry.JoinCount,
+		}
+		if meeting.IsActive {
+			item.RejoinToken = rejoinToken(meeting.ID, userID, expiresAt)
+			item.RejoinURL = meetingJoinURL(meeting) + "?rejoin=" + url.QueryEscape(item.RejoinToken)
+			item.RejoinExpiresAt = &expiresAt
+		}
+		recent = append(recent, item)
+	}
+
+	sendSuccessResponse(w, map[string]interface{}{"meetings": recent})
+}
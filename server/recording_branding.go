@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+	"video-meeting-app/storage"
+)
+
+// Organizations can brand their meetings' recordings with a logo, a
+// watermark and participant name labels. The compositor burns the overlay
+// into the recording; it is resolved when a recording starts and kept with
+// the session, so changing the branding does not affect recordings already
+// in progress.
+
+const (
+	MaxLogoUploadSize = 2 << 20
+	// Logos are overlaid in a corner of the recording, so they are kept small
+	MaxLogoDimension       = 1024
+	MaxWatermarkTextLength = 100
+)
+
+// Overlay positions
+const (
+	OverlayTopLeft     = "top-left"
+	OverlayTopRight    = "top-right"
+	OverlayBottomLeft  = "bottom-left"
+	OverlayBottomRight = "bottom-right"
+)
+
+// Logo files are named by content hash, like avatars
+var logoFilePattern = regexp.MustCompile(`^[0-9a-f]{16}\.png$`)
+
+func isValidOverlayPosition(position string) bool {
+	switch position {
+	case OverlayTopLeft, OverlayTopRight, OverlayBottomLeft, OverlayBottomRight:
+		return true
+	}
+	return false
+}
+
+// RecordingBrandingSettings are an organization's recording overlay settings
+type RecordingBrandingSettings struct {
+	WatermarkText string `json:"watermarkText,omitempty" bson:"watermarkText,omitempty"`
+	// Where the logo and watermark go, bottom-right by default
+	Position         string `json:"position,omitempty" bson:"position,omitempty"`
+	ParticipantNames bool   `json:"participantNames,omitempty" bson:"participantNames,omitempty"`
+}
+
+func validateRecordingBranding(settings *RecordingBrandingSettings) error {
+	if settings == nil {
+		return nil
+	}
+	if len([]rune(settings.WatermarkText)) > MaxWatermarkTextLength {
+		return fmt.Errorf("watermarkText must be at most %d characters", MaxWatermarkTextLength)
+	}
+	if settings.Position != "" && !isValidOverlayPosition(settings.Position) {
+		return fmt.Errorf("position must be top-left, top-right, bottom-left or bottom-right")
+	}
+	return nil
+}
+
+// RecordingOverlay is what the compositor draws over a recording
+type RecordingOverlay struct {
+	LogoURL          string `json:"logoUrl,omitempty" bson:"logoUrl,omitempty"`
+	WatermarkText    string `json:"watermarkText,omitempty" bson:"watermarkText,omitempty"`
+	Position         string `json:"position" bson:"position"`
+	ParticipantNames bool   `json:"participantNames" bson:"participantNames"`
+}
+
+// recordingOverlay returns the overlay of the organization's recordings, or
+// nil when the organization has no branding
+func recordingOverlay(ctx context.Context, orgID string) *RecordingOverlay {
+	if orgID == "" {
+		return nil
+	}
+	var org Organization
+	err := db.Organizations.FindOne(ctx, bson.M{"_id": orgID},
+		options.FindOne().SetProjection(bson.M{"logoUrl": 1, "settings.recordingBranding": 1})).Decode(&org)
+	if err != nil {
+		return nil
+	}
+
+	overlay := RecordingOverlay{LogoURL: org.LogoURL, Position: OverlayBottomRight}
+	if branding := org.Settings.RecordingBranding; branding != nil {
+		overlay.WatermarkText = branding.WatermarkText
+		overlay.ParticipantNames = branding.ParticipantNames
+		if branding.Position != "" {
+			overlay.Position = branding.Position
+		}
+	}
+	if overlay.LogoURL == "" && overlay.WatermarkText == "" && !overlay.ParticipantNames {
+		return nil
+	}
+	return &overlay
+}
+
+// processLogo checks an uploaded logo and re-encodes it as PNG, keeping its
+// transparency
+func processLogo(data []byte) ([]byte, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("unsupported image format")
+	}
+	if cfg.Width > MaxLogoDimension || cfg.Height > MaxLogoDimension {
+		return nil, fmt.Errorf("logo must be at most %dx%d pixels", MaxLogoDimension, MaxLogoDimension)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("image could not be decoded")
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// uploadOrgLogoHandler accepts a multipart "logo" file from an owner or admin
+// and makes it the organization's recording logo
+func uploadOrgLogoHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	org, _, ok := requireOrgRole(w, r, mux.Vars(r)["id"], userID, OrgRoleOwner, OrgRoleAdmin)
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, MaxLogoUploadSize+1024)
+	file, _, err := r.FormFile("logo")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			sendErrorResponse(w, "Logo must be 2 MB or smaller", http.StatusRequestEntityTooLarge)
+		} else {
+			sendErrorResponse(w, "A logo file is required", http.StatusBadRequest)
+		}
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, MaxLogoUploadSize+1))
+	if err != nil {
+		sendErrorResponse(w, "Failed to read upload", http.StatusBadRequest)
+		return
+	}
+	if len(data) > MaxLogoUploadSize {
+		sendErrorResponse(w, "Logo must be 2 MB or smaller", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	logo, err := processLogo(data)
+	if err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sum := sha256.Sum256(logo)
+	fileName := hex.EncodeToString(sum[:8]) + ".png"
+	key := "logos/" + org.ID + "/" + fileName
+	if err := blobStore.Put(r.Context(), key, "image/png", logo); err != nil {
+		log.Printf("Error storing logo for organization %s: %v", org.ID, err)
+		sendErrorResponse(w, "Failed to store logo", http.StatusInternalServerError)
+		return
+	}
+
+	logoURL := "/api/logos/" + org.ID + "/" + fileName
+	_, err = db.Organizations.UpdateOne(
+		r.Context(),
+		bson.M{"_id": org.ID},
+		bson.M{"$set": bson.M{"logoUrl": logoURL, "logoKey": key, "updatedAt": time.Now()}},
+	)
+	if err != nil {
+		log.Printf("Error updating logo for organization %s: %v", org.ID, err)
+		sendErrorResponse(w, "Failed to update logo", http.StatusInternalServerError)
+		return
+	}
+
+	// Recordings in progress keep the URL they started with, so the old
+	// image is only dropped from the organization, not from storage
+	sendSuccessResponse(w, map[string]string{"logoUrl": logoURL})
+}
+
+// deleteOrgLogoHandler removes the organization's recording logo
+func deleteOrgLogoHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	org, _, ok := requireOrgRole(w, r, mux.Vars(r)["id"], userID, OrgRoleOwner, OrgRoleAdmin)
+	if !ok {
+		return
+	}
+
+	_, err := db.Organizations.UpdateOne(
+		r.Context(),
+		bson.M{"_id": org.ID},
+		bson.M{
+			"$unset": bson.M{"logoUrl": "", "logoKey": ""},
+			"$set":   bson.M{"updatedAt": time.Now()},
+		},
+	)
+	if err != nil {
+		sendErrorResponse(w, "Failed to remove logo", http.StatusInternalServerError)
+		return
+	}
+
+	sendSuccessResponse(w, map[string]string{"message": "Logo removed"})
+}
+
+// serveOrgLogoHandler serves a stored logo. The compositor loads it without
+// credentials, and URLs are content-addressed, so responses may be cached
+// indefinitely.
+func serveOrgLogoHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !logoFilePattern.MatchString(vars["file"]) {
+		http.NotFound(w, r)
+		return
+	}
+
+	etag := `"` + vars["file"] + `"`
+	cacheControl := fmt.Sprintf("public, max-age=%d, immutable", int(AvatarCacheMaxAge.Seconds()))
+	if r.Header.Get("If-None-Match") == etag {
+		w.Header().Set("Cache-Control", cacheControl)
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	reader, info, err := blobStore.Get(r.Context(), "logos/"+vars["orgId"]+"/"+vars["file"])
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Error loading logo: %v", err)
+		}
+		http.NotFound(w, r)
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	io.Copy(w, reader)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+)
+
+// vttEscaper escapes cue text; escaping ">" also keeps "-->" out of it
+var vttEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\n", " ")
+
+// vttTimestamp formats an offset into the recording as hh:mm:ss.ttt
+func vttTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// writeRecordingCaptions writes the utterances as WebVTT cues timed from the
+// start of the recording, clipped to its length when that is known
+func writeRecordingCaptions(w http.ResponseWriter, recording Recording, utterances []TranscriptUtterance) {
+	length := time.Duration(recording.DurationSeconds) * time.Second
+
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	for _, u := range utterances {
+		start := max(u.StartedAt.Sub(recording.StartedAt), 0)
+		end := u.EndedAt.Sub(recording.StartedAt)
+		if length > 0 {
+			end = min(end, length)
+		}
+		if end <= start || strings.TrimSpace(u.Text) == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "\n%s --> %s\n<v %s>%s\n",
+			vttTimestamp(start), vttTimestamp(end), vttEscaper.Replace(u.UserName), vttEscaper.Replace(u.Text))
+	}
+
+	w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(b.String()))
+}
+
+// recordingCaptionsHandler serves the meeting's transcript as WebVTT
+// subtitles for the recording. Like the stream, it accepts a signed ?token=,
+// as <track> elements cannot send credentials of their own, or a host's or
+// attendee's session.
+func recordingCaptionsHandler(w http.ResponseWriter, r *http.Request) {
+	recording, ok := authorizeRecordingMedia(w, r)
+	if !ok {
+		return
+	}
+
+	filter := bson.M{"meetingId": recording.MeetingID, "endedAt": bson.M{"$gt": recording.StartedAt}}
+	if recording.DurationSeconds > 0 {
+		filter["startedAt"] = bson.M{"$lt": recording.StartedAt.Add(time.Duration(recording.DurationSeconds) * time.Second)}
+	}
+	cursor, err := db.TranscriptUtterances.Find(r.Context(), filter,
+		options.Find().SetSort(bson.D{{Key: "startedAt", Value: 1}}))
+	if err != nil {
+		sendErrorResponse(w, "Failed to fetch transcript", http.StatusInternalServerError)
+		return
+	}
+	var utterances []TranscriptUtterance
+	if err := cursor.All(r.Context(), &utterances); err != nil {
+		sendErrorResponse(w, "Failed to parse transcript", http.StatusInternalServerError)
+		return
+	}
+
+	writeRecordingCaptions(w, recording, utterances)
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+	"video-meeting-app/storage"
+)
+
+// Every stored recording gets a poster frame and a short muted preview clip,
+// for recording lists and hover previews. A background job looks for
+// recordings without them, whatever stored the recording, and generates them
+// with ffmpeg. Recordings are announced as ready when stored, so they are
+// whether or not previews are generated.
+
+const (
+	// A recording is leased while its previews are generated so instances
+	// don't generate them twice; a crashed worker's lease runs out
+	RecordingPreviewLease = 10 * time.Minute
+	// Recordings whose previews fail this many times are left without
+	MaxRecordingPreviewAttempts = 3
+	recordingPreviewTimeout     = 5 * time.Minute
+	PosterWidth                 = 640
+	PreviewWidth                = 480
+)
+
+// Recording preview statuses; recordings awaiting previews have none
+const (
+	PreviewStatusReady  = "ready"
+	PreviewStatusFailed = "failed"
+)
+
+// startRecordingPreviewJob generates missing recording previews until ctx is
+// cancelled. It does nothing unless ffmpeg is configured.
+func startRecordingPreviewJob(ctx context.Context) {
+	cfg := appConfig.Recordings
+	if cfg.FFmpegPath == "" {
+		return
+	}
+	log.Printf("Recording preview job started (ffmpeg %s)", cfg.FFmpegPath)
+
+	ticker := time.NewTicker(time.Duration(cfg.PreviewInterval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			generateDueRecordingPreviews(ctx)
+		}
+	}
+}
+
+// generateDueRecordingPreviews generates the previews of every recording
+// still missing them, leasing each one first
+func generateDueRecordingPreviews(ctx context.Context) {
+	for ctx.Err() == nil {
+		now := time.Now()
+		var recording Recording
+		err := db.Recordings.FindOneAndUpdate(
+			ctx,
+			bson.M{
+				"previewStatus": bson.M{"$exists": false},
+				"$or": []bson.M{
+					{"previewLeaseUntil": bson.M{"$exists": false}},
+					{"previewLeaseUntil": bson.M{"$lte": now}},
+				},
+			},
+			bson.M{
+				"$set": bson.M{"previewLeaseUntil": now.Add(RecordingPreviewLease)},
+				"$inc": bson.M{"previewAttempts": 1},
+			},
+			options.FindOneAndUpdate().
+				SetSort(bson.D{{Key: "createdAt", Value: 1}}).
+				SetReturnDocument(options.After),
+		).Decode(&recording)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return
+		}
+		if err != nil {
+			log.Printf("Recording previews: failed to claim recording: %v", err)
+			return
+		}
+
+		update := bson.M{"$unset": bson.M{"previewLeaseUntil": ""}}
+		posterKey, previewKey, err := generateRecordingPreviews(ctx, recording)
+		switch {
+		case err == nil:
+			update["$set"] = bson.M{"previewStatus": PreviewStatusReady, "posterKey": posterKey, "previewKey": previewKey}
+		case recording.PreviewAttempts >= MaxRecordingPreviewAttempts:
+			log.Printf("Recording previews: giving up on recording %s: %v", recording.ID, err)
+			update["$set"] = bson.M{"previewStatus": PreviewStatusFailed}
+		default:
+			log.Printf("Recording previews: error generating previews of recording %s: %v", recording.ID, err)
+		}
+		if _, err := db.Recordings.UpdateOne(ctx, bson.M{"_id": recording.ID}, update); err != nil {
+			log.Printf("Recording previews: error updating recording %s: %v", recording.ID, err)
+		}
+	}
+}
+
+// generateRecordingPreviews extracts the recording's poster frame and
+// preview clip and stores them, returning their keys
+func generateRecordingPreviews(ctx context.Context, recording Recording) (string, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, recordingPreviewTimeout)
+	defer cancel()
+
+	dir, err := os.MkdirTemp("", "recording-preview-")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "source")
+	if err := copyBlobToFile(ctx, recording.Key, source); err != nil {
+		return "", "", err
+	}
+
+	offset := time.Duration(appConfig.Recordings.PreviewOffset)
+	length := time.Duration(appConfig.Recordings.PreviewLength)
+	if duration := time.Duration(recording.DurationSeconds) * time.Second; duration > 0 && offset >= duration {
+		offset = duration / 2
+	}
+	at := strconv.FormatFloat(offset.Seconds(), 'f', 3, 64)
+
+	poster := filepath.Join(dir, "poster.jpg")
+	if err := runFFmpeg(ctx, "-ss", at, "-i", source,
+		"-frames:v", "1", "-vf", fmt.Sprintf("scale=%d:-2", PosterWidth), poster); err != nil {
+		return "", "", fmt.Errorf("poster: %w", err)
+	}
+	preview := filepath.Join(dir, "preview.mp4")
+	if err := runFFmpeg(ctx, "-ss", at, "-i", source,
+		"-t", strconv.FormatFloat(length.Seconds(), 'f', 3, 64),
+		"-vf", fmt.Sprintf("scale=%d:-2", PreviewWidth), "-an",
+		"-c:v", "libx264", "-preset", "veryfast", "-pix_fmt", "yuv420p", "-movflags", "+faststart",
+		preview); err != nil {
+		return "", "", fmt.Errorf("preview: %w", err)
+	}
+
+	posterKey := "recording-previews/" + recording.ID + "/poster.jpg"
+	previewKey := "recording-previews/" + recording.ID + "/preview.mp4"
+	if err := putFile(ctx, posterKey, "image/jpeg", poster); err != nil {
+		return "", "", err
+	}
+	if err := putFile(ctx, previewKey, "video/mp4", preview); err != nil {
+		return "", "", err
+	}
+	return posterKey, previewKey, nil
+}
+
+// runFFmpeg runs ffmpeg with args, returning the end of its output on failure
+func runFFmpeg(ctx context.Context, args ...string) error {
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, appConfig.Recordings.FFmpegPath,
+		append([]string{"-hide_banner", "-loglevel", "error", "-y"}, args...)...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		message := output.Bytes()
+		if len(message) > 500 {
+			message = message[len(message)-500:]
+		}
+		return fmt.Errorf("%w: %s", err, bytes.TrimSpace(message))
+	}
+	return nil
+}
+
+// copyBlobToFile writes a stored object to a local file
+func copyBlobToFile(ctx context.Context, key, path string) error {
+	object, _, err := blobStore.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, object); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// putFile stores a local file under key
+func putFile(ctx context.Context, key, contentType, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return blobStore.Put(ctx, key, contentType, data)
+}
+
+// deleteRecordingPreviews deletes the recording's stored poster and preview
+func deleteRecordingPreviews(ctx context.Context, recording Recording) {
+	for _, key := range []string{recording.PosterKey, recording.PreviewKey} {
+		if key == "" {
+			continue
+		}
+		if err := blobStore.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Error deleting recording preview %s: %v", key, err)
+		}
+	}
+}
+
+// recordingPreviewMediaHandler serves a recording's poster or preview clip.
+// Like the stream, it accepts a signed ?token= or a host's or attendee's
+// session.
+func recordingPreviewMediaHandler(w http.ResponseWriter, r *http.Request) {
+	recording, ok := authorizeRecordingMedia(w, r)
+	if !ok {
+		return
+	}
+
+	key, contentType := recording.PosterKey, "image/jpeg"
+	if mux.Vars(r)["media"] == "preview" {
+		key, contentType = recording.PreviewKey, "video/mp4"
+	}
+	if key == "" {
+		sendErrorResponse(w, "Recording preview not available", http.StatusNotFound)
+		return
+	}
+
+	object, info, err := blobStore.Get(r.Context(), key)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Error loading recording preview %s: %v", key, err)
+		}
+		sendErrorResponse(w, "Recording preview not found", http.StatusNotFound)
+		return
+	}
+	defer object.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	http.ServeContent(w, r, "", info.ModTime, object)
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/config"
+	"video-meeting-app/db"
+)
+
+// Recordings are composited by the recording client. The server tracks the
+// meeting's active recording and its layout, and tells the compositor when
+// the layout changes; the changes are kept so the recording can be
+// post-processed with the same layouts. Stopping a recording hands the
+// compositor a signed upload token, which it sends along with the finished
+// file to store it as the meeting's recording.
+
+// Recording layouts
+const (
+	RecordingLayoutGrid          = "grid"
+	RecordingLayoutActiveSpeaker = "active-speaker"
+	RecordingLayoutScreenShare   = "screen-share" // the shared screen with participant thumbnails
+)
+
+func isValidRecordingLayout(layout string) bool {
+	switch layout {
+	case RecordingLayoutGrid, RecordingLayoutActiveSpeaker, RecordingLayoutScreenShare:
+		return true
+	}
+	return false
+}
+
+// RecordingLayoutChange is a switch of the recording's layout
+type RecordingLayoutChange struct {
+	Layout    string    `json:"layout" bson:"layout"`
+	ChangedBy string    `json:"changedBy" bson:"changedBy"`
+	At        time.Time `json:"at" bson:"at"`
+}
+
+// RecordingSession is a meeting's active recording
+type RecordingSession struct {
+	StartedBy string                  `json:"startedBy" bson:"startedBy"`
+	StartedAt time.Time               `json:"startedAt" bson:"startedAt"`
+	Layout    string                  `json:"layout" bson:"layout"`
+	Layouts   []RecordingLayoutChange `json:"layouts" bson:"layouts"` // every layout used, in order
+	// The organization's branding, fixed when the recording starts
+	Overlay *RecordingOverlay `json:"overlay,omitempty" bson:"overlay,omitempty"`
+}
+
+// StoppedRecording is a stopped recording, with the token its file is
+// uploaded with
+type StoppedRecording struct {
+	RecordingSession
+	StoppedAt       time.Time `json:"stoppedAt"`
+	UploadToken     string    `json:"uploadToken"`
+	UploadExpiresAt time.Time `json:"uploadExpiresAt"`
+}
+
+// recordingUpload is what an upload token vouches for: who recorded the
+// meeting and when
+type recordingUpload struct {
+	MeetingID string
+	UserID    string
+	StartedAt time.Time
+	StoppedAt time.Time
+}
+
+// recordingUploadToken returns a token letting the recorder upload the
+// recording's file until expiresAt
+func recordingUploadToken(upload recordingUpload, expiresAt time.Time) string {
+	payload := strings.Join([]string{
+		upload.MeetingID,
+		upload.UserID,
+		strconv.FormatInt(upload.StartedAt.UnixMilli(), 10),
+		strconv.FormatInt(upload.StoppedAt.UnixMilli(), 10),
+		strconv.FormatInt(expiresAt.Unix(), 10),
+	}, ".")
+	return payload + "." + signValue("recording-upload."+payload)
+}
+
+// parseRecordingUploadToken verifies a token's signature and expiry. The
+// returned ID is the same for every use of the token, so a recording is
+// stored once.
+func parseRecordingUploadToken(token string) (recordingUpload, string, error) {
+	var upload recordingUpload
+	parts := strings.Split(token, ".")
+	if len(parts) != 6 {
+		return upload, "", fmt.Errorf("malformed upload token")
+	}
+	payload := strings.Join(parts[:5], ".")
+	if !verifySignature("recording-upload."+payload, parts[5]) {
+		return upload, "", fmt.Errorf("invalid upload token signature")
+	}
+	startedAt, err1 := strconv.ParseInt(parts[2], 10, 64)
+	stoppedAt, err2 := strconv.ParseInt(parts[3], 10, 64)
+	expiresAt, err3 := strconv.ParseInt(parts[4], 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return upload, "", fmt.Errorf("malformed upload token")
+	}
+	if time.Now().Unix() > expiresAt {
+		return upload, "", fmt.Errorf("upload token has expired")
+	}
+	upload = recordingUpload{
+		MeetingID: parts[0],
+		UserID:    parts[1],
+		StartedAt: time.UnixMilli(startedAt),
+		StoppedAt: time.UnixMilli(stoppedAt),
+	}
+	return upload, uuid.NewSHA1(uuid.NameSpaceURL, []byte(payload)).String(), nil
+}
+
+type recordingLayoutRequest struct {
+	Layout string `json:"layout"`
+}
+
+// canRecord applies the meeting's recording policy to the user
+func canRecord(ctx context.Context, meeting Meeting, userID string) bool {
+	policy := meeting.RecordingPolicy
+	if policy == "" {
+		policy = appConfig.Meetings.RecordingPolicy
+	}
+	switch policy {
+	case config.RecordingPolicyHostOnly:
+		return meeting.CreatedBy == userID
+	case config.RecordingPolicyAnyone:
+		return meeting.CreatedBy == userID || isParticipant(ctx, meeting.ID, userID)
+	}
+	return false
+}
+
+// startRecordingHandler starts recording the meeting with the layout in the
+// body, grid by default
+func startRecordingHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req recordingLayoutRequest
+	if r.ContentLength != 0 && !decodeStrictJSONBody(w, r, &req) {
+		return
+	}
+	if req.Layout == "" {
+		req.Layout = RecordingLayoutGrid
+	}
+	if !isValidRecordingLayout(req.Layout) {
+		sendErrorResponse(w, "layout must be grid, active-speaker or screen-share", http.StatusBadRequest)
+		return
+	}
+
+	meeting, err := findMeeting(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+	if !meeting.IsActive {
+		sendCodedError(w, ErrCodeMeetingEnded, "Meeting has ended")
+		return
+	}
+	if !canRecord(r.Context(), meeting, userID) {
+		sendCodedError(w, ErrCodeForbidden, "The meeting's recording policy does not allow you to record")
+		return
+	}
+
+	now := time.Now()
+	session := RecordingSession{
+		StartedBy: userID,
+		StartedAt: now,
+		Layout:    req.Layout,
+		Layouts:   []RecordingLayoutChange{{Layout: req.Layout, ChangedBy: userID, At: now}},
+		Overlay:   recordingOverlay(r.Context(), meeting.OrgID),
+	}
+	result, err := db.Meetings.UpdateOne(r.Context(),
+		bson.M{"_id": meeting.ID, "isActive": true, "recording": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"recording": session, "updatedAt": now}},
+	)
+	if err != nil {
+		log.Printf("Error starting recording: %v", err)
+		sendErrorResponse(w, "Failed to start recording", http.StatusInternalServerError)
+		return
+	}
+	if result.MatchedCount == 0 {
+		sendCodedError(w, ErrCodeConflict, "The meeting is already being recorded")
+		return
+	}
+	meetingLookups.Invalidate(r.Context(), meeting.ID)
+	recordMeetingEvent(r.Context(), meeting.ID, EventRecordingStarted, userID, "", map[string]interface{}{"layout": session.Layout})
+
+	hub.BroadcastToMeeting(meeting.ID, WebSocketMessage{
+		Type:   "recording-started",
+		Data:   session,
+		UserID: userID,
+	}, nil)
+	sendSuccessResponse(w, session)
+}
+
+// updateRecordingLayoutHandler switches the active recording's layout; the
+// host or whoever started the recording may switch it
+func updateRecordingLayoutHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req recordingLayoutRequest
+	if !decodeStrictJSONBody(w, r, &req) {
+		return
+	}
+	if !isValidRecordingLayout(req.Layout) {
+		sendErrorResponse(w, "layout must be grid, active-speaker or screen-share", http.StatusBadRequest)
+		return
+	}
+
+	meeting, err := findMeeting(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+
+	filter := bson.M{"_id": meeting.ID, "recording": bson.M{"$exists": true}}
+	if meeting.CreatedBy != userID {
+		filter["recording.startedBy"] = userID
+	}
+	change := RecordingLayoutChange{Layout: req.Layout, ChangedBy: userID, At: time.Now()}
+	var updated Meeting
+	err = db.Meetings.FindOneAndUpdate(r.Context(), filter,
+		bson.M{
+			"$set":  bson.M{"recording.layout": req.Layout, "updatedAt": change.At},
+			"$push": bson.M{"recording.layouts": change},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		sendCodedError(w, ErrCodeConflict, "No recording of yours is in progress")
+		return
+	}
+	if err != nil {
+		log.Printf("Error switching recording layout: %v", err)
+		sendErrorResponse(w, "Failed to switch layout", http.StatusInternalServerError)
+		return
+	}
+	meetingLookups.Invalidate(r.Context(), meeting.ID)
+
+	hub.BroadcastToMeeting(meeting.ID, WebSocketMessage{
+		Type:   "recording-layout",
+		Data:   change,
+		UserID: userID,
+	}, nil)
+	sendSuccessResponse(w, updated.Recording)
+}
+
+// stopRecordingHandler stops the active recording and returns it, with the
+// layouts it used, for the compositor to finish the file with, and the token
+// to upload the file with
+func stopRecordingHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	meeting, err := findMeeting(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+
+	filter := bson.M{"_id": meeting.ID, "recording": bson.M{"$exists": true}}
+	if meeting.CreatedBy != userID {
+		filter["recording.startedBy"] = userID
+	}
+	now := time.Now()
+	var stopped Meeting
+	err = db.Meetings.FindOneAndUpdate(r.Context(), filter,
+		bson.M{"$unset": bson.M{"recording": ""}, "$set": bson.M{"updatedAt": now}},
+		options.FindOneAndUpdate().SetProjection(bson.M{"recording": 1}),
+	).Decode(&stopped)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		sendCodedError(w, ErrCodeConflict, "No recording of yours is in progress")
+		return
+	}
+	if err != nil {
+		log.Printf("Error stopping recording: %v", err)
+		sendErrorResponse(w, "Failed to stop recording", http.StatusInternalServerError)
+		return
+	}
+	meetingLookups.Invalidate(r.Context(), meeting.ID)
+	recordMeetingEvent(r.Context(), meeting.ID, EventRecordingStopped, userID, "", nil)
+
+	hub.BroadcastToMeeting(meeting.ID, WebSocketMessage{
+		Type:   "recording-stopped",
+		Data:   map[string]string{"stoppedBy": userID},
+		UserID: userID,
+	}, nil)
+
+	// The file is credited to whoever started the recording
+	upload := recordingUpload{
+		MeetingID: meeting.ID,
+		UserID:    stopped.Recording.StartedBy,
+		StartedAt: stopped.Recording.StartedAt,
+		StoppedAt: now,
+	}
+	expiresAt := now.Add(time.Duration(appConfig.Recordings.UploadWindow))
+	sendSuccessResponse(w, StoppedRecording{
+		RecordingSession: *stopped.Recording,
+		StoppedAt:        now,
+		UploadToken:      recordingUploadToken(upload, expiresAt),
+		UploadExpiresAt:  expiresAt,
+	})
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+	"video-meeting-app/storage"
+)
+
+const (
+	// RecordingURLTTL is how long a signed stream URL stays valid
+	RecordingURLTTL = 1 * time.Hour
+	// RecordingTransferTimeout bounds uploading a recording's file and
+	// serving one response of its stream, in place of the server's timeouts
+	RecordingTransferTimeout = 2 * time.Hour
+	// MaxRecordingUploadTokenSize caps the uploadToken field of an upload
+	MaxRecordingUploadTokenSize = 1024
+)
+
+// Recording is a stored recording of a meeting; the media itself lives in
+// blob storage under Key
+type Recording struct {
+	ID              string    `json:"id" bson:"_id"`
+	MeetingID       string    `json:"meetingId" bson:"meetingId"`
+	Key             string    `json:"-" bson:"key"`
+	ContentType     string    `json:"contentType" bson:"contentType"`
+	Size            int64     `json:"size" bson:"size"`
+	DurationSeconds int64     `json:"durationSeconds" bson:"durationSeconds"`
+	RecordedBy      string    `json:"recordedBy" bson:"recordedBy"`
+	StartedAt       time.Time `json:"startedAt" bson:"startedAt"`
+	CreatedAt       time.Time `json:"createdAt" bson:"createdAt"`
+	// Poster frame and preview clip, generated after the recording is stored
+	PreviewStatus     string     `json:"previewStatus,omitempty" bson:"previewStatus,omitempty"`
+	PosterKey         string     `json:"-" bson:"posterKey,omitempty"`
+	PreviewKey        string     `json:"-" bson:"previewKey,omitempty"`
+	PreviewAttempts   int        `json:"-" bson:"previewAttempts,omitempty"`
+	PreviewLeaseUntil *time.Time `json:"-" bson:"previewLeaseUntil,omitempty"`
+	// Filled in for the caller when listing
+	StreamURL  string `json:"streamUrl,omitempty" bson:"-"`
+	PosterURL  string `json:"posterUrl,omitempty" bson:"-"`
+	PreviewURL string `json:"previewUrl,omitempty" bson:"-"`
+	// WebVTT subtitles from the meeting's transcript
+	CaptionsURL string `json:"captionsUrl,omitempty" bson:"-"`
+}
+
+// recordingContentTypes are the file types recordings are stored as, by
+// extension
+var recordingContentTypes = map[string]string{
+	".webm": "video/webm",
+	".mp4":  "video/mp4",
+}
+
+// recordingToken returns a token granting access to the recording's stream
+// until expiresAt. The signed payload is prefixed so other signed tokens
+// cannot be passed off as recording tokens.
+func recordingToken(recordingID string, expiresAt time.Time) string {
+	payload := recordingID + "." + strconv.FormatInt(expiresAt.Unix(), 10)
+	return payload + "." + signValue("recording."+payload)
+}
+
+// parseRecordingToken verifies a token's signature and expiry and returns the recording ID
+func parseRecordingToken(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("malformed recording token")
+	}
+	if !verifySignature("recording."+parts[0]+"."+parts[1], parts[2]) {
+		return "", fmt.Errorf("invalid recording signature")
+	}
+	expiresAt, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("malformed recording token")
+	}
+	if time.Now().Unix() > expiresAt {
+		return "", fmt.Errorf("recording link has expired")
+	}
+	return parts[0], nil
+}
+
+// recordingMediaURL returns a signed, expiring URL for the recording's
+// stream, poster, preview or captions
+func recordingMediaURL(recordingID, media string, now time.Time) string {
+	return "/api/recordings/" + recordingID + "/" + media + "?token=" + url.QueryEscape(recordingToken(recordingID, now.Add(RecordingURLTTL)))
+}
+
+// signRecordingURLs fills in the recording's signed media URLs
+func signRecordingURLs(recording *Recording, now time.Time) {
+	recording.StreamURL = recordingMediaURL(recording.ID, "stream", now)
+	recording.CaptionsURL = recordingMediaURL(recording.ID, "captions.vtt", now)
+	if recording.PosterKey != "" {
+		recording.PosterURL = recordingMediaURL(recording.ID, "poster", now)
+	}
+	if recording.PreviewKey != "" {
+		recording.PreviewURL = recordingMediaURL(recording.ID, "preview", now)
+	}
+}
+
+// loadRecordingForUser finds a recording the user may watch: they must have
+// hosted or attended its meeting
+func loadRecordingForUser(ctx context.Context, recordingID, userID string) (Recording, int, error) {
+	var recording Recording
+	if err := db.Recordings.FindOne(ctx, bson.M{"_id": recordingID}).Decode(&recording); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return recording, http.StatusNotFound, errors.New("Recording not found")
+		}
+		return recording, http.StatusInternalServerError, errors.New("Database error")
+	}
+	meeting, err := findMeeting(ctx, recording.MeetingID)
+	if err != nil {
+		return recording, http.StatusNotFound, errors.New("Recording not found")
+	}
+	if !isHostOrAttendee(ctx, meeting, userID) {
+		return recording, http.StatusForbidden, errors.New("Only the host and attendees can view recordings")
+	}
+	return recording, http.StatusOK, nil
+}
+
+// getMeetingRecordingsHandler lists a meeting's recordings with signed media URLs
+func getMeetingRecordingsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	meeting, err := findMeeting(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+	if !isHostOrAttendee(r.Context(), meeting, userID) {
+		sendCodedError(w, ErrCodeHostOnly, "Only the host and attendees can view recordings")
+		return
+	}
+
+	cursor, err := db.Recordings.Find(r.Context(), bson.M{"meetingId": meeting.ID},
+		options.Find().SetSort(bson.D{{Key: "startedAt", Value: 1}}))
+	if err != nil {
+		sendErrorResponse(w, "Failed to fetch recordings", http.StatusInternalServerError)
+		return
+	}
+	recordings := []Recording{}
+	if err := cursor.All(r.Context(), &recordings); err != nil {
+		sendErrorResponse(w, "Failed to parse recordings", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	for i := range recordings {
+		signRecordingURLs(&recordings[i], now)
+	}
+	sendSuccessResponse(w, recordings)
+}
+
+// uploadRecordingHandler stores the finished file of a stopped recording as
+// one of the meeting's recordings. The form carries the upload token from
+// stopping the recording and then the file, which is streamed to blob
+// storage as it arrives; the recorder or the host may upload it, once. Its
+// length, from the token, counts towards the host's recording minutes.
+func uploadRecordingHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	meeting, err := findMeeting(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+
+	// Large files take longer than the server's timeouts allow. Without
+	// deadline support those timeouts apply.
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(RecordingTransferTimeout)
+	rc.SetReadDeadline(deadline)
+	rc.SetWriteDeadline(deadline)
+
+	maxSize := int64(appConfig.Recordings.MaxUploadMB) << 20
+	tooLargeMessage := fmt.Sprintf("Recording must be %d MB or smaller", appConfig.Recordings.MaxUploadMB)
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+4096)
+	form, err := r.MultipartReader()
+	if err != nil {
+		sendErrorResponse(w, "A recording file is required", http.StatusBadRequest)
+		return
+	}
+	token, file, err := nextRecordingUploadPart(form)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			sendErrorResponse(w, tooLargeMessage, http.StatusRequestEntityTooLarge)
+		} else {
+			sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	defer file.Close()
+
+	upload, recordingID, err := parseRecordingUploadToken(token)
+	if err == nil && upload.MeetingID != meeting.ID {
+		err = errors.New("upload token is for another meeting")
+	}
+	if err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if upload.UserID != userID && meeting.CreatedBy != userID {
+		sendErrorResponse(w, "Only the recorder and the host can upload the recording", http.StatusForbidden)
+		return
+	}
+
+	ext := strings.ToLower(filepath.Ext(file.FileName()))
+	contentType, ok := recordingContentTypes[ext]
+	if !ok {
+		sendErrorResponse(w, "Recordings must be .webm or .mp4 files", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	existing, err := db.Recordings.CountDocuments(r.Context(), bson.M{"_id": recordingID})
+	if err != nil {
+		sendErrorResponse(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if existing > 0 {
+		sendCodedError(w, ErrCodeConflict, "This recording has already been uploaded")
+		return
+	}
+
+	duration := upload.StoppedAt.Sub(upload.StartedAt)
+	minutes := int(math.Ceil(duration.Minutes()))
+	quota, err := loadQuotaState(r.Context(), meeting.CreatedBy, time.Now())
+	if err != nil {
+		sendErrorResponse(w, "Failed to load quota", http.StatusInternalServerError)
+		return
+	}
+	if err := quota.CheckRecordingMinutes(minutes); err != nil {
+		sendCodedError(w, ErrCodeQuotaExceeded, err.Error())
+		return
+	}
+
+	// Every upload gets its own key, so of two uploads of the same recording
+	// the one that loses the insert below can't overwrite the other's file
+	recording := Recording{
+		ID:              recordingID,
+		MeetingID:       meeting.ID,
+		Key:             "recordings/" + meeting.ID + "/" + recordingID + "-" + uuid.New().String() + ext,
+		ContentType:     contentType,
+		DurationSeconds: int64(duration.Seconds()),
+		RecordedBy:      upload.UserID,
+		StartedAt:       upload.StartedAt,
+		CreatedAt:       time.Now(),
+	}
+	body := &recordingUploadReader{part: file, max: maxSize}
+	if err := blobStore.PutStream(r.Context(), recording.Key, contentType, body); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.Is(err, errRecordingTooLarge) || errors.As(err, &tooLarge) {
+			sendErrorResponse(w, tooLargeMessage, http.StatusRequestEntityTooLarge)
+			return
+		}
+		log.Printf("Error storing recording %s of meeting %s: %v", recording.ID, meeting.ID, err)
+		sendErrorResponse(w, "Failed to store recording", http.StatusInternalServerError)
+		return
+	}
+	recording.Size = body.size
+
+	// The file is deleted again when the recording isn't saved, even if the
+	// uploader has gone
+	cleanupCtx := context.WithoutCancel(r.Context())
+	if recording.Size == 0 {
+		blobStore.Delete(cleanupCtx, recording.Key)
+		sendErrorResponse(w, "File is empty", http.StatusBadRequest)
+		return
+	}
+	if _, err := db.Recordings.InsertOne(r.Context(), recording); err != nil {
+		if err := blobStore.Delete(cleanupCtx, recording.Key); err != nil {
+			log.Printf("Error deleting unsaved recording file %s: %v", recording.Key, err)
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			sendCodedError(w, ErrCodeConflict, "This recording has already been uploaded")
+			return
+		}
+		log.Printf("Error saving recording %s of meeting %s: %v", recording.ID, meeting.ID, err)
+		sendErrorResponse(w, "Failed to save recording", http.StatusInternalServerError)
+		return
+	}
+	publishRecordingReady(r.Context(), recording)
+
+	signRecordingURLs(&recording, time.Now())
+	sendSuccessResponse(w, recording)
+}
+
+// nextRecordingUploadPart reads an upload form up to its file, returning the
+// upload token sent before it and the file's part
+func nextRecordingUploadPart(form *multipart.Reader) (string, *multipart.Part, error) {
+	var token string
+	for {
+		part, err := form.NextPart()
+		if err == io.EOF {
+			return "", nil, errors.New("A recording file is required")
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		switch part.FormName() {
+		case "file":
+			if token == "" {
+				part.Close()
+				return "", nil, errors.New("uploadToken must come before the file")
+			}
+			return token, part, nil
+		case "uploadToken":
+			value, err := io.ReadAll(io.LimitReader(part, MaxRecordingUploadTokenSize))
+			if err != nil {
+				return "", nil, err
+			}
+			token = string(value)
+		}
+		part.Close()
+	}
+}
+
+// errRecordingTooLarge fails an upload that passes the size limit
+var errRecordingTooLarge = errors.New("recording is too large")
+
+// recordingUploadReader counts the bytes of an uploaded file, failing once
+// they pass max
+type recordingUploadReader struct {
+	part io.Reader
+	size int64
+	max  int64
+}
+
+func (u *recordingUploadReader) Read(p []byte) (int, error) {
+	n, err := u.part.Read(p)
+	u.size += int64(n)
+	if u.size > u.max {
+		return n, errRecordingTooLarge
+	}
+	return n, err
+}
+
+// getRecordingHandler returns a recording with fresh signed media URLs
+func getRecordingHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	recording, status, err := loadRecordingForUser(r.Context(), mux.Vars(r)["id"], userID)
+	if err != nil {
+		sendErrorResponse(w, err.Error(), status)
+		return
+	}
+	signRecordingURLs(&recording, time.Now())
+	sendSuccessResponse(w, recording)
+}
+
+// authorizeRecordingMedia loads the recording whose media is requested,
+// writing an error response and returning false unless the request carries
+// either a signed ?token= from a media URL, so players and downloads work
+// without the session cookie, or a session of a host or attendee
+func authorizeRecordingMedia(w http.ResponseWriter, r *http.Request) (Recording, bool) {
+	recordingID := mux.Vars(r)["id"]
+
+	var recording Recording
+	if token := r.URL.Query().Get("token"); token != "" {
+		tokenRecordingID, err := parseRecordingToken(token)
+		if err != nil || tokenRecordingID != recordingID {
+			sendErrorResponse(w, "Invalid or expired recording link", http.StatusForbidden)
+			return recording, false
+		}
+		if err := db.Recordings.FindOne(r.Context(), bson.M{"_id": recordingID}).Decode(&recording); err != nil {
+			sendErrorResponse(w, "Recording not found", http.StatusNotFound)
+			return recording, false
+		}
+		return recording, true
+	}
+
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return recording, false
+	}
+	recording, status, err := loadRecordingForUser(r.Context(), recordingID, userID)
+	if err != nil {
+		sendErrorResponse(w, err.Error(), status)
+		return recording, false
+	}
+	return recording, true
+}
+
+// streamRecordingHandler serves a recording's media with Range support
+func streamRecordingHandler(w http.ResponseWriter, r *http.Request) {
+	recording, ok := authorizeRecordingMedia(w, r)
+	if !ok {
+		return
+	}
+
+	object, info, err := blobStore.Get(r.Context(), recording.Key)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Error loading recording %s: %v", recording.ID, err)
+		}
+		sendErrorResponse(w, "Recording media not found", http.StatusNotFound)
+		return
+	}
+	defer object.Close()
+
+	// Playback can outlast the server's write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(RecordingTransferTimeout))
+
+	contentType := recording.ContentType
+	if contentType == "" {
+		contentType = info.ContentType
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "private, no-store")
+	// ServeContent handles Range, If-Range and If-Modified-Since
+	http.ServeContent(w, r, "", info.ModTime, object)
+}
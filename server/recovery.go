@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const ErrorReporterFlushTimeout = 2 * time.Second
+
+// ErrorReporter forwards recovered panics to an external error tracker
+type ErrorReporter interface {
+	ReportPanic(ctx context.Context, recovered interface{}, stack []byte, tags map[string]string)
+	Flush(timeout time.Duration)
+}
+
+// noopReporter is used when no error tracker is configured; panics are still logged
+type noopReporter struct{}
+
+func (noopReporter) ReportPanic(context.Context, interface{}, []byte, map[string]string) {}
+func (noopReporter) Flush(time.Duration)                                                 {}
+
+// sentryReporter sends panics to Sentry
+type sentryReporter struct{}
+
+func (sentryReporter) ReportPanic(ctx context.Context, recovered interface{}, stack []byte, tags map[string]string) {
+	hub := sentry.CurrentHub().Clone()
+	hub.ConfigureScope(func(scope *sentry.Scope) {
+		scope.SetTags(tags)
+		if spanContext := trace.SpanContextFromContext(ctx); spanContext.HasTraceID() {
+			scope.SetTag("trace_id", spanContext.TraceID().String())
+		}
+	})
+	hub.RecoverWithContext(ctx, recovered)
+}
+
+func (sentryReporter) Flush(timeout time.Duration) {
+	sentry.Flush(timeout)
+}
+
+var errorReporter ErrorReporter = noopReporter{}
+
+// initErrorReporter enables the Sentry reporter when SENTRY_DSN is set
+func initErrorReporter() error {
+	dsn := os.Getenv("SENTRY_DSN")
+	if dsn == "" {
+		return nil
+	}
+	err := sentry.Init(sentry.ClientOptions{
+		Dsn:         dsn,
+		Environment: os.Getenv("SENTRY_ENVIRONMENT"),
+	})
+	if err != nil {
+		return err
+	}
+	errorReporter = sentryReporter{}
+	log.Println("Sentry error reporting enabled")
+	return nil
+}
+
+// reportPanic logs a recovered panic with its stack trace, marks the current
+// span as failed and forwards it to the error reporter
+func reportPanic(ctx context.Context, recovered interface{}, tags map[string]string) {
+	stack := debug.Stack()
+	log.Printf("Panic recovered (%v): %v\n%s", tags, recovered, stack)
+
+	span := trace.SpanFromContext(ctx)
+	span.RecordError(fmt.Errorf("panic: %v", recovered))
+	span.SetStatus(codes.Error, "panic")
+
+	errorReporter.ReportPanic(ctx, recovered, stack, tags)
+}
+
+// recoveryMiddleware turns handler panics into 500 JSON responses
+func recoveryMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			// Deliberate aborts are how net/http cancels a response
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			tags := map[string]string{"method": r.Method, "path": r.URL.Path}
+			if route := mux.CurrentRoute(r); route != nil {
+				if template, err := route.GetPathTemplate(); err == nil {
+					tags["route"] = template
+				}
+			}
+			reportPanic(r.Context(), recovered, tags)
+			sendErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// recoverMessagePanic keeps a panicking WebSocket message handler from taking
+// down the connection's read loop
+func recoverMessagePanic(ctx context.Context, c *Client, messageType string) {
+	recovered := recover()
+	if recovered == nil {
+		return
+	}
+	reportPanic(ctx, recovered, map[string]string{
+		"ws.message_type": messageType,
+		"meeting.id":      c.meetingID,
+		"user.id":         c.userID,
+	})
+	c.sendError("Internal server error")
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+)
+
+// In a global deployment each meeting's realtime connections are served by
+// the instances of one region, its Region, so signaling stays within one
+// hub. A meeting starts in its creator's region. A connection reaching
+// another region is refused with WRONG_REGION and the URL to reconnect to.
+// When most of a meeting's connected participants are in another region,
+// the meeting moves there: it is handed over as when draining, with its
+// clients told to reconnect to the new region.
+
+// regionsEnabled reports whether meetings are routed by region
+func regionsEnabled() bool {
+	return appConfig.Regions.Local != ""
+}
+
+// regionURL returns the public URL of a configured region
+func regionURL(region string) (string, bool) {
+	for _, endpoint := range appConfig.Regions.Endpoints {
+		if endpoint.Name == region {
+			return endpoint.URL, true
+		}
+	}
+	return "", false
+}
+
+// clientRegion returns the configured region the load balancer placed the
+// caller in, or empty
+func clientRegion(r *http.Request) string {
+	if !regionsEnabled() {
+		return ""
+	}
+	region := strings.TrimSpace(r.Header.Get(appConfig.Regions.ClientHeader))
+	if _, ok := regionURL(region); !ok {
+		return ""
+	}
+	return region
+}
+
+// initialMeetingRegion picks a new meeting's region: the requested one, or
+// the creator's, or this instance's
+func initialMeetingRegion(r *http.Request, requested string) (string, error) {
+	if !regionsEnabled() {
+		return "", nil
+	}
+	if requested != "" {
+		if _, ok := regionURL(requested); !ok {
+			return "", errors.New("Unknown region")
+		}
+		return requested, nil
+	}
+	if region := clientRegion(r); region != "" {
+		return region, nil
+	}
+	return appConfig.Regions.Local, nil
+}
+
+// servedHere reports whether this instance's region serves a meeting in
+// region; meetings from before routing have none
+func servedHere(region string) bool {
+	return region == "" || region == appConfig.Regions.Local
+}
+
+// routeMeetingRegion refuses a realtime connection to a meeting served by
+// another region, pointing the client there. It reports whether the
+// connection may go ahead.
+func routeMeetingRegion(w http.ResponseWriter, r *http.Request, meeting Meeting) bool {
+	if !regionsEnabled() || servedHere(meeting.Region) {
+		return true
+	}
+	// The cached meeting may predate a move to this region
+	region := meeting.Region
+	var current struct {
+		Region string `bson:"region"`
+	}
+	err := db.Meetings.FindOne(r.Context(), bson.M{"_id": meeting.ID},
+		options.FindOne().SetProjection(bson.M{"region": 1})).Decode(&current)
+	if err == nil {
+		if servedHere(current.Region) {
+			return true
+		}
+		region = current.Region
+	}
+
+	base, ok := regionURL(region)
+	if !ok {
+		// The region is no longer configured, so this one takes over
+		return true
+	}
+	w.Header().Set("Location", strings.TrimSuffix(base, "/")+r.URL.RequestURI())
+	sendCodedError(w, ErrCodeWrongRegion, fmt.Sprintf("The meeting is served in region %s", region))
+	return false
+}
+
+// meetingRegionTracker counts the regions of the clients connected to each
+// meeting here and moves meetings whose participants are mostly elsewhere
+type meetingRegionTracker struct {
+	mu      sync.Mutex
+	clients map[string]map[*Client]string // meetingId -> client -> region
+	movedAt map[string]time.Time
+}
+
+var regionTracker = &meetingRegionTracker{
+	clients: make(map[string]map[*Client]string),
+	movedAt: make(map[string]time.Time),
+}
+
+// Join counts a registered client, moving its meeting if that tips the
+// majority to another region
+func (t *meetingRegionTracker) Join(c *Client) {
+	if !regionsEnabled() {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.clients[c.meetingID] == nil {
+		t.clients[c.meetingID] = make(map[*Client]string)
+	}
+	t.clients[c.meetingID][c] = c.region
+
+	region, ok := t.majorityLocked(c.meetingID)
+	if !ok || time.Since(t.movedAt[c.meetingID]) < time.Duration(appConfig.Regions.MoveCooldown) {
+		return
+	}
+	t.movedAt[c.meetingID] = time.Now()
+	go moveMeetingRegion(db.CopyTenant(context.Background(), c.ctx), c.meetingID, region)
+}
+
+// Leave stops counting a client; safe to call for clients never counted
+func (t *meetingRegionTracker) Leave(c *Client) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.clients[c.meetingID], c)
+	if len(t.clients[c.meetingID]) == 0 {
+		delete(t.clients, c.meetingID)
+		delete(t.movedAt, c.meetingID)
+	}
+}
+
+// majorityLocked returns the other region holding a majority of the
+// meeting's clients, once enough are connected; t.mu must be held
+func (t *meetingRegionTracker) majorityLocked(meetingID string) (string, bool) {
+	clients := t.clients[meetingID]
+	if len(clients) < appConfig.Regions.MinParticipantsToMove {
+		return "", false
+	}
+	counts := make(map[string]int)
+	for _, region := range clients {
+		if region != "" {
+			counts[region]++
+		}
+	}
+	for region, count := range counts {
+		if count*2 > len(clients) && region != appConfig.Regions.Local {
+			return region, true
+		}
+	}
+	return "", false
+}
+
+// moveMeetingRegion moves a meeting served here to region and hands its
+// clients over to it
+func moveMeetingRegion(ctx context.Context, meetingID, region string) {
+	ctx, span := tracer.Start(ctx, "regions.move")
+	defer span.End()
+
+	url, ok := regionURL(region)
+	if !ok {
+		return
+	}
+	result, err := db.Meetings.UpdateOne(ctx,
+		bson.M{"_id": meetingID, "region": bson.M{"$in": bson.A{appConfig.Regions.Local, "", nil}}},
+		bson.M{"$set": bson.M{"region": region, "updatedAt": time.Now()}},
+	)
+	if err != nil {
+		log.Printf("Regions: error moving meeting %s to %s: %v", meetingID, region, err)
+		return
+	}
+	if result.MatchedCount == 0 {
+		return
+	}
+	meetingLookups.Invalidate(ctx, meetingID)
+
+	state := hub.HandOverMeeting(meetingID, WebSocketMessage{Type: "reconnect", Data: map[string]interface{}{
+		"reason":   "region-moved",
+		"region":   region,
+		"url":      url,
+		"windowMs": time.Duration(appConfig.Handover.ReconnectWindow).Milliseconds(),
+	}})
+	if err := saveHandovers(ctx, []meetingHandover{state}); err != nil {
+		log.Printf("Regions: error handing meeting %s over: %v", meetingID, err)
+	}
+	log.Printf("Regions: moved meeting %s to %s", meetingID, region)
+}
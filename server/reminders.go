@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"video-meeting-app/db"
+)
+
+const (
+	ReminderLeadTime    = 10 * time.Minute
+	ReminderHTTPTimeout = 10 * time.Second
+)
+
+// Reminder delivery channels
+const (
+	ReminderChannelWebSocket = "websocket"
+	ReminderChannelWebhook   = "webhook"
+	ReminderChannelPush      = "push"
+	ReminderChannelEmail     = "email"
+)
+
+// ReminderPreferences controls how a user is reminded about scheduled meetings
+type ReminderPreferences struct {
+	Enabled    bool     `json:"enabled" bson:"enabled"`
+	Channels   []string `json:"channels" bson:"channels"`
+	WebhookURL string   `json:"webhookUrl,omitempty" bson:"webhookUrl,omitempty"`
+}
+
+// defaultReminderPreferences applies to users who never saved preferences
+func defaultReminderPreferences() ReminderPreferences {
+	return ReminderPreferences{Enabled: true, Channels: []string{ReminderChannelWebSocket, ReminderChannelPush}}
+}
+
+// MeetingReminder is the payload delivered to each reminder channel
+type MeetingReminder struct {
+	MeetingID   string    `json:"meetingId"`
+	Title       string    `json:"title"`
+	StartsAt    time.Time `json:"startsAt"`
+	JoinURL     string    `json:"joinUrl"`
+	RecipientID string    `json:"recipientId"`
+}
+
+// reminderHTTPClient posts to webhook URLs users enter, so it only reaches
+// public addresses
+var reminderHTTPClient = newPublicHTTPClient(ReminderHTTPTimeout)
+
+func getReminderPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var user User
+	if err := db.Users.FindOne(r.Context(), bson.M{"_id": userID}).Decode(&user); err != nil {
+		sendCodedError(w, ErrCodeUserNotFound, "User not found")
+		return
+	}
+
+	prefs := defaultReminderPreferences()
+	if user.ReminderPreferences != nil {
+		prefs = *user.ReminderPreferences
+	}
+	sendSuccessResponse(w, prefs)
+}
+
+func updateReminderPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var prefs ReminderPreferences
+	if !decodeJSONBody(w, r, &prefs) {
+		return
+	}
+
+	for _, channel := range prefs.Channels {
+		switch channel {
+		case ReminderChannelWebSocket, ReminderChannelPush, ReminderChannelEmail:
+		case ReminderChannelWebhook:
+			if err := validatePublicURL(prefs.WebhookURL); errors.Is(err, errNonPublicAddress) {
+				sendErrorResponse(w, "webhookUrl must point to a public address", http.StatusBadRequest)
+				return
+			} else if err != nil {
+				sendErrorResponse(w, "A valid webhookUrl is required for the webhook channel", http.StatusBadRequest)
+				return
+			}
+		default:
+			sendErrorResponse(w, fmt.Sprintf("Unsupported reminder channel: %s", channel), http.StatusBadRequest)
+			return
+		}
+	}
+
+	_, err := db.Users.UpdateOne(
+		r.Context(),
+		bson.M{"_id": userID},
+		bson.M{"$set": bson.M{"reminderPreferences": prefs, "updatedAt": time.Now()}},
+	)
+	if err != nil {
+		log.Printf("Error updating reminder preferences: %v", err)
+		sendErrorResponse(w, "Failed to update reminder preferences", http.StatusInternalServerError)
+		return
+	}
+
+	sendSuccessResponse(w, prefs)
+}
+
+// sendDueReminders finds scheduled meetings starting soon and reminds their
+// host and invitees once per meeting
+func sendDueReminders(ctx context.Context, now time.Time) error {
+	ctx, span := tracer.Start(ctx, "reminders.scan")
+	defer span.End()
+
+	cursor, err := db.Meetings.Find(ctx, bson.M{
+		"isActive":        true,
+		"scheduledFor":    bson.M{"$gt": now, "$lte": now.Add(ReminderLeadTime)},
+		"remindersSentAt": bson.M{"$exists": false},
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var meetings []Meeting
+	if err := cursor.All(ctx, &meetings); err != nil {
+		return err
+	}
+
+	for _, meeting := range meetings {
+		// Claim the meeting so concurrent scans (or instances) remind only once
+		result, err := db.Meetings.UpdateOne(
+			ctx,
+			bson.M{"_id": meeting.ID, "remindersSentAt": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"remindersSentAt": now}},
+		)
+		if err != nil {
+			log.Printf("Reminders: failed to claim meeting %s: %v", meeting.ID, err)
+			continue
+		}
+		if result.ModifiedCount == 0 {
+			continue
+		}
+
+		recipients := append([]string{meeting.CreatedBy}, meeting.Invitees...)
+		for _, userID := range uniqueStrings(recipients) {
+			sendMeetingReminder(ctx, meeting, *meeting.ScheduledFor, userID)
+		}
+	}
+
+	return nil
+}
+
+// sendMeetingReminder delivers a reminder on every channel the user enabled
+func sendMeetingReminder(ctx context.Context, meeting Meeting, startsAt time.Time, userID string) {
+	prefs := defaultReminderPreferences()
+	var user User
+	err := db.Users.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+	if err == nil && user.ReminderPreferences != nil {
+		prefs = *user.ReminderPreferences
+	}
+	if !prefs.Enabled {
+		return
+	}
+
+	reminder := MeetingReminder{
+		MeetingID:   meeting.ID,
+		Title:       meeting.Title,
+		StartsAt:    startsAt,
+		JoinURL:     meetingJoinURL(meeting),
+		RecipientID: userID,
+	}
+
+	for _, channel := range prefs.Channels {
+		switch channel {
+		case ReminderChannelWebSocket:
+			hub.SendToUser(userID, WebSocketMessage{
+				Type:      "meeting-reminder",
+				Data:      reminder,
+				MeetingID: meeting.ID,
+			})
+		case ReminderChannelWebhook:
+			go postReminderWebhook(prefs.WebhookURL, reminder)
+		case ReminderChannelPush:
+			sendPushToUser(userID, PushNotification{
+				Type:  PushMeetingStarting,
+				Title: "Meeting starting soon",
+				Body:  fmt.Sprintf("%s starts at %s", meeting.Title, startsAt.UTC().Format("15:04 MST")),
+				Data:  map[string]string{"meetingId": meeting.ID, "joinUrl": reminder.JoinURL},
+			})
+		case ReminderChannelEmail:
+			if user.Email == "" {
+				continue
+			}
+			err := mail.EnqueueTemplate(user.Email, "meeting_reminder", map[string]string{
+				"Name":         user.Name,
+				"MeetingTitle": meeting.Title,
+				"StartsAt":     startsAt.UTC().Format("15:04 MST"),
+				"JoinURL":      reminder.JoinURL,
+			})
+			if err != nil {
+				log.Printf("Reminders: error emailing %s about meeting %s: %v", userID, meeting.ID, err)
+			}
+		}
+	}
+}
+
+func postReminderWebhook(webhookURL string, reminder MeetingReminder) {
+	body, err := json.Marshal(reminder)
+	if err != nil {
+		log.Printf("Reminders: error marshaling webhook payload: %v", err)
+		return
+	}
+
+	resp, err := reminderHTTPClient.Post(webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Reminders: webhook delivery to %s failed: %v", webhookURL, err)
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		log.Printf("Reminders: webhook %s responded with status %d", webhookURL, resp.StatusCode)
+	}
+}
+
+// uniqueStrings returns values without duplicates or empty strings, preserving order
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if value == "" || seen[value] {
+			continue
+		}
+		seen[value] = true
+		result = append(result, value)
+	}
+	return result
+}
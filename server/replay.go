@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// ReplayBufferSize is how many recent messages per meeting are kept for
+	// clients resuming after a reconnect
+	ReplayBufferSize = 512
+	// ReplayRetention is how long a meeting's buffer outlives its last
+	// client, so a reconnect after everyone dropped can still resume
+	ReplayRetention = 2 * time.Minute
+)
+
+// ReplayStats counts the memory held by replay buffers and what the caps
+// have dropped since startup
+type ReplayStats struct {
+	// Filled in by the hub snapshot
+	Buffers int `json:"buffers"`
+	Entries int `json:"entries"`
+	Bytes   int `json:"bytes"`
+	// Buffers of meetings nobody was connected to
+	Evicted int64 `json:"evicted"`
+	// Entries dropped before ReplayBufferSize pushed them out
+	Trimmed int64 `json:"trimmed"`
+}
+
+var (
+	replayBuffersEvicted atomic.Int64
+	replayEntriesTrimmed atomic.Int64
+)
+
+// replayEntry is a delivered message and who it was delivered to. Entries
+// for one peer carry its user too, so a client can't claim another user's
+// peer ID to read their signaling.
+type replayEntry struct {
+	seq         uint64
+	frame       *encodedFrame
+	peerID      string // only this peer, when set
+	userID      string
+	excludePeer string // everyone but this peer, when set
+	// The message's JSON size, which the memory caps count
+	size int
+}
+
+// replayBuffer numbers one meeting's messages and keeps the most recent
+type replayBuffer struct {
+	lastSeq  uint64
+	entries  []replayEntry // oldest first, up to ReplayBufferSize
+	bytes    int
+	lastUsed time.Time
+}
+
+// add appends an entry, dropping the oldest beyond ReplayBufferSize entries
+// or maxBytes, and returns how much the buffer grew
+func (b *replayBuffer) add(entry replayEntry, maxBytes int) int {
+	before := b.bytes
+	entry.size = len(entry.frame.text)
+	b.entries = append(b.entries, entry)
+	b.bytes += entry.size
+	for len(b.entries) > ReplayBufferSize {
+		b.dropOldest()
+	}
+	for b.bytes > maxBytes {
+		b.dropOldest()
+		replayEntriesTrimmed.Add(1)
+	}
+	return b.bytes - before
+}
+
+func (b *replayBuffer) dropOldest() {
+	b.bytes -= b.entries[0].size
+	b.entries[0] = replayEntry{}
+	b.entries = b.entries[1:]
+}
+
+// ordered returns the entries oldest first
+func (b *replayBuffer) ordered() []replayEntry {
+	return b.entries
+}
+
+// since returns the entries after seq in order, or false when some of them
+// have already been dropped
+func (b *replayBuffer) since(seq uint64) ([]replayEntry, bool) {
+	if seq >= b.lastSeq {
+		return nil, true
+	}
+	ordered := b.ordered()
+	if len(ordered) == 0 || ordered[0].seq > seq+1 {
+		return nil, false
+	}
+	for i, entry := range ordered {
+		if entry.seq > seq {
+			return ordered[i:], true
+		}
+	}
+	return nil, true
+}
+
+// resumeRequest asks the hub to replay what a reconnected client missed
+type resumeRequest struct {
+	client *Client
+	from   uint64
+	peerID string // the client's peer ID before reconnecting
+}
+
+// sequence numbers a message for its meeting and returns the encoded message
+// and the meeting's buffer to record it in; must run on the shard's goroutine
+func (h *hubShard) sequence(meetingID string, message WebSocketMessage) (*encodedFrame, *replayBuffer, error) {
+	buffer := h.replay[meetingID]
+	if buffer == nil {
+		buffer = &replayBuffer{}
+		h.replay[meetingID] = buffer
+	}
+	buffer.lastSeq++
+	buffer.lastUsed = time.Now()
+	message.Seq = buffer.lastSeq
+
+	frame, err := newEncodedFrame(message)
+	return frame, buffer, err
+}
+
+// record adds an entry to a meeting's buffer and, when that takes the shard
+// over its share of hub.replayTotalMaxBytes, makes room; must run on the
+// shard's goroutine
+func (h *hubShard) record(buffer *replayBuffer, entry replayEntry) {
+	h.replayBytes += buffer.add(entry, h.replayMaxBytes)
+	if h.replayBytes > h.replayLimit {
+		h.shrinkReplay()
+	}
+}
+
+// dropReplay forgets a meeting's buffer; must run on the shard's goroutine
+func (h *hubShard) dropReplay(meetingID string) {
+	if buffer := h.replay[meetingID]; buffer != nil {
+		h.replayBytes -= buffer.bytes
+		delete(h.replay, meetingID)
+	}
+}
+
+// shrinkReplay brings the shard's buffers to 90% of its limit, so the next
+// few messages don't have to do it again. Buffers of meetings nobody is
+// connected to go first, least recently used first; then the largest
+// buffers lose their oldest half until there is room. Must run on the
+// shard's goroutine.
+func (h *hubShard) shrinkReplay() {
+	target := h.replayLimit / 10 * 9
+
+	var abandoned, live []string
+	for meetingID := range h.replay {
+		if len(h.meetings[meetingID]) == 0 {
+			abandoned = append(abandoned, meetingID)
+		} else {
+			live = append(live, meetingID)
+		}
+	}
+	slices.SortFunc(abandoned, func(a, b string) int {
+		return h.replay[a].lastUsed.Compare(h.replay[b].lastUsed)
+	})
+	for _, meetingID := range abandoned {
+		if h.replayBytes <= target {
+			return
+		}
+		h.dropReplay(meetingID)
+		replayBuffersEvicted.Add(1)
+	}
+
+	slices.SortFunc(live, func(a, b string) int {
+		return h.replay[b].bytes - h.replay[a].bytes
+	})
+	for _, meetingID := range live {
+		buffer := h.replay[meetingID]
+		for half := buffer.bytes / 2; buffer.bytes > half && h.replayBytes > target; {
+			before := buffer.bytes
+			buffer.dropOldest()
+			h.replayBytes -= before - buffer.bytes
+			replayEntriesTrimmed.Add(1)
+		}
+		if h.replayBytes <= target {
+			return
+		}
+	}
+}
+
+// pruneReplay drops buffers of meetings nobody has been connected to for
+// ReplayRetention; must run on the shard's goroutine
+func (h *hubShard) pruneReplay(now time.Time) {
+	for meetingID, buffer := range h.replay {
+		if len(h.meetings[meetingID]) == 0 && now.Sub(buffer.lastUsed) > ReplayRetention {
+			h.dropReplay(meetingID)
+		}
+	}
+}
+
+// resume sends a reconnected client the messages it missed, then "resumed".
+// When the buffer no longer reaches back far enough it sends "resume-failed"
+// and the client should re-join from scratch. Must run on the shard's goroutine.
+func (h *hubShard) resume(req resumeRequest) {
+	c := req.client
+	if _, ok := h.clients[c]; !ok {
+		return
+	}
+
+	var lastSeq uint64
+	var missed []replayEntry
+	ok := true
+	if buffer := h.replay[c.meetingID]; buffer != nil {
+		lastSeq = buffer.lastSeq
+		missed, ok = buffer.since(req.from)
+	} else {
+		ok = req.from == 0
+	}
+	if !ok || req.from > lastSeq {
+		h.sendToClient(c, WebSocketMessage{
+			Type:      "resume-failed",
+			Data:      map[string]interface{}{"from": req.from, "lastSeq": lastSeq},
+			MeetingID: c.meetingID,
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	// Anything after the client registered was delivered to it live
+	upTo := min(c.registeredSeq, lastSeq)
+	replayed := 0
+	for _, entry := range missed {
+		if entry.seq > upTo {
+			break
+		}
+		if entry.peerID != "" && (entry.peerID != req.peerID || entry.userID != c.userID) {
+			continue
+		}
+		if entry.excludePeer != "" && entry.excludePeer == req.peerID {
+			continue
+		}
+		if !h.deliver(c, entry.frame) {
+			return
+		}
+		replayed++
+	}
+
+	h.sendToClient(c, WebSocketMessage{
+		Type:      "resumed",
+		Data:      map[string]interface{}{"from": req.from, "lastSeq": upTo, "replayed": replayed},
+		MeetingID: c.meetingID,
+		Timestamp: time.Now(),
+	})
+}
+
+// Resume queues a replay of the client's missed messages. Safe to call from
+// any goroutine.
+func (h *Hub) Resume(client *Client, from uint64, previousPeerID string) {
+	h.shardFor(client.meetingID).resumes <- resumeRequest{client: client, from: from, peerID: previousPeerID}
+}
+
+// handleResumeFrom replays what a reconnected client missed. The client sends
+// the seq of the last message it processed and, if its peer ID changed, the
+// previous one so messages addressed to that peer are included.
+func handleResumeFrom(ctx context.Context, c *Client, data json.RawMessage) {
+	var req struct {
+		Seq    uint64 `json:"seq"`
+		PeerID string `json:"peerId"`
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		c.sendError("Invalid resume request")
+		return
+	}
+	if req.PeerID == "" {
+		req.PeerID = c.peerID
+	}
+	c.hub.Resume(c, req.Seq, req.PeerID)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Request body limits. Routes not in routeBodyLimits get DefaultMaxBodySize.
+const (
+	DefaultMaxBodySize = 1 << 20
+	AuthMaxBodySize    = 8 << 10
+	MeetingMaxBodySize = 64 << 10
+)
+
+// routeBodyLimits are body limits by "METHOD /path template"; zero leaves the
+// limit to the handler, as uploads enforce their own
+var routeBodyLimits = map[string]int64{
+	"POST /api/auth/register":                                AuthMaxBodySize,
+	"POST /api/auth/login":                                   AuthMaxBodySize,
+	"POST /api/meetings":                                     MeetingMaxBodySize,
+	"POST /api/meetings/{id}/clone":                          MeetingMaxBodySize,
+	"POST /api/meetings/{id}/join":                           MeetingMaxBodySize,
+	"PUT /api/meetings/{id}/participants":                    MeetingMaxBodySize,
+	"PATCH /api/meetings/{id}/participants":                  MeetingMaxBodySize,
+	"PUT /api/meetings/{id}/participants/{userId}/bandwidth": MeetingMaxBodySize,
+	"POST /api/meetings/{id}/recording/start":                MeetingMaxBodySize,
+	"PUT /api/meetings/{id}/recording/layout":                MeetingMaxBodySize,
+	"POST /api/users/me/avatar":                              0,
+	"POST /api/organizations/{id}/logo":                      0,
+	"POST /api/meetings/{id}/chat/attachments":               0,
+	"POST /api/meetings/{id}/recordings":                     0,
+}
+
+// bodyLimitFor returns the body limit of the matched route
+func bodyLimitFor(r *http.Request) int64 {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return DefaultMaxBodySize
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return DefaultMaxBodySize
+	}
+	if limit, ok := routeBodyLimits[r.Method+" "+template]; ok {
+		return limit
+	}
+	return DefaultMaxBodySize
+}
+
+// bodyLimitMiddleware caps request bodies at their route's limit. Bodies
+// declared larger are rejected up front; others fail when read past it.
+func bodyLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := bodyLimitFor(r)
+		if limit > 0 && r.Body != nil {
+			if r.ContentLength > limit {
+				sendErrorResponse(w, bodyTooLargeMessage(limit), http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func bodyTooLargeMessage(limit int64) string {
+	if limit >= 1<<20 {
+		return fmt.Sprintf("Request body must be %d MB or smaller", limit>>20)
+	}
+	return fmt.Sprintf("Request body must be %d KB or smaller", limit>>10)
+}
+
+// decodeJSONBody decodes the request's JSON body into dst, writing the error
+// response and returning false if it can't
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	return decodeBody(w, r, dst, false)
+}
+
+// decodeStrictJSONBody is decodeJSONBody for payloads that must not carry
+// unknown fields or anything after the JSON value
+func decodeStrictJSONBody(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	return decodeBody(w, r, dst, true)
+}
+
+func decodeBody(w http.ResponseWriter, r *http.Request, dst interface{}, strict bool) bool {
+	decoder := json.NewDecoder(r.Body)
+	if strict {
+		decoder.DisallowUnknownFields()
+	}
+	err := decoder.Decode(dst)
+	if err == nil && strict && decoder.More() {
+		err = errors.New("trailing data")
+	}
+	if err == nil {
+		return true
+	}
+
+	var tooLarge *http.MaxBytesError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &tooLarge):
+		sendErrorResponse(w, bodyTooLargeMessage(tooLarge.Limit), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, io.EOF):
+		sendErrorResponse(w, "Request body is required", http.StatusBadRequest)
+	case errors.As(err, &syntaxErr):
+		sendCodedError(w, ErrCodeInvalidJSON, fmt.Sprintf("Invalid JSON at offset %d", syntaxErr.Offset))
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		sendErrorResponse(w, fmt.Sprintf("Field %q must be a %s", typeErr.Field, typeErr.Type), http.StatusBadRequest)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		sendCodedError(w, ErrCodeUnknownField, "Unknown field "+strings.TrimPrefix(err.Error(), "json: unknown field "))
+	default:
+		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+	}
+	return false
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+)
+
+// retentionPolicy resolves how many days a kind of data is kept for a
+// meeting: its organization's override if set, otherwise the server's
+// setting. Zero days keeps the data forever.
+type retentionPolicy struct {
+	serverDays int
+	orgDays    map[string]int
+}
+
+func (p retentionPolicy) daysFor(orgID string) int {
+	if days, ok := p.orgDays[orgID]; ok {
+		return days
+	}
+	return p.serverDays
+}
+
+// shortest returns the smallest non-zero period, or 0 if everything is kept forever
+func (p retentionPolicy) shortest() int {
+	shortest := p.serverDays
+	for _, days := range p.orgDays {
+		if days > 0 && (shortest == 0 || days < shortest) {
+			shortest = days
+		}
+	}
+	return shortest
+}
+
+// loadRetentionPolicies reads the organizations that override the server's
+// chat or recording retention
+func loadRetentionPolicies(ctx context.Context) (chat, recordings retentionPolicy, err error) {
+	chat = retentionPolicy{serverDays: appConfig.Retention.ChatDays, orgDays: map[string]int{}}
+	recordings = retentionPolicy{serverDays: appConfig.Retention.RecordingDays, orgDays: map[string]int{}}
+
+	cursor, err := db.Organizations.Find(ctx, bson.M{"$or": []bson.M{
+		{"settings.chatRetentionDays": bson.M{"$gt": 0}},
+		{"settings.recordingRetentionDays": bson.M{"$gt": 0}},
+	}})
+	if err != nil {
+		return chat, recordings, err
+	}
+	var orgs []Organization
+	if err := cursor.All(ctx, &orgs); err != nil {
+		return chat, recordings, err
+	}
+	for _, org := range orgs {
+		if org.Settings.ChatRetentionDays > 0 {
+			chat.orgDays[org.ID] = org.Settings.ChatRetentionDays
+		}
+		if org.Settings.RecordingRetentionDays > 0 {
+			recordings.orgDays[org.ID] = org.Settings.RecordingRetentionDays
+		}
+	}
+	return chat, recordings, nil
+}
+
+// runRetentionPurge deletes chat, recordings and participants past their
+// retention period and archives meetings long ended. It returns the last
+// error; the steps after a failed one still run.
+func runRetentionPurge(ctx context.Context, now time.Time) error {
+	ctx, span := tracer.Start(ctx, "retention.purge")
+	defer span.End()
+
+	chat, recordings, err := loadRetentionPolicies(ctx)
+	if err != nil {
+		return fmt.Errorf("loading organization policies: %w", err)
+	}
+
+	var lastErr error
+	messages, err := purgeChatMessages(ctx, now, chat)
+	if err != nil {
+		lastErr = fmt.Errorf("purging chat messages: %w", err)
+	}
+	attachments, err := purgeChatAttachments(ctx, now, chat)
+	if err != nil {
+		lastErr = fmt.Errorf("purging chat attachments: %w", err)
+	}
+	recorded, err := purgeRecordings(ctx, now, recordings)
+	if err != nil {
+		lastErr = fmt.Errorf("purging recordings: %w", err)
+	}
+	participants, err := purgeEndedMeetingParticipants(ctx, now)
+	if err != nil {
+		lastErr = fmt.Errorf("purging participants: %w", err)
+	}
+	archived, err := archiveEndedMeetings(ctx, now)
+	if err != nil {
+		lastErr = fmt.Errorf("archiving meetings: %w", err)
+	}
+
+	if messages > 0 || attachments > 0 || recorded > 0 || participants > 0 || archived > 0 {
+		log.Printf("Retention: purged %d chat messages, %d attachments, %d recordings, %d participants, archived %d meetings in %v",
+			messages, attachments, recorded, participants, archived, time.Since(now))
+	}
+	return lastErr
+}
+
+// expiredByMeeting finds the meetings with documents in coll older than the
+// shortest retention period and returns each one's cutoff under its
+// organization's policy. Meetings whose data is kept forever are left out.
+func expiredByMeeting(ctx context.Context, coll *db.Collection, timeField string, now time.Time, policy retentionPolicy) (map[string]time.Time, error) {
+	shortest := policy.shortest()
+	if shortest == 0 {
+		return nil, nil
+	}
+	meetingIDs, err := coll.Distinct(ctx, "meetingId", bson.M{
+		timeField: bson.M{"$lt": now.AddDate(0, 0, -shortest)},
+	})
+	if err != nil || len(meetingIDs) == 0 {
+		return nil, err
+	}
+
+	// Meetings that no longer exist fall back to the server's setting
+	orgIDs := make(map[string]string, len(meetingIDs))
+	for _, coll := range []*db.Collection{db.Meetings, db.MeetingsArchive} {
+		cursor, err := coll.Find(ctx, bson.M{"_id": bson.M{"$in": meetingIDs}},
+			options.Find().SetProjection(bson.M{"orgId": 1}))
+		if err != nil {
+			return nil, err
+		}
+		var meetings []Meeting
+		if err := cursor.All(ctx, &meetings); err != nil {
+			return nil, err
+		}
+		for _, meeting := range meetings {
+			orgIDs[meeting.ID] = meeting.OrgID
+		}
+	}
+
+	cutoffs := make(map[string]time.Time, len(meetingIDs))
+	for _, id := range meetingIDs {
+		meetingID, ok := id.(string)
+		if !ok {
+			continue
+		}
+		if days := policy.daysFor(orgIDs[meetingID]); days > 0 {
+			cutoffs[meetingID] = now.AddDate(0, 0, -days)
+		}
+	}
+	return cutoffs, nil
+}
+
+// purgeChatMessages deletes chat messages past their meeting's chat retention
+func purgeChatMessages(ctx context.Context, now time.Time, policy retentionPolicy) (int64, error) {
+	cutoffs, err := expiredByMeeting(ctx, db.ChatMessages, "timestamp", now, policy)
+	if err != nil {
+		return 0, err
+	}
+	var purged int64
+	for meetingID, cutoff := range cutoffs {
+		result, err := db.ChatMessages.DeleteMany(ctx, bson.M{
+			"meetingId": meetingID,
+			"timestamp": bson.M{"$lt": cutoff},
+		})
+		if err != nil {
+			return purged, err
+		}
+		purged += result.DeletedCount
+	}
+	return purged, nil
+}
+
+// purgeChatAttachments deletes chat attachments, and their stored files,
+// past their meeting's chat retention
+func purgeChatAttachments(ctx context.Context, now time.Time, policy retentionPolicy) (int64, error) {
+	cutoffs, err := expiredByMeeting(ctx, db.ChatAttachments, "createdAt", now, policy)
+	if err != nil {
+		return 0, err
+	}
+	var purged int64
+	for meetingID, cutoff := range cutoffs {
+		cursor, err := db.ChatAttachments.Find(ctx, bson.M{
+			"meetingId": meetingID,
+			"createdAt": bson.M{"$lt": cutoff},
+		})
+		if err != nil {
+			return purged, err
+		}
+		var attachments []ChatAttachment
+		if err := cursor.All(ctx, &attachments); err != nil {
+			return purged, err
+		}
+		for _, attachment := range attachments {
+			// Keep the record if the file couldn't be removed so the next run retries
+			if err := blobStore.Delete(ctx, attachment.Key); err != nil {
+				log.Printf("Retention: error deleting attachment %s: %v", attachment.Key, err)
+				continue
+			}
+			result, err := db.ChatAttachments.DeleteOne(ctx, bson.M{"_id": attachment.ID})
+			if err != nil {
+				return purged, err
+			}
+			purged += result.DeletedCount
+		}
+	}
+	return purged, nil
+}
+
+// purgeRecordings deletes recordings, and their stored media, past their
+// meeting's recording retention
+func purgeRecordings(ctx context.Context, now time.Time, policy retentionPolicy) (int64, error) {
+	cutoffs, err := expiredByMeeting(ctx, db.Recordings, "createdAt", now, policy)
+	if err != nil {
+		return 0, err
+	}
+	var purged int64
+	for meetingID, cutoff := range cutoffs {
+		cursor, err := db.Recordings.Find(ctx, bson.M{
+			"meetingId": meetingID,
+			"createdAt": bson.M{"$lt": cutoff},
+		})
+		if err != nil {
+			return purged, err
+		}
+		var recordings []Recording
+		if err := cursor.All(ctx, &recordings); err != nil {
+			return purged, err
+		}
+		for _, recording := range recordings {
+			deleteRecordingPreviews(ctx, recording)
+			if err := blobStore.Delete(ctx, recording.Key); err != nil {
+				log.Printf("Retention: error deleting recording %s: %v", recording.Key, err)
+				continue
+			}
+			result, err := db.Recordings.DeleteOne(ctx, bson.M{"_id": recording.ID})
+			if err != nil {
+				return purged, err
+			}
+			purged += result.DeletedCount
+		}
+	}
+	return purged, nil
+}
+
+// purgeEndedMeetingParticipants deletes the participant records of meetings
+// that ended, or were deleted, more than ParticipantsAfterEnd ago
+func purgeEndedMeetingParticipants(ctx context.Context, now time.Time) (int64, error) {
+	cutoff := now.Add(-time.Duration(appConfig.Retention.ParticipantsAfterEnd))
+
+	// Participants of a meeting that ended before the cutoff all left before it
+	meetingIDs, err := db.Participants.Distinct(ctx, "meetingId", bson.M{
+		"leftAt": bson.M{"$lt": cutoff},
+	})
+	if err != nil || len(meetingIDs) == 0 {
+		return 0, err
+	}
+
+	cursor, err := db.Meetings.Find(ctx, bson.M{"_id": bson.M{"$in": meetingIDs}},
+		options.Find().SetProjection(bson.M{"isActive": 1, "updatedAt": 1}))
+	if err != nil {
+		return 0, err
+	}
+	var meetings []Meeting
+	if err := cursor.All(ctx, &meetings); err != nil {
+		return 0, err
+	}
+	inUse := make(map[string]bool, len(meetings))
+	for _, meeting := range meetings {
+		if meeting.IsActive || !meeting.UpdatedAt.Before(cutoff) {
+			inUse[meeting.ID] = true
+		}
+	}
+
+	var ended []string
+	for _, id := range meetingIDs {
+		if meetingID, ok := id.(string); ok && !inUse[meetingID] {
+			ended = append(ended, meetingID)
+		}
+	}
+	if len(ended) == 0 {
+		return 0, nil
+	}
+	result, err := db.Participants.DeleteMany(ctx, bson.M{"meetingId": bson.M{"$in": ended}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+)
+
+// Participant roles
+const (
+	RoleHost      = "host"
+	RolePresenter = "presenter"
+	RoleAttendee  = "attendee"
+)
+
+// Permission is an action that requires a minimum participant role
+type Permission string
+
+const (
+	PermissionScreenShare     Permission = "screen-share"
+	PermissionMuteOthers      Permission = "mute-others"
+	PermissionClearChat       Permission = "clear-chat"
+	PermissionModerateChat    Permission = "moderate-chat"
+	PermissionManageRoles     Permission = "manage-roles"
+	PermissionSpotlight       Permission = "spotlight"
+	PermissionLimitBandwidth  Permission = "limit-bandwidth"
+	PermissionClearWhiteboard Permission = "clear-whiteboard"
+)
+
+var rolePermissions = map[string]map[Permission]bool{
+	RoleHost: {
+		PermissionScreenShare:     true,
+		PermissionMuteOthers:      true,
+		PermissionClearChat:       true,
+		PermissionModerateChat:    true,
+		PermissionManageRoles:     true,
+		PermissionSpotlight:       true,
+		PermissionLimitBandwidth:  true,
+		PermissionClearWhiteboard: true,
+	},
+	RolePresenter: {
+		PermissionScreenShare: true,
+	},
+	RoleAttendee: {},
+}
+
+func isValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// participantRole returns the user's role in the meeting. The meeting creator
+// is always host; users without a participant record are attendees.
+func participantRole(ctx context.Context, meeting Meeting, userID string) string {
+	if meeting.CreatedBy == userID {
+		return RoleHost
+	}
+
+	var participant Participant
+	err := db.Participants.FindOne(
+		ctx,
+		bson.M{"meetingId": meeting.ID, "userId": userID},
+	).Decode(&participant)
+	if err != nil || !isValidRole(participant.Role) {
+		return RoleAttendee
+	}
+	return participant.Role
+}
+
+// hasPermission reports whether the user's role in the meeting grants permission
+func hasPermission(ctx context.Context, meeting Meeting, userID string, permission Permission) bool {
+	return rolePermissions[participantRole(ctx, meeting, userID)][permission]
+}
+
+// permissionDeniedMessage is the error returned to clients lacking a permission
+func permissionDeniedMessage(permission Permission) string {
+	return fmt.Sprintf("Permission denied: %s is not allowed for your role", permission)
+}
+
+// requireClientPermission loads the client's meeting and checks a permission,
+// sending an error frame to the client when it is missing
+func requireClientPermission(ctx context.Context, c *Client, permission Permission) (Meeting, bool) {
+	meeting, err := findMeeting(ctx, c.meetingID)
+	if err != nil {
+		c.sendError("Meeting not found")
+		return meeting, false
+	}
+	if !hasPermission(ctx, meeting, c.userID, permission) {
+		c.sendError(permissionDeniedMessage(permission))
+		return meeting, false
+	}
+	return meeting, true
+}
+
+func updateParticipantRoleHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	meetingID := vars["id"]
+	targetUserID := vars["userId"]
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	meeting, err := findMeeting(r.Context(), meetingID)
+	if err != nil {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+	meetingID = meeting.ID
+	if !hasPermission(r.Context(), meeting, userID, PermissionManageRoles) {
+		sendCodedError(w, ErrCodePermissionDenied, permissionDeniedMessage(PermissionManageRoles))
+		return
+	}
+
+	var req struct {
+		Role string `json:"role"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if !isValidRole(req.Role) {
+		sendErrorResponse(w, "Role must be host, presenter, or attendee", http.StatusBadRequest)
+		return
+	}
+	if targetUserID == meeting.CreatedBy && req.Role != RoleHost {
+		sendErrorResponse(w, "The meeting creator is always a host", http.StatusBadRequest)
+		return
+	}
+
+	result, err := db.Participants.UpdateOne(
+		r.Context(),
+		bson.M{"meetingId": meetingID, "userId": targetUserID},
+		bson.M{"$set": bson.M{
+			"role":       req.Role,
+			"isHost":     req.Role == RoleHost,
+			"lastActive": time.Now(),
+		}},
+	)
+	if err != nil {
+		log.Printf("Error updating participant role: %v", err)
+		sendErrorResponse(w, "Failed to update role", http.StatusInternalServerError)
+		return
+	}
+	if result.MatchedCount == 0 {
+		sendErrorResponse(w, "Participant not found", http.StatusNotFound)
+		return
+	}
+
+	hub.BroadcastToMeeting(meetingID, WebSocketMessage{
+		Type:   "role-changed",
+		Data:   map[string]string{"userId": targetUserID, "role": req.Role},
+		UserID: userID,
+	}, nil)
+
+	sendSuccessResponse(w, map[string]string{"userId": targetUserID, "role": req.Role})
+}
+
+// handleMuteParticipant lets hosts mute another participant's audio
+func handleMuteParticipant(ctx context.Context, c *Client, data json.RawMessage) {
+	var req struct {
+		UserID string `json:"userId"`
+	}
+	if err := json.Unmarshal(data, &req); err != nil || req.UserID == "" {
+		c.sendError("Invalid mute request")
+		return
+	}
+	if _, ok := requireClientPermission(ctx, c, PermissionMuteOthers); !ok {
+		return
+	}
+
+	_, err := db.Participants.UpdateOne(
+		ctx,
+		bson.M{"meetingId": c.meetingID, "userId": req.UserID},
+		bson.M{"$set": bson.M{"isAudioEnabled": false}},
+	)
+	if err != nil {
+		log.Printf("Error muting participant: %v", err)
+		c.sendError("Failed to mute participant")
+		return
+	}
+
+	hub.BroadcastToMeeting(c.meetingID, WebSocketMessage{
+		Type:   "participant-muted",
+		Data:   map[string]string{"userId": req.UserID, "mutedBy": c.userID},
+		UserID: c.userID,
+	}, nil)
+}
+
+// handleUnmuteParticipant asks another participant to unmute; browsers only
+// let the participant themselves turn the microphone back on
+func handleUnmuteParticipant(ctx context.Context, c *Client, data json.RawMessage) {
+	var req struct {
+		UserID string `json:"userId"`
+	}
+	if err := json.Unmarshal(data, &req); err != nil || req.UserID == "" {
+		c.sendError("Invalid unmute request")
+		return
+	}
+	if _, ok := requireClientPermission(ctx, c, PermissionMuteOthers); !ok {
+		return
+	}
+	present, err := db.Participants.CountDocuments(ctx, bson.M{
+		"meetingId": c.meetingID,
+		"userId":    req.UserID,
+		"leftAt":    bson.M{"$exists": false},
+	}, options.Count().SetLimit(1))
+	if err != nil {
+		log.Printf("Error checking participant: %v", err)
+		c.sendError("Failed to request unmute")
+		return
+	}
+	if present == 0 {
+		c.sendError("User is not in this meeting")
+		return
+	}
+
+	hub.SendToUserInMeeting(c.meetingID, req.UserID, WebSocketMessage{
+		Type:   "unmute-requested",
+		Data:   map[string]string{"requestedBy": c.userID},
+		UserID: c.userID,
+	})
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+)
+
+// RaisedHand is a participant waiting to speak
+type RaisedHand struct {
+	UserID   string    `json:"userId"`
+	UserName string    `json:"userName"`
+	RaisedAt time.Time `json:"raisedAt"`
+}
+
+// RoomState is everything a client needs to render the room on joining
+type RoomState struct {
+	MeetingID            string             `json:"meetingId"`
+	Title                string             `json:"title"`
+	HostID               string             `json:"hostId"`
+	IsLocked             bool               `json:"isLocked"`
+	SpotlightUserID      string             `json:"spotlightUserId,omitempty"`
+	Recording            *RecordingSession  `json:"recording,omitempty"`
+	TranscriptionEnabled bool               `json:"transcriptionEnabled"`
+	E2EE                 bool               `json:"e2ee"`
+	ChatFilter           string             `json:"chatFilter"`
+	EndsAt               *time.Time         `json:"endsAt,omitempty"`
+	Participants         []Participant      `json:"participants"`
+	ScreenShares         []screenShareGrant `json:"screenShares"`
+	RaisedHands          []RaisedHand       `json:"raisedHands"`
+	// The receiving client's own identity and role
+	You struct {
+		UserID string `json:"userId"`
+		PeerID string `json:"peerId"`
+		Role   string `json:"role"`
+	} `json:"you"`
+}
+
+// MeetingLiveState is the room with the meeting's open polls, for host
+// dashboards and bots that poll rather than hold a connection
+type MeetingLiveState struct {
+	RoomState
+	ActivePolls []Poll `json:"activePolls"`
+}
+
+// loadRoomState assembles the room as the client should see it
+func loadRoomState(ctx context.Context, c *Client) (RoomState, error) {
+	meeting, err := findMeeting(ctx, c.meetingID)
+	if err != nil {
+		return RoomState{}, err
+	}
+	state, err := buildRoomState(ctx, meeting)
+	if err != nil {
+		return state, err
+	}
+	state.You.UserID = c.userID
+	state.You.PeerID = c.peerID
+	state.You.Role = participantRole(ctx, meeting, c.userID)
+	return state, nil
+}
+
+// buildRoomState assembles the meeting's room as everyone sees it
+func buildRoomState(ctx context.Context, meeting Meeting) (RoomState, error) {
+	var state RoomState
+	// Participants in join order, so unchanged rooms serialize identically
+	cursor, err := db.Participants.Find(ctx, bson.M{
+		"meetingId": meeting.ID,
+		"leftAt":    bson.M{"$exists": false},
+	}, options.Find().SetSort(bson.D{{Key: "joinedAt", Value: 1}}))
+	if err != nil {
+		return state, err
+	}
+	participants := []Participant{}
+	if err := cursor.All(ctx, &participants); err != nil {
+		return state, err
+	}
+
+	userIDs := make([]string, 0, len(participants))
+	for _, participant := range participants {
+		userIDs = append(userIDs, participant.UserID)
+	}
+	online := presence.Online(ctx, userIDs)
+
+	raisedHands := []RaisedHand{}
+	for i := range participants {
+		participants[i].Online = online[participants[i].UserID]
+		// The arbiter is authoritative; the stored flag can lag a dropped connection
+		participants[i].IsScreenSharing = screenShares.IsSharing(meeting.ID, participants[i].UserID)
+		if participants[i].HandRaisedAt != nil {
+			raisedHands = append(raisedHands, RaisedHand{
+				UserID:   participants[i].UserID,
+				UserName: participants[i].UserName,
+				RaisedAt: *participants[i].HandRaisedAt,
+			})
+		}
+	}
+	sort.Slice(raisedHands, func(i, j int) bool {
+		return raisedHands[i].RaisedAt.Before(raisedHands[j].RaisedAt)
+	})
+
+	state = RoomState{
+		MeetingID:            meeting.ID,
+		Title:                meeting.Title,
+		HostID:               meeting.CreatedBy,
+		IsLocked:             meeting.IsLocked,
+		SpotlightUserID:      meeting.SpotlightUserID,
+		Recording:            meeting.Recording,
+		TranscriptionEnabled: meeting.TranscriptionEnabled,
+		E2EE:                 meeting.E2EE,
+		ChatFilter:           chatFilterOf(meeting),
+		EndsAt:               meeting.EndsAt,
+		Participants:         participants,
+		ScreenShares:         screenShares.Active(meeting.ID),
+		RaisedHands:          raisedHands,
+	}
+	return state, nil
+}
+
+// getMeetingStateHandler returns the host a live snapshot of the meeting.
+// Responses carry an ETag, so polling an unchanged meeting costs a 304.
+func getMeetingStateHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	meeting, err := findMeeting(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+	if meeting.CreatedBy != userID {
+		sendCodedError(w, ErrCodeHostOnly, "Only the host can view the meeting's live state")
+		return
+	}
+
+	room, err := buildRoomState(r.Context(), meeting)
+	if err != nil {
+		log.Printf("Error loading live state of meeting %s: %v", meeting.ID, err)
+		sendErrorResponse(w, "Failed to load meeting state", http.StatusInternalServerError)
+		return
+	}
+	room.You.UserID = userID
+	room.You.Role = RoleHost
+
+	cursor, err := db.Polls.Find(r.Context(),
+		bson.M{"meetingId": meeting.ID, "isClosed": false},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}))
+	if err != nil {
+		sendErrorResponse(w, "Failed to load meeting state", http.StatusInternalServerError)
+		return
+	}
+	state := MeetingLiveState{RoomState: room, ActivePolls: []Poll{}}
+	if err := cursor.All(r.Context(), &state.ActivePolls); err != nil {
+		sendErrorResponse(w, "Failed to load meeting state", http.StatusInternalServerError)
+		return
+	}
+	sendSuccessResponse(w, state)
+}
+
+// sendRoomState sends a newly registered client a "room-state" snapshot
+func sendRoomState(ctx context.Context, c *Client) {
+	state, err := loadRoomState(ctx, c)
+	if err != nil {
+		log.Printf("Error loading room state for meeting %s: %v", c.meetingID, err)
+		c.sendError("Failed to load room state")
+		return
+	}
+	c.hub.SendToClient(c, WebSocketMessage{
+		Type: "room-state",
+		Data: state,
+	})
+}
+
+// handleRaiseHand raises the client's hand
+func handleRaiseHand(ctx context.Context, c *Client, data json.RawMessage) {
+	now := time.Now()
+	result, err := db.Participants.UpdateOne(
+		ctx,
+		bson.M{"meetingId": c.meetingID, "userId": c.userID, "handRaisedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"handRaisedAt": now}},
+	)
+	if err != nil {
+		log.Printf("Error raising hand: %v", err)
+		c.sendError("Failed to raise hand")
+		return
+	}
+	if result.ModifiedCount == 0 {
+		return // already raised, or not a participant
+	}
+
+	c.hub.BroadcastToMeeting(c.meetingID, WebSocketMessage{
+		Type:   "hand-raised",
+		Data:   RaisedHand{UserID: c.userID, UserName: c.userName, RaisedAt: now},
+		UserID: c.userID,
+	}, nil)
+}
+
+// handleLowerHand lowers the client's hand, or another participant's for
+// those allowed to mute others
+func handleLowerHand(ctx context.Context, c *Client, data json.RawMessage) {
+	var req struct {
+		UserID string `json:"userId"`
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &req); err != nil {
+			c.sendError("Invalid lower-hand request")
+			return
+		}
+	}
+	target := c.userID
+	if req.UserID != "" && req.UserID != c.userID {
+		if _, ok := requireClientPermission(ctx, c, PermissionMuteOthers); !ok {
+			return
+		}
+		target = req.UserID
+	}
+
+	result, err := db.Participants.UpdateOne(
+		ctx,
+		bson.M{"meetingId": c.meetingID, "userId": target, "handRaisedAt": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"handRaisedAt": ""}},
+	)
+	if err != nil {
+		log.Printf("Error lowering hand: %v", err)
+		c.sendError("Failed to lower hand")
+		return
+	}
+	if result.ModifiedCount == 0 {
+		return
+	}
+
+	c.hub.BroadcastToMeeting(c.meetingID, WebSocketMessage{
+		Type:   "hand-lowered",
+		Data:   map[string]string{"userId": target, "loweredBy": c.userID},
+		UserID: c.userID,
+	}, nil)
+}
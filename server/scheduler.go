@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/config"
+	"video-meeting-app/db"
+	"video-meeting-app/schedule"
+)
+
+// The scheduler runs periodic jobs on cron-style schedules. When several
+// instances run, each scheduled run happens on one of them: the instance
+// that first claims the run's lock in the database runs it, and the others
+// skip it. A lock is held until the run finishes or its timeout passes, so a
+// run that overlaps the next leaves that one to be skipped as well.
+
+// JobLock is the database lock of a scheduled job. RunAt is the scheduled
+// time of its latest run; a run can only be claimed once.
+type JobLock struct {
+	Name        string    `json:"name" bson:"_id"`
+	RunAt       time.Time `json:"runAt" bson:"runAt"`
+	Owner       string    `json:"owner" bson:"owner"`
+	LockedUntil time.Time `json:"lockedUntil" bson:"lockedUntil"`
+}
+
+// ScheduledJob is a job run by the scheduler
+type ScheduledJob struct {
+	Name string
+	// The job's schedule, as configured
+	Spec string
+	// A run is cancelled after this long, and its lock released
+	Timeout time.Duration
+	Run     func(ctx context.Context, now time.Time) error
+
+	schedule schedule.Schedule
+	mu       sync.Mutex
+	stats    JobStats
+}
+
+// JobStats counts a job's runs on this instance since startup
+type JobStats struct {
+	Runs     int64 `json:"runs"`
+	Failures int64 `json:"failures"`
+	// Runs another instance claimed
+	Skipped        int64      `json:"skipped"`
+	Running        bool       `json:"running"`
+	LastRunAt      *time.Time `json:"lastRunAt,omitempty"`
+	LastDurationMs int64      `json:"lastDurationMs"`
+	LastError      string     `json:"lastError,omitempty"`
+	NextRunAt      time.Time  `json:"nextRunAt"`
+}
+
+// schedulerInstanceID names this instance in job locks
+var schedulerInstanceID = func() string {
+	host, _ := os.Hostname()
+	return host + "/" + uuid.New().String()[:8]
+}()
+
+// scheduledJobs are the jobs started by startScheduler, in the order the
+// admin status lists them
+var scheduledJobs []*ScheduledJob
+
+// scheduleJob adds a job to the scheduler
+func scheduleJob(job *ScheduledJob) error {
+	parsed, err := schedule.Parse(job.Spec)
+	if err != nil {
+		return fmt.Errorf("job %s: %w", job.Name, err)
+	}
+	job.schedule = parsed
+	scheduledJobs = append(scheduledJobs, job)
+	return nil
+}
+
+// startScheduler runs each scheduled job on its schedule until ctx is
+// cancelled
+func startScheduler(ctx context.Context) {
+	for _, job := range scheduledJobs {
+		log.Printf("Scheduler: %s runs %q", job.Name, job.Spec)
+		go runScheduledJob(ctx, job)
+	}
+}
+
+func runScheduledJob(ctx context.Context, job *ScheduledJob) {
+	for {
+		runAt := job.schedule.Next(time.Now())
+		job.mu.Lock()
+		job.stats.NextRunAt = runAt
+		job.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(runAt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		runJobOnce(ctx, job, runAt)
+	}
+}
+
+// runJobOnce claims the run at runAt and, if this instance got it, runs it
+func runJobOnce(ctx context.Context, job *ScheduledJob, runAt time.Time) {
+	claimed, err := claimJobRun(ctx, job, runAt)
+	if err != nil {
+		log.Printf("Scheduler: error claiming %s run: %v", job.Name, err)
+	}
+	if !claimed {
+		job.mu.Lock()
+		if err == nil {
+			job.stats.Skipped++
+		} else {
+			job.stats.Failures++
+			job.stats.LastError = err.Error()
+		}
+		job.mu.Unlock()
+		return
+	}
+
+	started := time.Now()
+	job.mu.Lock()
+	job.stats.Running = true
+	job.mu.Unlock()
+
+	err = runJobSafely(ctx, job, started)
+
+	job.mu.Lock()
+	job.stats.Running = false
+	job.stats.Runs++
+	job.stats.LastRunAt = &started
+	job.stats.LastDurationMs = time.Since(started).Milliseconds()
+	job.stats.LastError = ""
+	if err != nil {
+		job.stats.Failures++
+		job.stats.LastError = err.Error()
+	}
+	job.mu.Unlock()
+	if err != nil {
+		log.Printf("Scheduler: %s failed: %v", job.Name, err)
+	}
+
+	releaseJobRun(ctx, job, runAt)
+}
+
+// runJobSafely runs the job with its timeout, turning a panic into an error
+// so one bad run doesn't stop the job's schedule
+func runJobSafely(ctx context.Context, job *ScheduledJob, now time.Time) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, job.Timeout)
+	defer cancel()
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			reportPanic(ctx, recovered, map[string]string{"job": job.Name})
+			err = fmt.Errorf("panic: %v", recovered)
+		}
+	}()
+	return job.Run(ctx, now)
+}
+
+// claimJobRun takes the job's lock for the run at runAt. It reports false
+// when another instance already claimed the run or is still running the job.
+func claimJobRun(ctx context.Context, job *ScheduledJob, runAt time.Time) (bool, error) {
+	now := time.Now()
+	_, err := db.JobLocks.UpdateOne(
+		ctx,
+		bson.M{"_id": job.Name, "runAt": bson.M{"$lt": runAt}, "lockedUntil": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"runAt": runAt, "owner": schedulerInstanceID, "lockedUntil": now.Add(job.Timeout)}},
+		options.Update().SetUpsert(true),
+	)
+	// The upsert collides with the existing lock when the filter misses
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// releaseJobRun frees the job's lock once the run is over
+func releaseJobRun(ctx context.Context, job *ScheduledJob, runAt time.Time) {
+	_, err := db.JobLocks.UpdateOne(
+		ctx,
+		bson.M{"_id": job.Name, "runAt": runAt, "owner": schedulerInstanceID},
+		bson.M{"$set": bson.M{"lockedUntil": time.Now()}},
+	)
+	if err != nil {
+		log.Printf("Scheduler: error releasing %s lock: %v", job.Name, err)
+	}
+}
+
+type jobStatus struct {
+	Name     string `json:"name"`
+	Schedule string `json:"schedule"`
+	JobStats
+	// The latest run on any instance
+	Lock *JobLock `json:"lock,omitempty"`
+}
+
+// adminJobsHandler lists the scheduled jobs with this instance's counts and
+// the latest run on any instance
+func adminJobsHandler(w http.ResponseWriter, r *http.Request) {
+	locks := make(map[string]JobLock)
+	cursor, err := db.JobLocks.Find(r.Context(), bson.M{})
+	if err != nil {
+		log.Printf("Error loading job locks: %v", err)
+		sendErrorResponse(w, "Failed to load jobs", http.StatusInternalServerError)
+		return
+	}
+	var found []JobLock
+	if err := cursor.All(r.Context(), &found); err != nil {
+		log.Printf("Error loading job locks: %v", err)
+		sendErrorResponse(w, "Failed to load jobs", http.StatusInternalServerError)
+		return
+	}
+	for _, lock := range found {
+		locks[lock.Name] = lock
+	}
+
+	statuses := make([]jobStatus, 0, len(scheduledJobs))
+	for _, job := range scheduledJobs {
+		job.mu.Lock()
+		status := jobStatus{Name: job.Name, Schedule: job.Spec, JobStats: job.stats}
+		job.mu.Unlock()
+		if lock, ok := locks[job.Name]; ok {
+			status.Lock = &lock
+		}
+		statuses = append(statuses, status)
+	}
+	sendSuccessResponse(w, map[string]interface{}{
+		"instance": schedulerInstanceID,
+		"jobs":     statuses,
+	})
+}
+
+// scheduleJobs adds the periodic jobs with their configured schedules
+func scheduleJobs(cfg config.JobsConfig) error {
+	retention := cfg.Retention
+	if retention == "" {
+		retention = "@every " + time.Duration(appConfig.Retention.PurgeInterval).String()
+	}
+	jobs := []*ScheduledJob{
+		{Name: "cleanup", Spec: cfg.Cleanup, Timeout: 5 * time.Minute, Run: runCleanup},
+		{Name: "reminders", Spec: cfg.Reminders, Timeout: 5 * time.Minute, Run: sendDueReminders},
+		{Name: "retention", Spec: retention, Timeout: time.Hour, Run: runRetentionPurge},
+		{Name: "analytics-rollup", Spec: cfg.AnalyticsRollup, Timeout: 30 * time.Minute, Run: rollupDailyAnalytics},
+	}
+	for _, job := range jobs {
+		if err := scheduleJob(job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"video-meeting-app/db"
+)
+
+// screenShareGrant is an active screen share held by one client
+type screenShareGrant struct {
+	Token     string    `json:"-"`
+	UserID    string    `json:"userId"`
+	PeerID    string    `json:"peerId"`
+	StartedAt time.Time `json:"startedAt"`
+	client    *Client
+	// Set while a handed over grant waits for its presenter to reconnect
+	reservedUntil time.Time
+}
+
+// ScreenShareArbiter limits the number of concurrent screen shares per meeting
+type ScreenShareArbiter struct {
+	mu        sync.Mutex
+	maxShares int
+	grants    map[string][]*screenShareGrant // meetingId -> active grants
+}
+
+func newScreenShareArbiter(maxShares int) *ScreenShareArbiter {
+	return &ScreenShareArbiter{
+		maxShares: maxShares,
+		grants:    make(map[string][]*screenShareGrant),
+	}
+}
+
+// screenShares is created in main with the configured per-meeting limit
+var screenShares *ScreenShareArbiter
+
+// Acquire grants the client a screen share if a slot is free. A client that
+// already holds a share gets its existing grant back.
+func (a *ScreenShareArbiter) Acquire(c *Client) (*screenShareGrant, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.pruneReservations(c.meetingID)
+	for _, grant := range a.grants[c.meetingID] {
+		if grant.client == c {
+			return grant, true
+		}
+	}
+	// A presenter whose share was handed over gets it back, token and all
+	for _, grant := range a.grants[c.meetingID] {
+		if grant.client == nil && grant.UserID == c.userID {
+			grant.client = c
+			grant.PeerID = c.peerID
+			grant.reservedUntil = time.Time{}
+			return grant, true
+		}
+	}
+	if len(a.grants[c.meetingID]) >= a.maxShares {
+		return nil, false
+	}
+
+	tokenBytes := make([]byte, 16)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		log.Printf("Error generating screen share token: %v", err)
+		return nil, false
+	}
+
+	grant := &screenShareGrant{
+		Token:     hex.EncodeToString(tokenBytes),
+		UserID:    c.userID,
+		PeerID:    c.peerID,
+		StartedAt: time.Now(),
+		client:    c,
+	}
+	a.grants[c.meetingID] = append(a.grants[c.meetingID], grant)
+	return grant, true
+}
+
+// Release frees the client's screen share, returning it if one was held
+func (a *ScreenShareArbiter) Release(c *Client) *screenShareGrant {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	grants := a.grants[c.meetingID]
+	for i, grant := range grants {
+		if grant.client == c {
+			a.grants[c.meetingID] = append(grants[:i], grants[i+1:]...)
+			if len(a.grants[c.meetingID]) == 0 {
+				delete(a.grants, c.meetingID)
+			}
+			return grant
+		}
+	}
+	return nil
+}
+
+// Reserve holds a screen share handed over from another instance for its
+// presenter, until they acquire it again or ttl passes
+func (a *ScreenShareArbiter) Reserve(meetingID string, grant handoverGrant, ttl time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, existing := range a.grants[meetingID] {
+		if existing.Token == grant.Token {
+			return
+		}
+	}
+	a.grants[meetingID] = append(a.grants[meetingID], &screenShareGrant{
+		Token:         grant.Token,
+		UserID:        grant.UserID,
+		PeerID:        grant.PeerID,
+		StartedAt:     grant.StartedAt,
+		reservedUntil: time.Now().Add(ttl),
+	})
+}
+
+// pruneReservations drops the meeting's reserved grants that were never
+// taken back; a.mu must be held
+func (a *ScreenShareArbiter) pruneReservations(meetingID string) {
+	now := time.Now()
+	grants := a.grants[meetingID][:0]
+	for _, grant := range a.grants[meetingID] {
+		if grant.client == nil && now.After(grant.reservedUntil) {
+			continue
+		}
+		grants = append(grants, grant)
+	}
+	if len(grants) == 0 {
+		delete(a.grants, meetingID)
+	} else {
+		a.grants[meetingID] = grants
+	}
+}
+
+// ReleaseMeeting drops every grant for a meeting, e.g. when it ends
+func (a *ScreenShareArbiter) ReleaseMeeting(meetingID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.grants, meetingID)
+}
+
+// ValidToken reports whether token is an active grant for the user in the meeting
+func (a *ScreenShareArbiter) ValidToken(meetingID, userID, token string) bool {
+	if token == "" {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, grant := range a.grants[meetingID] {
+		if grant.Token == token && grant.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSharing reports whether the user holds a screen share in the meeting
+func (a *ScreenShareArbiter) IsSharing(meetingID, userID string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, grant := range a.grants[meetingID] {
+		if grant.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// Active returns the current grants for a meeting
+func (a *ScreenShareArbiter) Active(meetingID string) []screenShareGrant {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.pruneReservations(meetingID)
+	active := make([]screenShareGrant, 0, len(a.grants[meetingID]))
+	for _, grant := range a.grants[meetingID] {
+		active = append(active, *grant)
+	}
+	return active
+}
+
+// handleScreenShareStart requests a screen share slot for the client
+func handleScreenShareStart(ctx context.Context, c *Client, data json.RawMessage) {
+	if _, ok := requireClientPermission(ctx, c, PermissionScreenShare); !ok {
+		return
+	}
+
+	grant, ok := screenShares.Acquire(c)
+	if !ok {
+		c.sendError("Screen share limit reached for this meeting")
+		return
+	}
+
+	setScreenSharing(ctx, c.meetingID, c.userID, true)
+	recordMeetingEvent(ctx, c.meetingID, EventScreenShareStarted, c.userID, c.userName, nil)
+
+	c.hub.SendToClient(c, WebSocketMessage{
+		Type: "screenshare-granted",
+		Data: map[string]string{"shareToken": grant.Token},
+	})
+	c.hub.BroadcastToMeeting(c.meetingID, WebSocketMessage{
+		Type:   "screenshare-started",
+		Data:   grant,
+		UserID: c.userID,
+	}, nil)
+}
+
+// handleScreenShareStop releases the client's screen share
+func handleScreenShareStop(ctx context.Context, c *Client, data json.RawMessage) {
+	if grant := screenShares.Release(c); grant != nil {
+		announceScreenShareStopped(ctx, c, grant)
+	}
+}
+
+// announceScreenShareStopped persists and broadcasts the end of a screen share.
+// It queues through the hub, so it must not be called from a hub goroutine.
+func announceScreenShareStopped(ctx context.Context, c *Client, grant *screenShareGrant) {
+	if !screenShares.IsSharing(c.meetingID, c.userID) {
+		setScreenSharing(ctx, c.meetingID, c.userID, false)
+	}
+	recordMeetingEvent(ctx, c.meetingID, EventScreenShareStopped, c.userID, c.userName, nil)
+	c.hub.BroadcastToMeeting(c.meetingID, WebSocketMessage{
+		Type:   "screenshare-stopped",
+		Data:   grant,
+		UserID: c.userID,
+	}, nil)
+}
+
+func setScreenSharing(ctx context.Context, meetingID, userID string, sharing bool) {
+	_, err := db.Participants.UpdateOne(
+		ctx,
+		bson.M{"meetingId": meetingID, "userId": userID},
+		bson.M{"$set": bson.M{"isScreenSharing": sharing}},
+	)
+	if err != nil {
+		log.Printf("Error updating screen share state: %v", err)
+	}
+}
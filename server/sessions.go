@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+)
+
+// A login creates a session with a random token, sent as the session cookie.
+// Sessions are kept in a store every instance shares, so whichever instance a
+// request reaches can validate it. Only the token's hash is stored. Each use
+// of a session extends it by the idle timeout, up to its maximum lifetime.
+
+const (
+	SessionTokenPrefix = "sess_"
+
+	sessionKeyPrefix     = "session:"
+	sessionUserKeyPrefix = "session:user:"
+)
+
+// Session is a login session
+type Session struct {
+	// The hash of the session's token
+	ID           string    `json:"-" bson:"_id"`
+	UserID       string    `json:"userId" bson:"userId"`
+	IP           string    `json:"ip,omitempty" bson:"ip,omitempty"`
+	UserAgent    string    `json:"userAgent,omitempty" bson:"userAgent,omitempty"`
+	CreatedAt    time.Time `json:"createdAt" bson:"createdAt"`
+	LastSeenAt   time.Time `json:"lastSeenAt" bson:"lastSeenAt"`
+	ExpiresAt    time.Time `json:"expiresAt" bson:"expiresAt"`
+	MaxExpiresAt time.Time `json:"maxExpiresAt" bson:"maxExpiresAt"`
+	TenantID     string    `json:"tenantId,omitempty" bson:"tenantId,omitempty"`
+}
+
+// SessionStore keeps sessions where every instance can find them
+type SessionStore interface {
+	Create(ctx context.Context, session Session) error
+	// Touch returns the live session with the ID, extended to idle after now
+	// but not past its maximum lifetime; nil when there is none
+	Touch(ctx context.Context, id string, now time.Time, idle time.Duration) (*Session, error)
+	Delete(ctx context.Context, id string) error
+	// DeleteUser ends every session of the user
+	DeleteUser(ctx context.Context, userID string) error
+}
+
+// sessionStore is created in main; sessions are in the database unless Redis
+// is configured
+var sessionStore SessionStore
+
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// createSession starts a session for the user and returns its token
+func createSession(r *http.Request, userID string) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := SessionTokenPrefix + base64.RawURLEncoding.EncodeToString(buf)
+
+	now := time.Now()
+	session := Session{
+		ID:           hashSessionToken(token),
+		UserID:       userID,
+		IP:           getClientIP(r),
+		UserAgent:    truncateString(r.UserAgent(), 256),
+		CreatedAt:    now,
+		LastSeenAt:   now,
+		ExpiresAt:    now.Add(time.Duration(appConfig.Sessions.IdleTimeout)),
+		MaxExpiresAt: now.Add(time.Duration(appConfig.Sessions.MaxLifetime)),
+	}
+	if tenantID, ok := db.TenantFromContext(r.Context()); ok {
+		session.TenantID = tenantID
+	}
+	if err := sessionStore.Create(r.Context(), session); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// sessionUserID returns the user of the request's session cookie, extending
+// the session; empty when there is no live session
+func sessionUserID(r *http.Request) string {
+	cookie, err := r.Cookie(CookieName)
+	if err != nil || !strings.HasPrefix(cookie.Value, SessionTokenPrefix) {
+		return ""
+	}
+	session, err := sessionStore.Touch(r.Context(), hashSessionToken(cookie.Value), time.Now(),
+		time.Duration(appConfig.Sessions.IdleTimeout))
+	if err != nil {
+		log.Printf("Error loading session: %v", err)
+		return ""
+	}
+	if session == nil {
+		return ""
+	}
+	return session.UserID
+}
+
+// endSession ends the request's session, if it has one
+func endSession(r *http.Request) {
+	cookie, err := r.Cookie(CookieName)
+	if err != nil || !strings.HasPrefix(cookie.Value, SessionTokenPrefix) {
+		return
+	}
+	if err := sessionStore.Delete(r.Context(), hashSessionToken(cookie.Value)); err != nil {
+		log.Printf("Error ending session: %v", err)
+	}
+}
+
+// endUserSessions signs the user out everywhere
+func endUserSessions(ctx context.Context, userID string) {
+	if err := sessionStore.DeleteUser(ctx, userID); err != nil {
+		log.Printf("Error ending sessions of %s: %v", userID, err)
+	}
+}
+
+// mongoSessionStore keeps sessions in the database, which drops them once
+// they expire
+type mongoSessionStore struct{}
+
+func (mongoSessionStore) Create(ctx context.Context, session Session) error {
+	_, err := db.Sessions.InsertOne(ctx, session)
+	return err
+}
+
+func (mongoSessionStore) Touch(ctx context.Context, id string, now time.Time, idle time.Duration) (*Session, error) {
+	var session Session
+	err := db.Sessions.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "expiresAt": bson.M{"$gt": now}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{
+			"lastSeenAt": now,
+			"expiresAt":  bson.M{"$min": bson.A{now.Add(idle), "$maxExpiresAt"}},
+		}}}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&session)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (mongoSessionStore) Delete(ctx context.Context, id string) error {
+	_, err := db.Sessions.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+func (mongoSessionStore) DeleteUser(ctx context.Context, userID string) error {
+	_, err := db.Sessions.DeleteMany(ctx, bson.M{"userId": userID})
+	return err
+}
+
+// redisSessionStore keeps each session under its own key, expiring with the
+// session, and a set of each user's session IDs
+type redisSessionStore struct {
+	client *redis.Client
+}
+
+func newRedisSessionStore(redisURL string) (*redisSessionStore, error) {
+	client, err := newRedisClient(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	return &redisSessionStore{client: client}, nil
+}
+
+func (s *redisSessionStore) Create(ctx context.Context, session Session) error {
+	payload, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	userKey := sessionUserKeyPrefix + session.UserID
+	pipe := s.client.TxPipeline()
+	pipe.Set(ctx, sessionKeyPrefix+session.ID, payload, time.Until(session.ExpiresAt))
+	pipe.SAdd(ctx, userKey, session.ID)
+	// The newest session outlives the others
+	pipe.PExpireAt(ctx, userKey, session.MaxExpiresAt)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisSessionStore) Touch(ctx context.Context, id string, now time.Time, idle time.Duration) (*Session, error) {
+	key := sessionKeyPrefix + id
+	payload, err := s.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var session Session
+	if err := json.Unmarshal(payload, &session); err != nil {
+		return nil, err
+	}
+	if !db.InTenant(ctx, session.TenantID) || !session.ExpiresAt.After(now) {
+		return nil, nil
+	}
+
+	session.LastSeenAt = now
+	session.ExpiresAt = now.Add(idle)
+	if session.ExpiresAt.After(session.MaxExpiresAt) {
+		session.ExpiresAt = session.MaxExpiresAt
+	}
+	if payload, err = json.Marshal(session); err != nil {
+		return nil, err
+	}
+	// XX: a session ended meanwhile stays ended
+	extended, err := s.client.SetXX(ctx, key, payload, session.ExpiresAt.Sub(now)).Result()
+	if err != nil || !extended {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s *redisSessionStore) Delete(ctx context.Context, id string) error {
+	return s.client.Del(ctx, sessionKeyPrefix+id).Err()
+}
+
+func (s *redisSessionStore) DeleteUser(ctx context.Context, userID string) error {
+	userKey := sessionUserKeyPrefix + userID
+	ids, err := s.client.SMembers(ctx, userKey).Result()
+	if err != nil {
+		return err
+	}
+	keys := []string{userKey}
+	for _, id := range ids {
+		keys = append(keys, sessionKeyPrefix+id)
+	}
+	return s.client.Del(ctx, keys...).Err()
+}
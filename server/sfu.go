@@ -0,0 +1,685 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/interceptor/pkg/gcc"
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+)
+
+// SFU mode: instead of a full peer-to-peer mesh, each participant opens one
+// PeerConnection to the server, which forwards RTP to everyone else in the
+// meeting. Enabled with SFU_MODE=true; signaling runs over the meeting WebSocket.
+
+const (
+	audioLevelURI        = "urn:ietf:params:rtp-hdrext:ssrc-audio-level"
+	sfuSignalMaxAttempts = 25
+	sfuSignalRetryDelay  = 3 * time.Second
+	sfuKeyFrameInterval  = 3 * time.Second
+	// sfuICERestartTimeout is how long a failed connection has to recover
+	// through an ICE restart before it is closed
+	sfuICERestartTimeout = 15 * time.Second
+	DefaultSTUNServer    = "stun:stun.l.google.com:19302"
+)
+
+// sfu is nil unless SFU mode is enabled
+var sfu *SFU
+
+// SFU routes media between the participants of each meeting
+type SFU struct {
+	api   *webrtc.API
+	mu    sync.Mutex
+	rooms map[string]*sfuRoom
+
+	// The congestion controller hands over each PeerConnection's bandwidth
+	// estimator while the connection is created
+	pcMu      sync.Mutex
+	estimator cc.BandwidthEstimator
+}
+
+// sfuRoom holds the peer connections and forwarded tracks of one meeting
+type sfuRoom struct {
+	meetingID   string
+	mu          sync.Mutex
+	peers       map[*Client]*webrtc.PeerConnection
+	tracks      map[string]*sfuTrack // track ID -> forwarded track
+	subscribers map[*Client]*sfuSubscriber
+	capped      map[*Client]bool // publishers held to a bitrate cap by REMB
+	relays      map[*Client]*sfuDataRelay
+	speakers    *activeSpeakerDetector
+	talkTime    *talkTimeTracker
+	done        chan struct{}
+	// Set while the host has transcription on
+	transcribing atomic.Bool
+}
+
+// sfuTrack is a publisher's track re-sent to every subscriber
+type sfuTrack struct {
+	local     *webrtc.TrackLocalStaticRTP // nil for simulcast tracks
+	publisher *Client
+	kind      webrtc.RTPCodecType
+
+	// Simulcast tracks are sent to each subscriber on its own down track,
+	// carrying the layer chosen for it
+	codec      webrtc.RTPCodecCapability
+	streamID   string
+	mu         sync.RWMutex
+	layers     []*sfuLayer
+	downTracks map[*Client]*sfuDownTrack
+}
+
+func newSFU() (*SFU, error) {
+	mediaEngine := &webrtc.MediaEngine{}
+	if err := mediaEngine.RegisterDefaultCodecs(); err != nil {
+		return nil, err
+	}
+	// Clients put their microphone level in every audio packet; the SFU reads it
+	// for active speaker detection
+	if err := mediaEngine.RegisterHeaderExtension(
+		webrtc.RTPHeaderExtensionCapability{URI: audioLevelURI}, webrtc.RTPCodecTypeAudio,
+	); err != nil {
+		return nil, err
+	}
+	// Simulcast layers are told apart by their mid and RTP stream ID
+	if err := webrtc.ConfigureSimulcastExtensionHeaders(mediaEngine); err != nil {
+		return nil, err
+	}
+
+	registry := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(mediaEngine, registry); err != nil {
+		return nil, err
+	}
+
+	s := &SFU{rooms: make(map[string]*sfuRoom)}
+
+	// Estimate each peer's downlink from its TWCC feedback, to choose the
+	// simulcast layers it receives. Packets are not paced.
+	congestion, err := cc.NewInterceptor(func() (cc.BandwidthEstimator, error) {
+		return gcc.NewSendSideBWE(
+			gcc.SendSideBWEInitialBitrate(sfuInitialBitrate),
+			gcc.SendSideBWEPacer(gcc.NewNoOpPacer()),
+		)
+	})
+	if err != nil {
+		return nil, err
+	}
+	congestion.OnNewPeerConnection(func(_ string, estimator cc.BandwidthEstimator) {
+		s.estimator = estimator
+	})
+	registry.Add(congestion)
+	if err := webrtc.ConfigureTWCCHeaderExtensionSender(mediaEngine, registry); err != nil {
+		return nil, err
+	}
+
+	s.api = webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine), webrtc.WithInterceptorRegistry(registry))
+	return s, nil
+}
+
+// iceServers returns the ICE servers offered to SFU peer connections
+func iceServers() []webrtc.ICEServer {
+	return []webrtc.ICEServer{{URLs: []string{DefaultSTUNServer}}}
+}
+
+// room returns the meeting's room, creating it on first use
+func (s *SFU) room(meetingID string) *sfuRoom {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	room, ok := s.rooms[meetingID]
+	if !ok {
+		room = &sfuRoom{
+			meetingID:   meetingID,
+			peers:       make(map[*Client]*webrtc.PeerConnection),
+			tracks:      make(map[string]*sfuTrack),
+			subscribers: make(map[*Client]*sfuSubscriber),
+			capped:      make(map[*Client]bool),
+			relays:      make(map[*Client]*sfuDataRelay),
+			speakers:    newActiveSpeakerDetector(),
+			talkTime:    newTalkTimeTracker(meetingID),
+			done:        make(chan struct{}),
+		}
+		s.rooms[meetingID] = room
+		go room.run()
+	}
+	return room
+}
+
+// removeRoomIfEmpty drops a room once its last peer has gone
+func (s *SFU) removeRoomIfEmpty(room *sfuRoom) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	room.mu.Lock()
+	empty := len(room.peers) == 0
+	room.mu.Unlock()
+
+	if empty && s.rooms[room.meetingID] == room {
+		delete(s.rooms, room.meetingID)
+		close(room.done)
+	}
+}
+
+// peerConnection returns the client's SFU connection, if any
+func (s *SFU) peerConnection(c *Client) *webrtc.PeerConnection {
+	s.mu.Lock()
+	room, ok := s.rooms[c.meetingID]
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	return room.peers[c]
+}
+
+// newPeerConnection creates a PeerConnection along with the estimator of the
+// bandwidth towards it
+func (s *SFU) newPeerConnection() (*webrtc.PeerConnection, cc.BandwidthEstimator, error) {
+	s.pcMu.Lock()
+	defer s.pcMu.Unlock()
+
+	pc, err := s.api.NewPeerConnection(webrtc.Configuration{ICEServers: iceServers()})
+	estimator := s.estimator
+	s.estimator = nil
+	return pc, estimator, err
+}
+
+// Join creates the client's PeerConnection and sends it an offer containing
+// every track currently published in the meeting. A client publishing
+// simulcast sends its own offer first, which is answered before that.
+func (s *SFU) Join(c *Client, offer *webrtc.SessionDescription) error {
+	if s.peerConnection(c) != nil {
+		return errors.New("already connected to the SFU")
+	}
+
+	pc, estimator, err := s.newPeerConnection()
+	if err != nil {
+		return err
+	}
+
+	// Accept one audio and one video track from the client, unless it
+	// offered its own
+	if offer == nil {
+		for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeAudio, webrtc.RTPCodecTypeVideo} {
+			if _, err := pc.AddTransceiverFromKind(kind, webrtc.RTPTransceiverInit{
+				Direction: webrtc.RTPTransceiverDirectionRecvonly,
+			}); err != nil {
+				pc.Close()
+				return err
+			}
+		}
+	}
+
+	room := s.room(c.meetingID)
+	if transcriber != nil {
+		if meeting, err := findMeeting(context.Background(), c.meetingID); err == nil {
+			room.transcribing.Store(meeting.TranscriptionEnabled)
+		}
+	}
+
+	pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		if candidate == nil {
+			return
+		}
+		c.hub.SendToClient(c, WebSocketMessage{Type: "sfu-candidate", Data: candidate.ToJSON()})
+	})
+
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		switch state {
+		case webrtc.PeerConnectionStateConnected:
+			// Video resumes sooner after an ICE restart with a fresh key frame
+			go room.requestKeyFrames()
+		case webrtc.PeerConnectionStateFailed:
+			// Usually the client's network changed, e.g. from Wi-Fi to LTE
+			go func() {
+				if err := s.RestartICE(c); err != nil {
+					pc.Close()
+					return
+				}
+				time.AfterFunc(sfuICERestartTimeout, func() {
+					if pc.ConnectionState() != webrtc.PeerConnectionStateConnected {
+						pc.Close()
+					}
+				})
+			}()
+		case webrtc.PeerConnectionStateClosed:
+			s.Leave(c)
+		}
+	})
+
+	pc.OnTrack(func(remote *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		if remote.RID() != "" {
+			room.forwardSimulcastLayer(c, remote)
+			return
+		}
+		room.forwardTrack(c, remote, receiver)
+	})
+
+	relay, err := room.newDataRelay(c, pc)
+	if err != nil {
+		pc.Close()
+		return err
+	}
+	room.relayDataChannels(c, relay)
+
+	if offer != nil {
+		if err := pc.SetRemoteDescription(*offer); err != nil {
+			pc.Close()
+			return err
+		}
+		answer, err := pc.CreateAnswer(nil)
+		if err == nil {
+			err = pc.SetLocalDescription(answer)
+		}
+		if err != nil {
+			pc.Close()
+			return err
+		}
+		c.hub.SendToClient(c, WebSocketMessage{Type: "sfu-answer", Data: answer})
+	}
+
+	room.mu.Lock()
+	room.peers[c] = pc
+	room.subscribers[c] = &sfuSubscriber{estimator: estimator}
+	room.relays[c] = relay
+	room.mu.Unlock()
+
+	room.signalPeers()
+	return nil
+}
+
+// Leave closes the client's PeerConnection and stops forwarding its tracks
+func (s *SFU) Leave(c *Client) {
+	s.mu.Lock()
+	room, ok := s.rooms[c.meetingID]
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	room.mu.Lock()
+	pc, ok := room.peers[c]
+	delete(room.peers, c)
+	delete(room.subscribers, c)
+	delete(room.capped, c)
+	delete(room.relays, c)
+	for id, track := range room.tracks {
+		if track.publisher == c {
+			delete(room.tracks, id)
+			continue
+		}
+		track.mu.Lock()
+		delete(track.downTracks, c)
+		track.mu.Unlock()
+	}
+	room.mu.Unlock()
+
+	if !ok {
+		return
+	}
+	room.speakers.remove(c)
+	pc.Close()
+
+	room.signalPeers()
+	s.removeRoomIfEmpty(room)
+}
+
+// SetTranscribing turns transcription of the meeting's audio on or off
+func (s *SFU) SetTranscribing(meetingID string, enabled bool) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	room, ok := s.rooms[meetingID]
+	s.mu.Unlock()
+	if ok {
+		room.transcribing.Store(enabled)
+	}
+}
+
+// HandleAnswer applies the client's answer to the last server offer
+func (s *SFU) HandleAnswer(c *Client, answer webrtc.SessionDescription) error {
+	pc := s.peerConnection(c)
+	if pc == nil {
+		return errors.New("not connected to the SFU")
+	}
+	return pc.SetRemoteDescription(answer)
+}
+
+// HandleCandidate adds a remote ICE candidate from the client
+func (s *SFU) HandleCandidate(c *Client, candidate webrtc.ICECandidateInit) error {
+	pc := s.peerConnection(c)
+	if pc == nil {
+		return errors.New("not connected to the SFU")
+	}
+	return pc.AddICECandidate(candidate)
+}
+
+// RestartICE sends the client an offer with fresh ICE credentials, so its
+// connection recovers from a network change without rejoining
+func (s *SFU) RestartICE(c *Client) error {
+	s.mu.Lock()
+	room, ok := s.rooms[c.meetingID]
+	s.mu.Unlock()
+	if !ok {
+		return errors.New("not connected to the SFU")
+	}
+
+	// Offers to the room's peers are made under its lock
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	pc, ok := room.peers[c]
+	if !ok {
+		return errors.New("not connected to the SFU")
+	}
+
+	offer, err := pc.CreateOffer(&webrtc.OfferOptions{ICERestart: true})
+	if err != nil {
+		return err
+	}
+	if err := pc.SetLocalDescription(offer); err != nil {
+		return err
+	}
+	c.hub.SendToClient(c, WebSocketMessage{Type: "sfu-offer", Data: offer})
+	return nil
+}
+
+// forwardTrack republishes a remote track to the other peers and pumps RTP to
+// it until the publisher goes away
+func (room *sfuRoom) forwardTrack(publisher *Client, remote *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+	local, err := webrtc.NewTrackLocalStaticRTP(remote.Codec().RTPCodecCapability, remote.ID(), remote.StreamID())
+	if err != nil {
+		log.Printf("SFU: error creating local track: %v", err)
+		return
+	}
+
+	room.mu.Lock()
+	room.tracks[local.ID()] = &sfuTrack{local: local, publisher: publisher, kind: remote.Kind()}
+	room.mu.Unlock()
+	room.signalPeers()
+
+	defer func() {
+		room.mu.Lock()
+		delete(room.tracks, local.ID())
+		room.mu.Unlock()
+		room.signalPeers()
+	}()
+
+	audioLevelID := uint8(0)
+	if remote.Kind() == webrtc.RTPCodecTypeAudio {
+		for _, ext := range receiver.GetParameters().HeaderExtensions {
+			if ext.URI == audioLevelURI {
+				audioLevelID = uint8(ext.ID)
+			}
+		}
+	}
+
+	// Open but quiet mics are not forwarded, which matters in large meetings
+	var gate *silenceGate
+	if audioLevelID != 0 {
+		gate = newSilenceGate()
+	}
+
+	// Audio is cut into speech segments for the transcriber while transcription is on
+	var segmenter *audioSegmenter
+	defer func() {
+		if segmenter != nil {
+			segmenter.flush()
+		}
+	}()
+
+	for {
+		packet, _, err := remote.ReadRTP()
+		if err != nil {
+			return
+		}
+
+		var level uint8
+		var hasLevel bool
+		if audioLevelID != 0 {
+			if level, hasLevel = readAudioLevel(packet, audioLevelID); hasLevel {
+				room.speakers.observe(publisher, level)
+			}
+		}
+
+		if transcriber != nil && remote.Kind() == webrtc.RTPCodecTypeAudio {
+			if room.transcribing.Load() {
+				if segmenter == nil {
+					segmenter = newAudioSegmenter(room.meetingID, publisher)
+				}
+				segmenter.write(packet, level, hasLevel, time.Now())
+			} else if segmenter != nil {
+				segmenter.flush()
+				segmenter = nil
+			}
+		}
+
+		if gate != nil && !gate.pass(packet, level, hasLevel, time.Now()) {
+			continue
+		}
+		if err := local.WriteRTP(packet); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+			return
+		}
+	}
+}
+
+// signalPeers brings every PeerConnection in line with the room's tracks and
+// sends each client a fresh offer
+func (room *sfuRoom) signalPeers() {
+	room.mu.Lock()
+	defer func() {
+		room.mu.Unlock()
+		room.requestKeyFrames()
+	}()
+
+	for attempt := 0; ; attempt++ {
+		if attempt == sfuSignalMaxAttempts {
+			// Give up for now and try again shortly, e.g. after a pending negotiation settles
+			go func() {
+				time.Sleep(sfuSignalRetryDelay)
+				room.signalPeers()
+			}()
+			return
+		}
+		if !room.attemptSync() {
+			return
+		}
+	}
+}
+
+// attemptSync adds and removes senders so each peer receives every track but
+// its own, returning true if the sync must be retried. Caller holds room.mu.
+func (room *sfuRoom) attemptSync() bool {
+	for client, pc := range room.peers {
+		if pc.ConnectionState() == webrtc.PeerConnectionStateClosed {
+			delete(room.peers, client)
+			return true
+		}
+
+		existing := map[string]bool{}
+		for _, sender := range pc.GetSenders() {
+			if sender.Track() == nil {
+				continue
+			}
+			existing[sender.Track().ID()] = true
+			if _, ok := room.tracks[sender.Track().ID()]; !ok {
+				if err := pc.RemoveTrack(sender); err != nil {
+					return true
+				}
+			}
+		}
+
+		// Never send a peer's own tracks back to it
+		for _, receiver := range pc.GetReceivers() {
+			if receiver.Track() != nil {
+				existing[receiver.Track().ID()] = true
+			}
+		}
+
+		for id, track := range room.tracks {
+			if existing[id] {
+				continue
+			}
+			var local webrtc.TrackLocal = track.local
+			if track.local == nil {
+				down, err := track.downTrack(id, client)
+				if err != nil {
+					return true
+				}
+				local = down.local
+			}
+			sender, err := pc.AddTrack(local)
+			if err != nil {
+				return true
+			}
+			go room.readSenderRTCP(client, track, sender)
+		}
+
+		offer, err := pc.CreateOffer(nil)
+		if err != nil {
+			return true
+		}
+		if err := pc.SetLocalDescription(offer); err != nil {
+			return true
+		}
+
+		client.hub.SendToClient(client, WebSocketMessage{Type: "sfu-offer", Data: offer})
+	}
+	return false
+}
+
+// requestKeyFrames asks every publisher for a key frame so new subscribers can
+// start decoding video immediately
+func (room *sfuRoom) requestKeyFrames() {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	for _, pc := range room.peers {
+		for _, receiver := range pc.GetReceivers() {
+			// Simulcast receivers have a track per layer
+			for _, track := range receiver.Tracks() {
+				pc.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(track.SSRC())}})
+			}
+		}
+	}
+}
+
+// run performs periodic room work until the room is removed
+func (room *sfuRoom) run() {
+	keyFrames := time.NewTicker(sfuKeyFrameInterval)
+	speakers := time.NewTicker(ActiveSpeakerInterval)
+	talkTime := time.NewTicker(TalkTimeFlushInterval)
+	layers := time.NewTicker(sfuLayerInterval)
+	caps := time.NewTicker(sfuBitrateCapInterval)
+	defer keyFrames.Stop()
+	defer speakers.Stop()
+	defer talkTime.Stop()
+	defer layers.Stop()
+	defer caps.Stop()
+
+	for {
+		select {
+		case <-room.done:
+			room.talkTime.flush(context.Background())
+			return
+		case <-keyFrames.C:
+			room.requestKeyFrames()
+		case <-talkTime.C:
+			room.talkTime.flush(context.Background())
+		case <-layers.C:
+			room.measureLayers(sfuLayerInterval)
+			room.assignLayers()
+		case <-caps.C:
+			room.enforceBitrateCaps(context.Background())
+		case now := <-speakers.C:
+			room.talkTime.sample(room.speakers.speaking(now), ActiveSpeakerInterval, now)
+			if speaker, level, changed := room.speakers.evaluate(time.Now()); changed {
+				hub.BroadcastToMeeting(room.meetingID, WebSocketMessage{
+					Type: "active-speaker",
+					Data: map[string]interface{}{
+						"userId": speaker.userID,
+						"peerId": speaker.peerID,
+						"level":  level,
+					},
+				}, nil)
+			}
+		}
+	}
+}
+
+// handleSFUJoin connects the client to the meeting's SFU room. The client may
+// include its own offer, e.g. to publish simulcast.
+func handleSFUJoin(ctx context.Context, c *Client, data json.RawMessage) {
+	if sfu == nil {
+		c.sendError("SFU mode is not enabled")
+		return
+	}
+	var req struct {
+		Offer *webrtc.SessionDescription `json:"offer"`
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &req); err != nil {
+			c.sendError("Invalid SFU join")
+			return
+		}
+	}
+	if err := sfu.Join(c, req.Offer); err != nil {
+		log.Printf("SFU: join failed for %s: %v", c.userID, err)
+		c.sendError("Failed to join SFU: " + err.Error())
+	}
+}
+
+// handleSFUAnswer applies the client's answer to a server offer
+func handleSFUAnswer(ctx context.Context, c *Client, data json.RawMessage) {
+	if sfu == nil {
+		c.sendError("SFU mode is not enabled")
+		return
+	}
+	var answer webrtc.SessionDescription
+	if err := json.Unmarshal(data, &answer); err != nil {
+		c.sendError("Invalid SFU answer")
+		return
+	}
+	if err := sfu.HandleAnswer(c, answer); err != nil {
+		c.sendError("Failed to apply SFU answer: " + err.Error())
+	}
+}
+
+// handleSFUICERestart restarts ICE on the client's SFU connection, which it
+// asks for when its network changes
+func handleSFUICERestart(ctx context.Context, c *Client, data json.RawMessage) {
+	if sfu == nil {
+		c.sendError("SFU mode is not enabled")
+		return
+	}
+	if err := sfu.RestartICE(c); err != nil {
+		c.sendError("Failed to restart ICE: " + err.Error())
+	}
+}
+
+// handleSFUCandidate adds an ICE candidate from the client
+func handleSFUCandidate(ctx context.Context, c *Client, data json.RawMessage) {
+	if sfu == nil {
+		c.sendError("SFU mode is not enabled")
+		return
+	}
+	var candidate webrtc.ICECandidateInit
+	if err := json.Unmarshal(data, &candidate); err != nil {
+		c.sendError("Invalid SFU candidate")
+		return
+	}
+	if err := sfu.HandleCandidate(c, candidate); err != nil {
+		c.sendError("Failed to add SFU candidate: " + err.Error())
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// In SFU mode app messages (whiteboard strokes, cursor positions) travel over
+// data channels to the server, which relays them to the other participants on
+// the channel with the same label, so clients need no data channel per pair.
+// Every client gets an "app" channel; it may open more, e.g. an unordered
+// "cursor" channel, and the server opens them towards the others with the
+// same reliability.
+
+const (
+	sfuAppChannel        = "app"
+	sfuMaxDataChannels   = 8
+	sfuMaxDataLabelBytes = 64
+)
+
+// sfuDataRelay holds a participant's data channels, by label, and the
+// budgets of the app messages it sends on them
+type sfuDataRelay struct {
+	pc       *webrtc.PeerConnection
+	mu       sync.Mutex
+	channels map[string]*webrtc.DataChannel
+	// Buckets are per channel and die with the connection, so they are never evicted
+	limiter *RateLimiter
+}
+
+// relayedAppMessage is an app message as delivered to the other participants
+type relayedAppMessage struct {
+	UserID string          `json:"userId"`
+	PeerID string          `json:"peerId"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// newDataRelay opens the client's "app" channel and accepts the channels it
+// opens itself
+func (room *sfuRoom) newDataRelay(c *Client, pc *webrtc.PeerConnection) (*sfuDataRelay, error) {
+	relay := &sfuDataRelay{
+		pc:       pc,
+		channels: make(map[string]*webrtc.DataChannel),
+		limiter:  newRateLimiter(RateLimitPolicy{}, 0),
+	}
+	if _, err := room.ensureChannel(c, relay, sfuAppChannel, nil); err != nil {
+		return nil, err
+	}
+
+	pc.OnDataChannel(func(dc *webrtc.DataChannel) {
+		label := dc.Label()
+		relay.mu.Lock()
+		_, exists := relay.channels[label]
+		accepted := !exists && len(label) <= sfuMaxDataLabelBytes && len(relay.channels) < sfuMaxDataChannels
+		if accepted {
+			relay.channels[label] = dc
+		}
+		relay.mu.Unlock()
+		if !accepted {
+			dc.Close()
+			return
+		}
+
+		room.relayFrom(c, relay, dc)
+		// Open the channel towards everyone else now, so it is ready by the
+		// time the first message arrives
+		dc.OnOpen(func() {
+			room.openDataChannel(c, label, dataChannelInit(dc))
+		})
+	})
+	return relay, nil
+}
+
+// ensureChannel returns the client's channel with the label, opening it and
+// relaying what the client sends on it if it is new
+func (room *sfuRoom) ensureChannel(c *Client, relay *sfuDataRelay, label string, init *webrtc.DataChannelInit) (*webrtc.DataChannel, error) {
+	relay.mu.Lock()
+	defer relay.mu.Unlock()
+
+	if dc, ok := relay.channels[label]; ok {
+		return dc, nil
+	}
+	if len(relay.channels) >= sfuMaxDataChannels {
+		return nil, errors.New("too many data channels")
+	}
+	dc, err := relay.pc.CreateDataChannel(label, init)
+	if err != nil {
+		return nil, err
+	}
+	relay.channels[label] = dc
+	room.relayFrom(c, relay, dc)
+	return dc, nil
+}
+
+// labels returns the relay's channels, by label, with their settings
+func (relay *sfuDataRelay) labels() map[string]*webrtc.DataChannelInit {
+	relay.mu.Lock()
+	defer relay.mu.Unlock()
+
+	labels := make(map[string]*webrtc.DataChannelInit, len(relay.channels))
+	for label, dc := range relay.channels {
+		labels[label] = dataChannelInit(dc)
+	}
+	return labels
+}
+
+// dataChannelInit returns the settings to open a channel like dc with
+func dataChannelInit(dc *webrtc.DataChannel) *webrtc.DataChannelInit {
+	ordered := dc.Ordered()
+	return &webrtc.DataChannelInit{
+		Ordered:           &ordered,
+		MaxPacketLifeTime: dc.MaxPacketLifeTime(),
+		MaxRetransmits:    dc.MaxRetransmits(),
+	}
+}
+
+// relayDataChannels opens, on a newly joined client's connection, the
+// channels the other participants use
+func (room *sfuRoom) relayDataChannels(c *Client, relay *sfuDataRelay) {
+	labels := map[string]*webrtc.DataChannelInit{}
+	for _, other := range room.dataRelays(c) {
+		for label, init := range other.labels() {
+			labels[label] = init
+		}
+	}
+	for label, init := range labels {
+		room.ensureChannel(c, relay, label, init)
+	}
+}
+
+// openDataChannel opens a channel a client opened towards everyone else
+func (room *sfuRoom) openDataChannel(from *Client, label string, init *webrtc.DataChannelInit) {
+	for client, relay := range room.dataRelays(from) {
+		room.ensureChannel(client, relay, label, init)
+	}
+}
+
+// dataRelays returns the data relays of every client but one
+func (room *sfuRoom) dataRelays(except *Client) map[*Client]*sfuDataRelay {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	relays := make(map[*Client]*sfuDataRelay, len(room.relays))
+	for client, relay := range room.relays {
+		if client != except {
+			relays[client] = relay
+		}
+	}
+	return relays
+}
+
+// relayFrom relays the app messages a client sends on a channel to the other
+// participants. Messages must be JSON text and within the channel's budget;
+// the rest are dropped.
+func (room *sfuRoom) relayFrom(c *Client, relay *sfuDataRelay, dc *webrtc.DataChannel) {
+	label := dc.Label()
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		name, budget := appConfig.RateLimit.DataChannels.Budget(label)
+		if !msg.IsString || len(msg.Data) > budget.MaxBytes || !json.Valid(msg.Data) {
+			return
+		}
+		policy := RateLimitPolicy{Name: name, RequestsPerMinute: budget.PerMinute, Burst: budget.Burst}
+		if ok, _, _ := relay.limiter.Allow(policy, label, time.Now()); !ok {
+			return
+		}
+
+		payload, err := json.Marshal(relayedAppMessage{UserID: c.userID, PeerID: c.peerID, Data: msg.Data})
+		if err != nil {
+			return
+		}
+		init := dataChannelInit(dc)
+		for client, other := range room.dataRelays(c) {
+			out, err := room.ensureChannel(client, other, label, init)
+			if err != nil || out.ReadyState() != webrtc.DataChannelStateOpen {
+				continue
+			}
+			if err := out.SendText(string(payload)); err != nil {
+				log.Printf("SFU: error relaying %s message in meeting %s: %v", label, room.meetingID, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// sfuSilenceHold is how long an audio track must stay silent before its
+// packets stop being forwarded, so the tails of words are not cut off
+const sfuSilenceHold = 1 * time.Second
+
+// silenceGate stops forwarding an audio track while its RTP audio levels say
+// it is silent. Sequence numbers are rewritten so subscribers see the pause
+// as silence rather than loss, and the first packet after one is marked as
+// the start of a talkspurt.
+type silenceGate struct {
+	maxLevel  uint8 // -dBov; quieter packets are silent
+	lastSound time.Time
+	closed    bool
+	dropped   uint16
+}
+
+// newSilenceGate returns the gate for an audio track, or nil when silence
+// suppression is off
+func newSilenceGate() *silenceGate {
+	level := appConfig.Media.SilenceLevel
+	if level <= 0 {
+		return nil
+	}
+	return &silenceGate{maxLevel: uint8(level)}
+}
+
+// pass reports whether to forward the packet, renumbering it if so. Packets
+// without a level are always forwarded.
+func (g *silenceGate) pass(packet *rtp.Packet, level uint8, hasLevel bool, now time.Time) bool {
+	switch {
+	case !hasLevel || level <= g.maxLevel:
+		g.lastSound = now
+		if g.closed {
+			g.closed = false
+			packet.Marker = true
+		}
+	case now.Sub(g.lastSound) >= sfuSilenceHold:
+		g.closed = true
+	}
+
+	if g.closed {
+		g.dropped++
+		return false
+	}
+	packet.SequenceNumber -= g.dropped
+	return true
+}
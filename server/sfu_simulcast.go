@@ -0,0 +1,708 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// Simulcast: a publisher may send its video as several encodings ("layers",
+// told apart by their RTP stream ID). Each subscriber gets its own down track
+// carrying one layer, chosen from the subscriber's bandwidth (its own hint,
+// REMB and the TWCC-based estimate) and the size it renders the video at.
+// Switches wait for a key frame on the new layer, and sequence numbers,
+// timestamps and VP8 picture IDs are rewritten so the subscriber sees one
+// continuous stream.
+
+const (
+	// sfuLayerInterval is how often layers are measured and reassigned
+	sfuLayerInterval = 1 * time.Second
+	// sfuInitialBitrate is the downlink estimate before TWCC feedback arrives
+	sfuInitialBitrate = 1_000_000
+	// Subscribers rendering a video no wider than these get the low or middle layer
+	sfuLowLayerMaxWidth = 320
+	sfuMidLayerMaxWidth = 640
+	// sfuAllTemporalLayers forwards every temporal layer
+	sfuAllTemporalLayers = 0xff
+	MaxSFUViewports      = 100
+)
+
+// sfuDefaultLayerBitrates stand in for layers not measured yet, lowest first
+var sfuDefaultLayerBitrates = []int64{150_000, 500_000, 1_500_000}
+
+// sfuLayer is one simulcast encoding of a publisher's video
+type sfuLayer struct {
+	rid     string
+	ssrc    uint32
+	bytes   atomic.Int64 // received since the last measurement
+	bitrate atomic.Int64 // bits per second over the last interval
+}
+
+// sfuViewport is the size a subscriber renders a video at
+type sfuViewport struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// sfuSubscriber is what the room knows about a peer's downlink
+type sfuSubscriber struct {
+	estimator cc.BandwidthEstimator // from TWCC feedback; nil if unavailable
+	remb      atomic.Int64          // latest REMB from the peer, bits per second
+
+	mu        sync.Mutex
+	hint      int64                  // bits per second the client says it can take; 0 if unknown
+	viewports map[string]sfuViewport // track ID -> rendered size; unset tracks get any layer
+}
+
+// bandwidth returns the lowest of the subscriber's downlink estimates, or 0
+// if there are none
+func (s *sfuSubscriber) bandwidth() int64 {
+	s.mu.Lock()
+	estimates := []int64{s.hint, s.remb.Load()}
+	s.mu.Unlock()
+	if s.estimator != nil {
+		estimates = append(estimates, int64(s.estimator.GetTargetBitrate()))
+	}
+
+	var lowest int64
+	for _, estimate := range estimates {
+		if estimate > 0 && (lowest == 0 || estimate < lowest) {
+			lowest = estimate
+		}
+	}
+	return lowest
+}
+
+// layerCap returns the highest of a track's layers worth sending for how
+// the subscriber renders it, or -1 if it isn't shown
+func (s *sfuSubscriber) layerCap(trackID string, layers int) int {
+	s.mu.Lock()
+	viewport, ok := s.viewports[trackID]
+	s.mu.Unlock()
+	if !ok {
+		return layers - 1
+	}
+
+	size := max(viewport.Width, viewport.Height*16/9)
+	switch {
+	case size <= 0:
+		return -1
+	case size <= sfuLowLayerMaxWidth:
+		return 0
+	case size <= sfuMidLayerMaxWidth:
+		return min(1, layers-1)
+	default:
+		return layers - 1
+	}
+}
+
+// sfuDownTrack carries one layer of a simulcast track to one subscriber
+type sfuDownTrack struct {
+	local     *webrtc.TrackLocalStaticRTP
+	clockRate uint32
+	mimeType  string
+
+	mu          sync.Mutex
+	current     string // layer being forwarded; empty until its first key frame
+	target      string // layer to switch to at its next key frame; empty pauses
+	maxTemporal uint8
+
+	// Rewriting state, so switches and dropped frames leave no gaps
+	started     bool
+	resync      bool // the next packet starts a layer
+	lastSeq     uint16
+	lastTS      uint32
+	lastSentAt  time.Time
+	seqOffset   uint16
+	tsOffset    uint32
+	lastPicID   uint16
+	picIDOffset uint16
+	lastTL0     uint8
+	tl0Offset   uint8
+}
+
+// setTarget chooses the layer and temporal layers to forward, returning
+// whether the layer changed
+func (d *sfuDownTrack) setTarget(rid string, maxTemporal uint8) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.maxTemporal = maxTemporal
+	if rid == d.target {
+		return false
+	}
+	d.target = rid
+	if rid == "" {
+		d.current = ""
+	}
+	return true
+}
+
+// write forwards a packet if it belongs to the layer the subscriber receives,
+// switching to the target layer at its first key frame
+func (d *sfuDownTrack) write(rid string, packet *rtp.Packet) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if rid != d.current {
+		if rid != d.target || !isKeyFrame(d.mimeType, packet.Payload) {
+			return nil
+		}
+		d.current = rid
+		d.resync = true
+	}
+
+	vp8, hasVP8 := vp8Descriptor{}, false
+	if strings.EqualFold(d.mimeType, webrtc.MimeTypeVP8) {
+		vp8, hasVP8 = parseVP8Descriptor(packet.Payload)
+	}
+
+	if d.resync {
+		// Continue the numbering of the last packet sent, on whichever layer
+		if d.started {
+			elapsed := uint32(time.Since(d.lastSentAt).Seconds() * float64(d.clockRate))
+			d.seqOffset = packet.SequenceNumber - d.lastSeq - 1
+			d.tsOffset = packet.Timestamp - d.lastTS - max(elapsed, 1)
+			if hasVP8 {
+				d.picIDOffset = vp8.pictureID - d.lastPicID - 1
+				d.tl0Offset = vp8.tl0 - d.lastTL0 - 1
+			}
+		}
+		d.started = true
+		d.resync = false
+	}
+
+	if hasVP8 && vp8.hasTID && vp8.tid > d.maxTemporal {
+		// Later packets move up so the dropped one leaves no gap
+		d.seqOffset++
+		if vp8.startOfFrame {
+			d.picIDOffset++
+		}
+		return nil
+	}
+
+	// Header extension IDs were negotiated with the publisher, not the
+	// subscriber, so none are forwarded
+	out := rtp.Packet{Header: packet.Header, Payload: packet.Payload}
+	out.Header.Extension = false
+	out.Header.Extensions = nil
+	out.SequenceNumber = packet.SequenceNumber - d.seqOffset
+	out.Timestamp = packet.Timestamp - d.tsOffset
+	if hasVP8 && (vp8.pictureIDAt > 0 || vp8.tl0At > 0) {
+		out.Payload = slices.Clone(packet.Payload)
+		d.lastPicID = vp8.setPictureID(out.Payload, vp8.pictureID-d.picIDOffset)
+		d.lastTL0 = vp8.setTL0(out.Payload, vp8.tl0-d.tl0Offset)
+	}
+	d.lastSeq, d.lastTS, d.lastSentAt = out.SequenceNumber, out.Timestamp, time.Now()
+
+	return d.local.WriteRTP(&out)
+}
+
+// vp8Descriptor is the part of a VP8 payload descriptor the SFU reads (RFC 7741)
+type vp8Descriptor struct {
+	startOfFrame bool
+	keyFrame     bool
+	pictureIDAt  int // offset of the picture ID, 0 if absent
+	longID       bool
+	pictureID    uint16
+	tl0At        int // offset of TL0PICIDX, 0 if absent
+	tl0          uint8
+	hasTID       bool
+	tid          uint8
+}
+
+func parseVP8Descriptor(payload []byte) (vp8Descriptor, bool) {
+	var d vp8Descriptor
+	if len(payload) < 1 {
+		return d, false
+	}
+	first := payload[0]
+	i := 1
+	if first&0x80 != 0 { // X: extensions follow
+		if len(payload) <= i {
+			return d, false
+		}
+		ext := payload[i]
+		i++
+		if ext&0x80 != 0 { // I: picture ID
+			if len(payload) <= i {
+				return d, false
+			}
+			d.pictureIDAt = i
+			if payload[i]&0x80 != 0 {
+				if len(payload) <= i+1 {
+					return d, false
+				}
+				d.longID = true
+				d.pictureID = binary.BigEndian.Uint16(payload[i:]) & 0x7fff
+				i += 2
+			} else {
+				d.pictureID = uint16(payload[i])
+				i++
+			}
+		}
+		if ext&0x40 != 0 { // L: TL0PICIDX
+			if len(payload) <= i {
+				return d, false
+			}
+			d.tl0At = i
+			d.tl0 = payload[i]
+			i++
+		}
+		if ext&0x30 != 0 { // T or K: TID/KEYIDX byte
+			if len(payload) <= i {
+				return d, false
+			}
+			if ext&0x20 != 0 {
+				d.hasTID = true
+				d.tid = payload[i] >> 6
+			}
+			i++
+		}
+	}
+	if len(payload) <= i {
+		return d, false
+	}
+	d.startOfFrame = first&0x10 != 0 && first&0x07 == 0
+	d.keyFrame = d.startOfFrame && payload[i]&0x01 == 0
+	return d, true
+}
+
+// setPictureID writes a picture ID into the payload, returning it as written
+func (d vp8Descriptor) setPictureID(payload []byte, id uint16) uint16 {
+	switch {
+	case d.pictureIDAt == 0:
+		return id
+	case d.longID:
+		id &= 0x7fff
+		binary.BigEndian.PutUint16(payload[d.pictureIDAt:], 0x8000|id)
+	default:
+		id &= 0x7f
+		payload[d.pictureIDAt] = byte(id)
+	}
+	return id
+}
+
+func (d vp8Descriptor) setTL0(payload []byte, tl0 uint8) uint8 {
+	if d.tl0At > 0 {
+		payload[d.tl0At] = tl0
+	}
+	return tl0
+}
+
+// isKeyFrame reports whether a packet starts a key frame, so a subscriber can
+// start decoding there. Codecs the SFU can't parse may switch anywhere.
+// End-to-end encrypted frames keep these headers in the clear (see e2ee.go).
+func isKeyFrame(mimeType string, payload []byte) bool {
+	switch {
+	case strings.EqualFold(mimeType, webrtc.MimeTypeVP8):
+		d, ok := parseVP8Descriptor(payload)
+		return ok && d.keyFrame
+	case strings.EqualFold(mimeType, webrtc.MimeTypeH264):
+		return h264KeyFrame(payload)
+	}
+	return true
+}
+
+// h264KeyFrame reports whether a packet carries an IDR slice or SPS (RFC 6184)
+func h264KeyFrame(payload []byte) bool {
+	const (
+		naluIDR  = 5
+		naluSPS  = 7
+		naluSTAP = 24
+		naluFU   = 28
+	)
+	if len(payload) < 1 {
+		return false
+	}
+	switch nalu := payload[0] & 0x1f; nalu {
+	case naluIDR, naluSPS:
+		return true
+	case naluSTAP:
+		for i := 1; i+2 < len(payload); {
+			size := int(binary.BigEndian.Uint16(payload[i:]))
+			i += 2
+			if nalu := payload[i] & 0x1f; nalu == naluIDR || nalu == naluSPS {
+				return true
+			}
+			i += size
+		}
+	case naluFU:
+		if len(payload) > 1 && payload[1]&0x80 != 0 {
+			nalu := payload[1] & 0x1f
+			return nalu == naluIDR || nalu == naluSPS
+		}
+	}
+	return false
+}
+
+// forwardSimulcastLayer adds a layer to its track and forwards it to the
+// subscribers receiving it, until the publisher goes away
+func (room *sfuRoom) forwardSimulcastLayer(publisher *Client, remote *webrtc.TrackRemote) {
+	layer := &sfuLayer{rid: remote.RID(), ssrc: uint32(remote.SSRC())}
+
+	room.mu.Lock()
+	track, ok := room.tracks[remote.ID()]
+	if !ok {
+		track = &sfuTrack{
+			publisher:  publisher,
+			kind:       remote.Kind(),
+			codec:      remote.Codec().RTPCodecCapability,
+			streamID:   remote.StreamID(),
+			downTracks: make(map[*Client]*sfuDownTrack),
+		}
+		room.tracks[remote.ID()] = track
+	}
+	track.mu.Lock()
+	track.layers = append(track.layers, layer)
+	track.mu.Unlock()
+	room.mu.Unlock()
+	if !ok {
+		room.signalPeers()
+	}
+
+	defer func() {
+		room.mu.Lock()
+		track.mu.Lock()
+		track.layers = slices.DeleteFunc(track.layers, func(l *sfuLayer) bool { return l == layer })
+		last := len(track.layers) == 0
+		track.mu.Unlock()
+		if last && room.tracks[remote.ID()] == track {
+			delete(room.tracks, remote.ID())
+		}
+		room.mu.Unlock()
+		if last {
+			room.signalPeers()
+		}
+	}()
+
+	for {
+		packet, _, err := remote.ReadRTP()
+		if err != nil {
+			return
+		}
+		layer.bytes.Add(int64(len(packet.Payload)))
+
+		track.mu.RLock()
+		for _, down := range track.downTracks {
+			if err := down.write(layer.rid, packet); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+				log.Printf("SFU: error forwarding layer %s: %v", layer.rid, err)
+			}
+		}
+		track.mu.RUnlock()
+	}
+}
+
+// downTrack returns the subscriber's down track of a simulcast track,
+// creating it on first use
+func (track *sfuTrack) downTrack(id string, subscriber *Client) (*sfuDownTrack, error) {
+	track.mu.Lock()
+	defer track.mu.Unlock()
+
+	if down, ok := track.downTracks[subscriber]; ok {
+		return down, nil
+	}
+	local, err := webrtc.NewTrackLocalStaticRTP(track.codec, id, track.streamID)
+	if err != nil {
+		return nil, err
+	}
+	down := &sfuDownTrack{
+		local:       local,
+		clockRate:   track.codec.ClockRate,
+		mimeType:    track.codec.MimeType,
+		maxTemporal: sfuAllTemporalLayers,
+	}
+	// Start on the lowest layer so video appears quickly; the next
+	// assignment moves it up if there is room
+	if len(track.layers) > 0 {
+		down.target = track.layers[0].rid
+	}
+	track.downTracks[subscriber] = down
+	return down, nil
+}
+
+// activeLayers returns the layers the publisher is sending, lowest bitrate
+// first. Before the first measurement every layer counts, in arrival order.
+func (track *sfuTrack) activeLayers() []*sfuLayer {
+	track.mu.RLock()
+	defer track.mu.RUnlock()
+
+	var active []*sfuLayer
+	for _, layer := range track.layers {
+		if layer.bitrate.Load() > 0 {
+			active = append(active, layer)
+		}
+	}
+	if len(active) == 0 {
+		return slices.Clone(track.layers)
+	}
+	sort.SliceStable(active, func(i, j int) bool { return active[i].bitrate.Load() < active[j].bitrate.Load() })
+	return active
+}
+
+// layerChoice is the layer, and highest temporal layer, a down track carries;
+// an empty rid pauses it
+type layerChoice struct {
+	rid         string
+	maxTemporal uint8
+}
+
+// chooseLayers picks a layer of each track for a subscriber: each starts on
+// its lowest and moves up in turn, up to what its viewport warrants, while
+// the total fits the budget. A budget of 0 is unlimited. When even the
+// lowest layers don't fit, only their base temporal layer is sent.
+func chooseLayers(tracks [][]*sfuLayer, caps []int, budget int64) []layerChoice {
+	cost := func(layers []*sfuLayer, i int) int64 {
+		if bitrate := layers[i].bitrate.Load(); bitrate > 0 {
+			return bitrate
+		}
+		return sfuDefaultLayerBitrates[min(i, len(sfuDefaultLayerBitrates)-1)]
+	}
+
+	chosen := make([]int, len(tracks))
+	var spent int64
+	for i, layers := range tracks {
+		chosen[i] = -1
+		if caps[i] >= 0 && len(layers) > 0 {
+			chosen[i] = 0
+			spent += cost(layers, 0)
+		}
+	}
+	for upgraded := true; upgraded; {
+		upgraded = false
+		for i, layers := range tracks {
+			next := chosen[i] + 1
+			if chosen[i] < 0 || next > caps[i] || next >= len(layers) {
+				continue
+			}
+			extra := cost(layers, next) - cost(layers, chosen[i])
+			if budget > 0 && spent+extra > budget {
+				continue
+			}
+			chosen[i] = next
+			spent += extra
+			upgraded = true
+		}
+	}
+
+	choices := make([]layerChoice, len(tracks))
+	for i, layers := range tracks {
+		if chosen[i] < 0 {
+			continue
+		}
+		choices[i] = layerChoice{rid: layers[chosen[i]].rid, maxTemporal: sfuAllTemporalLayers}
+		if budget > 0 && spent > budget && chosen[i] == 0 {
+			choices[i].maxTemporal = 0
+		}
+	}
+	return choices
+}
+
+// measureLayers updates the bitrate of every simulcast layer in the room
+func (room *sfuRoom) measureLayers(interval time.Duration) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	for _, track := range room.tracks {
+		track.mu.RLock()
+		for _, layer := range track.layers {
+			layer.bitrate.Store(layer.bytes.Swap(0) * 8 * int64(time.Second) / int64(interval))
+		}
+		track.mu.RUnlock()
+	}
+}
+
+// assignLayers chooses the layer each subscriber receives of every simulcast
+// track, asking the publisher for a key frame on layers being switched to
+func (room *sfuRoom) assignLayers() {
+	type simulcastTrack struct {
+		id    string
+		track *sfuTrack
+	}
+	room.mu.Lock()
+	var tracks []simulcastTrack
+	for id, track := range room.tracks {
+		if track.local == nil {
+			tracks = append(tracks, simulcastTrack{id, track})
+		}
+	}
+	subscribers := make(map[*Client]*sfuSubscriber, len(room.subscribers))
+	for client, subscriber := range room.subscribers {
+		subscribers[client] = subscriber
+	}
+	room.mu.Unlock()
+	if len(tracks) == 0 {
+		return
+	}
+
+	layers := make([][]*sfuLayer, len(tracks))
+	for i, t := range tracks {
+		layers[i] = t.track.activeLayers()
+	}
+
+	for client, subscriber := range subscribers {
+		var received []int
+		var downs []*sfuDownTrack
+		for i, t := range tracks {
+			t.track.mu.RLock()
+			down, ok := t.track.downTracks[client]
+			t.track.mu.RUnlock()
+			if ok {
+				received = append(received, i)
+				downs = append(downs, down)
+			}
+		}
+		if len(downs) == 0 {
+			continue
+		}
+
+		subset := make([][]*sfuLayer, len(received))
+		caps := make([]int, len(received))
+		for j, i := range received {
+			subset[j] = layers[i]
+			caps[j] = subscriber.layerCap(tracks[i].id, len(layers[i]))
+		}
+
+		for j, choice := range chooseLayers(subset, caps, subscriber.bandwidth()) {
+			t := tracks[received[j]]
+			if !downs[j].setTarget(choice.rid, choice.maxTemporal) {
+				continue
+			}
+			if choice.rid != "" {
+				room.requestLayerKeyFrame(t.track, choice.rid)
+			}
+			client.hub.SendToClient(client, WebSocketMessage{
+				Type: "sfu-layer",
+				Data: map[string]interface{}{"trackId": t.id, "rid": choice.rid, "paused": choice.rid == ""},
+			})
+		}
+	}
+}
+
+// requestLayerKeyFrame asks the publisher of a simulcast track for a key
+// frame on one layer
+func (room *sfuRoom) requestLayerKeyFrame(track *sfuTrack, rid string) {
+	track.mu.RLock()
+	var ssrc uint32
+	for _, layer := range track.layers {
+		if layer.rid == rid {
+			ssrc = layer.ssrc
+		}
+	}
+	track.mu.RUnlock()
+
+	room.mu.Lock()
+	pc := room.peers[track.publisher]
+	room.mu.Unlock()
+	if pc != nil && ssrc != 0 {
+		pc.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: ssrc}})
+	}
+}
+
+// readSenderRTCP reads a subscriber's feedback on a forwarded track: REMB
+// updates its bandwidth, and key frame requests for simulcast tracks go to
+// the publisher for the layer the subscriber receives
+func (room *sfuRoom) readSenderRTCP(subscriber *Client, track *sfuTrack, sender *webrtc.RTPSender) {
+	for {
+		packets, _, err := sender.ReadRTCP()
+		if err != nil {
+			return
+		}
+		for _, packet := range packets {
+			switch p := packet.(type) {
+			case *rtcp.ReceiverEstimatedMaximumBitrate:
+				room.mu.Lock()
+				state, ok := room.subscribers[subscriber]
+				room.mu.Unlock()
+				if ok {
+					state.remb.Store(int64(p.Bitrate))
+				}
+			case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+				if track.local != nil {
+					continue // the periodic key frame requests cover shared tracks
+				}
+				track.mu.RLock()
+				var rid string
+				if down, ok := track.downTracks[subscriber]; ok {
+					down.mu.Lock()
+					rid = down.current
+					down.mu.Unlock()
+				}
+				track.mu.RUnlock()
+				if rid != "" {
+					room.requestLayerKeyFrame(track, rid)
+				}
+			}
+		}
+	}
+}
+
+// SetPreferences records a subscriber's downlink bandwidth, in bits per
+// second, and the size it renders each track at
+func (s *SFU) SetPreferences(c *Client, bandwidth int64, viewports map[string]sfuViewport) error {
+	s.mu.Lock()
+	room, ok := s.rooms[c.meetingID]
+	s.mu.Unlock()
+	if !ok {
+		return errors.New("not connected to the SFU")
+	}
+
+	room.mu.Lock()
+	subscriber, ok := room.subscribers[c]
+	room.mu.Unlock()
+	if !ok {
+		return errors.New("not connected to the SFU")
+	}
+
+	subscriber.mu.Lock()
+	subscriber.hint = bandwidth
+	subscriber.viewports = viewports
+	subscriber.mu.Unlock()
+
+	room.assignLayers()
+	return nil
+}
+
+// handleSFUPreferences records the client's downlink bandwidth and the size
+// it renders each video at, which the SFU picks simulcast layers by
+func handleSFUPreferences(ctx context.Context, c *Client, data json.RawMessage) {
+	if sfu == nil {
+		c.sendError("SFU mode is not enabled")
+		return
+	}
+	var req struct {
+		Bandwidth int64                  `json:"bandwidth"` // kbps
+		Viewports map[string]sfuViewport `json:"viewports"`
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		c.sendError("Invalid SFU preferences")
+		return
+	}
+	if req.Bandwidth < 0 || len(req.Viewports) > MaxSFUViewports {
+		c.sendError("Invalid SFU preferences")
+		return
+	}
+	for _, viewport := range req.Viewports {
+		if viewport.Width < 0 || viewport.Height < 0 {
+			c.sendError("Invalid SFU preferences")
+			return
+		}
+	}
+	if err := sfu.SetPreferences(c, req.Bandwidth*1000, req.Viewports); err != nil {
+		c.sendError("Failed to apply SFU preferences: " + err.Error())
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Stream types carried in signaling messages
+const (
+	StreamTypeCamera = "camera"
+	StreamTypeScreen = "screen"
+)
+
+// handleSignal relays WebRTC signaling (offer, answer, ICE candidate) to the
+// target peer in the same meeting. Screen-share streams must carry the share
+// token granted by the arbiter. An "ice-restart" signal carries no
+// description: a peer whose network changed sends it to ask the other side
+// for an offer with fresh ICE credentials, as only the offerer can restart.
+func handleSignal(ctx context.Context, c *Client, data json.RawMessage) {
+	var signal SignalingData
+	if err := json.Unmarshal(data, &signal); err != nil || signal.ToPeerID == "" {
+		c.sendError("Invalid signaling message")
+		return
+	}
+
+	// The server is authoritative about who is sending
+	signal.FromPeerID = c.peerID
+
+	if signal.StreamType == StreamTypeScreen && !screenShares.ValidToken(c.meetingID, c.userID, signal.ShareToken) {
+		c.sendError("A valid screen share token is required")
+		return
+	}
+
+	// A description's bandwidth limits what its recipient sends, which is
+	// how a capped participant is held to its cap
+	if signal.Offer != nil || signal.Answer != nil {
+		if meeting, err := findMeeting(ctx, c.meetingID); err == nil {
+			if kbps := peerBitrateCap(ctx, meeting, signal.ToPeerID); kbps > 0 {
+				if signal.Offer != nil {
+					signal.Offer.SDP = capVideoBandwidth(signal.Offer.SDP, kbps)
+				}
+				if signal.Answer != nil {
+					signal.Answer.SDP = capVideoBandwidth(signal.Answer.SDP, kbps)
+				}
+			}
+		}
+	}
+
+	hub.SendToPeer(c.meetingID, signal.ToPeerID, WebSocketMessage{
+		Type:   "signal",
+		Data:   signal,
+		UserID: c.userID,
+	})
+}
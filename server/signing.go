@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+)
+
+const (
+	// SigningKeysKept is how many keys verify links: the newest signs, and
+	// links signed before a rotation keep working until the next one
+	SigningKeysKept            = 2
+	SigningKeysRefreshInterval = time.Minute
+)
+
+var (
+	signingKey     []byte
+	signingKeyOnce sync.Once
+)
+
+// getSigningKey returns the HMAC key for signed links, read from JWT_SECRET.
+// Without it a random per-process key is used, so links stop working after a
+// restart. Keys made by rotateSigningKey take over from it.
+func getSigningKey() []byte {
+	signingKeyOnce.Do(func() {
+		if secret := os.Getenv("JWT_SECRET"); secret != "" {
+			signingKey = []byte(secret)
+			return
+		}
+		log.Println("Warning: JWT_SECRET is not set, using a random signing key")
+		signingKey = make([]byte, 32)
+		if _, err := rand.Read(signingKey); err != nil {
+			log.Fatalf("Failed to generate signing key: %v", err)
+		}
+	})
+	return signingKey
+}
+
+// SigningKey is a rotated link signing key, shared by all instances through
+// the database
+type SigningKey struct {
+	ID        string    `json:"id" bson:"_id"`
+	Key       []byte    `json:"-" bson:"key"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+}
+
+// signingKeyring holds the keys that verify signed links, newest first.
+// Each instance reloads it periodically, so a rotation made elsewhere
+// applies within SigningKeysRefreshInterval.
+type signingKeyring struct {
+	mu   sync.RWMutex
+	keys [][]byte
+}
+
+var signingKeys = &signingKeyring{}
+
+// Keys returns the verifying keys, newest first; JWT_SECRET's key until
+// keys have been rotated
+func (k *signingKeyring) Keys() [][]byte {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if len(k.keys) == 0 {
+		return [][]byte{getSigningKey()}
+	}
+	return k.keys
+}
+
+func (k *signingKeyring) refresh(ctx context.Context) error {
+	cursor, err := db.SigningKeys.Find(ctx, bson.M{}, options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}}).
+		SetLimit(SigningKeysKept))
+	if err != nil {
+		return err
+	}
+	var rotated []SigningKey
+	if err := cursor.All(ctx, &rotated); err != nil {
+		return err
+	}
+
+	keys := make([][]byte, 0, SigningKeysKept)
+	for _, key := range rotated {
+		keys = append(keys, key.Key)
+	}
+	// Links signed before the first rotation used JWT_SECRET's key
+	if len(keys) < SigningKeysKept {
+		keys = append(keys, getSigningKey())
+	}
+	k.mu.Lock()
+	k.keys = keys
+	k.mu.Unlock()
+	return nil
+}
+
+// startSigningKeysRefresh reloads the signing keys until ctx is cancelled
+func startSigningKeysRefresh(ctx context.Context) {
+	if err := signingKeys.refresh(ctx); err != nil {
+		log.Printf("Error loading signing keys: %v", err)
+	}
+
+	ticker := time.NewTicker(SigningKeysRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := signingKeys.refresh(ctx); err != nil {
+				log.Printf("Error refreshing signing keys: %v", err)
+			}
+		}
+	}
+}
+
+// rotateSigningKey makes a new key sign links from now on. Links signed with
+// the previous key still verify; older ones stop working.
+func rotateSigningKey(ctx context.Context, now time.Time) (SigningKey, error) {
+	key := SigningKey{ID: uuid.New().String(), Key: make([]byte, 32), CreatedAt: now}
+	if _, err := rand.Read(key.Key); err != nil {
+		return SigningKey{}, err
+	}
+	if _, err := db.SigningKeys.InsertOne(ctx, key); err != nil {
+		return SigningKey{}, err
+	}
+	return key, signingKeys.refresh(ctx)
+}
+
+func signWithKey(key []byte, value string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signValue returns a URL-safe HMAC-SHA256 signature of value
+func signValue(value string) string {
+	return signWithKey(signingKeys.Keys()[0], value)
+}
+
+// verifySignature checks a signature produced by signValue with any current
+// key, in constant time
+func verifySignature(value, signature string) bool {
+	valid := false
+	for _, key := range signingKeys.Keys() {
+		if hmac.Equal([]byte(signWithKey(key, value)), []byte(signature)) {
+			valid = true
+		}
+	}
+	return valid
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+)
+
+const (
+	SlackAuthorizeURL       = "https://slack.com/oauth/v2/authorize"
+	SlackTokenURL           = "https://slack.com/api/oauth.v2.access"
+	SlackScopes             = "commands,chat:write"
+	SlackStateTTL           = 10 * time.Minute
+	SlackRequestMaxAge      = 5 * time.Minute
+	SlackHTTPTimeout        = 10 * time.Second
+	MaxSlackCommandBodySize = 64 * 1024
+)
+
+// SlackInstallation is a workspace that installed the app; the installing
+// user owns the meetings created from that workspace
+type SlackInstallation struct {
+	TeamID      string    `json:"teamId" bson:"_id"`
+	TeamName    string    `json:"teamName" bson:"teamName"`
+	BotUserID   string    `json:"botUserId" bson:"botUserId"`
+	BotToken    string    `json:"-" bson:"botToken"`
+	Scope       string    `json:"scope" bson:"scope"`
+	InstalledBy string    `json:"installedBy" bson:"installedBy"`
+	InstalledAt time.Time `json:"installedAt" bson:"installedAt"`
+}
+
+var slackHTTPClient = &http.Client{Timeout: SlackHTTPTimeout}
+
+// slackInstallState returns the signed OAuth state identifying the installing
+// user. The signed payload is prefixed so other signed tokens cannot be
+// passed off as install states.
+func slackInstallState(userID string) string {
+	payload := userID + "." + strconv.FormatInt(time.Now().Add(SlackStateTTL).Unix(), 10)
+	return payload + "." + signValue("slack-state."+payload)
+}
+
+// parseSlackInstallState verifies the OAuth state and returns the user ID
+func parseSlackInstallState(state string) (string, error) {
+	parts := strings.Split(state, ".")
+	if len(parts) != 3 || !verifySignature("slack-state."+parts[0]+"."+parts[1], parts[2]) {
+		return "", fmt.Errorf("invalid install state")
+	}
+	expiresAt, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		return "", fmt.Errorf("install link has expired")
+	}
+	return parts[0], nil
+}
+
+// verifySlackRequest checks Slack's v0 request signature and rejects stale
+// timestamps to prevent replays
+func verifySlackRequest(r *http.Request, body []byte) bool {
+	timestamp := r.Header.Get("X-Slack-Request-Timestamp")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := time.Since(time.Unix(seconds, 0)); age > SlackRequestMaxAge || age < -SlackRequestMaxAge {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(appConfig.Slack.SigningSecret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(r.Header.Get("X-Slack-Signature")))
+}
+
+// slackEscape escapes the characters Slack treats as markup
+func slackEscape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// requireSlack rejects requests when the integration is not configured
+func requireSlack(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !appConfig.Slack.Enabled() {
+			sendErrorResponse(w, "Slack integration is not configured", http.StatusNotFound)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// slackInstallHandler starts the OAuth install flow for the signed-in user
+func slackInstallHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	query := url.Values{
+		"client_id":    {appConfig.Slack.ClientID},
+		"scope":        {SlackScopes},
+		"redirect_uri": {appConfig.Slack.RedirectURL},
+		"state":        {slackInstallState(userID)},
+	}
+	http.Redirect(w, r, SlackAuthorizeURL+"?"+query.Encode(), http.StatusFound)
+}
+
+// slackOAuthCallbackHandler exchanges the OAuth code for a bot token and
+// stores the workspace installation
+func slackOAuthCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		sendErrorResponse(w, fmt.Sprintf("Slack install was not completed: %s", errCode), http.StatusBadRequest)
+		return
+	}
+
+	userID, err := parseSlackInstallState(query.Get("state"))
+	if err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	code := query.Get("code")
+	if code == "" {
+		sendErrorResponse(w, "Missing authorization code", http.StatusBadRequest)
+		return
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, SlackTokenURL, strings.NewReader(url.Values{
+		"client_id":     {appConfig.Slack.ClientID},
+		"client_secret": {appConfig.Slack.ClientSecret},
+		"code":          {code},
+		"redirect_uri":  {appConfig.Slack.RedirectURL},
+	}.Encode()))
+	if err != nil {
+		sendErrorResponse(w, "Failed to contact Slack", http.StatusInternalServerError)
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := slackHTTPClient.Do(req)
+	if err != nil {
+		log.Printf("Slack: token exchange failed: %v", err)
+		sendErrorResponse(w, "Failed to contact Slack", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		OK          bool   `json:"ok"`
+		Error       string `json:"error"`
+		AccessToken string `json:"access_token"`
+		BotUserID   string `json:"bot_user_id"`
+		Scope       string `json:"scope"`
+		Team        struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"team"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil || !token.OK {
+		log.Printf("Slack: token exchange rejected: %v %s", err, token.Error)
+		sendErrorResponse(w, "Slack rejected the install", http.StatusBadGateway)
+		return
+	}
+
+	installation := SlackInstallation{
+		TeamID:      token.Team.ID,
+		TeamName:    token.Team.Name,
+		BotUserID:   token.BotUserID,
+		BotToken:    token.AccessToken,
+		Scope:       token.Scope,
+		InstalledBy: userID,
+		InstalledAt: time.Now(),
+	}
+	// Reinstalling replaces the workspace's token and owner
+	_, err = db.SlackInstallations.ReplaceOne(
+		r.Context(),
+		bson.M{"_id": installation.TeamID},
+		installation,
+		options.Replace().SetUpsert(true),
+	)
+	if err != nil {
+		log.Printf("Slack: failed to store installation for %s: %v", installation.TeamID, err)
+		sendErrorResponse(w, "Failed to save Slack installation", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Slack app installed in %s (%s) by %s", installation.TeamName, installation.TeamID, userID)
+	http.Redirect(w, r, appBaseURL()+"/?slack=installed", http.StatusFound)
+}
+
+// slackCommandHandler handles the slash command: it creates an instant meeting
+// titled with the command text and posts the join link to the channel
+func slackCommandHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, MaxSlackCommandBodySize))
+	if err != nil {
+		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !verifySlackRequest(r, body) {
+		sendErrorResponse(w, "Invalid Slack signature", http.StatusUnauthorized)
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var installation SlackInstallation
+	err = db.SlackInstallations.FindOne(r.Context(), bson.M{"_id": form.Get("team_id")}).Decode(&installation)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Printf("Slack: failed to load installation: %v", err)
+		}
+		sendSlackResponse(w, "ephemeral", "This workspace hasn't finished installing the app. Ask an admin to install it again.")
+		return
+	}
+
+	title := strings.TrimSpace(form.Get("text"))
+	if title == "" {
+		title = fmt.Sprintf("%s's meeting", form.Get("user_name"))
+	}
+
+	now := time.Now()
+	meeting := Meeting{
+		ID:              uuid.New().String(),
+		Title:           title,
+		Description:     fmt.Sprintf("Started from Slack by @%s", form.Get("user_name")),
+		CreatedBy:       installation.InstalledBy,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+		IsActive:        true,
+		MaxParticipants: appConfig.Meetings.DefaultParticipants,
+	}
+	if err := insertMeeting(r.Context(), &meeting); err != nil {
+		log.Printf("Slack: error creating meeting: %v", err)
+		sendSlackResponse(w, "ephemeral", "Sorry, the meeting could not be created. Please try again.")
+		return
+	}
+
+	sendSlackResponse(w, "in_channel", fmt.Sprintf("<@%s> started a meeting: *%s*\nJoin here: %s",
+		form.Get("user_id"), slackEscape(title), meetingJoinURL(meeting)))
+}
+
+// sendSlackResponse replies to a slash command; "in_channel" messages are
+// visible to the whole channel, "ephemeral" ones only to the caller
+func sendSlackResponse(w http.ResponseWriter, responseType, text string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"response_type": responseType,
+		"text":          text,
+	})
+}
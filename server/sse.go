@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// sseSession is a meeting connection over the server-sent events fallback,
+// for networks whose proxies block WebSockets. The hub delivers to it like
+// any client; messages from the client arrive as POSTs carrying the session
+// ID.
+type sseSession struct {
+	id string
+	// cancel ends the event stream
+	cancel context.CancelFunc
+}
+
+// sseClients finds the client of an event stream by session ID
+var sseClients = struct {
+	sync.Mutex
+	byID map[string]*Client
+}{byID: make(map[string]*Client)}
+
+func registerSSEClient(c *Client) {
+	sseClients.Lock()
+	defer sseClients.Unlock()
+	sseClients.byID[c.sse.id] = c
+}
+
+func unregisterSSEClient(c *Client) {
+	sseClients.Lock()
+	defer sseClients.Unlock()
+	delete(sseClients.byID, c.sse.id)
+}
+
+func findSSEClient(sessionID string) *Client {
+	sseClients.Lock()
+	defer sseClients.Unlock()
+	return sseClients.byID[sessionID]
+}
+
+// sseStreamHandler opens a meeting's event stream. The first event, "session",
+// carries the URL to POST client messages to; every later event is a hub
+// message in the same JSON the WebSocket carries.
+func sseStreamHandler(w http.ResponseWriter, r *http.Request) {
+	userID, meetingID, ok := authorizeMeetingConnection(w, r)
+	if !ok {
+		return
+	}
+
+	// The stream outlives the server's write timeout; writes get their own deadline
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		sendErrorResponse(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	client := newMeetingClient(r, userID, meetingID)
+	client.sse = &sseSession{id: uuid.New().String(), cancel: cancel}
+	registerSSEClient(client)
+	defer unregisterSSEClient(client)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // keep nginx from buffering the stream
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "event: session\ndata: {\"sessionId\":%q,\"sendUrl\":%q}\n\n",
+		client.sse.id, fmt.Sprintf("/api/sse/%s/%s", meetingID, client.sse.id))
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	client.hub.Register(client)
+	regionTracker.Join(client)
+	defer client.leave()
+	go sendRoomState(client.ctx, client)
+
+	client.streamEvents(ctx, w, rc)
+}
+
+// streamEvents writes queued messages and periodic keepalives to the event
+// stream until the client disconnects or the hub closes its queue
+func (c *Client) streamEvents(ctx context.Context, w http.ResponseWriter, rc *http.ResponseController) {
+	ticker := time.NewTicker(PingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-c.send.ready:
+			for {
+				message, ok, done := c.send.pop()
+				if done {
+					return
+				}
+				if !ok {
+					break
+				}
+				rc.SetWriteDeadline(time.Now().Add(WriteWait))
+				// JSON frames never contain newlines, so each fits one data line
+				if _, err := fmt.Fprintf(w, "data: %s\n\n", message); err != nil {
+					return
+				}
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			rc.SetWriteDeadline(time.Now().Add(WriteWait))
+			if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+			// Keepalives stand in for WebSocket pongs as the participant heartbeat
+			go markParticipantActive(context.Background(), c.meetingID, c.userID)
+		}
+	}
+}
+
+// sseSendHandler takes one client message for an event stream session. The
+// body is the same JSON frame a WebSocket client sends; replies and errors
+// arrive on the stream.
+func sseSendHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	client := findSSEClient(vars["sessionId"])
+	if client == nil || client.userID != userID || client.meetingID != vars["meetingId"] {
+		sendErrorResponse(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, MaxMessageSize+1))
+	if err != nil {
+		sendErrorResponse(w, "Invalid message format", http.StatusBadRequest)
+		return
+	}
+	if len(data) > MaxMessageSize {
+		sendErrorResponse(w, "Message too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	msg, err := decodeClientFrame(websocket.TextMessage, data)
+	if err != nil {
+		sendErrorResponse(w, "Invalid message format", http.StatusBadRequest)
+		return
+	}
+	if !client.receive(msg, len(data)) {
+		sendCodedError(w, ErrCodeRateLimited, "Message limit exceeded")
+		return
+	}
+	sendSuccessResponse(w, nil)
+}
@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// gridFSStore keeps objects in a GridFS bucket with the key as the file name.
+// Writing an existing key replaces it.
+type gridFSStore struct {
+	bucket *gridfs.Bucket
+}
+
+func newGridFSStore(database *mongo.Database, bucketName string) (*gridFSStore, error) {
+	bucket, err := gridfs.NewBucket(database, options.GridFSBucket().SetName(bucketName))
+	if err != nil {
+		return nil, err
+	}
+	return &gridFSStore{bucket: bucket}, nil
+}
+
+func (s *gridFSStore) Put(ctx context.Context, key, contentType string, data []byte) error {
+	return s.PutStream(ctx, key, contentType, bytes.NewReader(data))
+}
+
+func (s *gridFSStore) PutStream(ctx context.Context, key, contentType string, body io.Reader) error {
+	// Upload first so readers never see the key missing, then drop older
+	// revisions. A failed read aborts the upload and removes its chunks.
+	opts := options.GridFSUpload().SetMetadata(bson.M{"contentType": contentType})
+	id, err := s.bucket.UploadFromStream(key, body, opts)
+	if err != nil {
+		return err
+	}
+	return s.deleteRevisions(ctx, key, id)
+}
+
+func (s *gridFSStore) Get(ctx context.Context, key string) (io.ReadSeekCloser, ObjectInfo, error) {
+	file, err := s.latest(ctx, key)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	stream, err := s.bucket.OpenDownloadStream(file.ID)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	object := &gridFSObject{bucket: s.bucket, id: file.ID, size: file.Length, stream: stream}
+	return object, ObjectInfo{
+		Key:         key,
+		ContentType: file.Metadata.ContentType,
+		Size:        file.Length,
+		ModTime:     file.UploadDate,
+	}, nil
+}
+
+func (s *gridFSStore) Delete(ctx context.Context, key string) error {
+	return s.deleteRevisions(ctx, key, primitive.NilObjectID)
+}
+
+// gridFSObject reads a GridFS file. Download streams only move forward, so
+// seeking just records the new offset; the next read reopens the stream if it
+// must go back and skips ahead to the offset.
+type gridFSObject struct {
+	bucket    *gridfs.Bucket
+	id        primitive.ObjectID
+	size      int64
+	stream    *gridfs.DownloadStream
+	streamPos int64 // offset of the stream
+	offset    int64 // offset of the next read
+}
+
+func (o *gridFSObject) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+	if o.offset < o.streamPos {
+		stream, err := o.bucket.OpenDownloadStream(o.id)
+		if err != nil {
+			return 0, err
+		}
+		o.stream.Close()
+		o.stream, o.streamPos = stream, 0
+	}
+	if o.offset > o.streamPos {
+		skipped, err := o.stream.Skip(o.offset - o.streamPos)
+		o.streamPos += skipped
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := o.stream.Read(p)
+	o.streamPos += int64(n)
+	o.offset = o.streamPos
+	return n, err
+}
+
+func (o *gridFSObject) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		offset += o.size
+	}
+	if offset < 0 {
+		return 0, errors.New("negative seek offset")
+	}
+	o.offset = offset
+	return offset, nil
+}
+
+func (o *gridFSObject) Close() error {
+	return o.stream.Close()
+}
+
+type gridFSFile struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	Length     int64              `bson:"length"`
+	UploadDate time.Time          `bson:"uploadDate"`
+	Metadata   struct {
+		ContentType string `bson:"contentType"`
+	} `bson:"metadata"`
+}
+
+// latest returns the newest revision stored under key
+func (s *gridFSStore) latest(ctx context.Context, key string) (gridFSFile, error) {
+	cursor, err := s.bucket.FindContext(ctx, bson.M{"filename": key},
+		options.GridFSFind().SetSort(bson.M{"uploadDate": -1}).SetLimit(1))
+	if err != nil {
+		return gridFSFile{}, err
+	}
+	defer cursor.Close(ctx)
+
+	if !cursor.Next(ctx) {
+		if err := cursor.Err(); err != nil {
+			return gridFSFile{}, err
+		}
+		return gridFSFile{}, ErrNotFound
+	}
+	var file gridFSFile
+	err = cursor.Decode(&file)
+	return file, err
+}
+
+// deleteRevisions removes every revision of key except keep
+func (s *gridFSStore) deleteRevisions(ctx context.Context, key string, keep primitive.ObjectID) error {
+	cursor, err := s.bucket.FindContext(ctx, bson.M{"filename": key})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var file gridFSFile
+		if err := cursor.Decode(&file); err != nil {
+			return err
+		}
+		if file.ID == keep {
+			continue
+		}
+		if err := s.bucket.DeleteContext(ctx, file.ID); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+			return err
+		}
+	}
+	return cursor.Err()
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// memoryStore keeps objects in process memory; meant for demos and CI, where
+// uploads needn't survive a restart
+type memoryStore struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+}
+
+type memoryObject struct {
+	data []byte
+	info ObjectInfo
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{objects: make(map[string]memoryObject)}
+}
+
+func (s *memoryStore) Put(ctx context.Context, key, contentType string, data []byte) error {
+	object := memoryObject{
+		data: bytes.Clone(data),
+		info: ObjectInfo{Key: key, ContentType: contentType, Size: int64(len(data)), ModTime: time.Now()},
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = object
+	return nil
+}
+
+func (s *memoryStore) PutStream(ctx context.Context, key, contentType string, body io.Reader) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, key, contentType, data)
+}
+
+func (s *memoryStore) Get(ctx context.Context, key string) (io.ReadSeekCloser, ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	object, ok := s.objects[key]
+	if !ok {
+		return nil, ObjectInfo{}, ErrNotFound
+	}
+	// Stored bytes are never modified, so readers can share them
+	return memoryReader{bytes.NewReader(object.data)}, object.info, nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+	return nil
+}
+
+// memoryReader adds a no-op Close to a bytes.Reader
+type memoryReader struct {
+	*bytes.Reader
+}
+
+func (memoryReader) Close() error { return nil }
@@ -0,0 +1,54 @@
+// Package storage stores binary objects such as avatars behind a small
+// interface, backed by MongoDB GridFS, S3, a local directory or memory.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"video-meeting-app/config"
+)
+
+// ErrNotFound is returned when no object exists under a key
+var ErrNotFound = errors.New("object not found")
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key         string
+	ContentType string
+	Size        int64
+	ModTime     time.Time
+}
+
+// Store saves and serves objects by key. Keys are slash-separated paths such
+// as "avatars/<userId>/<hash>.jpg". Objects are seekable so they can be
+// served with HTTP range requests.
+type Store interface {
+	Put(ctx context.Context, key, contentType string, data []byte) error
+	// PutStream stores what body yields without holding all of it in
+	// memory. Nothing is stored under key if reading body fails.
+	PutStream(ctx context.Context, key, contentType string, body io.Reader) error
+	Get(ctx context.Context, key string) (io.ReadSeekCloser, ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// New returns the store selected by cfg
+func New(cfg config.StorageConfig, database *mongo.Database) (Store, error) {
+	switch cfg.Backend {
+	case config.StorageBackendGridFS:
+		return newGridFSStore(database, cfg.GridFSBucket)
+	case config.StorageBackendLocal:
+		return newLocalStore(cfg.LocalDir)
+	case config.StorageBackendS3:
+		return newS3Store(cfg)
+	case config.StorageBackendMemory:
+		return newMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+	}
+}
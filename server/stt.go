@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"video-meeting-app/config"
+)
+
+const (
+	STTHTTPTimeout = 60 * time.Second
+	GoogleSTTURL   = "https://speech.googleapis.com/v1/speech:recognize"
+)
+
+// STTProvider turns a clip of speech into text. Audio is Ogg Opus at 48 kHz,
+// as forwarded by the SFU; language is a BCP 47 tag such as en-US.
+type STTProvider interface {
+	Name() string
+	Transcribe(ctx context.Context, audio []byte, language string) (string, error)
+}
+
+var sttHTTPClient = &http.Client{Timeout: STTHTTPTimeout}
+
+// newSTTProvider returns the configured provider
+func newSTTProvider(cfg config.TranscriptionConfig) (STTProvider, error) {
+	switch cfg.Provider {
+	case config.STTProviderWhisper:
+		return &whisperProvider{apiKey: cfg.WhisperAPIKey, model: cfg.WhisperModel, url: cfg.WhisperURL}, nil
+	case config.STTProviderGoogle:
+		return &googleSTTProvider{apiKey: cfg.GoogleAPIKey}, nil
+	default:
+		return nil, fmt.Errorf("unknown speech-to-text provider %q", cfg.Provider)
+	}
+}
+
+// readSTTError describes an unsuccessful provider response
+func readSTTError(provider string, resp *http.Response) error {
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("%s responded with status %d: %s", provider, resp.StatusCode, strings.TrimSpace(string(detail)))
+}
+
+// whisperProvider uses the OpenAI audio transcription API, or any server
+// compatible with it
+type whisperProvider struct {
+	apiKey string
+	model  string
+	url    string
+}
+
+func (p *whisperProvider) Name() string { return config.STTProviderWhisper }
+
+func (p *whisperProvider) Transcribe(ctx context.Context, audio []byte, language string) (string, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("model", p.model)
+	form.WriteField("response_format", "json")
+	// Whisper takes ISO 639-1 codes, i.e. the primary subtag
+	if primary, _, _ := strings.Cut(language, "-"); primary != "" {
+		form.WriteField("language", strings.ToLower(primary))
+	}
+	file, err := form.CreateFormFile("file", "speech.ogg")
+	if err != nil {
+		return "", err
+	}
+	if _, err := file.Write(audio); err != nil {
+		return "", err
+	}
+	if err := form.Close(); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	resp, err := sttHTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", readSTTError("whisper", resp)
+	}
+
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(result.Text), nil
+}
+
+// googleSTTProvider uses Google Cloud Speech-to-Text synchronous recognition,
+// which accepts clips of up to a minute
+type googleSTTProvider struct {
+	apiKey string
+}
+
+func (p *googleSTTProvider) Name() string { return config.STTProviderGoogle }
+
+func (p *googleSTTProvider) Transcribe(ctx context.Context, audio []byte, language string) (string, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"config": map[string]interface{}{
+			"encoding":                   "OGG_OPUS",
+			"sampleRateHertz":            48000,
+			"audioChannelCount":          2,
+			"languageCode":               language,
+			"enableAutomaticPunctuation": true,
+		},
+		"audio": map[string]string{"content": base64.StdEncoding.EncodeToString(audio)},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, GoogleSTTURL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Goog-Api-Key", p.apiKey)
+
+	resp, err := sttHTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", readSTTError("google", resp)
+	}
+
+	var result struct {
+		Results []struct {
+			Alternatives []struct {
+				Transcript string `json:"transcript"`
+			} `json:"alternatives"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+
+	// Consecutive results cover consecutive stretches of the clip
+	var parts []string
+	for _, r := range result.Results {
+		if len(r.Alternatives) > 0 {
+			parts = append(parts, strings.TrimSpace(r.Alternatives[0].Transcript))
+		}
+	}
+	return strings.Join(parts, " "), nil
+}
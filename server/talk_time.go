@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+)
+
+const (
+	// TalkTimeFlushInterval is how often accumulated talk time is written out
+	TalkTimeFlushInterval = 10 * time.Second
+	// Starting to speak while someone has held the floor this long is an
+	// interruption; shorter overlaps are treated as turn-taking
+	interruptionMinTalk = 1 * time.Second
+)
+
+// TalkTime is one participant's speaking statistics for a meeting
+type TalkTime struct {
+	ID             string    `json:"-" bson:"_id"` // meetingId:userId
+	MeetingID      string    `json:"meetingId" bson:"meetingId"`
+	UserID         string    `json:"userId" bson:"userId"`
+	UserName       string    `json:"userName" bson:"userName"`
+	SpeakingMillis int64     `json:"speakingMillis" bson:"speakingMillis"`
+	Turns          int64     `json:"turns" bson:"turns"`
+	Interruptions  int64     `json:"interruptions" bson:"interruptions"` // times they cut someone off
+	Interrupted    int64     `json:"interrupted" bson:"interrupted"`     // times they were cut off
+	UpdatedAt      time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+// talkTimeTracker accumulates talk time for one SFU room from periodic
+// samples of who is speaking, and adds it to the stored totals on flush
+type talkTimeTracker struct {
+	meetingID string
+
+	mu            sync.Mutex
+	speakingSince map[string]time.Time // userId -> start of their current turn
+	pending       map[string]*TalkTime // not yet flushed
+}
+
+func newTalkTimeTracker(meetingID string) *talkTimeTracker {
+	return &talkTimeTracker{
+		meetingID:     meetingID,
+		speakingSince: make(map[string]time.Time),
+		pending:       make(map[string]*TalkTime),
+	}
+}
+
+func (t *talkTimeTracker) stats(c *Client) *TalkTime {
+	stats, ok := t.pending[c.userID]
+	if !ok {
+		stats = &TalkTime{MeetingID: t.meetingID, UserID: c.userID}
+		t.pending[c.userID] = stats
+	}
+	stats.UserName = c.userName
+	return stats
+}
+
+// sample records that the clients were speaking for the last interval
+func (t *talkTimeTracker) sample(speakers []*Client, interval time.Duration, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	speaking := make(map[string]bool, len(speakers))
+	var started []*Client
+	for _, c := range speakers {
+		if speaking[c.userID] {
+			continue // the same user on two devices
+		}
+		speaking[c.userID] = true
+		t.stats(c).SpeakingMillis += interval.Milliseconds()
+		if _, ok := t.speakingSince[c.userID]; !ok {
+			started = append(started, c)
+		}
+	}
+
+	for _, c := range started {
+		stats := t.stats(c)
+		stats.Turns++
+		for userID, since := range t.speakingSince {
+			if speaking[userID] && now.Sub(since) >= interruptionMinTalk {
+				stats.Interruptions++
+				if other, ok := t.pending[userID]; ok {
+					other.Interrupted++
+				} else {
+					t.pending[userID] = &TalkTime{MeetingID: t.meetingID, UserID: userID, Interrupted: 1}
+				}
+			}
+		}
+	}
+	for _, c := range started {
+		t.speakingSince[c.userID] = now
+	}
+	for userID := range t.speakingSince {
+		if !speaking[userID] {
+			delete(t.speakingSince, userID)
+		}
+	}
+}
+
+// flush adds the pending statistics to the stored totals
+func (t *talkTimeTracker) flush(ctx context.Context) {
+	t.mu.Lock()
+	pending := t.pending
+	t.pending = make(map[string]*TalkTime)
+	t.mu.Unlock()
+
+	ctx = meetingTenantContext(ctx, t.meetingID)
+	now := time.Now()
+	for _, stats := range pending {
+		set := bson.M{"updatedAt": now}
+		if stats.UserName != "" {
+			set["userName"] = stats.UserName
+		}
+		_, err := db.TalkTime.UpdateOne(
+			ctx,
+			bson.M{"_id": t.meetingID + ":" + stats.UserID},
+			bson.M{
+				"$inc": bson.M{
+					"speakingMillis": stats.SpeakingMillis,
+					"turns":          stats.Turns,
+					"interruptions":  stats.Interruptions,
+					"interrupted":    stats.Interrupted,
+				},
+				"$set":         set,
+				"$setOnInsert": bson.M{"meetingId": t.meetingID, "userId": stats.UserID},
+			},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			log.Printf("Error saving talk time for %s in meeting %s: %v", stats.UserID, t.meetingID, err)
+		}
+	}
+}
+
+// ParticipantAnalytics combines a participant's attendance and talk time
+type ParticipantAnalytics struct {
+	UserID          string  `json:"userId"`
+	UserName        string  `json:"userName"`
+	AttendedSeconds int64   `json:"attendedSeconds"`
+	SpeakingSeconds int64   `json:"speakingSeconds"`
+	SpeakingShare   float64 `json:"speakingShare"` // fraction of all talk time in the meeting
+	Turns           int64   `json:"turns"`
+	Interruptions   int64   `json:"interruptions"`
+	Interrupted     int64   `json:"interrupted"`
+}
+
+// MeetingAnalytics is the attendance and talk time of a meeting's participants
+type MeetingAnalytics struct {
+	MeetingID        string                 `json:"meetingId"`
+	StartedAt        *time.Time             `json:"startedAt"`
+	Participants     []ParticipantAnalytics `json:"participants"`
+	SpeakingSeconds  int64                  `json:"speakingSeconds"`
+	Interruptions    int64                  `json:"interruptions"`
+	TalkTimeMeasured bool                   `json:"talkTimeMeasured"`
+}
+
+// getMeetingAnalyticsHandler returns attendance and talk time per participant
+// so the host can review how balanced the meeting was. Talk time is only
+// measured in SFU mode.
+func getMeetingAnalyticsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	meeting, err := findMeeting(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+	if meeting.CreatedBy != userID {
+		sendCodedError(w, ErrCodeHostOnly, "Only the host can view analytics")
+		return
+	}
+
+	analytics, err := loadMeetingAnalytics(r.Context(), meeting)
+	if err != nil {
+		log.Printf("Error loading analytics for meeting %s: %v", meeting.ID, err)
+		sendErrorResponse(w, "Failed to fetch analytics", http.StatusInternalServerError)
+		return
+	}
+	sendSuccessResponse(w, analytics)
+}
+
+// loadMeetingAnalytics combines the meeting's attendance events and talk time
+func loadMeetingAnalytics(ctx context.Context, meeting Meeting) (MeetingAnalytics, error) {
+	analytics := MeetingAnalytics{MeetingID: meeting.ID, StartedAt: meeting.StartedAt}
+
+	cursor, err := db.AttendanceEvents.Find(
+		ctx,
+		bson.M{"meetingId": meeting.ID},
+		options.Find().SetSort(bson.D{{Key: "at", Value: 1}}),
+	)
+	if err != nil {
+		return analytics, err
+	}
+	var events []AttendanceEvent
+	if err := cursor.All(ctx, &events); err != nil {
+		return analytics, err
+	}
+
+	cursor, err = db.TalkTime.Find(ctx, bson.M{"meetingId": meeting.ID})
+	if err != nil {
+		return analytics, err
+	}
+	var talkTimes []TalkTime
+	if err := cursor.All(ctx, &talkTimes); err != nil {
+		return analytics, err
+	}
+
+	until := time.Now()
+	if !meeting.IsActive {
+		until = meeting.UpdatedAt
+	}
+
+	byUser := make(map[string]*ParticipantAnalytics)
+	participant := func(userID, userName string) *ParticipantAnalytics {
+		p, ok := byUser[userID]
+		if !ok {
+			p = &ParticipantAnalytics{UserID: userID, UserName: userName}
+			byUser[userID] = p
+		}
+		return p
+	}
+	for _, record := range summarizeAttendance(events, until) {
+		participant(record.UserID, record.UserName).AttendedSeconds = record.TotalSeconds
+	}
+
+	var totalMillis, totalInterruptions int64
+	for _, talk := range talkTimes {
+		p := participant(talk.UserID, talk.UserName)
+		p.SpeakingSeconds = talk.SpeakingMillis / 1000
+		p.Turns = talk.Turns
+		p.Interruptions = talk.Interruptions
+		p.Interrupted = talk.Interrupted
+		totalMillis += talk.SpeakingMillis
+		totalInterruptions += talk.Interruptions
+	}
+	for _, talk := range talkTimes {
+		if totalMillis > 0 {
+			byUser[talk.UserID].SpeakingShare = float64(talk.SpeakingMillis) / float64(totalMillis)
+		}
+	}
+
+	participants := make([]ParticipantAnalytics, 0, len(byUser))
+	for _, p := range byUser {
+		participants = append(participants, *p)
+	}
+	sort.Slice(participants, func(i, j int) bool {
+		if participants[i].SpeakingSeconds != participants[j].SpeakingSeconds {
+			return participants[i].SpeakingSeconds > participants[j].SpeakingSeconds
+		}
+		return participants[i].UserName < participants[j].UserName
+	})
+
+	analytics.Participants = participants
+	analytics.SpeakingSeconds = totalMillis / 1000
+	analytics.Interruptions = totalInterruptions
+	analytics.TalkTimeMeasured = len(talkTimes) > 0
+	return analytics, nil
+}
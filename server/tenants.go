@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"video-meeting-app/config"
+	"video-meeting-app/db"
+)
+
+// Each configured tenant is a customer deployment reached on its own domains,
+// with its own origins and branding. Requests are confined to the tenant of
+// the host they were made to, and the db package keeps each tenant's
+// documents apart; see db/tenant.go.
+
+// Tenant is a configured tenant, resolved from a request's host
+type Tenant struct {
+	ID       string
+	Name     string
+	Branding config.TenantBranding
+	origins  []originPattern
+}
+
+// tenantsByDomain maps each tenant domain to its tenant; empty when the
+// server hosts only the default tenant
+var tenantsByDomain = map[string]*Tenant{}
+
+type tenantContextKey struct{}
+
+// loadTenants builds the tenants from the configuration
+func loadTenants(configured []config.TenantConfig) error {
+	byDomain := make(map[string]*Tenant)
+	for _, cfg := range configured {
+		tenant := &Tenant{ID: cfg.ID, Name: cfg.Name, Branding: cfg.Branding}
+		for _, origin := range cfg.AllowedOrigins {
+			pattern, err := parseOriginPattern(origin)
+			if err != nil {
+				return fmt.Errorf("tenant %s: %w", cfg.ID, err)
+			}
+			tenant.origins = append(tenant.origins, pattern)
+		}
+		for _, domain := range cfg.Domains {
+			byDomain[strings.ToLower(domain)] = tenant
+		}
+	}
+	tenantsByDomain = byDomain
+	return nil
+}
+
+// tenancyEnabled reports whether the server hosts tenants besides the default
+func tenancyEnabled() bool {
+	return len(tenantsByDomain) > 0
+}
+
+// tenantIDs lists the IDs of the default tenant and the configured ones, for
+// background work done per tenant
+func tenantIDs() []string {
+	ids := []string{db.DefaultTenant}
+	seen := make(map[string]bool)
+	for _, tenant := range tenantsByDomain {
+		if !seen[tenant.ID] {
+			seen[tenant.ID] = true
+			ids = append(ids, tenant.ID)
+		}
+	}
+	sort.Strings(ids[1:])
+	return ids
+}
+
+// tenantForHost returns the tenant a host belongs to; nil for the default tenant
+func tenantForHost(host string) *Tenant {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	return tenantsByDomain[strings.ToLower(host)]
+}
+
+// requestTenant returns the tenant the request was made to; nil for the
+// default tenant
+func requestTenant(r *http.Request) *Tenant {
+	tenant, _ := r.Context().Value(tenantContextKey{}).(*Tenant)
+	return tenant
+}
+
+// tenantMiddleware confines the request to the tenant of its host. It runs
+// before anything else touches the database.
+func tenantMiddleware(next http.Handler) http.Handler {
+	if !tenancyEnabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant := tenantForHost(r.Host)
+		tenantID := db.DefaultTenant
+		if tenant != nil {
+			tenantID = tenant.ID
+		}
+		ctx := db.WithTenant(context.WithValue(r.Context(), tenantContextKey{}, tenant), tenantID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// allowedOrigin checks a browser origin for the request's tenant. Tenants
+// allow their own origins, with credentials; the default tenant allows the
+// origin registry's.
+func allowedOrigin(r *http.Request, origin string) (allowed, credentials bool) {
+	tenant := requestTenant(r)
+	if tenant == nil {
+		return corsOrigins.Allowed(origin)
+	}
+	parsed, err := parseOriginPattern(origin)
+	if err != nil || parsed.wildcard {
+		return false, false
+	}
+	for _, pattern := range tenant.origins {
+		if pattern.matches(parsed) {
+			return true, true
+		}
+	}
+	return false, false
+}
+
+// meetingTenantContext confines ctx to the tenant of the meeting when it
+// isn't confined already, so work done outside a request, such as by
+// background jobs and the SFU, stores the meeting's documents in its tenant
+func meetingTenantContext(ctx context.Context, meetingID string) context.Context {
+	if _, scoped := db.TenantFromContext(ctx); scoped || !tenancyEnabled() {
+		return ctx
+	}
+	meeting, err := findMeeting(ctx, meetingID)
+	if err != nil {
+		return ctx
+	}
+	return db.WithTenant(ctx, meeting.TenantID)
+}
+
+type tenantResponse struct {
+	// Empty for the default tenant
+	ID       string                `json:"id,omitempty"`
+	Name     string                `json:"name,omitempty"`
+	Branding config.TenantBranding `json:"branding"`
+}
+
+// getTenantHandler tells the frontend which tenant it is serving and how to
+// brand itself
+func getTenantHandler(w http.ResponseWriter, r *http.Request) {
+	tenant := requestTenant(r)
+	if tenant == nil {
+		sendSuccessResponse(w, tenantResponse{})
+		return
+	}
+	sendSuccessResponse(w, tenantResponse{ID: tenant.ID, Name: tenant.Name, Branding: tenant.Branding})
+}
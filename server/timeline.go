@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+)
+
+// A meeting's timeline is the notable things that happened in it, for
+// playback UIs to show as chapter markers. Joins and leaves come from the
+// attendance log; everything else is stored as a meeting event.
+
+// Meeting event types
+const (
+	EventScreenShareStarted = "screenshare-started"
+	EventScreenShareStopped = "screenshare-stopped"
+	EventRecordingStarted   = "recording-started"
+	EventRecordingStopped   = "recording-stopped"
+	EventPollCreated        = "poll-created"
+	EventPollClosed         = "poll-closed"
+)
+
+// MeetingEvent is one entry of a meeting's timeline
+type MeetingEvent struct {
+	ID        string                 `json:"id" bson:"_id"`
+	MeetingID string                 `json:"meetingId" bson:"meetingId"`
+	Type      string                 `json:"type" bson:"type"`
+	UserID    string                 `json:"userId,omitempty" bson:"userId,omitempty"`
+	UserName  string                 `json:"userName,omitempty" bson:"userName,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty" bson:"details,omitempty"`
+	At        time.Time              `json:"at" bson:"at"`
+	// Seconds into the recording, when the timeline is fetched for one
+	Offset *float64 `json:"offsetSeconds,omitempty" bson:"-"`
+}
+
+// recordMeetingEvent stores a timeline event; failures are logged only
+func recordMeetingEvent(ctx context.Context, meetingID, eventType, userID, userName string, details map[string]interface{}) {
+	ctx = meetingTenantContext(ctx, meetingID)
+	_, err := db.MeetingEvents.InsertOne(ctx, MeetingEvent{
+		ID:        uuid.New().String(),
+		MeetingID: meetingID,
+		Type:      eventType,
+		UserID:    userID,
+		UserName:  userName,
+		Details:   details,
+		At:        time.Now(),
+	})
+	if err != nil {
+		log.Printf("Error recording %s event in meeting %s: %v", eventType, meetingID, err)
+	}
+}
+
+// loadMeetingTimeline returns the meeting's events and attendance in order
+func loadMeetingTimeline(ctx context.Context, meetingID string) ([]MeetingEvent, error) {
+	byTime := options.Find().SetSort(bson.D{{Key: "at", Value: 1}})
+
+	cursor, err := db.MeetingEvents.Find(ctx, bson.M{"meetingId": meetingID}, byTime)
+	if err != nil {
+		return nil, err
+	}
+	events := []MeetingEvent{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+
+	cursor, err = db.AttendanceEvents.Find(ctx, bson.M{"meetingId": meetingID}, byTime)
+	if err != nil {
+		return nil, err
+	}
+	var attendance []AttendanceEvent
+	if err := cursor.All(ctx, &attendance); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]string, len(attendance))
+	for _, a := range attendance {
+		names[a.UserID] = a.UserName
+		events = append(events, MeetingEvent{
+			ID:        a.ID,
+			MeetingID: a.MeetingID,
+			Type:      a.Type,
+			UserID:    a.UserID,
+			UserName:  a.UserName,
+			At:        a.At,
+		})
+	}
+	// Events recorded outside the meeting's connections carry no name
+	for i := range events {
+		if events[i].UserName == "" {
+			events[i].UserName = names[events[i].UserID]
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].At.Before(events[j].At) })
+	return events, nil
+}
+
+// getMeetingTimelineHandler returns the meeting's timeline to the host and
+// attendees. With ?recordingId= it is limited to that recording and each
+// event carries its offset into it.
+func getMeetingTimelineHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	meeting, err := findMeeting(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+	if !isHostOrAttendee(r.Context(), meeting, userID) {
+		sendCodedError(w, ErrCodeHostOnly, "Only the host and attendees can view the timeline")
+		return
+	}
+
+	var recording *Recording
+	if recordingID := r.URL.Query().Get("recordingId"); recordingID != "" {
+		var found Recording
+		err := db.Recordings.FindOne(r.Context(), bson.M{"_id": recordingID, "meetingId": meeting.ID}).Decode(&found)
+		if err != nil {
+			sendErrorResponse(w, "Recording not found", http.StatusNotFound)
+			return
+		}
+		recording = &found
+	}
+
+	events, err := loadMeetingTimeline(r.Context(), meeting.ID)
+	if err != nil {
+		log.Printf("Error loading timeline of meeting %s: %v", meeting.ID, err)
+		sendErrorResponse(w, "Failed to fetch timeline", http.StatusInternalServerError)
+		return
+	}
+
+	if recording != nil {
+		length := time.Duration(recording.DurationSeconds) * time.Second
+		within := events[:0]
+		for _, event := range events {
+			offset := event.At.Sub(recording.StartedAt)
+			if offset < 0 || (length > 0 && offset > length) {
+				continue
+			}
+			seconds := offset.Seconds()
+			event.Offset = &seconds
+			within = append(within, event)
+		}
+		events = within
+	}
+
+	sendSuccessResponse(w, events)
+}
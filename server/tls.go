@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"video-meeting-app/config"
+)
+
+// Timeouts of the plain HTTP listener, which only answers ACME challenges and
+// redirects
+const (
+	HTTPRedirectReadTimeout  = 5 * time.Second
+	HTTPRedirectWriteTimeout = 5 * time.Second
+)
+
+// newCertManager obtains and renews certificates for the configured domains
+func newCertManager(cfg config.TLSConfig) *autocert.Manager {
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(cfg.Domains...),
+		Cache:      autocert.DirCache(cfg.CacheDir),
+		Email:      cfg.Email,
+	}
+	if cfg.DirectoryURL != "" {
+		manager.Client = &acme.Client{DirectoryURL: cfg.DirectoryURL}
+	}
+	return manager
+}
+
+// newHTTPRedirectServer answers ACME HTTP-01 challenges and redirects every
+// other request to the same URL over HTTPS
+func newHTTPRedirectServer(manager *autocert.Manager, cfg config.TLSConfig, httpsPort string) *http.Server {
+	redirect := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+
+	return &http.Server{
+		Addr:         ":" + cfg.HTTPPort,
+		Handler:      manager.HTTPHandler(redirect),
+		ReadTimeout:  HTTPRedirectReadTimeout,
+		WriteTimeout: HTTPRedirectWriteTimeout,
+	}
+}
+
+// withHSTS tells browsers to use HTTPS for the server's host from now on
+func withHSTS(cfg config.TLSConfig, next http.Handler) http.Handler {
+	if cfg.HSTSMaxAge <= 0 {
+		return next
+	}
+	value := fmt.Sprintf("max-age=%d", int64(time.Duration(cfg.HSTSMaxAge)/time.Second))
+	if cfg.HSTSIncludeSubdomains {
+		value += "; includeSubDomains"
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			w.Header().Set("Strict-Transport-Security", value)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
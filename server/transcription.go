@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3/pkg/media/oggwriter"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+)
+
+const (
+	// A segment ends after this much silence, or at the maximum length
+	transcriptSilenceGap  = 800 * time.Millisecond
+	transcriptMaxSegment  = 30 * time.Second
+	transcriptMinSpeech   = 300 * time.Millisecond
+	transcriptQueueSize   = 200
+	transcriptSegmentWait = 2 * time.Minute
+	// Interim captions are best effort and the first work dropped under load
+	captionQueueSize = 50
+	// Finished segments are remembered this long so late interim results are discarded
+	captionFinishedTTL = time.Minute
+)
+
+// TranscriptUtterance is one stretch of speech by one participant
+type TranscriptUtterance struct {
+	ID        string    `json:"id" bson:"_id"`
+	MeetingID string    `json:"meetingId" bson:"meetingId"`
+	UserID    string    `json:"userId" bson:"userId"`
+	UserName  string    `json:"userName" bson:"userName"`
+	PeerID    string    `json:"peerId" bson:"peerId"`
+	Text      string    `json:"text" bson:"text"`
+	StartedAt time.Time `json:"startedAt" bson:"startedAt"`
+	EndedAt   time.Time `json:"endedAt" bson:"endedAt"`
+	Provider  string    `json:"provider" bson:"provider"`
+}
+
+// transcriptSegment is a clip of one speaker waiting to be transcribed. Interim
+// segments are the speech so far of a segment still in progress; they only
+// produce live captions and are not stored.
+type transcriptSegment struct {
+	id        string
+	final     bool
+	inFlight  *atomic.Bool // cleared once an interim segment is done
+	meetingID string
+	userID    string
+	userName  string
+	peerID    string
+	audio     []byte // Ogg Opus
+	startedAt time.Time
+	endedAt   time.Time
+}
+
+// TranscriptionService sends speech segments to the STT provider, stores the
+// resulting utterances and broadcasts them as live captions
+type TranscriptionService struct {
+	provider        STTProvider
+	language        string
+	workers         int
+	captionInterval time.Duration
+	segments        chan transcriptSegment
+	interim         chan transcriptSegment
+
+	mu       sync.Mutex
+	finished map[string]time.Time // segment ID -> when its final caption was sent
+}
+
+// transcriber is nil unless a speech-to-text provider is configured
+var transcriber *TranscriptionService
+
+func newTranscriptionService(provider STTProvider, language string, workers int, captionInterval time.Duration) *TranscriptionService {
+	return &TranscriptionService{
+		provider:        provider,
+		language:        language,
+		workers:         workers,
+		captionInterval: captionInterval,
+		segments:        make(chan transcriptSegment, transcriptQueueSize),
+		interim:         make(chan transcriptSegment, captionQueueSize),
+		finished:        make(map[string]time.Time),
+	}
+}
+
+// Run transcribes queued segments until ctx is cancelled
+func (t *TranscriptionService) Run(ctx context.Context) {
+	for i := 0; i < t.workers; i++ {
+		go func() {
+			for {
+				// Final segments go first
+				select {
+				case segment := <-t.segments:
+					t.transcribe(ctx, segment)
+					continue
+				default:
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case segment := <-t.segments:
+					t.transcribe(ctx, segment)
+				case segment := <-t.interim:
+					t.transcribe(ctx, segment)
+				}
+			}
+		}()
+	}
+}
+
+// Submit queues a segment; segments are dropped while the provider is backed up
+func (t *TranscriptionService) Submit(segment transcriptSegment) {
+	if !segment.final {
+		select {
+		case t.interim <- segment:
+		default:
+			segment.inFlight.Store(false)
+		}
+		return
+	}
+	select {
+	case t.segments <- segment:
+	default:
+		log.Printf("Transcription: queue full, dropping %v of speech from %s in meeting %s",
+			segment.endedAt.Sub(segment.startedAt), segment.userID, segment.meetingID)
+	}
+}
+
+func (t *TranscriptionService) transcribe(ctx context.Context, segment transcriptSegment) {
+	if !segment.final {
+		defer segment.inFlight.Store(false)
+	}
+	ctx, cancel := context.WithTimeout(ctx, transcriptSegmentWait)
+	defer cancel()
+
+	text, err := t.provider.Transcribe(ctx, segment.audio, t.language)
+	if err != nil {
+		log.Printf("Transcription: %s failed for meeting %s: %v", t.provider.Name(), segment.meetingID, err)
+		return
+	}
+
+	if !segment.final {
+		if text != "" && !t.isFinished(segment.id) {
+			broadcastCaption(segment, text)
+		}
+		return
+	}
+	t.markFinished(segment.id)
+	if text == "" {
+		return
+	}
+	broadcastCaption(segment, text)
+
+	_, err = db.TranscriptUtterances.InsertOne(meetingTenantContext(ctx, segment.meetingID), TranscriptUtterance{
+		ID:        segment.id,
+		MeetingID: segment.meetingID,
+		UserID:    segment.userID,
+		UserName:  segment.userName,
+		PeerID:    segment.peerID,
+		Text:      text,
+		StartedAt: segment.startedAt,
+		EndedAt:   segment.endedAt,
+		Provider:  t.provider.Name(),
+	})
+	if err != nil {
+		log.Printf("Transcription: error storing utterance for meeting %s: %v", segment.meetingID, err)
+	}
+}
+
+func (t *TranscriptionService) isFinished(segmentID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.finished[segmentID]
+	return ok
+}
+
+func (t *TranscriptionService) markFinished(segmentID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	for id, at := range t.finished {
+		if now.Sub(at) > captionFinishedTTL {
+			delete(t.finished, id)
+		}
+	}
+	t.finished[segmentID] = now
+}
+
+// broadcastCaption sends a live caption to the meeting. Captions of one
+// segment share its ID; clients replace interim text until the final one.
+func broadcastCaption(segment transcriptSegment, text string) {
+	hub.BroadcastToMeeting(segment.meetingID, WebSocketMessage{
+		Type: "caption",
+		Data: map[string]interface{}{
+			"segmentId": segment.id,
+			"peerId":    segment.peerID,
+			"userId":    segment.userID,
+			"userName":  segment.userName,
+			"text":      text,
+			"final":     segment.final,
+			"startedAt": segment.startedAt,
+		},
+		UserID: segment.userID,
+	}, nil)
+}
+
+// audioSegmenter cuts one publisher's audio track into speech segments at
+// pauses, using the audio level clients put in each packet
+type audioSegmenter struct {
+	meetingID string
+	publisher *Client
+
+	buf         bytes.Buffer
+	ogg         *oggwriter.OggWriter // nil between segments
+	segmentID   string
+	startedAt   time.Time
+	lastSpeech  time.Time
+	lastPacket  time.Time
+	lastInterim time.Time
+	// Set while an interim transcription of this segment is pending, so a
+	// slow provider is not sent overlapping requests
+	interimInFlight atomic.Bool
+}
+
+func newAudioSegmenter(meetingID string, publisher *Client) *audioSegmenter {
+	return &audioSegmenter{meetingID: meetingID, publisher: publisher}
+}
+
+// write adds a packet to the current segment. Without an audio level every
+// packet counts as speech and segments are cut at the maximum length.
+func (s *audioSegmenter) write(packet *rtp.Packet, level uint8, hasLevel bool, now time.Time) {
+	// Clients may stop sending during silence, so a gap in packets is a pause too
+	if s.ogg != nil && now.Sub(s.lastPacket) >= transcriptSilenceGap {
+		s.flush()
+	}
+	s.lastPacket = now
+
+	speaking := !hasLevel || level <= activeSpeakerMaxLevel
+	if s.ogg == nil {
+		if !speaking {
+			return
+		}
+		s.buf.Reset()
+		ogg, err := oggwriter.NewWith(&s.buf, 48000, 2)
+		if err != nil {
+			log.Printf("Transcription: error starting segment: %v", err)
+			return
+		}
+		s.ogg = ogg
+		s.segmentID = uuid.New().String()
+		s.startedAt = now
+		s.lastSpeech = now
+		s.lastInterim = now
+	}
+
+	if err := s.ogg.WriteRTP(packet); err != nil {
+		log.Printf("Transcription: error buffering audio: %v", err)
+		s.ogg = nil
+		return
+	}
+	if speaking {
+		s.lastSpeech = now
+	}
+	if now.Sub(s.lastSpeech) >= transcriptSilenceGap || now.Sub(s.startedAt) >= transcriptMaxSegment {
+		s.flush()
+		return
+	}
+
+	interval := transcriber.captionInterval
+	if interval > 0 && speaking && now.Sub(s.lastInterim) >= interval && s.interimInFlight.CompareAndSwap(false, true) {
+		s.lastInterim = now
+		transcriber.Submit(s.segment(false))
+	}
+}
+
+// segment snapshots the audio buffered so far
+func (s *audioSegmenter) segment(final bool) transcriptSegment {
+	return transcriptSegment{
+		id:        s.segmentID,
+		final:     final,
+		inFlight:  &s.interimInFlight,
+		meetingID: s.meetingID,
+		userID:    s.publisher.userID,
+		userName:  s.publisher.userName,
+		peerID:    s.publisher.peerID,
+		audio:     bytes.Clone(s.buf.Bytes()),
+		startedAt: s.startedAt,
+		endedAt:   s.lastSpeech,
+	}
+}
+
+// flush submits the current segment if it holds enough speech
+func (s *audioSegmenter) flush() {
+	if s.ogg == nil {
+		return
+	}
+	s.ogg.Close()
+	s.ogg = nil
+
+	if s.lastSpeech.Sub(s.startedAt) < transcriptMinSpeech {
+		return
+	}
+	transcriber.Submit(s.segment(true))
+}
+
+// transcriptionHandler returns a handler with which the host starts or stops
+// transcribing the meeting. Participants are told so clients can show it.
+func transcriptionHandler(enabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := getUserIDFromToken(r)
+		if userID == "" {
+			sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if transcriber == nil {
+			sendErrorResponse(w, "Transcription is not enabled on this server", http.StatusServiceUnavailable)
+			return
+		}
+
+		meeting, err := findMeeting(r.Context(), mux.Vars(r)["id"])
+		if err != nil {
+			sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+			return
+		}
+		if meeting.CreatedBy != userID {
+			sendCodedError(w, ErrCodeHostOnly, "Only the host can control transcription")
+			return
+		}
+		if enabled && !meeting.IsActive {
+			sendCodedError(w, ErrCodeMeetingEnded, "Meeting has ended")
+			return
+		}
+		// The server can't hear an end-to-end encrypted meeting
+		if enabled && meeting.E2EE {
+			sendCodedError(w, ErrCodeConflict, "End-to-end encrypted meetings cannot be transcribed")
+			return
+		}
+
+		_, err = db.Meetings.UpdateOne(
+			r.Context(),
+			bson.M{"_id": meeting.ID},
+			bson.M{"$set": bson.M{"transcriptionEnabled": enabled, "updatedAt": time.Now()}},
+		)
+		if err != nil {
+			log.Printf("Error updating transcription: %v", err)
+			sendErrorResponse(w, "Failed to update meeting", http.StatusInternalServerError)
+			return
+		}
+		meetingLookups.Invalidate(r.Context(), meeting.ID)
+		sfu.SetTranscribing(meeting.ID, enabled)
+
+		eventType := "transcription-stopped"
+		if enabled {
+			eventType = "transcription-started"
+		}
+		hub.BroadcastToMeeting(meeting.ID, WebSocketMessage{
+			Type:   eventType,
+			Data:   map[string]bool{"transcriptionEnabled": enabled},
+			UserID: userID,
+		}, nil)
+
+		sendSuccessResponse(w, map[string]interface{}{"id": meeting.ID, "transcriptionEnabled": enabled})
+	}
+}
+
+// getTranscriptHandler returns the meeting's utterances in order, as JSON or
+// as plain text with ?format=txt
+func getTranscriptHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	meeting, err := findMeeting(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+	if !isHostOrAttendee(r.Context(), meeting, userID) {
+		sendCodedError(w, ErrCodeHostOnly, "Only the host and attendees can view the transcript")
+		return
+	}
+
+	cursor, err := db.TranscriptUtterances.Find(
+		r.Context(),
+		bson.M{"meetingId": meeting.ID},
+		options.Find().SetSort(bson.D{{Key: "startedAt", Value: 1}}),
+	)
+	if err != nil {
+		sendErrorResponse(w, "Failed to fetch transcript", http.StatusInternalServerError)
+		return
+	}
+	utterances := []TranscriptUtterance{}
+	if err := cursor.All(r.Context(), &utterances); err != nil {
+		sendErrorResponse(w, "Failed to parse transcript", http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "txt" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="transcript-%s.txt"`, meeting.ID))
+		w.WriteHeader(http.StatusOK)
+		for _, u := range utterances {
+			fmt.Fprintf(w, "[%s] %s: %s\n", u.StartedAt.UTC().Format("15:04:05"), u.UserName, u.Text)
+		}
+		return
+	}
+
+	sendSuccessResponse(w, map[string]interface{}{
+		"meetingId":            meeting.ID,
+		"transcriptionEnabled": meeting.TranscriptionEnabled,
+		"utterances":           utterances,
+	})
+}
@@ -0,0 +1,474 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+)
+
+const (
+	WebhookHTTPTimeout       = 10 * time.Second
+	WebhookScanInterval      = 15 * time.Second
+	WebhookDeliveryLease     = 1 * time.Minute
+	WebhookRetryBaseDelay    = 30 * time.Second
+	WebhookMaxAttempts       = 5
+	DefaultDeliveriesPerPage = 50
+	MaxDeliveriesPerPage     = 200
+	maxWebhookErrorLength    = 500
+)
+
+// Webhook event types
+const (
+	WebhookMeetingStarted    = "meeting.started"
+	WebhookMeetingEnded      = "meeting.ended"
+	WebhookParticipantJoined = "participant.joined"
+	WebhookRecordingReady    = "recording.ready"
+)
+
+var supportedWebhookEvents = map[string]bool{
+	WebhookMeetingStarted:    true,
+	WebhookMeetingEnded:      true,
+	WebhookParticipantJoined: true,
+	WebhookRecordingReady:    true,
+}
+
+// Webhook delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is an endpoint a user registered to receive events about their meetings
+type Webhook struct {
+	ID        string    `json:"id" bson:"_id"`
+	UserID    string    `json:"userId" bson:"userId"`
+	URL       string    `json:"url" bson:"url"`
+	Secret    string    `json:"-" bson:"secret"`
+	Events    []string  `json:"events" bson:"events"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+	TenantID  string    `json:"-" bson:"tenantId,omitempty"`
+}
+
+// WebhookDelivery is one event queued for (or delivered to) one webhook
+type WebhookDelivery struct {
+	ID             string     `json:"id" bson:"_id"`
+	WebhookID      string     `json:"webhookId" bson:"webhookId"`
+	Event          string     `json:"event" bson:"event"`
+	Payload        string     `json:"payload" bson:"payload"`
+	Status         string     `json:"status" bson:"status"`
+	Attempts       int        `json:"attempts" bson:"attempts"`
+	LastStatusCode int        `json:"lastStatusCode,omitempty" bson:"lastStatusCode,omitempty"`
+	LastError      string     `json:"lastError,omitempty" bson:"lastError,omitempty"`
+	NextAttemptAt  time.Time  `json:"nextAttemptAt" bson:"nextAttemptAt"`
+	CreatedAt      time.Time  `json:"createdAt" bson:"createdAt"`
+	DeliveredAt    *time.Time `json:"deliveredAt,omitempty" bson:"deliveredAt,omitempty"`
+}
+
+// WebhookEnvelope is the JSON body POSTed to webhook endpoints
+type WebhookEnvelope struct {
+	ID        string      `json:"id"`
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"createdAt"`
+	Data      interface{} `json:"data"`
+}
+
+// webhookHTTPClient only connects to public addresses, so a webhook can't
+// reach the server's own network
+var webhookHTTPClient = newPublicHTTPClient(WebhookHTTPTimeout)
+
+// webhookWake nudges the delivery worker when new deliveries are queued
+var webhookWake = make(chan struct{}, 1)
+
+// signWebhookPayload returns the hex HMAC-SHA256 of "timestamp.body" keyed
+// with the webhook's secret
+func signWebhookPayload(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func generateWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+func createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+		Secret string   `json:"secret,omitempty"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+
+	if err := validatePublicURL(req.URL); errors.Is(err, errNonPublicAddress) {
+		sendErrorResponse(w, "url must point to a public address", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		sendErrorResponse(w, "A valid http(s) url is required", http.StatusBadRequest)
+		return
+	}
+	events := uniqueStrings(req.Events)
+	if len(events) == 0 {
+		sendErrorResponse(w, "At least one event is required", http.StatusBadRequest)
+		return
+	}
+	for _, event := range events {
+		if !supportedWebhookEvents[event] {
+			sendErrorResponse(w, fmt.Sprintf("Unsupported event: %s", event), http.StatusBadRequest)
+			return
+		}
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		var err error
+		if secret, err = generateWebhookSecret(); err != nil {
+			sendErrorResponse(w, "Failed to generate secret", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	webhook := Webhook{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		URL:       req.URL,
+		Secret:    secret,
+		Events:    events,
+		CreatedAt: time.Now(),
+	}
+	if _, err := db.Webhooks.InsertOne(r.Context(), webhook); err != nil {
+		log.Printf("Error creating webhook: %v", err)
+		sendErrorResponse(w, "Failed to create webhook", http.StatusInternalServerError)
+		return
+	}
+
+	// The secret is only ever returned here
+	sendSuccessResponse(w, map[string]interface{}{
+		"webhook": webhook,
+		"secret":  secret,
+	})
+}
+
+func getWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	cursor, err := db.Webhooks.Find(
+		r.Context(),
+		bson.M{"userId": userID},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}),
+	)
+	if err != nil {
+		sendErrorResponse(w, "Failed to fetch webhooks", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(r.Context())
+
+	webhooks := []Webhook{}
+	if err := cursor.All(r.Context(), &webhooks); err != nil {
+		sendErrorResponse(w, "Failed to parse webhooks", http.StatusInternalServerError)
+		return
+	}
+
+	sendSuccessResponse(w, webhooks)
+}
+
+func deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	result, err := db.Webhooks.DeleteOne(r.Context(), bson.M{"_id": vars["id"], "userId": userID})
+	if err != nil {
+		sendErrorResponse(w, "Failed to delete webhook", http.StatusInternalServerError)
+		return
+	}
+	if result.DeletedCount == 0 {
+		sendErrorResponse(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+
+	sendSuccessResponse(w, map[string]string{"message": "Webhook deleted"})
+}
+
+// getWebhookDeliveriesHandler returns a webhook's delivery log, newest first
+func getWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	count, err := db.Webhooks.CountDocuments(r.Context(), bson.M{"_id": vars["id"], "userId": userID})
+	if err != nil {
+		sendErrorResponse(w, "Failed to fetch webhook", http.StatusInternalServerError)
+		return
+	}
+	if count == 0 {
+		sendErrorResponse(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+
+	limit := DefaultDeliveriesPerPage
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			sendErrorResponse(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, MaxDeliveriesPerPage)
+	}
+
+	filter := bson.M{"webhookId": vars["id"]}
+	if status := r.URL.Query().Get("status"); status != "" {
+		filter["status"] = status
+	}
+
+	cursor, err := db.WebhookDeliveries.Find(
+		r.Context(),
+		filter,
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetLimit(int64(limit)),
+	)
+	if err != nil {
+		sendErrorResponse(w, "Failed to fetch deliveries", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(r.Context())
+
+	deliveries := []WebhookDelivery{}
+	if err := cursor.All(r.Context(), &deliveries); err != nil {
+		sendErrorResponse(w, "Failed to parse deliveries", http.StatusInternalServerError)
+		return
+	}
+
+	sendSuccessResponse(w, deliveries)
+}
+
+// emitWebhookEvent queues the event for every webhook of the user subscribed
+// to it. Webhooks receive domain events through the event bus rather than
+// from here directly; see event_bus.go.
+func emitWebhookEvent(ctx context.Context, userID, event string, data interface{}) error {
+	cursor, err := db.Webhooks.Find(ctx, bson.M{"userId": userID, "events": event})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var webhooks []Webhook
+	if err := cursor.All(ctx, &webhooks); err != nil {
+		return err
+	}
+	if len(webhooks) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	for _, webhook := range webhooks {
+		deliveryID := uuid.New().String()
+		payload, err := json.Marshal(WebhookEnvelope{ID: deliveryID, Event: event, CreatedAt: now, Data: data})
+		if err != nil {
+			log.Printf("Error marshaling %s webhook payload: %v", event, err)
+			continue
+		}
+		// Deliveries are queued in their webhook's tenant, also when the
+		// event comes from a background job
+		deliveryCtx := ctx
+		if _, scoped := db.TenantFromContext(ctx); !scoped {
+			deliveryCtx = db.WithTenant(ctx, webhook.TenantID)
+		}
+		_, err = db.WebhookDeliveries.InsertOne(deliveryCtx, WebhookDelivery{
+			ID:            deliveryID,
+			WebhookID:     webhook.ID,
+			Event:         event,
+			Payload:       string(payload),
+			Status:        DeliveryPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+		if err != nil {
+			log.Printf("Error queueing %s delivery for webhook %s: %v", event, webhook.ID, err)
+		}
+	}
+
+	select {
+	case webhookWake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// startWebhookDeliveryJob delivers queued webhook events and retries failed
+// ones with exponential backoff, until ctx is cancelled
+func startWebhookDeliveryJob(ctx context.Context) {
+	ticker := time.NewTicker(WebhookScanInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-webhookWake:
+		}
+		deliverDueWebhooks(ctx)
+	}
+}
+
+// deliverDueWebhooks attempts every due delivery. Each one is leased first so
+// concurrent workers (or instances) don't send it twice.
+func deliverDueWebhooks(ctx context.Context) {
+	for ctx.Err() == nil {
+		now := time.Now()
+		var delivery WebhookDelivery
+		err := db.WebhookDeliveries.FindOneAndUpdate(
+			ctx,
+			bson.M{"status": DeliveryPending, "nextAttemptAt": bson.M{"$lte": now}},
+			bson.M{"$set": bson.M{"nextAttemptAt": now.Add(WebhookDeliveryLease)}},
+			options.FindOneAndUpdate().
+				SetSort(bson.D{{Key: "nextAttemptAt", Value: 1}}).
+				SetReturnDocument(options.After),
+		).Decode(&delivery)
+		if err == mongo.ErrNoDocuments {
+			return
+		}
+		if err != nil {
+			log.Printf("Webhooks: failed to claim delivery: %v", err)
+			return
+		}
+		attemptWebhookDelivery(ctx, delivery)
+	}
+}
+
+func attemptWebhookDelivery(ctx context.Context, delivery WebhookDelivery) {
+	ctx, span := tracer.Start(ctx, "webhooks.deliver")
+	defer span.End()
+
+	var webhook Webhook
+	if err := db.Webhooks.FindOne(ctx, bson.M{"_id": delivery.WebhookID}).Decode(&webhook); err != nil {
+		finishWebhookDelivery(ctx, delivery, DeliveryFailed, 0, "webhook no longer exists")
+		return
+	}
+
+	statusCode, err := postWebhook(ctx, webhook, delivery)
+	if err == nil {
+		finishWebhookDelivery(ctx, delivery, DeliverySucceeded, statusCode, "")
+		return
+	}
+
+	if delivery.Attempts+1 >= WebhookMaxAttempts {
+		finishWebhookDelivery(ctx, delivery, DeliveryFailed, statusCode, err.Error())
+		return
+	}
+
+	delay := WebhookRetryBaseDelay << delivery.Attempts
+	_, updateErr := db.WebhookDeliveries.UpdateOne(
+		ctx,
+		bson.M{"_id": delivery.ID},
+		bson.M{
+			"$inc": bson.M{"attempts": 1},
+			"$set": bson.M{
+				"lastStatusCode": statusCode,
+				"lastError":      truncateString(err.Error(), maxWebhookErrorLength),
+				"nextAttemptAt":  time.Now().Add(delay),
+			},
+		},
+	)
+	if updateErr != nil {
+		log.Printf("Webhooks: failed to reschedule delivery %s: %v", delivery.ID, updateErr)
+	}
+}
+
+func finishWebhookDelivery(ctx context.Context, delivery WebhookDelivery, status string, statusCode int, errMessage string) {
+	set := bson.M{"status": status, "lastStatusCode": statusCode}
+	if status == DeliverySucceeded {
+		set["deliveredAt"] = time.Now()
+	}
+	if errMessage != "" {
+		set["lastError"] = truncateString(errMessage, maxWebhookErrorLength)
+	}
+
+	_, err := db.WebhookDeliveries.UpdateOne(
+		ctx,
+		bson.M{"_id": delivery.ID},
+		bson.M{"$inc": bson.M{"attempts": 1}, "$set": set},
+	)
+	if err != nil {
+		log.Printf("Webhooks: failed to update delivery %s: %v", delivery.ID, err)
+	}
+}
+
+// postWebhook sends one signed delivery; any non-2xx response is an error
+func postWebhook(ctx context.Context, webhook Webhook, delivery WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Id", delivery.ID)
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+signWebhookPayload(webhook.Secret, timestamp, body))
+
+	resp, err := webhookHTTPClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// truncateString shortens s to at most n bytes
+func truncateString(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
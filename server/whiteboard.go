@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"video-meeting-app/db"
+)
+
+// A meeting's whiteboard is a log of numbered events, relayed to the meeting
+// as they are made. Events are folded into a snapshot every so often, so a
+// late joiner loads the snapshot and the few events after it rather than the
+// whole log. Strokes still being drawn are not logged; in SFU mode clients
+// send them over the "whiteboard" data channel.
+
+const (
+	// WhiteboardCompactEvery is how many events may follow the snapshot
+	// before they are folded into it
+	WhiteboardCompactEvery = 200
+	MaxStrokePoints        = 1000
+	MaxStrokeWidth         = 100
+	// An event numbered but never stored, e.g. after a failed insert, is
+	// skipped once the events after it are this old
+	whiteboardGapTimeout = 30 * time.Second
+)
+
+// Whiteboard operations
+const (
+	WhiteboardOpStroke = "stroke" // add a stroke, or replace one with the same ID
+	WhiteboardOpErase  = "erase"
+	WhiteboardOpClear  = "clear"
+)
+
+// WhiteboardStroke is one line drawn on the board, in board coordinates
+type WhiteboardStroke struct {
+	ID     string       `json:"id" bson:"id"`
+	UserID string       `json:"userId" bson:"userId"`
+	Tool   string       `json:"tool,omitempty" bson:"tool,omitempty"`
+	Color  string       `json:"color,omitempty" bson:"color,omitempty"`
+	Width  float64      `json:"width,omitempty" bson:"width,omitempty"`
+	Points [][2]float64 `json:"points" bson:"points"`
+}
+
+// WhiteboardEvent is one change to a meeting's whiteboard
+type WhiteboardEvent struct {
+	ID        string            `json:"-" bson:"_id"` // meetingId:seq
+	MeetingID string            `json:"meetingId" bson:"meetingId"`
+	Seq       int64             `json:"seq" bson:"seq"`
+	Op        string            `json:"op" bson:"op"`
+	Stroke    *WhiteboardStroke `json:"stroke,omitempty" bson:"stroke,omitempty"`
+	StrokeID  string            `json:"strokeId,omitempty" bson:"strokeId,omitempty"` // erased stroke
+	UserID    string            `json:"userId" bson:"userId"`
+	CreatedAt time.Time         `json:"createdAt" bson:"createdAt"`
+}
+
+// WhiteboardSnapshot is a meeting's board as of event Seq
+type WhiteboardSnapshot struct {
+	MeetingID string             `json:"meetingId" bson:"_id"`
+	Seq       int64              `json:"seq" bson:"seq"`
+	LastSeq   int64              `json:"-" bson:"lastSeq"` // the last event number handed out
+	Strokes   []WhiteboardStroke `json:"strokes" bson:"strokes"`
+	UpdatedAt time.Time          `json:"updatedAt" bson:"updatedAt"`
+}
+
+// apply folds an event into the snapshot's strokes
+func (s *WhiteboardSnapshot) apply(event WhiteboardEvent) {
+	switch event.Op {
+	case WhiteboardOpClear:
+		s.Strokes = []WhiteboardStroke{}
+	case WhiteboardOpStroke, WhiteboardOpErase:
+		id := event.StrokeID
+		if event.Stroke != nil {
+			id = event.Stroke.ID
+		}
+		kept := s.Strokes[:0]
+		for _, stroke := range s.Strokes {
+			if stroke.ID != id {
+				kept = append(kept, stroke)
+			}
+		}
+		s.Strokes = kept
+		if event.Stroke != nil {
+			s.Strokes = append(s.Strokes, *event.Stroke)
+		}
+	}
+	s.Seq = event.Seq
+}
+
+// nextWhiteboardSeq numbers a new event of the meeting's board. The counter
+// lives on the snapshot so every instance draws from it; the snapshot's Seq
+// is returned too.
+func nextWhiteboardSeq(ctx context.Context, meetingID string) (int64, int64, error) {
+	var snapshot WhiteboardSnapshot
+	err := db.WhiteboardSnapshots.FindOneAndUpdate(ctx,
+		bson.M{"_id": meetingID},
+		bson.M{
+			"$inc":         bson.M{"lastSeq": 1},
+			"$setOnInsert": bson.M{"seq": 0, "strokes": []WhiteboardStroke{}, "updatedAt": time.Now()},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After).
+			SetProjection(bson.M{"seq": 1, "lastSeq": 1}),
+	).Decode(&snapshot)
+	return snapshot.LastSeq, snapshot.Seq, err
+}
+
+// loadWhiteboard returns the meeting's current board: its snapshot with the
+// events since folded in, up to the first one not yet stored. It also
+// returns the Seq of the stored snapshot.
+func loadWhiteboard(ctx context.Context, meetingID string) (WhiteboardSnapshot, int64, error) {
+	snapshot := WhiteboardSnapshot{MeetingID: meetingID, Strokes: []WhiteboardStroke{}}
+	err := db.WhiteboardSnapshots.FindOne(ctx, bson.M{"_id": meetingID}).Decode(&snapshot)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return snapshot, 0, err
+	}
+	if snapshot.Strokes == nil {
+		snapshot.Strokes = []WhiteboardStroke{}
+	}
+	base := snapshot.Seq
+
+	cursor, err := db.WhiteboardEvents.Find(ctx,
+		bson.M{"meetingId": meetingID, "seq": bson.M{"$gt": base}},
+		options.Find().SetSort(bson.D{{Key: "seq", Value: 1}}),
+	)
+	if err != nil {
+		return snapshot, base, err
+	}
+	var events []WhiteboardEvent
+	if err := cursor.All(ctx, &events); err != nil {
+		return snapshot, base, err
+	}
+	for _, event := range events {
+		// A gap is usually an event still being stored; later ones wait for it
+		if event.Seq != snapshot.Seq+1 && time.Since(event.CreatedAt) < whiteboardGapTimeout {
+			break
+		}
+		snapshot.apply(event)
+	}
+	return snapshot, base, nil
+}
+
+// compactWhiteboard folds the events after the snapshot into it and deletes
+// them. Compactions racing on the same board leave it to the first one.
+func compactWhiteboard(ctx context.Context, meetingID string) {
+	ctx = meetingTenantContext(ctx, meetingID)
+	snapshot, base, err := loadWhiteboard(ctx, meetingID)
+	if err != nil {
+		log.Printf("Error loading whiteboard of meeting %s: %v", meetingID, err)
+		return
+	}
+	if snapshot.Seq == base {
+		return
+	}
+
+	result, err := db.WhiteboardSnapshots.UpdateOne(ctx,
+		bson.M{"_id": meetingID, "seq": base},
+		bson.M{"$set": bson.M{"seq": snapshot.Seq, "strokes": snapshot.Strokes, "updatedAt": time.Now()}},
+	)
+	if err != nil {
+		log.Printf("Error compacting whiteboard of meeting %s: %v", meetingID, err)
+		return
+	}
+	if result.MatchedCount == 0 {
+		return
+	}
+	if _, err := db.WhiteboardEvents.DeleteMany(ctx, bson.M{
+		"meetingId": meetingID,
+		"seq":       bson.M{"$lte": snapshot.Seq},
+	}); err != nil {
+		log.Printf("Error deleting compacted whiteboard events of meeting %s: %v", meetingID, err)
+	}
+}
+
+// validateStroke checks a stroke sent by a client
+func validateStroke(stroke *WhiteboardStroke) bool {
+	if stroke == nil || stroke.ID == "" || len(stroke.ID) > 64 {
+		return false
+	}
+	if len(stroke.Points) == 0 || len(stroke.Points) > MaxStrokePoints {
+		return false
+	}
+	return stroke.Width >= 0 && stroke.Width <= MaxStrokeWidth && len(stroke.Tool) <= 32 && len(stroke.Color) <= 32
+}
+
+// handleWhiteboard records a change to the meeting's whiteboard and relays
+// it, numbered, to everyone in the meeting including the sender. Clearing
+// the board requires the clear-whiteboard permission.
+func handleWhiteboard(ctx context.Context, c *Client, data json.RawMessage) {
+	var req struct {
+		Op       string            `json:"op"`
+		Stroke   *WhiteboardStroke `json:"stroke"`
+		StrokeID string            `json:"strokeId"`
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		c.sendError("Invalid whiteboard message")
+		return
+	}
+
+	event := WhiteboardEvent{
+		MeetingID: c.meetingID,
+		Op:        req.Op,
+		UserID:    c.userID,
+		CreatedAt: time.Now(),
+	}
+	switch req.Op {
+	case WhiteboardOpStroke:
+		if !validateStroke(req.Stroke) {
+			c.sendError("Invalid whiteboard stroke")
+			return
+		}
+		req.Stroke.UserID = c.userID
+		event.Stroke = req.Stroke
+	case WhiteboardOpErase:
+		if req.StrokeID == "" {
+			c.sendError("strokeId is required")
+			return
+		}
+		event.StrokeID = req.StrokeID
+	case WhiteboardOpClear:
+		if _, ok := requireClientPermission(ctx, c, PermissionClearWhiteboard); !ok {
+			return
+		}
+	default:
+		c.sendError("Unknown whiteboard operation")
+		return
+	}
+
+	seq, snapshotSeq, err := nextWhiteboardSeq(ctx, c.meetingID)
+	if err != nil {
+		log.Printf("Error numbering whiteboard event: %v", err)
+		c.sendError("Failed to update whiteboard")
+		return
+	}
+	event.Seq = seq
+	event.ID = c.meetingID + ":" + strconv.FormatInt(seq, 10)
+	if _, err := db.WhiteboardEvents.InsertOne(ctx, event); err != nil {
+		log.Printf("Error saving whiteboard event: %v", err)
+		c.sendError("Failed to update whiteboard")
+		return
+	}
+
+	hub.BroadcastToMeeting(c.meetingID, WebSocketMessage{
+		Type:   "whiteboard",
+		Data:   event,
+		UserID: c.userID,
+	}, nil)
+
+	if seq-snapshotSeq >= WhiteboardCompactEvery {
+		go compactWhiteboard(context.Background(), c.meetingID)
+	}
+}
+
+// getWhiteboardHandler returns the meeting's current board. Clients apply
+// "whiteboard" events with a greater seq on top of it.
+func getWhiteboardHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromToken(r)
+	if userID == "" {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	meeting, err := findMeeting(r.Context(), mux.Vars(r)["id"])
+	if err != nil || !canViewMeeting(meeting, userID) {
+		sendCodedError(w, ErrCodeMeetingNotFound, "Meeting not found")
+		return
+	}
+
+	snapshot, _, err := loadWhiteboard(r.Context(), meeting.ID)
+	if err != nil {
+		sendErrorResponse(w, "Failed to load whiteboard", http.StatusInternalServerError)
+		return
+	}
+	sendSuccessResponse(w, snapshot)
+}
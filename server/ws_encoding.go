@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
+
+	"video-meeting-app/wsproto"
+)
+
+// encodedFrame is a hub message with its encodings, made once per message
+// however many clients receive it. The protobuf form is built on first use,
+// since most meetings have no binary clients. Only used on one shard's goroutine.
+type encodedFrame struct {
+	message WebSocketMessage
+	text    []byte
+	binary  []byte
+}
+
+func newEncodedFrame(message WebSocketMessage) (*encodedFrame, error) {
+	text, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	return &encodedFrame{message: message, text: text}, nil
+}
+
+// bytesFor returns the frame as the client negotiated it
+func (f *encodedFrame) bytesFor(c *Client) []byte {
+	if !c.binary {
+		return f.text
+	}
+	if f.binary == nil {
+		f.binary = protoEnvelope(f.message).Marshal()
+	}
+	return f.binary
+}
+
+// protoEnvelope converts a message for binary clients. Signaling and presence
+// payloads get their own schema; everything else travels as JSON inside the
+// envelope.
+func protoEnvelope(message WebSocketMessage) *wsproto.Envelope {
+	env := &wsproto.Envelope{
+		Type:        message.Type,
+		MeetingID:   message.MeetingID,
+		UserID:      message.UserID,
+		TimestampMs: message.Timestamp.UnixMilli(),
+		Seq:         message.Seq,
+	}
+
+	switch data := message.Data.(type) {
+	case SignalingData:
+		env.Signal = signalToProto(data)
+		return env
+	case webrtc.SessionDescription:
+		env.Description = descriptionToProto(&data)
+		return env
+	case *webrtc.SessionDescription:
+		env.Description = descriptionToProto(data)
+		return env
+	case webrtc.ICECandidateInit:
+		env.Candidate = candidateToProto(&data)
+		return env
+	}
+
+	switch message.Type {
+	case "user-joined", "user-left", "presence-changed", "active-speaker":
+		if peer := peerToProto(message.Data); peer != nil {
+			env.Peer = peer
+			return env
+		}
+	}
+
+	if message.Data != nil {
+		// The whole message already marshaled, so its data does too
+		env.JSON, _ = json.Marshal(message.Data)
+	}
+	return env
+}
+
+func signalToProto(s SignalingData) *wsproto.Signal {
+	return &wsproto.Signal{
+		Type:       s.Type,
+		FromPeerID: s.FromPeerID,
+		ToPeerID:   s.ToPeerID,
+		Offer:      descriptionToProto(s.Offer),
+		Answer:     descriptionToProto(s.Answer),
+		Candidate:  candidateToProto(s.Candidate),
+		StreamType: s.StreamType,
+		ShareToken: s.ShareToken,
+	}
+}
+
+func descriptionToProto(d *webrtc.SessionDescription) *wsproto.SessionDescription {
+	if d == nil {
+		return nil
+	}
+	return &wsproto.SessionDescription{Type: d.Type.String(), SDP: d.SDP}
+}
+
+func candidateToProto(c *webrtc.ICECandidateInit) *wsproto.ICECandidate {
+	if c == nil {
+		return nil
+	}
+	candidate := &wsproto.ICECandidate{
+		Candidate:        c.Candidate,
+		SDPMid:           c.SDPMid,
+		UsernameFragment: c.UsernameFragment,
+	}
+	if c.SDPMLineIndex != nil {
+		index := uint32(*c.SDPMLineIndex)
+		candidate.SDPMLineIndex = &index
+	}
+	return candidate
+}
+
+// peerToProto reads the userId/peerId/online/level map used by presence events
+func peerToProto(data interface{}) *wsproto.Peer {
+	switch fields := data.(type) {
+	case map[string]string:
+		return &wsproto.Peer{UserID: fields["userId"], PeerID: fields["peerId"]}
+	case map[string]interface{}:
+		peer := &wsproto.Peer{}
+		peer.UserID, _ = fields["userId"].(string)
+		peer.PeerID, _ = fields["peerId"].(string)
+		peer.Online, _ = fields["online"].(bool)
+		if level, ok := fields["level"].(uint8); ok {
+			peer.Level = uint32(level)
+		}
+		return peer
+	}
+	return nil
+}
+
+func descriptionFromProto(d *wsproto.SessionDescription) *webrtc.SessionDescription {
+	if d == nil {
+		return nil
+	}
+	return &webrtc.SessionDescription{Type: webrtc.NewSDPType(d.Type), SDP: d.SDP}
+}
+
+func candidateFromProto(c *wsproto.ICECandidate) *webrtc.ICECandidateInit {
+	if c == nil {
+		return nil
+	}
+	candidate := &webrtc.ICECandidateInit{
+		Candidate:        c.Candidate,
+		SDPMid:           c.SDPMid,
+		UsernameFragment: c.UsernameFragment,
+	}
+	if c.SDPMLineIndex != nil {
+		index := uint16(*c.SDPMLineIndex)
+		candidate.SDPMLineIndex = &index
+	}
+	return candidate
+}
+
+// decodeProtoFrame turns a binary client frame into the ClientMessage the
+// handlers take. Typed payloads are converted to the JSON the handlers decode.
+func decodeProtoFrame(data []byte) (ClientMessage, error) {
+	env, err := wsproto.Unmarshal(data)
+	if err != nil {
+		return ClientMessage{}, err
+	}
+	msg := ClientMessage{Type: env.Type}
+
+	var payload interface{}
+	switch {
+	case env.JSON != nil:
+		msg.Data = env.JSON
+		return msg, nil
+	case env.Signal != nil:
+		payload = SignalingData{
+			Type:       env.Signal.Type,
+			ToPeerID:   env.Signal.ToPeerID,
+			Offer:      descriptionFromProto(env.Signal.Offer),
+			Answer:     descriptionFromProto(env.Signal.Answer),
+			Candidate:  candidateFromProto(env.Signal.Candidate),
+			StreamType: env.Signal.StreamType,
+			ShareToken: env.Signal.ShareToken,
+		}
+	case env.Description != nil:
+		payload = descriptionFromProto(env.Description)
+	case env.Candidate != nil:
+		payload = candidateFromProto(env.Candidate)
+	case env.Peer != nil:
+		return msg, errors.New("peer payloads are server-sent only")
+	default:
+		return msg, nil
+	}
+	msg.Data, err = json.Marshal(payload)
+	return msg, err
+}
+
+// decodeClientFrame decodes a frame read from a meeting socket
+func decodeClientFrame(messageType int, data []byte) (ClientMessage, error) {
+	if messageType == websocket.BinaryMessage {
+		return decodeProtoFrame(data)
+	}
+	var msg ClientMessage
+	err := json.Unmarshal(data, &msg)
+	return msg, err
+}
+
+// jsonFrameToProto re-encodes a JSON frame for a binary presence socket,
+// whose messages arrive already marshaled
+func jsonFrameToProto(frame []byte) []byte {
+	var message WebSocketMessage
+	if err := json.Unmarshal(frame, &message); err != nil {
+		log.Printf("Error re-encoding presence frame: %v", err)
+		return nil
+	}
+	return protoEnvelope(message).Marshal()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"video-meeting-app/config"
+)
+
+// messageGuard enforces a client's WebSocket message budgets. It is only used
+// from the client's read loop.
+type messageGuard struct {
+	limits     config.WebSocketLimits
+	limiter    *RateLimiter
+	violations []time.Time
+}
+
+func newMessageGuard(limits config.WebSocketLimits) *messageGuard {
+	// Buckets are per message type and die with the client, so they are never evicted
+	return &messageGuard{limits: limits, limiter: newRateLimiter(RateLimitPolicy{}, 0)}
+}
+
+// check reports whether a message of the type and size is within budget.
+// When it isn't, disconnect says whether the client has run out of warnings.
+func (g *messageGuard) check(messageType string, size int, now time.Time) (allowed bool, reason string, disconnect bool) {
+	limits := g.limits
+	name, budget := limits.Budget(messageType)
+
+	switch {
+	case size > budget.MaxBytes:
+		reason = fmt.Sprintf("%s messages are limited to %d bytes", messageType, budget.MaxBytes)
+	default:
+		policy := RateLimitPolicy{Name: name, RequestsPerMinute: budget.PerMinute, Burst: budget.Burst}
+		if ok, _, _ := g.limiter.Allow(policy, "client", now); ok {
+			return true, "", false
+		}
+		reason = fmt.Sprintf("Too many %s messages, slow down", messageType)
+	}
+
+	// Only violations inside the window count toward a disconnect
+	cutoff := now.Add(-time.Duration(limits.ViolationWindow))
+	kept := g.violations[:0]
+	for _, at := range g.violations {
+		if at.After(cutoff) {
+			kept = append(kept, at)
+		}
+	}
+	g.violations = append(kept, now)
+	return false, reason, len(g.violations) >= limits.MaxViolations
+}
+
+// allowMessage applies the client's budgets to an incoming message and
+// reports whether to handle it and whether to keep reading. Over budget the
+// client is warned with "rate-limited"; past the violation limit the
+// connection is closed.
+func (c *Client) allowMessage(messageType string, size int) (allowed bool, keepOpen bool) {
+	allowed, reason, disconnect := c.guard.check(messageType, size, time.Now())
+	if allowed {
+		return true, true
+	}
+
+	if disconnect {
+		log.Printf("Disconnecting %s from meeting %s for flooding (%s)", c.userID, c.meetingID, messageType)
+		if c.sse != nil {
+			c.sse.cancel()
+		} else {
+			c.conn.WriteControl(
+				websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "Message limit exceeded"),
+				time.Now().Add(WriteWait),
+			)
+		}
+		return false, false
+	}
+
+	c.hub.SendToClient(c, WebSocketMessage{
+		Type: "rate-limited",
+		Data: map[string]string{"messageType": messageType, "warning": reason},
+	})
+	return false, true
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// SendQueueSize is how many frames a client may have waiting before
+	// droppable messages are shed
+	SendQueueSize = 256
+	// SendQueueSignalingReserve is how far signaling may run past
+	// SendQueueSize; a client that far behind is disconnected. Signaling may
+	// run the same proportion past hub.sendQueueMaxBytes.
+	SendQueueSignalingReserve = 64
+)
+
+// messageClass decides what happens to a message when a client's queue is full
+type messageClass int
+
+const (
+	// classDefault messages are dropped when the queue is full. The client
+	// sees a gap in seq and can reconnect with resume-from to recover them.
+	classDefault messageClass = iota
+	// classSignaling messages are never dropped; WebRTC negotiation can't
+	// recover from a lost offer or candidate
+	classSignaling
+	// classPresence messages only matter in their latest form, so a queued
+	// one with the same key is replaced rather than appended to
+	classPresence
+)
+
+// classifyMessage returns a message's class and, for presence, the key
+// messages coalesce on
+func classifyMessage(message WebSocketMessage) (messageClass, string) {
+	switch message.Type {
+	case "signal", "sfu-offer", "sfu-candidate", "meeting-ended", "e2ee-key", "e2ee-key-request":
+		return classSignaling, ""
+	case "active-speaker":
+		return classPresence, message.Type
+	case "typing", "presence-changed":
+		return classPresence, message.Type + ":" + dataString(message.Data, "userId")
+	case "caption":
+		// Interim captions are superseded by later text for the same segment
+		return classPresence, message.Type + ":" + dataString(message.Data, "segmentId")
+	}
+	return classDefault, ""
+}
+
+// dataString reads a string field from a message's map data
+func dataString(data interface{}, field string) string {
+	switch fields := data.(type) {
+	case map[string]string:
+		return fields[field]
+	case map[string]interface{}:
+		value, _ := fields[field].(string)
+		return value
+	}
+	return ""
+}
+
+// SendQueueStats counts how client send queues have shed load since startup
+type SendQueueStats struct {
+	Dropped      int64 `json:"dropped"`
+	Coalesced    int64 `json:"coalesced"`
+	Disconnected int64 `json:"disconnected"`
+	// Filled in by the hub snapshot: clients whose queue is currently full,
+	// the bytes waiting in all queues and in the longest
+	FullQueues        int `json:"fullQueues"`
+	QueuedBytes       int `json:"queuedBytes"`
+	LargestQueueBytes int `json:"largestQueueBytes"`
+}
+
+var (
+	sendQueueDropped      atomic.Int64
+	sendQueueCoalesced    atomic.Int64
+	sendQueueDisconnected atomic.Int64
+)
+
+// getSendQueueStats returns a snapshot of the send queue counters
+func getSendQueueStats() SendQueueStats {
+	return SendQueueStats{
+		Dropped:      sendQueueDropped.Load(),
+		Coalesced:    sendQueueCoalesced.Load(),
+		Disconnected: sendQueueDisconnected.Load(),
+	}
+}
+
+type queuedFrame struct {
+	data  []byte
+	class messageClass
+	key   string
+}
+
+// sendQueue holds a client's outgoing frames. The client's hub shard pushes
+// and its writePump pops.
+type sendQueue struct {
+	mu     sync.Mutex
+	frames []queuedFrame
+	bytes  int
+	closed bool
+	// Past this many bytes queued, frames are shed as past SendQueueSize
+	maxBytes int
+	// ready is signalled when frames are pushed or the queue is closed
+	ready chan struct{}
+}
+
+func newSendQueue(maxBytes int) *sendQueue {
+	return &sendQueue{ready: make(chan struct{}, 1), maxBytes: maxBytes}
+}
+
+func (q *sendQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// push queues a frame according to its class. It reports false when the
+// client has fallen too far behind to keep and should be disconnected.
+func (q *sendQueue) push(data []byte, class messageClass, key string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return true
+	}
+
+	if class == classPresence {
+		for i := range q.frames {
+			if q.frames[i].class == classPresence && q.frames[i].key == key {
+				q.bytes += len(data) - len(q.frames[i].data)
+				q.frames[i].data = data
+				sendQueueCoalesced.Add(1)
+				return true
+			}
+		}
+	}
+
+	if len(q.frames) >= SendQueueSize || q.bytes+len(data) > q.maxBytes {
+		switch class {
+		case classSignaling:
+			reserveBytes := q.maxBytes / SendQueueSize * SendQueueSignalingReserve
+			if len(q.frames) >= SendQueueSize+SendQueueSignalingReserve || q.bytes+len(data) > q.maxBytes+reserveBytes {
+				return false
+			}
+		default:
+			sendQueueDropped.Add(1)
+			return true
+		}
+	}
+
+	q.frames = append(q.frames, queuedFrame{data: data, class: class, key: key})
+	q.bytes += len(data)
+	q.signal()
+	return true
+}
+
+// pop takes the oldest frame. done is true once the queue is closed and
+// drained.
+func (q *sendQueue) pop() (data []byte, ok bool, done bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.frames) == 0 {
+		return nil, false, q.closed
+	}
+	data = q.frames[0].data
+	q.bytes -= len(data)
+	q.frames[0] = queuedFrame{}
+	q.frames = q.frames[1:]
+	return data, true, false
+}
+
+// close stops accepting frames; writePump sends what is queued and then a
+// close frame
+func (q *sendQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.signal()
+}
+
+// size reports how many frames are waiting and their bytes
+func (q *sendQueue) size() (frames, bytes int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.frames), q.bytes
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// Kinds of JSON value a message field may hold
+type fieldKind int
+
+const (
+	kindString fieldKind = iota
+	kindNumber
+	kindBool
+	kindObject
+	kindArray
+)
+
+func (k fieldKind) String() string {
+	return [...]string{"a string", "a number", "a boolean", "an object", "an array"}[k]
+}
+
+// fieldRule describes one field of a client message's data
+type fieldRule struct {
+	kind     fieldKind
+	required bool     // must be present, and non-empty for strings
+	oneOf    []string // allowed string values, when set
+}
+
+// messageSchema lists the fields a message type's data may carry. Fields not
+// listed are ignored, so clients can send extra fields harmlessly.
+type messageSchema map[string]fieldRule
+
+// clientMessageSchemas holds every message type clients may send; others are
+// rejected before reaching a handler
+var clientMessageSchemas = map[string]messageSchema{
+	"poll-vote": {
+		"pollId":      {kind: kindString, required: true},
+		"optionIndex": {kind: kindNumber, required: true},
+	},
+	"chat-message": {
+		"message":       {kind: kindString},
+		"attachmentIds": {kind: kindArray},
+	},
+	"chat-clear": {},
+	"chat-edit": {
+		"messageId": {kind: kindString, required: true},
+		"message":   {kind: kindString, required: true},
+	},
+	"chat-delete": {
+		"messageId": {kind: kindString, required: true},
+	},
+	"chat-read": {
+		"messageId": {kind: kindString, required: true},
+	},
+	"typing": {
+		"isTyping": {kind: kindBool, required: true},
+	},
+	"stats": {
+		"rtt":        {kind: kindNumber, required: true},
+		"packetLoss": {kind: kindNumber, required: true},
+		"bitrate":    {kind: kindNumber},
+	},
+	"resume-from": {
+		"seq":    {kind: kindNumber, required: true},
+		"peerId": {kind: kindString},
+	},
+	"raise-hand": {},
+	"lower-hand": {
+		"userId": {kind: kindString},
+	},
+	"mute-participant": {
+		"userId": {kind: kindString, required: true},
+	},
+	"unmute-participant": {
+		"userId": {kind: kindString, required: true},
+	},
+	"signal": {
+		"type":       {kind: kindString, required: true, oneOf: []string{"offer", "answer", "candidate", "ice-candidate", "ice-restart"}},
+		"toPeerId":   {kind: kindString, required: true},
+		"offer":      {kind: kindObject},
+		"answer":     {kind: kindObject},
+		"candidate":  {kind: kindObject},
+		"streamType": {kind: kindString, oneOf: []string{StreamTypeCamera, StreamTypeScreen}},
+		"shareToken": {kind: kindString},
+	},
+	"screenshare-start": {},
+	"screenshare-stop":  {},
+	"sfu-join": {
+		"offer": {kind: kindObject},
+	},
+	"sfu-answer": {
+		"type": {kind: kindString, required: true, oneOf: []string{"answer"}},
+		"sdp":  {kind: kindString, required: true},
+	},
+	"sfu-candidate": {
+		"candidate":     {kind: kindString, required: true},
+		"sdpMid":        {kind: kindString},
+		"sdpMLineIndex": {kind: kindNumber},
+	},
+	"sfu-ice-restart": {},
+	"notes-op": {
+		"version": {kind: kindNumber, required: true},
+		"ops":     {kind: kindArray, required: true},
+	},
+	"whiteboard": {
+		"op":       {kind: kindString, required: true, oneOf: []string{WhiteboardOpStroke, WhiteboardOpErase, WhiteboardOpClear}},
+		"stroke":   {kind: kindObject},
+		"strokeId": {kind: kindString},
+	},
+	"sfu-preferences": {
+		"bandwidth": {kind: kindNumber},
+		"viewports": {kind: kindObject},
+	},
+	"e2ee-public-key": {
+		"publicKey": {kind: kindString, required: true},
+	},
+	"e2ee-key": {
+		"toPeerId":     {kind: kindString, required: true},
+		"keyId":        {kind: kindNumber, required: true},
+		"encryptedKey": {kind: kindString, required: true},
+		"publicKey":    {kind: kindString},
+	},
+	"e2ee-key-request": {
+		"toPeerId": {kind: kindString, required: true},
+	},
+}
+
+// MessageValidationError describes why a client message was rejected. It is
+// sent back to the sender as the data of an "error" frame.
+type MessageValidationError struct {
+	Error       string `json:"error"`
+	Code        string `json:"code"` // unknown_type, invalid_data, missing_field or invalid_field
+	MessageType string `json:"messageType"`
+	Field       string `json:"field,omitempty"`
+}
+
+func jsonKind(value json.RawMessage) (fieldKind, bool) {
+	switch trimmed := bytes.TrimSpace(value); {
+	case len(trimmed) == 0:
+		return 0, false
+	case trimmed[0] == '"':
+		return kindString, true
+	case trimmed[0] == '{':
+		return kindObject, true
+	case trimmed[0] == '[':
+		return kindArray, true
+	case bytes.Equal(trimmed, []byte("true")), bytes.Equal(trimmed, []byte("false")):
+		return kindBool, true
+	case trimmed[0] == '-' || (trimmed[0] >= '0' && trimmed[0] <= '9'):
+		return kindNumber, true
+	}
+	return 0, false // null
+}
+
+// validateClientMessage checks a message against its type's schema
+func validateClientMessage(msg ClientMessage) *MessageValidationError {
+	schema, ok := clientMessageSchemas[msg.Type]
+	if !ok {
+		return &MessageValidationError{
+			Error:       fmt.Sprintf("Unknown message type: %s", msg.Type),
+			Code:        "unknown_type",
+			MessageType: msg.Type,
+		}
+	}
+
+	fields := map[string]json.RawMessage{}
+	if len(bytes.TrimSpace(msg.Data)) > 0 && !bytes.Equal(bytes.TrimSpace(msg.Data), []byte("null")) {
+		if err := json.Unmarshal(msg.Data, &fields); err != nil {
+			return &MessageValidationError{
+				Error:       "Message data must be an object",
+				Code:        "invalid_data",
+				MessageType: msg.Type,
+			}
+		}
+	}
+
+	// Check fields in a fixed order so the same message always gets the same error
+	names := make([]string, 0, len(schema))
+	for name := range schema {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		rule := schema[name]
+		value, present := fields[name]
+		kind, notNull := jsonKind(value)
+		if !present || !notNull {
+			if rule.required {
+				return &MessageValidationError{
+					Error:       fmt.Sprintf("%s is required", name),
+					Code:        "missing_field",
+					MessageType: msg.Type,
+					Field:       name,
+				}
+			}
+			continue
+		}
+		if kind != rule.kind {
+			return &MessageValidationError{
+				Error:       fmt.Sprintf("%s must be %s", name, rule.kind),
+				Code:        "invalid_field",
+				MessageType: msg.Type,
+				Field:       name,
+			}
+		}
+		if rule.kind != kindString {
+			continue
+		}
+
+		var text string
+		json.Unmarshal(value, &text)
+		if rule.required && text == "" {
+			return &MessageValidationError{
+				Error:       fmt.Sprintf("%s is required", name),
+				Code:        "missing_field",
+				MessageType: msg.Type,
+				Field:       name,
+			}
+		}
+		if len(rule.oneOf) > 0 && text != "" && !slices.Contains(rule.oneOf, text) {
+			return &MessageValidationError{
+				Error:       fmt.Sprintf("%s must be one of %v", name, rule.oneOf),
+				Code:        "invalid_field",
+				MessageType: msg.Type,
+				Field:       name,
+			}
+		}
+	}
+	return nil
+}
+
+// sendValidationError tells the sender why its message was rejected
+func (c *Client) sendValidationError(err *MessageValidationError) {
+	c.hub.SendToClient(c, WebSocketMessage{
+		Type: "error",
+		Data: err,
+	})
+}
@@ -0,0 +1,317 @@
+// Package wsproto encodes meeting WebSocket frames as protobuf for clients
+// that negotiate the binary subprotocol. The schema is messages.proto; the
+// encoding is written against protowire so no generated code is needed.
+package wsproto
+
+import (
+	"errors"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// WebSocket subprotocols a client may offer in Sec-WebSocket-Protocol
+const (
+	SubprotocolProto = "meet.v1.proto"
+	SubprotocolJSON  = "meet.v1.json"
+)
+
+var errMalformed = errors.New("malformed protobuf frame")
+
+// Envelope is one frame. At most one payload field is set.
+type Envelope struct {
+	Type        string
+	MeetingID   string
+	UserID      string
+	TimestampMs int64
+	Seq         uint64
+
+	JSON        []byte
+	Signal      *Signal
+	Description *SessionDescription
+	Candidate   *ICECandidate
+	Peer        *Peer
+}
+
+type Signal struct {
+	Type       string
+	FromPeerID string
+	ToPeerID   string
+	Offer      *SessionDescription
+	Answer     *SessionDescription
+	Candidate  *ICECandidate
+	StreamType string
+	ShareToken string
+}
+
+type SessionDescription struct {
+	Type string
+	SDP  string
+}
+
+// ICECandidate mirrors RTCIceCandidateInit; nil pointers are absent fields
+type ICECandidate struct {
+	Candidate        string
+	SDPMid           *string
+	SDPMLineIndex    *uint32
+	UsernameFragment *string
+}
+
+type Peer struct {
+	UserID string
+	PeerID string
+	Online bool
+	Level  uint32
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendBytes(b []byte, num protowire.Number, v []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+// Marshal encodes the envelope
+func (e *Envelope) Marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, e.Type)
+	b = appendString(b, 2, e.MeetingID)
+	b = appendString(b, 3, e.UserID)
+	b = appendVarint(b, 4, uint64(e.TimestampMs))
+	b = appendVarint(b, 5, e.Seq)
+	switch {
+	case e.JSON != nil:
+		b = appendBytes(b, 6, e.JSON)
+	case e.Signal != nil:
+		b = appendBytes(b, 7, e.Signal.marshal())
+	case e.Description != nil:
+		b = appendBytes(b, 8, e.Description.marshal())
+	case e.Candidate != nil:
+		b = appendBytes(b, 9, e.Candidate.marshal())
+	case e.Peer != nil:
+		b = appendBytes(b, 10, e.Peer.marshal())
+	}
+	return b
+}
+
+func (s *Signal) marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, s.Type)
+	b = appendString(b, 2, s.FromPeerID)
+	b = appendString(b, 3, s.ToPeerID)
+	if s.Offer != nil {
+		b = appendBytes(b, 4, s.Offer.marshal())
+	}
+	if s.Answer != nil {
+		b = appendBytes(b, 5, s.Answer.marshal())
+	}
+	if s.Candidate != nil {
+		b = appendBytes(b, 6, s.Candidate.marshal())
+	}
+	b = appendString(b, 7, s.StreamType)
+	b = appendString(b, 8, s.ShareToken)
+	return b
+}
+
+func (d *SessionDescription) marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, d.Type)
+	b = appendString(b, 2, d.SDP)
+	return b
+}
+
+func (c *ICECandidate) marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, c.Candidate)
+	// Optional fields are written whenever present, even if zero
+	if c.SDPMid != nil {
+		b = appendBytes(b, 2, []byte(*c.SDPMid))
+	}
+	if c.SDPMLineIndex != nil {
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(*c.SDPMLineIndex))
+	}
+	if c.UsernameFragment != nil {
+		b = appendBytes(b, 4, []byte(*c.UsernameFragment))
+	}
+	return b
+}
+
+func (p *Peer) marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, p.UserID)
+	b = appendString(b, 2, p.PeerID)
+	if p.Online {
+		b = appendVarint(b, 3, 1)
+	}
+	b = appendVarint(b, 4, uint64(p.Level))
+	return b
+}
+
+// fields walks the fields of a message, calling fn with each field's number
+// and its varint or length-delimited value. Fields of other wire types are
+// skipped, as unknown fields are.
+func fields(b []byte, fn func(num protowire.Number, varint uint64, bytes []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return errMalformed
+		}
+		b = b[n:]
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return errMalformed
+			}
+			b = b[n:]
+			if err := fn(num, v, nil); err != nil {
+				return err
+			}
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return errMalformed
+			}
+			b = b[n:]
+			if err := fn(num, 0, v); err != nil {
+				return err
+			}
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return errMalformed
+			}
+			b = b[n:]
+		}
+	}
+	return nil
+}
+
+// Unmarshal decodes an envelope
+func Unmarshal(b []byte) (*Envelope, error) {
+	e := &Envelope{}
+	err := fields(b, func(num protowire.Number, v uint64, data []byte) error {
+		var err error
+		switch num {
+		case 1:
+			e.Type = string(data)
+		case 2:
+			e.MeetingID = string(data)
+		case 3:
+			e.UserID = string(data)
+		case 4:
+			e.TimestampMs = int64(v)
+		case 5:
+			e.Seq = v
+		case 6:
+			e.JSON = append([]byte{}, data...)
+		case 7:
+			e.Signal, err = unmarshalSignal(data)
+		case 8:
+			e.Description, err = unmarshalDescription(data)
+		case 9:
+			e.Candidate, err = unmarshalCandidate(data)
+		case 10:
+			e.Peer, err = unmarshalPeer(data)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func unmarshalSignal(b []byte) (*Signal, error) {
+	s := &Signal{}
+	err := fields(b, func(num protowire.Number, v uint64, data []byte) error {
+		var err error
+		switch num {
+		case 1:
+			s.Type = string(data)
+		case 2:
+			s.FromPeerID = string(data)
+		case 3:
+			s.ToPeerID = string(data)
+		case 4:
+			s.Offer, err = unmarshalDescription(data)
+		case 5:
+			s.Answer, err = unmarshalDescription(data)
+		case 6:
+			s.Candidate, err = unmarshalCandidate(data)
+		case 7:
+			s.StreamType = string(data)
+		case 8:
+			s.ShareToken = string(data)
+		}
+		return err
+	})
+	return s, err
+}
+
+func unmarshalDescription(b []byte) (*SessionDescription, error) {
+	d := &SessionDescription{}
+	err := fields(b, func(num protowire.Number, v uint64, data []byte) error {
+		switch num {
+		case 1:
+			d.Type = string(data)
+		case 2:
+			d.SDP = string(data)
+		}
+		return nil
+	})
+	return d, err
+}
+
+func unmarshalCandidate(b []byte) (*ICECandidate, error) {
+	c := &ICECandidate{}
+	err := fields(b, func(num protowire.Number, v uint64, data []byte) error {
+		switch num {
+		case 1:
+			c.Candidate = string(data)
+		case 2:
+			mid := string(data)
+			c.SDPMid = &mid
+		case 3:
+			index := uint32(v)
+			c.SDPMLineIndex = &index
+		case 4:
+			fragment := string(data)
+			c.UsernameFragment = &fragment
+		}
+		return nil
+	})
+	return c, err
+}
+
+func unmarshalPeer(b []byte) (*Peer, error) {
+	p := &Peer{}
+	err := fields(b, func(num protowire.Number, v uint64, data []byte) error {
+		switch num {
+		case 1:
+			p.UserID = string(data)
+		case 2:
+			p.PeerID = string(data)
+		case 3:
+			p.Online = v != 0
+		case 4:
+			p.Level = uint32(v)
+		}
+		return nil
+	})
+	return p, err
+}
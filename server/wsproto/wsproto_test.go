@@ -0,0 +1,200 @@
+package wsproto
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func ptr[T any](v T) *T { return &v }
+
+func roundTripCases() map[string]*Envelope {
+	return map[string]*Envelope{
+		"empty": {},
+		"header only": {
+			Type:        "ping",
+			MeetingID:   "meeting-1",
+			UserID:      "user-1",
+			TimestampMs: 1700000000123,
+			Seq:         42,
+		},
+		"negative timestamp": {Type: "ping", TimestampMs: -1},
+		"json": {
+			Type: "chat-message",
+			Seq:  1,
+			JSON: []byte(`{"text":"hello"}`),
+		},
+		"empty json": {Type: "chat-message", JSON: []byte{}},
+		"signal": {
+			Type: "signal",
+			Signal: &Signal{
+				Type:       "offer",
+				FromPeerID: "peer-a",
+				ToPeerID:   "peer-b",
+				Offer:      &SessionDescription{Type: "offer", SDP: "v=0\r\n"},
+				Answer:     &SessionDescription{Type: "answer", SDP: "v=0\r\n"},
+				Candidate:  &ICECandidate{Candidate: "candidate:1 1 udp 1 10.0.0.1 5000 typ host"},
+				StreamType: "screen",
+				ShareToken: "token",
+			},
+		},
+		"description": {
+			Type:        "sfu-offer",
+			Description: &SessionDescription{Type: "offer", SDP: "v=0\r\no=- 0 0 IN IP4 127.0.0.1\r\n"},
+		},
+		"candidate with zero optionals": {
+			Type: "sfu-candidate",
+			Candidate: &ICECandidate{
+				Candidate:        "candidate:1",
+				SDPMid:           ptr(""),
+				SDPMLineIndex:    ptr(uint32(0)),
+				UsernameFragment: ptr(""),
+			},
+		},
+		"candidate with optionals": {
+			Type: "sfu-candidate",
+			Candidate: &ICECandidate{
+				Candidate:        "candidate:2",
+				SDPMid:           ptr("audio"),
+				SDPMLineIndex:    ptr(uint32(3)),
+				UsernameFragment: ptr("frag"),
+			},
+		},
+		"candidate without optionals": {
+			Type:      "sfu-candidate",
+			Candidate: &ICECandidate{Candidate: "candidate:3"},
+		},
+		"peer": {
+			Type: "active-speaker",
+			Peer: &Peer{UserID: "user-1", PeerID: "peer-1", Online: true, Level: 127},
+		},
+		"offline peer": {
+			Type: "user-left",
+			Peer: &Peer{UserID: "user-1"},
+		},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for name, want := range roundTripCases() {
+		t.Run(name, func(t *testing.T) {
+			got, err := Unmarshal(want.Marshal())
+			if err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("round trip mismatch\n got: %+v\nwant: %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalSkipsUnknownFields(t *testing.T) {
+	want := &Envelope{Type: "ping", Seq: 7}
+	b := want.Marshal()
+	b = protowire.AppendTag(b, 99, protowire.VarintType)
+	b = protowire.AppendVarint(b, 1)
+	b = protowire.AppendTag(b, 100, protowire.BytesType)
+	b = protowire.AppendBytes(b, []byte("future"))
+	b = protowire.AppendTag(b, 101, protowire.Fixed32Type)
+	b = protowire.AppendFixed32(b, 1)
+	b = protowire.AppendTag(b, 102, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, 1)
+
+	got, err := Unmarshal(b)
+	if err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestUnmarshalTruncated(t *testing.T) {
+	for name, envelope := range roundTripCases() {
+		b := envelope.Marshal()
+		for n := 0; n < len(b); n++ {
+			got, err := Unmarshal(b[:n])
+			if err == nil && reflect.DeepEqual(got, envelope) {
+				t.Errorf("%s: truncated to %d of %d bytes decoded to the full envelope", name, n, len(b))
+			}
+		}
+	}
+}
+
+func TestUnmarshalMalformed(t *testing.T) {
+	overlong := make([]byte, 0, 12)
+	overlong = protowire.AppendTag(overlong, 5, protowire.VarintType)
+	for i := 0; i < 11; i++ {
+		overlong = append(overlong, 0xff)
+	}
+
+	nested := func(num protowire.Number, inner []byte) []byte {
+		b := protowire.AppendTag(nil, num, protowire.BytesType)
+		return protowire.AppendBytes(b, inner)
+	}
+
+	tests := map[string][]byte{
+		"truncated tag":           {0x80},
+		"field number zero":       {0x00, 0x01},
+		"overlong varint":         overlong,
+		"length past end":         {0x0a, 0x05, 'a', 'b'},
+		"huge length":             {0x0a, 0xff, 0xff, 0xff, 0xff, 0x0f},
+		"truncated fixed32":       {0x0d, 0x01, 0x02},
+		"truncated fixed64":       {0x09, 0x01, 0x02, 0x03},
+		"end group without start": {0x0c},
+		"reserved wire type":      {0x0e, 0x00},
+		"malformed signal":        nested(7, []byte{0x0a, 0x03}),
+		"malformed signal offer":  nested(7, nested(4, []byte{0x12, 0x09})),
+		"malformed description":   nested(8, []byte{0xff}),
+		"malformed candidate":     nested(9, []byte{0x18}),
+		"malformed peer":          nested(10, []byte{0x22, 0x01}),
+	}
+	for name, b := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := Unmarshal(b)
+			if !errors.Is(err, errMalformed) {
+				t.Errorf("Unmarshal(%x) = %+v, %v; want errMalformed", b, got, err)
+			}
+		})
+	}
+}
+
+func FuzzUnmarshal(f *testing.F) {
+	for _, envelope := range roundTripCases() {
+		f.Add(envelope.Marshal())
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		envelope, err := Unmarshal(b)
+		if err != nil {
+			return
+		}
+		// Whatever decodes must survive a second round trip unchanged
+		again, err := Unmarshal(envelope.Marshal())
+		if err != nil {
+			t.Fatalf("re-decoding %x: %v", b, err)
+		}
+		if !reflect.DeepEqual(normalize(again), normalize(envelope)) {
+			t.Fatalf("re-decoding %x changed the envelope\n got: %+v\nwant: %+v", b, again, envelope)
+		}
+	})
+}
+
+// normalize drops the payloads Marshal would not write, since the oneof
+// keeps only the first one set
+func normalize(e *Envelope) *Envelope {
+	n := *e
+	switch {
+	case n.JSON != nil:
+		n.Signal, n.Description, n.Candidate, n.Peer = nil, nil, nil, nil
+	case n.Signal != nil:
+		n.Description, n.Candidate, n.Peer = nil, nil, nil
+	case n.Description != nil:
+		n.Candidate, n.Peer = nil, nil
+	case n.Candidate != nil:
+		n.Peer = nil
+	}
+	return &n
+}
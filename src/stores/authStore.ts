@@ -23,8 +23,8 @@ interface AuthState {
 // Token validation helper
 const isValidToken = (token: string): boolean => {
   try {
-    // Check if token follows the format "token_<userID>"
-    return token.startsWith('token_') && token.length > 6;
+    // Session tokens are opaque: "sess_<random>"
+    return token.startsWith('sess_') && token.length > 5;
   } catch {
     return false;
   }